@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 )
 
 const (
@@ -21,11 +23,18 @@ const (
 
 var requiredScopes = []string{"openid", "offline_access"}
 
+// waitThreshold is added to the polling interval requested by the device authorization endpoint.
+var waitThreshold = waitThresholdInSeconds * time.Second
+
+// ErrExpiredToken is returned by Authenticator.Wait when the device code expires before the user logs in.
+var ErrExpiredToken = errors.New("device code expired")
+
 type Authenticator struct {
 	Audience           string
 	ClientID           string
 	DeviceCodeEndpoint string
 	OauthTokenEndpoint string
+	Client             httputil.Client
 }
 
 // SecretStore provides access to stored sensitive data.
@@ -56,7 +65,7 @@ type State struct {
 func RequiredScopes() []string { return requiredScopes }
 
 func (s *State) IntervalDuration() time.Duration {
-	return time.Duration(s.Interval+waitThresholdInSeconds) * time.Second
+	return time.Duration(s.Interval)*time.Second + waitThreshold
 }
 
 // Start kicks-off the device authentication flow
@@ -70,9 +79,11 @@ func (a *Authenticator) Start(ctx context.Context) (State, error) {
 	return s, nil
 }
 
-// Wait waits until the user is logged in on the browser.
-func (a *Authenticator) Wait(ctx context.Context, state State) (Result, error) {
+// Wait waits until the user is logged in on the browser. If pending is non-nil, it is called every time the device
+// authorization endpoint reports that login is still pending.
+func (a *Authenticator) Wait(ctx context.Context, state State, pending func()) (Result, error) {
 	t := time.NewTicker(state.IntervalDuration())
+	defer t.Stop()
 	for {
 		select {
 		case <-ctx.Done():
@@ -83,11 +94,10 @@ func (a *Authenticator) Wait(ctx context.Context, state State) (Result, error) {
 				"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
 				"device_code": {state.DeviceCode},
 			}
-			r, err := http.PostForm(a.OauthTokenEndpoint, data)
+			r, err := a.postForm(ctx, a.OauthTokenEndpoint, data)
 			if err != nil {
 				return Result{}, fmt.Errorf("cannot get device code: %w", err)
 			}
-			defer r.Body.Close()
 
 			var res struct {
 				AccessToken      string  `json:"access_token"`
@@ -101,13 +111,24 @@ func (a *Authenticator) Wait(ctx context.Context, state State) (Result, error) {
 			}
 
 			err = json.NewDecoder(r.Body).Decode(&res)
+			r.Body.Close()
 			if err != nil {
 				return Result{}, fmt.Errorf("cannot decode response: %w", err)
 			}
 
 			if res.Error != nil {
-				if *res.Error == "authorization_pending" {
+				switch *res.Error {
+				case "authorization_pending":
+					if pending != nil {
+						pending()
+					}
 					continue
+				case "slow_down":
+					state.Interval += 5
+					t.Reset(state.IntervalDuration())
+					continue
+				case "expired_token":
+					return Result{}, ErrExpiredToken
 				}
 				return Result{}, errors.New(res.ErrorDescription)
 			}
@@ -127,7 +148,7 @@ func (a *Authenticator) getDeviceCode(ctx context.Context) (State, error) {
 		"scope":     {strings.Join(requiredScopes, " ")},
 		"audience":  {a.Audience},
 	}
-	r, err := http.PostForm(a.DeviceCodeEndpoint, data)
+	r, err := a.postForm(ctx, a.DeviceCodeEndpoint, data)
 	if err != nil {
 		return State{}, fmt.Errorf("cannot get device code: %w", err)
 	}
@@ -139,3 +160,15 @@ func (a *Authenticator) getDeviceCode(ctx context.Context) (State, error) {
 	}
 	return res, nil
 }
+
+func (a *Authenticator) postForm(ctx context.Context, endpoint string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if a.Client == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return a.Client.Do(req, 30*time.Second)
+}
@@ -5,8 +5,10 @@ package auth0
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -22,11 +24,16 @@ const (
 	reauthMessage     = "re-authenticate with 'vespa auth login'"
 )
 
+// ErrLoginRequired is returned when the user must log in again to get an access token.
+var ErrLoginRequired = errors.New(reauthMessage)
+
 // Credentials holds the credentials retrieved from Auth0.
 type Credentials struct {
 	AccessToken string    `json:"access_token,omitempty"`
 	Scopes      []string  `json:"scopes,omitempty"`
 	ExpiresAt   time.Time `json:"expires_at"`
+	// System is the name of the system these credentials were retrieved for
+	System string `json:"system,omitempty"`
 }
 
 // Client is a client for the Auth0 service.
@@ -41,6 +48,31 @@ type Options struct {
 	ConfigPath string
 	SystemName string
 	SystemURL  string
+	// Secrets stores the refresh tokens. If nil, the keyring given by auth.NewKeyring is used
+	Secrets auth.SecretStore
+}
+
+// secrets returns the store of refresh tokens of these options.
+func (o Options) secrets() auth.SecretStore {
+	if o.Secrets == nil {
+		return auth.NewKeyring()
+	}
+	return o.Secrets
+}
+
+// credentialsKey returns the key of the credentials of a system in the configuration file. Credentials are keyed by the
+// API URL of their system, such that systems sharing a name are kept apart.
+func credentialsKey(systemName, systemURL string) string {
+	if systemURL == "" {
+		return systemName
+	}
+	return systemURL
+}
+
+// refreshTokenKey returns the key of the refresh token of a system in secret storage. This is the credentials key of the
+// system, escaped such that it can be part of a file name.
+func refreshTokenKey(systemName, systemURL string) string {
+	return url.QueryEscape(credentialsKey(systemName, systemURL))
 }
 
 // config is the root type of the persisted config
@@ -58,6 +90,16 @@ type auth0Provider struct {
 	Systems map[string]Credentials `json:"systems"`
 }
 
+// credentials returns the credentials of given system. Credentials written by older versions of the CLI are keyed by
+// the system name only.
+func (p auth0Provider) credentials(systemName, systemURL string) (Credentials, bool) {
+	if creds, ok := p.Systems[credentialsKey(systemName, systemURL)]; ok {
+		return creds, true
+	}
+	creds, ok := p.Systems[systemName]
+	return creds, ok
+}
+
 // flowConfig represents the authorization flow configuration retrieved from a Vespa system.
 type flowConfig struct {
 	Audience           string `json:"audience"`
@@ -93,6 +135,7 @@ func NewClient(httpClient httputil.Client, options Options) (*Client, error) {
 		ClientID:           c.ClientID,
 		DeviceCodeEndpoint: c.DeviceCodeEndpoint,
 		OauthTokenEndpoint: c.OauthTokenEndpoint,
+		Client:             httpClient,
 	}
 	provider, err := readConfig(options.ConfigPath)
 	if err != nil {
@@ -137,24 +180,30 @@ func (a *Client) Authenticate(request *http.Request) error {
 
 // AccessToken returns an access token for the configured system, refreshing it if necessary.
 func (a *Client) AccessToken() (string, error) {
-	creds, ok := a.provider.Systems[a.options.SystemName]
+	creds, ok := a.provider.credentials(a.options.SystemName, a.options.SystemURL)
 	if !ok {
-		return "", fmt.Errorf("auth0: system %s is not configured: %s", a.options.SystemName, reauthMessage)
+		return "", fmt.Errorf("auth0: system %s is not configured: %w", a.options.SystemName, ErrLoginRequired)
 	} else if creds.AccessToken == "" {
-		return "", fmt.Errorf("auth0: access token missing: %s", reauthMessage)
+		return "", fmt.Errorf("auth0: access token missing: %w", ErrLoginRequired)
 	} else if scopesChanged(creds) {
-		return "", fmt.Errorf("auth0: authentication scopes changed: %s", reauthMessage)
+		return "", fmt.Errorf("auth0: authentication scopes changed: %w", ErrLoginRequired)
 	} else if isExpired(creds.ExpiresAt, accessTokenExpiry) {
 		// check if the stored access token is expired:
 		// use the refresh token to get a new access token:
 		tr := &auth.TokenRetriever{
 			Authenticator: a.Authenticator,
-			Secrets:       auth.NewKeyring(),
+			Secrets:       a.options.secrets(),
 			Client:        http.DefaultClient,
 		}
-		resp, err := tr.Refresh(cancelOnInterrupt(), a.options.SystemName)
+		ctx := cancelOnInterrupt()
+		key := refreshTokenKey(a.options.SystemName, a.options.SystemURL)
+		resp, err := tr.Refresh(ctx, key)
+		if auth.IsNotFound(err) && key != a.options.SystemName {
+			// Refresh tokens stored by older versions of the CLI are keyed by the system name only
+			resp, err = tr.Refresh(ctx, a.options.SystemName)
+		}
 		if err != nil {
-			return "", fmt.Errorf("auth0: failed to renew access token: %w: %s", err, reauthMessage)
+			return "", fmt.Errorf("auth0: failed to renew access token: %w: %w", err, ErrLoginRequired)
 		} else {
 			// persist the updated system with renewed access token
 			creds.AccessToken = resp.AccessToken
@@ -185,12 +234,20 @@ func scopesChanged(s Credentials) bool {
 	return false
 }
 
+// RefreshTokenKey returns the key under which the refresh token of the system configured in this client is stored in
+// secret storage.
+func (a *Client) RefreshTokenKey() string {
+	return refreshTokenKey(a.options.SystemName, a.options.SystemURL)
+}
+
 // WriteCredentials writes given credentials to the configuration file.
 func (a *Client) WriteCredentials(credentials Credentials) error {
 	if a.provider.Systems == nil {
 		a.provider.Systems = make(map[string]Credentials)
 	}
-	a.provider.Systems[a.options.SystemName] = credentials
+	credentials.System = a.options.SystemName
+	delete(a.provider.Systems, a.options.SystemName) // Replaces any credentials keyed by name only
+	a.provider.Systems[credentialsKey(a.options.SystemName, a.options.SystemURL)] = credentials
 	if err := writeConfig(a.provider, a.options.ConfigPath); err != nil {
 		return fmt.Errorf("auth0: failed to write config: %w", err)
 	}
@@ -199,17 +256,65 @@ func (a *Client) WriteCredentials(credentials Credentials) error {
 
 // RemoveCredentials removes credentials for the system configured in this client.
 func (a *Client) RemoveCredentials() error {
-	tr := &auth.TokenRetriever{Secrets: auth.NewKeyring()}
-	if err := tr.Delete(a.options.SystemName); err != nil {
-		return fmt.Errorf("auth0: failed to remove system %s from secret storage: %w", a.options.SystemName, err)
+	if err := RemoveCredentials(a.options); err != nil {
+		return err
 	}
-	delete(a.provider.Systems, a.options.SystemName)
-	if err := writeConfig(a.provider, a.options.ConfigPath); err != nil {
+	provider, err := readConfig(a.options.ConfigPath)
+	if err != nil {
+		return err
+	}
+	a.provider = provider
+	return nil
+}
+
+// RemoveCredentials removes the credentials stored for the system of options from the configuration file at
+// options.ConfigPath, and its refresh token from secret storage. Credentials of other systems are kept.
+func RemoveCredentials(options Options) error {
+	provider, err := readConfig(options.ConfigPath)
+	if err != nil {
+		return err
+	}
+	tr := &auth.TokenRetriever{Secrets: options.secrets()}
+	key := refreshTokenKey(options.SystemName, options.SystemURL)
+	err = tr.Delete(key)
+	if key != options.SystemName {
+		// Also remove any refresh token stored by an older version of the CLI, keyed by the system name only
+		if legacyErr := tr.Delete(options.SystemName); auth.IsNotFound(err) || (legacyErr != nil && !auth.IsNotFound(legacyErr)) {
+			err = legacyErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("auth0: failed to remove system %s from secret storage: %w", options.SystemName, err)
+	}
+	delete(provider.Systems, options.SystemName)
+	delete(provider.Systems, credentialsKey(options.SystemName, options.SystemURL))
+	if err := writeConfig(provider, options.ConfigPath); err != nil {
 		return fmt.Errorf("auth0: failed to write config: %w", err)
 	}
 	return nil
 }
 
+// ReadCredentials reads the credentials stored for given system in the configuration file at configPath. The bool
+// return value is false if no credentials are stored for the system.
+func ReadCredentials(configPath, systemName, systemURL string) (Credentials, bool, error) {
+	provider, err := readConfig(configPath)
+	if err != nil {
+		return Credentials{}, false, err
+	}
+	creds, ok := provider.credentials(systemName, systemURL)
+	return creds, ok, nil
+}
+
+// ListCredentials returns all credentials stored in the configuration file at configPath, keyed by the API URL of
+// their system. Credentials written by older versions of the CLI are keyed by the system name instead.
+func ListCredentials(configPath string) (map[string]Credentials, error) {
+	provider, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Systems, nil
+}
+
 func writeConfig(provider auth0Provider, path string) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0700); err != nil {
@@ -2,14 +2,18 @@
 package auth0
 
 import (
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
+	"github.com/zalando/go-keyring"
 )
 
 func TestConfigWriting(t *testing.T) {
@@ -22,7 +26,7 @@ func TestConfigWriting(t *testing.T) {
   "oauth-token-endpoint": "https://example.com/oauth/token"
 }`
 	httpClient.NextResponseString(200, flowConfigResponse)
-	client, err := NewClient(&httpClient, Options{ConfigPath: configPath, SystemName: "public", SystemURL: "http://example.com"})
+	client, err := NewClient(&httpClient, Options{ConfigPath: configPath, SystemName: "public", SystemURL: "https://public.example.com"})
 	require.Nil(t, err)
 	assert.Equal(t, "https://example.com/api/v2/", client.Authenticator.Audience)
 	assert.Equal(t, "some-id", client.Authenticator.ClientID)
@@ -41,13 +45,14 @@ func TestConfigWriting(t *testing.T) {
         "auth0": {
             "version": 1,
             "systems": {
-                "public": {
+                "https://public.example.com": {
                     "access_token": "some-token",
                     "scopes": [
                         "foo",
                         "bar"
                     ],
-                    "expires_at": "2022-03-01T15:45:50Z"
+                    "expires_at": "2022-03-01T15:45:50Z",
+                    "system": "public"
                 }
             }
         }
@@ -57,7 +62,7 @@ func TestConfigWriting(t *testing.T) {
 
 	// Switch to another system
 	httpClient.NextResponseString(200, flowConfigResponse)
-	client, err = NewClient(&httpClient, Options{ConfigPath: configPath, SystemName: "publiccd", SystemURL: "http://example.com"})
+	client, err = NewClient(&httpClient, Options{ConfigPath: configPath, SystemName: "publiccd", SystemURL: "https://publiccd.example.com"})
 	require.Nil(t, err)
 	creds2 := Credentials{
 		AccessToken: "another-token",
@@ -71,26 +76,99 @@ func TestConfigWriting(t *testing.T) {
         "auth0": {
             "version": 1,
             "systems": {
-                "public": {
+                "https://public.example.com": {
                     "access_token": "some-token",
                     "scopes": [
                         "foo",
                         "bar"
                     ],
-                    "expires_at": "2022-03-01T15:45:50Z"
+                    "expires_at": "2022-03-01T15:45:50Z",
+                    "system": "public"
                 },
-                "publiccd": {
+                "https://publiccd.example.com": {
                     "access_token": "another-token",
                     "scopes": [
                         "baz"
                     ],
-                    "expires_at": "2022-03-01T15:45:50Z"
+                    "expires_at": "2022-03-01T15:45:50Z",
+                    "system": "publiccd"
                 }
             }
         }
     }
 }`
 	assertConfig(t, expected, configPath)
+
+	creds, ok, err := ReadCredentials(configPath, "public", "https://public.example.com")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	creds1.System = "public"
+	assert.Equal(t, creds1, creds)
+	_, ok, err = ReadCredentials(configPath, "public", "https://other.example.com")
+	require.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = ReadCredentials(configPath, "main", "https://main.example.com")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	all, err := ListCredentials(configPath)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"https://public.example.com", "https://publiccd.example.com"}, slices.Sorted(maps.Keys(all)))
+}
+
+func TestLegacyCredentials(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	require.Nil(t, os.WriteFile(configPath, []byte(`{"version":1,"providers":{"auth0":{"version":1,"systems":{"public":{"access_token":"old-token","expires_at":"2022-03-01T15:45:50Z"}}}}}`), 0600))
+	creds, ok, err := ReadCredentials(configPath, "public", "https://public.example.com")
+	require.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "old-token", creds.AccessToken)
+
+	// Writing credentials replaces those keyed by name only
+	httpClient := mock.HTTPClient{}
+	httpClient.NextResponseString(200, `{}`)
+	client, err := NewClient(&httpClient, Options{ConfigPath: configPath, SystemName: "public", SystemURL: "https://public.example.com"})
+	require.Nil(t, err)
+	require.Nil(t, client.WriteCredentials(Credentials{AccessToken: "new-token"}))
+	all, err := ListCredentials(configPath)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]Credentials{"https://public.example.com": {AccessToken: "new-token", System: "public"}}, all)
+}
+
+func TestRemoveRefreshToken(t *testing.T) {
+	keyring.MockInit()
+	secrets := auth.NewSystemKeyring()
+	configPath := filepath.Join(t.TempDir(), "config")
+	options := Options{ConfigPath: configPath, SystemName: "public", SystemURL: "https://public.example.com", Secrets: secrets}
+	httpClient := mock.HTTPClient{}
+	httpClient.NextResponseString(200, `{}`)
+	client, err := NewClient(&httpClient, options)
+	require.Nil(t, err)
+	assert.Equal(t, "https%3A%2F%2Fpublic.example.com", client.RefreshTokenKey())
+	require.Nil(t, client.WriteCredentials(Credentials{AccessToken: "token"}))
+
+	// Both the refresh token keyed by the system URL, and any keyed by name by older versions, are removed
+	require.Nil(t, secrets.Set(auth.SecretsNamespace, client.RefreshTokenKey(), "refresh-token"))
+	require.Nil(t, secrets.Set(auth.SecretsNamespace, "public", "old-refresh-token"))
+	require.Nil(t, secrets.Set(auth.SecretsNamespace, "publiccd", "other-refresh-token"))
+	require.Nil(t, RemoveCredentials(options))
+	_, err = secrets.Get(auth.SecretsNamespace, client.RefreshTokenKey())
+	assert.True(t, auth.IsNotFound(err))
+	_, err = secrets.Get(auth.SecretsNamespace, "public")
+	assert.True(t, auth.IsNotFound(err))
+	value, err := secrets.Get(auth.SecretsNamespace, "publiccd")
+	require.Nil(t, err)
+	assert.Equal(t, "other-refresh-token", value)
+	_, ok, err := ReadCredentials(configPath, "public", "https://public.example.com")
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	// Removing a token keyed by name only suffices
+	require.Nil(t, secrets.Set(auth.SecretsNamespace, "public", "old-refresh-token"))
+	require.Nil(t, RemoveCredentials(options))
+
+	// Removing fails when no refresh token is stored
+	assert.NotNil(t, RemoveCredentials(options))
 }
 
 func assertConfig(t *testing.T, expected, path string) {
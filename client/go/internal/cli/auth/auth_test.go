@@ -0,0 +1,53 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	waitThreshold = time.Millisecond
+	httpClient := &mock.HTTPClient{}
+	a := Authenticator{
+		ClientID:           "some-id",
+		DeviceCodeEndpoint: "https://example.com/oauth/device/code",
+		OauthTokenEndpoint: "https://example.com/oauth/token",
+		Client:             httpClient,
+	}
+	httpClient.NextResponseString(200, `{"device_code":"dc1","user_code":"ABCD-EFGH","verification_uri_complete":"https://example.com/activate?user_code=ABCD-EFGH","expires_in":900,"interval":0}`)
+	state, err := a.Start(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "https://example.com/oauth/device/code", httpClient.LastRequest.URL.String())
+	assert.Equal(t, "ABCD-EFGH", state.UserCode)
+	assert.Equal(t, "https://example.com/activate?user_code=ABCD-EFGH", state.VerificationURI)
+
+	// Pending until user logs in
+	httpClient.NextResponseString(400, `{"error":"authorization_pending"}`)
+	httpClient.NextResponseString(400, `{"error":"authorization_pending"}`)
+	httpClient.NextResponseString(200, `{"access_token":"at","refresh_token":"rt","expires_in":3600}`)
+	pending := 0
+	res, err := a.Wait(context.Background(), state, func() { pending++ })
+	require.Nil(t, err)
+	assert.Equal(t, 2, pending)
+	assert.Equal(t, Result{AccessToken: "at", RefreshToken: "rt", ExpiresIn: 3600}, res)
+	require.Nil(t, httpClient.LastRequest.ParseForm())
+	assert.Equal(t, "https://example.com/oauth/token", httpClient.LastRequest.URL.String())
+	assert.Equal(t, "dc1", httpClient.LastRequest.PostForm.Get("device_code"))
+
+	// Device code expires
+	httpClient.NextResponseString(400, `{"error":"expired_token","error_description":"Token expired"}`)
+	_, err = a.Wait(context.Background(), state, nil)
+	assert.True(t, errors.Is(err, ErrExpiredToken))
+
+	// Other errors
+	httpClient.NextResponseString(403, `{"error":"access_denied","error_description":"User denied access"}`)
+	_, err = a.Wait(context.Background(), state, nil)
+	assert.Equal(t, "User denied access", err.Error())
+}
@@ -3,6 +3,8 @@
 package auth
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 
 	"github.com/zalando/go-keyring"
@@ -22,6 +24,73 @@ func NewKeyringWithOptions(useDummy bool) SecretStore {
 	return &realKeyring{}
 }
 
+// NewSystemKeyring returns a store of secrets in the credential store of the operating system. This is the Keychain on
+// macOS, the Credential Manager on Windows, and a Secret Service on Linux.
+func NewSystemKeyring() SecretStore { return &realKeyring{} }
+
+// NewFileKeyring returns a store of secrets in unencrypted files in the home directory of the user.
+func NewFileKeyring() SecretStore { return &dummyKeyring{} }
+
+// IsNotFound returns whether err is the error of getting or deleting a secret which is not stored.
+func IsNotFound(err error) bool {
+	return errors.Is(err, keyring.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// migratingStore is a SecretStore which moves secrets from one store to another when they are read.
+type migratingStore struct {
+	to       SecretStore
+	from     SecretStore
+	migrated func(namespace, key string)
+}
+
+// NewMigratingStore returns a store of secrets in to. A secret which is not found in to, but in from, is moved to to
+// when it is read, and migrated is then called, if non-nil. Secrets are removed from both when they are deleted.
+func NewMigratingStore(to, from SecretStore, migrated func(namespace, key string)) SecretStore {
+	return &migratingStore{to: to, from: from, migrated: migrated}
+}
+
+// Set sets the given key/value pair with the given namespace.
+func (s *migratingStore) Set(namespace, key, value string) error {
+	if err := s.to.Set(namespace, key, value); err != nil {
+		return err
+	}
+	s.from.Delete(namespace, key) // Remove any stale secret, which would otherwise be found if to is cleared
+	return nil
+}
+
+// Get gets a value for the given namespace and key.
+func (s *migratingStore) Get(namespace, key string) (string, error) {
+	value, err := s.to.Get(namespace, key)
+	if err == nil || !IsNotFound(err) {
+		return value, err
+	}
+	value, fromErr := s.from.Get(namespace, key)
+	if fromErr != nil {
+		return "", err
+	}
+	if s.to.Set(namespace, key, value) != nil {
+		return value, nil // Kept where it is, to try moving it again later
+	}
+	s.from.Delete(namespace, key)
+	if s.migrated != nil {
+		s.migrated(namespace, key)
+	}
+	return value, nil
+}
+
+// Delete deletes a value for the given namespace and key.
+func (s *migratingStore) Delete(namespace, key string) error {
+	err := s.to.Delete(namespace, key)
+	fromErr := s.from.Delete(namespace, key)
+	if err == nil || fromErr == nil {
+		return nil
+	}
+	if IsNotFound(err) {
+		return fromErr
+	}
+	return err
+}
+
 // Set sets the given key/value pair with the given namespace.
 func (k *realKeyring) Set(namespace, key, value string) error {
 	return keyring.Set(namespace, key, value)
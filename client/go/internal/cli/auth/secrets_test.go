@@ -0,0 +1,52 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+func TestMigratingStore(t *testing.T) {
+	keyring.MockInit()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	files, system := NewFileKeyring(), NewSystemKeyring()
+	require.Nil(t, files.Set(SecretsNamespace, "public", "refresh-token"))
+
+	var migrated []string
+	store := NewMigratingStore(system, files, func(namespace, key string) { migrated = append(migrated, namespace+"/"+key) })
+	_, err := store.Get(SecretsNamespace, "other")
+	assert.True(t, IsNotFound(err))
+	assert.Nil(t, migrated)
+
+	value, err := store.Get(SecretsNamespace, "public")
+	require.Nil(t, err)
+	assert.Equal(t, "refresh-token", value)
+	assert.Equal(t, []string{"vespa-cli/public"}, migrated)
+	_, err = os.Stat(filepath.Join(home, ".vespa", "keyring.vespa-cli.public"))
+	assert.True(t, os.IsNotExist(err))
+	value, err = system.Get(SecretsNamespace, "public")
+	require.Nil(t, err)
+	assert.Equal(t, "refresh-token", value)
+
+	// Read from the new store only, once moved
+	value, err = store.Get(SecretsNamespace, "public")
+	require.Nil(t, err)
+	assert.Equal(t, "refresh-token", value)
+	assert.Len(t, migrated, 1)
+
+	require.Nil(t, store.Set(SecretsNamespace, "publiccd", "other-token"))
+	value, err = system.Get(SecretsNamespace, "publiccd")
+	require.Nil(t, err)
+	assert.Equal(t, "other-token", value)
+
+	require.Nil(t, store.Delete(SecretsNamespace, "public"))
+	_, err = system.Get(SecretsNamespace, "public")
+	assert.True(t, IsNotFound(err))
+	assert.True(t, IsNotFound(store.Delete(SecretsNamespace, "public")))
+}
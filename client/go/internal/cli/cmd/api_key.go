@@ -4,13 +4,16 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"net/http"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
-	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
@@ -44,6 +47,9 @@ Note that when overriding the developer key through environment variables,
 that key will always be used. It's not possible to specify a tenant-specific
 key through the environment.
 
+A key set in-line in VESPA_CLI_API_KEY is never written to disk. Use
+'vespa auth show' to see which key is in use.
+
 See https://docs.vespa.ai/en/cloud/security/guide.html for more details about developer keys.`,
 		Example:           "$ vespa auth api-key -a my-tenant.my-app.my-instance",
 		DisableAutoGenTag: true,
@@ -55,6 +61,36 @@ See https://docs.vespa.ai/en/cloud/security/guide.html for more details about de
 	}
 	cmd.Flags().BoolVarP(&overwriteKey, "force", "f", false, "Force overwrite of existing developer key")
 	cmd.MarkPersistentFlagRequired(applicationFlag)
+	cmd.AddCommand(newAPIKeyRotateCmd(cli))
+	return cmd
+}
+
+func newAPIKeyRotateCmd(cli *CLI) *cobra.Command {
+	var revokeOld bool
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace the developer key with a new one",
+		Long: `Replace the developer key with a new one.
+
+A new developer key is created and its public key is registered with the tenant
+in Vespa Cloud. Once a request signed with the new key succeeds, the new key
+replaces the existing key file, and the existing key is kept as a timestamped
+backup next to it.
+
+If any step fails, the existing developer key is left in place.
+
+With --revoke-old, the public key of the existing developer key is also removed
+from the tenant once the new key has been verified.`,
+		Example: `$ vespa auth api-key rotate -a my-tenant.my-app
+$ vespa auth api-key rotate --revoke-old -a my-tenant.my-app`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return doAPIKeyRotate(cli, revokeOld)
+		},
+	}
+	cmd.Flags().BoolVar(&revokeOld, "revoke-old", false, "Remove the existing developer key from the tenant after the new key is verified")
 	return cmd
 }
 
@@ -72,36 +108,147 @@ func doApiKey(cli *CLI, overwriteKey bool, args []string) error {
 		return err
 	}
 	apiKeyFile := cli.config.apiKeyPath(app.Tenant)
-	if ioutil.Exists(apiKeyFile) && !overwriteKey {
-		err := fmt.Errorf("refusing to overwrite '%s'", apiKeyFile)
+	files := cli.apiKeyFiles()
+	if existing, err := files.ReadFile(apiKeyFile); err == nil && !overwriteKey {
+		err := fmt.Errorf("refusing to overwrite %s", files.Describe(apiKeyFile))
 		cli.printErr(err, "Use -f to overwrite it")
-		printPublicKey(system, apiKeyFile, app.Tenant)
+		printPublicKey(system, existing, app.Tenant)
 		return ErrCLI{error: err, quiet: true}
 	}
 	apiKey, err := vespa.CreateAPIKey()
 	if err != nil {
 		return fmt.Errorf("could not create api key: %w", err)
 	}
-	if err := os.WriteFile(apiKeyFile, apiKey, 0600); err == nil {
-		cli.printSuccess("Developer private key for tenant ", color.CyanString(app.Tenant), " written to '", apiKeyFile, "'")
-		return printPublicKey(system, apiKeyFile, app.Tenant)
+	if err := files.WriteFile(apiKeyFile, apiKey); err == nil {
+		cli.printSuccess("Developer private key for tenant ", color.CyanString(app.Tenant), " written to ", files.Describe(apiKeyFile))
+		return printPublicKey(system, apiKey, app.Tenant)
 	} else {
-		return fmt.Errorf("failed to write: '%s': %w", apiKeyFile, err)
+		return fmt.Errorf("failed to write: %s: %w", files.Describe(apiKeyFile), err)
+	}
+}
+
+func doAPIKeyRotate(cli *CLI, revokeOld bool) error {
+	if _, ok := cli.config.apiKeyFromEnv(); ok {
+		return errHint(fmt.Errorf("cannot rotate developer key set in VESPA_CLI_API_KEY"), "Create a new key with 'vespa auth api-key' and update the environment")
+	}
+	target, err := cli.target(targetOptions{supportedType: cloudTargetOnly, noCertificate: true})
+	if err != nil {
+		return err
+	}
+	service, err := target.DeployService()
+	if err != nil {
+		return err
+	}
+	app := target.Deployment().Application
+	apiKeyFile := cli.config.apiKeyPath(app.Tenant)
+	files := cli.apiKeyFiles()
+	oldKey, err := files.ReadFile(apiKeyFile)
+	if err != nil {
+		return errHint(fmt.Errorf("failed to read: %s: %w", files.Describe(apiKeyFile), err), "Create a developer key with 'vespa auth api-key'")
+	}
+	oldPublicKey, err := publicKeyFrom(oldKey)
+	if err != nil {
+		return err
 	}
+	newKey, err := vespa.CreateAPIKey()
+	if err != nil {
+		return fmt.Errorf("could not create api key: %w", err)
+	}
+	newPublicKey, err := publicKeyFrom(newKey)
+	if err != nil {
+		return err
+	}
+	newKeyFile := apiKeyFile + ".new"
+	if err := files.WriteFile(newKeyFile, newKey); err != nil {
+		return fmt.Errorf("failed to write: %s: %w", files.Describe(newKeyFile), err)
+	}
+	defer files.Remove(newKeyFile) // Either renamed or abandoned when we're done
+	keyURL := fmt.Sprintf("%s/application/v4/tenant/%s/key", service.BaseURL, app.Tenant)
+	unchanged := fmt.Sprintf("The existing developer key in %s is unchanged", files.Describe(apiKeyFile))
+	if err := developerKeyRequest(service.Do, "POST", keyURL, newPublicKey); err != nil {
+		return errHint(fmt.Errorf("could not register new developer key: %w", err), unchanged)
+	}
+	cli.printInfo("Registered new developer key with tenant ", app.Tenant)
+	// Verify the new key by signing a request with it
+	signer := vespa.NewRequestSigner(app.SerializedForm(), newKey)
+	verify := func(request *http.Request, timeout time.Duration) (*http.Response, error) {
+		if err := signer.SignRequest(request); err != nil {
+			return nil, err
+		}
+		return cli.httpClient.Do(request, timeout)
+	}
+	if err := developerKeyRequest(verify, "GET", fmt.Sprintf("%s/application/v4/tenant/%s", service.BaseURL, app.Tenant), nil); err != nil {
+		if err := developerKeyRequest(service.Do, "DELETE", keyURL, newPublicKey); err != nil {
+			cli.printWarning(fmt.Sprintf("Could not remove new developer key from tenant %s: %s", app.Tenant, err))
+		}
+		return errHint(fmt.Errorf("could not verify new developer key: %w", err), unchanged)
+	}
+	backupFile := fmt.Sprintf("%s.%s.bak", apiKeyFile, time.Now().UTC().Format("20060102T150405Z"))
+	if err := files.Rename(apiKeyFile, backupFile); err != nil {
+		return errHint(fmt.Errorf("failed to back up %s: %w", files.Describe(apiKeyFile), err), unchanged)
+	}
+	if err := files.Rename(newKeyFile, apiKeyFile); err != nil {
+		if restoreErr := files.Rename(backupFile, apiKeyFile); restoreErr != nil {
+			return fmt.Errorf("failed to write %s: %w, and failed to restore backup %s: %s", files.Describe(apiKeyFile), err, files.Describe(backupFile), restoreErr)
+		}
+		return errHint(fmt.Errorf("failed to write: %s: %w", files.Describe(apiKeyFile), err), unchanged)
+	}
+	cli.printSuccess("Rotated developer key for tenant ", color.CyanString(app.Tenant), ". Previous key backed up to ", files.Describe(backupFile))
+	if revokeOld {
+		if err := developerKeyRequest(verify, "DELETE", keyURL, oldPublicKey); err != nil {
+			return errHint(fmt.Errorf("could not revoke previous developer key: %w", err),
+				"The new developer key is in use, but the previous key is still registered with the tenant",
+				fmt.Sprintf("Remove it at %s/tenant/%s/account/keys", target.Deployment().System.ConsoleURL, app.Tenant))
+		}
+		cli.printSuccess("Revoked previous developer key for tenant ", color.CyanString(app.Tenant))
+	}
+	return nil
 }
 
-func printPublicKey(system vespa.System, apiKeyFile, tenant string) error {
-	pemKeyData, err := os.ReadFile(apiKeyFile)
+// developerKeyRequest sends a request for the developer key in pemPublicKey, if any, using do.
+func developerKeyRequest(do func(*http.Request, time.Duration) (*http.Response, error), method, url string, pemPublicKey []byte) error {
+	var body io.Reader
+	if pemPublicKey != nil {
+		b, err := json.Marshal(map[string]string{"key": string(pemPublicKey)})
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return fmt.Errorf("failed to read: '%s': %w", apiKeyFile, err)
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	response, err := do(req, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("got status %d from %s", response.StatusCode, url)
+	}
+	return nil
+}
+
+func publicKeyFrom(pemKeyData []byte) ([]byte, error) {
 	key, err := vespa.ECPrivateKeyFrom(pemKeyData)
 	if err != nil {
-		return fmt.Errorf("failed to load key: %w", err)
+		return nil, fmt.Errorf("failed to load key: %w", err)
 	}
 	pemPublicKey, err := vespa.PEMPublicKeyFrom(key)
 	if err != nil {
-		return fmt.Errorf("failed to extract public key: %w", err)
+		return nil, fmt.Errorf("failed to extract public key: %w", err)
+	}
+	return pemPublicKey, nil
+}
+
+func printPublicKey(system vespa.System, pemKeyData []byte, tenant string) error {
+	pemPublicKey, err := publicKeyFrom(pemKeyData)
+	if err != nil {
+		return err
 	}
 	fingerprint, err := vespa.FingerprintMD5(pemPublicKey)
 	if err != nil {
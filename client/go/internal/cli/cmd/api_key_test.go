@@ -4,9 +4,16 @@
 package cmd
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
 )
 
 func TestAPIKey(t *testing.T) {
@@ -33,3 +40,76 @@ func testAPIKey(t *testing.T, subcommand []string) {
 	assert.Contains(t, stderr.String(), "Hint: Use -f to overwrite it\n")
 	assert.Contains(t, stdout.String(), "This is your public key")
 }
+
+func TestAPIKeyRotate(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+	apiKeyFile := cli.config.apiKeyPath("t1")
+	oldKey, err := os.ReadFile(apiKeyFile)
+	require.Nil(t, err)
+	oldPublicKey, err := publicKeyFrom(oldKey)
+	require.Nil(t, err)
+	httpClient := &mock.HTTPClient{ReadBody: true}
+	cli.httpClient = httpClient
+
+	// Verification with new key fails
+	httpClient.NextResponseString(200, `{}`)
+	httpClient.NextResponseString(403, `{"error-code":"FORBIDDEN"}`)
+	httpClient.NextResponseString(200, `{}`)
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "api-key", "rotate"))
+	assert.Contains(t, stderr.String(), "Error: could not verify new developer key: got status 403 from https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1\n")
+	assert.Contains(t, stderr.String(), "Hint: The existing developer key in '"+apiKeyFile+"' is unchanged\n")
+	require.Equal(t, 3, len(httpClient.Requests))
+	assert.Equal(t, "POST", httpClient.Requests[0].Method)
+	assert.Equal(t, "DELETE", httpClient.Requests[2].Method)
+	assertFileContent(t, apiKeyFile, oldKey)
+	assert.False(t, ioutil.Exists(apiKeyFile+".new"))
+
+	// Rotation succeeds
+	httpClient.Requests = nil
+	httpClient.NextResponseString(200, `{}`)
+	httpClient.NextResponseString(200, `{}`)
+	httpClient.NextResponseString(200, `{}`)
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "api-key", "rotate", "--revoke-old"))
+	require.Equal(t, 3, len(httpClient.Requests))
+	newKey, err := os.ReadFile(apiKeyFile)
+	require.Nil(t, err)
+	assert.NotEqual(t, oldKey, newKey)
+	newPublicKey, err := publicKeyFrom(newKey)
+	require.Nil(t, err)
+
+	register := httpClient.Requests[0]
+	assert.Equal(t, "POST", register.Method)
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/key", register.URL.String())
+	verify := httpClient.Requests[1]
+	assert.Equal(t, "GET", verify.Method)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(newPublicKey), verify.Header.Get("X-Key"))
+	revoke := httpClient.Requests[2]
+	assert.Equal(t, "DELETE", revoke.Method)
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/key", revoke.URL.String())
+	var revoked struct {
+		Key string `json:"key"`
+	}
+	require.Nil(t, json.Unmarshal(httpClient.LastBody, &revoked))
+	assert.Equal(t, string(oldPublicKey), revoked.Key)
+
+	backups, err := filepath.Glob(apiKeyFile + ".*.bak")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(backups))
+	assertFileContent(t, backups[0], oldKey)
+	info, err := os.Stat(apiKeyFile)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Contains(t, stdout.String(), "Success: Rotated developer key for tenant t1. Previous key backed up to '"+backups[0]+"'\n")
+	assert.Contains(t, stdout.String(), "Success: Revoked previous developer key for tenant t1\n")
+}
+
+func assertFileContent(t *testing.T, path string, expected []byte) {
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, expected, data)
+}
@@ -0,0 +1,172 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Transformation of the fields of documents by a jq expression, for vespa visit and vespa feed
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/itchyny/gojq"
+	"github.com/spf13/cobra"
+)
+
+// applyFlags holds the flags of a command which transforms the fields of documents by a jq expression.
+type applyFlags struct {
+	expression string
+	file       string
+}
+
+func addApplyFlags(cmd *cobra.Command, flags *applyFlags) {
+	cmd.Flags().StringVar(&flags.expression, "apply", "", "Transform the fields of each document by this jq expression. Documents for which it returns null are dropped")
+	cmd.Flags().StringVar(&flags.file, "apply-file", "", "Transform the fields of each document by the jq program in this file, like --apply")
+}
+
+// applier returns the applier of the expression given by these flags, or nil if documents should not be transformed.
+func (f applyFlags) applier() (*documentApplier, error) {
+	program, name := f.expression, "--apply"
+	if f.file != "" {
+		if f.expression != "" {
+			return nil, fmt.Errorf("options --apply and --apply-file cannot be combined")
+		}
+		data, err := os.ReadFile(f.file)
+		if err != nil {
+			return nil, err
+		}
+		program, name = string(data), f.file
+	}
+	if program == "" {
+		return nil, nil
+	}
+	query, err := gojq.Parse(program)
+	if err != nil {
+		var parseErr *gojq.ParseError
+		if errors.As(err, &parseErr) {
+			err = fmt.Errorf("%w at position %d", err, parseErr.Offset)
+		}
+		return nil, errHint(fmt.Errorf("invalid jq expression in %s: %w", name, err), "See https://jqlang.github.io/jq/manual/ for the syntax of jq expressions")
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, errHint(fmt.Errorf("invalid jq expression in %s: %w", name, err), "See https://jqlang.github.io/jq/manual/ for the syntax of jq expressions")
+	}
+	return &documentApplier{code: code, concurrency: runtime.GOMAXPROCS(0)}, nil
+}
+
+// documentApplier transforms the fields of documents by a compiled jq expression, which is shared by all documents.
+// The expression is given the fields object of a document, and returns the fields to replace it, or null to drop the
+// document.
+type documentApplier struct {
+	code *gojq.Code
+	// concurrency is the number of documents transformed at once by applyAll
+	concurrency int
+
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+// apply returns the document body, which holds its fields in a "fields" member, with the fields transformed. A nil body
+// and error is returned if the document is dropped. Bodies without fields, like those of removes, are returned as-is.
+func (a *documentApplier) apply(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return body, nil
+	}
+	members, err := decodeObject(body)
+	if err != nil {
+		a.failed.Add(1)
+		return nil, err
+	}
+	fields := getMember(members, "fields")
+	if fields == nil {
+		return body, nil
+	}
+	result, err := a.applyFields(fields)
+	if err != nil {
+		a.failed.Add(1)
+		return nil, err
+	}
+	if result == nil {
+		a.dropped.Add(1)
+		return nil, nil
+	}
+	return encodeObject(setMember(members, "fields", result)), nil
+}
+
+func (a *documentApplier) applyFields(fields []byte) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(fields))
+	dec.UseNumber()
+	var input any
+	if err := dec.Decode(&input); err != nil {
+		return nil, err
+	}
+	iter := a.code.Run(input)
+	value, ok := iter.Next()
+	if !ok {
+		return nil, fmt.Errorf("expression returned no value")
+	}
+	if err, ok := value.(error); ok {
+		return nil, err
+	}
+	if next, ok := iter.Next(); ok {
+		if err, ok := next.(error); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("expression returned more than one value")
+	}
+	switch value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		return gojq.Marshal(value)
+	}
+	return nil, fmt.Errorf("expression returned %s, but fields must be an object: %s", gojq.TypeOf(value), gojq.Preview(value))
+}
+
+// applyAll transforms bodies concurrently, and returns the results in order, as returned by apply.
+func (a *documentApplier) applyAll(bodies [][]byte) ([][]byte, []error) {
+	outs := make([][]byte, len(bodies))
+	errs := make([]error, len(bodies))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(a.concurrency, len(bodies)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(bodies); i = int(next.Add(1) - 1) {
+				outs[i], errs[i] = a.apply(bodies[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return outs, errs
+}
+
+// close returns an error if any document failed to be transformed.
+func (a *documentApplier) close() error {
+	if a == nil {
+		return nil
+	}
+	if n := a.failed.Load(); n > 0 {
+		return fmt.Errorf("--apply failed for %d documents", n)
+	}
+	return nil
+}
+
+// summary returns the number of documents dropped or failed by the expression, or nil if none is applied.
+func (a *documentApplier) summary() *applySummary {
+	if a == nil {
+		return nil
+	}
+	return &applySummary{DroppedCount: a.dropped.Load(), ErrorCount: a.failed.Load()}
+}
+
+// applySummary holds the number of operations of a feed which were dropped or failed by --apply.
+type applySummary struct {
+	DroppedCount int64 `json:"feeder.apply.dropped.count"`
+	ErrorCount   int64 `json:"feeder.apply.error.count"`
+}
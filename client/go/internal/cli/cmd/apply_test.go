@@ -0,0 +1,126 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestApply(t *testing.T) {
+	applier, err := applyFlags{expression: `if .year < 1900 then null else .title |= ascii_upcase end`}.applier()
+	require.Nil(t, err)
+	bodies := [][]byte{
+		[]byte(`{"id":"id:t:m::1","fields":{"title":"a","year":2000,"big":12345678901234567890}}`),
+		[]byte(`{"fields":{"title":"b","year":1800}}`),
+		[]byte(`{"fields":{"title":1,"year":2000}}`),
+		[]byte(`{"id":"id:t:m::4"}`),
+	}
+	outs, errs := applier.applyAll(bodies)
+	assert.Equal(t, `{"id":"id:t:m::1","fields":{"big":12345678901234567890,"title":"A","year":2000}}`, string(outs[0]))
+	assert.Nil(t, errs[0])
+	assert.Nil(t, outs[1])
+	assert.Nil(t, errs[1])
+	assert.Nil(t, outs[2])
+	assert.Equal(t, "ascii_upcase cannot be applied to: number (1)", errs[2].Error())
+	assert.Equal(t, string(bodies[3]), string(outs[3]))
+	assert.Nil(t, errs[3])
+	assert.Equal(t, &applySummary{DroppedCount: 1, ErrorCount: 1}, applier.summary())
+	assert.Equal(t, "--apply failed for 1 documents", applier.close().Error())
+
+	for expression, msg := range map[string]string{
+		`.title`:     "expression returned string, but fields must be an object: \"a\"",
+		`.[]`:        "expression returned more than one value",
+		`empty`:      "expression returned no value",
+		`error("x")`: "error: x",
+	} {
+		applier, err := applyFlags{expression: expression}.applier()
+		require.Nil(t, err)
+		_, err = applier.apply([]byte(`{"fields":{"title":"a","year":2000}}`))
+		assert.Equal(t, msg, err.Error(), expression)
+	}
+
+	_, err = applyFlags{expression: `.title |`}.applier()
+	assert.Equal(t, "invalid jq expression in --apply: unexpected EOF at position 8", err.Error())
+	programFile := filepath.Join(t.TempDir(), "migrate.jq")
+	require.Nil(t, os.WriteFile(programFile, []byte("# Rename artist_name\n.artist = .artist_name | del(.artist_name)\n"), 0644))
+	applier, err = applyFlags{file: programFile}.applier()
+	require.Nil(t, err)
+	out, err := applier.apply([]byte(`{"fields":{"artist_name":"x"}}`))
+	require.Nil(t, err)
+	assert.Equal(t, `{"fields":{"artist":"x"}}`, string(out))
+	_, err = applyFlags{expression: ".", file: programFile}.applier()
+	assert.NotNil(t, err)
+	applier, err = applyFlags{}.applier()
+	assert.Nil(t, applier)
+	assert.Nil(t, err)
+}
+
+func TestVisitApply(t *testing.T) {
+	visit := func(args ...string) (string, string, error) {
+		cli, stdout, stderr := newTestCLI(t)
+		client := cli.httpClient.(*mock.HTTPClient)
+		client.NextResponseString(200, handlersResponse)
+		client.NextResponseString(200, normalpre+document1+","+document2+`],"documentCount":2,"continuation":"CAFE"}`)
+		client.NextResponseString(200, normalpre+document3+`],"documentCount":1}`)
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default"}, args...)
+		err := cli.Run(args...)
+		return stdout.String(), stderr.String(), err
+	}
+	stdout, stderr, err := visit("--apply", `if .title == "t2" then null else .title |= ascii_upcase end`)
+	require.Nil(t, err)
+	assert.Equal(t, `{"id":"id:t:m::1","fields":{"title":"T"}}
+{"id":"id:t:m::3","fields":{"ar":"xyz","title":"XYZZY","w":63,"year":2000}}
+`, stdout)
+	assert.Equal(t, "Dropped 1 documents for which --apply returned null\n", stderr)
+
+	stdout, stderr, err = visit("--apply", `.title += 1`)
+	require.NotNil(t, err)
+	assert.Equal(t, "visit failed: --apply failed for 3 documents", err.Error())
+	assert.Equal(t, "", stdout)
+	assert.Contains(t, stderr, "Error: --apply failed for id:t:m::3: cannot add: string (\"xyzzy\") and number (1)\n")
+
+	cli, _, stderr2 := newTestCLI(t)
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--apply", ".", "--count"))
+	cli, _, stderr2 = newTestCLI(t)
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--apply", "{"))
+	assert.Contains(t, stderr2.String(), "Error: invalid jq expression in --apply: unexpected EOF at position 1\nHint: See https://jqlang.github.io/jq/manual/ for the syntax of jq expressions\n")
+}
+
+func TestFeedApply(t *testing.T) {
+	td := t.TempDir()
+	jsonFile := filepath.Join(td, "docs.jsonl")
+	require.Nil(t, os.WriteFile(jsonFile, []byte(`{"put": "id:ns:type::doc1", "fields": {"artist_name": "A", "year": 1800}}
+{"put": "id:ns:type::doc2", "fields": {"artist_name": 2, "year": 2001}}
+{"remove": "id:ns:type::doc3"}
+{"put": "id:ns:type::doc4", "fields": {"artist_name": "D", "year": 2000}}
+`), 0644))
+	apply := `if .year < 1900 then null else {artist: (.artist_name | ascii_downcase), year} end`
+
+	cli, stdout, stderr := newTestCLI(t)
+	httpClient := cli.httpClient.(*mock.HTTPClient)
+	httpClient.ReadBody = true
+	queueFeedBlockCheck(httpClient)
+	httpClient.NextResponseString(200, `{"message":"OK"}`)
+	httpClient.NextResponseString(200, `{"message":"OK"}`)
+	err := cli.Run("feed", "-t", "http://127.0.0.1:8080", "--inflight", "1", "--apply", apply, jsonFile)
+	require.NotNil(t, err)
+	assert.Equal(t, "--apply failed for 1 documents", err.Error())
+	require.Equal(t, 3, len(httpClient.Requests))
+	assert.Equal(t, "/document/v1/ns/type/docid/doc3", httpClient.Requests[1].URL.Path)
+	assert.Equal(t, "/document/v1/ns/type/docid/doc4", httpClient.Requests[2].URL.Path)
+	assert.Equal(t, `{"fields":{"artist":"d","year":2000}}`, string(httpClient.LastBody))
+	assert.Equal(t, "feed: --apply failed for id:ns:type::doc2 in "+jsonFile+" line 2: ascii_downcase cannot be applied to: number (2)\nError: --apply failed for 1 documents\n", stderr.String())
+	assert.Contains(t, stdout.String(), `"feeder.apply.dropped.count": 1,`)
+	assert.Contains(t, stdout.String(), `"feeder.apply.error.count": 1`)
+
+	// Operations are checked after they are transformed
+	cli, stdout, stderr = newTestCLI(t)
+	require.NotNil(t, cli.Run("feed", "--dry-run", "--apply", apply, jsonFile))
+	assert.Contains(t, stdout.String(), `"feeder.operation.count": 2,`)
+	assert.Equal(t, "Error: --apply failed for id:ns:type::doc2 in "+jsonFile+" line 2: ascii_downcase cannot be applied to: number (2)\nError: found 1 invalid operations\n", stderr.String())
+}
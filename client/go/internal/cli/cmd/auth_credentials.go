@@ -0,0 +1,369 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa auth list-credentials and auth prune commands
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+const (
+	credentialCertificate = "certificate"
+	credentialToken       = "token"
+)
+
+func newAuthListCredentialsCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list-credentials",
+		Short: "List the data plane credentials stored in the Vespa CLI home directory",
+		Long: `List the data plane credentials stored in the Vespa CLI home directory.
+
+This shows each certificate and private key pair created by 'vespa auth cert',
+and each token stored by 'vespa auth token set', with the application it
+belongs to, when it was created, and when it expires. Tokens are not stored per
+application, and are shown with the current application if they are the token
+given by the data-plane-token option.
+
+When you are authenticated with Vespa Cloud, by 'vespa auth login' or an API
+key, the control plane is asked whether the application of each credential
+still exists in its tenant. Credentials of applications which do not exist, and
+expired certificates, can be deleted with 'vespa auth prune'.
+`,
+		Example: `$ vespa auth list-credentials
+$ vespa auth list-credentials -o json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			credentials, err := readDataPlaneCredentials(cli)
+			if err != nil {
+				return err
+			}
+			if cli.jsonOutput() {
+				return cli.printResult(credentials)
+			}
+			if len(credentials) == 0 {
+				cli.printInfo("No data plane credentials stored. Create a certificate with 'vespa auth cert'")
+				return nil
+			}
+			return printDataPlaneCredentials(cli, credentials)
+		},
+	}
+}
+
+func newAuthPruneCmd(cli *CLI) *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete expired data plane credentials, and those of deleted applications",
+		Long: `Delete expired data plane credentials, and those of deleted applications.
+
+The certificates listed by 'vespa auth list-credentials' which are expired, or
+belong to an application which no longer exists in its tenant, are deleted
+from the Vespa CLI home directory, together with their private keys. You are
+asked to confirm the deletion of each, unless --force is given. Whether an
+application exists is only known when you are authenticated with Vespa Cloud.
+Tokens are never deleted, since neither their application nor their expiry
+are known.
+
+The credentials of the current application are only deleted when you confirm
+it by typing the name of the application, also with --force. They are kept
+when the terminal is not interactive.
+`,
+		Example: `$ vespa auth prune
+$ vespa auth prune --force`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !force {
+				if err := cli.checkInteractive(); err != nil {
+					return errHint(err, "Use --force to delete the credentials without confirmation")
+				}
+			}
+			credentials, err := readDataPlaneCredentials(cli)
+			if err != nil {
+				return err
+			}
+			deleted := []dataPlaneCredential{}
+			for _, c := range credentials {
+				reason := c.pruneReason(cli.now())
+				if reason == "" {
+					continue
+				}
+				var ok bool
+				if c.Current {
+					cli.printWarning(fmt.Sprintf("The %s of %s is %s, but it is the certificate of the current application", c.Type, color.CyanString(c.Application), reason))
+					if ok, _ = cli.confirmExact(c.Application); !ok {
+						cli.printInfo("Kept the ", c.Type, " of ", c.Application)
+						continue
+					}
+				} else if force {
+					ok = true
+				} else if ok, err = cli.confirm(fmt.Sprintf("Delete the %s of %s, which is %s?", c.Type, color.CyanString(c.Application), reason), false); err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+				if err := c.remove(); err != nil {
+					return fmt.Errorf("could not delete the %s of %s: %w", c.Type, c.Application, err)
+				}
+				deleted = append(deleted, c)
+			}
+			if cli.jsonOutput() {
+				return cli.printResult(deleted)
+			}
+			if len(deleted) == 0 {
+				cli.printInfo("No credentials deleted")
+				return nil
+			}
+			plural := "s"
+			if len(deleted) == 1 {
+				plural = ""
+			}
+			cli.printSuccess(fmt.Sprintf("Deleted %d credential%s", len(deleted), plural))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Delete credentials without asking for confirmation, except those of the current application")
+	return cmd
+}
+
+// dataPlaneCredential describes a data plane credential stored in the Vespa CLI home directory.
+type dataPlaneCredential struct {
+	Type string `json:"type"`
+	// Name is the name of a token
+	Name        string `json:"name,omitempty"`
+	Application string `json:"application,omitempty"`
+	// Paths holds the files of the credential
+	Paths     []string   `json:"paths"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Exists is whether the application exists in its tenant, if known
+	Exists *bool `json:"applicationExists,omitempty"`
+	// Current is whether this is used for the current application
+	Current bool `json:"current"`
+}
+
+// pruneReason returns why this credential can be pruned at time now, or the empty string if it cannot.
+func (c dataPlaneCredential) pruneReason(now time.Time) string {
+	if c.Type != credentialCertificate {
+		return ""
+	}
+	if c.ExpiresAt != nil && c.ExpiresAt.Before(now) {
+		return "expired"
+	}
+	if c.Exists != nil && !*c.Exists {
+		return "of an application which does not exist"
+	}
+	return ""
+}
+
+// remove deletes the files of this credential, and the application directory holding them, if it is left empty.
+func (c dataPlaneCredential) remove() error {
+	for _, path := range c.Paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if c.Type == credentialCertificate && len(c.Paths) > 0 {
+		os.Remove(filepath.Dir(c.Paths[0])) // Fails if the directory holds other files of the application
+	}
+	return nil
+}
+
+// readDataPlaneCredentials returns the certificates and tokens stored in the home directory of cli, sorted by type and
+// application. Whether their applications exist is checked with the control plane, if cli is authenticated with it.
+func readDataPlaneCredentials(cli *CLI) ([]dataPlaneCredential, error) {
+	var current string
+	if app, err := cli.config.application(); err == nil {
+		current = app.String()
+	}
+	currentToken, _ := cli.config.get(dataPlaneTokenOption)
+	entries, err := os.ReadDir(cli.config.homeDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	credentials := []dataPlaneCredential{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := vespa.ApplicationFromString(entry.Name()); err != nil {
+			continue
+		}
+		if c, ok := readCertificateCredential(filepath.Join(cli.config.homeDir, entry.Name())); ok {
+			c.Application = entry.Name()
+			c.Current = c.Application == current
+			credentials = append(credentials, c)
+		}
+	}
+	tokens, err := os.ReadDir(filepath.Join(cli.config.homeDir, dataPlaneTokensDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range tokens {
+		info, err := entry.Info()
+		if err != nil || !entry.Type().IsRegular() || !tokenName.MatchString(entry.Name()) {
+			continue
+		}
+		c := dataPlaneCredential{
+			Type:      credentialToken,
+			Name:      entry.Name(),
+			Paths:     []string{cli.config.dataPlaneTokenPath(entry.Name())},
+			CreatedAt: info.ModTime(),
+			Current:   entry.Name() == currentToken,
+		}
+		if c.Current {
+			c.Application = current
+		}
+		credentials = append(credentials, c)
+	}
+	sort.SliceStable(credentials, func(i, j int) bool {
+		if credentials[i].Type != credentials[j].Type {
+			return credentials[i].Type == credentialCertificate
+		}
+		return credentials[i].Application+credentials[i].Name < credentials[j].Application+credentials[j].Name
+	})
+	checkApplications(cli, credentials)
+	return credentials, nil
+}
+
+// readCertificateCredential returns the certificate and private key stored in appDir, if any.
+func readCertificateCredential(appDir string) (dataPlaneCredential, bool) {
+	c := dataPlaneCredential{Type: credentialCertificate}
+	var modTime time.Time
+	for _, name := range []string{"data-plane-public-cert.pem", "data-plane-private-key.pem"} {
+		path := filepath.Join(appDir, name)
+		if info, err := os.Stat(path); err == nil {
+			c.Paths = append(c.Paths, path)
+			if modTime.IsZero() || info.ModTime().Before(modTime) {
+				modTime = info.ModTime()
+			}
+		}
+	}
+	if len(c.Paths) == 0 {
+		return dataPlaneCredential{}, false
+	}
+	c.CreatedAt = modTime
+	data, err := os.ReadFile(filepath.Join(appDir, "data-plane-public-cert.pem"))
+	if err != nil {
+		return c, true
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			c.CreatedAt = cert.NotBefore
+			c.ExpiresAt = &cert.NotAfter
+		}
+	}
+	return c, true
+}
+
+// checkApplications sets whether the application of each credential exists, for the tenants cli is authenticated with.
+// A failure to check is reported as a warning, and leaves it unknown.
+func checkApplications(cli *CLI, credentials []dataPlaneCredential) {
+	system, err := cli.system(vespa.TargetCloud)
+	if err != nil {
+		return
+	}
+	exists := make(map[string]*bool)
+	for i, c := range credentials {
+		if c.Application == "" {
+			continue
+		}
+		app, err := vespa.ApplicationFromString(c.Application)
+		if err != nil {
+			continue
+		}
+		key := app.Tenant + "." + app.Application
+		if _, ok := exists[key]; !ok {
+			exists[key] = nil
+			if cli.controlPlaneAuthenticated(system, app.Tenant) {
+				exists[key], err = applicationExists(cli, app)
+				if err != nil {
+					cli.printWarning(fmt.Sprintf("Could not check whether %s exists: %s", key, err))
+				}
+			}
+		}
+		credentials[i].Exists = exists[key]
+	}
+}
+
+func applicationExists(cli *CLI, app vespa.ApplicationID) (*bool, error) {
+	target, err := cli.createCloudTarget(vespa.TargetCloud, targetOptions{application: app.String(), noCertificate: true}, "")
+	if err != nil {
+		return nil, err
+	}
+	exists, err := vespa.ApplicationExists(target)
+	if err != nil {
+		return nil, err
+	}
+	return &exists, nil
+}
+
+// controlPlaneAuthenticated returns whether requests to the control plane of system, for given tenant, are
+// authenticated with stored credentials, without logging in.
+func (c *CLI) controlPlaneAuthenticated(system vespa.System, tenant string) bool {
+	if c.config.authMethod(c) == authMethodAPIKey {
+		if _, ok := c.config.apiKeyFromEnv(); ok {
+			return true
+		}
+		if _, ok := c.config.apiKeyFileFromEnv(); ok {
+			return true
+		}
+		_, err := c.apiKeyFiles().ReadFile(c.config.apiKeyPath(tenant))
+		return err == nil
+	}
+	_, ok, err := auth0.ReadCredentials(c.config.authConfigPath(), system.Name, system.URL)
+	return err == nil && ok
+}
+
+func printDataPlaneCredentials(cli *CLI, credentials []dataPlaneCredential) error {
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tAPPLICATION\tCREATED\tEXPIRES\tSTATUS")
+	for _, c := range credentials {
+		name, application, expires := orDash(c.Name), orDash(c.Application), "-"
+		if c.Current {
+			application += " (current)"
+		}
+		if c.ExpiresAt != nil {
+			expires = formatExpiry(*c.ExpiresAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Type, name, application, c.CreatedAt.Local().Format(time.DateOnly), expires, c.status(cli.now()))
+	}
+	return w.Flush()
+}
+
+// status returns a description of the state of this credential at time now.
+func (c dataPlaneCredential) status(now time.Time) string {
+	switch {
+	case c.ExpiresAt != nil && c.ExpiresAt.Before(now):
+		return "expired"
+	case c.Exists != nil && !*c.Exists:
+		return "application deleted"
+	case c.Exists != nil:
+		return "ok"
+	case c.Application == "":
+		return "-"
+	}
+	return "unknown"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
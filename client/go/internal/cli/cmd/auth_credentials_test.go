@@ -0,0 +1,133 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func writeTestCertificate(t *testing.T, homeDir, app string, notBefore, notAfter time.Time) {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "cloud.vespa.example"}, NotBefore: notBefore, NotAfter: notAfter}
+	certificateDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	require.Nil(t, err)
+	privateKeyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.Nil(t, err)
+	appDir := filepath.Join(homeDir, app)
+	require.Nil(t, os.MkdirAll(appDir, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(appDir, "data-plane-public-cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateDER}), 0600))
+	require.Nil(t, os.WriteFile(filepath.Join(appDir, "data-plane-private-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyDER}), 0600))
+}
+
+func newCredentialsTestCLI(t *testing.T) (*CLI, *bytes.Buffer, *bytes.Buffer, *mock.HTTPClient) {
+	cli, stdout, stderr := newTestCLI(t)
+	homeDir := cli.config.homeDir
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeTestCertificate(t, homeDir, "t1.a1.default", created, created.Add(10*365*24*time.Hour))
+	writeTestCertificate(t, homeDir, "t1.current.default", created, created.Add(10*365*24*time.Hour))
+	writeTestCertificate(t, homeDir, "t1.expired.default", created, created.Add(24*time.Hour))
+	writeTestCertificate(t, homeDir, "t1.old.default", created, created.Add(10*365*24*time.Hour))
+	// Other files of an application are kept
+	require.Nil(t, os.WriteFile(filepath.Join(homeDir, "t1.old.default", "session_id"), []byte("1\n"), 0600))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.current"))
+	require.Nil(t, cli.config.writeDataPlaneToken("mytoken", "secret"))
+	require.Nil(t, cli.config.writeDataPlaneToken("other", "secret"))
+	require.Nil(t, cli.Run("config", "set", "data-plane-token", "mytoken"))
+	require.Nil(t, os.WriteFile(filepath.Join(homeDir, "auth.json"), []byte(`{"version":1,"providers":{"auth0":{"version":1,"systems":{"public":{"access_token":"opaque","expires_at":"2030-01-01T00:00:00Z"}}}}}`), 0600))
+	cli.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+	stdout.Reset()
+	stderr.Reset()
+
+	// Applications t1.a1, t1.current, t1.expired and t1.old are checked in order
+	client := cli.httpClient.(*mock.HTTPClient)
+	client.NextResponseString(200, `{}`)
+	client.NextResponseString(404, `{}`)
+	client.NextResponseString(200, `{}`)
+	client.NextResponseString(404, `{}`)
+	return cli, stdout, stderr, client
+}
+
+func TestAuthListCredentials(t *testing.T) {
+	cli, stdout, _, client := newCredentialsTestCLI(t)
+	require.Nil(t, cli.Run("auth", "list-credentials"))
+	require.Equal(t, 4, len(client.Requests))
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1", client.Requests[0].URL.String())
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/old", client.Requests[3].URL.String())
+	lines := bytes.Split(bytes.TrimSpace(stdout.Bytes()), []byte("\n"))
+	require.Equal(t, 7, len(lines))
+	assert.Regexp(t, `^TYPE +NAME +APPLICATION +CREATED +EXPIRES +STATUS$`, string(lines[0]))
+	assert.Regexp(t, `^certificate +- +t1\.a1\.default +2024-01-0[12] +2033-12-.* ok$`, string(lines[1]))
+	assert.Regexp(t, `^certificate +- +t1\.current\.default \(current\) +2024-01-0[12] +.* application deleted$`, string(lines[2]))
+	assert.Regexp(t, `^certificate +- +t1\.expired\.default +2024-01-0[12] +.*\(expired\) +expired$`, string(lines[3]))
+	assert.Regexp(t, `^certificate +- +t1\.old\.default +2024-01-0[12] +.* application deleted$`, string(lines[4]))
+	assert.Regexp(t, `^token +other +- +\d{4}-\d\d-\d\d +- +-$`, string(lines[5]))
+	assert.Regexp(t, `^token +mytoken +t1\.current\.default \(current\) +\d{4}-\d\d-\d\d +- +application deleted$`, string(lines[6]))
+
+	// Not authenticated with the control plane
+	require.Nil(t, os.Remove(filepath.Join(cli.config.homeDir, "auth.json")))
+	client.Requests = nil
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "list-credentials", "-o", "json"))
+	assert.Equal(t, 0, len(client.Requests))
+	var credentials []dataPlaneCredential
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &credentials))
+	require.Equal(t, 6, len(credentials))
+	assert.Equal(t, "t1.a1.default", credentials[0].Application)
+	assert.Nil(t, credentials[0].Exists)
+	assert.Equal(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), credentials[2].ExpiresAt.UTC())
+	assert.False(t, credentials[4].Current)
+	assert.True(t, credentials[5].Current)
+	assert.Equal(t, "mytoken", credentials[5].Name)
+}
+
+func TestAuthPrune(t *testing.T) {
+	cli, stdout, stderr, _ := newCredentialsTestCLI(t)
+	require.NotNil(t, cli.Run("auth", "prune"))
+	assert.Equal(t, "Error: terminal is not interactive\nHint: Use --force to delete the credentials without confirmation\n", stderr.String())
+
+	// The current application is kept without confirmation
+	cli, stdout, stderr, _ = newCredentialsTestCLI(t)
+	require.Nil(t, cli.Run("auth", "prune", "--force"))
+	assert.Equal(t, "Success: Deleted 2 credentials\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Warning: The certificate of t1.current.default is of an application which does not exist, but it is the certificate of the current application\n")
+	assert.Contains(t, stderr.String(), "Kept the certificate of t1.current.default\n")
+	homeDir := cli.config.homeDir
+	assert.FileExists(t, filepath.Join(homeDir, "t1.a1.default", "data-plane-public-cert.pem"))
+	assert.FileExists(t, filepath.Join(homeDir, "t1.current.default", "data-plane-public-cert.pem"))
+	assert.NoDirExists(t, filepath.Join(homeDir, "t1.expired.default"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "t1.old.default", "data-plane-public-cert.pem"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "t1.old.default", "data-plane-private-key.pem"))
+	assert.FileExists(t, filepath.Join(homeDir, "t1.old.default", "session_id"))
+	assert.FileExists(t, cli.config.dataPlaneTokenPath("mytoken"))
+	assert.FileExists(t, cli.config.dataPlaneTokenPath("other"))
+
+	// Each deletion is confirmed, and the current application by its name
+	cli, stdout, _, _ = newCredentialsTestCLI(t)
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = bytes.NewBufferString("t1.current.default\nn\ny\n")
+	require.Nil(t, cli.Run("auth", "prune"))
+	assert.Regexp(t, `Type .*t1\.current\.default.* to confirm: `, stdout.String())
+	assert.Regexp(t, `Delete the certificate of .*t1\.expired\.default.*, which is expired\? \[y/N\] `, stdout.String())
+	assert.Contains(t, stdout.String(), "Deleted 2 credentials\n")
+	homeDir = cli.config.homeDir
+	assert.NoDirExists(t, filepath.Join(homeDir, "t1.current.default"))
+	assert.DirExists(t, filepath.Join(homeDir, "t1.expired.default"))
+	assert.NoFileExists(t, filepath.Join(homeDir, "t1.old.default", "data-plane-public-cert.pem"))
+}
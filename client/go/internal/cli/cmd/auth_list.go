@@ -0,0 +1,96 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa auth list command
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+func newAuthListCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the systems with stored credentials",
+		Long: `List the systems with credentials stored by "auth login".
+
+Credentials are stored per system, keyed by its API URL, so that logging in to
+one system does not replace the login to another. The credentials matching the
+system of the current target are used automatically. The identity of each
+login and the expiry of its access token are shown. Access tokens are renewed
+automatically when they expire, for as long as the login is valid.
+`,
+		Example: `$ vespa auth list
+$ vespa auth list -o json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listCredentials(cli)
+		},
+	}
+}
+
+// storedCredentials describes the credentials stored for a system.
+type storedCredentials struct {
+	System    string    `json:"system"`
+	URL       string    `json:"url"`
+	Identity  string    `json:"identity,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// readStoredCredentials returns the credentials stored in the auth configuration of cli, sorted by system URL.
+func readStoredCredentials(cli *CLI) ([]storedCredentials, error) {
+	all, err := auth0.ListCredentials(cli.config.authConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]storedCredentials, 0, len(all))
+	for key, creds := range all {
+		stored := storedCredentials{System: creds.System, Identity: tokenSubject(creds.AccessToken), ExpiresAt: creds.ExpiresAt}
+		if strings.Contains(key, "://") {
+			stored.URL = key
+		} else {
+			stored.System = key // Stored by an older version of the CLI, keyed by system name
+		}
+		if system, err := vespa.GetSystem(key); err == nil {
+			stored.URL = system.URL
+			if stored.System == "" {
+				stored.System = system.Name
+			}
+		}
+		result = append(result, stored)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].URL < result[j].URL })
+	return result, nil
+}
+
+func listCredentials(cli *CLI) error {
+	stored, err := readStoredCredentials(cli)
+	if err != nil {
+		return err
+	}
+	if cli.jsonOutput() {
+		return cli.printResult(stored)
+	}
+	if len(stored) == 0 {
+		cli.printInfo("No credentials stored. Authenticate with 'vespa auth login'")
+		return nil
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SYSTEM\tURL\tIDENTITY\tEXPIRES")
+	for _, s := range stored {
+		identity := s.Identity
+		if identity == "" {
+			identity = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.System, s.URL, identity, formatExpiry(s.ExpiresAt))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,65 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthListAndLogout(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VESPA_CLI_DUMMY_KEYRING", "true")
+	cli, stdout, stderr := newTestCLI(t)
+
+	require.Nil(t, cli.Run("auth", "list"))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "No credentials stored. Authenticate with 'vespa auth login'\n", stderr.String())
+
+	token := "header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice@example.com"}`)) + ".signature"
+	expiresAt := time.Date(2022, 3, 1, 15, 45, 50, 0, time.UTC)
+	authConfig := fmt.Sprintf(`{"version":1,"providers":{"auth0":{"version":1,"systems":{
+"public":{"access_token":%q,"expires_at":"2022-03-01T15:45:50Z"},
+"https://api.internal.example.com:4443":{"access_token":"opaque","expires_at":"2022-03-01T15:45:50Z","system":"api.internal.example.com:4443"}}}}}`, token)
+	require.Nil(t, os.WriteFile(filepath.Join(cli.config.homeDir, "auth.json"), []byte(authConfig), 0600))
+	require.Nil(t, os.MkdirAll(filepath.Join(home, ".vespa"), 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(home, ".vespa", "keyring.vespa-cli.api.internal.example.com:4443"), []byte("rt"), 0600))
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "list"))
+	expiry := formatExpiry(expiresAt)
+	assert.Equal(t, ""+
+		"SYSTEM                         URL                                    IDENTITY           EXPIRES\n"+
+		"public                         https://api-ctl.vespa-cloud.com:4443   alice@example.com  "+expiry+"\n"+
+		"api.internal.example.com:4443  https://api.internal.example.com:4443  -                  "+expiry+"\n",
+		stdout.String())
+
+	// Logging out of one system keeps the credentials of the other
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "logout", "--system", "https://api.internal.example.com:4443"))
+	assert.Equal(t, "Success: Logged out\n", stdout.String())
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "list"))
+	assert.Equal(t, ""+
+		"SYSTEM  URL                                   IDENTITY           EXPIRES\n"+
+		"public  https://api-ctl.vespa-cloud.com:4443  alice@example.com  "+expiry+"\n",
+		stdout.String())
+	_, err := os.Stat(filepath.Join(home, ".vespa", "keyring.vespa-cli.api.internal.example.com:4443"))
+	assert.True(t, os.IsNotExist(err))
+
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "logout", "--system", "https://api.internal.example.com:4443"))
+	assert.Equal(t, "Error: not logged in to system https://api.internal.example.com:4443\n"+
+		"Hint: Run 'vespa auth list' to see the systems with stored credentials\n", stderr.String())
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "list", "-o", "json"))
+	assert.JSONEq(t, `[{"system": "public", "url": "https://api-ctl.vespa-cloud.com:4443", "identity": "alice@example.com", "expiresAt": "2022-03-01T15:45:50Z"}]`, stdout.String())
+}
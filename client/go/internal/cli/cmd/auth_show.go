@@ -8,29 +8,45 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
 )
 
 func newAuthShowCmd(cli *CLI) *cobra.Command {
+	var format string
 	cmd := &cobra.Command{
 		Use:   "show",
 		Short: "Show authenticated user",
-		Long: `Show which user (if any) is authenticated with "auth login"
+		Long: `Show which user (if any) is authenticated with "auth login".
+
+The user's email, the tenants the user is a member of and the user's roles in
+them are shown, together with the authentication method used for the current
+target. This is either an access token, as retrieved by "auth login", or an API
+key. Where the credentials were read from, e.g. a file or the environment, the
+expiry time of an access token, and the credential store holding API keys and
+refresh tokens, as set by "vespa config set credential-store", are also shown.
 `,
-		Example:           "$ vespa auth show",
+		Example: `$ vespa auth show
+$ vespa auth show --format json`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
 			if _, err := cli.config.application(); err != nil {
 				cmd.Flag("application").Value.Set("none.none")
 				cmd.Flag("application").Changed = true
 			}
-			return doAuthShow(cli, args)
+			return doAuthShow(cli, format)
 		},
 	}
+	cmd.Flags().StringVar(&format, "format", "human", "Output format. Must be 'human' (human-readable) or 'json'")
 	return cmd
 }
 
@@ -46,11 +62,43 @@ type userV1 struct {
 	} `json:"tenants"`
 }
 
-func doAuthShow(cli *CLI, args []string) error {
+// authShowResult is the JSON result of auth show.
+type authShowResult struct {
+	Email     string                  `json:"email"`
+	Tenants   map[string]tenantResult `json:"tenants"`
+	Method    string                  `json:"method"`
+	Source    string                  `json:"source"`
+	ExpiresAt *time.Time              `json:"expiresAt,omitempty"`
+	// CredentialStore is the name of the configured credential store
+	CredentialStore string `json:"credentialStore"`
+}
+
+type tenantResult struct {
+	Roles []string `json:"roles"`
+}
+
+func doAuthShow(cli *CLI, format string) error {
 	target, err := cli.target(targetOptions{supportedType: cloudTargetOnly})
 	if err != nil {
 		return err
 	}
+	system := target.Deployment().System
+	method := cli.config.authMethod(cli)
+	source := cli.config.authConfigPath()
+	if method == authMethodAPIKey {
+		source = cli.config.apiKeySource(target.Deployment().Application.Tenant)
+	}
+	var creds auth0.Credentials
+	if method == authMethodToken {
+		var ok bool
+		creds, ok, err = auth0.ReadCredentials(cli.config.authConfigPath(), system.Name, system.URL)
+		if err != nil {
+			return err
+		}
+		if !ok || creds.AccessToken == "" {
+			return errHint(fmt.Errorf("not logged in to system %s", system.Name), "Authenticate with 'vespa auth login'")
+		}
+	}
 	service, err := target.DeployService()
 	if err != nil {
 		return err
@@ -62,19 +110,57 @@ func doAuthShow(cli *CLI, args []string) error {
 	}
 	response, err := service.Do(req, time.Second*3)
 	if err != nil {
+		if method == authMethodToken && time.Now().After(creds.ExpiresAt) {
+			return errCode(codeAuthExpired, fmt.Errorf("access token expired at %s: %w", creds.ExpiresAt.Local().Format(time.RFC3339), err), "Run 'vespa auth login' to authenticate again")
+		}
 		return err
 	}
 	defer response.Body.Close()
+	if response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden {
+		return errHint(fmt.Errorf("not authorized: got status %d from %s", response.StatusCode, url), "Run 'vespa auth login' to authenticate again")
+	} else if response.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to get user: got status %d from %s", response.StatusCode, url)
+	}
 	dec := json.NewDecoder(response.Body)
 	var userResponse userV1
 	if err = dec.Decode(&userResponse); err != nil {
 		return err
 	}
+	if method == authMethodToken {
+		// The access token may have been renewed by the request
+		if renewed, ok, err := auth0.ReadCredentials(cli.config.authConfigPath(), system.Name, system.URL); err == nil && ok {
+			creds = renewed
+		}
+	}
+	if format == "json" {
+		result := authShowResult{Email: userResponse.User.Email, Tenants: make(map[string]tenantResult), Method: method, Source: source, CredentialStore: cli.config.credentialStore()}
+		if cli.config.isEnvSource(source) {
+			result.Source = "environment"
+		}
+		for tenant, data := range userResponse.Tenants {
+			result.Tenants[tenant] = tenantResult{Roles: data.Roles}
+		}
+		if method == authMethodToken {
+			result.ExpiresAt = &creds.ExpiresAt
+		}
+		return writeJSON(cli, result)
+	}
 	var output bytes.Buffer
 	fmt.Fprintf(&output, "Logged in as: %s", userResponse.User.Email)
-	for tenant, data := range userResponse.Tenants {
+	if method == authMethodToken {
+		fmt.Fprintf(&output, "\nAuthenticated with: access token %s, expires %s", cli.config.describeSource(source), formatExpiry(creds.ExpiresAt))
+	} else {
+		fmt.Fprintf(&output, "\nAuthenticated with: API key %s", cli.describeAPIKeySource(target.Deployment().Application.Tenant))
+	}
+	fmt.Fprintf(&output, "\nCredential store: %s", cli.config.credentialStoreDescription())
+	tenants := make([]string, 0, len(userResponse.Tenants))
+	for tenant := range userResponse.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	for _, tenant := range tenants {
 		fmt.Fprintf(&output, "\nAvailable tenant: %s", tenant)
-		for idx, role := range data.Roles {
+		for idx, role := range userResponse.Tenants[tenant].Roles {
 			if idx == 0 {
 				fmt.Fprintf(&output, "\n    your roles:")
 			}
@@ -84,3 +170,11 @@ func doAuthShow(cli *CLI, args []string) error {
 	cli.printSuccess(output.String())
 	return nil
 }
+
+func formatExpiry(t time.Time) string {
+	remaining := time.Until(t).Round(time.Minute)
+	if remaining <= 0 {
+		return fmt.Sprintf("%s (expired)", t.Local().Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s (in %s)", t.Local().Format(time.RFC3339), remaining)
+}
@@ -4,10 +4,21 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 func TestAuthShow(t *testing.T) {
@@ -35,5 +46,79 @@ func testAuthShow(t *testing.T, subcommand []string) {
 	err = cli.Run(subcommand...)
 	assert.Nil(t, err)
 	assert.Contains(t, stderr.String(), "Authenticating with API key")
-	assert.Contains(t, stdout.String(), "Logged in as: foo@bar\n")
+	assert.Contains(t, stdout.String(), "Logged in as: foo@bar\nAuthenticated with: API key from '"+cli.config.apiKeyPath("t1")+"'\nCredential store: file\n")
+}
+
+type failingAuthenticator struct{}
+
+func (a *failingAuthenticator) Authenticate(request *http.Request) error {
+	return errors.New("failed to renew access token")
+}
+
+func TestAuthShowToken(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t)
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1"))
+
+	// Logged out
+	require.Nil(t, os.MkdirAll(cli.config.homeDir, 0700))
+	require.Nil(t, os.WriteFile(filepath.Join(cli.config.homeDir, "auth.json"), []byte(`{"version":1,"providers":{"auth0":{"version":1,"systems":{}}}}`), 0600))
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "show"))
+	assert.Equal(t, "Error: not logged in to system public\nHint: Authenticate with 'vespa auth login'\n", stderr.String())
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeAuthConfig(t, cli, expiresAt)
+	userResponse := `{"user":{"email":"foo@bar"},"tenants":{"t2":{"roles":["developer"]},"t1":{"roles":["administrator","developer"]}}}`
+	httpClient := &mock.HTTPClient{}
+	httpClient.NextResponseString(200, userResponse)
+	cli.httpClient = httpClient
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("auth", "show"))
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "Success: Logged in as: foo@bar\n"+
+		"Authenticated with: access token from '"+cli.config.authConfigPath()+"', expires "+expiresAt.Format(time.RFC3339)+" (in 1h0m0s)\n"+
+		"Credential store: file\n"+
+		"Available tenant: t1\n"+
+		"    your roles: administrator developer\n"+
+		"Available tenant: t2\n"+
+		"    your roles: developer\n", stdout.String())
+
+	httpClient.NextResponseString(200, userResponse)
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "show", "--format", "json"))
+	var result authShowResult
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.Equal(t, "foo@bar", result.Email)
+	assert.Equal(t, "token", result.Method)
+	assert.Equal(t, "file", result.CredentialStore)
+	assert.Equal(t, []string{"administrator", "developer"}, result.Tenants["t1"].Roles)
+	require.NotNil(t, result.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*result.ExpiresAt))
+
+	// Rejected token
+	httpClient.NextResponseString(403, `{"error-code":"FORBIDDEN"}`)
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "show", "--format", "human"))
+	assert.Equal(t, "Error: not authorized: got status 403 from https://api-ctl.vespa-cloud.com:4443/user/v1/user\n"+
+		"Hint: Run 'vespa auth login' to authenticate again\n", stderr.String())
+
+	// Expired token which cannot be renewed
+	expiredAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeAuthConfig(t, cli, expiredAt)
+	cli.auth0Factory = func(httpClient httputil.Client, options auth0.Options) (vespa.Authenticator, error) {
+		return &failingAuthenticator{}, nil
+	}
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "show"))
+	assert.Equal(t, fmt.Sprintf("Error: access token expired at %s: auth failed: failed to renew access token [AUTH_EXPIRED]\n", expiredAt.Format(time.RFC3339))+
+		"Hint: Run 'vespa auth login' to authenticate again\n"+
+		"Hint: Authenticated with access token from '"+cli.config.authConfigPath()+"'\n", stderr.String())
+}
+
+func writeAuthConfig(t *testing.T, cli *CLI, expiresAt time.Time) {
+	authConfig := fmt.Sprintf(`{"version":1,"providers":{"auth0":{"version":1,"systems":{"public":{"access_token":"secret","expires_at":%q}}}}}`,
+		expiresAt.Format(time.RFC3339))
+	require.Nil(t, os.WriteFile(filepath.Join(cli.config.homeDir, "auth.json"), []byte(authConfig), 0600))
 }
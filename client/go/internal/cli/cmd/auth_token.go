@@ -0,0 +1,84 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func newAuthTokenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "token",
+		Short: "Manage data plane tokens",
+		Long: `Manage data plane tokens.
+
+Data plane tokens authenticate requests to the token endpoints of an
+application deployed to Vespa Cloud, as an alternative to the data plane
+certificate. Tokens are created in the Vespa Cloud console, and granted access
+to an application by the clients element of its services.xml.`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      false,
+		Args:              cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("invalid command: %s", args[0])
+		},
+	}
+}
+
+func newAuthTokenSetCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "set name",
+		Short: "Store a data plane token",
+		Long: `Store a data plane token.
+
+The token is read from standard input, and stored under the given name in the
+Vespa CLI home directory, in a file readable only by the current user. The
+data-plane-token option is set to name, so that the token is used whenever
+token authentication is selected, with --auth token or the data-plane-auth
+option. The VESPA_CLI_DATA_PLANE_TOKEN environment variable takes precedence
+over the stored token.
+
+Storing a token under an existing name replaces the token.`,
+		Example: `$ vespa auth token set mytoken < token.txt
+$ vespa config set data-plane-auth token`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if !tokenName.MatchString(name) {
+				return fmt.Errorf("invalid token name: %q: must consist of letters, digits, '-' and '_'", name)
+			}
+			if cli.isTerminal() {
+				fmt.Fprint(cli.Stderr, "Enter data plane token: ")
+			}
+			token, err := bufio.NewReader(cli.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			token = strings.TrimSpace(token)
+			if token == "" {
+				return errHint(fmt.Errorf("no token given"), "Give the token on standard input, e.g. 'vespa auth token set "+name+" < token.txt'")
+			}
+			if err := cli.config.writeDataPlaneToken(name, token); err != nil {
+				return fmt.Errorf("could not store token: %w", err)
+			}
+			if err := cli.config.set(dataPlaneTokenOption, name); err != nil {
+				return err
+			}
+			if err := cli.config.write(); err != nil {
+				return err
+			}
+			cli.printSuccess("Stored data plane token ", color.CyanString(name), " in ", color.CyanString(cli.config.dataPlaneTokenPath(name)))
+			if cli.selectAuthMethod() != "token" {
+				cli.printInfo("Use the token with 'vespa config set data-plane-auth token', or --auth token")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestAuthTokenSet(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.Stdin = bytes.NewBufferString("secret-token\n")
+	require.Nil(t, cli.Run("auth", "token", "set", "mytoken"))
+	path := filepath.Join(cli.config.homeDir, "data-plane-tokens", "mytoken")
+	assert.Equal(t, "Success: Stored data plane token mytoken in "+path+"\n", stdout.String())
+	assert.Equal(t, "Use the token with 'vespa config set data-plane-auth token', or --auth token\n", stderr.String())
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, "secret-token\n", string(data))
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	token, ok := cli.config.get(dataPlaneTokenOption)
+	assert.True(t, ok)
+	assert.Equal(t, "mytoken", token)
+
+	stderr.Reset()
+	cli.Stdin = bytes.NewBufferString("")
+	assert.NotNil(t, cli.Run("auth", "token", "set", "mytoken"))
+	assert.Equal(t, "Error: no token given\nHint: Give the token on standard input, e.g. 'vespa auth token set mytoken < token.txt'\n", stderr.String())
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("config", "set", "data-plane-token", "other"))
+	assert.Equal(t, "Error: no data plane token named other is stored\nHint: Store it with 'vespa auth token set other'\n", stderr.String())
+}
+
+func TestQueryTokenAuth(t *testing.T) {
+	cli, _, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "zone", "dev.us-north-1"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+	require.Nil(t, cli.Run("config", "set", "endpoint-cache-ttl", "0"))
+	client := &mock.HTTPClient{}
+	cli.httpClient = client
+	// Without the endpoint cache, each query discovers the endpoints of the deployment, and chooses the one matching the
+	// authentication method
+	nextEndpoints := func() {
+		client.NextResponse(mock.HTTPResponse{
+			URI:    "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1",
+			Status: 200,
+			Body: []byte(`{"endpoints": [
+  {"cluster": "search", "url": "https://mtls.example.com", "scope": "zone", "authMethod": "mtls"},
+  {"cluster": "search", "url": "https://token.example.com", "scope": "zone", "authMethod": "token"}
+]}`),
+		})
+	}
+	nextQuery := func(status int, body string) {
+		nextEndpoints()
+		client.NextResponseString(status, body)
+	}
+
+	// Token authentication requires a token
+	require.Nil(t, cli.Run("config", "set", "data-plane-auth", "token"))
+	stderr.Reset()
+	nextEndpoints()
+	assert.NotNil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, `Error: token authentication is selected, but no data plane token is set
+Hint: Store a token with 'vespa auth token set <name>'
+Hint: Or set the VESPA_CLI_DATA_PLANE_TOKEN environment variable
+`, stderr.String())
+
+	// Stored token is sent to the token endpoint, and no certificate is required
+	cli.Stdin = bytes.NewBufferString("secret-token")
+	require.Nil(t, cli.Run("auth", "token", "set", "mytoken"))
+	nextQuery(200, "{}")
+	require.Nil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, "token.example.com", client.LastRequest.URL.Host)
+	assert.Equal(t, "Bearer secret-token", client.LastRequest.Header.Get("Authorization"))
+
+	// Environment variable takes precedence over the stored token
+	cli.Environment["VESPA_CLI_DATA_PLANE_TOKEN"] = "env-token"
+	nextQuery(200, "{}")
+	require.Nil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, "Bearer env-token", client.LastRequest.Header.Get("Authorization"))
+	delete(cli.Environment, "VESPA_CLI_DATA_PLANE_TOKEN")
+
+	// Rejected requests name the authentication method
+	stderr.Reset()
+	nextQuery(401, `{"message":"unauthorized"}`)
+	assert.NotNil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, `Error: query rejected using token authentication: Status 401 [AUTH_FAILED]
+{
+    "message": "unauthorized"
+}
+Hint: Check that the token is valid, and is allowed to access this application
+Hint: The token was read from the stored token mytoken
+Hint: Use --auth cert to authenticate with the data plane certificate instead
+`, stderr.String())
+
+	// Certificate authentication is chosen per command
+	nextQuery(200, "{}")
+	require.Nil(t, cli.Run("query", "--auth", "cert", "select * from music"))
+	assert.Equal(t, "mtls.example.com", client.LastRequest.URL.Host)
+	assert.Equal(t, "", client.LastRequest.Header.Get("Authorization"))
+
+	// Invalid and unsupported uses of the flag
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("query", "--auth", "foo", "select * from music"))
+	assert.Equal(t, "Error: invalid value for --auth: \"foo\"\nHint: Must be \"cert\" or \"token\"\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("config", "get", "--auth", "token"))
+	assert.Equal(t, "Error: --auth is not supported by vespa config get\nHint: Supported commands are document, feed, query, visit, curl and status auth\n", stderr.String())
+}
+
+func TestDocumentTokenAuth(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, _, stderr := newTestCLI(t, "VESPA_CLI_DATA_PLANE_TOKEN=secret-token")
+	cli.httpClient = client
+
+	client.NextResponseString(403, "Forbidden")
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "get", "id:mynamespace:music::a-head-full-of-dreams"))
+	assert.Equal(t, "Bearer secret-token", client.LastRequest.Header.Get("Authorization"))
+	assert.Equal(t, "Error: Document operation rejected using token authentication: Status 403\nForbidden\n", stderr.String())
+
+	// Token is not sent when certificate authentication is chosen
+	client.NextResponseString(200, "{}")
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "get", "--auth", "cert", "id:mynamespace:music::a-head-full-of-dreams"))
+	assert.Equal(t, "", client.LastRequest.Header.Get("Authorization"))
+}
@@ -4,9 +4,15 @@
 package cmd
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -27,7 +33,9 @@ func newCertCmd(cli *CLI) *cobra.Command {
 The private key and certificate will be stored in the Vespa CLI home directory
 (see 'vespa help config'). Other commands will then automatically load the
 certificate as necessary. The certificate will be added to your application
-package specified as an argument to this command (default '.').
+package specified as an argument to this command (default '.'). Other
+certificates in security/clients.pem are kept, and with -f, the certificate
+replaces only the one it overwrites.
 
 It's possible to override the private key and certificate used through
 environment variables. This can be useful in continuous integration systems.
@@ -58,6 +66,9 @@ Note that when overriding key pair through environment variables, that key pair
 will always be used for all applications. It's not possible to specify an
 application-specific key.
 
+A key pair set in-line is used directly, without being written to any file.
+'vespa status' shows when the certificate is read from the environment.
+
 See https://docs.vespa.ai/en/cloud/security/guide.html for more details.`,
 		Example: `$ vespa auth cert
 $ vespa auth cert -a my-tenant.my-app.my-instance
@@ -77,7 +88,11 @@ $ vespa auth cert -a my-tenant.my-app.my-instance path/to/application/package`,
 }
 
 func newCertAddCmd(cli *CLI) *cobra.Command {
-	var overwriteCertificate bool
+	var (
+		overwriteCertificate bool
+		certificateFile      string
+		privateKeyFile       string
+	)
 	cmd := &cobra.Command{
 		Use:   "add",
 		Short: "Add certificate to application package",
@@ -86,18 +101,29 @@ func newCertAddCmd(cli *CLI) *cobra.Command {
 The certificate will be loaded from the Vespa CLI home directory (see 'vespa
 help config') by default.
 
+A certificate issued by another certificate authority, e.g. an internal CA, can
+be imported with --certificate and --key. The key pair is verified to match and
+the certificate to be valid, before both are copied to the Vespa CLI home
+directory and the certificate is added to the application package.
+
 The location of the application package can be specified as an argument to this
 command (default '.').`,
 		Example: `$ vespa auth cert add -a my-tenant.my-app.my-instance
-$ vespa auth cert add -a my-tenant.my-app.my-instance path/to/application/package`,
+$ vespa auth cert add -a my-tenant.my-app.my-instance path/to/application/package
+$ vespa auth cert add -a my-tenant.my-app.my-instance --certificate cert.pem --key key.pem`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return doCertAdd(cli, overwriteCertificate, args)
+			if certificateFile != "" || privateKeyFile != "" {
+				return doCertImport(cli, certificateFile, privateKeyFile, overwriteCertificate, args)
+			}
+			return doCertAdd(cli, overwriteCertificate, nil, args)
 		},
 	}
 	cmd.Flags().BoolVarP(&overwriteCertificate, "force", "f", false, "Force overwrite of existing certificate")
+	cmd.Flags().StringVar(&certificateFile, "certificate", "", "Import the certificate in this file, issued by another certificate authority")
+	cmd.Flags().StringVar(&privateKeyFile, "key", "", "Import the private key of the imported certificate from this file")
 	cmd.MarkPersistentFlagRequired(applicationFlag)
 	return cmd
 }
@@ -129,26 +155,116 @@ func doCert(cli *CLI, overwriteCertificate, skipApplicationPackage bool, args []
 			return errHint(fmt.Errorf("certificate '%s' already exists", color.CyanString(certificateFile.path)), hint)
 		}
 	}
+	previous := previousCertificate(certificateFile.path, overwriteCertificate)
 
-	keyPair, err := vespa.CreateKeyPair()
-	if err != nil {
+	var keyPair vespa.PemKeyPair
+	if err := cli.step("Creating key pair", func() error {
+		keyPair, err = vespa.CreateKeyPair()
+		return err
+	}); err != nil {
 		return err
 	}
-	if err := keyPair.WriteCertificateFile(certificateFile.path, overwriteCertificate); err != nil {
-		return fmt.Errorf("could not write certificate: %w", err)
-	}
-	if err := keyPair.WritePrivateKeyFile(privateKeyFile.path, overwriteCertificate); err != nil {
-		return fmt.Errorf("could not write private key: %w", err)
+	if err := cli.step("Writing certificate and private key", func() error {
+		if err := keyPair.WriteCertificateFile(certificateFile.path, overwriteCertificate); err != nil {
+			return fmt.Errorf("could not write certificate: %w", err)
+		}
+		if err := keyPair.WritePrivateKeyFile(privateKeyFile.path, overwriteCertificate); err != nil {
+			return fmt.Errorf("could not write private key: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 	cli.printSuccess("Certificate written to ", color.CyanString("'"+certificateFile.path+"'"))
 	cli.printSuccess("Private key written to ", color.CyanString("'"+privateKeyFile.path+"'"))
 	if !skipApplicationPackage {
-		return doCertAdd(cli, overwriteCertificate, args)
+		return cli.step("Adding certificate to application package", func() error {
+			return doCertAdd(cli, overwriteCertificate, previous, args)
+		})
 	}
 	return nil
 }
 
-func doCertAdd(cli *CLI, overwriteCertificate bool, args []string) error {
+// previousCertificate returns the certificate in path which is about to be overwritten, if overwrite is true, such that
+// it can be replaced by the new one in the application package.
+func previousCertificate(path string, overwrite bool) []byte {
+	if !overwrite {
+		return nil
+	}
+	data, _ := os.ReadFile(path)
+	return data
+}
+
+func doCertImport(cli *CLI, certificateFile, privateKeyFile string, overwriteCertificate bool, args []string) error {
+	if certificateFile == "" || privateKeyFile == "" {
+		return errHint(fmt.Errorf("both certificate and private key must be given"), "Use --certificate and --key together")
+	}
+	targetType, err := cli.targetType(cloudTargetOnly)
+	if err != nil {
+		return err
+	}
+	app, err := cli.config.application()
+	if err != nil {
+		return err
+	}
+	var keyPair vespa.PemKeyPair
+	if keyPair.Certificate, err = os.ReadFile(certificateFile); err != nil {
+		return fmt.Errorf("could not read certificate: %w", err)
+	}
+	if keyPair.PrivateKey, err = os.ReadFile(privateKeyFile); err != nil {
+		return fmt.Errorf("could not read private key: %w", err)
+	}
+	certificate, err := vespa.ValidateKeyPair(keyPair, cli.now())
+	if err != nil {
+		return errHint(fmt.Errorf("cannot import certificate '%s': %w", certificateFile, err), "The certificate must be valid and match the private key in '"+privateKeyFile+"'")
+	}
+	privateKeyPath, err := cli.config.privateKeyPath(app, targetType.name)
+	if err != nil {
+		return err
+	}
+	certificatePath, err := cli.config.certificatePath(app, targetType.name)
+	if err != nil {
+		return err
+	}
+	pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{})
+	if err != nil {
+		return err
+	}
+	if !overwriteCertificate {
+		hint := "Use -f flag to force overwriting"
+		if ioutil.Exists(privateKeyPath.path) {
+			return errHint(fmt.Errorf("private key '%s' already exists", color.CyanString(privateKeyPath.path)), hint)
+		}
+		if ioutil.Exists(certificatePath.path) {
+			return errHint(fmt.Errorf("certificate '%s' already exists", color.CyanString(certificatePath.path)), hint)
+		}
+	}
+	if pkg.IsZip() {
+		return errHint(fmt.Errorf("cannot add certificate to compressed application package: '%s'", pkg.Path), "Try running 'mvn clean', then 'vespa auth cert add' and finally 'mvn package'")
+	}
+	previous := previousCertificate(certificatePath.path, overwriteCertificate)
+	if err := keyPair.WriteCertificateFile(certificatePath.path, overwriteCertificate); err != nil {
+		return fmt.Errorf("could not write certificate: %w", err)
+	}
+	if err := keyPair.WritePrivateKeyFile(privateKeyPath.path, overwriteCertificate); err != nil {
+		return fmt.Errorf("could not write private key: %w", err)
+	}
+	cli.printSuccess("Certificate imported to ", color.CyanString("'"+certificatePath.path+"'"))
+	cli.printSuccess("Private key imported to ", color.CyanString("'"+privateKeyPath.path+"'"))
+	log.Printf("Certificate fingerprint (SHA-256): %s", color.CyanString(vespa.FingerprintSHA256(certificate)))
+	log.Printf("Certificate expires at: %s", color.CyanString(certificate.NotAfter.Format(time.RFC3339)))
+	return addCertificate(cli, overwriteCertificate, false, previous, args)
+}
+
+func doCertAdd(cli *CLI, overwriteCertificate bool, previous []byte, args []string) error {
+	return addCertificate(cli, overwriteCertificate, true, previous, args)
+}
+
+// addCertificate adds the certificate of the current application to the application package in args. If replace is
+// true, the certificate replaces previous, and any copy of itself, in the package, keeping the other certificates.
+// Otherwise the certificate is appended to the existing ones, and failOnDuplicate decides whether a certificate already
+// present in the package is an error.
+func addCertificate(cli *CLI, replace, failOnDuplicate bool, previous []byte, args []string) error {
 	target, err := cli.target(targetOptions{supportedType: cloudTargetOnly})
 	if err != nil {
 		return err
@@ -157,10 +273,26 @@ func doCertAdd(cli *CLI, overwriteCertificate bool, args []string) error {
 	if err != nil {
 		return err
 	}
-	if pkg.HasCertificate() && !overwriteCertificate {
-		return errHint(fmt.Errorf("application package '%s' already contains a certificate", pkg.Path), "Use -f flag to force overwriting")
+	if pkg.IsZip() {
+		hint := "Try running 'mvn clean', then 'vespa auth cert add' and finally 'mvn package'"
+		return errHint(fmt.Errorf("cannot add certificate to compressed application package: '%s'", pkg.Path), hint)
+	}
+	tlsOptions, err := cli.config.readTLSOptions(target.Deployment().Application, target.Type())
+	if err != nil {
+		return err
+	}
+	added, err := copyCertificate(tlsOptions, cli, pkg, replace, previous)
+	if err != nil {
+		return err
+	}
+	if !added {
+		msg := fmt.Sprintf("application package '%s' already contains this certificate", pkg.Path)
+		if failOnDuplicate {
+			return errHint(fmt.Errorf("%s", msg), "Use 'vespa auth cert list' to show the certificates in the application package")
+		}
+		cli.printInfo("Not copying certificate: ", msg)
 	}
-	return requireCertificate(true, false, cli, target, pkg)
+	return nil
 }
 
 func requireCertificate(force, ignoreZip bool, cli *CLI, target vespa.Target, pkg vespa.ApplicationPackage) error {
@@ -180,7 +312,8 @@ func requireCertificate(force, ignoreZip bool, cli *CLI, target vespa.Target, pk
 		return err
 	}
 	if force {
-		return copyCertificate(tlsOptions, cli, pkg)
+		_, err := copyCertificate(tlsOptions, cli, pkg, false, nil)
+		return err
 	}
 	if pkg.HasCertificate() {
 		if cli.isCI() {
@@ -213,7 +346,8 @@ func requireCertificate(force, ignoreZip bool, cli *CLI, target vespa.Target, pk
 			return err
 		}
 		if ok {
-			return copyCertificate(tlsOptions, cli, pkg)
+			_, err := copyCertificate(tlsOptions, cli, pkg, false, nil)
+			return err
 		}
 	}
 	return errHint(fmt.Errorf("deployment to Vespa Cloud requires certificate in application package"),
@@ -221,18 +355,233 @@ func requireCertificate(force, ignoreZip bool, cli *CLI, target vespa.Target, pk
 		"Pass --add-cert to use the certificate of the current application")
 }
 
-func copyCertificate(tlsOptions vespa.TLSOptions, cli *CLI, pkg vespa.ApplicationPackage) error {
-	data, err := os.ReadFile(tlsOptions.CertificateFile)
-	if err != nil {
-		return errHint(fmt.Errorf("could not read certificate file: %w", err))
+// copyCertificate copies the certificate in tlsOptions to security/clients.pem of pkg. If replace is true, the
+// certificate replaces the existing file. Otherwise it is appended, unless already present. It returns whether the
+// certificate was copied.
+func copyCertificate(tlsOptions vespa.TLSOptions, cli *CLI, pkg vespa.ApplicationPackage, replace bool, previous []byte) (bool, error) {
+	data := tlsOptions.CertificatePEM
+	if len(data) == 0 {
+		var err error
+		if data, err = os.ReadFile(tlsOptions.CertificateFile); err != nil {
+			return false, errHint(fmt.Errorf("could not read certificate file: %w", err))
+		}
 	}
 	dstPath := filepath.Join(pkg.Path, "security", "clients.pem")
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-		return fmt.Errorf("could not create security directory: %w", err)
+	if replace {
+		if err := pkg.ReplaceClientCertificate(data, previous); err != nil {
+			return false, err
+		}
+	} else {
+		added, err := pkg.AddClientCertificate(data)
+		if err != nil || !added {
+			return false, err
+		}
+	}
+	cli.printSuccess("Copied certificate ", cli.config.describeSource(tlsOptions.CertificateFile), " to '", dstPath, "'")
+	return true, nil
+}
+
+func newCertListCmd(cli *CLI) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "list [application-directory]",
+		Short: "List certificates in application package",
+		Long: `List the client certificates in security/clients.pem of your application package.
+
+The SHA-256 fingerprint, expiry time and subject of each certificate is shown.
+The fingerprint can be passed to 'vespa auth cert remove'.
+
+The location of the application package can be specified as an argument to this
+command (default '.').`,
+		Example: `$ vespa auth cert list
+$ vespa auth cert list path/to/application/package
+$ vespa auth cert list --format json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			return doCertList(cli, format, args)
+		},
 	}
-	err = ioutil.AtomicWriteFile(dstPath, data)
-	if err == nil {
-		cli.printSuccess("Copied certificate from '", tlsOptions.CertificateFile, "' to '", dstPath, "'")
+	cmd.Flags().StringVar(&format, "format", "human", "Output format. Must be 'human' (human-readable) or 'json'")
+	return cmd
+}
+
+func newCertRemoveCmd(cli *CLI) *cobra.Command {
+	var fingerprint string
+	cmd := &cobra.Command{
+		Use:   "remove [application-directory]",
+		Short: "Remove certificate from application package",
+		Long: `Remove a client certificate from security/clients.pem of your application package.
+
+The certificate to remove is identified by its SHA-256 fingerprint, as shown by
+'vespa auth cert list'. The fingerprint is matched ignoring case and colons.
+
+The location of the application package can be specified as an argument to this
+command (default '.').`,
+		Example: `$ vespa auth cert remove --fingerprint 3A:5F:...:C2
+$ vespa auth cert remove --fingerprint 3A:5F:...:C2 path/to/application/package`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fingerprint == "" {
+				return errHint(fmt.Errorf("no fingerprint given"), "Specify the certificate to remove with --fingerprint", "Use 'vespa auth cert list' to show the certificates in the application package")
+			}
+			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{})
+			if err != nil {
+				return err
+			}
+			if err := pkg.RemoveClientCertificate(fingerprint); err != nil {
+				return errHint(err, "Use 'vespa auth cert list' to show the certificates in the application package")
+			}
+			cli.printSuccess("Removed certificate ", color.CyanString(fingerprint), " from '", filepath.Join(pkg.Path, "security", "clients.pem"), "'")
+			return nil
+		},
 	}
-	return err
+	cmd.Flags().StringVar(&fingerprint, "fingerprint", "", "SHA-256 fingerprint of the certificate to remove")
+	return cmd
+}
+
+func newCertInfoCmd(cli *CLI) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the data plane certificate of the current application",
+		Long: `Show the data plane certificate of the current application.
+
+This prints the subject, SHA-256 fingerprint and validity period of the
+certificate Vespa CLI uses to authenticate with the data plane of your
+application, and where the certificate is read from.
+
+Vespa CLI warns when connecting to an application whose certificate expires
+soon. The number of days before expiry to start warning can be set with 'vespa
+config set cert-warning-days'.`,
+		Example: `$ vespa auth cert info
+$ vespa auth cert info -a my-tenant.my-app.my-instance`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			return doCertInfo(cli, format)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "human", "Output format. Must be 'human' (human-readable) or 'json'")
+	return cmd
+}
+
+type certInfoResult struct {
+	Subject     string `json:"subject"`
+	Issuer      string `json:"issuer"`
+	Fingerprint string `json:"fingerprint"`
+	NotBefore   string `json:"notBefore"`
+	NotAfter    string `json:"notAfter"`
+	Expired     bool   `json:"expired"`
+	Source      string `json:"source"`
+}
+
+func doCertInfo(cli *CLI, format string) error {
+	targetType, err := cli.targetType(anyTarget)
+	if err != nil {
+		return err
+	}
+	app := vespa.DefaultApplication
+	if targetType.name == vespa.TargetCloud || targetType.name == vespa.TargetHosted {
+		if app, err = cli.config.application(); err != nil {
+			return err
+		}
+	}
+	certificatePath, err := cli.config.certificatePath(app, targetType.name)
+	if err != nil {
+		return err
+	}
+	certificatePEM, source, err := cli.config.credentialsPEM("VESPA_CLI_DATA_PLANE_CERT", certificatePath)
+	if err != nil {
+		return err
+	}
+	if len(certificatePEM) == 0 {
+		return errHint(fmt.Errorf("no certificate exists for %s", app.String()), "Try creating a certificate with 'vespa auth cert'")
+	}
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil {
+		return fmt.Errorf("invalid certificate %s: no pem data found", cli.config.describeSource(source))
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("invalid certificate %s: %w", cli.config.describeSource(source), err)
+	}
+	result := certInfoResult{
+		Subject:     certificate.Subject.String(),
+		Issuer:      certificate.Issuer.String(),
+		Fingerprint: vespa.FingerprintSHA256(certificate),
+		NotBefore:   certificate.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:    certificate.NotAfter.UTC().Format(time.RFC3339),
+		Expired:     cli.now().After(certificate.NotAfter),
+		Source:      source,
+	}
+	if cli.config.isEnvSource(source) {
+		result.Source = "environment"
+	}
+	if format == "json" {
+		return writeJSON(cli, result)
+	}
+	validity := "expires in " + strconv.Itoa(int(certificate.NotAfter.Sub(cli.now()).Hours()/24)) + " days"
+	if result.Expired {
+		validity = color.RedString("expired")
+	}
+	log.Printf("Subject: %s", color.CyanString(result.Subject))
+	log.Printf("Issuer: %s", color.CyanString(result.Issuer))
+	log.Printf("Fingerprint (SHA-256): %s", color.CyanString(result.Fingerprint))
+	log.Printf("Valid from: %s", color.CyanString(result.NotBefore))
+	log.Printf("Valid until: %s (%s)", color.CyanString(result.NotAfter), validity)
+	log.Printf("Source: %s", strings.TrimPrefix(cli.config.describeSource(source), "from "))
+	return nil
+}
+
+type certListResult struct {
+	Fingerprint string `json:"fingerprint"`
+	Subject     string `json:"subject"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+func doCertList(cli *CLI, format string, args []string) error {
+	pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{})
+	if err != nil {
+		return err
+	}
+	certificates, err := pkg.ClientCertificates()
+	if err != nil {
+		return err
+	}
+	result := make([]certListResult, 0, len(certificates))
+	for _, c := range certificates {
+		result = append(result, certListResult{
+			Fingerprint: vespa.FingerprintSHA256(c),
+			Subject:     c.Subject.CommonName,
+			ExpiresAt:   c.NotAfter.UTC().Format(time.RFC3339),
+		})
+	}
+	if format == "json" {
+		return writeJSON(cli, result)
+	}
+	if len(result) == 0 {
+		cli.printInfo("No certificates found in ", filepath.Join(pkg.Path, "security", "clients.pem"))
+		return nil
+	}
+	for i, r := range result {
+		expiry := r.ExpiresAt
+		if cli.now().After(certificates[i].NotAfter) {
+			expiry += " (expired)"
+		}
+		log.Printf("%s %s %s", color.CyanString(r.Fingerprint), expiry, r.Subject)
+	}
+	return nil
 }
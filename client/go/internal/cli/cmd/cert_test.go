@@ -4,14 +4,20 @@
 package cmd
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
@@ -64,7 +70,8 @@ func TestCertCompressedPackage(t *testing.T) {
 
 	err = cli.Run("auth", "cert", zipFile)
 	assert.NotNil(t, err)
-	assert.Contains(t, stderr.String(), "Error: cannot add certificate to compressed application package")
+	assert.Contains(t, stderr.String(), "Adding certificate to application package ... failed (0.0s)\n")
+	assert.Contains(t, stderr.String(), "Error: adding certificate to application package failed: cannot add certificate to compressed application package")
 
 	err = os.Remove(zipFile)
 	assert.Nil(t, err)
@@ -90,12 +97,174 @@ func TestCertAdd(t *testing.T) {
 	assert.Equal(t, fmt.Sprintf("Success: Copied certificate from '%s' to '%s'\n", certificate, pkgCertificate), stdout.String())
 
 	require.NotNil(t, cli.Run("auth", "cert", "add", pkgDir))
-	assert.Contains(t, stderr.String(), fmt.Sprintf("Error: application package '%s' already contains a certificate", appDir))
+	assert.Contains(t, stderr.String(), fmt.Sprintf("Error: application package '%s' already contains this certificate", appDir))
 	stdout.Reset()
 	require.Nil(t, cli.Run("auth", "cert", "add", "-f", pkgDir))
 	assert.Equal(t, fmt.Sprintf("Success: Copied certificate from '%s' to '%s'\n", certificate, pkgCertificate), stdout.String())
 }
 
+func TestCertForceKeepsOtherCertificates(t *testing.T) {
+	cli, _, _ := newTestCLI(t)
+	configureCloud(t, cli)
+	appDir, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	pkgCertificate := filepath.Join(appDir, "security", "clients.pem")
+	var others []string
+	var clientsPEM []byte
+	for range 2 {
+		kp, err := vespa.CreateKeyPair()
+		require.Nil(t, err)
+		certificate, err := vespa.ValidateKeyPair(kp, time.Now())
+		require.Nil(t, err)
+		others = append(others, vespa.FingerprintSHA256(certificate))
+		clientsPEM = append(clientsPEM, kp.Certificate...)
+	}
+	require.Nil(t, os.MkdirAll(filepath.Dir(pkgCertificate), 0755))
+	require.Nil(t, os.WriteFile(pkgCertificate, clientsPEM, 0644))
+	pkg, err := vespa.FindApplicationPackage(pkgDir, vespa.PackageOptions{})
+	require.Nil(t, err)
+	fingerprints := func() []string {
+		certificates, err := pkg.ClientCertificates()
+		require.Nil(t, err)
+		var fps []string
+		for _, c := range certificates {
+			fps = append(fps, vespa.FingerprintSHA256(c))
+		}
+		return fps
+	}
+
+	// The certificate is appended to the existing ones
+	require.Nil(t, cli.Run("auth", "cert", pkgDir))
+	fps := fingerprints()
+	require.Len(t, fps, 3)
+	assert.Equal(t, others, fps[:2])
+	first := fps[2]
+
+	// A new certificate replaces only the one it overwrites
+	require.Nil(t, cli.Run("auth", "cert", "-f", pkgDir))
+	fps = fingerprints()
+	require.Len(t, fps, 3)
+	assert.Equal(t, others, fps[:2])
+	assert.NotEqual(t, first, fps[2])
+
+	// Adding the same certificate again with -f replaces it in place
+	require.Nil(t, cli.Run("auth", "cert", "add", "-f", pkgDir))
+	assert.Equal(t, fps, fingerprints())
+}
+
+func TestCertListRemove(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	configureCloud(t, cli)
+	appDir, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	pkgCertificate := filepath.Join(appDir, "security", "clients.pem")
+
+	stderr.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "list", pkgDir))
+	assert.Equal(t, fmt.Sprintf("No certificates found in %s\n", pkgCertificate), stderr.String())
+
+	// Add two different certificates
+	require.Nil(t, cli.Run("auth", "cert", pkgDir))
+	require.Nil(t, cli.Run("auth", "cert", "-N", "-f"))
+	require.Nil(t, cli.Run("auth", "cert", "add", pkgDir))
+	pkg, err := vespa.FindApplicationPackage(pkgDir, vespa.PackageOptions{})
+	require.Nil(t, err)
+	certificates, err := pkg.ClientCertificates()
+	require.Nil(t, err)
+	require.Len(t, certificates, 2)
+	first := vespa.FingerprintSHA256(certificates[0])
+	second := vespa.FingerprintSHA256(certificates[1])
+	assert.NotEqual(t, first, second)
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "list", pkgDir))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], first+" "+certificates[0].NotAfter.UTC().Format(time.RFC3339)))
+	assert.True(t, strings.HasPrefix(lines[1], second+" "))
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "list", "--format", "json", pkgDir))
+	var result []certListResult
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	require.Len(t, result, 2)
+	assert.Equal(t, second, result[1].Fingerprint)
+
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "remove", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: no fingerprint given")
+
+	stdout.Reset()
+	lowerCase := strings.ToLower(strings.ReplaceAll(first, ":", ""))
+	require.Nil(t, cli.Run("auth", "cert", "remove", "--fingerprint", lowerCase, pkgDir))
+	assert.Equal(t, fmt.Sprintf("Success: Removed certificate %s from '%s'\n", lowerCase, pkgCertificate), stdout.String())
+	certificates, err = pkg.ClientCertificates()
+	require.Nil(t, err)
+	require.Len(t, certificates, 1)
+	assert.Equal(t, second, vespa.FingerprintSHA256(certificates[0]))
+
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "remove", "--fingerprint", first, pkgDir))
+	assert.Contains(t, stderr.String(), "Error: no certificate with fingerprint "+first+" found")
+
+	require.Nil(t, cli.Run("auth", "cert", "remove", "--fingerprint", second, pkgDir))
+	assert.False(t, ioutil.Exists(pkgCertificate))
+}
+
+func TestCertInfo(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	configureCloud(t, cli)
+	require.NotNil(t, cli.Run("auth", "cert", "info"))
+	assert.Equal(t, "Error: no certificate exists for t1.a1.i1\nHint: Try creating a certificate with 'vespa auth cert'\n", stderr.String())
+
+	require.Nil(t, cli.Run("auth", "cert", "-N"))
+	certificateFile := filepath.Join(cli.config.homeDir, "t1.a1.i1", "data-plane-public-cert.pem")
+	data, err := os.ReadFile(certificateFile)
+	require.Nil(t, err)
+	block, _ := pem.Decode(data)
+	require.NotNil(t, block)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+
+	cli.now = func() time.Time { return certificate.NotAfter.Add(-10*24*time.Hour - time.Hour) }
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "info"))
+	assert.Equal(t, fmt.Sprintf(`Subject: CN=cloud.vespa.example
+Issuer: CN=cloud.vespa.example
+Fingerprint (SHA-256): %s
+Valid from: %s
+Valid until: %s (expires in 10 days)
+Source: '%s'
+`, vespa.FingerprintSHA256(certificate), certificate.NotBefore.UTC().Format(time.RFC3339), certificate.NotAfter.UTC().Format(time.RFC3339), certificateFile), stdout.String())
+
+	cli.now = func() time.Time { return certificate.NotAfter.Add(time.Hour) }
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "info", "--format", "json"))
+	var result certInfoResult
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.True(t, result.Expired)
+	assert.Equal(t, certificateFile, result.Source)
+}
+
+func TestCertExpiryWarning(t *testing.T) {
+	keyPair, err := vespa.CreateKeyPair()
+	require.Nil(t, err)
+	certificate, err := vespa.ValidateKeyPair(keyPair, time.Now())
+	require.Nil(t, err)
+	cli, _, stderr := newTestCLI(t, "NO_COLOR=true", "VESPA_CLI_DATA_PLANE_CERT="+string(keyPair.Certificate), "VESPA_CLI_DATA_PLANE_KEY="+string(keyPair.PrivateKey))
+	warning := "Warning: data plane certificate from environment variable VESPA_CLI_DATA_PLANE_CERT expires at " + certificate.NotAfter.UTC().Format(time.RFC3339) + " (in 10 days)\n"
+
+	cli.now = func() time.Time { return certificate.NotAfter.Add(-10*24*time.Hour - time.Hour) }
+	_, err = cli.target(targetOptions{})
+	require.Nil(t, err)
+	assert.Contains(t, stderr.String(), warning)
+
+	// Outside the warning period
+	stderr.Reset()
+	require.Nil(t, cli.Run("config", "set", "cert-warning-days", "7"))
+	_, err = cli.target(targetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "", stderr.String())
+}
+
 func TestCertNoAdd(t *testing.T) {
 	cli, stdout, stderr := newTestCLI(t)
 	configureCloud(t, cli)
@@ -122,3 +291,63 @@ func TestCertNoAdd(t *testing.T) {
 	require.Nil(t, cli.Run("auth", "cert", "-N", "-f"))
 	assert.Equal(t, fmt.Sprintf("Success: Certificate written to '%s'\nSuccess: Private key written to '%s'\n", certificate, privateKey), stdout.String())
 }
+
+func TestCertImport(t *testing.T) {
+	appDir, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	configureCloud(t, cli)
+
+	keyPair, err := vespa.CreateKeyPair()
+	require.Nil(t, err)
+	other, err := vespa.CreateKeyPair()
+	require.Nil(t, err)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	otherKeyFile := filepath.Join(dir, "other-key.pem")
+	require.Nil(t, os.WriteFile(certFile, keyPair.Certificate, 0600))
+	require.Nil(t, os.WriteFile(keyFile, keyPair.PrivateKey, 0600))
+	require.Nil(t, os.WriteFile(otherKeyFile, other.PrivateKey, 0600))
+
+	// Both files are required
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "add", "--certificate", certFile, pkgDir))
+	assert.Equal(t, "Error: both certificate and private key must be given\nHint: Use --certificate and --key together\n", stderr.String())
+
+	// Key must match certificate
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "add", "--certificate", certFile, "--key", otherKeyFile, pkgDir))
+	assert.Equal(t, "Error: cannot import certificate '"+certFile+"': invalid key pair: tls: private key does not match public key\n"+
+		"Hint: The certificate must be valid and match the private key in '"+otherKeyFile+"'\n", stderr.String())
+
+	// Expired certificate is rejected
+	cli.now = func() time.Time { return time.Now().Add(20 * 365 * 24 * time.Hour) }
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "add", "--certificate", certFile, "--key", keyFile, pkgDir))
+	assert.Contains(t, stderr.String(), "certificate expired at ")
+	cli.now = time.Now
+
+	// Import succeeds
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "cert", "add", "--certificate", certFile, "--key", keyFile, pkgDir))
+	app, err := vespa.ApplicationFromString("t1.a1.i1")
+	require.Nil(t, err)
+	certificate := filepath.Join(cli.config.homeDir, app.String(), "data-plane-public-cert.pem")
+	privateKey := filepath.Join(cli.config.homeDir, app.String(), "data-plane-private-key.pem")
+	pkgCertificate := filepath.Join(appDir, "security", "clients.pem")
+	parsed, err := vespa.ValidateKeyPair(keyPair, time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("Success: Certificate imported to '%s'\nSuccess: Private key imported to '%s'\n"+
+		"Certificate fingerprint (SHA-256): %s\nCertificate expires at: %s\n"+
+		"Success: Copied certificate from '%s' to '%s'\n",
+		certificate, privateKey, vespa.FingerprintSHA256(parsed), parsed.NotAfter.Format(time.RFC3339), certificate, pkgCertificate), stdout.String())
+	assertFileContent(t, certificate, keyPair.Certificate)
+	assertFileContent(t, privateKey, keyPair.PrivateKey)
+	assertFileContent(t, pkgCertificate, keyPair.Certificate)
+
+	// Existing certificate is not overwritten by default
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "cert", "add", "--certificate", certFile, "--key", keyFile, pkgDir))
+	assert.Equal(t, "Error: private key '"+privateKey+"' already exists\nHint: Use -f flag to force overwriting\n", stderr.String())
+	require.Nil(t, cli.Run("auth", "cert", "add", "-f", "--certificate", certFile, "--key", keyFile, pkgDir))
+}
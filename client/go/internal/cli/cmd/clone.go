@@ -6,6 +6,7 @@ package cmd
 
 import (
 	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -16,18 +17,29 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
-const sampleAppsNamePrefix = "sample-apps-master"
+const (
+	sampleAppsNamePrefix = "sample-apps-"
+	defaultSampleAppsRef = "master"
+)
+
+// sampleAppsRefEscaper escapes a Git ref for use in the name of a cached file. Underscore is escaped as it separates
+// the ref from the entity tag.
+var sampleAppsRefEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C", "_", "%5F", ":", "%3A")
 
 func newCloneCmd(cli *CLI) *cobra.Command {
 	var (
 		listApps bool
 		noCache  bool
+		ref      string
+		params   []string
+		dryRun   bool
 	)
 	cmd := &cobra.Command{
 		Use:   "clone sample-application-path target-directory",
@@ -37,38 +49,80 @@ func newCloneCmd(cli *CLI) *cobra.Command {
 Sample applications are downloaded from
 https://github.com/vespa-engine/sample-apps.
 
-By default, sample applications are cached in the user's cache directory. This
-directory can be overridden by setting the VESPA_CLI_CACHE_DIR environment
-variable.`,
-		Example:           "$ vespa clone album-recommendation my-app",
+Sample applications are downloaded from the master branch by default. Use
+--ref to download them from another branch, a tag or a commit instead.
+
+By default, sample applications are cached in the user's cache directory, with
+one copy per ref. This directory can be overridden by setting the
+VESPA_CLI_CACHE_DIR environment variable. Use --force-refresh to ignore the
+cache.
+
+Use --param to adapt the cloned application, by replacing the value of a
+parameter of the sample application with your own, in the names and contents
+of the files it is used in. The parameters of a sample application are
+declared in its vespa-template.json, e.g. {"parameters": {"schema": "music",
+"tenant": "mytenant"}}, which maps each parameter to the value used in the
+application. Without this file, the parameters are application, the name of
+the sample application, and schema, the name of its schema if it has exactly
+one. The application parameter is replaced in services.xml, deployment.xml and
+hosts.xml, the schema parameter in schemas/*.sd, services.xml and
+tests/*/*.json, and the tenant parameter in deployment.xml and tests/*/*.json.
+Other files may be declared for a parameter, and must be for any other
+parameter, e.g. {"files": {"tenant": ["tests/*/*.json"]}}. Use --dry-run to
+see which files would be renamed and changed.
+
+Use --list to list the available sample applications. If GitHub is unavailable,
+the applications in the cached copy are listed.`,
+		Example: `$ vespa clone album-recommendation my-app
+$ vespa clone --list
+$ vespa clone --ref v8.300.15 album-recommendation my-app
+$ vespa clone --param schema=song --param application=my-app album-recommendation my-app`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
+		ValidArgsFunction: cli.completeSampleApp,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if ref == "" {
+				return fmt.Errorf("ref must be non-empty")
+			}
+			cloner := &cloner{cli: cli, noCache: noCache, ref: ref, params: params, dryRun: dryRun}
 			if listApps {
-				apps, err := listSampleApps(cli.httpClient)
+				if len(args) != 0 {
+					return fmt.Errorf("expected no arguments with --list, got %d", len(args))
+				}
+				apps, err := cloner.List()
 				if err != nil {
 					return fmt.Errorf("could not list sample applications: %w", err)
 				}
+				w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
 				for _, app := range apps {
-					log.Print(app)
+					fmt.Fprintf(w, "%s\t%s\n", app.name, app.description)
 				}
-				return nil
+				return w.Flush()
 			}
 			if len(args) != 2 {
 				return fmt.Errorf("expected exactly 2 arguments, got %d", len(args))
 			}
-			cloner := &cloner{cli: cli, noCache: noCache}
 			return cloner.Clone(args[0], args[1])
 		},
 	}
 	cmd.Flags().BoolVarP(&listApps, "list", "l", false, "List available sample applications")
-	cmd.Flags().BoolVarP(&noCache, "force", "f", false, "Ignore cache and force downloading the latest sample application from GitHub")
+	cmd.Flags().BoolVarP(&noCache, "force-refresh", "f", false, "Ignore cache and force downloading the latest sample applications from GitHub")
+	cmd.Flags().BoolVar(&noCache, "force", false, "Ignore cache and force downloading the latest sample applications from GitHub")
+	cmd.Flags().MarkDeprecated("force", "use --force-refresh instead")
+	cmd.Flags().StringVar(&ref, "ref", defaultSampleAppsRef, "The branch, tag or commit of the sample applications to use")
+	cmd.Flags().StringVar(&ref, "branch", defaultSampleAppsRef, "Alias of --ref")
+	cmd.Flags().StringArrayVar(&params, "param", nil, "Replace a parameter of the sample application, on the form name=value. May be repeated")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the files which would be renamed and changed, without cloning")
 	return cmd
 }
 
 type cloner struct {
 	cli     *CLI
 	noCache bool
+	ref     string
+	// params holds values of template parameters to replace in the cloned application, on the form name=value
+	params []string
+	dryRun bool
 }
 
 type zipFile struct {
@@ -80,13 +134,7 @@ type zipFile struct {
 func (c *cloner) createDirectory(path string) error {
 	if err := os.Mkdir(path, 0755); err != nil {
 		if errors.Is(err, fs.ErrExist) {
-			entries, err := os.ReadDir(path)
-			if err != nil {
-				return err
-			}
-			if len(entries) > 0 {
-				return fmt.Errorf("%s already exists and is not empty", path)
-			}
+			return checkDirectory(path)
 		} else {
 			return err
 		}
@@ -94,10 +142,24 @@ func (c *cloner) createDirectory(path string) error {
 	return nil
 }
 
+// checkDirectory returns an error if path exists, and is not an empty directory.
+func checkDirectory(path string) error {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", path)
+	}
+	return nil
+}
+
 // Clone copies the application identified by applicationName into given path. If the cached copy of sample applications
 // has expired (as determined by its entity tag), a current copy will be downloaded from GitHub automatically.
 func (c *cloner) Clone(applicationName, path string) error {
-	zipPath, err := c.zipPath()
+	zipPath, _, err := c.zipPath()
 	if err != nil {
 		return err
 	}
@@ -108,47 +170,69 @@ func (c *cloner) Clone(applicationName, path string) error {
 	}
 	defer r.Close()
 
-	found := false
+	dirPrefix := zipRoot(&r.Reader) + applicationName + "/"
+	var files []*zip.File
 	for _, f := range r.File {
-		dirPrefix := "sample-apps-master/" + applicationName + "/"
 		if strings.HasPrefix(f.Name, dirPrefix) {
-			if !found { // Create destination directory lazily when source is found
-				if err := c.createDirectory(path); err != nil {
-					return fmt.Errorf("could not create directory: %w", err)
-				}
-			}
-			found = true
-
-			if err := copyFromZip(f, path, dirPrefix); err != nil {
-				return fmt.Errorf("could not copy zip entry '%s': %w", color.CyanString(f.Name), err)
-			}
+			files = append(files, f)
 		}
 	}
-
-	if !found {
+	if len(files) == 0 {
 		return errHint(fmt.Errorf("could not find source application '%s'", color.CyanString(applicationName)), "Use -f to ignore the cache")
+	}
+	template, err := newCloneTemplate(applicationName, dirPrefix, files, c.params)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		if err := checkDirectory(path); err != nil {
+			return fmt.Errorf("could not create directory: %w", err)
+		}
+	} else if err := c.createDirectory(path); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	for _, f := range files {
+		if err := c.copyFromZip(f, path, dirPrefix, template); err != nil {
+			return fmt.Errorf("could not copy zip entry '%s': %w", color.CyanString(f.Name), err)
+		}
+	}
+	if c.dryRun {
+		log.Print("Would clone into ", color.CyanString(path))
 	} else {
 		log.Print("Cloned into ", color.CyanString(path))
 	}
 	return nil
 }
 
-// zipPath returns the path to the latest sample application ZIP file.
-func (c *cloner) zipPath() (string, error) {
+// zipRoot returns the directory holding the sample applications in ZIP file r, e.g. sample-apps-master/.
+func zipRoot(r *zip.Reader) string {
+	for _, f := range r.File {
+		if root, _, ok := strings.Cut(f.Name, "/"); ok && root != "__MACOSX" {
+			return root + "/"
+		}
+	}
+	return ""
+}
+
+// zipPath returns the path to the latest sample application ZIP file of the ref of this, and whether this is a cached
+// copy used because downloading the latest one failed.
+func (c *cloner) zipPath() (string, bool, error) {
 	zipFiles, err := c.listZipFiles()
 	if err != nil {
-		return "", nil
+		return "", false, nil
 	}
 	cacheCandidates := zipFiles
 	if c.noCache {
 		cacheCandidates = nil
 	}
 	zipPath, cacheHit, err := c.downloadZip(cacheCandidates)
+	stale := false
 	if err != nil {
 		if cacheHit {
 			c.cli.printWarning(err)
+			stale = true
 		} else {
-			return "", err
+			return "", false, err
 		}
 	}
 	if cacheHit {
@@ -160,10 +244,15 @@ func (c *cloner) zipPath() (string, error) {
 			os.Remove(zf.path)
 		}
 	}
-	return zipPath, nil
+	return zipPath, stale, nil
+}
+
+// zipName returns the name of the cached ZIP file of the ref of this, without its entity tag and extension.
+func (c *cloner) zipName() string {
+	return sampleAppsNamePrefix + sampleAppsRefEscaper.Replace(c.ref)
 }
 
-// listZipFiles list all sample apps ZIP files found in cacheDir.
+// listZipFiles list all sample apps ZIP files of the ref of this found in cacheDir.
 func (c *cloner) listZipFiles() ([]zipFile, error) {
 	dirEntries, err := os.ReadDir(c.cli.config.cacheDir)
 	if err != nil {
@@ -175,7 +264,7 @@ func (c *cloner) listZipFiles() ([]zipFile, error) {
 		if ext != ".zip" {
 			continue
 		}
-		if !strings.HasPrefix(entry.Name(), sampleAppsNamePrefix) {
+		if base := strings.TrimSuffix(entry.Name(), ext); base != c.zipName() && !strings.HasPrefix(base, c.zipName()+"_") {
 			continue
 		}
 		fi, err := entry.Info()
@@ -212,7 +301,7 @@ func (c *cloner) downloadZip(cachedFiles []zipFile) (string, bool, error) {
 	// the cached copy if GitHub is unavailable.
 	cacheHit := zipPath != ""
 	err := c.cli.spinner(c.cli.Stderr, color.YellowString("Downloading sample apps ..."), func() error {
-		request, err := http.NewRequest("GET", "https://github.com/vespa-engine/sample-apps/archive/refs/heads/master.zip", nil)
+		request, err := http.NewRequest("GET", "https://github.com/vespa-engine/sample-apps/archive/"+c.ref+".zip", nil)
 		if err != nil {
 			return fmt.Errorf("invalid url: %w", err)
 		}
@@ -228,6 +317,9 @@ func (c *cloner) downloadZip(cachedFiles []zipFile) (string, bool, error) {
 		if response.StatusCode == http.StatusNotModified { // entity tag matched so our cached copy is current
 			return nil
 		}
+		if response.StatusCode == http.StatusNotFound {
+			return errHint(fmt.Errorf("could not download sample apps: no ref '%s' found", c.ref), "The ref must be a branch, tag or commit in https://github.com/vespa-engine/sample-apps")
+		}
 		if response.StatusCode != http.StatusOK {
 			return fmt.Errorf("could not download sample apps: github returned status %d", response.StatusCode)
 		}
@@ -260,7 +352,7 @@ func (c *cloner) writeZip(zipReader io.Reader, etag string) (string, error) {
 		return "", fmt.Errorf("could not write sample apps to file: %s: %w", f.Name(), err)
 	}
 	f.Close()
-	path := filepath.Join(c.cli.config.cacheDir, sampleAppsNamePrefix)
+	path := filepath.Join(c.cli.config.cacheDir, c.zipName())
 	if etag != "" {
 		path += "_" + etag
 	}
@@ -276,30 +368,55 @@ func trimEntityTagID(s string) string {
 	return strings.TrimSuffix(strings.TrimPrefix(s, `W/"`), `"`)
 }
 
-func copyFromZip(f *zip.File, destinationDir string, zipEntryPrefix string) error {
-	destinationPath := filepath.Join(destinationDir, filepath.FromSlash(strings.TrimPrefix(f.Name, zipEntryPrefix)))
+// copyFromZip copies zip entry f to destinationDir, with parameters of template, if any, replaced in its name and
+// contents. In dry run mode, the changes template would make are printed instead.
+func (c *cloner) copyFromZip(f *zip.File, destinationDir string, zipEntryPrefix string, template *cloneTemplate) error {
+	name := strings.TrimPrefix(f.Name, zipEntryPrefix)
+	if template != nil && name == templateManifest {
+		return nil
+	}
+	if newName := template.rename(name); newName != name {
+		if c.dryRun {
+			log.Printf("Would rename %s to %s", name, newName)
+		} else {
+			log.Printf("Renamed %s to %s", name, newName)
+		}
+		name = newName
+	}
+	destinationPath := filepath.Join(destinationDir, filepath.FromSlash(name))
 	if strings.HasSuffix(f.Name, "/") {
-		if f.Name != zipEntryPrefix { // root is already created
+		if f.Name != zipEntryPrefix && !c.dryRun { // root is already created
 			if err := os.Mkdir(destinationPath, 0755); err != nil {
 				return err
 			}
 		}
-	} else {
-		r, err := f.Open()
+		return nil
+	}
+	var r io.Reader
+	if template != nil {
+		data, err := template.apply(f, name, log.Writer(), c.dryRun)
 		if err != nil {
 			return err
 		}
-		defer r.Close()
-		destination, err := os.Create(destinationPath)
+		r = bytes.NewReader(data)
+	} else {
+		rc, err := f.Open()
 		if err != nil {
 			return err
 		}
-		if _, err := io.Copy(destination, r); err != nil {
-			return err
-		}
-		if err := os.Chmod(destinationPath, f.Mode()); err != nil {
-			return err
-		}
+		defer rc.Close()
+		r = rc
 	}
-	return nil
+	if c.dryRun {
+		return nil
+	}
+	destination, err := os.Create(destinationPath)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+	if _, err := io.Copy(destination, r); err != nil {
+		return err
+	}
+	return os.Chmod(destinationPath, f.Mode())
 }
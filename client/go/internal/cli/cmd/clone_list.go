@@ -2,85 +2,117 @@
 package cmd
 
 import (
-	"encoding/json"
-	"net/http"
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
-	"github.com/vespa-engine/vespa/client/go/internal/httputil"
+	"github.com/fatih/color"
 )
 
-func listSampleApps(client httputil.Client) ([]string, error) {
-	return listSampleAppsAt("https://api.github.com/repos/vespa-engine/sample-apps/contents/", client)
+var sampleAppTitlePrefix = regexp.MustCompile(`(?i)^vespa sample applications?\s*-\s*`)
+
+// sampleApp is a sample application, with a one-line description from its README.
+type sampleApp struct {
+	name        string
+	description string
 }
 
-func listSampleAppsAt(url string, client httputil.Client) ([]string, error) {
-	rfs, err := getRepositoryFiles(url, client)
+// List returns the sample applications in the ref of this, sorted by name. If they cannot be downloaded, the
+// applications in the cached copy, if any, are listed, with a warning that these may be stale.
+func (c *cloner) List() ([]sampleApp, error) {
+	zipPath, stale, err := c.zipPath()
 	if err != nil {
 		return nil, err
 	}
-	var apps []string
-	for _, rf := range rfs {
-		isApp, follow := isApp(rf)
-		if isApp {
-			apps = append(apps, rf.Path)
-		} else if follow {
-			apps2, err := listSampleAppsAt(rf.URL, client)
-			if err != nil {
-				return nil, err
+	if stale {
+		if fi, err := os.Stat(zipPath); err == nil {
+			c.cli.printWarning(fmt.Sprintf("listing sample apps downloaded at %s, which may be stale", fi.ModTime().Format(time.DateTime)))
+		}
+	}
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open sample apps zip '%s': %w", color.CyanString(zipPath), err)
+	}
+	defer r.Close()
+	return listSampleApps(&r.Reader), nil
+}
+
+// listSampleApps returns the sample applications in ZIP file r, sorted by name.
+func listSampleApps(r *zip.Reader) []sampleApp {
+	root := zipRoot(r)
+	dirs := make(map[string][]string) // Sub-directories by their parent, relative to root
+	readmes := make(map[string]*zip.File)
+	for _, f := range r.File {
+		name, ok := strings.CutPrefix(f.Name, root)
+		if !ok {
+			continue
+		}
+		if dir, ok := strings.CutSuffix(name, "/"); ok && dir != "" {
+			parent := path.Dir(dir)
+			if parent == "." {
+				parent = ""
+			}
+			dirs[parent] = append(dirs[parent], dir)
+		} else if path.Base(name) == "README.md" {
+			readmes[path.Dir(name)] = f
+		}
+	}
+	var apps []sampleApp
+	var list func(parent string)
+	list = func(parent string) {
+		for _, dir := range dirs[parent] {
+			isApp, follow := isApp(dir)
+			if isApp {
+				apps = append(apps, sampleApp{name: dir, description: readmeTitle(readmes[dir])})
+			} else if follow {
+				list(dir)
 			}
-			apps = append(apps, apps2...)
 		}
 	}
-	sort.Strings(apps)
-	return apps, nil
+	list("")
+	sort.Slice(apps, func(i, j int) bool { return apps[i].name < apps[j].name })
+	return apps
 }
 
-func getRepositoryFiles(url string, client httputil.Client) ([]repositoryFile, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// readmeTitle returns the first heading of README f, without the common prefix of sample application titles.
+func readmeTitle(f *zip.File) string {
+	if f == nil {
+		return ""
 	}
-	response, err := client.Do(req, time.Minute)
+	r, err := f.Open()
 	if err != nil {
-		return nil, err
+		return ""
 	}
-	defer response.Body.Close()
-	var files []repositoryFile
-	dec := json.NewDecoder(response.Body)
-	if err := dec.Decode(&files); err != nil {
-		return nil, err
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if title, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "# "); ok {
+			return sampleAppTitlePrefix.ReplaceAllString(strings.TrimSpace(title), "")
+		}
 	}
-	return files, nil
+	return ""
 }
 
-func isApp(rf repositoryFile) (ok bool, follow bool) {
-	if rf.Type != "dir" {
-		return false, false
-	}
-	if rf.Path == "" {
-		return false, false
-	}
-	if rf.Path[0] == '_' || rf.Path[0] == '.' {
+func isApp(dir string) (ok bool, follow bool) {
+	name := path.Base(dir)
+	if name[0] == '_' || name[0] == '.' {
 		return false, false
 	}
 	// These are just heuristics and must be updated if we add more directories that are not applications, or that
 	// contain multiple applications inside
-	switch rf.Name {
+	switch name {
 	case "test", "bin", "src":
 		return false, false
 	}
-	switch rf.Path {
+	switch dir {
 	case "news", "examples", "examples/operations", "operations", "vespa-cloud":
 		return false, true
 	}
 	return true, false
 }
-
-type repositoryFile struct {
-	Path    string `json:"path"`
-	Name    string `json:"name"`
-	Type    string `json:"type"`
-	URL     string `json:"url"`
-	HtmlURL string `json:"html_url"`
-}
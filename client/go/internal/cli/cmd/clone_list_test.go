@@ -7,55 +7,36 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 )
 
 func TestListSampleApps(t *testing.T) {
-	c := &mock.HTTPClient{}
-	c.NextResponseString(200, readTestData(t, "sample-apps-contents.json"))
-	c.NextResponseString(200, readTestData(t, "sample-apps-news.json"))
-	c.NextResponseString(200, readTestData(t, "sample-apps-operations.json"))
-	c.NextResponseString(200, readTestData(t, "sample-apps-vespa-cloud.json"))
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = httpClient
+	httpClient.NextResponseString(200, readTestData(t, "sample-apps-master.zip"))
 
-	apps, err := listSampleApps(c)
-	assert.Nil(t, err)
-	expected := []string{
-		"album-recommendation-monitoring",
-		"album-recommendation-selfhosted",
-		"basic-search-on-gke",
-		"boolean-search",
-		"dense-passage-retrieval-with-ann",
-		"generic-request-processing",
-		"http-api-using-request-handlers-and-processors",
-		"incremental-search",
-		"model-evaluation",
-		"msmarco-ranking",
-		"multiple-bundles",
-		"multiple-bundles-lib",
-		"news/app-1-getting-started",
-		"news/app-2-feed-and-query",
-		"news/app-3-searching",
-		"news/app-5-recommendation",
-		"news/app-6-recommendation-with-searchers",
-		"news/app-7-parent-child",
-		"operations/multinode",
-		"part-purchases-demo",
-		"secure-vespa-with-mtls",
-		"semantic-qa-retrieval",
-		"tensor-playground",
-		"text-search",
-		"transformers",
-		"use-case-shopping",
-		"vespa-chinese-linguistics",
-		"vespa-cloud/album-recommendation",
-		"vespa-cloud/album-recommendation-docproc",
-		"vespa-cloud/album-recommendation-prod",
-		"vespa-cloud/album-recommendation-searcher",
-		"vespa-cloud/cord-19-search",
-		"vespa-cloud/joins",
-		"vespa-cloud/vespa-documentation-search",
-	}
-	assert.Equal(t, expected, apps)
+	require.Nil(t, cli.Run("clone", "--list"))
+	expected := `text-search                                  text search tutorial
+vespa-cloud/album-recommendation             album recommendations
+vespa-cloud/album-recommendation-java        album recommendation, with Java components
+vespa-cloud/album-recommendation-prod-tests  album recommendations
+vespa-cloud/cord-19-search                   CORD-19
+vespa-cloud/document-processing              document processing
+vespa-cloud/joins                            document joins
+vespa-cloud/vespa-documentation-search       Vespa Documentation Search
+`
+	assert.Equal(t, expected, stdout.String())
+	assert.Equal(t, "https://github.com/vespa-engine/sample-apps/archive/master.zip", httpClient.LastRequest.URL.String())
+
+	// Listing falls back to the cached copy if GitHub is unavailable
+	httpClient.NextStatus(500)
+	stdout.Reset()
+	require.Nil(t, cli.Run("clone", "--list"))
+	assert.Contains(t, stdout.String(), "text-search")
+	assert.Contains(t, stderr.String(), "Warning: could not download sample apps: github returned status 500\nWarning: listing sample apps downloaded at ")
+	assert.Contains(t, stderr.String(), ", which may be stale\n")
 }
 
 func readTestData(t *testing.T, name string) string {
@@ -0,0 +1,199 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Parameter substitution in cloned sample applications
+
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// templateManifest is the name of the file declaring the parameters of a sample application. It is not cloned.
+const templateManifest = "vespa-template.json"
+
+// templateFiles are the files each well-known parameter is replaced in, unless the template manifest declares others.
+var templateFiles = map[string][]string{
+	"application": {"services.xml", "deployment.xml", "hosts.xml"},
+	"schema":      {"schemas/*.sd", "services.xml", "tests/*/*.json"},
+	"tenant":      {"deployment.xml", "tests/*/*.json"},
+}
+
+// cloneTemplate replaces the current values of parameters of a sample application, in the names and contents of the
+// files declared for each parameter.
+type cloneTemplate struct {
+	replacements []templateReplacement
+}
+
+type templateReplacement struct {
+	param   string
+	from    string
+	to      string
+	files   []string
+	pattern *regexp.Regexp
+}
+
+// matches returns whether the parameter of r is replaced in the file with relative path name. A pattern of files
+// matches the base name of the path, or as many of its last elements as the pattern has.
+func (r templateReplacement) matches(name string) bool {
+	elements := strings.Split(name, "/")
+	for _, pattern := range r.files {
+		n := strings.Count(pattern, "/") + 1
+		if n > len(elements) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.Join(elements[len(elements)-n:], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateParameters returns the parameters of the sample application in files, by name, with the value each has in
+// the sample application, and the files each is replaced in, if declared. These are read from the template manifest
+// in the application, if any, and otherwise inferred: the application parameter is the name of the application
+// directory, and the schema parameter the name of the schema, if the application has a single one.
+func templateParameters(applicationName, dirPrefix string, files []*zip.File) (map[string]string, map[string][]string, error) {
+	params := make(map[string]string)
+	var schemas []string
+	for _, f := range files {
+		name := strings.TrimPrefix(f.Name, dirPrefix)
+		if name == templateManifest {
+			r, err := f.Open()
+			if err != nil {
+				return nil, nil, err
+			}
+			defer r.Close()
+			var manifest struct {
+				Parameters map[string]string   `json:"parameters"`
+				Files      map[string][]string `json:"files"`
+			}
+			if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s in %s: %w", templateManifest, applicationName, err)
+			}
+			return manifest.Parameters, manifest.Files, nil
+		}
+		if path.Ext(name) == ".sd" && path.Base(path.Dir(name)) == "schemas" {
+			schemas = append(schemas, strings.TrimSuffix(path.Base(name), ".sd"))
+		}
+	}
+	params["application"] = path.Base(applicationName)
+	if len(schemas) == 1 {
+		params["schema"] = schemas[0]
+	}
+	return params, nil, nil
+}
+
+// newCloneTemplate creates a template replacing the parameters of the sample application in files with values, where
+// each value is on the form name=value. It returns nil if there are no values to replace.
+func newCloneTemplate(applicationName, dirPrefix string, files []*zip.File, values []string) (*cloneTemplate, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	params, paramFiles, err := templateParameters(applicationName, dirPrefix, files)
+	if err != nil {
+		return nil, err
+	}
+	t := &cloneTemplate{}
+	for _, value := range values {
+		name, to, ok := strings.Cut(value, "=")
+		if !ok || name == "" || to == "" {
+			return nil, fmt.Errorf("invalid parameter '%s': must be on the form name=value", value)
+		}
+		from, ok := params[name]
+		if !ok {
+			names := make([]string, 0, len(params))
+			for name := range params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, errHint(fmt.Errorf("unknown parameter '%s' for %s", name, applicationName),
+				fmt.Sprintf("Parameters of this application are: %s", strings.Join(names, ", ")))
+		}
+		files, ok := paramFiles[name]
+		if !ok {
+			files, ok = templateFiles[name]
+		}
+		if !ok {
+			return nil, errHint(fmt.Errorf("no files to replace parameter '%s' in are declared for %s", name, applicationName),
+				fmt.Sprintf(`Declare them in %s, e.g. {"files": {"%s": ["services.xml"]}}`, templateManifest, name))
+		}
+		t.replacements = append(t.replacements, templateReplacement{
+			param:   name,
+			from:    from,
+			to:      to,
+			files:   files,
+			pattern: regexp.MustCompile(`\b` + regexp.QuoteMeta(from) + `\b`),
+		})
+	}
+	return t, nil
+}
+
+// rename returns relative path name with parameters replaced in its base name, for the parameters replaced in it.
+func (t *cloneTemplate) rename(name string) string {
+	if t == nil {
+		return name
+	}
+	dir, base := path.Split(name)
+	for _, r := range t.replacements {
+		if r.matches(name) {
+			base = r.pattern.ReplaceAllLiteralString(base, r.to)
+		}
+	}
+	return dir + base
+}
+
+// replace returns data of the file with relative path name with parameters replaced, and the number of replacements
+// made of each parameter. Binary data is returned as-is.
+func (t *cloneTemplate) replace(name string, data []byte) ([]byte, []int) {
+	counts := make([]int, len(t.replacements))
+	if bytes.IndexByte(data, 0) >= 0 {
+		return data, counts
+	}
+	for i, r := range t.replacements {
+		if !r.matches(name) {
+			continue
+		}
+		counts[i] = len(r.pattern.FindAllIndex(data, -1))
+		if counts[i] > 0 {
+			data = r.pattern.ReplaceAllLiteral(data, []byte(r.to))
+		}
+	}
+	return data, counts
+}
+
+// apply reads zip entry f and returns its contents with parameters replaced, printing a line for each kind of
+// replacement to w, as past or planned changes.
+func (t *cloneTemplate) apply(f *zip.File, name string, w io.Writer, dryRun bool) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, counts := t.replace(name, data)
+	verb := "Replaced"
+	if dryRun {
+		verb = "Would replace"
+	}
+	for i, count := range counts {
+		if count > 0 {
+			plural := "s"
+			if count == 1 {
+				plural = ""
+			}
+			r := t.replacements[i]
+			fmt.Fprintf(w, "%s '%s' with '%s' in %s (%d occurrence%s)\n", verb, r.from, r.to, name, count, plural)
+		}
+	}
+	return data, nil
+}
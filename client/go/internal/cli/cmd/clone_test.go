@@ -97,6 +97,25 @@ func TestClone(t *testing.T) {
 		}
 	}
 	assert.Equal(t, []string{"sample-apps-master_id1.zip"}, zipFiles)
+
+	// Cloning another ref downloads and caches it separately
+	headers = make(http.Header)
+	headers.Set("etag", `W/"id2"`)
+	httpClient.NextResponse(mock.HTTPResponse{Status: 200, Body: testdata, Header: headers})
+	stdout.Reset()
+	app5 := filepath.Join(tempDir, "app5")
+	require.Nil(t, cli.Run("clone", "--ref", "feature/x_y", sampleAppName, app5))
+	assert.Equal(t, "https://github.com/vespa-engine/sample-apps/archive/feature/x_y.zip", httpClient.LastRequest.URL.String())
+	assert.Equal(t, "", httpClient.LastRequest.Header.Get("if-none-match"))
+	assertFiles(t, app5)
+	assert.True(t, ioutil.Exists(filepath.Join(cli.config.cacheDir, "sample-apps-master_id1.zip")))
+	assert.True(t, ioutil.Exists(filepath.Join(cli.config.cacheDir, "sample-apps-feature%2Fx%5Fy_id2.zip")))
+
+	// An unknown ref is an error
+	httpClient.NextStatus(404)
+	stderr.Reset()
+	require.NotNil(t, cli.Run("clone", "--ref", "nope", sampleAppName, filepath.Join(tempDir, "app6")))
+	assert.Equal(t, "Error: could not download sample apps: no ref 'nope' found\nHint: The ref must be a branch, tag or commit in https://github.com/vespa-engine/sample-apps\n", stderr.String())
 }
 
 func assertFiles(t *testing.T, app string) {
@@ -114,3 +133,51 @@ func assertFiles(t *testing.T, app string) {
 	require.Nil(t, err)
 	assert.Equal(t, os.FileMode(0755), scriptStat.Mode())
 }
+
+func TestCloneWithParameters(t *testing.T) {
+	testdata, err := os.ReadFile(filepath.Join("testdata", "sample-apps-master.zip"))
+	require.Nil(t, err)
+	httpClient := &mock.HTTPClient{}
+	httpClient.NextResponseBytes(200, testdata)
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = httpClient
+	app := filepath.Join(t.TempDir(), "app")
+
+	require.Nil(t, cli.Run("clone", "--param", "schema=passage", "--dry-run", "text-search", app))
+	assert.Contains(t, stdout.String(), "Would rename src/main/application/schemas/msmarco.sd to src/main/application/schemas/passage.sd\n")
+	assert.Contains(t, stdout.String(), "Would replace 'msmarco' with 'passage' in src/main/application/services.xml (2 occurrences)\n")
+	assert.True(t, strings.HasSuffix(stdout.String(), "Would clone into "+app+"\n"))
+	assert.False(t, ioutil.Exists(app))
+
+	httpClient.NextResponseBytes(200, testdata)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = httpClient
+	require.Nil(t, cli.Run("clone", "--param", "schema=passage", "text-search", app))
+	assert.Contains(t, stdout.String(), "Renamed src/main/application/schemas/msmarco.sd to src/main/application/schemas/passage.sd\n")
+	assert.True(t, ioutil.Exists(filepath.Join(app, "src", "main", "application", "schemas", "passage.sd")))
+	assert.False(t, ioutil.Exists(filepath.Join(app, "src", "main", "application", "schemas", "msmarco.sd")))
+	services, err := os.ReadFile(filepath.Join(app, "src", "main", "application", "services.xml"))
+	require.Nil(t, err)
+	assert.Contains(t, string(services), `<content id="passage" version="1.0">`)
+	assert.Contains(t, string(services), `<document type='passage' mode="index"/>`)
+
+	// Files the parameter is not declared for are kept as they are
+	assert.True(t, ioutil.IsDir(filepath.Join(app, "msmarco", "sample")))
+	assert.True(t, ioutil.Exists(filepath.Join(app, "src", "python", "msmarco.py")))
+	readme, err := os.ReadFile(filepath.Join(app, "README.md"))
+	require.Nil(t, err)
+	assert.Contains(t, string(readme), "msmarco")
+	assert.NotContains(t, stdout.String(), "README.md")
+
+	httpClient.NextResponseBytes(200, testdata)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = httpClient
+	require.NotNil(t, cli.Run("clone", "--param", "tenant=foo", "text-search", filepath.Join(t.TempDir(), "app")))
+	assert.Equal(t, "Error: unknown parameter 'tenant' for text-search\nHint: Parameters of this application are: application, schema\n", stderr.String())
+
+	httpClient.NextResponseBytes(200, testdata)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = httpClient
+	require.NotNil(t, cli.Run("clone", "--param", "schema=passage", "--dry-run", "text-search", app))
+	assert.Equal(t, "Error: could not create directory: "+app+" already exists and is not empty\n", stderr.String())
+}
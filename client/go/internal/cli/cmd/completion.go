@@ -0,0 +1,301 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// completionTimeout is the timeout of each request made when completing a command line. Completion runs while the
+// user waits, so it gives up quickly when the network is unavailable.
+const completionTimeout = 2 * time.Second
+
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// configureCompletions registers dynamic completion of the global flags.
+func (c *CLI) configureCompletions() {
+	c.cmd.RegisterFlagCompletionFunc(applicationFlag, c.completeApplication)
+	c.cmd.RegisterFlagCompletionFunc(zoneFlag, c.completeZone)
+	for _, name := range []string{targetFlag, colorFlag, outputFlag, profileFlag, authFlag} {
+		if complete := c.optionCompletion(name); complete != nil {
+			c.cmd.RegisterFlagCompletionFunc(name, complete)
+		}
+	}
+}
+
+// fixedCompletion returns a completion function completing any of values.
+func fixedCompletion(values ...string) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// optionCompletion returns the completion function for values of the named config option, or nil if its values cannot
+// be completed.
+func (c *CLI) optionCompletion(option string) completionFunc {
+	switch option {
+	case targetFlag:
+		return fixedCompletion(vespa.TargetLocal, vespa.TargetCloud, vespa.TargetHosted)
+	case colorFlag:
+		return fixedCompletion("auto", "always", "never")
+	case outputFlag:
+		return fixedCompletion("human", "json")
+	case quietFlag, debugModeFlag, updateCheckOption:
+		return fixedCompletion("true", "false")
+	case dataPlaneAuthOption, authFlag:
+		return fixedCompletion("cert", "token")
+	case credentialStoreOption:
+		return fixedCompletion(credentialStoreFile, credentialStoreKeychain)
+	case profileFlag:
+		return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			profiles, _ := c.config.listProfiles()
+			return profiles, cobra.ShellCompDirectiveNoFileComp
+		}
+	case applicationFlag:
+		return c.completeApplication
+	case zoneFlag:
+		return c.completeZone
+	}
+	return nil
+}
+
+// completeConfigSet completes the option name and value arguments of 'vespa config set'.
+func (c *CLI) completeConfigSet(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return c.config.list(true), cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		if complete := c.optionCompletion(args[0]); complete != nil {
+			return complete(cmd, args, toComplete)
+		}
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSampleApp completes the names of the sample applications in the cached copy of the sample apps. The
+// sample apps are never downloaded while completing.
+func (c *CLI) completeSampleApp(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveFilterDirs // Target directory
+	}
+	ref, _ := cmd.Flags().GetString("ref")
+	cloner := &cloner{cli: c, ref: ref}
+	zipFiles, err := cloner.listZipFiles()
+	if err != nil || len(zipFiles) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	sort.Slice(zipFiles, func(i, j int) bool { return zipFiles[i].modTime.After(zipFiles[j].modTime) })
+	r, err := zip.OpenReader(zipFiles[0].path)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer r.Close()
+	var names []string
+	for _, app := range listSampleApps(&r.Reader) {
+		names = append(names, app.name+"\t"+app.description)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeApplication completes an application ID one part at a time, from the tenants of the authenticated user, the
+// applications of the tenant, and the instances of the application.
+func (c *CLI) completeApplication(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	api, ok := c.completionAPI()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	parts := strings.Split(toComplete, ".")
+	switch len(parts) {
+	case 1:
+		tenants := api.tenants()
+		for i, tenant := range tenants {
+			tenants[i] = tenant + "."
+		}
+		return tenants, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	case 2:
+		var completions []string
+		for _, app := range api.applications(parts[0]) {
+			completions = append(completions, parts[0]+"."+app)
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	case 3:
+		var completions []string
+		for _, instance := range api.instances(parts[0], parts[1]) {
+			completions = append(completions, parts[0]+"."+parts[1]+"."+instance)
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeZone completes the zones the selected application is deployed in, and the default zone.
+func (c *CLI) completeZone(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	api, ok := c.completionAPI()
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	zones := []string{api.system.DefaultZone.String()}
+	if app, err := c.config.application(); err == nil {
+		for _, zone := range api.zones(app) {
+			if !slices.Contains(zones, zone) {
+				zones = append(zones, zone)
+			}
+		}
+	}
+	return zones, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionAPI is a silent client of the Vespa Cloud API, used for completion.
+type completionAPI struct {
+	cli    *CLI
+	client httputil.Client
+	system vespa.System
+}
+
+// completionAPI returns a client for the Vespa Cloud API, if the configured target is Vespa Cloud.
+func (c *CLI) completionAPI() (*completionAPI, bool) {
+	if target, err := c.config.targetOrURL(); err != nil || target != vespa.TargetCloud {
+		return nil, false
+	}
+	system, err := c.system(vespa.TargetCloud)
+	if err != nil {
+		return nil, false
+	}
+	client := c.httpClientFactory(completionTimeout)
+	httputil.ConfigureRetry(client, httputil.RetryPolicy{})
+	return &completionAPI{cli: c, client: client, system: system}, true
+}
+
+// authenticator returns an authenticator for requests concerning tenant. Unlike when running commands, no warnings
+// are printed.
+func (a *completionAPI) authenticator(tenant string) (vespa.Authenticator, error) {
+	config := a.cli.config
+	if config.authMethod(a.cli) == authMethodAPIKey {
+		if tenant == "" {
+			return nil, fmt.Errorf("no tenant specified")
+		}
+		apiKey, ok := config.apiKeyFromEnv()
+		if !ok {
+			var err error
+			if apiKey, err = a.cli.apiKeyFiles().ReadFile(config.apiKeyPath(tenant)); err != nil {
+				return nil, err
+			}
+		}
+		keyID := vespa.ApplicationID{Tenant: tenant, Application: "default", Instance: "default"}
+		if app, err := config.application(); err == nil && app.Tenant == tenant {
+			keyID = app
+		}
+		return vespa.NewRequestSigner(keyID.SerializedForm(), apiKey), nil
+	}
+	return a.cli.auth0Factory(a.client, a.cli.auth0Options(a.system))
+}
+
+// get decodes the JSON response of a GET request to path of the API into v.
+func (a *completionAPI) get(tenant, path string, v any) error {
+	auth, err := a.authenticator(tenant)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", a.system.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	if err := auth.Authenticate(req); err != nil {
+		return err
+	}
+	response, err := a.client.Do(req, completionTimeout)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %d", response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// tenants returns the tenants of the authenticated user. With an API key, this is only the tenant of the configured
+// application.
+func (a *completionAPI) tenants() []string {
+	if a.cli.config.authMethod(a.cli) == authMethodAPIKey {
+		if app, err := a.cli.config.application(); err == nil {
+			return []string{app.Tenant}
+		}
+		return nil
+	}
+	var user userV1
+	if err := a.get("", "/user/v1/user", &user); err != nil {
+		return nil
+	}
+	var tenants []string
+	for tenant := range user.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+func (a *completionAPI) tenantApplications(tenant string) []vespa.ApplicationID {
+	var response vespa.CloudTenantResponse
+	if err := a.get(tenant, "/application/v4/tenant/"+tenant, &response); err != nil {
+		return nil
+	}
+	var ids []vespa.ApplicationID
+	for _, app := range response.Applications {
+		ids = append(ids, vespa.ApplicationID{Tenant: app.Tenant, Application: app.Application, Instance: app.Instance})
+	}
+	return ids
+}
+
+// applications returns the applications of tenant.
+func (a *completionAPI) applications(tenant string) []string {
+	var apps []string
+	for _, id := range a.tenantApplications(tenant) {
+		if !slices.Contains(apps, id.Application) {
+			apps = append(apps, id.Application)
+		}
+	}
+	sort.Strings(apps)
+	return apps
+}
+
+// instances returns the instances of the named application.
+func (a *completionAPI) instances(tenant, application string) []string {
+	var instances []string
+	for _, id := range a.tenantApplications(tenant) {
+		if id.Application == application && id.Instance != "" && !slices.Contains(instances, id.Instance) {
+			instances = append(instances, id.Instance)
+		}
+	}
+	sort.Strings(instances)
+	return instances
+}
+
+// zones returns the zones the instance of app is deployed in.
+func (a *completionAPI) zones(app vespa.ApplicationID) []string {
+	var response vespa.CloudInstanceResponse
+	if err := a.get(app.Tenant, fmt.Sprintf("/application/v4/tenant/%s/application/%s", app.Tenant, app.Application), &response); err != nil {
+		return nil
+	}
+	var zones []string
+	for _, instance := range response.Instances {
+		if instance.Instance != app.Instance {
+			continue
+		}
+		for _, deployment := range instance.Deployments {
+			zones = append(zones, deployment.Environment+"."+deployment.Region)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,115 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func assertCompletion(t *testing.T, cli *CLI, stdout *bytes.Buffer, want string, args ...string) {
+	t.Helper()
+	stdout.Reset()
+	require.Nil(t, cli.Run(append([]string{"__complete"}, args...)...))
+	assert.Equal(t, want, stdout.String())
+}
+
+func newCompletionCLI(t *testing.T) (*CLI, *mock.HTTPClient, *bytes.Buffer) {
+	t.Helper()
+	cli, stdout, _ := newTestCLI(t)
+	httpClient := cli.httpClient.(*mock.HTTPClient)
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	// Presence of auth config selects authentication with access token
+	require.Nil(t, os.WriteFile(cli.config.authConfigPath(), []byte("{}"), 0600))
+	return cli, httpClient, stdout
+}
+
+func TestCompleteApplication(t *testing.T) {
+	cli, httpClient, stdout := newCompletionCLI(t)
+	httpClient.NextResponseString(200, `{"tenants": {"t2": {}, "t1": {}}}`)
+	assertCompletion(t, cli, stdout, "t1.\nt2.\n:6\n", "status", "-a", "")
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/user/v1/user", httpClient.LastRequest.URL.String())
+
+	tenantResponse := `{"tenant": "t1", "applications": [
+  {"tenant": "t1", "application": "a2", "instance": "default"},
+  {"tenant": "t1", "application": "a1", "instance": "i2"},
+  {"tenant": "t1", "application": "a1", "instance": "i1"}
+]}`
+	httpClient.NextResponseString(200, tenantResponse)
+	assertCompletion(t, cli, stdout, "t1.a1\nt1.a2\n:6\n", "status", "-a", "t1.")
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1", httpClient.LastRequest.URL.String())
+
+	httpClient.NextResponseString(200, tenantResponse)
+	assertCompletion(t, cli, stdout, "t1.a1.i1\nt1.a1.i2\n:4\n", "status", "-a", "t1.a1.")
+
+	// Network errors complete nothing, silently
+	httpClient.NextResponseError(errors.New("network unreachable"))
+	assertCompletion(t, cli, stdout, ":6\n", "status", "-a", "t1.")
+}
+
+func TestCompleteZone(t *testing.T) {
+	cli, httpClient, stdout := newCompletionCLI(t)
+	httpClient.NextResponseString(200, `{"instances": [
+  {"instance": "default", "deployments": [{"environment": "prod", "region": "aws-us-east-1c"}, {"environment": "dev", "region": "aws-us-east-1c"}]},
+  {"instance": "other", "deployments": [{"environment": "prod", "region": "aws-eu-west-1a"}]}
+]}`)
+	assertCompletion(t, cli, stdout, "dev.aws-us-east-1c\nprod.aws-us-east-1c\n:4\n", "deploy", "-a", "t1.a1", "-z", "")
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1", httpClient.LastRequest.URL.String())
+
+	// Nothing is completed for other targets
+	cli, stdout, _ = newTestCLI(t)
+	assertCompletion(t, cli, stdout, ":4\n", "deploy", "-a", "t1.a1", "-z", "")
+}
+
+func TestCompleteConfigSet(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t)
+	assertCompletion(t, cli, stdout, `application
+cert-warning-days
+cluster
+color
+credential-store
+data-plane-auth
+data-plane-headers
+data-plane-token
+debug
+endpoint-cache-ttl
+endpoint-overrides
+endpoint-overrides-insecure
+http-retries
+instance
+output
+profile
+quiet
+target
+update-check
+zone
+:4
+`, "config", "set", "")
+	assertCompletion(t, cli, stdout, "auto\nalways\nnever\n:4\n", "config", "set", "color", "")
+	assertCompletion(t, cli, stdout, "true\nfalse\n:4\n", "config", "set", "update-check", "")
+	assertCompletion(t, cli, stdout, "local\ncloud\nhosted\n:4\n", "config", "set", "target", "")
+	assertCompletion(t, cli, stdout, ":4\n", "config", "set", "cluster", "")
+	assertCompletion(t, cli, stdout, "human\njson\n:4\n", "status", "-o", "")
+}
+
+func TestCompleteSampleApp(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t)
+	// Nothing is completed without a cached copy
+	assertCompletion(t, cli, stdout, ":4\n", "clone", "")
+
+	testdata, err := os.ReadFile(filepath.Join("testdata", "sample-apps-master.zip"))
+	require.Nil(t, err)
+	require.Nil(t, os.MkdirAll(cli.config.cacheDir, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(cli.config.cacheDir, "sample-apps-master_id1.zip"), testdata, 0644))
+	stdout.Reset()
+	require.Nil(t, cli.Run("__complete", "clone", ""))
+	assert.Contains(t, stdout.String(), "text-search\t")
+	assert.Equal(t, 0, len(cli.httpClient.(*mock.HTTPClient).Requests))
+	assertCompletion(t, cli, stdout, ":16\n", "clone", "text-search", "")
+}
@@ -7,10 +7,16 @@ package cmd
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,11 +26,50 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/vespa-engine/vespa/client/go/internal/cli/config"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 const (
-	configFile = "config.yaml"
+	configFile         = "config.yaml"
+	profilesDir        = "profiles"
+	dataPlaneTokensDir = "data-plane-tokens"
+	savedQueriesDir    = "queries"
+	defaultProfile     = "default"
+
+	authMethodAPIKey = "api-key"
+	authMethodToken  = "token"
+
+	certWarningDaysOption           = "cert-warning-days"
+	credentialStoreOption           = "credential-store"
+	dataPlaneAuthOption             = "data-plane-auth"
+	dataPlaneHeadersOption          = "data-plane-headers"
+	dataPlaneTokenOption            = "data-plane-token"
+	endpointCacheTTLOption          = "endpoint-cache-ttl"
+	endpointOverridesOption         = "endpoint-overrides"
+	endpointOverridesInsecureOption = "endpoint-overrides-insecure"
+	httpRetriesOption               = "http-retries"
+	updateCheckOption               = "update-check"
+)
+
+// configOptions holds the options that have no corresponding flag, and their default values.
+var configOptions = map[string]string{
+	certWarningDaysOption:           "30",
+	credentialStoreOption:           credentialStoreFile,
+	dataPlaneAuthOption:             "",
+	dataPlaneHeadersOption:          "",
+	dataPlaneTokenOption:            "",
+	endpointCacheTTLOption:          "10m",
+	endpointOverridesOption:         "",
+	endpointOverridesInsecureOption: "false",
+	httpRetriesOption:               "2",
+	updateCheckOption:               "true",
+}
+
+var (
+	profileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	tokenName   = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	queryName   = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 )
 
 func newConfigCmd() *cobra.Command {
@@ -43,7 +88,9 @@ overridden by setting the VESPA_CLI_HOME environment variable.
 When setting an option locally, the configuration is written to .vespa in the
 working directory, where that directory is assumed to be a Vespa application
 directory. This allows you to have separate configuration options per
-application.
+application. Local configuration is also found when running commands from a
+subdirectory of the application directory, e.g. src/main/application, as the
+closest parent directory holding .vespa/config.yaml is used.
 
 Vespa CLI chooses the value for a given option in the following order, from
 most to least preferred:
@@ -63,6 +110,12 @@ defaults to "default". This is only relevant for the "cloud" and "hosted"
 targets. See https://docs.vespa.ai/en/cloud/tenant-apps-instances.html for more details.
 This has no default value. Examples: tenant1.app1, tenant1.app1.instance1
 
+cert-warning-days
+
+Specifies how many days before expiry of the data plane certificate Vespa CLI
+starts warning about it, when connecting to an application. Defaults to 30.
+Setting this to 0 disables the warning.
+
 cluster
 
 Specifies the container cluster to manage. If left empty (default) and the
@@ -74,8 +127,87 @@ https://docs.vespa.ai/en/reference/services-container.html for more details.
 color
 
 Controls how Vespa CLI uses colors. Setting this to "auto" (default) enables
-colors if supported by the terminal, "never" completely disables colors and
-"always" enables colors unilaterally.
+colors if supported by the terminal, and the NO_COLOR environment variable is
+unset or empty. Setting this to "never" completely disables colors and "always"
+enables colors unilaterally, also when output is not a terminal.
+
+credential-store
+
+Specifies where Vespa CLI stores the API keys of tenants, and the refresh tokens
+of 'vespa auth login'. Setting this to "file" (default) stores API keys as files
+in the Vespa CLI home directory, and refresh tokens in the credential store of
+the operating system, or in files when logging in with --file-storage. Setting
+this to "keychain" stores both in the credential store of the operating system:
+the Keychain on macOS, the Credential Manager on Windows, and a Secret Service,
+such as GNOME Keyring, on Linux. Credentials stored in files are moved to the
+credential store when they are first used. An API key given by
+VESPA_CLI_API_KEY or VESPA_CLI_API_KEY_FILE is always read from there.
+
+data-plane-auth
+
+Specifies how requests to the data plane of an application, made by document,
+feed, query and visit, are authenticated. Setting this to "cert" authenticates
+with the data plane certificate, and "token" with a data plane token, using the
+token endpoint of the application. When unset (default), a token is used if the
+VESPA_CLI_DATA_PLANE_TOKEN environment variable is set, and the certificate
+otherwise. This can be overridden for a single command with --auth.
+
+data-plane-headers
+
+Specifies headers to add to every request to the data plane of an application,
+made by document, feed, query, visit and test, as a JSON object mapping each
+header name to its value, such as '{"X-Org-Token":"secret"}'. This allows
+reaching applications behind a gateway requiring headers of its own. A header
+given by --header replaces the one of the same name given here. Headers set by
+Vespa CLI itself, like Content-Type, cannot be given. The values of these
+headers are redacted from the output of --verbose and --trace-file, unless
+--show-secrets is given. This has no default value.
+
+data-plane-token
+
+Specifies the name of the stored data plane token to use with token
+authentication. Tokens are stored with 'vespa auth token set', which also sets
+this option. The VESPA_CLI_DATA_PLANE_TOKEN environment variable takes
+precedence over the stored token. This has no default value.
+
+endpoint-cache-ttl
+
+Specifies how long the endpoints of an application in Vespa Cloud are cached,
+such that commands like query and document need not ask the control plane for
+them on every invocation. Endpoints are cached per application, zone and
+credentials, and are discovered again when a request to a cached endpoint fails
+to connect or gets status 404. Defaults to 10m. Setting this to 0 disables the
+cache, which can also be done for a single command with --no-endpoint-cache.
+
+endpoint-overrides
+
+Specifies addresses to connect to instead of others, as a JSON object mapping
+each overridden host:port to the host:port to connect to instead. This allows
+reaching endpoints on internal host names through SSH tunnels, such as
+'{"internal-host:8080":"localhost:18080"}'. Requests are sent as to the
+original address, with its Host header, and its TLS certificate verified
+against its host name, and are never sent through a proxy. Overrides can also
+be given for a single command with --endpoint-override, and the connections
+made are shown with --verbose. This has no default value. Alternatively,
+endpoints can be reached through a SOCKS5 proxy, such as one opened by
+'ssh -D', given by the ALL_PROXY environment variable, e.g.
+socks5://localhost:1080.
+
+endpoint-overrides-insecure
+
+Controls whether the TLS certificates of overridden endpoints are verified.
+Setting this to "true" skips verification for these endpoints only. Defaults
+to "false".
+
+http-retries
+
+Specifies how many times Vespa CLI retries a request which fails with a
+transient error, such as a connection reset or status 503, with increasing
+delays in between. Only requests which are safe to repeat, like those reading
+status, documents or query results, are retried, and some commands, like curl
+and test, never retry. Defaults to 2. Setting this to 0 disables retries, which
+can also be done for a single command with --no-retry. Retries are shown with
+--verbose.
 
 instance
 
@@ -84,9 +216,28 @@ precedence over the instance specified as part of the 'application' option.
 This has no default value and is only relevant for the "cloud" and "hosted"
 targets. Example: instance2
 
+output
+
+Controls how commands print their results. Setting this to "human" (default)
+prints human-readable text, while "json" prints the result of a command as a
+single JSON object on standard output, and any other text on standard error.
+Errors are printed as a JSON object with a message and hints. Supported by
+deploy, destroy, status, config get and auth show.
+
+profile
+
+Specifies the configuration profile to use. The target, application, instance,
+zone and cluster options are stored per profile. Defaults to "default", the
+profile holding options set before any profile was created. See 'vespa help
+config profile' for how to manage profiles.
+
 quiet
 
-Suppress informational output. Errors are still printed.
+Suppress informational output, such as success messages, warnings and progress.
+Errors and the results of commands, such as query results and documents, are
+still printed. Commands which prompt for confirmation fail instead, unless
+confirmation is given by a flag such as --force. Warnings are printed if the
+verbose flag of a command is set.
 
 target
 
@@ -103,12 +254,21 @@ e.g. vespa deploy or vespa query. Possible values are:
 - hosted: Connect to hosted Vespa (reserved for internal use)
 - *url*:  Connect to a platform running at given URL. This instructs the command
           you're running to target a concrete URL. The cluster option cannot be
-          used with this target.
+          used with this target. This may be a comma-separated list of URLs
+          of config servers, which are tried in order until one is reachable.
+          Use --verbose to show which is used.
 
 Authentication is configured automatically for the cloud and hosted targets. To
 set a custom private key and certificate, e.g. for use with a self-hosted Vespa
 installation configured with mTLS, see the documentation of 'vespa auth cert'.
 
+update-check
+
+Controls whether Vespa CLI checks for new releases. When set to "true"
+(default), Vespa CLI checks at most once per day, when running interactively,
+and prints a hint on how to upgrade if a newer release is available. The check
+is also made by 'vespa version'. Setting this to "false" disables all checks.
+
 zone
 
 Specifies a custom zone to use when connecting to a Vespa Cloud application.
@@ -125,10 +285,20 @@ dev.aws-us-east-1c, dev.gcp-us-central1-f, perf.aws-us-east-1c`,
 }
 
 func newConfigSetCmd(cli *CLI) *cobra.Command {
-	var localArg bool
+	var (
+		localArg     bool
+		allowUnknown bool
+	)
 	cmd := &cobra.Command{
 		Use:   "set option-name value",
 		Short: "Set a configuration option.",
+		Long: `Set a configuration option.
+
+The option name and value are validated before the option is written. See
+'vespa help config' for the available options and their values. Options which
+are unknown to this version of Vespa CLI, such as options added in a newer
+version, can only be set with --allow-unknown, and are not validated.
+`,
 		Example: `# Set the target to Vespa Cloud
 $ vespa config set target cloud
 
@@ -146,6 +316,7 @@ $ vespa config set --local zone perf.us-north-1`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: cli.completeConfigSet,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config := cli.config
 			if localArg {
@@ -155,13 +326,27 @@ $ vespa config set --local zone perf.us-north-1`,
 				}
 				config = cli.config.local
 			}
-			if err := config.set(args[0], args[1]); err != nil {
+			option, value := args[0], args[1]
+			if err := config.checkOption(option); err != nil {
+				if !allowUnknown {
+					cliErr, ok := err.(ErrCLI)
+					if !ok {
+						cliErr = errHint(err)
+					}
+					cliErr.hints = append(cliErr.hints, "Use --allow-unknown to set an option unknown to this version of Vespa CLI")
+					return cliErr
+				}
+				config.config.Set(option, value)
+				return config.write()
+			}
+			if err := config.set(option, value); err != nil {
 				return err
 			}
 			return config.write()
 		},
 	}
 	cmd.Flags().BoolVarP(&localArg, "local", "l", false, "Write option to local configuration, i.e. for the current application")
+	cmd.Flags().BoolVar(&allowUnknown, "allow-unknown", false, "Allow setting an option which is unknown to this version of Vespa CLI, e.g. one used by a newer version")
 	return cmd
 }
 
@@ -201,7 +386,11 @@ $ vespa config unset --local application`,
 }
 
 func newConfigGetCmd(cli *CLI) *cobra.Command {
-	var localArg bool
+	var (
+		localArg  bool
+		allArg    bool
+		redactArg bool
+	)
 	cmd := &cobra.Command{
 		Use:   "get [option-name]",
 		Short: "Show given configuration option, or all configuration options",
@@ -209,11 +398,19 @@ func newConfigGetCmd(cli *CLI) *cobra.Command {
 
 By default, this command prints the effective configuration for the current
 application, i.e. it takes into account any local configuration located in
-[working-directory]/.vespa.
+.vespa/config.yaml in the working directory or its closest parent directory
+holding such a file. The file holding each value is printed next to it. The
+profile option shows the active profile.
+
+With --redact, secrets are removed from the output, such that it can be shared,
+e.g. in an issue: the values of data-plane-headers, credentials and query
+strings of target URLs, and the home directory of the user in file paths.
 `,
 		Example: `$ vespa config get
 $ vespa config get target
-$ vespa config get --local`,
+$ vespa config get --all
+$ vespa config get --local
+$ vespa config get --redact`,
 		Args:              cobra.MaximumNArgs(1),
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
@@ -226,17 +423,45 @@ $ vespa config get --local`,
 				}
 				config = cli.config.local
 			}
+			if allArg && len(args) > 0 {
+				return fmt.Errorf("cannot combine --all with an option name")
+			}
+			options := args
 			if len(args) == 0 { // Print all values
-				for _, option := range config.list(!localArg) {
-					config.printOption(option)
+				options = config.list(!localArg)
+			}
+			if cli.jsonOutput() {
+				values := make(map[string]any, len(options))
+				for _, option := range options {
+					if err := config.checkOption(option); err != nil {
+						if len(args) > 0 {
+							return err
+						}
+						continue
+					}
+					if value, ok := config.get(option); ok {
+						if redactArg {
+							value = redactOption(option, value)
+						}
+						values[option] = value
+					} else {
+						values[option] = nil
+					}
+				}
+				return cli.printResult(values)
+			}
+			if len(args) == 0 {
+				for _, option := range options {
+					config.printOption(cli.Stdout, option, redactArg)
 				}
-			} else {
-				return config.printOption(args[0])
+				return nil
 			}
-			return nil
+			return config.printOption(cli.Stdout, args[0], redactArg)
 		},
 	}
 	cmd.Flags().BoolVarP(&localArg, "local", "l", false, "Show only local configuration, if any")
+	cmd.Flags().BoolVar(&allArg, "all", false, "Show all configuration options, including the active profile. This is the default when no option is given")
+	cmd.Flags().BoolVar(&redactArg, "redact", false, "Remove secrets, such as header values and the home directory, from the values shown")
 	return cmd
 }
 
@@ -248,6 +473,8 @@ type Config struct {
 
 	flags  map[string]*pflag.Flag
 	config *config.Config
+
+	profiles map[string]*config.Config // Loaded profiles, by name. This is nil for local configuration
 }
 
 type KeyPair struct {
@@ -262,15 +489,20 @@ func loadConfig(environment map[string]string, flags map[string]*pflag.Flag) (*C
 	if err != nil {
 		return nil, fmt.Errorf("could not detect config directory: %w", err)
 	}
-	config, err := loadConfigFrom(home, environment, flags)
+	c, err := loadConfigFrom(home, environment, flags)
+	if err != nil {
+		return nil, err
+	}
+	c.profiles = make(map[string]*config.Config)
+	// Load local config from working directory, or the closest parent directory holding local config
+	localDir, err := findLocalConfigDir(".", home)
 	if err != nil {
 		return nil, err
 	}
-	// Load local config from working directory by default
-	if err := config.loadLocalConfigFrom("."); err != nil {
+	if err := c.loadLocalConfigFrom(localDir); err != nil {
 		return nil, err
 	}
-	return config, nil
+	return c, nil
 }
 
 func loadConfigFrom(dir string, environment map[string]string, flags map[string]*pflag.Flag) (*Config, error) {
@@ -309,6 +541,33 @@ func athenzPath(filename string) (string, error) {
 	return filepath.Join(userHome, ".athenz", filename), nil
 }
 
+// findLocalConfigDir returns the closest directory, starting at dir and walking up, which holds local configuration in
+// .vespa/config.yaml. The global configuration in homeDir is not considered local. If no such directory exists, dir is
+// returned.
+func findLocalConfigDir(dir, homeDir string) (string, error) {
+	start, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	home, err := filepath.Abs(homeDir)
+	if err != nil {
+		return "", err
+	}
+	for d := start; ; {
+		candidate := filepath.Join(d, ".vespa")
+		if candidate != home {
+			if _, err := os.Stat(filepath.Join(candidate, configFile)); err == nil {
+				return d, nil
+			}
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir, nil
+		}
+		d = parent
+	}
+}
+
 func (c *Config) loadLocalConfigFrom(parent string) error {
 	home := filepath.Join(parent, ".vespa")
 	_, err := os.Stat(home)
@@ -327,8 +586,14 @@ func (c *Config) write() error {
 	if err := os.MkdirAll(c.homeDir, 0700); err != nil {
 		return err
 	}
-	configFile := filepath.Join(c.homeDir, configFile)
-	return c.config.WriteFile(configFile)
+	if values, err := c.profileValues(); err != nil {
+		return err
+	} else if values != nil {
+		if err := values.WriteFile(c.profilePath(c.activeProfile())); err != nil {
+			return err
+		}
+	}
+	return c.config.WriteFile(c.path())
 }
 
 func (c *Config) targetOrURL() (string, error) {
@@ -339,6 +604,114 @@ func (c *Config) targetOrURL() (string, error) {
 	return targetType, nil
 }
 
+// httpRetries returns the number of times to retry requests failing with a transient error.
+func (c *Config) httpRetries() int {
+	value, _ := c.get(httpRetriesOption)
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0
+	}
+	return retries
+}
+
+// endpointCacheTTL returns how long discovered endpoints are cached.
+func (c *Config) endpointCacheTTL() time.Duration {
+	value, _ := c.get(endpointCacheTTLOption)
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// endpointOverrides returns the addresses to connect to instead of others, and whether to skip verification of their
+// TLS certificates.
+func (c *Config) endpointOverrides() (map[string]string, bool) {
+	value, _ := c.get(endpointOverridesOption)
+	overrides, err := parseEndpointOverrides(value)
+	if err != nil {
+		overrides = nil
+	}
+	insecure, _ := c.get(endpointOverridesInsecureOption)
+	return overrides, insecure == "true"
+}
+
+// dataPlaneHeaders returns the headers to add to requests to the data plane, as given by the data-plane-headers option.
+func (c *Config) dataPlaneHeaders() (http.Header, error) {
+	value, _ := c.get(dataPlaneHeadersOption)
+	header, err := parseDataPlaneHeaders(value)
+	if err != nil {
+		return nil, errHint(fmt.Errorf("invalid value for %s: %w", dataPlaneHeadersOption, err), "Set a valid value with 'vespa config set "+dataPlaneHeadersOption+"'")
+	}
+	return header, nil
+}
+
+// parseDataPlaneHeaders parses the JSON object value, mapping header names to values. An empty value has no headers.
+func parseDataPlaneHeaders(value string) (http.Header, error) {
+	header := make(http.Header)
+	if value == "" {
+		return header, nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		return nil, err
+	}
+	for name, v := range values {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		header.Set(name, v)
+	}
+	return header, nil
+}
+
+// parseEndpointOverrides parses the JSON object value, mapping host:port to host:port. An empty value has no overrides.
+func parseEndpointOverrides(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return nil, err
+	}
+	for addr, override := range overrides {
+		if err := checkEndpointOverride(addr, override); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// checkEndpointOverride returns an error if addr or override is not on the form host:port.
+func checkEndpointOverride(addr, override string) error {
+	if err := httputil.CheckAddress(addr); err != nil {
+		return err
+	}
+	return httputil.CheckAddress(override)
+}
+
+// endpointCachePath returns the path of the file holding cached endpoints.
+func (c *Config) endpointCachePath() string { return filepath.Join(c.homeDir, "endpoints.json") }
+
+// certWarningDays returns the number of days before expiry of the data plane certificate to start warning about it.
+func (c *Config) certWarningDays() int {
+	value, _ := c.get(certWarningDaysOption)
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0
+	}
+	return days
+}
+
+// updateCheck returns whether to check for new releases of Vespa CLI.
+func (c *Config) updateCheck() bool {
+	value, _ := c.get(updateCheckOption)
+	return value == "true"
+}
+
+// updateCheckPath returns the path of the file recording the time of the last check for new releases.
+func (c *Config) updateCheckPath() string { return filepath.Join(c.homeDir, "last-update-check") }
+
 func (c *Config) isQuiet() bool {
 	quiet, _ := c.get(quietFlag)
 	return quiet == "true"
@@ -507,7 +880,8 @@ func (c *Config) readTLSOptions(app vespa.ApplicationID, targetType string) (ves
 			if source == "" {
 				source = "environment"
 			}
-			return vespa.TLSOptions{}, fmt.Errorf("certificate in %s expired at %s (%s ago)", source, cert.NotAfter, delta)
+			return vespa.TLSOptions{}, errHint(fmt.Errorf("certificate in %s expired at %s (%s ago)", source, cert.NotAfter, delta),
+				"Run 'vespa auth cert -f' to create a new certificate", "Use 'vespa auth cert info' to show the certificate")
 		}
 	}
 	return options, nil
@@ -530,10 +904,54 @@ func (c *Config) apiKeyPath(tenantName string) string {
 	return filepath.Join(c.homeDir, tenantName+".api-key.pem")
 }
 
+// apiKeySource returns the source of the API key for given tenant. This is either a file path or the name of the
+// environment variable holding the key.
+func (c *Config) apiKeySource(tenantName string) string {
+	if _, ok := c.apiKeyFromEnv(); ok {
+		return "VESPA_CLI_API_KEY"
+	}
+	return c.apiKeyPath(tenantName)
+}
+
+// isEnvSource returns whether credentials read from source were set in an environment variable. A source is either a
+// file path or the name of the environment variable holding the credentials.
+func (c *Config) isEnvSource(source string) bool {
+	_, ok := c.environment[source]
+	return ok && strings.HasPrefix(source, "VESPA_CLI_")
+}
+
+// describeSource returns a human-readable description of where credentials read from source came from.
+func (c *Config) describeSource(source string) string {
+	if c.isEnvSource(source) {
+		return "from environment variable " + source
+	}
+	return "from '" + source + "'"
+}
+
 func (c *Config) authConfigPath() string {
 	return filepath.Join(c.homeDir, "auth.json")
 }
 
+// authMethod returns the method used to authenticate with the Vespa Cloud API, which is either authMethodAPIKey or
+// authMethodToken.
+func (c *Config) authMethod(cli *CLI) string {
+	if _, ok := c.apiKeyFromEnv(); ok {
+		return authMethodAPIKey
+	}
+	if _, ok := c.apiKeyFileFromEnv(); ok {
+		return authMethodAPIKey
+	}
+	if cli.isCloudCI() {
+		return authMethodToken // Vespa Cloud CI only talks to data plane and does not have an API key
+	}
+	if !cli.isCI() {
+		if _, err := os.Stat(c.authConfigPath()); err == nil {
+			return authMethodToken // We have auth config, so we should prefer Auth0 over API key
+		}
+	}
+	return authMethodAPIKey
+}
+
 func (c *Config) readAPIKey(cli *CLI, tenantName string) ([]byte, error) {
 	if override, ok := c.apiKeyFromEnv(); ok {
 		return override, nil
@@ -541,16 +959,13 @@ func (c *Config) readAPIKey(cli *CLI, tenantName string) ([]byte, error) {
 	if path, ok := c.apiKeyFileFromEnv(); ok {
 		return os.ReadFile(path)
 	}
-	if cli.isCloudCI() {
-		return nil, nil // Vespa Cloud CI only talks to data plane and does not have an API key
+	if c.authMethod(cli) != authMethodAPIKey {
+		return nil, nil
 	}
 	if !cli.isCI() {
-		if _, err := os.Stat(c.authConfigPath()); err == nil {
-			return nil, nil // We have auth config, so we should prefer Auth0 over API key
-		}
 		cli.printWarning("Authenticating with API key, intended for use in CI environments.", "Authenticate with 'vespa auth login' instead")
 	}
-	return os.ReadFile(c.apiKeyPath(tenantName))
+	return cli.apiKeyFiles().ReadFile(c.apiKeyPath(tenantName))
 }
 
 func (c *Config) readSessionID(app vespa.ApplicationID) (int64, error) {
@@ -573,6 +988,84 @@ func (c *Config) writeSessionID(app vespa.ApplicationID, sessionID int64) error
 	return os.WriteFile(sessionPath, []byte(fmt.Sprintf("%d\n", sessionID)), 0600)
 }
 
+// dataPlaneTokenPath returns the path of the file holding the data plane token of given name.
+func (c *Config) dataPlaneTokenPath(name string) string {
+	return filepath.Join(c.homeDir, dataPlaneTokensDir, name)
+}
+
+// readDataPlaneToken reads the stored data plane token of given name.
+func (c *Config) readDataPlaneToken(name string) (string, error) {
+	if !tokenName.MatchString(name) {
+		return "", fmt.Errorf("invalid token name: %q: must consist of letters, digits, '-' and '_'", name)
+	}
+	data, err := os.ReadFile(c.dataPlaneTokenPath(name))
+	if os.IsNotExist(err) {
+		return "", errHint(fmt.Errorf("no data plane token named %s is stored", name), "Store it with 'vespa auth token set "+name+"'")
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeDataPlaneToken stores token as the data plane token of given name. The token is readable only by the user.
+func (c *Config) writeDataPlaneToken(name, token string) error {
+	if !tokenName.MatchString(name) {
+		return fmt.Errorf("invalid token name: %q: must consist of letters, digits, '-' and '_'", name)
+	}
+	filename := c.dataPlaneTokenPath(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	// Restrict permissions of an existing file before writing the new token to it
+	if err := os.Chmod(filename, 0600); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(filename, []byte(token+"\n"), 0600)
+}
+
+// savedQueryPath returns the path of the file holding the saved query of given name.
+func (c *Config) savedQueryPath(name string) string {
+	return filepath.Join(c.homeDir, savedQueriesDir, name+".json")
+}
+
+// findSavedQuery returns the path of the saved query of given name, or nameOrPath itself if it is the path of a query
+// file, i.e., if it contains a path separator or ends with .json.
+func (c *Config) findSavedQuery(nameOrPath string) (string, error) {
+	if strings.ContainsRune(nameOrPath, '/') || strings.ContainsRune(nameOrPath, filepath.Separator) || strings.HasSuffix(nameOrPath, ".json") {
+		if _, err := os.Stat(nameOrPath); err != nil {
+			return "", fmt.Errorf("could not read saved query: %w", err)
+		}
+		return nameOrPath, nil
+	}
+	if !queryName.MatchString(nameOrPath) {
+		return "", fmt.Errorf("invalid query name: %q: must consist of letters, digits, '-' and '_'", nameOrPath)
+	}
+	filename := c.savedQueryPath(nameOrPath)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return "", errHint(fmt.Errorf("no query named %s is saved", nameOrPath), "Save it with 'vespa query --save "+nameOrPath+" <query-parameters>'", "List saved queries with 'vespa query --list-saved'")
+	} else if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// listSavedQueries returns the names of all saved queries, sorted.
+func (c *Config) listSavedQueries() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(c.homeDir, savedQueriesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && !entry.IsDir() && queryName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func (c *Config) applicationFilePath(app vespa.ApplicationID, name string) (string, error) {
 	appDir := filepath.Join(c.homeDir, app.String())
 	if err := os.MkdirAll(appDir, 0700); err != nil {
@@ -593,15 +1086,18 @@ func (c *Config) list(includeUnset bool) []string {
 	for k := range c.flags {
 		flags = append(flags, k)
 	}
+	for k := range configOptions {
+		flags = append(flags, k)
+	}
 	sort.Strings(flags)
 	return flags
 }
 
-// flagValue returns the set value and default value of the named flag.
+// flagValue returns the set value and default value of the named flag. Options without a flag have only a default value.
 func (c *Config) flagValue(name string) (string, string, bool) {
 	f, ok := c.flags[name]
 	if !ok {
-		return "", "", ok
+		return "", configOptions[name], false
 	}
 	return f.Value.String(), f.DefValue, f.Changed
 }
@@ -616,104 +1112,418 @@ func (c *Config) getNonEmpty(option string) (string, bool) {
 }
 
 // get returns the value associated with option, from the most preferred source in the following order: flag > local
-// config > global config.
+// config > active profile > global config.
 func (c *Config) get(option string) (string, bool) {
+	value, _, ok := c.lookup(option)
+	return value, ok
+}
+
+// lookup returns the value associated with option, as get does, and the path of the file holding the value. The path is
+// empty if the value is given by a flag, or is the default value.
+func (c *Config) lookup(option string) (string, string, bool) {
 	flagValue, flagDefault, changed := c.flagValue(option)
 	// explicit flag value always takes precedence over everything else
 	if changed {
-		return flagValue, true
+		return flagValue, "", true
 	}
 	// ... then local config, if option is explicitly defined there
 	if c.local != nil {
 		if value, ok := c.local.getNonEmpty(option); ok {
-			return value, ok
+			return value, c.local.path(), ok
+		}
+	}
+	// ... then the active profile, if option is stored per profile
+	if isProfileOption(option) {
+		if values, _ := c.profileValues(); values != nil {
+			if v, ok := values.Get(option); ok && v != "" {
+				return v, c.profilePath(c.activeProfile()), true
+			}
+			return flagDefault, "", flagDefault != ""
 		}
 	}
 	// ... then global config
 	if v, ok := c.getNonEmpty(option); ok {
-		return v, ok
+		return v, c.path(), ok
 	}
 	// ... then finally default flag value, if any
-	return flagDefault, flagDefault != ""
+	return flagDefault, "", flagDefault != ""
 }
 
+// path returns the path of the file holding this configuration.
+func (c *Config) path() string { return filepath.Join(c.homeDir, configFile) }
+
 func (c *Config) set(option, value string) error {
+	if err := c.checkOption(option); err != nil {
+		return err
+	}
+	value, err := c.checkValue(option, value)
+	if err != nil {
+		return err
+	}
+	if option == profileFlag {
+		if value == defaultProfile {
+			c.config.Del(option)
+		} else {
+			c.config.Set(option, value)
+		}
+		return nil
+	}
+	c.store(option, value)
+	return nil
+}
+
+// checkValue returns an error if value is not a valid value of option. Otherwise, the value is returned in its
+// canonical form.
+func (c *Config) checkValue(option, value string) (string, error) {
 	switch option {
 	case targetFlag:
 		switch value {
 		case vespa.TargetLocal, vespa.TargetCloud, vespa.TargetHosted:
-			c.config.Set(option, value)
-			return nil
+			return value, nil
 		}
-		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
-			c.config.Set(option, value)
-			return nil
+		if isURL(value) && !slices.ContainsFunc(vespa.SplitURLs(value), func(u string) bool { return !isURL(u) }) {
+			return value, nil
 		}
+		return "", errHint(fmt.Errorf("invalid target: %q", value), `Must be "local", "cloud", "hosted", or one or more comma-separated URLs, such as http://127.0.0.1:19071`)
 	case applicationFlag:
 		app, err := vespa.ApplicationFromString(value)
 		if err != nil {
-			return err
+			return "", errHint(err, "Must be on the form tenant.application or tenant.application.instance")
 		}
-		c.config.Set(option, app.String())
-		return nil
-	case instanceFlag:
-		c.config.Set(option, value)
-		return nil
-	case clusterFlag:
-		c.config.Set(clusterFlag, value)
-		return nil
+		return app.String(), nil
+	case zoneFlag:
+		if _, err := vespa.ZoneFromString(value); err != nil {
+			return "", errHint(err, "Must be on the form environment.region, such as dev.aws-us-east-1c")
+		}
+		return value, nil
+	case profileFlag:
+		if err := c.checkProfile(value); err != nil {
+			return "", err
+		}
+		return value, nil
 	case colorFlag:
-		switch value {
-		case "auto", "never", "always":
-			c.config.Set(option, value)
-			return nil
+		return checkEnum(option, value, "auto", "never", "always")
+	case outputFlag:
+		return checkEnum(option, value, "human", "json")
+	case quietFlag, updateCheckOption, endpointOverridesInsecureOption:
+		return checkEnum(option, value, "true", "false")
+	case endpointOverridesOption:
+		if _, err := parseEndpointOverrides(value); err != nil {
+			return "", errHint(fmt.Errorf("invalid value for %s: %w", option, err), `Must be a JSON object mapping host:port to host:port, such as {"internal-host:8080":"localhost:18080"}`)
 		}
-	case quietFlag:
-		switch value {
-		case "true", "false":
-			c.config.Set(option, value)
-			return nil
+		return value, nil
+	case dataPlaneAuthOption:
+		return checkEnum(option, value, "cert", "token")
+	case dataPlaneHeadersOption:
+		header, err := parseDataPlaneHeaders(value)
+		if err != nil {
+			return "", errHint(fmt.Errorf("invalid value for %s: %w", option, err), `Must be a JSON object mapping header names to values, such as {"X-Org-Token":"secret"}`)
 		}
-	case zoneFlag:
-		if _, err := vespa.ZoneFromString(value); err != nil {
-			return err
+		for name := range header {
+			if err := checkDataPlaneHeader(name); err != nil {
+				return "", err
+			}
 		}
-		c.config.Set(option, value)
-		return nil
+		return value, nil
+	case credentialStoreOption:
+		return checkEnum(option, value, credentialStoreFile, credentialStoreKeychain)
+	case dataPlaneTokenOption:
+		if _, err := c.readDataPlaneToken(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	case endpointCacheTTLOption:
+		if ttl, err := time.ParseDuration(value); err != nil || ttl < 0 {
+			return "", errHint(fmt.Errorf("invalid value for %s: %q", option, value), "Must be a non-negative duration, such as 10m or 30s")
+		}
+		return value, nil
+	case certWarningDaysOption, httpRetriesOption:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return "", errHint(fmt.Errorf("invalid value for %s: %q", option, value), "Must be a non-negative integer")
+		}
+		return value, nil
 	}
-	return fmt.Errorf("invalid option or value: %s = %s", option, value)
+	return value, nil
+}
+
+// checkEnum returns an error if value is not one of the allowed values of option.
+func checkEnum(option, value string, allowed ...string) (string, error) {
+	if slices.Contains(allowed, value) {
+		return value, nil
+	}
+	quoted := make([]string, len(allowed))
+	for i, v := range allowed {
+		quoted[i] = strconv.Quote(v)
+	}
+	last := len(quoted) - 1
+	return "", errHint(fmt.Errorf("invalid value for %s: %q", option, value), "Must be "+strings.Join(quoted[:last], ", ")+" or "+quoted[last])
 }
 
 func (c *Config) unset(option string) error {
 	if err := c.checkOption(option); err != nil {
 		return err
 	}
-	c.config.Del(option)
+	if values, _ := c.profileValues(); values != nil && isProfileOption(option) {
+		values.Del(option)
+	} else {
+		c.config.Del(option)
+	}
+	return nil
+}
+
+// store sets option to value, in the active profile if option is stored per profile.
+func (c *Config) store(option, value string) {
+	if values, _ := c.profileValues(); values != nil && isProfileOption(option) {
+		values.Set(option, value)
+		return
+	}
+	c.config.Set(option, value)
+}
+
+// isProfileOption returns whether option is stored per profile.
+func isProfileOption(option string) bool {
+	switch option {
+	case targetFlag, applicationFlag, instanceFlag, zoneFlag, clusterFlag:
+		return true
+	}
+	return false
+}
+
+// activeProfile returns the name of the profile in use.
+func (c *Config) activeProfile() string {
+	if name, ok := c.get(profileFlag); ok {
+		return name
+	}
+	return defaultProfile
+}
+
+func (c *Config) profilePath(name string) string {
+	return filepath.Join(c.homeDir, profilesDir, name+".yaml")
+}
+
+// profileValues returns the options of the active profile. The options of the default profile are stored in the main
+// configuration, in which case nil is returned.
+func (c *Config) profileValues() (*config.Config, error) {
+	if c.profiles == nil {
+		return nil, nil // Local configuration has no profiles
+	}
+	name := c.activeProfile()
+	if name == defaultProfile {
+		return nil, nil
+	}
+	if values, ok := c.profiles[name]; ok {
+		return values, nil
+	}
+	values, err := c.readProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	c.profiles[name] = values
+	return values, nil
+}
+
+// readProfile reads the options of the named profile, which must not be the default profile.
+func (c *Config) readProfile(name string) (*config.Config, error) {
+	if err := c.checkProfile(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(c.profilePath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values, err := config.Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", name, err)
+	}
+	return values, nil
+}
+
+// checkProfile returns an error if name is not a valid name of an existing profile.
+func (c *Config) checkProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name: %q: must consist of letters, digits, '-' and '_'", name)
+	}
+	if name == defaultProfile || c.profiles == nil {
+		return nil
+	}
+	if _, err := os.Stat(c.profilePath(name)); os.IsNotExist(err) {
+		return errHint(fmt.Errorf("profile %s does not exist", name), "Create it with 'vespa config profile create "+name+"'")
+	} else if err != nil {
+		return err
+	}
 	return nil
 }
 
+// listProfiles returns the names of all profiles, sorted.
+func (c *Config) listProfiles() ([]string, error) {
+	profiles := []string{defaultProfile}
+	entries, err := os.ReadDir(filepath.Join(c.homeDir, profilesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".yaml")
+		if ok && !entry.IsDir() && profileName.MatchString(name) && name != defaultProfile {
+			profiles = append(profiles, name)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles, nil
+}
+
+// createProfile creates a new profile without any options set.
+func (c *Config) createProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return c.checkProfile(name)
+	}
+	if name == defaultProfile {
+		return fmt.Errorf("profile %s already exists", name)
+	}
+	filename := c.profilePath(name)
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("profile %s already exists", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return config.New().WriteFile(filename)
+}
+
 func (c *Config) checkOption(option string) error {
-	if _, ok := c.flags[option]; !ok {
+	if _, ok := c.flags[option]; ok {
+		return nil
+	}
+	if _, ok := configOptions[option]; !ok {
+		if suggestion, ok := closestOption(option, c.list(true)); ok {
+			return errHint(fmt.Errorf("invalid option: %s", option), "Did you mean '"+suggestion+"'?")
+		}
 		return fmt.Errorf("invalid option: %s", option)
 	}
 	return nil
 }
 
-func (c *Config) printOption(option string) error {
+// closestOption returns the option in options which is closest to option by edit distance, if it is close enough to be
+// a likely typo.
+func closestOption(option string, options []string) (string, bool) {
+	best, bestDistance := "", -1
+	for _, candidate := range options {
+		if d := editDistance(option, candidate); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	maxDistance := max(1, min(3, len(option)/3))
+	return best, bestDistance >= 0 && bestDistance <= maxDistance
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func (c *Config) printOption(w io.Writer, option string, redact bool) error {
 	if err := c.checkOption(option); err != nil {
 		return err
 	}
-	value, ok := c.get(option)
+	value, source, ok := c.lookup(option)
+	var invalid error
+	if ok && source != "" {
+		_, invalid = c.checkValue(option, value)
+	}
+	if redact {
+		value = redactOption(option, value)
+		source = redactHome(source)
+		if invalid != nil {
+			invalid = errors.New(redactHome(invalid.Error()))
+		}
+	}
+	faintColor := color.New(color.FgWhite, color.Faint)
 	if !ok {
-		faintColor := color.New(color.FgWhite, color.Faint)
 		value = faintColor.Sprint("<unset>")
 	} else {
 		value = color.CyanString(value)
 	}
-	log.Printf("%s = %s", option, value)
+	if source != "" {
+		value += faintColor.Sprintf(" (from %s)", source)
+	}
+	if invalid != nil {
+		value += color.RedString(" (invalid: %s)", invalid)
+	}
+	fmt.Fprintf(w, "%s = %s\n", option, value)
 	return nil
 }
 
+// redactedValue replaces the secrets removed by redactOption.
+const redactedValue = "redacted"
+
+// redactOption returns value of option without secrets, such that it can be shared: the values of data plane headers,
+// credentials and query strings of URLs, and the home directory of the user.
+func redactOption(option, value string) string {
+	switch option {
+	case dataPlaneHeadersOption:
+		header, err := parseDataPlaneHeaders(value)
+		if err != nil {
+			return redactedValue
+		}
+		redacted := make(map[string]string, len(header))
+		for name := range header {
+			redacted[name] = redactedValue
+		}
+		data, err := json.Marshal(redacted)
+		if err != nil {
+			return redactedValue
+		}
+		return string(data)
+	case targetFlag:
+		urls := vespa.SplitURLs(value)
+		for i, u := range urls {
+			urls[i] = redactURL(u)
+		}
+		return strings.Join(urls, ",")
+	}
+	return redactHome(value)
+}
+
+// redactURL returns rawURL with any credentials and query string replaced by redactedValue.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	if u.User != nil {
+		u.User = url.User(redactedValue)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedValue
+	}
+	return u.String()
+}
+
+// redactHome replaces the home directory of the user in s by ~, as it usually holds the user name.
+func redactHome(s string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || len(home) < 2 {
+		return s
+	}
+	return strings.ReplaceAll(s, home, "~")
+}
+
 func vespaCliHome(env map[string]string) (string, error) {
 	home := env["VESPA_CLI_HOME"]
 	if home == "" {
@@ -0,0 +1,266 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa config export and import commands
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/config"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// configExport holds exported configuration. Secrets are encoded as base64 in JSON.
+type configExport struct {
+	Config   map[string]string            `json:"config"`
+	Profiles map[string]map[string]string `json:"profiles,omitempty"`
+	Secrets  *configSecrets               `json:"secrets,omitempty"`
+}
+
+// configSecrets holds the credentials of an application.
+type configSecrets struct {
+	Application string `json:"application"`
+	APIKey      []byte `json:"apiKey,omitempty"`
+	Certificate []byte `json:"certificate,omitempty"`
+	PrivateKey  []byte `json:"privateKey,omitempty"`
+}
+
+func newConfigExportCmd(cli *CLI) *cobra.Command {
+	var (
+		format         string
+		includeSecrets bool
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export configuration as a single document",
+		Long: `Export configuration as a single document.
+
+The global configuration options, and the options of all profiles, are printed
+as a single JSON document, which can be imported with 'vespa config import',
+e.g. to bootstrap a CI runner.
+
+With --include-secrets, the API key and the data plane certificate and private
+key of the configured application are also exported, encoded as base64. Keep
+such an export as secret as the keys themselves.`,
+		Example: `$ vespa config export --format json > vespa-config.json
+$ vespa config export --include-secrets > vespa-config.json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			export, err := exportConfig(cli, includeSecrets)
+			if err != nil {
+				return err
+			}
+			return writeJSON(cli, export)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "json", "Output format. Must be 'json'")
+	cmd.Flags().BoolVar(&includeSecrets, "include-secrets", false, "Include the API key and data plane certificate and private key of the configured application")
+	return cmd
+}
+
+func newConfigImportCmd(cli *CLI) *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "import export-file",
+		Short: "Import configuration exported by 'vespa config export'",
+		Long: `Import configuration exported by 'vespa config export'.
+
+The configuration options, profiles and any secrets in the given file, or
+standard input if '-', are written to the configuration directory. Keys are
+written with permissions restricted to the current user.
+
+Existing profiles and credentials are not overwritten, unless --force is given.`,
+		Example: `$ vespa config import vespa-config.json
+$ vespa config export --include-secrets | VESPA_CLI_HOME=/tmp/vespa vespa config import -`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r io.Reader = cli.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			var export configExport
+			if err := json.NewDecoder(r).Decode(&export); err != nil {
+				return fmt.Errorf("invalid configuration export: %w", err)
+			}
+			if err := importConfig(cli, export, force); err != nil {
+				return err
+			}
+			cli.printSuccess("Imported configuration to ", cli.config.homeDir)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing profiles and credentials")
+	return cmd
+}
+
+func configValues(cfg *config.Config) map[string]string {
+	values := make(map[string]string)
+	for _, key := range cfg.Keys() {
+		if v, ok := cfg.Get(key); ok && v != "" {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+func exportConfig(cli *CLI, includeSecrets bool) (configExport, error) {
+	export := configExport{Config: configValues(cli.config.config)}
+	profiles, err := cli.config.listProfiles()
+	if err != nil {
+		return configExport{}, err
+	}
+	for _, name := range profiles {
+		if name == defaultProfile {
+			continue
+		}
+		values, err := cli.config.readProfile(name)
+		if err != nil {
+			return configExport{}, err
+		}
+		if export.Profiles == nil {
+			export.Profiles = make(map[string]map[string]string)
+		}
+		export.Profiles[name] = configValues(values)
+	}
+	if !includeSecrets {
+		return export, nil
+	}
+	app, err := cli.config.application()
+	if err != nil {
+		return configExport{}, errHint(fmt.Errorf("cannot export secrets: %w", err), "Set the application whose secrets to export with --application")
+	}
+	secrets := configSecrets{Application: app.String()}
+	readSecret := func(path string) ([]byte, error) { return readCredential(osFiles{}, path) }
+	if secrets.APIKey, err = readCredential(cli.apiKeyFiles(), cli.config.apiKeyPath(app.Tenant)); err != nil {
+		return configExport{}, err
+	}
+	certPath, err := cli.config.certificatePath(app, vespa.TargetCloud)
+	if err != nil {
+		return configExport{}, err
+	}
+	if secrets.Certificate, err = readSecret(certPath.path); err != nil {
+		return configExport{}, err
+	}
+	keyPath, err := cli.config.privateKeyPath(app, vespa.TargetCloud)
+	if err != nil {
+		return configExport{}, err
+	}
+	if secrets.PrivateKey, err = readSecret(keyPath.path); err != nil {
+		return configExport{}, err
+	}
+	export.Secrets = &secrets
+	return export, nil
+}
+
+func importConfig(cli *CLI, export configExport, force bool) error {
+	for name, values := range export.Profiles {
+		if name == defaultProfile || !profileName.MatchString(name) {
+			return fmt.Errorf("invalid profile name: %q", name)
+		}
+		profile := config.New()
+		for option, value := range values {
+			if !isProfileOption(option) {
+				return fmt.Errorf("invalid option in profile %s: %s", name, option)
+			}
+			profile.Set(option, value)
+		}
+		filename := cli.config.profilePath(name)
+		if err := checkOverwrite(filename, force); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return err
+		}
+		if err := profile.WriteFile(filename); err != nil {
+			return err
+		}
+	}
+	// The default profile is active while importing, so that options are written to the main configuration. The active
+	// profile is set last, as it must exist.
+	for option, value := range export.Config {
+		if option == profileFlag {
+			continue
+		}
+		if err := cli.config.set(option, value); err != nil {
+			return err
+		}
+	}
+	if profile, ok := export.Config[profileFlag]; ok {
+		if err := cli.config.set(profileFlag, profile); err != nil {
+			return err
+		}
+	}
+	if err := cli.config.write(); err != nil {
+		return err
+	}
+	if export.Secrets == nil {
+		return nil
+	}
+	app, err := vespa.ApplicationFromString(export.Secrets.Application)
+	if err != nil {
+		return fmt.Errorf("invalid application of secrets: %w", err)
+	}
+	certPath, err := cli.config.certificatePath(app, vespa.TargetCloud)
+	if err != nil {
+		return err
+	}
+	keyPath, err := cli.config.privateKeyPath(app, vespa.TargetCloud)
+	if err != nil {
+		return err
+	}
+	secrets := []struct {
+		files credentialFiles
+		path  string
+		data  []byte
+	}{
+		{cli.apiKeyFiles(), cli.config.apiKeyPath(app.Tenant), export.Secrets.APIKey},
+		{osFiles{}, certPath.path, export.Secrets.Certificate},
+		{osFiles{}, keyPath.path, export.Secrets.PrivateKey},
+	}
+	for _, secret := range secrets {
+		if secret.data == nil {
+			continue
+		}
+		if existing, err := readCredential(secret.files, secret.path); err != nil {
+			return err
+		} else if existing != nil && !force {
+			return errHint(fmt.Errorf("refusing to overwrite %s", secret.files.Describe(secret.path)), "Use --force to overwrite existing files")
+		}
+		if err := secret.files.WriteFile(secret.path, secret.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCredential reads the credential at path from files, or returns nil if it is not stored.
+func readCredential(files credentialFiles, path string) ([]byte, error) {
+	b, err := files.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func checkOverwrite(filename string, force bool) error {
+	if _, err := os.Stat(filename); err == nil && !force {
+		return errHint(fmt.Errorf("refusing to overwrite %s", filename), "Use --force to overwrite existing files")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestConfigExportImport(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	require.Nil(t, cli.Run("config", "set", "color", "never"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+	require.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	require.Nil(t, cli.Run("config", "profile", "create", "staging"))
+	require.Nil(t, cli.Run("config", "set", "--profile", "staging", "zone", "perf.aws-us-east-1c"))
+	require.Nil(t, cli.Run("config", "get", "--profile", "default", "target")) // Flags persist between runs
+
+	// Secrets are excluded by default
+	stdout.Reset()
+	require.Nil(t, cli.Run("config", "export"))
+	var export configExport
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &export))
+	assert.Equal(t, map[string]string{"target": "cloud", "application": "t1.a1.i1", "color": "never"}, export.Config)
+	assert.Equal(t, map[string]map[string]string{"staging": {"zone": "perf.aws-us-east-1c"}}, export.Profiles)
+	assert.Nil(t, export.Secrets)
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("config", "export", "--include-secrets"))
+	exported := stdout.String()
+	require.Nil(t, json.Unmarshal([]byte(exported), &export))
+	require.NotNil(t, export.Secrets)
+	assert.Equal(t, "t1.a1.i1", export.Secrets.Application)
+	assert.NotEmpty(t, export.Secrets.APIKey)
+	assert.NotEmpty(t, export.Secrets.Certificate)
+	assert.NotEmpty(t, export.Secrets.PrivateKey)
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("config", "export", "--format", "yaml"))
+	assert.Equal(t, "Error: invalid format: yaml\n", stderr.String())
+
+	// Import into a different home and deploy with the imported configuration
+	cli2, stdout2, stderr2 := newTestCLI(t, "NO_COLOR=true")
+	cli2.Stdin = bytes.NewBufferString(exported)
+	require.Nil(t, cli2.Run("config", "import", "-"))
+	assert.Equal(t, "Success: Imported configuration to "+cli2.config.homeDir+"\n", stdout2.String())
+	for _, path := range []string{
+		filepath.Join(cli2.config.homeDir, "t1.api-key.pem"),
+		filepath.Join(cli2.config.homeDir, "t1.a1.i1", "data-plane-public-cert.pem"),
+		filepath.Join(cli2.config.homeDir, "t1.a1.i1", "data-plane-private-key.pem"),
+	} {
+		info, err := os.Stat(path)
+		require.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), path)
+	}
+
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, false)
+	httpClient := &mock.HTTPClient{}
+	httpClient.NextResponseString(200, `ok`)
+	cli2.httpClient = httpClient
+	stdout2.Reset()
+	require.Nil(t, cli2.Run("deploy", "--add-cert", "--wait=0", pkgDir))
+	assert.Contains(t, stdout2.String(), "Success: Triggered deployment")
+
+	// Existing credentials are not overwritten
+	stderr2.Reset()
+	cli2.Stdin = bytes.NewBufferString(exported)
+	require.NotNil(t, cli2.Run("config", "import", "-"))
+	assert.Contains(t, stderr2.String(), "Error: refusing to overwrite")
+	assert.Contains(t, stderr2.String(), "Hint: Use --force to overwrite existing files\n")
+	cli2.Stdin = bytes.NewBufferString(exported)
+	require.Nil(t, cli2.Run("config", "import", "--force", "-"))
+
+	stdout2.Reset()
+	require.Nil(t, cli2.Run("config", "get", "--profile", "staging", "zone"))
+	assert.Contains(t, stdout2.String(), "zone = perf.aws-us-east-1c")
+}
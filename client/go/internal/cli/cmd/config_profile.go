@@ -0,0 +1,123 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa config profile command
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func newConfigProfileCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "profile",
+		Short: "Manage configuration profiles",
+		Long: `Manage configuration profiles.
+
+A profile holds its own target, application, instance, zone and cluster
+options, making it easy to switch between e.g. a local Vespa instance and an
+application in Vespa Cloud. Credentials, such as API keys and certificates, are
+selected by the application of the profile.
+
+Other options, such as color, are shared by all profiles. The "default"
+profile always exists, and holds the options set before any profile was
+created.
+
+The active profile is used by all commands, and can be overridden for a single
+command with the --profile flag. Options set with 'vespa config set' are
+written to the active profile.`,
+		Example: `$ vespa config profile create staging
+$ vespa config profile use staging
+$ vespa config set target cloud
+$ vespa config profile list
+$ vespa query --profile default 'yql=select * from music where true'`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      false,
+		Args:              cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("invalid command: %s", args[0])
+		},
+	}
+}
+
+func newConfigProfileCreateCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:               "create profile-name",
+		Short:             "Create a configuration profile",
+		Example:           "$ vespa config profile create staging",
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cli.config.createProfile(args[0]); err != nil {
+				return err
+			}
+			cli.printSuccess("Created profile ", color.CyanString(args[0]))
+			cli.printInfo("Use 'vespa config profile use ", args[0], "' to make it the active profile")
+			return nil
+		},
+	}
+}
+
+func newConfigProfileUseCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:               "use profile-name",
+		Short:             "Set the active configuration profile",
+		Example:           "$ vespa config profile use staging",
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cli.config.set(profileFlag, args[0]); err != nil {
+				return err
+			}
+			if err := cli.config.write(); err != nil {
+				return err
+			}
+			cli.printSuccess("Switched to profile ", color.CyanString(args[0]))
+			return nil
+		},
+	}
+}
+
+// profileList is the JSON result of config profile list.
+type profileList struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+func newConfigProfileListCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:               "list",
+		Short:             "List configuration profiles",
+		Long:              "List configuration profiles. The active profile is marked with an asterisk.",
+		Example:           "$ vespa config profile list",
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			profiles, err := cli.config.listProfiles()
+			if err != nil {
+				return err
+			}
+			active := cli.config.activeProfile()
+			if cli.jsonOutput() {
+				return cli.printResult(profileList{Active: active, Profiles: profiles})
+			}
+			for _, name := range profiles {
+				if name == active {
+					fmt.Fprintln(cli.Stdout, "*", color.CyanString(name))
+				} else {
+					fmt.Fprintln(cli.Stdout, " ", name)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// isProfileCommand returns whether cmd manages profiles, and should run even if the active profile does not exist.
+func isProfileCommand(cmd *cobra.Command) bool {
+	return cmd.Parent() != nil && cmd.Parent().Name() == "profile"
+}
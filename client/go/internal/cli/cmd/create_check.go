@@ -0,0 +1,94 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Checks of updates which create missing documents
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// createCheckFlags holds the flags choosing whether updates which create missing documents are checked.
+type createCheckFlags struct {
+	warn   bool
+	strict bool
+}
+
+func addCreateCheckFlags(cmd *cobra.Command, flags *createCheckFlags) {
+	cmd.PersistentFlags().BoolVar(&flags.warn, "warn-create", false, "Warn about updates which create missing documents, where fields absent from the update get their default values")
+	cmd.PersistentFlags().BoolVar(&flags.strict, "strict-create", false, "Fail on updates which create missing documents, where fields absent from the update get their default values. Implies --warn-create")
+}
+
+// checker returns the checker configured by these flags, or nil if updates should not be checked.
+func (f createCheckFlags) checker(cli *CLI) *createChecker {
+	if !f.warn && !f.strict {
+		return nil
+	}
+	return &createChecker{deployedDocumentTypes: deployedDocumentTypes{
+		cli:    cli,
+		strict: f.strict,
+		check:  "updates which create missing documents",
+		hint:   "Use --warn-create instead of --strict-create to only warn",
+	}}
+}
+
+// createChecker checks updates which create the document if it does not exist, against the schemas deployed to the
+// current target. A document created by such an update gets default values for all fields absent from the update.
+// The deployed schemas are fetched once, when the first such update is checked.
+type createChecker struct {
+	deployedDocumentTypes
+	// reported holds the document types and absent fields already warned about
+	reported map[string]bool
+}
+
+// check checks doc, which creates its document if create is true, or if it says so itself. An error is returned if doc
+// leaves any field absent, and this is strict. Otherwise, a warning is printed the first time fields of a document type
+// are left absent.
+func (c *createChecker) check(doc document.Document, create bool) error {
+	if c == nil || doc.Operation != document.OperationUpdate || !(create || doc.Create) {
+		return nil
+	}
+	docType, ok, err := c.lookup(doc.Id.Type)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil // Not deployed, so Vespa rejects the update instead
+	}
+	var update struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if doc.Body != nil {
+		if err := json.Unmarshal(doc.Body, &update); err != nil {
+			return fmt.Errorf("invalid update of %s: %w", doc.Id, err)
+		}
+	}
+	var absent []string
+	for _, field := range docType.Fields {
+		if _, ok := update.Fields[field]; !ok {
+			absent = append(absent, field)
+		}
+	}
+	if len(absent) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("update of %s creates the document if it does not exist, with default values for fields absent from the update: %s",
+		doc.Id, strings.Join(absent, ", "))
+	if c.strict {
+		return errHint(errors.New(msg), "Add these fields to the update, or use --warn-create instead of --strict-create to only warn")
+	}
+	key := docType.Name + ":" + strings.Join(absent, ",")
+	if c.reported[key] {
+		return nil
+	}
+	if c.reported == nil {
+		c.reported = make(map[string]bool)
+	}
+	c.reported[key] = true
+	c.cli.printWarning(strings.ToUpper(msg[:1])+msg[1:], "Further updates of document type '"+docType.Name+"' without these fields are not reported")
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestDocumentUpdateCreateCheck(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+
+	update := `{"update": "id:ns:music::a", "create": true, "fields": {"title": {"assign": "A"}}}`
+	mockSchemaFetch(client)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	require.Nil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--warn-create", "--data", update))
+	assert.Equal(t, "Warning: Update of id:ns:music::a creates the document if it does not exist, with default values for fields absent from the update: artist, year\n"+
+		"Hint: Further updates of document type 'music' without these fields are not reported\n", stderr.String())
+	assert.True(t, client.Consumed())
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/ns/music/docid/a?timeout=60000ms&create=true", client.LastRequest.URL.String())
+
+	// Strict checking fails before sending the update
+	mockSchemaFetch(client)
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--strict-create", "--data", update))
+	assert.Equal(t, "Error: update of id:ns:music::a creates the document if it does not exist, with default values for fields absent from the update: artist, year\n"+
+		"Hint: Add these fields to the update, or use --warn-create instead of --strict-create to only warn\n", stderr.String())
+	assert.True(t, client.Consumed())
+	assert.Len(t, client.Requests, 9)
+
+	// Updates which set all fields, or do not create documents, pass
+	mockSchemaFetch(client)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	stderr.Reset()
+	require.Nil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--strict-create", "--data",
+		`{"update": "id:ns:music::a", "create": true, "fields": {"title": {"assign": "A"}, "artist": {"assign": "B"}, "year": {"assign": 2000}}}`))
+	assert.Equal(t, "", stderr.String())
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	require.Nil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--strict-create", "--data",
+		`{"update": "id:ns:music::a", "fields": {"title": {"assign": "A"}}}`))
+	assert.Equal(t, "", stderr.String())
+	assert.True(t, client.Consumed())
+
+	// Schemas which cannot be fetched fail strict checking only
+	client.NextResponseString(404, `{"error-code": "NOT_FOUND"}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--warn-create", "--data", update))
+	assert.Equal(t, "Warning: Could not check updates which create missing documents against the deployed schemas: no application package is deployed\n", stderr.String())
+	client.NextResponseString(404, `{"error-code": "NOT_FOUND"}`)
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("document", "update", "-t", "http://127.0.0.1:8080", "--strict-create", "--data", update))
+	assert.Equal(t, "Error: could not check updates which create missing documents against the deployed schemas: no application package is deployed\n"+
+		"Hint: Use --warn-create instead of --strict-create to only warn\n", stderr.String())
+	assert.True(t, client.Consumed())
+}
+
+func TestFeedCreateCheck(t *testing.T) {
+	td := t.TempDir()
+	jsonFile := filepath.Join(td, "docs.jsonl")
+	require.Nil(t, os.WriteFile(jsonFile, []byte(`{"update": "id:ns:music::a", "fields": {"title": {"assign": "A"}}}
+{"update": "id:ns:music::b", "fields": {"title": {"assign": "B"}}}
+{"update": "id:ns:music::c", "fields": {"title": {"assign": "C"}, "year": {"assign": 2000}}}
+{"put": "id:ns:music::d", "fields": {"title": "D"}}
+`), 0644))
+
+	// Schemas are fetched once, and each document type and set of absent fields is reported once
+	cli, _, stderr := newTestCLI(t)
+	client := cli.httpClient.(*mock.HTTPClient)
+	queueFeedBlockCheck(client)
+	mockSchemaFetch(client)
+	for range 4 {
+		client.NextResponseString(200, `{"message":"OK"}`)
+	}
+	require.Nil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--inflight", "1", "--create", "--warn-create", jsonFile))
+	assert.Equal(t, "Warning: Update of id:ns:music::a creates the document if it does not exist, with default values for fields absent from the update: artist, year\n"+
+		"Hint: Further updates of document type 'music' without these fields are not reported\n"+
+		"Warning: Update of id:ns:music::c creates the document if it does not exist, with default values for fields absent from the update: artist\n"+
+		"Hint: Further updates of document type 'music' without these fields are not reported\n", stderr.String())
+	assert.Len(t, client.Requests, 9)
+
+	// Feeding stops at the first such update when strict
+	cli, _, stderr = newTestCLI(t)
+	client = cli.httpClient.(*mock.HTTPClient)
+	queueFeedBlockCheck(client)
+	mockSchemaFetch(client)
+	assert.NotNil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--create", "--strict-create", jsonFile))
+	assert.Equal(t, "Error: update of id:ns:music::a creates the document if it does not exist, with default values for fields absent from the update: artist, year\n"+
+		"Hint: Add these fields to the update, or use --warn-create instead of --strict-create to only warn\n", stderr.String())
+	assert.Len(t, client.Requests, 5)
+}
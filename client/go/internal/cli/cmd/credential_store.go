@@ -0,0 +1,171 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Storage of API keys and refresh tokens, in files or in the credential store of the operating system
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+const (
+	// credentialStoreFile stores API keys in files, and refresh tokens as chosen at login
+	credentialStoreFile = "file"
+	// credentialStoreKeychain stores API keys and refresh tokens in the credential store of the operating system
+	credentialStoreKeychain = "keychain"
+)
+
+// credentialStore returns the name of the configured credential store.
+func (c *Config) credentialStore() string {
+	store, _ := c.get(credentialStoreOption)
+	return store
+}
+
+// credentialStoreDescription returns a human-readable description of the configured credential store.
+func (c *Config) credentialStoreDescription() string {
+	if c.credentialStore() == credentialStoreKeychain {
+		return "keychain (the credential store of the operating system)"
+	}
+	return "file"
+}
+
+// refreshTokenStore returns the store of refresh tokens. With the keychain credential store, this is the credential
+// store of the operating system, and refresh tokens stored in files, by logging in with --file-storage, are moved
+// there when first read.
+func (c *CLI) refreshTokenStore() auth.SecretStore {
+	if c.config.credentialStore() != credentialStoreKeychain {
+		return auth.NewKeyring()
+	}
+	return auth.NewMigratingStore(auth.NewSystemKeyring(), auth.NewFileKeyring(), func(namespace, system string) {
+		c.printInfo("Moved refresh token of system ", color.CyanString(system), " to the credential store of the operating system")
+	})
+}
+
+// auth0Options returns the options of an Auth0 client for given system.
+func (c *CLI) auth0Options(system vespa.System) auth0.Options {
+	return auth0.Options{ConfigPath: c.config.authConfigPath(), SystemName: system.Name, SystemURL: system.URL, Secrets: c.refreshTokenStore()}
+}
+
+// credentialFiles reads and writes credentials which are otherwise stored as files, such as API keys. Each credential
+// is given by the path of its file. Reading a credential which is not stored fails with an error satisfying
+// os.IsNotExist.
+type credentialFiles interface {
+	ReadFile(path string) ([]byte, error)
+	// WriteFile writes data, which is only readable by the user
+	WriteFile(path string, data []byte) error
+	Rename(oldPath, newPath string) error
+	Remove(path string) error
+	// Describe describes where the credential given by path is stored
+	Describe(path string) string
+}
+
+// apiKeyFiles returns the storage of API keys. With the keychain credential store, API keys are stored in the
+// credential store of the operating system, unless VESPA_CLI_API_KEY_FILE is set.
+func (c *CLI) apiKeyFiles() credentialFiles {
+	if _, ok := c.config.apiKeyFileFromEnv(); ok || c.config.credentialStore() != credentialStoreKeychain {
+		return osFiles{}
+	}
+	return &keychainFiles{store: auth.NewSystemKeyring(), migrated: func(path string) {
+		c.printInfo("Moved '", path, "' to the credential store of the operating system")
+	}}
+}
+
+// describeAPIKeySource returns a human-readable description of where the API key of tenant is read from.
+func (c *CLI) describeAPIKeySource(tenantName string) string {
+	source := c.config.apiKeySource(tenantName)
+	if c.config.isEnvSource(source) {
+		return c.config.describeSource(source)
+	}
+	return "from " + c.apiKeyFiles().Describe(source)
+}
+
+// osFiles stores credentials in files.
+type osFiles struct{}
+
+func (osFiles) ReadFile(path string) ([]byte, error) { return os.ReadFile(path) }
+
+func (osFiles) WriteFile(path string, data []byte) error {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600) // WriteFile keeps the permissions of an existing file
+}
+
+func (osFiles) Rename(oldPath, newPath string) error { return os.Rename(oldPath, newPath) }
+
+func (osFiles) Remove(path string) error { return os.Remove(path) }
+
+func (osFiles) Describe(path string) string { return "'" + path + "'" }
+
+// keychainFiles stores credentials in a secret store, keyed by the base name of their file. A credential which is not
+// in the store, but in its file, is moved to the store when it is read, and migrated is then called.
+type keychainFiles struct {
+	store    auth.SecretStore
+	migrated func(path string)
+}
+
+func (k *keychainFiles) key(path string) string { return filepath.Base(path) }
+
+func (k *keychainFiles) ReadFile(path string) ([]byte, error) {
+	value, err := k.store.Get(auth.SecretsNamespace, k.key(path))
+	if err == nil {
+		return []byte(value), nil
+	}
+	if !auth.IsNotFound(err) {
+		return nil, fmt.Errorf("could not read %s: %w", k.Describe(path), err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: k.Describe(path), Err: fs.ErrNotExist}
+	}
+	if err := k.store.Set(auth.SecretsNamespace, k.key(path), string(data)); err != nil {
+		return nil, fmt.Errorf("could not move '%s' to the credential store of the operating system: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	if k.migrated != nil {
+		k.migrated(path)
+	}
+	return data, nil
+}
+
+func (k *keychainFiles) WriteFile(path string, data []byte) error {
+	if err := k.store.Set(auth.SecretsNamespace, k.key(path), string(data)); err != nil {
+		return fmt.Errorf("could not write %s: %w", k.Describe(path), err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (k *keychainFiles) Rename(oldPath, newPath string) error {
+	data, err := k.ReadFile(oldPath)
+	if err != nil {
+		return err
+	}
+	if err := k.WriteFile(newPath, data); err != nil {
+		return err
+	}
+	return k.Remove(oldPath)
+}
+
+func (k *keychainFiles) Remove(path string) error {
+	err := k.store.Delete(auth.SecretsNamespace, k.key(path))
+	if auth.IsNotFound(err) {
+		return os.Remove(path)
+	}
+	return err
+}
+
+func (k *keychainFiles) Describe(path string) string {
+	return "'" + k.key(path) + "' in the credential store of the operating system"
+}
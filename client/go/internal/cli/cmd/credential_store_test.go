@@ -0,0 +1,72 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/zalando/go-keyring"
+)
+
+func TestCredentialStoreKeychain(t *testing.T) {
+	keyring.MockInit()
+	t.Setenv("HOME", t.TempDir()) // Refresh tokens in files are stored in the home directory
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	require.NotNil(t, cli.Run("config", "set", "credential-store", "vault"))
+	assert.Equal(t, "Error: invalid value for credential-store: \"vault\"\nHint: Must be \"file\" or \"keychain\"\n", stderr.String())
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+
+	// An existing API key is moved to the credential store when first used
+	require.Nil(t, cli.Run("auth", "api-key"))
+	apiKeyFile := cli.config.apiKeyPath("t1")
+	apiKey, err := os.ReadFile(apiKeyFile)
+	require.Nil(t, err)
+	require.Nil(t, cli.Run("config", "set", "credential-store", "keychain"))
+	httpClient := &mock.HTTPClient{}
+	httpClient.NextResponseString(200, `{"user":{"email":"foo@bar"}}`)
+	cli.httpClient = httpClient
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("auth", "show"))
+	assert.Contains(t, stderr.String(), "Moved '"+apiKeyFile+"' to the credential store of the operating system\n")
+	assert.Equal(t, "Success: Logged in as: foo@bar\n"+
+		"Authenticated with: API key from 't1.api-key.pem' in the credential store of the operating system\n"+
+		"Credential store: keychain (the credential store of the operating system)\n", stdout.String())
+	_, err = os.Stat(apiKeyFile)
+	assert.True(t, os.IsNotExist(err))
+	stored, err := keyring.Get(auth.SecretsNamespace, "t1.api-key.pem")
+	require.Nil(t, err)
+	assert.Equal(t, string(apiKey), stored)
+	signed, err := cli.config.readAPIKey(cli, "t1")
+	require.Nil(t, err)
+	assert.Equal(t, apiKey, signed)
+
+	// New keys are written to the credential store
+	stdout.Reset()
+	require.NotNil(t, cli.Run("auth", "api-key"))
+	require.Nil(t, cli.Run("auth", "api-key", "-f"))
+	assert.Contains(t, stdout.String(), "Success: Developer private key for tenant t1 written to 't1.api-key.pem' in the credential store of the operating system\n")
+	_, err = os.Stat(apiKeyFile)
+	assert.True(t, os.IsNotExist(err))
+	stored, err = keyring.Get(auth.SecretsNamespace, "t1.api-key.pem")
+	require.Nil(t, err)
+	assert.NotEqual(t, string(apiKey), stored)
+
+	// Refresh tokens cannot be stored in files
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "login", "--file-storage"))
+	assert.Equal(t, "Error: cannot store the refresh token in files with credential store keychain\n"+
+		"Hint: Omit --file-storage, or run 'vespa config set credential-store file' to use files\n", stderr.String())
+	system, err := cli.system(vespa.TargetCloud)
+	require.Nil(t, err)
+	require.Nil(t, cli.refreshTokenStore().Set(auth.SecretsNamespace, system.Name, "refresh-token"))
+	token, err := keyring.Get(auth.SecretsNamespace, system.Name)
+	require.Nil(t, err)
+	assert.Equal(t, "refresh-token", token)
+}
@@ -2,20 +2,31 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/curl"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 func newCurlCmd(cli *CLI) *cobra.Command {
 	var (
-		waitSecs int
-		dryRun   bool
+		waitSecs     int
+		dryRun       bool
+		serviceName  string
+		listServices bool
+		opts         curlOptions
 	)
 	cmd := &cobra.Command{
 		Use:   "curl [curl-options] path",
@@ -24,33 +35,86 @@ func newCurlCmd(cli *CLI) *cobra.Command {
 
 Execute curl with the appropriate URL, certificate and private key for your application.
 
+The request is sent to the container cluster given by --service, or by the
+cluster option if --service is not given. The service may also be 'deploy',
+the deploy API of the target. Requests to container clusters are authenticated
+with the data plane credentials chosen by --auth or the data-plane-auth
+option. Use --list-services to show the services of the target.
+
+The request may instead be sent by the CLI itself, without curl, by using any
+of the --request, --header, --data, --max-time, --retry and --include flags.
+These can not be combined with curl options. The request body given with
+--data is read from a file with @file, or from standard input with @-. Failed
+requests are retried on connection errors and 5xx responses, but only for
+idempotent methods.
+
 For a more high-level interface to query and feeding, see the 'query' and 'document' commands.
 `,
 		Example: `$ vespa curl /ApplicationStatus
 $ vespa curl -- -X POST -H "Content-Type:application/json" --data-binary @src/test/resources/A-Head-Full-of-Dreams.json /document/v1/namespace/music/docid/1
-$ vespa curl -- -v --data-urlencode "yql=select * from music where album contains 'head'" /search/\?hits=5`,
+$ vespa curl -- -v --data-urlencode "yql=select * from music where album contains 'head'" /search/\?hits=5
+$ vespa curl -X PUT -d @src/test/resources/A-Head-Full-of-Dreams.json --retry 3 /document/v1/namespace/music/docid/1
+$ cat query.json | vespa curl -d @- --max-time 10 --include /search/
+$ vespa curl --service my-handlers /my/handler
+$ vespa curl --list-services`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
-		Args:              cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			target, err := cli.target(targetOptions{})
-			if err != nil {
-				return err
+		Args: func(cmd *cobra.Command, args []string) error {
+			if listServices {
+				return cobra.NoArgs(cmd, args)
 			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			service, err := waiter.Service(target, cli.config.cluster())
+			if listServices {
+				if serviceName != "" {
+					return fmt.Errorf("options --service and --list-services cannot be combined")
+				}
+				return listCurlServices(cli, waiter)
+			}
+			service, authMethod, err := curlService(cli, waiter, serviceName)
 			if err != nil {
 				return err
 			}
+			opts.authMethod = authMethod
 			url := joinURL(service.BaseURL, args[len(args)-1])
 			rawArgs := args[:len(args)-1]
+			native := false
+			for _, name := range []string{"request", "header", "data", "max-time", "retry", "include"} {
+				native = native || cmd.Flags().Changed(name)
+			}
+			if native {
+				if len(rawArgs) > 0 {
+					return errHint(fmt.Errorf("curl options can not be combined with --request, --header, --data, --max-time, --retry or --include"),
+						"Pass all options to curl after --, or none")
+				}
+				if dryRun {
+					c, err := opts.curlCommand(cli, url)
+					if err != nil {
+						return err
+					}
+					log.Print(c.String())
+					return nil
+				}
+				cli.disableRetries() // Retries are controlled by --retry
+				return opts.send(cli, service, url)
+			}
 			c, err := curl.RawArgs(url, rawArgs...)
 			if err != nil {
 				return err
 			}
 			c.CaCertificate = service.TLSOptions.CACertificateFile
-			c.PrivateKey = service.TLSOptions.PrivateKeyFile
-			c.Certificate = service.TLSOptions.CertificateFile
+			if authMethod == "token" {
+				token, _, err := cli.dataPlaneToken()
+				if err != nil {
+					return err
+				}
+				c.Header("Authorization", "Bearer "+token)
+			} else {
+				c.PrivateKey = service.TLSOptions.PrivateKeyFile
+				c.Certificate = service.TLSOptions.CertificateFile
+			}
 			if dryRun {
 				log.Print(c.String())
 			} else {
@@ -62,12 +126,301 @@ $ vespa curl -- -v --data-urlencode "yql=select * from music where album contain
 		},
 	}
 	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Print the curl command that would be executed")
+	cmd.Flags().StringVarP(&opts.method, "request", "X", "", "The HTTP method to use. Default is POST with --data, and GET otherwise")
+	cmd.Flags().StringArrayVarP(&opts.headers, "header", "H", nil, "Add a header to the request, on the form 'Name: value'. May be repeated")
+	cmd.Flags().StringVarP(&opts.data, "data", "d", "", "The request body. Use @file to read it from a file, or @- to read it from standard input")
+	cmd.Flags().Float64Var(&opts.maxTime, "max-time", 0, "Maximum time in seconds to wait for each attempt of the request. 0 means no limit")
+	cmd.Flags().IntVar(&opts.retries, "retry", 0, "Number of times to retry the request on connection errors and 5xx responses, for idempotent methods")
+	cmd.Flags().BoolVar(&opts.include, "include", false, "Print the response status and headers before the body")
+	cmd.Flags().StringVarP(&serviceName, "service", "s", "", "The service to send the request to: the name of a container cluster, or 'deploy'. Defaults to the cluster option")
+	cmd.Flags().BoolVar(&listServices, "list-services", false, "List the services requests can be sent to")
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
 	return cmd
 }
 
+// curlServiceDeploy is the name of the deploy API in the service flag of vespa curl.
+const curlServiceDeploy = "deploy"
+
+// curlService returns the service of given name, or the cluster given by the cluster option if name is empty, and the
+// method used to authenticate requests to it. The authentication method is empty for the deploy API.
+func curlService(cli *CLI, waiter *Waiter, name string) (*vespa.Service, string, error) {
+	if name == curlServiceDeploy {
+		target, err := cli.target(targetOptions{})
+		if err != nil {
+			return nil, "", err
+		}
+		service, err := target.DeployService()
+		return service, "", err
+	}
+	authMethod := cli.selectAuthMethod()
+	target, err := cli.target(targetOptions{noCertificate: authMethod == "token"})
+	if err != nil {
+		return nil, "", err
+	}
+	if name == "" {
+		service, err := waiter.ServiceWithAuthMethod(target, cli.config.cluster(), authMethod)
+		return service, authMethod, err
+	}
+	services, err := waiter.services(target)
+	if err != nil {
+		return nil, "", err
+	}
+	names := curlServiceNames(services)
+	if !slices.Contains(names, name) {
+		return nil, "", errHint(fmt.Errorf("no such service: %q", name), "Valid services are "+strings.Join(names, ", "), "Run 'vespa curl --list-services' to show the URL of each service")
+	}
+	for _, s := range services {
+		if s.Name == name && (s.AuthMethod == authMethod || s.AuthMethod == "") {
+			return s, authMethod, waiter.maybeWaitFor(s)
+		}
+	}
+	return nil, "", errHint(fmt.Errorf("service %s has no endpoint for %s", name, authMethodDescription(authMethod)), "Use --auth to choose another authentication method")
+}
+
+// curlServiceNames returns the names of the services vespa curl can send requests to, given the container services of
+// the target.
+func curlServiceNames(services []*vespa.Service) []string {
+	names := []string{curlServiceDeploy}
+	for _, s := range services {
+		if s.Name != "" && !slices.Contains(names, s.Name) {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// curlServiceEntry is a service listed by vespa curl --list-services.
+type curlServiceEntry struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	AuthMethod string `json:"authMethod,omitempty"`
+}
+
+// listCurlServices prints the services of the target, which requests can be sent to.
+func listCurlServices(cli *CLI, waiter *Waiter) error {
+	authMethod := cli.selectAuthMethod()
+	target, err := cli.target(targetOptions{noCertificate: authMethod == "token"})
+	if err != nil {
+		return err
+	}
+	deployService, err := target.DeployService()
+	if err != nil {
+		return err
+	}
+	entries := []curlServiceEntry{{Name: curlServiceDeploy, URL: deployService.BaseURL}}
+	services, err := waiter.services(target)
+	if err != nil {
+		return err
+	}
+	for _, s := range services {
+		entries = append(entries, curlServiceEntry{Name: s.Name, URL: s.BaseURL, AuthMethod: s.AuthMethod})
+	}
+	if cli.jsonOutput() {
+		return cli.printResult(entries)
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tURL\tAUTH")
+	for _, e := range entries {
+		name, auth := e.Name, e.AuthMethod
+		if name == "" {
+			name = "-"
+		}
+		if auth == "" {
+			auth = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, e.URL, auth)
+	}
+	return w.Flush()
+}
+
 func joinURL(baseURL, path string) string {
 	baseURL = strings.TrimSuffix(baseURL, "/")
 	path = strings.TrimPrefix(path, "/")
 	return baseURL + "/" + path
 }
+
+// curlOptions are the options for requests sent by vespa curl itself.
+type curlOptions struct {
+	method  string
+	headers []string
+	data    string
+	maxTime float64
+	retries int
+	include bool
+	// authMethod is the method used to authenticate with the data plane, or empty if the request goes elsewhere
+	authMethod string
+}
+
+func (o *curlOptions) httpMethod() string {
+	if o.method != "" {
+		return strings.ToUpper(o.method)
+	}
+	if o.data != "" {
+		return "POST"
+	}
+	return "GET"
+}
+
+func (o *curlOptions) header(cli *CLI) (http.Header, error) {
+	header := make(http.Header)
+	for _, h := range o.headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header '%s': must be on the form 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if o.data != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	if o.authMethod == "token" {
+		if err := cli.addBearerToken(&header); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// body returns the request body, read from a file or standard input if the data option is @file or @-.
+func (o *curlOptions) body(cli *CLI) ([]byte, error) {
+	fileName, ok := strings.CutPrefix(o.data, "@")
+	if !ok {
+		return []byte(o.data), nil
+	}
+	if fileName == "-" {
+		data, err := io.ReadAll(cli.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("could not read request body from standard input: %w", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %w", err)
+	}
+	return data, nil
+}
+
+// curlCommand returns the curl command equivalent to sending the request of these options.
+func (o *curlOptions) curlCommand(cli *CLI, url string) (*curl.Command, error) {
+	var rawArgs []string
+	if o.retries > 0 {
+		rawArgs = append(rawArgs, "--retry", strconv.Itoa(o.retries))
+	}
+	if o.include {
+		rawArgs = append(rawArgs, "-i")
+	}
+	fileName, fromFile := strings.CutPrefix(o.data, "@")
+	if o.data != "" && !fromFile {
+		rawArgs = append(rawArgs, "--data-binary", o.data)
+	}
+	c, err := curl.RawArgs(url, rawArgs...)
+	if err != nil {
+		return nil, err
+	}
+	if o.method != "" || o.data != "" {
+		c.Method = o.httpMethod()
+	}
+	c.Timeout = time.Duration(o.maxTime * float64(time.Second))
+	header, err := o.header(cli)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		for _, value := range values {
+			c.Header(name, value)
+		}
+	}
+	if fromFile {
+		if fileName == "-" {
+			c.WithBodyInput(cli.Stdin)
+		} else {
+			c.WithBodyFile(fileName)
+		}
+	}
+	return c, nil
+}
+
+// send sends the request of these options to url of service, and prints the response.
+func (o *curlOptions) send(cli *CLI, service *vespa.Service, url string) error {
+	if o.retries < 0 {
+		return fmt.Errorf("invalid retry: %d: must be at least 0", o.retries)
+	}
+	if o.maxTime < 0 {
+		return fmt.Errorf("invalid max-time: %g: must be at least 0", o.maxTime)
+	}
+	body, err := o.body(cli)
+	if err != nil {
+		return err
+	}
+	header, err := o.header(cli)
+	if err != nil {
+		return err
+	}
+	method := o.httpMethod()
+	retries := 0
+	if isIdempotent(method) {
+		retries = o.retries
+	}
+	timeout := time.Duration(o.maxTime * float64(time.Second))
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header = header.Clone()
+		response, err := service.Do(request, timeout)
+		var problem string
+		if err != nil {
+			problem = err.Error()
+		} else if response.StatusCode/100 == 5 {
+			problem = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+		}
+		if problem != "" && attempt < retries {
+			if response != nil {
+				response.Body.Close()
+			}
+			cli.printWarning(fmt.Sprintf("request failed: %s. Retrying in %s, %d retries left", problem, cli.retryInterval, retries-attempt))
+			if err := cli.sleep(cli.retryInterval); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+		defer response.Body.Close()
+		if o.include {
+			printResponseHeader(cli.Stdout, response)
+		}
+		_, err = io.Copy(cli.Stdout, response.Body)
+		return err
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+func printResponseHeader(w io.Writer, response *http.Response) {
+	proto := response.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	fmt.Fprintf(w, "%s %d %s\r\n", proto, response.StatusCode, http.StatusText(response.StatusCode))
+	names := make([]string, 0, len(response.Header))
+	for name := range response.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range response.Header[name] {
+			fmt.Fprintf(w, "%s: %s\r\n", name, value)
+		}
+	}
+	fmt.Fprint(w, "\r\n")
+}
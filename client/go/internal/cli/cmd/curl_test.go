@@ -2,11 +2,15 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
 )
 
 func TestCurl(t *testing.T) {
@@ -26,3 +30,87 @@ func TestCurl(t *testing.T) {
 		filepath.Join(cli.config.homeDir, "t1.a1.i1", "data-plane-public-cert.pem"))
 	assert.Equal(t, expected, stdout.String())
 }
+
+func TestCurlService(t *testing.T) {
+	setup := func() (*CLI, *bytes.Buffer, *bytes.Buffer) {
+		cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+		cli.Environment["VESPA_CLI_ENDPOINTS"] = `{"endpoints":[{"cluster":"container","url":"http://127.0.0.1:8080"},{"cluster":"handlers","url":"http://127.0.0.1:8081"}]}`
+		require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+		require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+		require.Nil(t, cli.Run("auth", "api-key"))
+		require.Nil(t, cli.Run("auth", "cert", "--no-add"))
+		stdout.Reset()
+		stderr.Reset()
+		return cli, stdout, stderr
+	}
+
+	cli, stdout, _ := setup()
+	assert.Nil(t, cli.Run("curl", "--list-services"))
+	assert.Equal(t, `SERVICE    URL                                   AUTH
+deploy     https://api-ctl.vespa-cloud.com:4443  -
+container  http://127.0.0.1:8080                 mtls
+container  http://127.0.0.1:8080                 token
+handlers   http://127.0.0.1:8081                 mtls
+handlers   http://127.0.0.1:8081                 token
+`, stdout.String())
+
+	cli, stdout, _ = setup()
+	assert.Nil(t, cli.Run("curl", "-n", "--service", "handlers", "/my/handler"))
+	assert.Equal(t, fmt.Sprintf("curl --key %s --cert %s http://127.0.0.1:8081/my/handler\n",
+		filepath.Join(cli.config.homeDir, "t1.a1.i1", "data-plane-private-key.pem"),
+		filepath.Join(cli.config.homeDir, "t1.a1.i1", "data-plane-public-cert.pem")), stdout.String())
+
+	// Token authentication sends the token instead of the certificate
+	cli, stdout, _ = setup()
+	cli.Environment["VESPA_CLI_DATA_PLANE_TOKEN"] = "secret"
+	assert.Nil(t, cli.Run("curl", "-n", "--service", "handlers", "/my/handler"))
+	assert.Equal(t, "curl -H 'Authorization: Bearer secret' http://127.0.0.1:8081/my/handler\n", stdout.String())
+
+	cli, _, stderr := setup()
+	assert.NotNil(t, cli.Run("curl", "--service", "nope", "/my/handler"))
+	assert.Equal(t, "Error: no such service: \"nope\"\nHint: Valid services are deploy, container, handlers\nHint: Run 'vespa curl --list-services' to show the URL of each service\n", stderr.String())
+}
+
+func TestCurlNative(t *testing.T) {
+	client := &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(503, "unavailable")
+	client.NextResponse(mock.HTTPResponse{Status: 200, Body: []byte(`{"ok":true}`), Header: map[string][]string{"X-Foo": {"bar"}}})
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.retryInterval = 0
+	bodyFile := filepath.Join(t.TempDir(), "doc.json")
+	require.Nil(t, os.WriteFile(bodyFile, []byte(`{"fields":{}}`), 0644))
+
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "curl", "-X", "put", "-d", "@"+bodyFile, "-H", "X-Bar: baz", "--retry", "2", "--include", "/document/v1/ns/music/docid/1"))
+	assert.Equal(t, "Warning: request failed: 503 Service Unavailable. Retrying in 0s, 2 retries left\n", stderr.String())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nX-Foo: bar\r\n\r\n{\"ok\":true}", stdout.String())
+	require.Len(t, client.Requests, 2)
+	assert.Equal(t, "PUT", client.LastRequest.Method)
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/ns/music/docid/1", client.LastRequest.URL.String())
+	assert.Equal(t, "baz", client.LastRequest.Header.Get("X-Bar"))
+	assert.Equal(t, "application/json", client.LastRequest.Header.Get("Content-Type"))
+	assert.Equal(t, `{"fields":{}}`, string(client.LastBody))
+
+	// POST is not retried, and reads body from stdin
+	client = &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(503, "unavailable")
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString(`{"yql":"select * from music where true"}`)
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "curl", "-d", "@-", "--retry", "2", "/search/"))
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "unavailable", stdout.String())
+	require.Len(t, client.Requests, 1)
+	assert.Equal(t, "POST", client.LastRequest.Method)
+	assert.Equal(t, `{"yql":"select * from music where true"}`, string(client.LastBody))
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "curl", "--retry", "2", "--", "-v", "/search/"))
+	assert.Contains(t, stderr.String(), "curl options can not be combined with")
+}
+
+func TestCurlNativeDryRun(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t)
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "curl", "-n", "-d", `{"foo":1}`, "--retry", "3", "--max-time", "5", "/document/v1/ns/music/docid/1"))
+	assert.Equal(t, "curl -X POST -m 5 -H 'Content-Type: application/json' --retry 3 --data-binary '{\"foo\":1}' http://127.0.0.1:8080/document/v1/ns/music/docid/1\n", stdout.String())
+}
@@ -0,0 +1,69 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// showSecretsFlag is the flag showing the values of headers given by the header flag, or the data-plane-headers option,
+// in verbose and trace output.
+const showSecretsFlag = "show-secrets"
+
+// reservedHeaders are the headers Vespa CLI sets itself on requests to the data plane, which cannot be given by the
+// header flag or the data-plane-headers option. Authorization is set with token authentication only, and is rejected
+// by addBearerToken then.
+var reservedHeaders = []string{"Content-Type"}
+
+// checkDataPlaneHeader returns an error if the header of given name cannot be added to requests to the data plane.
+func checkDataPlaneHeader(name string) error {
+	for _, reserved := range reservedHeaders {
+		if http.CanonicalHeaderKey(name) == reserved {
+			return errHint(fmt.Errorf("header '%s' cannot be set, as it is set by Vespa CLI", reserved), "Remove the header from --header, or from the "+dataPlaneHeadersOption+" option")
+		}
+	}
+	return nil
+}
+
+// dataPlaneHeader returns the header of requests to the data plane, holding the headers of the data-plane-headers
+// option, and those given by the header flag values headers, which replace any of the same name. With token
+// authentication, the bearer token is added. The values of the option and flag headers are redacted from curl commands
+// printed in verbose mode, and from the trace file, unless the show-secrets flag is set.
+func (cli *CLI) dataPlaneHeader(headers []string, authMethod string) (http.Header, error) {
+	header, err := cli.config.dataPlaneHeaders()
+	if err != nil {
+		return nil, err
+	}
+	flagHeader, err := httputil.ParseHeader(headers)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(header, flagHeader)
+	for name := range header {
+		if err := checkDataPlaneHeader(name); err != nil {
+			return nil, err
+		}
+	}
+	if !cli.showSecrets {
+		cli.secretHeaders = slices.Sorted(maps.Keys(header))
+		if cli.tracer != nil {
+			cli.tracer.RedactHeaders(cli.secretHeaders...)
+		}
+	}
+	if authMethod == "token" {
+		if err := cli.addBearerToken(&header); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// curlWriter returns a writer of the curl commands equivalent to requests to the data plane, to standard error.
+func (cli *CLI) curlWriter() vespa.CurlWriter {
+	return vespa.CurlWriter{Writer: cli.Stderr, Redact: cli.secretHeaders}
+}
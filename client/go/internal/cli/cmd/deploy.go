@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -18,12 +19,42 @@ import (
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
+// deployResult is the JSON result of deploy.
+type deployResult struct {
+	Path       string           `json:"path"`
+	RunID      int64            `json:"runId,omitempty"`
+	SessionID  int64            `json:"sessionId,omitempty"`
+	ConsoleURL string           `json:"consoleUrl,omitempty"`
+	Digest     string           `json:"digest,omitempty"`
+	Endpoints  []deployEndpoint `json:"endpoints,omitempty"`
+	Steps      []stepResult     `json:"steps,omitempty"`
+
+	ConfigChangeActions *vespa.ConfigChangeActions `json:"configChangeActions,omitempty"`
+}
+
+type deployEndpoint struct {
+	Cluster string `json:"cluster"`
+	URL     string `json:"url"`
+}
+
 func newDeployCmd(cli *CLI) *cobra.Command {
 	var (
 		waitSecs    int
 		logLevelArg string
 		versionArg  string
 		copyCert    bool
+		noRestart   bool
+		excludes    []string
+		remote      remotePackageOptions
+		showDiff    bool
+		diffContext bool
+		confirm     bool
+		printDigest bool
+		noDetect    bool
+		sets        []string
+		dryRun      bool
+
+		validationOverrides []string
 	)
 	cmd := &cobra.Command{
 		Use:   "deploy [application-directory-or-file]",
@@ -43,27 +74,112 @@ completed.
 
 If application directory is not specified, it defaults to working directory.
 
+The application package can also be given as an https:// URL, or as a Maven
+coordinate on the form groupId:artifactId:version:zip, e.g. when it's published
+to an artifact repository. The application package is then downloaded to a
+temporary file before it's deployed. Use --header to pass authentication
+headers, and --sha256 to verify the checksum of the downloaded file.
+
+Files matching the patterns in a .vespaignore file at the root of the
+application package are not included when deploying a directory. The file uses
+the same syntax as .gitignore. Additional patterns can be given with --exclude.
+
+` + templateHelp + `
+
+The progress of uploads which take a while, e.g. of application packages with
+large models, is shown as a progress bar with the bytes sent, transfer rate and
+estimated time left. When standard error is not a terminal, a line of progress
+is printed every 10 seconds instead. No progress is shown with --quiet or when
+printing JSON.
+
+With --wait, deploy blocks until the deployment has converged and its container
+services respond on /status.html, and prints the endpoints of the services. In
+Vespa Cloud this follows the deployment run, while for self-hosted targets the
+config server is polled until all services have converged on the new config
+generation. The wait time is given in seconds or as a duration, e.g. 10m. If
+the deployment does not become ready in time, deploy fails with the last known
+state.
+
+When deploying to a self-hosted Vespa, any config change actions returned by the
+config server are printed, i.e. services which must be restarted and document
+types which must be re-fed for the change to take effect. The
+--require-no-restart flag makes deploy fail instead of activating the
+application package when any restart or re-feed is required. This is useful as
+a gate in continuous integration.
+
+Log messages returned by the config server are printed too: warnings and errors
+when the deployment succeeds, and all messages when it fails. Use --verbose to
+also print debug messages. When waiting for a deployment to Vespa Cloud which
+fails, the last error in the log of its deployment run is shown.
+
+When the config server rejects a deployment because of a validation which
+protects against a risky change, such as content-cluster-removal or
+field-type-change, the failed validations are printed together with what they
+protect against. To proceed, they must be allowed in validation-overrides.xml
+of the application package. Deploy offers to write this file, allowing the
+changes for 7 days, and to deploy again. When not running interactively, give
+the validations to allow with --add-validation-override. Overrides are never
+added without either.
+
+With --diff, the application package currently deployed is fetched, and the
+files which are added, removed or modified in the given application package are
+printed, without deploying. Use --diff-context to also show a unified diff of
+each modified text file. Combine --diff with --confirm to deploy after
+confirming the changes interactively. See also 'vespa diff'.
+
+The zip created from an application directory is deterministic: the same files
+always give the same bytes, regardless of their order on disk and their
+modification times. With --print-digest, the SHA-256 digest of the application
+package is printed before it is uploaded, on the form sha256:<hex>, and included
+in the JSON result, e.g. for detecting changes between builds.
+
 In Vespa Cloud you may override the Vespa runtime version (--version) for your
 deployment. This option should only be used if you have a reason for using a
 specific version. By default, Vespa Cloud chooses a suitable version for you.
+
+When the target is local and no config server is reachable on port 19071, the
+containers of Docker or Podman are inspected, through the socket of the
+container runtime. If a single running container publishes port 19071 on
+another host port, the ports published by that container are used. Otherwise,
+deploy fails with a hint on how to start a Vespa container. Use --no-detect to
+skip this check, e.g. when no container runtime is used.
 `,
 		Example: `$ vespa deploy .
 $ vespa deploy -t cloud
 $ vespa deploy -t cloud -z dev.aws-us-east-1c  # -z can be omitted here as this zone is the default
-$ vespa deploy -t cloud -z perf.aws-us-east-1c`,
+$ vespa deploy -t cloud -z perf.aws-us-east-1c
+$ vespa deploy -t cloud --wait 10m
+$ vespa deploy --diff --diff-context
+$ vespa deploy --diff --confirm
+$ vespa deploy --set nodes=4 --dry-run
+$ vespa deploy --add-validation-override content-cluster-removal
+$ vespa deploy https://example.com/my-app-1.2.3.zip --sha256 9f86d0...
+$ vespa deploy com.example:my-app:1.2.3:zip --maven-repository https://repo.example.com/maven2`,
 		Args:              cobra.MaximumNArgs(1),
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{Compiled: true})
+			pkg, cleanup, err := cli.applicationPackageFromRemote(args, vespa.PackageOptions{Compiled: true}, remote)
 			if err != nil {
 				return err
 			}
-			target, err := cli.target(targetOptions{logLevel: logLevelArg})
+			defer cleanup()
+			pkg.Exclude = excludes
+			if err := cli.applyTemplate(&pkg, sets, dryRun); err != nil {
+				return err
+			}
+			if dryRun {
+				return nil
+			}
+			target, err := cli.target(targetOptions{logLevel: logLevelArg, detectLocal: !noDetect})
 			if err != nil {
 				return err
 			}
-			opts := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target}
+			if noRestart && target.IsCloud() {
+				return errHint(fmt.Errorf("--require-no-restart is not supported for %s target", target.Type()), "Vespa Cloud shows required restarts in the deployment log")
+			}
+			var digest string
+			opts := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target, PackageFunc: cli.packageFunc(printDigest, &digest), UploadFunc: cli.uploadFunc()}
 			if versionArg != "" {
 				version, err := version.Parse(versionArg)
 				if err != nil {
@@ -84,37 +200,130 @@ $ vespa deploy -t cloud -z perf.aws-us-east-1c`,
 			if _, err := waiter.DeployService(target); err != nil {
 				return err
 			}
+			if showDiff || diffContext {
+				if err := cli.printPackageDiff(target, pkg, diffContext); err != nil {
+					return err
+				}
+				if !confirm {
+					return nil
+				}
+			}
+			if confirm {
+				ok, err := cli.confirm("Deploy "+color.CyanString("'"+pkg.Path+"'")+"?", false)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errCode(codeConfirmationRequired, fmt.Errorf("refusing to deploy without confirmation"))
+				}
+			}
 			var result vespa.PrepareResult
-			err = cli.spinner(cli.Stderr, "Uploading application package...", func() error {
-				result, err = vespa.Deploy(opts)
-				return err
-			})
+			upload := func() error {
+				return cli.step("Uploading application package", func() error {
+					var err error
+					if noRestart {
+						result, err = vespa.Prepare(opts)
+					} else {
+						result, err = vespa.Deploy(opts)
+					}
+					return err
+				})
+			}
+			if err = upload(); err != nil {
+				cli.printDeployErrorLog(err)
+				var retry bool
+				if retry, err = cli.overrideValidations(err, pkg, validationOverrides); retry {
+					if err = upload(); err != nil {
+						cli.printDeployErrorLog(err)
+					}
+				}
+			}
 			if err != nil {
 				if target.IsCloud() && errors.Is(err, vespa.ErrUnauthorized) {
-					return errHint(err,
+					return errCode(codeAuthFailed, err,
 						"You do not have access to the tenant "+color.CyanString(target.Deployment().Application.Tenant),
 						"You may need to create the tenant at "+color.CyanString(target.Deployment().System.ConsoleURL+"/tenant"),
 						"If the tenant already exists you may need to run 'vespa auth login' to gain access to it")
 				}
 				return err
 			}
+			if noRestart {
+				if actions := result.ConfigChangeActions; len(actions.Restart) > 0 || len(actions.Refeed) > 0 {
+					cli.printDeployLog(result.LogLines, false)
+					printConfigChangeActions(cli.Stderr, actions)
+					return errCode(codeRestartRequired, fmt.Errorf("deployment requires restart or re-feed: session %d was prepared, but not activated", result.ID),
+						"Deploy without --require-no-restart to activate this application package anyway")
+				}
+				var activateLog []vespa.LogLinePrepareResponse
+				err := cli.step("Activating application package", func() error {
+					var err error
+					activateLog, err = vespa.Activate(result.ID, opts)
+					return err
+				})
+				if err != nil {
+					cli.printDeployLog(result.LogLines, false)
+					cli.printDeployErrorLog(err)
+					return err
+				}
+				result.LogLines = append(result.LogLines, activateLog...)
+			}
+			deployed := deployResult{Path: pkg.Path}
+			if printDigest {
+				deployed.Digest = "sha256:" + digest
+			}
+			if !result.ConfigChangeActions.IsEmpty() {
+				deployed.ConfigChangeActions = &result.ConfigChangeActions
+			}
+			deployed.Steps = cli.steps
 			if opts.Target.IsCloud() {
 				cli.printSuccess("Triggered deployment of ", color.CyanString("'"+pkg.Path+"'"), " with run ID ", color.CyanString(strconv.FormatInt(result.ID, 10)))
+				deployed.RunID = result.ID
+				deployed.ConsoleURL = opts.Target.Deployment().System.ConsoleRunURL(opts.Target.Deployment(), result.ID)
 			} else {
 				cli.printSuccess("Deployed ", color.CyanString("'"+pkg.Path+"'"), " with session ID ", color.CyanString(strconv.FormatInt(result.ID, 10)))
-				printPrepareLog(cli.Stderr, result)
+				cli.printDeployLog(result.LogLines, false)
+				printConfigChangeActions(cli.Stderr, result.ConfigChangeActions)
+				deployed.SessionID = result.ID
 			}
 			if opts.Target.IsCloud() {
 				log.Printf("\nUse %s for deployment status, or follow this deployment at", color.CyanString("vespa status deployment"))
-				log.Print(color.CyanString(opts.Target.Deployment().System.ConsoleRunURL(opts.Target.Deployment(), result.ID)))
+				log.Print(color.CyanString(deployed.ConsoleURL))
 			}
-			return waitForVespaReady(target, result.ID, waiter)
+			services, err := waitForVespaReady(target, result.ID, waiter)
+			if err != nil {
+				var deployErr *vespa.DeployError
+				if target.IsCloud() && errors.As(err, &deployErr) && len(deployErr.LogLines) > 0 {
+					last := deployErr.LogLines[len(deployErr.LogLines)-1]
+					return errCode(codeDeploymentFailed, err, "Run "+strconv.FormatInt(result.ID, 10)+" failed with: "+last.Message,
+						"See "+deployed.ConsoleURL+" for the full run log")
+				}
+				return err
+			}
+			for _, s := range services {
+				deployed.Endpoints = append(deployed.Endpoints, deployEndpoint{Cluster: s.Name, URL: s.BaseURL})
+				if !cli.jsonOutput() && !cli.config.isQuiet() {
+					printServiceStatusText(s, "human", nil, cli)
+				}
+			}
+			return cli.printResult(deployed)
 		},
 	}
 	cmd.Flags().StringVarP(&logLevelArg, "log-level", "l", "error", `Log level for Vespa logs. Must be "error", "warning", "info" or "debug"`)
 	cmd.Flags().StringVarP(&versionArg, "version", "V", "", `Override the Vespa runtime version to use in Vespa Cloud`)
 	cmd.Flags().BoolVarP(&copyCert, "add-cert", "A", false, `Copy certificate of the configured application to the current application package`)
+	addRemotePackageFlags(cmd, &remote)
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil, `Exclude files matching this pattern from the application package, in addition to those in .vespaignore. Can be repeated`)
+	cmd.Flags().StringArrayVar(&sets, "set", nil, `Set the value of a placeholder in services.xml and deployment.xml, on the form name=value. Can be repeated`)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, `Print services.xml and deployment.xml with placeholders substituted, without deploying`)
+	cmd.Flags().BoolVar(&noRestart, "require-no-restart", false, `Fail without activating the application package if any restart or re-feed is required (self-hosted only)`)
+	cmd.Flags().BoolVar(&showDiff, "diff", false, `Show files changed compared to the deployed application package, and exit without deploying unless --confirm is given`)
+	cmd.Flags().BoolVar(&diffContext, "diff-context", false, `Show a unified diff of each modified text file. Implies --diff`)
+	cmd.Flags().BoolVar(&confirm, "confirm", false, `Prompt for confirmation before deploying`)
+	cmd.Flags().StringSliceVar(&validationOverrides, "add-validation-override", nil, `Allow the change protected by this validation for 7 days, by adding it to validation-overrides.xml, if the deployment is rejected by it. Can be repeated`)
+	bindNoDetectFlag(cmd, &noDetect)
+	cmd.Flags().BoolVar(&printDigest, "print-digest", false, `Print the SHA-256 digest of the application package before uploading it`)
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
+	bindNoEarlyAbortFlag(cmd)
 	return cmd
 }
 
@@ -139,20 +348,22 @@ func newPrepareCmd(cli *CLI) *cobra.Command {
 			if _, err := waiter.DeployService(target); err != nil {
 				return err
 			}
-			opts := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target}
+			opts := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target, UploadFunc: cli.uploadFunc()}
 			var result vespa.PrepareResult
-			err = cli.spinner(cli.Stderr, "Uploading application package...", func() error {
+			err = cli.step("Uploading application package", func() error {
 				result, err = vespa.Prepare(opts)
 				return err
 			})
 			if err != nil {
+				cli.printDeployErrorLog(err)
 				return err
 			}
 			if err := cli.config.writeSessionID(vespa.DefaultApplication, result.ID); err != nil {
 				return fmt.Errorf("could not write session id: %w", err)
 			}
 			cli.printSuccess("Prepared ", color.CyanString("'"+pkg.Path+"'"), " with session ", result.ID)
-			printPrepareLog(cli.Stderr, result)
+			cli.printDeployLog(result.LogLines, false)
+			printConfigChangeActions(cli.Stderr, result.ConfigChangeActions)
 			return nil
 		},
 	}
@@ -182,49 +393,147 @@ func newActivateCmd(cli *CLI) *cobra.Command {
 				return err
 			}
 			opts := vespa.DeploymentOptions{Target: target}
-			err = vespa.Activate(sessionID, opts)
+			var activateLog []vespa.LogLinePrepareResponse
+			err = cli.step("Activating application package", func() error {
+				activateLog, err = vespa.Activate(sessionID, opts)
+				return err
+			})
 			if err != nil {
+				cli.printDeployErrorLog(err)
 				return err
 			}
 			cli.printSuccess("Activated application with session ", sessionID)
-			return waitForVespaReady(target, sessionID, waiter)
+			cli.printDeployLog(activateLog, false)
+			_, err = waitForVespaReady(target, sessionID, waiter)
+			return err
 		},
 	}
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
+	bindNoEarlyAbortFlag(cmd)
 	return cmd
 }
 
-func waitForVespaReady(target vespa.Target, sessionOrRunID int64, waiter *Waiter) error {
+// waitForVespaReady waits for the deployment identified by sessionOrRunID to converge, and returns its services, if
+// waiting for them.
+func waitForVespaReady(target vespa.Target, sessionOrRunID int64, waiter *Waiter) ([]*vespa.Service, error) {
 	fastWait := waiter.FastWaitOn(target)
 	hasTimeout := waiter.Timeout > 0
 	if fastWait || hasTimeout {
 		// Wait for deployment convergence
 		if _, err := waiter.Deployment(target, sessionOrRunID); err != nil {
 			if fastWait && errors.Is(err, vespa.ErrWaitTimeout) {
-				return nil // // Do not report fast wait timeout as an error
+				return nil, nil // // Do not report fast wait timeout as an error
 			}
-			return err
+			return nil, err
 		}
 		// Wait for healthy services where we expect them to be reachable (cloud and local). When using a custom target,
 		// we do not wait for services as there is no guarantee that they are reachable from the machine executing
 		// deploy.
 		if hasTimeout && (target.IsCloud() || target.Type() == vespa.TargetLocal) {
-			_, err := waiter.Services(target)
-			return err
+			return waiter.Services(target)
 		}
 	}
-	return nil
+	return nil, nil
 }
 
-func printPrepareLog(stderr io.Writer, result vespa.PrepareResult) {
-	for _, entry := range result.LogLines {
+// printDeployLog prints the log messages returned by the config server when deploying. If the deployment succeeded,
+// only warnings and errors are printed, while all messages are printed for a failed deployment. Debug messages are
+// only printed with --verbose.
+func (c *CLI) printDeployLog(entries []vespa.LogLinePrepareResponse, failed bool) {
+	for _, entry := range entries {
 		level := entry.Level
-		switch level {
-		case "ERROR":
+		switch strings.ToUpper(level) {
+		case "ERROR", "SEVERE":
 			level = color.RedString(level)
 		case "WARNING":
 			level = color.YellowString(level)
+		case "INFO":
+			if !failed && !c.verbose {
+				continue
+			}
+		default: // Debug, or one of the finer levels of Java logging
+			if !c.verbose {
+				continue
+			}
 		}
-		fmt.Fprintf(stderr, "%s %s\n", level, entry.Message)
+		fmt.Fprintf(c.Stderr, "%s %s\n", level, entry.Message)
+	}
+}
+
+// printDeployErrorLog prints the log messages of the failed deployment in err, if any.
+func (c *CLI) printDeployErrorLog(err error) {
+	var deployErr *vespa.DeployError
+	if errors.As(err, &deployErr) {
+		c.printDeployLog(deployErr.LogLines, true)
+	}
+}
+
+// printPackageStats prints the number of files skipped by ignore patterns, and the size of the zipped application
+// package, if any files were skipped.
+func (c *CLI) printPackageStats(stats vespa.PackageStats) {
+	if stats.Skipped == 0 {
+		return
+	}
+	c.printInfo("Skipped ", stats.Skipped, " files matching ignore patterns. Application package contains ", stats.Files, " files, with size ", formatSize(stats.Size))
+}
+
+// packageFunc returns a function printing statistics of the zipped application package, and also its digest if
+// printDigest is true. The digest is stored in digest.
+func (c *CLI) packageFunc(printDigest bool, digest *string) func(vespa.PackageStats) {
+	return func(stats vespa.PackageStats) {
+		c.printPackageStats(stats)
+		*digest = stats.Digest
+		if printDigest {
+			fmt.Fprintln(c.textOutput(), "sha256:"+stats.Digest)
+		}
+	}
+}
+
+// formatSize formats size in bytes using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// printConfigChangeActions prints the actions required for a config change to take effect, if any.
+func printConfigChangeActions(stderr io.Writer, actions vespa.ConfigChangeActions) {
+	if len(actions.Restart) > 0 {
+		fmt.Fprintln(stderr, color.YellowString("Restart required")+" for the change to take effect:")
+		for _, a := range actions.Restart {
+			fmt.Fprintf(stderr, "  %s in %s cluster %s: %s\n", a.ServiceType, a.ClusterType, color.CyanString(a.ClusterName), strings.Join(a.Messages, "; "))
+			printChangeActionServices(stderr, a.Services)
+		}
+	}
+	if len(actions.Refeed) > 0 {
+		fmt.Fprintln(stderr, color.RedString("Re-feed required")+" for the change to take effect:")
+		for _, a := range actions.Refeed {
+			fmt.Fprintf(stderr, "  %s in cluster %s (%s): %s\n", color.RedString("document type "+a.DocumentType), color.CyanString(a.ClusterName), a.Name, strings.Join(a.Messages, "; "))
+			printChangeActionServices(stderr, a.Services)
+		}
+	}
+	if len(actions.Reindex) > 0 {
+		fmt.Fprintln(stderr, color.YellowString("Reindexing required")+" for the change to take effect:")
+		for _, a := range actions.Reindex {
+			fmt.Fprintf(stderr, "  document type %s in cluster %s (%s): %s\n", a.DocumentType, color.CyanString(a.ClusterName), a.Name, strings.Join(a.Messages, "; "))
+		}
+	}
+}
+
+func printChangeActionServices(stderr io.Writer, services []vespa.ChangeActionService) {
+	if len(services) == 0 {
+		return
+	}
+	names := make([]string, 0, len(services))
+	for _, s := range services {
+		names = append(names, s.ServiceName+" on "+s.HostName)
 	}
+	fmt.Fprintf(stderr, "    affected services: %s\n", strings.Join(names, ", "))
 }
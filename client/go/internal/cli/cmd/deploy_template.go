@@ -0,0 +1,69 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// templateHelp documents the placeholders of an application package, for the help of commands which deploy one.
+const templateHelp = `Placeholders in services.xml and deployment.xml of an application directory
+are substituted before the application package is zipped. A placeholder is
+given as ${name}, with a value set by --set name=value, or as ${env.NAME}, with
+the value of the environment variable NAME unless set by --set. A default can
+be given after a colon, as in ${nodes:2}. The deployment fails if any
+placeholder has no value and no default. Files without placeholders are
+deployed as they are. Use --dry-run to print the rendered files, without
+deploying.`
+
+// applyTemplate sets the template of pkg from the values in sets, given as name=value, and from the environment, and
+// checks that all its placeholders resolve. If dryRun is true, the rendered template files are printed.
+func (c *CLI) applyTemplate(pkg *vespa.ApplicationPackage, sets []string, dryRun bool) error {
+	if pkg.IsZip() {
+		if len(sets) > 0 || dryRun {
+			return fmt.Errorf("--set and --dry-run are not supported for compressed application package: '%s'", pkg.Path)
+		}
+		return nil
+	}
+	values := make(map[string]string)
+	for _, set := range sets {
+		name, value, ok := strings.Cut(set, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid --set: %q: must be on the form name=value", set)
+		}
+		values[name] = value
+	}
+	pkg.Template = &vespa.Template{
+		Values: values,
+		LookupEnv: func(name string) (string, bool) {
+			value, ok := c.Environment[name]
+			return value, ok
+		},
+	}
+	files, err := pkg.RenderTemplates()
+	if err != nil {
+		var unresolved *vespa.UnresolvedError
+		if errors.As(err, &unresolved) {
+			return errHint(err, "Set a value with --set name=value, or give a default in the placeholder, as in ${name:default}")
+		}
+		return err
+	}
+	if dryRun {
+		for _, name := range vespa.TemplateFiles {
+			data, ok := files[name]
+			if !ok {
+				continue
+			}
+			fmt.Fprintln(c.Stdout, color.CyanString("==> "+name+" <=="))
+			fmt.Fprint(c.Stdout, string(data))
+			if !strings.HasSuffix(string(data), "\n") {
+				fmt.Fprintln(c.Stdout)
+			}
+		}
+	}
+	return nil
+}
@@ -7,15 +7,20 @@ package cmd
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
@@ -70,9 +75,9 @@ Hint: Pass --add-cert to use the certificate of the current application
 	assert.Equal(t, apiKeyWarning+"Error: no certificate exists for t1.a2.i2\nHint: Try (re)creating the certificate with 'vespa auth cert'\n", stderr.String())
 
 	// Mismatching certificate is detected
+	assert.Nil(t, cli.Run("auth", "cert", "--application=t1.a1.i1", "-f", "--no-add"))
 	stdout.Reset()
 	stderr.Reset()
-	assert.Nil(t, cli.Run("auth", "cert", "--application=t1.a1.i1", "-f", "--no-add"))
 	require.NotNil(t, cli.Run("deploy", "--application=t1.a1.i1", pkgDir2))
 	assert.Equal(t, apiKeyWarning+`Error: certificate in security/clients.pem does not match the stored key pair for t1.a1.i1
 Hint: If this application was deployed using a different application ID in the past, the matching key pair may be stored under a different ID in `+
@@ -108,9 +113,21 @@ func TestDeployCloudFastWait(t *testing.T) {
 	httpClient.NextResponseString(200, `{"active": false, "status": "unsuccesful"}`)
 	httpClient.NextResponseString(200, `{"active": false, "status": "unsuccesful"}`)
 	require.NotNil(t, cli.Run("deploy", pkgDir))
-	assert.Equal(t, stderr.String(), "Error: deployment failed: run 0 ended with unsuccessful status: unsuccesful\n")
+	assert.Equal(t, stderr.String(), stepLines("Uploading application package", "done")+"Error: deployment failed: run 0 ended with unsuccessful status: unsuccesful [DEPLOYMENT_FAILED]\n")
 	assert.True(t, httpClient.Consumed())
 
+	// Rejected deployment shows the error of its run
+	stdout.Reset()
+	stderr.Reset()
+	failed := `{"active": false, "status": "deploymentFailed", "lastId": 2, "log": {"deployReal": [{"at": 1000, "type": "info", "message": "Deploying"}, {"at": 2000, "type": "error", "message": "Invalid application: field 'foo' does not exist"}]}}`
+	httpClient.NextResponseString(200, `ok`)
+	httpClient.NextResponseString(200, failed)
+	httpClient.NextResponseString(200, failed)
+	require.NotNil(t, cli.Run("deploy", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: deployment failed: run 0 ended with unsuccessful status: deploymentFailed [DEPLOYMENT_FAILED]\n"+
+		"Hint: Run 0 failed with: Invalid application: field 'foo' does not exist\n"+
+		"Hint: See https://console.vespa-cloud.com/tenant/t1/application/a1/dev/instance/i1/job/dev-aws-us-east-1c/run/0 for the full run log\n")
+
 	// Deployment which is running does not return error
 	stdout.Reset()
 	stderr.Reset()
@@ -136,17 +153,19 @@ func TestDeployCloudUnauthorized(t *testing.T) {
 	assert.Nil(t, cli.Run("auth", "api-key"))
 	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
 	httpClient.NextResponseString(403, "bugger off")
+	stderr.Reset()
 	require.NotNil(t, cli.Run("deploy", pkgDir))
-	assert.Equal(t, `Error: deployment failed: unauthorized (status 403)
+	assert.Equal(t, stepLines("Uploading application package", "failed")+`Error: uploading application package failed: deployment failed: unauthorized (status 403) [AUTH_FAILED]
 bugger off
 Hint: You do not have access to the tenant t1
 Hint: You may need to create the tenant at https://console.vespa-cloud.com/tenant
 Hint: If the tenant already exists you may need to run 'vespa auth login' to gain access to it
+Hint: Authenticated with API key from '`+cli.config.apiKeyPath("t1")+`'
 `, stderr.String())
 }
 
 func TestDeployWait(t *testing.T) {
-	cli, stdout, _ := newTestCLI(t)
+	cli, stdout, stderr := newTestCLI(t)
 	client := &mock.HTTPClient{}
 	cli.httpClient = client
 	cli.retryInterval = 0
@@ -165,10 +184,240 @@ func TestDeployWait(t *testing.T) {
 	})
 	mockServiceStatus(client, "foo") // Wait for deployment
 	mockServiceStatus(client, "foo") // Look up services
-	assert.Nil(t, cli.Run("deploy", "--wait=3", pkg))
+	assert.Nil(t, cli.Run("deploy", "--wait=3s", pkg))
 	assert.Equal(t,
-		"Success: Deployed '"+pkg+"' with session ID 1\n",
+		"Success: Deployed '"+pkg+"' with session ID 1\n"+
+			"Container foo at http://127.0.0.1:8080 is ready\n",
 		stdout.String())
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("deploy", "--wait=soon", pkg))
+	assert.Contains(t, stderr.String(), `Error: invalid argument "soon" for "-w, --wait" flag: invalid duration: "soon": must be a number of seconds or a duration such as 5m`)
+}
+
+func TestDeployJSONOutput(t *testing.T) {
+	pkg := "testdata/applications/withTarget/target/application.zip"
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"session-id":"42"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	assert.Nil(t, cli.Run("deploy", "--wait=0", "-o", "json", pkg))
+	assert.Equal(t, `{
+  "path": "`+pkg+`",
+  "sessionId": 42,
+  "steps": [
+    {
+      "name": "Uploading application package",
+      "status": "done",
+      "durationSeconds": 0
+    }
+  ]
+}
+`, stdout.String())
+	assert.Equal(t, "Success: Deployed '"+pkg+"' with session ID 42\n", stderr.String())
+
+	client.NextResponseString(400, `{"error-code":"INVALID_APPLICATION_PACKAGE","message":"Invalid XML"}`)
+	stdout.Reset()
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("deploy", "--wait=0", "--output", "json", pkg))
+	assert.Equal(t, "", stdout.String())
+	var errJSON errorJSON
+	require.Nil(t, json.Unmarshal(stderr.Bytes(), &errJSON))
+	assert.Contains(t, errJSON.Message, "Invalid XML")
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("deploy", "--wait=0", "--output", "yaml", pkg))
+	assert.Equal(t, "Error: invalid output option: yaml\n", stderr.String())
+}
+
+func TestDeployExecute(t *testing.T) {
+	pkg := "testdata/applications/withTarget/target/application.zip"
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"session-id":"42"}`)
+	client.NextResponseString(200, `{"session-id":"43"}`)
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = client
+	result, err := cli.Execute([]string{"deploy", "--wait=0", "-o", "json", pkg})
+	require.Nil(t, err)
+	assert.Equal(t, "deploy", result.Command)
+	deployed, ok := result.Value.(deployResult)
+	require.True(t, ok)
+	assert.Equal(t, int64(42), deployed.SessionID)
+
+	// Flags of the previous command are not given to the next
+	stdout.Reset()
+	result, err = cli.Execute([]string{"deploy", "--wait=0", pkg})
+	require.Nil(t, err)
+	assert.Equal(t, int64(43), result.Value.(deployResult).SessionID)
+	assert.Equal(t, "Success: Deployed '"+pkg+"' with session ID 43\n", stdout.String())
+
+	result, err = cli.Execute([]string{"version", "--no-such-flag"})
+	assert.NotNil(t, err)
+	assert.Nil(t, result.Value)
+}
+
+func TestDeployConfigChangeActions(t *testing.T) {
+	pkg := "testdata/applications/withTarget/target/application.zip"
+	response := `{
+  "session-id": "42",
+  "configChangeActions": {
+    "restart": [{
+      "clusterName": "default",
+      "clusterType": "container",
+      "serviceType": "container",
+      "messages": ["Changes to JVM options"],
+      "services": [{"serviceName": "container", "serviceType": "container", "configId": "default/container.0", "hostName": "host1"}]
+    }],
+    "refeed": [{
+      "name": "field-type-change",
+      "documentType": "music",
+      "clusterName": "content",
+      "messages": ["Field 'year' changed type"],
+      "services": []
+    }]
+  }
+}`
+	actions := `Restart required for the change to take effect:
+  container in container cluster default: Changes to JVM options
+    affected services: container on host1
+Re-feed required for the change to take effect:
+  document type music in cluster content (field-type-change): Field 'year' changed type
+`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, response)
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("deploy", "--wait=0", pkg))
+	assert.Equal(t, "Success: Deployed '"+pkg+"' with session ID 42\n", stdout.String())
+	assert.Equal(t, stepLines("Uploading application package", "done")+actions, stderr.String())
+
+	// JSON output includes actions
+	client.NextResponseString(200, response)
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("deploy", "--wait=0", "-o", "json", pkg))
+	var result deployResult
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	require.NotNil(t, result.ConfigChangeActions)
+	assert.Equal(t, "host1", result.ConfigChangeActions.Restart[0].Services[0].HostName)
+	assert.Equal(t, "music", result.ConfigChangeActions.Refeed[0].DocumentType)
+
+	// Required restart fails deployment before activation
+	client.NextResponseString(200, `{"session-id":"42"}`)
+	client.NextResponseString(200, response)
+	stdout.Reset()
+	stderr.Reset()
+	require.NotNil(t, cli.Run("deploy", "--wait=0", "-o", "human", "--require-no-restart", pkg))
+	assert.Equal(t, stepLines("Uploading application package", "done")+actions+"Error: deployment requires restart or re-feed: session 42 was prepared, but not activated [RESTART_REQUIRED]\n"+
+		"Hint: Deploy without --require-no-restart to activate this application package anyway\n", stderr.String())
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/session/42/prepared", client.LastRequest.URL.String())
+
+	// No required restart activates
+	client.NextResponseString(200, `{"session-id":"43"}`)
+	client.NextResponseString(200, `{"session-id":"43"}`)
+	client.NextResponseString(200, `{}`)
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("deploy", "--wait=0", "--require-no-restart", pkg))
+	assert.Equal(t, "Success: Deployed '"+pkg+"' with session ID 43\n", stdout.String())
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/session/43/active", client.LastRequest.URL.String())
+	assert.Equal(t, "PUT", client.LastRequest.Method)
+}
+
+func TestDeployLog(t *testing.T) {
+	pkg := "testdata/applications/withTarget/target/application.zip"
+	response := `{"session-id": "42", "log": [
+  {"time": 1000, "level": "INFO", "message": "Preparing"},
+  {"time": 2000, "level": "WARNING", "message": "Deprecated element 'search'"},
+  {"time": 3000, "level": "DEBUG", "message": "Took 42 ms"}
+]}`
+	client := &mock.HTTPClient{}
+	cli, _, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+
+	// Only warnings and errors are printed on success
+	client.NextResponseString(200, response)
+	require.Nil(t, cli.Run("deploy", "--wait=0", pkg))
+	assert.Equal(t, stepLines("Uploading application package", "done")+"WARNING Deprecated element 'search'\n", stderr.String())
+
+	// Everything is printed with --verbose
+	client.NextResponseString(200, response)
+	stderr.Reset()
+	require.Nil(t, cli.Run("deploy", "--wait=0", "--verbose", pkg))
+	assert.Equal(t, stepLines("Uploading application package", "done")+"INFO Preparing\nWARNING Deprecated element 'search'\nDEBUG Took 42 ms\n", stderr.String())
+
+	// All but debug messages are printed on failure
+	cli, _, stderr = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	client.NextResponseString(400, `{"error-code": "INVALID_APPLICATION_PACKAGE", "message": "Invalid application package", "log": [
+  {"time": 1000, "level": "INFO", "message": "Preparing"},
+  {"time": 2000, "level": "ERROR", "message": "Unknown document type 'music'"},
+  {"time": 3000, "level": "DEBUG", "message": "Took 42 ms"}
+]}`)
+	require.NotNil(t, cli.Run("deploy", "--wait=0", pkg))
+	assert.Equal(t, stepLines("Uploading application package", "failed")+"INFO Preparing\nERROR Unknown document type 'music'\n"+
+		"Error: uploading application package failed: invalid application package (status 400) [INVALID_APPLICATION_PACKAGE]\nInvalid application package\n", stderr.String())
+
+	// Activation log is printed too
+	client.NextResponseString(200, `{"session-id": "43"}`)
+	client.NextResponseString(200, `{"session-id": "43"}`)
+	client.NextResponseString(200, `{"log": [{"time": 1000, "level": "WARNING", "message": "Activation is slow"}]}`)
+	stderr.Reset()
+	require.Nil(t, cli.Run("deploy", "--wait=0", "--require-no-restart", pkg))
+	assert.Equal(t, stepLines("Uploading application package", "done")+stepLines("Activating application package", "done")+"WARNING Activation is slow\n", stderr.String())
+}
+
+func TestDeployRemote(t *testing.T) {
+	zipData, err := os.ReadFile("testdata/applications/withTarget/target/application.zip")
+	require.Nil(t, err)
+	checksum := sha256.Sum256(zipData)
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+
+	// Download from URL, with header and checksum
+	client.NextResponseBytes(200, zipData)
+	client.NextResponseString(200, `{"session-id":"42"}`)
+	require.Nil(t, cli.Run("deploy", "--wait=0", "--header", "Authorization: Bearer secret", "--sha256", hex.EncodeToString(checksum[:]), "https://example.com/apps/my-app-1.2.3.zip"))
+	download := client.Requests[0]
+	assert.Equal(t, "https://example.com/apps/my-app-1.2.3.zip", download.URL.String())
+	assert.Equal(t, "Bearer secret", download.Header.Get("Authorization"))
+	assertPackageUpload(1, "http://127.0.0.1:19071/application/v2/tenant/default/prepareandactivate", client, t)
+	output := stdout.String()
+	require.True(t, strings.HasPrefix(output, "Success: Deployed '"), output)
+	zipFile := strings.TrimSuffix(strings.TrimPrefix(output, "Success: Deployed '"), "' with session ID 42\n")
+	assert.Equal(t, "my-app-1.2.3.zip", filepath.Base(zipFile))
+	assert.False(t, ioutil.Exists(zipFile)) // Temporary file is removed
+
+	// Download from Maven repository, with mismatching checksum
+	client.NextResponseBytes(200, zipData)
+	stderr.Reset()
+	require.NotNil(t, cli.Run("deploy", "--wait=0", "--header", "X-Foo: bar", "--sha256", "CAFE", "--maven-repository", "https://repo.example.com/maven2/", "com.example:my-app:1.2.3:zip"))
+	assert.Equal(t, "https://repo.example.com/maven2/com/example/my-app/1.2.3/my-app-1.2.3.zip", client.LastRequest.URL.String())
+	assert.Contains(t, stderr.String(), "Error: checksum of application package from https://repo.example.com/maven2/com/example/my-app/1.2.3/my-app-1.2.3.zip is "+
+		hex.EncodeToString(checksum[:])+", expected cafe\nHint: The application package may be corrupt, or the expected checksum wrong\n")
+
+	// Download fails
+	client.NextStatus(404)
+	stderr.Reset()
+	require.NotNil(t, cli.Run("deploy", "--wait=0", "--header", "X-Foo: bar", "--sha256", "cafe", "https://example.com/apps/my-app-1.2.4.zip"))
+	assert.Contains(t, stderr.String(), "Error: could not download application package: https://example.com/apps/my-app-1.2.4.zip returned status 404\n")
+
+	// Checksum requires remote application package
+	stderr.Reset()
+	require.NotNil(t, cli.Run("deploy", "--wait=0", "--sha256", "cafe", "testdata/applications/withTarget/target/application.zip"))
+	assert.Contains(t, stderr.String(), "Error: --header and --sha256 require an application package URL or Maven coordinate\n")
+}
+
+func TestDeployQuiet(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("deploy", "--wait=0", "-q", "testdata/applications/withTarget/target/application.zip"))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	assertDeployRequestMade("http://127.0.0.1:19071", client, t)
 }
 
 func TestPrepareZip(t *testing.T) {
@@ -228,7 +477,7 @@ func TestDeployApplicationDirectoryWithPomAndEmptyTarget(t *testing.T) {
 }
 
 func TestDeployIncludesExpectedFiles(t *testing.T) {
-	cli, stdout, _ := newTestCLI(t)
+	cli, stdout, stderr := newTestCLI(t)
 	client := &mock.HTTPClient{}
 	cli.httpClient = client
 	assert.Nil(t, cli.Run("deploy", "--wait=0", "testdata/applications/withSource"))
@@ -251,6 +500,72 @@ func TestDeployIncludesExpectedFiles(t *testing.T) {
 		zipFiles = append(zipFiles, f.Name)
 	}
 	assert.Equal(t, []string{".vespaignore", "hosts.xml", "schemas/msmarco.sd", "services.xml"}, zipFiles)
+
+	// Exclude additional files
+	stderr.Reset()
+	assert.Nil(t, cli.Run("deploy", "--wait=0", "--exclude", "hosts.xml", "--exclude", "schemas/", "testdata/applications/withSource"))
+	assert.Equal(t, []string{".vespaignore", "services.xml"}, zipEntries(t, client.LastRequest.Body))
+	assert.True(t, strings.HasPrefix(stderr.String(), "Uploading application package ...\nSkipped 4 files matching ignore patterns. Application package contains 2 files, with size "), stderr.String())
+}
+
+func zipEntries(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	require.Nil(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestDeployTemplate(t *testing.T) {
+	appDir := t.TempDir()
+	services := `<services><container id="${env.CLUSTER}" version="1.0"><nodes count="${nodes:2}"/></container></services>`
+	require.Nil(t, os.WriteFile(filepath.Join(appDir, "services.xml"), []byte(services), 0644))
+
+	client := &mock.HTTPClient{}
+	cli, stdout, _ := newTestCLI(t, "CLUSTER=default")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("deploy", "--set", "nodes=4", "--dry-run", appDir))
+	assert.Equal(t, `==> services.xml <==
+<services><container id="default" version="1.0"><nodes count="4"/></container></services>
+`, stdout.String())
+	assert.Empty(t, client.Requests)
+
+	cli, _, _ = newTestCLI(t, "CLUSTER=default")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("deploy", "--wait=0", appDir))
+	data, err := io.ReadAll(client.LastRequest.Body)
+	require.Nil(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.Nil(t, err)
+	require.Len(t, zr.File, 1)
+	f, err := zr.File[0].Open()
+	require.Nil(t, err)
+	rendered, err := io.ReadAll(f)
+	require.Nil(t, err)
+	assert.Equal(t, `<services><container id="default" version="1.0"><nodes count="2"/></container></services>`, string(rendered))
+
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("deploy", "--wait=0", appDir))
+	assert.Equal(t, "Error: unresolved placeholders in application package: services.xml: ${env.CLUSTER}\n"+
+		"Hint: Set a value with --set name=value, or give a default in the placeholder, as in ${name:default}\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("deploy", "--wait=0", "--set", "nodes=4", "testdata/applications/withTarget/target/application.zip"))
+	assert.Equal(t, "Error: --set and --dry-run are not supported for compressed application package: 'testdata/applications/withTarget/target/application.zip'\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("deploy", "--wait=0", "--set", "foo", appDir))
+	assert.Equal(t, "Error: invalid --set: \"foo\": must be on the form name=value\n", stderr.String())
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "10.0 MiB", formatSize(10<<20))
 }
 
 func TestDeployApplicationPackageErrorWithUnexpectedNonJson(t *testing.T) {
@@ -372,7 +687,7 @@ func assertApplicationPackageError(t *testing.T, cmd string, status int, expecte
 	args = append(args, "testdata/applications/withTarget/target/application.zip")
 	assert.NotNil(t, cli.Run(args...))
 	assert.Equal(t,
-		"Error: invalid application package (status "+strconv.Itoa(status)+")\n"+expectedMessage+"\n",
+		stepLines("Uploading application package", "failed")+"Error: uploading application package failed: invalid application package (status "+strconv.Itoa(status)+") [INVALID_APPLICATION_PACKAGE]\n"+expectedMessage+"\n",
 		stderr.String())
 }
 
@@ -382,8 +697,85 @@ func assertDeployServerError(t *testing.T, status int, errorMessage string) {
 	client.NextResponseString(status, errorMessage)
 	cli, _, stderr := newTestCLI(t)
 	cli.httpClient = client
-	assert.NotNil(t, cli.Run("deploy", "--wait=0", "testdata/applications/withTarget/target/application.zip"))
+	err := cli.Run("deploy", "--wait=0", "testdata/applications/withTarget/target/application.zip")
+	require.NotNil(t, err)
 	assert.Equal(t,
-		"Error: error from deploy API at 127.0.0.1:19071 (status "+strconv.Itoa(status)+"):\n"+errorMessage+"\n",
+		stepLines("Uploading application package", "failed")+"Error: uploading application package failed: error from deploy API at 127.0.0.1:19071 (status "+strconv.Itoa(status)+"): [SERVER_ERROR]\n"+errorMessage+"\n",
 		stderr.String())
+	assert.Equal(t, exitTransient, err.(ErrCLI).Status)
+}
+
+func TestDeployValidationOverride(t *testing.T) {
+	rejection := `{"error-code": "INVALID_APPLICATION_PACKAGE", "message": "Invalid application: content-cluster-removal: Content cluster 'music' is removed. ` +
+		`This will cause loss of all data in this cluster. To allow this add <allow until='yyyy-mm-dd'>content-cluster-removal</allow> to validation-overrides.xml, ` +
+		`see https://docs.vespa.ai/en/reference/validation-overrides.html"}`
+	deploy := func(pkgDir, stdin string, args ...string) (*mock.HTTPClient, string, error) {
+		cli, stdout, stderr := newTestCLI(t, "USER=alice")
+		cli.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+		if stdin != "" {
+			require.Nil(t, cli.Run("config", "set", "update-check", "false"))
+			cli.isTerminal = func() bool { return true }
+			cli.Stdin = bytes.NewBufferString(stdin)
+		}
+		client := cli.httpClient.(*mock.HTTPClient)
+		client.NextResponseString(400, rejection)
+		client.NextResponseString(200, `{"session-id": "42"}`)
+		err := cli.Run(append(append([]string{"deploy", "--wait=0"}, args...), pkgDir)...)
+		return client, stdout.String() + stderr.String(), err
+	}
+	newPackage := func() string {
+		dir := t.TempDir()
+		require.Nil(t, os.WriteFile(filepath.Join(dir, "services.xml"), []byte("<services/>\n"), 0644))
+		return dir
+	}
+	overrides := `<validation-overrides>
+  <!-- Added by alice with vespa deploy at 2024-01-01T00:00:00Z -->
+  <allow until="2024-01-08">content-cluster-removal</allow>
+</validation-overrides>
+`
+
+	// Overrides are never added without the flag, or confirmation
+	dir := newPackage()
+	client, output, err := deploy(dir, "")
+	require.NotNil(t, err)
+	assert.Equal(t, 1, len(client.Requests))
+	assert.Contains(t, output, "Deployment was rejected by validations which can be overridden:\n"+
+		"  content-cluster-removal: Removing a content cluster, which deletes all its documents\n"+
+		"    Content cluster 'music' is removed. This will cause loss of all data in this cluster\n")
+	assert.Contains(t, output, "Hint: To allow these changes until 2024-01-08, deploy again with --add-validation-override content-cluster-removal\n")
+	assert.NoFileExists(t, filepath.Join(dir, "validation-overrides.xml"))
+
+	_, _, err = deploy(dir, "", "--add-validation-override", "field-type-change")
+	require.NotNil(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "validation-overrides.xml"))
+
+	// With the flag, the override is added, and the deployment retried
+	client, output, err = deploy(dir, "", "--add-validation-override", "content-cluster-removal")
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(client.Requests))
+	assert.Contains(t, output, "Allowed content-cluster-removal until 2024-01-08 in "+filepath.Join(dir, "validation-overrides.xml")+"\n")
+	data, err := os.ReadFile(filepath.Join(dir, "validation-overrides.xml"))
+	require.Nil(t, err)
+	assert.Equal(t, overrides, string(data))
+
+	// Interactively, the user is asked
+	dir = newPackage()
+	client, output, err = deploy(dir, "y\n")
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(client.Requests))
+	assert.Contains(t, output, "Allow content-cluster-removal until 2024-01-08 in validation-overrides.xml, and deploy again? [y/N]")
+	data, err = os.ReadFile(filepath.Join(dir, "validation-overrides.xml"))
+	require.Nil(t, err)
+	assert.Equal(t, overrides, string(data))
+
+	dir = newPackage()
+	client, _, err = deploy(dir, "n\n")
+	require.NotNil(t, err)
+	assert.Equal(t, 1, len(client.Requests))
+	assert.NoFileExists(t, filepath.Join(dir, "validation-overrides.xml"))
+
+	// Zipped application packages cannot be changed
+	_, output, err = deploy("testdata/applications/withTarget/target/application.zip", "", "--add-validation-override", "content-cluster-removal")
+	require.NotNil(t, err)
+	assert.Contains(t, output, "Hint: To allow these changes, add <allow until='2024-01-08'>content-cluster-removal</allow> to validation-overrides.xml in the application package, and deploy again\n")
 }
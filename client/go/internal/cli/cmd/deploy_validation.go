@@ -0,0 +1,85 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Overriding of validations rejecting a deployment
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// validationOverrideDays is the number of days a validation override added by vespa deploy is valid.
+const validationOverrideDays = 7
+
+// overrideValidations handles a deployment which failed with err. If the deployment was rejected by validations which
+// can be overridden, these are printed, together with what they protect against. Overrides are then written to
+// validation-overrides.xml of pkg, if all the failed validations are given in allowed, or if the user confirms it
+// when prompted. It returns whether overrides were written, such that the deployment should be retried, and the error
+// to return otherwise.
+func (c *CLI) overrideValidations(err error, pkg vespa.ApplicationPackage, allowed []string) (bool, error) {
+	var deployErr *vespa.DeployError
+	if !errors.As(err, &deployErr) || len(deployErr.ValidationFailures) == 0 {
+		return false, err
+	}
+	failures := deployErr.ValidationFailures
+	fmt.Fprintln(c.Stderr, "Deployment was rejected by validations which can be overridden:")
+	var ids, missing []string
+	for _, f := range failures {
+		description := f.Description()
+		if description == "" {
+			description = "See https://docs.vespa.ai/en/reference/validation-overrides.html"
+		}
+		fmt.Fprintf(c.Stderr, "  %s: %s\n", color.CyanString(f.ID), description)
+		if f.Message != "" {
+			fmt.Fprintf(c.Stderr, "    %s\n", f.Message)
+		}
+		ids = append(ids, f.ID)
+		if !slices.Contains(allowed, f.ID) {
+			missing = append(missing, f.ID)
+		}
+	}
+	now := c.now()
+	until := now.AddDate(0, 0, validationOverrideDays)
+	date := until.Format(time.DateOnly)
+	if pkg.IsZip() {
+		var allows []string
+		for _, id := range ids {
+			allows = append(allows, fmt.Sprintf("<allow until='%s'>%s</allow>", date, id))
+		}
+		return false, errHint(err, "To allow these changes, add "+strings.Join(allows, " ")+" to "+vespa.ValidationOverridesFile+" in the application package, and deploy again")
+	}
+	if len(missing) > 0 {
+		if c.checkInteractive() != nil {
+			return false, errHint(err, "To allow these changes until "+date+", deploy again with --add-validation-override "+strings.Join(ids, ","))
+		}
+		ok, cerr := c.confirm(fmt.Sprintf("Allow %s until %s in %s, and deploy again?", strings.Join(ids, ", "), date, vespa.ValidationOverridesFile), false)
+		if cerr != nil {
+			return false, cerr
+		}
+		if !ok {
+			return false, err
+		}
+	}
+	comment := fmt.Sprintf("Added by %s with vespa deploy at %s", c.userName(), now.UTC().Format(time.RFC3339))
+	path, werr := pkg.AddValidationOverrides(ids, until, comment)
+	if werr != nil {
+		return false, fmt.Errorf("could not add validation overrides: %w", werr)
+	}
+	c.printInfo("Allowed ", strings.Join(ids, ", "), " until ", date, " in ", color.CyanString(path))
+	return true, nil
+}
+
+// userName returns the name of the user running the CLI, as given by the environment.
+func (c *CLI) userName() string {
+	for _, name := range []string{"USER", "USERNAME"} {
+		if user := c.Environment[name]; user != "" {
+			return user
+		}
+	}
+	return "unknown user"
+}
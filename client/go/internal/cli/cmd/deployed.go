@@ -0,0 +1,72 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Fetching of what is deployed to the current target, for checks against it
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// fetchDeployed calls fetch, which fetches something deployed to the current target, while showing a spinner with
+// given message. If nothing is deployed, an error saying so is returned.
+func fetchDeployed[T any](cli *CLI, message string, fetch func() (T, error)) (T, error) {
+	var result T
+	err := cli.spinner(cli.Stderr, message, func() error {
+		var err error
+		result, err = fetch()
+		return err
+	})
+	if errors.Is(err, vespa.ErrNotFound) {
+		err = fmt.Errorf("no application package is deployed")
+	}
+	return result, err
+}
+
+// fetchDeployedDocumentTypes fetches the document types deployed to the current target.
+func (c *CLI) fetchDeployedDocumentTypes() ([]vespa.DocumentType, error) {
+	return fetchDeployed(c, "Fetching deployed schemas...", func() ([]vespa.DocumentType, error) {
+		target, err := c.target(targetOptions{noCertificate: c.selectAuthMethod() == "token"})
+		if err != nil {
+			return nil, err
+		}
+		return vespa.FetchDocumentTypes(target)
+	})
+}
+
+// deployedDocumentTypes holds the document types deployed to the current target, for checks of fed operations. They
+// are fetched once, when the first document type is looked up.
+type deployedDocumentTypes struct {
+	cli *CLI
+	// strict is whether a check fails when the document types cannot be fetched, instead of warning
+	strict bool
+	// check describes what is checked, and hint how to only warn, when the document types cannot be fetched
+	check string
+	hint  string
+
+	fetched  bool
+	docTypes map[string]vespa.DocumentType
+}
+
+// lookup returns the deployed document type of given name, and whether it is deployed. If fetching the document types
+// fails, a warning is printed, and no document type is found. When strict, the failure is returned instead.
+func (d *deployedDocumentTypes) lookup(name string) (vespa.DocumentType, bool, error) {
+	if !d.fetched {
+		d.fetched = true
+		docTypes, err := d.cli.fetchDeployedDocumentTypes()
+		if err != nil {
+			if d.strict {
+				return vespa.DocumentType{}, false, errHint(fmt.Errorf("could not check %s against the deployed schemas: %w", d.check, err), d.hint)
+			}
+			d.cli.printWarning(fmt.Sprintf("Could not check %s against the deployed schemas: %s", d.check, err))
+			return vespa.DocumentType{}, false, nil
+		}
+		d.docTypes = make(map[string]vespa.DocumentType, len(docTypes))
+		for _, docType := range docTypes {
+			d.docTypes[docType.Name] = docType
+		}
+	}
+	docType, ok := d.docTypes[name]
+	return docType, ok, nil
+}
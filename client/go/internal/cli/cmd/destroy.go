@@ -2,15 +2,61 @@
 package cmd
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
+// deploymentTarget wraps a target, overriding the deployment it manages.
+type deploymentTarget struct {
+	vespa.Target
+	deployment vespa.Deployment
+}
+
+func (t *deploymentTarget) Deployment() vespa.Deployment { return t.deployment }
+
+// destroyPlan describes a deployment that would be removed by destroy.
+type destroyPlan struct {
+	Tenant      string `json:"tenant"`
+	Application string `json:"application"`
+	Instance    string `json:"instance"`
+	Zone        string `json:"zone"`
+	Environment string `json:"environment"`
+	URL         string `json:"url"`
+	// RemovedLocal holds the local files removed with --remove-local
+	RemovedLocal []string `json:"removedLocal,omitempty"`
+	// Steps holds the steps of removing the deployment
+	Steps []stepResult `json:"steps,omitempty"`
+}
+
+func newDestroyPlan(deployment vespa.Deployment) destroyPlan {
+	return destroyPlan{
+		Tenant:      deployment.Application.Tenant,
+		Application: deployment.Application.Application,
+		Instance:    deployment.Application.Instance,
+		Zone:        deployment.Zone.String(),
+		Environment: deployment.Zone.Environment,
+		URL:         deployment.System.DeploymentURL(deployment),
+	}
+}
+
 func newDestroyCmd(cli *CLI) *cobra.Command {
-	force := false
+	var (
+		force        bool
+		allInstances bool
+		dryRun       bool
+		removeLocal  bool
+		format       string
+	)
 	cmd := &cobra.Command{
 		Use:   "destroy",
 		Short: "Remove a deployed Vespa application and its data",
@@ -19,9 +65,34 @@ func newDestroyCmd(cli *CLI) *cobra.Command {
 This command removes the currently deployed application and permanently
 deletes its data.
 
+The dev and perf deployments of the application instance in every zone are
+removed, as listed by Vespa Cloud. Use --zone to remove the deployment in a
+single zone only. If removing one deployment fails, the others are still
+removed, and the command fails at the end with the deployments which could
+not be removed.
+
 When run interactively, the command will prompt for confirmation before
-removing the application. When run non-interactively, the command will refuse
-to remove the application unless the --force option is given.
+removing the application, listing each deployment to remove. Confirmation is
+given by typing the full name of the application, i.e.
+tenant.application.instance. When run non-interactively, the
+command will refuse to remove the application unless the --force option is
+given.
+
+With --dry-run, the deployments that would be removed are printed, but nothing
+is removed. Use --format json to print them as JSON.
+
+With --all-instances, all dev and perf deployments of every instance of the
+application are removed. Production deployments found are skipped. In this
+case, confirmation is given by typing tenant.application.
+
+With --remove-local, the local files of each removed deployment are removed
+too, once the deployment is removed. These are the data plane certificate and
+private key of the application instance in the Vespa CLI home directory, the
+certificate from security/clients.pem of the application package in the
+working directory, and the application and instance options of the local
+configuration of that package, if they refer to the removed instance. Each
+removed file is listed. Files of other applications and instances, API keys,
+and the global configuration are never touched.
 
 This command can only be used to remove non-production deployments, in Vespa
 Cloud. See https://docs.vespa.ai/en/cloud/deleting-applications.html for how to remove
@@ -32,34 +103,352 @@ containers in use by the application. For example:
 https://github.com/vespa-engine/sample-apps/tree/master/examples/operations/multinode-HA#clean-up-after-testing`,
 		Example: `$ vespa destroy
 $ vespa destroy -a mytenant.myapp.myinstance
-$ vespa destroy --force`,
+$ vespa destroy -z dev.aws-us-east-1c
+$ vespa destroy -a mytenant.myapp --all-instances
+$ vespa destroy --force
+$ vespa destroy --force --remove-local
+$ vespa destroy --dry-run --format json`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
 			target, err := cli.target(targetOptions{supportedType: cloudTargetOnly})
 			if err != nil {
 				return err
 			}
-			description := target.Deployment().String()
-			env := target.Deployment().Zone.Environment
-			if env != "dev" && env != "perf" {
-				return errHint(fmt.Errorf("cannot remove production %s", description), "See https://docs.vespa.ai/en/cloud/deleting-applications.html")
-			}
-			ok := force
-			if !ok {
-				cli.printWarning(fmt.Sprintf("This operation will irrecoverably remove the %s and all of its data", color.RedString(description)))
-				ok, _ = cli.confirm("Proceed with removal?", false)
-			}
-			if ok {
-				err := vespa.Deactivate(vespa.DeploymentOptions{Target: target})
-				if err == nil {
-					cli.printSuccess(fmt.Sprintf("Removed %s", description))
+			if allInstances {
+				if cli.zones.given > 0 {
+					return fmt.Errorf("--all-instances cannot be combined with --%s", zoneFlag)
 				}
-				return err
+				return destroyAllInstances(cli, target, force, dryRun, removeLocal, format)
 			}
-			return fmt.Errorf("refusing to remove %s without confirmation", description)
+			return destroyInstance(cli, target, force, dryRun, removeLocal, format)
 		},
 	}
 	cmd.PersistentFlags().BoolVar(&force, "force", false, "Disable confirmation (default false)")
+	cmd.PersistentFlags().BoolVar(&allInstances, "all-instances", false, "Remove dev and perf deployments of all instances of the application (default false)")
+	cmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Print what would be removed, without removing anything (default false)")
+	cmd.PersistentFlags().BoolVar(&removeLocal, "remove-local", false, "Also remove the local certificate, key and configuration of each removed application instance (default false)")
+	cmd.PersistentFlags().StringVar(&format, "format", "human", "Output format of --dry-run. Must be 'human' (human-readable) or 'json'")
 	return cmd
 }
+
+// removableDeployments returns the non-production deployments of the application managed by target, of all its
+// instances if allInstances is true, and of the instance of target otherwise. Production deployments are skipped with a
+// warning.
+func removableDeployments(cli *CLI, target vespa.Target, allInstances bool) ([]vespa.Deployment, error) {
+	current := target.Deployment()
+	response, err := target.ShowApplicationInstance(current.Application, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var deployments []vespa.Deployment
+	for _, instance := range response.Instances {
+		if !allInstances && instance.Instance != current.Application.Instance {
+			continue
+		}
+		for _, d := range instance.Deployments {
+			deployment := vespa.Deployment{
+				System: current.System,
+				Application: vespa.ApplicationID{
+					Tenant:      current.Application.Tenant,
+					Application: current.Application.Application,
+					Instance:    instance.Instance,
+				},
+				Zone: vespa.ZoneID{Environment: d.Environment, Region: d.Region},
+			}
+			if d.Environment != "dev" && d.Environment != "perf" {
+				cli.printWarning(fmt.Sprintf("Skipping production %s", deployment))
+				continue
+			}
+			deployments = append(deployments, deployment)
+		}
+	}
+	return deployments, nil
+}
+
+// printDestroyPlan prints the given deployments. If list is false, a single deployment is printed as a JSON object
+// instead of an array.
+func printDestroyPlan(cli *CLI, format string, list bool, deployments ...vespa.Deployment) error {
+	if format == "json" {
+		plans := make([]destroyPlan, 0, len(deployments))
+		for _, d := range deployments {
+			plans = append(plans, newDestroyPlan(d))
+		}
+		enc := json.NewEncoder(cli.Stdout)
+		enc.SetIndent("", "  ")
+		if !list && len(plans) == 1 {
+			return enc.Encode(plans[0])
+		}
+		return enc.Encode(plans)
+	}
+	for _, d := range deployments {
+		plan := newDestroyPlan(d)
+		fmt.Fprintf(cli.Stdout, "Would remove %s\n", color.RedString(d.String()))
+		fmt.Fprintf(cli.Stdout, "  Zone:        %s\n", plan.Zone)
+		fmt.Fprintf(cli.Stdout, "  Environment: %s\n", plan.Environment)
+		fmt.Fprintf(cli.Stdout, "  Endpoint:    %s\n", color.CyanString(plan.URL))
+	}
+	return nil
+}
+
+// destroyInstance removes the deployments of the application instance of target, in the zone given by the zone flag,
+// or in all zones where it has dev and perf deployments if no zone is given.
+func destroyInstance(cli *CLI, target vespa.Target, force, dryRun, removeLocal bool, format string) error {
+	app := target.Deployment().Application
+	deployments := []vespa.Deployment{target.Deployment()}
+	if cli.zones.given > 0 {
+		env := target.Deployment().Zone.Environment
+		if env != "dev" && env != "perf" {
+			return errCode(codeProductionDestroy, fmt.Errorf("cannot remove production %s", target.Deployment()), "See https://docs.vespa.ai/en/cloud/deleting-applications.html")
+		}
+	} else {
+		var err error
+		if deployments, err = removableDeployments(cli, target, false); err != nil {
+			return err
+		}
+		if len(deployments) == 0 {
+			return errCode(codeDeploymentNotFound, fmt.Errorf("no removable deployments found for %s", app), "Use --zone to remove the deployment in a given zone")
+		}
+	}
+	if dryRun {
+		return printDestroyPlan(cli, format, false, deployments...)
+	}
+	description := deployments[0].String()
+	if len(deployments) > 1 {
+		description = "deployments of " + app.String()
+	}
+	ok := force
+	if !ok {
+		if len(deployments) == 1 {
+			cli.printWarning(fmt.Sprintf("This operation will irrecoverably remove the %s and all of its data", color.RedString(description)))
+		} else {
+			warnRemoval(cli, deployments)
+		}
+		ok, _ = cli.confirmExact(app.String())
+	}
+	if !ok {
+		return errCode(codeConfirmationRequired, fmt.Errorf("refusing to remove %s without confirmation", description))
+	}
+	removed, err := destroyDeployments(cli, target, deployments, removeLocal)
+	if err != nil {
+		return err
+	}
+	if len(removed) == 1 {
+		return cli.printResult(removed[0])
+	}
+	return cli.printResult(removed)
+}
+
+// warnRemoval prints a warning listing the given deployments, which are about to be removed.
+func warnRemoval(cli *CLI, deployments []vespa.Deployment) {
+	var sb strings.Builder
+	sb.WriteString("This operation will irrecoverably remove the following deployments and all of their data:")
+	for _, d := range deployments {
+		sb.WriteString("\n  ")
+		sb.WriteString(color.RedString(d.String()))
+	}
+	cli.printWarning(sb.String())
+}
+
+func destroyAllInstances(cli *CLI, target vespa.Target, force, dryRun, removeLocal bool, format string) error {
+	deployments, err := removableDeployments(cli, target, true)
+	if err != nil {
+		return err
+	}
+	app := target.Deployment().Application
+	appName := app.Tenant + "." + app.Application
+	if len(deployments) == 0 {
+		return errCode(codeDeploymentNotFound, fmt.Errorf("no removable deployments found for %s", appName))
+	}
+	if dryRun {
+		return printDestroyPlan(cli, format, true, deployments...)
+	}
+	ok := force
+	if !ok {
+		warnRemoval(cli, deployments)
+		ok, _ = cli.confirmExact(appName)
+	}
+	if !ok {
+		return errCode(codeConfirmationRequired, fmt.Errorf("refusing to remove deployments of %s without confirmation", appName))
+	}
+	removed, err := destroyDeployments(cli, target, deployments, removeLocal)
+	if err != nil {
+		return err
+	}
+	return cli.printResult(removed)
+}
+
+// destroyDeployments removes the given deployments, managed by target, and returns the plans of those removed. If
+// removing one deployment fails, the others are still removed, and an error listing those which failed is returned.
+// With removeLocal, the local files of each application instance are removed with its last deployment, if all its
+// deployments were removed.
+func destroyDeployments(cli *CLI, target vespa.Target, deployments []vespa.Deployment, removeLocal bool) ([]destroyPlan, error) {
+	last := make(map[vespa.ApplicationID]int)
+	for i, d := range deployments {
+		last[d.Application] = i
+	}
+	failedApps := make(map[vespa.ApplicationID]bool)
+	var failed []string
+	removed := make([]destroyPlan, 0, len(deployments))
+	for i, d := range deployments {
+		removeFiles := removeLocal && last[d.Application] == i && !failedApps[d.Application]
+		plan, err := destroyDeployment(cli, &deploymentTarget{Target: target, deployment: d}, d, removeFiles)
+		if err != nil {
+			if len(deployments) == 1 {
+				return nil, err
+			}
+			cli.printErr(err)
+			failedApps[d.Application] = true
+			failed = append(failed, d.String())
+			continue
+		}
+		removed = append(removed, plan)
+	}
+	if len(failed) > 0 {
+		return removed, fmt.Errorf("could not remove %d of %d deployments: %s", len(failed), len(deployments), strings.Join(failed, ", "))
+	}
+	return removed, nil
+}
+
+// destroyDeployment removes deployment d, managed by target, and its local files if removeLocal is true.
+func destroyDeployment(cli *CLI, target vespa.Target, d vespa.Deployment, removeLocal bool) (destroyPlan, error) {
+	firstStep := len(cli.steps)
+	plan := newDestroyPlan(d)
+	err := cli.step(fmt.Sprintf("Removing %s", d), func() error {
+		return vespa.Deactivate(vespa.DeploymentOptions{Target: target})
+	})
+	if err != nil {
+		return plan, err
+	}
+	cli.printSuccess(fmt.Sprintf("Removed %s", d))
+	if removeLocal {
+		err := cli.step("Removing local files", func() error {
+			var err error
+			plan.RemovedLocal, err = removeLocalFiles(cli, d.Application)
+			return err
+		})
+		if err != nil {
+			return plan, err
+		}
+	}
+	plan.Steps = cli.steps[firstStep:]
+	return plan, nil
+}
+
+// removeLocalFiles removes the local files of application instance app, and returns the paths of the removed files.
+// These are the files in the directory of app in the CLI home directory, the certificate of app from
+// security/clients.pem of the application package in the working directory, and the options of the local
+// configuration referring to app.
+func removeLocalFiles(cli *CLI, app vespa.ApplicationID) ([]string, error) {
+	var removed []string
+	appDir := filepath.Join(cli.config.homeDir, app.String())
+	// The certificate must be removed from the application package before it is removed from the home directory
+	if certificatePEM, err := os.ReadFile(filepath.Join(appDir, "data-plane-public-cert.pem")); err == nil {
+		path, err := removeClientCertificate(cli, certificatePEM)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			removed = append(removed, path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	entries, err := os.ReadDir(appDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(appDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+		removed = append(removed, path)
+	}
+	if err == nil {
+		if err := os.Remove(appDir); err != nil {
+			return nil, err
+		}
+	}
+	path, err := removeLocalApplicationConfig(cli, app)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		removed = append(removed, path)
+	}
+	for _, path := range removed {
+		cli.printInfo("Removed ", color.CyanString(path))
+	}
+	if len(removed) == 0 {
+		cli.printInfo("No local files found for ", app.String())
+	}
+	return removed, nil
+}
+
+// removeClientCertificate removes the certificate in certificatePEM from security/clients.pem of the application
+// package in the working directory, if present, and returns the path of the changed file.
+func removeClientCertificate(cli *CLI, certificatePEM []byte) (string, error) {
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil {
+		return "", nil
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", nil
+	}
+	pkg, err := cli.applicationPackageFrom(nil, vespa.PackageOptions{SourceOnly: true})
+	if err != nil || pkg.IsZip() {
+		return "", nil // No application package to change
+	}
+	certificates, err := pkg.ClientCertificates()
+	if err != nil {
+		return "", err
+	}
+	fingerprint := vespa.FingerprintSHA256(certificate)
+	for _, c := range certificates {
+		if vespa.FingerprintSHA256(c) == fingerprint {
+			if err := pkg.RemoveClientCertificate(fingerprint); err != nil {
+				return "", err
+			}
+			return filepath.Join(pkg.Path, "security", "clients.pem") + " (certificate " + fingerprint + ")", nil
+		}
+	}
+	return "", nil
+}
+
+// removeLocalApplicationConfig unsets the application and instance options of the local configuration, if they refer
+// to app, and returns the path of the changed configuration file.
+func removeLocalApplicationConfig(cli *CLI, app vespa.ApplicationID) (string, error) {
+	local := cli.config.local
+	if local == nil {
+		return "", nil
+	}
+	value, ok := local.getNonEmpty(applicationFlag)
+	if !ok {
+		return "", nil
+	}
+	localApp, err := vespa.ApplicationFromString(value)
+	if err != nil {
+		return "", nil
+	}
+	if instance, ok := local.getNonEmpty(instanceFlag); ok {
+		localApp.Instance = instance
+	}
+	if localApp != app {
+		return "", nil
+	}
+	for _, option := range []string{applicationFlag, instanceFlag} {
+		if err := local.unset(option); err != nil {
+			return "", err
+		}
+	}
+	if err := local.write(); err != nil {
+		return "", err
+	}
+	return local.path() + " (options " + applicationFlag + " and " + instanceFlag + ")", nil
+}
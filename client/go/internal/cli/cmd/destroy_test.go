@@ -3,6 +3,9 @@ package cmd
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,19 +32,41 @@ func TestDestroy(t *testing.T) {
 	stderr.Reset()
 	buf.WriteString("\n")
 	require.NotNil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c"))
-	warning := "Warning: This operation will irrecoverably remove the deployment of foo.bar.baz in dev.aws-us-east-1c and all of its data"
-	confirmation := "Proceed with removal? [y/N] "
-	assert.Equal(t, warning+"\nError: refusing to remove deployment of foo.bar.baz in dev.aws-us-east-1c without confirmation\n", stderr.String())
+	warning := "Warning: This operation will irrecoverably remove the deployment of foo.bar.baz in dev.aws-us-east-1c and all of its data\n"
+	confirmation := "Type foo.bar.baz to confirm: "
+	refusal := "Error: refusing to remove deployment of foo.bar.baz in dev.aws-us-east-1c without confirmation [CONFIRMATION_REQUIRED]\n"
+	assert.Equal(t, warning+"Error: confirmation does not match: expected \"foo.bar.baz\", got \"\"\n"+refusal, stderr.String())
 	assert.Equal(t, confirmation, stdout.String())
 
-	// Removes deployment with confirmation
+	// No removal with mismatching confirmation
+	stdout.Reset()
+	stderr.Reset()
+	buf.WriteString("foo.bar\n")
+	require.NotNil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c"))
+	assert.Equal(t, warning+"Error: confirmation does not match: expected \"foo.bar.baz\", got \"foo.bar\"\n"+refusal, stderr.String())
+
+	// Simple yes is not accepted
 	stdout.Reset()
 	stderr.Reset()
 	buf.WriteString("y\n")
+	require.NotNil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c"))
+
+	// Removes deployment with confirmation
+	stdout.Reset()
+	stderr.Reset()
+	buf.WriteString("foo.bar.baz\n")
 	require.Nil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c"))
 	success := "Success: Removed deployment of foo.bar.baz in dev.aws-us-east-1c\n"
 	assert.Equal(t, confirmation+success, stdout.String())
 
+	// No removal when non-interactive
+	stdout.Reset()
+	stderr.Reset()
+	cli.isTerminal = func() bool { return false }
+	require.NotNil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c"))
+	assert.Equal(t, warning+refusal, stderr.String())
+	cli.isTerminal = func() bool { return true }
+
 	// Force flag always removes deployment
 	stdout.Reset()
 	stderr.Reset()
@@ -49,8 +74,9 @@ func TestDestroy(t *testing.T) {
 	assert.Equal(t, success, stdout.String())
 
 	// Cannot remove a prod deployment
+	stderr.Reset()
 	require.NotNil(t, cli.Run("destroy", "-z", "prod.aws-us-east-1c"))
-	assert.Equal(t, "Error: cannot remove production deployment of foo.bar.baz in prod.aws-us-east-1c\nHint: See https://docs.vespa.ai/en/cloud/deleting-applications.html\n", stderr.String())
+	assert.Equal(t, "Error: cannot remove production deployment of foo.bar.baz in prod.aws-us-east-1c [PRODUCTION_DESTROY_REFUSED]\nHint: See https://docs.vespa.ai/en/cloud/deleting-applications.html\n", stderr.String())
 
 	// Cannot remove a local deployment at all
 	stderr.Reset()
@@ -59,3 +85,221 @@ func TestDestroy(t *testing.T) {
 	require.NotNil(t, cli.Run("destroy", "-z", "prod.aws-us-east-1c"))
 	assert.Equal(t, "Error: command does not support local target\nHint: to switch target run the following:\nHint: $ vespa config set target cloud\n", stderr.String())
 }
+
+func TestDestroyAllInstances(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	cli.isTerminal = func() bool { return true }
+	var buf bytes.Buffer
+	cli.Stdin = &buf
+
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "foo.bar"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+
+	instances := `{
+  "instances": [
+    {"instance": "alice", "deployments": [{"environment": "dev", "region": "aws-us-east-1c"}]},
+    {"instance": "bob", "deployments": [{"environment": "perf", "region": "aws-us-east-1c"}, {"environment": "prod", "region": "aws-us-east-1c"}]}
+  ]
+}`
+	listing := mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar", Status: 200, Body: []byte(instances)}
+
+	// No removal without confirmation
+	stdout.Reset()
+	stderr.Reset()
+	httpClient.NextResponse(listing)
+	buf.WriteString("\n")
+	require.NotNil(t, cli.Run("destroy", "--all-instances"))
+	warnings := "Warning: Skipping production deployment of foo.bar.bob in prod.aws-us-east-1c\n" +
+		"Warning: This operation will irrecoverably remove the following deployments and all of their data:\n" +
+		"  deployment of foo.bar.alice in dev.aws-us-east-1c\n" +
+		"  deployment of foo.bar.bob in perf.aws-us-east-1c\n"
+	assert.Equal(t, warnings+"Error: confirmation does not match: expected \"foo.bar\", got \"\"\n"+"Error: refusing to remove deployments of foo.bar without confirmation [CONFIRMATION_REQUIRED]\n", stderr.String())
+
+	// Removes each deployment with confirmation
+	stdout.Reset()
+	stderr.Reset()
+	httpClient.NextResponse(listing)
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar/instance/alice/environment/dev/region/aws-us-east-1c", Status: 200})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar/instance/bob/environment/perf/region/aws-us-east-1c", Status: 200})
+	buf.WriteString("foo.bar\n")
+	require.Nil(t, cli.Run("destroy", "--all-instances"))
+	assert.Equal(t, "Type foo.bar to confirm: "+
+		"Success: Removed deployment of foo.bar.alice in dev.aws-us-east-1c\n"+
+		"Success: Removed deployment of foo.bar.bob in perf.aws-us-east-1c\n", stdout.String())
+
+	// Non-interactive removal requires force
+	stdout.Reset()
+	stderr.Reset()
+	cli.isTerminal = func() bool { return false }
+	httpClient.NextResponse(listing)
+	require.NotNil(t, cli.Run("destroy", "--all-instances"))
+	httpClient.NextResponse(listing)
+	httpClient.NextStatus(200)
+	httpClient.NextStatus(200)
+	require.Nil(t, cli.Run("destroy", "--all-instances", "--force"))
+	assert.Equal(t, "DELETE", httpClient.LastRequest.Method)
+}
+
+func TestDestroyAllZones(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	cli.isTerminal = func() bool { return true }
+	var buf bytes.Buffer
+	cli.Stdin = &buf
+
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "foo.bar.baz"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+
+	instances := `{
+  "instances": [
+    {"instance": "baz", "deployments": [{"environment": "dev", "region": "aws-us-east-1c"}, {"environment": "dev", "region": "gcp-us-central1-f"}, {"environment": "prod", "region": "aws-us-east-1c"}]},
+    {"instance": "other", "deployments": [{"environment": "dev", "region": "aws-us-east-1c"}]}
+  ]
+}`
+	listing := mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar", Status: 200, Body: []byte(instances)}
+
+	// Deployments of the instance in every zone are listed, and removed with confirmation
+	stdout.Reset()
+	stderr.Reset()
+	httpClient.NextResponse(listing)
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar/instance/baz/environment/dev/region/aws-us-east-1c", Status: 200})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/foo/application/bar/instance/baz/environment/dev/region/gcp-us-central1-f", Status: 200})
+	buf.WriteString("foo.bar.baz\n")
+	require.Nil(t, cli.Run("destroy"))
+	warnings := "Warning: Skipping production deployment of foo.bar.baz in prod.aws-us-east-1c\n" +
+		"Warning: This operation will irrecoverably remove the following deployments and all of their data:\n" +
+		"  deployment of foo.bar.baz in dev.aws-us-east-1c\n" +
+		"  deployment of foo.bar.baz in dev.gcp-us-central1-f\n"
+	assert.True(t, strings.HasPrefix(stderr.String(), warnings), stderr.String())
+	assert.Equal(t, "Type foo.bar.baz to confirm: "+
+		"Success: Removed deployment of foo.bar.baz in dev.aws-us-east-1c\n"+
+		"Success: Removed deployment of foo.bar.baz in dev.gcp-us-central1-f\n", stdout.String())
+	assert.Equal(t, 3, len(httpClient.Requests))
+
+	// Other deployments are removed when one fails
+	stdout.Reset()
+	stderr.Reset()
+	httpClient.NextResponse(listing)
+	httpClient.NextResponseString(500, `{"error-code": "INTERNAL_SERVER_ERROR", "message": "boom"}`)
+	httpClient.NextStatus(200)
+	require.NotNil(t, cli.Run("destroy", "--force"))
+	assert.Equal(t, "Success: Removed deployment of foo.bar.baz in dev.gcp-us-central1-f\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Error: could not remove 1 of 2 deployments: deployment of foo.bar.baz in dev.aws-us-east-1c\n")
+	assert.Equal(t, "DELETE", httpClient.LastRequest.Method)
+
+	// Removed deployments are printed as a list of JSON objects
+	stdout.Reset()
+	httpClient.NextResponse(listing)
+	httpClient.NextStatus(200)
+	httpClient.NextStatus(200)
+	require.Nil(t, cli.Run("destroy", "--force", "-o", "json"))
+	assert.Contains(t, stdout.String(), `"zone": "dev.gcp-us-central1-f"`)
+	assert.True(t, strings.HasPrefix(stdout.String(), "[\n"), stdout.String())
+
+	// Nothing to remove
+	stderr.Reset()
+	httpClient.NextResponse(listing)
+	require.NotNil(t, cli.Run("destroy", "-a", "foo.bar.none", "--force", "-o", "human"))
+	assert.Equal(t, "Error: no removable deployments found for foo.bar.none [DEPLOYMENT_NOT_FOUND]\nHint: Use --zone to remove the deployment in a given zone\n", stderr.String())
+
+	stderr.Reset()
+	require.NotNil(t, cli.Run("destroy", "--all-instances", "-z", "dev.aws-us-east-1c", "-o", "human"))
+	assert.Equal(t, "Error: --all-instances cannot be combined with --zone\n", stderr.String())
+}
+
+func TestDestroyDryRun(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "foo.bar.baz"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c", "--force", "--dry-run"))
+	assert.Equal(t, `Would remove deployment of foo.bar.baz in dev.aws-us-east-1c
+  Zone:        dev.aws-us-east-1c
+  Environment: dev
+  Endpoint:    https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/foo/application/bar/instance/baz/environment/dev/region/aws-us-east-1c
+`, stdout.String())
+	assert.Empty(t, httpClient.Requests)
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c", "--dry-run", "--format", "json"))
+	assert.Equal(t, `{
+  "tenant": "foo",
+  "application": "bar",
+  "instance": "baz",
+  "zone": "dev.aws-us-east-1c",
+  "environment": "dev",
+  "url": "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/foo/application/bar/instance/baz/environment/dev/region/aws-us-east-1c"
+}
+`, stdout.String())
+	assert.Empty(t, httpClient.Requests)
+
+	// Production deployment still fails
+	stdout.Reset()
+	require.NotNil(t, cli.Run("destroy", "-z", "prod.aws-us-east-1c", "--dry-run"))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "Error: cannot remove production deployment of foo.bar.baz in prod.aws-us-east-1c [PRODUCTION_DESTROY_REFUSED]\nHint: See https://docs.vespa.ai/en/cloud/deleting-applications.html\n", stderr.String())
+
+	stderr.Reset()
+	require.NotNil(t, cli.Run("destroy", "--dry-run", "--format", "xml"))
+	assert.Equal(t, "Error: invalid format: xml\n", stderr.String())
+}
+
+func TestDestroyRemoveLocal(t *testing.T) {
+	appDir, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	t.Cleanup(func() { os.Chdir(wd) })
+	require.Nil(t, os.Chdir(pkgDir))
+
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a2.i1"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+	require.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	require.Nil(t, cli.Run("config", "set", "--local", "application", "t1.a1.i1"))
+	require.Nil(t, cli.Run("auth", "cert", "--no-add=false"))
+
+	homeDir := cli.config.homeDir
+	otherAppDir := filepath.Join(homeDir, "t1.a2.i1")
+	certificate := filepath.Join(homeDir, "t1.a1.i1", "data-plane-public-cert.pem")
+	privateKey := filepath.Join(homeDir, "t1.a1.i1", "data-plane-private-key.pem")
+	clientsFile := filepath.Join(appDir, "security", "clients.pem")
+	require.FileExists(t, clientsFile)
+
+	stdout.Reset()
+	stderr.Reset()
+	httpClient.NextStatus(200)
+	require.Nil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c", "--force", "--remove-local"))
+	assert.Equal(t, "Success: Removed deployment of t1.a1.i1 in dev.aws-us-east-1c\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Removed "+filepath.Join("src", "main", "application", "security", "clients.pem")+" (certificate ")
+	assert.Contains(t, stderr.String(), "Removed "+privateKey+"\nRemoved "+certificate+"\n")
+	assert.Contains(t, stderr.String(), "Removed "+filepath.Join(".vespa", "config.yaml")+" (options application and instance)\n")
+	assert.NoDirExists(t, filepath.Join(homeDir, "t1.a1.i1"))
+	assert.NoFileExists(t, clientsFile)
+	assert.FileExists(t, filepath.Join(otherAppDir, "data-plane-public-cert.pem"))
+	assert.FileExists(t, filepath.Join(otherAppDir, "data-plane-private-key.pem"))
+	assert.FileExists(t, filepath.Join(homeDir, "t1.api-key.pem"))
+	data, err := os.ReadFile(filepath.Join(pkgDir, ".vespa", "config.yaml"))
+	require.Nil(t, err)
+	assert.Equal(t, "{}\n", string(data))
+
+	// Nothing left to remove
+	stderr.Reset()
+	httpClient.NextStatus(200)
+	require.Nil(t, cli.Run("destroy", "-z", "dev.aws-us-east-1c", "-a", "t1.a1.i1", "--force", "--remove-local"))
+	assert.Equal(t, stepLines("Removing deployment of t1.a1.i1 in dev.aws-us-east-1c", "done")+
+		"Removing local files ...\nNo local files found for t1.a1.i1\nRemoving local files ... done (0.0s)\n", stderr.String())
+}
@@ -0,0 +1,189 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa diff command
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+func newDiffCmd(cli *CLI) *cobra.Command {
+	var (
+		showContent bool
+		excludes    []string
+	)
+	cmd := &cobra.Command{
+		Use:   "diff [application-directory-or-file]",
+		Short: "Show differences between an application package and the deployed one",
+		Long: `Show differences between an application package and the deployed one.
+
+This command fetches the application package currently deployed to the target,
+and prints the files which are added, removed or modified in the given
+application package, compared to the deployed one. Files are compared by their
+SHA-256 checksum.
+
+With --context, a unified diff is printed for each modified text file, such as
+services.xml or schemas. Binary files are described by size and checksum only.
+
+If application directory is not specified, it defaults to working directory.`,
+		Example: `$ vespa diff
+$ vespa diff --context my-app
+$ vespa diff -t cloud target/application.zip`,
+		Args:              cobra.MaximumNArgs(1),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{Compiled: true})
+			if err != nil {
+				return err
+			}
+			pkg.Exclude = excludes
+			target, err := cli.target(targetOptions{})
+			if err != nil {
+				return err
+			}
+			return cli.printPackageDiff(target, pkg, showContent)
+		},
+	}
+	cmd.Flags().BoolVar(&showContent, "context", false, "Show a unified diff of each modified text file")
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil, `Exclude files matching this pattern from the application package, in addition to those in .vespaignore. Can be repeated`)
+	return cmd
+}
+
+// printPackageDiff prints the files which differ between pkg and the application package deployed to target. If
+// showContent is true, a unified diff of each modified text file is printed too.
+func (c *CLI) printPackageDiff(target vespa.Target, pkg vespa.ApplicationPackage, showContent bool) error {
+	tmpDir, err := os.MkdirTemp("", "vespa-diff")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	var fetched vespa.FetchResult
+	err = c.spinner(c.Stderr, "Fetching deployed application package...", func() error {
+		fetched, err = vespa.Fetch(vespa.DeploymentOptions{Target: target}, tmpDir, vespa.FetchOptions{})
+		return err
+	})
+	deployedFiles := map[string]vespa.PackageFile{}
+	description := "deployed application package"
+	switch {
+	case errors.Is(err, vespa.ErrNotFound):
+		description = "empty application package, as none is deployed"
+	case err != nil:
+		return fmt.Errorf("could not fetch deployed application package: %w", err)
+	default:
+		deployed := vespa.ApplicationPackage{Path: fetched.Path}
+		if deployedFiles, err = deployed.Files(); err != nil {
+			return err
+		}
+		if fetched.Generation > 0 {
+			description += " at config generation " + strconv.FormatInt(fetched.Generation, 10)
+		} else if fetched.Build > 0 {
+			description += " build " + strconv.FormatInt(fetched.Build, 10)
+		}
+	}
+	localFiles, err := pkg.Files()
+	if err != nil {
+		return err
+	}
+	changes := vespa.DiffFiles(deployedFiles, localFiles)
+	w := c.Stdout
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "No changes in %s compared to %s\n", color.CyanString("'"+pkg.Path+"'"), description)
+		return nil
+	}
+	fmt.Fprintf(w, "Changes in %s compared to %s:\n", color.CyanString("'"+pkg.Path+"'"), description)
+	var added, removed, modified int
+	for _, change := range changes {
+		switch {
+		case change.Added():
+			added++
+			fmt.Fprintf(w, "  %s %s%s\n", color.GreenString("added:   "), change.Path, describeBinary(change.New))
+		case change.Removed():
+			removed++
+			fmt.Fprintf(w, "  %s %s%s\n", color.RedString("removed: "), change.Path, describeBinary(change.Old))
+		default:
+			modified++
+			fmt.Fprintf(w, "  %s %s%s\n", color.YellowString("modified:"), change.Path, describeBinaryChange(change.Old, change.New))
+			if showContent && isText(change.Old) && isText(change.New) {
+				printUnifiedDiff(w, change)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d files changed: %d added, %d modified, %d removed\n", len(changes), added, modified, removed)
+	return nil
+}
+
+// isText returns whether the content of file is known, and looks like text.
+func isText(file *vespa.PackageFile) bool {
+	return file.Content != nil && utf8.Valid(file.Content) && !bytes.ContainsRune(file.Content, 0)
+}
+
+func shortHash(file *vespa.PackageFile) string { return file.SHA256[:12] }
+
+func describeBinary(file *vespa.PackageFile) string {
+	if isText(file) {
+		return ""
+	}
+	return fmt.Sprintf(" (binary, %s, sha256 %s)", formatSize(file.Size), shortHash(file))
+}
+
+func describeBinaryChange(oldFile, newFile *vespa.PackageFile) string {
+	if isText(oldFile) && isText(newFile) {
+		return ""
+	}
+	return fmt.Sprintf(" (binary, %s → %s, sha256 %s → %s)", formatSize(oldFile.Size), formatSize(newFile.Size), shortHash(oldFile), shortHash(newFile))
+}
+
+func printUnifiedDiff(w io.Writer, change vespa.FileChange) {
+	printLinesDiff(w, "deployed/"+change.Path, "local/"+change.Path, change.Old.Content, change.New.Content)
+}
+
+// printLinesDiff prints a unified diff, with colors, from oldContent of fromFile to newContent of toFile.
+func printLinesDiff(w io.Writer, fromFile, toFile string, oldContent, newContent []byte) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(oldContent),
+		B:        splitLines(newContent),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = color.New(color.Bold).Sprint(line)
+		case strings.HasPrefix(line, "+"):
+			line = color.GreenString(line)
+		case strings.HasPrefix(line, "-"):
+			line = color.RedString(line)
+		case strings.HasPrefix(line, "@@"):
+			line = color.CyanString(line)
+		}
+		fmt.Fprintln(w, "    "+line)
+	}
+}
+
+// splitLines splits content into lines, each ending with a newline.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestDiff(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t, "NO_COLOR=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	appDir := writeDiffApplication(t)
+
+	mockDeployedApplication(httpClient)
+	require.Nil(t, cli.Run("diff", appDir))
+	assert.Equal(t, "Changes in '"+appDir+"' compared to deployed application package at config generation 3:\n"+
+		"  modified: model.bin (binary, 2 B → 2 B, sha256 b413f47d13ee → fcf0a6c700dd)\n"+
+		"  added:    new.txt\n"+
+		"  removed:  old.txt\n"+
+		"  modified: services.xml\n"+
+		"4 files changed: 1 added, 2 modified, 1 removed\n", stdout.String())
+
+	mockDeployedApplication(httpClient)
+	stdout.Reset()
+	require.Nil(t, cli.Run("diff", "--context", appDir))
+	assert.Contains(t, stdout.String(), "  modified: services.xml\n"+
+		"    --- deployed/services.xml\n"+
+		"    +++ local/services.xml\n"+
+		"    @@ -1,3 +1,3 @@\n"+
+		"     <services>\n"+
+		"    -  <container/>\n"+
+		"    +  <content/>\n"+
+		"     </services>\n")
+
+	// Nothing deployed
+	httpClient.NextStatus(404)
+	stdout.Reset()
+	require.Nil(t, cli.Run("diff", appDir))
+	assert.Contains(t, stdout.String(), "Changes in '"+appDir+"' compared to empty application package, as none is deployed:\n")
+	assert.Contains(t, stdout.String(), "3 files changed: 3 added, 0 modified, 0 removed\n")
+}
+
+func TestDeployDiff(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	appDir := writeDiffApplication(t)
+
+	// Only shows diff
+	mockDeployedApplication(httpClient)
+	require.Nil(t, cli.Run("deploy", "--diff", appDir))
+	assert.Contains(t, stdout.String(), "4 files changed: 1 added, 2 modified, 1 removed\n")
+	assert.True(t, httpClient.Consumed())
+	assert.Equal(t, 5, len(httpClient.Requests)) // No upload
+
+	// Refuses to deploy without confirmation
+	cli.isTerminal = func() bool { return true }
+	var stdin bytes.Buffer
+	cli.Stdin = &stdin
+	stdin.WriteString("n\n")
+	mockDeployedApplication(httpClient)
+	require.NotNil(t, cli.Run("deploy", "--diff", "--confirm", appDir))
+	assert.Equal(t, "Error: refusing to deploy without confirmation [CONFIRMATION_REQUIRED]\n", stderr.String())
+	assert.Equal(t, 10, len(httpClient.Requests))
+
+	// Deploys after confirmation
+	stdin.WriteString("y\n")
+	stdout.Reset()
+	mockDeployedApplication(httpClient)
+	httpClient.NextResponseString(200, `{"session-id":"4"}`)
+	require.Nil(t, cli.Run("deploy", "--diff", "--confirm", appDir))
+	assert.Contains(t, stdout.String(), "Deploy '"+appDir+"'? [y/N] Success: Deployed '"+appDir+"' with session ID 4\n")
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/prepareandactivate", httpClient.LastRequest.URL.String())
+}
+
+func writeDiffApplication(t *testing.T) string {
+	appDir := t.TempDir()
+	files := map[string]string{
+		"services.xml": "<services>\n  <content/>\n</services>\n",
+		"model.bin":    "\x00\x02",
+		"new.txt":      "new",
+	}
+	for name, content := range files {
+		require.Nil(t, os.WriteFile(filepath.Join(appDir, name), []byte(content), 0644))
+	}
+	return appDir
+}
+
+func mockDeployedApplication(httpClient *mock.HTTPClient) {
+	contentURL := "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content/"
+	httpClient.NextResponseString(200, `{"generation": 3}`)
+	httpClient.NextResponseString(200, `["`+contentURL+`services.xml","`+contentURL+`model.bin","`+contentURL+`old.txt"]`)
+	httpClient.NextResponseString(200, "<services>\n  <container/>\n</services>\n")
+	httpClient.NextResponseString(200, "\x00\x01")
+	httpClient.NextResponseString(200, "old")
+}
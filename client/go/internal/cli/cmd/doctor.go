@@ -0,0 +1,449 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/config"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// Statuses of the checks made by vespa doctor.
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+	doctorSkip = "skip"
+)
+
+const (
+	// clockSkewWarning is the difference between the local clock and the clock of the target above which a warning is
+	// given.
+	clockSkewWarning = time.Minute
+	// clockSkewLimit is the difference between the local clock and the clock of the target above which the clock check
+	// fails, as certificates and access tokens may then be considered invalid.
+	clockSkewLimit = 5 * time.Minute
+)
+
+// doctorCheck is the result of a check made by vespa doctor.
+type doctorCheck struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+type doctorJSON struct {
+	Version  string         `json:"version"`
+	Platform string         `json:"platform"`
+	Passed   bool           `json:"passed"`
+	Checks   []doctorCheck  `json:"checks"`
+	Config   map[string]any `json:"config"`
+}
+
+// doctorChecks are the checks made by vespa doctor, in the order they are made. Checks which need a target are skipped
+// if no target can be created.
+var doctorChecks = []struct {
+	name        string
+	needsTarget bool
+	run         func(cli *CLI, target vespa.Target) doctorCheck
+}{
+	{"config", false, doctorConfig},
+	{"version", false, doctorVersion},
+	{"target", true, doctorTarget},
+	{"clock", true, doctorClock},
+	{"auth", true, doctorAuth},
+	{"certificate", true, doctorCertificate},
+}
+
+// urlInText matches the URLs in the messages and hints of checks, which are redacted before they are shown.
+var urlInText = regexp.MustCompile(`https?://[^\s'"]+`)
+
+func doctorCheckNames() []string {
+	names := make([]string, 0, len(doctorChecks))
+	for _, check := range doctorChecks {
+		names = append(names, check.name)
+	}
+	return names
+}
+
+func newDoctorCmd(cli *CLI) *cobra.Command {
+	var (
+		format string
+		skip   []string
+	)
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the setup of Vespa CLI",
+		Long: `Diagnose the setup of Vespa CLI.
+
+This command runs a series of checks of the configuration and environment of
+Vespa CLI, and prints whether each passed, gave a warning or failed, with hints
+on how to resolve problems. The checks are:
+
+config       The options set in the global and local configuration, and in the
+             active profile, are known and have valid values
+version      This is the latest release of Vespa CLI, as found on GitHub
+target       The deploy API of the target, i.e. the config server or Vespa
+             Cloud, can be reached
+clock        The local clock is within a minute of the clock of the target
+auth         For Vespa Cloud, the API key or access token is accepted
+certificate  For Vespa Cloud with certificate (mTLS) authentication, the data
+             plane certificate is valid and included in the application
+             package of the deployment
+
+Checks are skipped with --skip, e.g. --skip version in environments without
+access to GitHub.
+
+The report ends with the configuration, as shown by 'vespa config get --redact',
+and the output has secrets removed, such that it is safe to share, e.g. in an
+issue. The command fails if any check fails.`,
+		Example: `$ vespa doctor
+$ vespa doctor --format json
+$ vespa doctor --skip version,clock`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			names := doctorCheckNames()
+			for _, name := range skip {
+				if !slices.Contains(names, name) {
+					return errHint(fmt.Errorf("invalid check: %s", name), "Must be one of "+strings.Join(names, ", "))
+				}
+			}
+			checks := runDoctorChecks(cli, skip)
+			failed := 0
+			for _, check := range checks {
+				if check.Status == doctorFail {
+					failed++
+				}
+			}
+			if format == "json" {
+				report := doctorJSON{
+					Version:  cli.version.String(),
+					Platform: runtime.GOOS + "/" + runtime.GOARCH,
+					Passed:   failed == 0,
+					Checks:   checks,
+					Config:   redactedConfig(cli.config),
+				}
+				if err := writeJSON(cli, report); err != nil {
+					return err
+				}
+			} else {
+				printDoctorReport(cli, checks)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	cmd.Flags().StringSliceVar(&skip, "skip", nil, "Checks to skip, any of "+strings.Join(doctorCheckNames(), ", "))
+	return cmd
+}
+
+// runDoctorChecks runs all checks not named in skip, and returns their results with secrets redacted.
+func runDoctorChecks(cli *CLI, skip []string) []doctorCheck {
+	target, targetErr := cli.target(targetOptions{noCertificate: true})
+	checks := make([]doctorCheck, 0, len(doctorChecks))
+	for _, c := range doctorChecks {
+		var check doctorCheck
+		switch {
+		case slices.Contains(skip, c.name):
+			check = doctorCheck{Status: doctorSkip, Message: "skipped by --skip"}
+		case c.needsTarget && targetErr != nil:
+			check = doctorCheck{Status: doctorSkip, Message: "skipped, as no target could be created"}
+			if c.name == "target" {
+				check = doctorCheckOfErr("could not create target", targetErr)
+			}
+		default:
+			check = c.run(cli, target)
+		}
+		check.Name = c.name
+		check.Message = redactText(check.Message)
+		if len(check.Hints) > 0 {
+			hints := make([]string, len(check.Hints)) // Hints may be shared, e.g. with errorCodes
+			for i, hint := range check.Hints {
+				hints[i] = redactText(hint)
+			}
+			check.Hints = hints
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// redactText returns s with the home directory and the secrets of any URLs in it redacted.
+func redactText(s string) string {
+	return redactHome(urlInText.ReplaceAllStringFunc(s, redactURL))
+}
+
+// doctorCheckOfErr returns a failed check with the message and hints of err.
+func doctorCheckOfErr(message string, err error) doctorCheck {
+	check := doctorCheck{Status: doctorFail, Message: message + ": " + err.Error()}
+	var cliErr ErrCLI
+	if errors.As(err, &cliErr) {
+		check.Message = message + ": " + cliErr.error.Error()
+		check.Hints = cliErr.hints
+	}
+	return check
+}
+
+// doctorCheckOfAuth converts the result of a check of data plane authentication.
+func doctorCheckOfAuth(check authCheck) doctorCheck {
+	status := doctorPass
+	switch {
+	case check.Skipped:
+		status = doctorSkip
+	case !check.Passed:
+		status = doctorFail
+	}
+	return doctorCheck{Status: status, Message: check.Message, Hints: check.Hints}
+}
+
+// doctorConfig checks that the options set in all configuration files are known, and have valid values.
+func doctorConfig(cli *CLI, _ vespa.Target) doctorCheck {
+	type configFile struct {
+		path   string
+		values *config.Config
+	}
+	var (
+		files    = []configFile{{cli.config.path(), cli.config.config}}
+		problems []string
+		count    int
+	)
+	if cli.config.local != nil {
+		files = append(files, configFile{cli.config.local.path(), cli.config.local.config})
+	}
+	if values, err := cli.config.profileValues(); err != nil {
+		problems = append(problems, err.Error())
+	} else if values != nil {
+		files = append(files, configFile{cli.config.profilePath(cli.config.activeProfile()), values})
+	}
+	for _, f := range files {
+		for _, option := range f.values.Keys() {
+			count++
+			value, _ := f.values.Get(option)
+			if err := cli.config.checkOption(option); err != nil {
+				problems = append(problems, fmt.Sprintf("%s in %s", err, f.path))
+			} else if _, err := cli.config.checkValue(option, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s = %s in %s: %s", option, redactOption(option, value), f.path, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return doctorCheck{
+			Status:  doctorFail,
+			Message: fmt.Sprintf("%d of %d options are invalid: %s", len(problems), count, strings.Join(problems, "; ")),
+			Hints:   []string{"Set a valid value with 'vespa config set', or remove the option with 'vespa config unset'"},
+		}
+	}
+	return doctorCheck{Status: doctorPass, Message: fmt.Sprintf("%d options are set, all valid", count)}
+}
+
+// doctorVersion checks that this is the latest release of Vespa CLI.
+func doctorVersion(cli *CLI, _ vespa.Target) doctorCheck {
+	latest, err := latestRelease(cli.httpClient, 10*time.Second)
+	if err != nil {
+		return doctorCheck{
+			Status:  doctorWarn,
+			Message: "could not find the latest release: " + err.Error(),
+			Hints:   []string{"Skip this check with --skip version if GitHub cannot be reached"},
+		}
+	}
+	if cli.version.Less(latest.Version) {
+		return doctorCheck{
+			Status:  doctorWarn,
+			Message: fmt.Sprintf("version %s is older than the latest release, %s", cli.version, latest.Version),
+			Hints:   []string{"Upgrade by running: " + upgradeCommand(usingHomebrew(cli))},
+		}
+	}
+	return doctorCheck{Status: doctorPass, Message: fmt.Sprintf("version %s is the latest release", cli.version)}
+}
+
+// requestDeployService sends a request to the deploy service of target, on a path not requiring authentication.
+func requestDeployService(target vespa.Target) (*vespa.Service, *http.Response, error) {
+	service, err := target.DeployService()
+	if err != nil {
+		return nil, nil, err
+	}
+	request, err := http.NewRequest("GET", strings.TrimRight(service.BaseURL, "/")+"/status.html", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := service.Do(request, 10*time.Second)
+	if err != nil {
+		return service, nil, withoutURL(err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	return service, response, nil
+}
+
+// withoutURL returns the error wrapped by err, if it is a *url.Error, as its URL may hold secrets.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// doctorTarget checks that the deploy service of target can be reached.
+func doctorTarget(cli *CLI, target vespa.Target) doctorCheck {
+	service, response, err := requestDeployService(target)
+	if service == nil {
+		return doctorCheckOfErr("could not find deploy API of target", err)
+	}
+	desc := fmt.Sprintf("%s at %s", service.Description(), redactURL(service.BaseURL))
+	if err != nil {
+		return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("could not reach %s: %s", desc, err), Hints: errorCodes[codeEndpointUnreachable].remediation}
+	}
+	if response.StatusCode/100 != 2 {
+		return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("%s responded with status %d", desc, response.StatusCode)}
+	}
+	return doctorCheck{Status: doctorPass, Message: fmt.Sprintf("%s is reachable, for target %s", desc, target.Type())}
+}
+
+// doctorClock checks that the local clock is close to the clock of the deploy service of target.
+func doctorClock(cli *CLI, target vespa.Target) doctorCheck {
+	_, response, err := requestDeployService(target)
+	if err != nil {
+		return doctorCheck{Status: doctorSkip, Message: "skipped, as the target cannot be reached"}
+	}
+	remoteTime, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return doctorCheck{Status: doctorSkip, Message: "skipped, as the target sent no valid Date header"}
+	}
+	skew := cli.now().Sub(remoteTime)
+	direction := "ahead of"
+	if skew < 0 {
+		skew, direction = -skew, "behind"
+	}
+	message := fmt.Sprintf("local clock is %s %s the clock of the target", skew.Round(time.Second), direction)
+	hints := []string{"Synchronize the local clock, e.g. with NTP, as a skewed clock makes certificates and access tokens appear invalid"}
+	switch {
+	case skew > clockSkewLimit:
+		return doctorCheck{Status: doctorFail, Message: message, Hints: hints}
+	case skew > clockSkewWarning:
+		return doctorCheck{Status: doctorWarn, Message: message, Hints: hints}
+	}
+	return doctorCheck{Status: doctorPass, Message: fmt.Sprintf("local clock is within %s of the clock of the target", clockSkewWarning)}
+}
+
+// doctorAuth checks that the credentials of the Vespa Cloud API are accepted.
+func doctorAuth(cli *CLI, target vespa.Target) doctorCheck {
+	if target.Type() != vespa.TargetCloud {
+		return doctorCheck{Status: doctorSkip, Message: "skipped, as only Vespa Cloud credentials are checked"}
+	}
+	var (
+		method      = cli.config.authMethod(cli)
+		system      = target.Deployment().System
+		credentials = "API key " + cli.describeAPIKeySource(target.Deployment().Application.Tenant)
+		hint        = "Check the API key of the tenant, or create a new one with 'vespa auth api-key'"
+		token       auth0.Credentials
+	)
+	if method == authMethodToken {
+		credentials = "access token " + cli.config.describeSource(cli.config.authConfigPath())
+		hint = "Run 'vespa auth login' to authenticate again"
+		token, _, _ = auth0.ReadCredentials(cli.config.authConfigPath(), system.Name, system.URL)
+	}
+	service, err := target.DeployService()
+	if err != nil {
+		return doctorCheckOfErr("could not find deploy API of target", err)
+	}
+	request, err := http.NewRequest("GET", service.BaseURL+"/user/v1/user", nil)
+	if err != nil {
+		return doctorCheckOfErr("invalid request", err)
+	}
+	response, err := service.Do(request, 10*time.Second)
+	if err != nil {
+		err = withoutURL(err)
+		if method == authMethodToken && !token.ExpiresAt.IsZero() && cli.now().After(token.ExpiresAt) {
+			return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("%s expired at %s: %s", credentials, token.ExpiresAt.UTC().Format(time.RFC3339), err), Hints: []string{hint}}
+		}
+		return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("could not authenticate with %s: %s", credentials, err), Hints: []string{hint}}
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if authRejected(response.StatusCode) {
+		return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("%s was rejected by %s with status %d", credentials, redactURL(service.BaseURL), response.StatusCode), Hints: []string{hint}}
+	} else if response.StatusCode/100 != 2 {
+		return doctorCheck{Status: doctorFail, Message: fmt.Sprintf("could not authenticate with %s: got status %d from %s", credentials, response.StatusCode, redactURL(service.BaseURL))}
+	}
+	if method == authMethodToken && !token.ExpiresAt.IsZero() {
+		credentials += ", valid until " + token.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return doctorCheck{Status: doctorPass, Message: "authenticated to " + system.Name + " with " + credentials}
+}
+
+// doctorCertificate checks that the data plane certificate is valid, and in the application package deployed to target.
+func doctorCertificate(cli *CLI, target vespa.Target) doctorCheck {
+	if target.Type() != vespa.TargetCloud {
+		return doctorCheck{Status: doctorSkip, Message: "skipped, as only data plane certificates for Vespa Cloud are checked"}
+	}
+	if cli.selectAuthMethod() != "mtls" {
+		return doctorCheck{Status: doctorSkip, Message: "skipped, as the data plane uses token authentication"}
+	}
+	tlsOptions, check := cli.checkLocalCertificate(target.Deployment().Application)
+	if !check.Passed {
+		return doctorCheckOfAuth(check)
+	}
+	return doctorCheckOfAuth(checkDeployedCertificate(target, tlsOptions))
+}
+
+// redactedConfig returns the value of every option of c, with secrets redacted, or nil for options which are not set.
+func redactedConfig(c *Config) map[string]any {
+	options := c.list(true)
+	values := make(map[string]any, len(options))
+	for _, option := range options {
+		if value, ok := c.get(option); ok {
+			values[option] = redactOption(option, value)
+		} else {
+			values[option] = nil
+		}
+	}
+	return values
+}
+
+func printDoctorReport(cli *CLI, checks []doctorCheck) {
+	fmt.Fprintf(cli.Stdout, "Vespa CLI %s on %s/%s\n", cli.version, runtime.GOOS, runtime.GOARCH)
+	for _, check := range checks {
+		var status string
+		switch check.Status {
+		case doctorPass:
+			status = color.GreenString(check.Status)
+		case doctorWarn:
+			status = color.YellowString(check.Status)
+		case doctorFail:
+			status = color.RedString(check.Status)
+		default:
+			status = color.New(color.FgWhite, color.Faint).Sprint(check.Status)
+		}
+		fmt.Fprintf(cli.Stdout, "%s %s: %s\n", status, check.Name, check.Message)
+		for _, hint := range check.Hints {
+			fmt.Fprintf(cli.Stdout, "     %s %s\n", color.CyanString("Hint:"), hint)
+		}
+	}
+	fmt.Fprintln(cli.Stdout, "\nConfiguration, with secrets redacted:")
+	for _, option := range cli.config.list(true) {
+		cli.config.printOption(cli.Stdout, option, true)
+	}
+}
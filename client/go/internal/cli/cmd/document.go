@@ -6,9 +6,14 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -30,26 +35,52 @@ func addDocumentFlags(cli *CLI, cmd *cobra.Command, printCurl *bool, timeoutSecs
 	cli.bindWaitFlag(cmd, 0, waitSecs)
 }
 
-func documentClient(cli *CLI, timeoutSecs int, waiter *Waiter, printCurl bool, headers []string) (*document.Client, *vespa.Service, error) {
-	docService, err := documentService(cli, waiter)
+// idGeneratorFlags holds the options for generating the IDs of documents which do not specify one.
+type idGeneratorFlags struct {
+	idFrom    string
+	id        string
+	namespace string
+	docType   string
+}
+
+func addIdGeneratorFlags(cmd *cobra.Command, flags *idGeneratorFlags) {
+	cmd.PersistentFlags().StringVar(&flags.idFrom, "id-from", "", "Create the ID of documents which do not have one from the value of this field. Requires --namespace and --document-type")
+	cmd.PersistentFlags().StringVar(&flags.id, "id", "", `Create the ID of documents which do not have one from a random UUID, if set to "uuid". Requires --namespace and --document-type`)
+	cmd.PersistentFlags().StringVar(&flags.namespace, "namespace", "", "Namespace of created document IDs")
+	cmd.PersistentFlags().StringVar(&flags.docType, "document-type", "", "Document type of created document IDs")
+}
+
+// generator returns the document ID generator configured by these flags, or nil if no IDs should be generated.
+func (f idGeneratorFlags) generator() (*document.IdGenerator, error) {
+	if f.idFrom == "" && f.id == "" {
+		if f.namespace != "" || f.docType != "" {
+			return nil, errHint(fmt.Errorf("options --namespace and --document-type require --id-from or --id"), "Use --id-from <field> or --id uuid to create document IDs")
+		}
+		return nil, nil
+	}
+	if f.idFrom != "" && f.id != "" {
+		return nil, fmt.Errorf("options --id-from and --id cannot be combined")
+	}
+	if f.id != "" && f.id != "uuid" {
+		return nil, errHint(fmt.Errorf("invalid id: %s", f.id), `Must be "uuid"`)
+	}
+	if f.namespace == "" || f.docType == "" {
+		return nil, errHint(fmt.Errorf("options --namespace and --document-type are required to create document IDs"), "Example: --namespace mynamespace --document-type music")
+	}
+	return document.NewIdGenerator(f.namespace, f.docType, f.idFrom)
+}
+
+func documentClient(cli *CLI, timeoutSecs int, waiter *Waiter, printCurl bool, headers []string, clusters *contentClusterFlags) (*document.Client, *vespa.Service, error) {
+	docService, err := checkedDocumentService(cli, waiter, clusters)
 	if err != nil {
 		return nil, nil, err
 	}
-	if printCurl {
-		docService.CurlWriter = vespa.CurlWriter{Writer: cli.Stderr}
-	}
-	header, err := httputil.ParseHeader(headers)
+	header, err := documentHeader(cli, docService, headers)
 	if err != nil {
 		return nil, nil, err
 	}
-	authMethod := cli.selectAuthMethod()
-	if authMethod == "token" {
-		err = cli.addBearerToken(&header)
-		if err != nil {
-			return nil, nil, err
-		}
-		docService.TLSOptions.CertificateFile = ""
-		docService.TLSOptions.PrivateKeyFile = ""
+	if printCurl {
+		docService.CurlWriter = cli.curlWriter()
 	}
 	client, err := document.NewClient(document.ClientOptions{
 		Compression: document.CompressionAuto,
@@ -57,15 +88,17 @@ func documentClient(cli *CLI, timeoutSecs int, waiter *Waiter, printCurl bool, h
 		BaseURL:     docService.BaseURL,
 		NowFunc:     time.Now,
 		Header:      header,
-	}, []httputil.Client{docService})
+		Route:       clusters.route,
+		Cluster:     clusters.cluster,
+	}, []httputil.Client{&progressClient{Client: docService, cli: cli, message: "Uploading document", minSize: documentProgressSize}})
 	if err != nil {
 		return nil, nil, err
 	}
 	return client, docService, nil
 }
 
-func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter *Waiter, printCurl bool, cli *CLI, headers []string, data string) error {
-	client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers)
+func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter *Waiter, printCurl bool, cli *CLI, headers []string, data string, ids *document.IdGenerator, createCheck *createChecker, clusters *contentClusterFlags) error {
+	client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers, clusters)
 	if err != nil {
 		return err
 	}
@@ -84,6 +117,9 @@ func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter
 	} else if len(args) == 1 {
 		id = args[0]
 	}
+	if id != "" && ids != nil {
+		return fmt.Errorf("cannot combine document id %s with --id-from or --id", id)
+	}
 
 	var r io.ReadCloser
 	switch {
@@ -103,7 +139,7 @@ func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter
 	doc, err := document.NewDecoder(r).Decode()
 	if err != nil {
 		if errors.Is(err, document.ErrMissingId) {
-			if id == "" {
+			if id == "" && ids == nil {
 				return fmt.Errorf("no document id given neither as argument or as a 'put', 'update' or 'remove' key in the JSON file")
 			}
 		} else {
@@ -117,6 +153,12 @@ func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter
 			return err
 		}
 		doc.Id = docId
+	} else if doc.Id.String() == "" && ids != nil {
+		docId, err := ids.Generate(doc.Body)
+		if err != nil {
+			return err
+		}
+		doc.Id = docId
 	}
 
 	if op > -1 {
@@ -126,15 +168,32 @@ func sendOperation(op document.Operation, args []string, timeoutSecs int, waiter
 		doc.Operation = op
 	}
 
+	if err := createCheck.check(doc, false); err != nil {
+		return err
+	}
+
 	if doc.Body != nil {
 		service.CurlWriter.InputFile = filename
 	}
 
 	result := client.Send(doc)
-	return printResult(cli, operationResult(false, doc, service, result), false)
+	return printResult(cli, clusters.annotate(operationResult(false, doc, service, cli.selectAuthMethod(), result)), false)
 }
 
-func readDocuments(ids []string, timeoutSecs int, waiter *Waiter, printCurl bool, cli *CLI, fieldSet string, headers []string, ignoreNotFound bool) error {
+func readDocuments(ids []string, timeoutSecs int, waiter *Waiter, printCurl bool, cli *CLI, fieldSet string, fields []string, headers []string, ignoreNotFound bool, format string, raw bool, strict bool, clusters *contentClusterFlags, transformer *documentTransformer) error {
+	if format != "human" && format != "json" && format != "jsonl" && format != "pretty" {
+		return errHint(fmt.Errorf("invalid format: %s", format), "Must be 'human', 'json', 'jsonl' or 'pretty'")
+	}
+	if raw {
+		if len(fields) > 0 {
+			return fmt.Errorf("option --raw cannot be combined with --fields")
+		}
+		format = "raw"
+	}
+	ids, err := expandDocumentIds(ids, cli.Stdin)
+	if err != nil {
+		return err
+	}
 	parsedIds := make([]document.Id, 0, len(ids))
 	for _, id := range ids {
 		parsedId, err := document.ParseId(id)
@@ -144,25 +203,119 @@ func readDocuments(ids []string, timeoutSecs int, waiter *Waiter, printCurl bool
 		parsedIds = append(parsedIds, parsedId)
 	}
 
-	client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers)
+	client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers, clusters)
 	if err != nil {
 		return err
 	}
 
+	var missingErr error
+	printed := 0
+	if format == "json" {
+		fmt.Fprint(cli.Stdout, "[")
+		defer func() {
+			if printed > 0 {
+				fmt.Fprintln(cli.Stdout)
+			}
+			fmt.Fprintln(cli.Stdout, "]")
+		}()
+	}
 	for _, docId := range parsedIds {
 		result := client.Get(docId, fieldSet)
-		if err := printResult(cli, operationResult(true, document.Document{Id: docId}, service, result), true); err != nil {
-			ignoreErr := ignoreNotFound && result.HTTPStatus == 404
-			if !ignoreErr {
+		if len(fields) > 0 && result.Err == nil && result.HTTPStatus == 200 {
+			if result.Body, err = projectFields(result.Body, fields); err != nil {
 				return err
 			}
 		}
+		if transformer != nil && result.Err == nil && result.HTTPStatus == 200 {
+			if result.Body, err = transformer.transform(docId.String(), result.Body); err != nil {
+				var transformErr *transformError
+				if !errors.As(err, &transformErr) || strict {
+					return err
+				}
+				cli.printErr(err)
+				continue
+			}
+		}
+		if format != "human" && result.Err == nil && result.HTTPStatus == 200 {
+			if err := printDocument(cli.Stdout, result.Body, format, printed); err != nil {
+				return err
+			}
+			printed++
+			continue
+		}
+		if err := printResult(cli, clusters.annotate(operationResult(true, document.Document{Id: docId}, service, cli.selectAuthMethod(), result)), true); err != nil {
+			if result.HTTPStatus != 404 || strict {
+				return err
+			}
+			if !ignoreNotFound {
+				missingErr = err
+			}
+		}
 	}
+	if err := transformer.close(); err != nil {
+		return err
+	}
+	return missingErr
+}
 
+// expandDocumentIds replaces any "-" in ids with the document IDs read from stdin, one per line.
+func expandDocumentIds(ids []string, stdin io.Reader) ([]string, error) {
+	var expanded []string
+	for _, id := range ids {
+		if id != "-" {
+			expanded = append(expanded, id)
+			continue
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				expanded = append(expanded, line)
+			}
+		}
+	}
+	if len(expanded) == 0 {
+		return nil, fmt.Errorf("no document ids given")
+	}
+	return expanded, nil
+}
+
+// printDocument writes the document in body to w, as an element of JSON array, as a line of JSONL, in pretty format or
+// exactly as received.
+func printDocument(w io.Writer, body []byte, format string, index int) error {
+	switch format {
+	case "pretty":
+		return printPrettyDocument(w, body)
+	case "raw":
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(body, []byte("\n")) {
+			_, err := fmt.Fprintln(w)
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return fmt.Errorf("invalid document in response: %w", err)
+	}
+	if format == "json" {
+		if index > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprintln(w)
+	}
+	buf.WriteTo(w)
+	if format == "jsonl" {
+		fmt.Fprintln(w)
+	}
 	return nil
 }
 
-func operationResult(read bool, doc document.Document, service *vespa.Service, result document.Result) OperationResult {
+func operationResult(read bool, doc document.Document, service *vespa.Service, authMethod string, result document.Result) OperationResult {
 	if result.Err != nil {
 		return Failure(result.Err.Error())
 	}
@@ -174,6 +327,9 @@ func operationResult(read bool, doc document.Document, service *vespa.Service, r
 			return Success(doc.Operation.String() + " " + doc.Id.String())
 		}
 	}
+	if authRejected(result.HTTPStatus) {
+		return FailureWithPayload("Document operation rejected using "+authMethodDescription(authMethod)+": Status "+strconv.Itoa(result.HTTPStatus), ioutil.ReaderToJSON(bodyReader))
+	}
 	if result.HTTPStatus/100 == 4 {
 		return FailureWithPayload("Invalid document operation: Status "+strconv.Itoa(result.HTTPStatus), ioutil.ReaderToJSON(bodyReader))
 	}
@@ -187,6 +343,7 @@ func newDocumentCmd(cli *CLI) *cobra.Command {
 		waitSecs    int
 		headers     []string
 		data        string
+		clusters    contentClusterFlags
 	)
 	cmd := &cobra.Command{
 		Use:   "document json-file",
@@ -199,6 +356,16 @@ https://docs.vespa.ai/en/reference/document-json-format.html#document-operations
 When this returns successfully, the document is guaranteed to be visible in any
 subsequent get or query operation.
 
+When the application has several content clusters holding the document type,
+the cluster to read from or write to must be given with --content-cluster. The
+content cluster is checked against the deployed services when the deployment
+can be reached, and is printed along with the result. Operations can also be
+sent to a given route with --route.
+
+The endpoints of an application in Vespa Cloud are cached between commands, as
+for 'vespa query'. Use --no-endpoint-cache to discover them from the control
+plane instead.
+
 To feed with high throughput, https://docs.vespa.ai/en/reference/vespa-cli/vespa_feed.html
 should be used instead of this.`,
 		Example:           `$ vespa document src/test/resources/A-Head-Full-of-Dreams.json`,
@@ -208,10 +375,11 @@ should be used instead of this.`,
 
 		RunE: func(cmd *cobra.Command, args []string) error {
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			return sendOperation(-1, args, timeoutSecs, waiter, printCurl, cli, headers, data)
+			return sendOperation(-1, args, timeoutSecs, waiter, printCurl, cli, headers, data, nil, nil, &clusters)
 		},
 	}
 	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
 	return cmd
 }
 
@@ -222,6 +390,8 @@ func newDocumentPutCmd(cli *CLI) *cobra.Command {
 		waitSecs    int
 		headers     []string
 		data        string
+		idFlags     idGeneratorFlags
+		clusters    contentClusterFlags
 	)
 	cmd := &cobra.Command{
 		Use:   "put [id] json-file",
@@ -232,19 +402,32 @@ If the document id is specified both as an argument and in the file the argument
 
 If json-file is a single dash ('-'), the document will be read from standard input.
 Alternatively, you can use the --data parameter to provide the document data directly.
+
+If the document has no id, it can be created with --namespace and
+--document-type, together with either --id-from, which uses the value of the
+named field as the user-specified part of the id, or --id uuid, which uses a
+random UUID. The id of the written document is printed.
 `,
 		Args: cobra.RangeArgs(0, 2),
 		Example: `$ vespa document put src/test/resources/A-Head-Full-of-Dreams.json
 $ vespa document put id:mynamespace:music::a-head-full-of-dreams src/test/resources/A-Head-Full-of-Dreams.json
-$ vespa document put id:mynamespace:music::a-head-full-of-dreams --data '{"fields":{"title":"My Title","artist":"My Artist"}}'`,
+$ vespa document put id:mynamespace:music::a-head-full-of-dreams --data '{"fields":{"title":"My Title","artist":"My Artist"}}'
+$ vespa document put --namespace mynamespace --document-type music --id-from title --data '{"fields":{"title":"My Title"}}'
+$ vespa document put --namespace mynamespace --document-type music --id uuid --data '{"fields":{"title":"My Title"}}'`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			return sendOperation(document.OperationPut, args, timeoutSecs, waiter, printCurl, cli, headers, data)
+			ids, err := idFlags.generator()
+			if err != nil {
+				return err
+			}
+			return sendOperation(document.OperationPut, args, timeoutSecs, waiter, printCurl, cli, headers, data, ids, nil, &clusters)
 		},
 	}
 	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
+	addIdGeneratorFlags(cmd, &idFlags)
 	return cmd
 }
 
@@ -255,48 +438,138 @@ func newDocumentUpdateCmd(cli *CLI) *cobra.Command {
 		waitSecs    int
 		headers     []string
 		data        string
+		updateFlags updateFlags
+		createCheck createCheckFlags
+		clusters    contentClusterFlags
 	)
 	cmd := &cobra.Command{
 		Use:   "update [id] json-file",
 		Short: "Modifies some fields of an existing document",
 		Long: `Updates the values of the fields given in a json file as specified in the file.
-If the document id is specified both as an argument and in the file the argument takes precedence.`,
+If the document id is specified both as an argument and in the file the argument takes precedence.
+
+Instead of a json file, the update can be given with the --set, --add, --remove
+and --remove-field flags, which may be repeated to update multiple fields. The
+document id must then be given as an argument. Values which are numbers or
+booleans are sent as such, unless --string is given, and other values are sent
+as strings. Elements are added to and removed from arrays with field=value, and
+keys of weighted sets with field{key}=weight and field{key}. Use --dry-run to
+print the update in the document JSON format, without sending it.
+
+An update with "create": true creates the document if it does not exist, and
+fields absent from the update then get their default values. With
+--warn-create, such an update is checked against the schemas of the deployed
+application package, and the fields it leaves absent are printed as a warning.
+With --strict-create, the update is not sent if it leaves any field absent.`,
 		Args: cobra.RangeArgs(0, 2),
 		Example: `$ vespa document update src/test/resources/A-Head-Full-of-Dreams-Update.json
-$ vespa document update id:mynamespace:music::a-head-full-of-dreams src/test/resources/A-Head-Full-of-Dreams.json`,
+$ vespa document update id:mynamespace:music::a-head-full-of-dreams src/test/resources/A-Head-Full-of-Dreams.json
+$ vespa document update id:mynamespace:music::a-head-full-of-dreams --set available=false --set year=2015
+$ vespa document update id:mynamespace:music::a-head-full-of-dreams --add 'tags{pop}=10' --remove 'tags{rock}' --remove-field label
+$ vespa document update id:mynamespace:music::a-head-full-of-dreams --add tracks='Up&Up' --dry-run`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if updateFlags.given() {
+				if len(args) != 1 || data != "" {
+					return errHint(fmt.Errorf("options --set, --add, --remove and --remove-field require a document id, and cannot be combined with a json file or --data"),
+						"Example: vespa document update id:mynamespace:music::a-head-full-of-dreams --set year=2015")
+				}
+				update, err := updateFlags.build(args[0])
+				if err != nil {
+					return err
+				}
+				if updateFlags.dryRun {
+					fmt.Fprintln(cli.Stdout, string(update))
+					return nil
+				}
+				data = string(update)
+			} else if updateFlags.dryRun {
+				return fmt.Errorf("option --dry-run requires --set, --add, --remove or --remove-field")
+			} else if updateFlags.strings {
+				return fmt.Errorf("option --string requires --set, --add or --remove")
+			}
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			return sendOperation(document.OperationUpdate, args, timeoutSecs, waiter, printCurl, cli, headers, data)
+			return sendOperation(document.OperationUpdate, args, timeoutSecs, waiter, printCurl, cli, headers, data, nil, createCheck.checker(cli), &clusters)
 		},
 	}
 	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
+	addUpdateFlags(cmd, &updateFlags)
+	addCreateCheckFlags(cmd, &createCheck)
 	return cmd
 }
 
 func newDocumentRemoveCmd(cli *CLI) *cobra.Command {
 	var (
 		printCurl   bool
+		force       bool
 		timeoutSecs int
 		waitSecs    int
 		headers     []string
 		data        string
+		selection   string
+		clusters    contentClusterFlags
 	)
 	cmd := &cobra.Command{
 		Use:   "remove id | json-file",
 		Short: "Removes a document from Vespa",
 		Long: `Removes the document specified either as a document id or given in the json file.
-If the document id is specified both as an argument and in the file the argument takes precedence.`,
-		Args: cobra.ExactArgs(1),
+If the document id is specified both as an argument and in the file the argument takes precedence.
+
+With --selection, all documents matching the given document selection are
+removed instead. When the application has multiple content clusters, the cluster
+to remove documents from must be given with --content-cluster. When run interactively,
+the command will prompt for confirmation before removing documents by
+selection, which is given by typing the name of the content cluster, or the
+selection if no cluster is given. When run non-interactively, the command will
+refuse to remove documents by selection unless the --force option is given.`,
+		Args: cobra.RangeArgs(0, 1),
 		Example: `$ vespa document remove src/test/resources/A-Head-Full-of-Dreams-Remove.json
-$ vespa document remove id:mynamespace:music::a-head-full-of-dreams`,
+$ vespa document remove id:mynamespace:music::a-head-full-of-dreams
+$ vespa document remove --selection 'music.year < 1990' --content-cluster music`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
+			if selection != "" {
+				if len(args) > 0 {
+					return fmt.Errorf("cannot remove both document %s and documents matching --selection", args[0])
+				}
+				service, err := checkedDocumentService(cli, waiter, &clusters)
+				if err != nil {
+					return err
+				}
+				header, err := documentHeader(cli, service, headers)
+				if err != nil {
+					return err
+				}
+				if printCurl {
+					service.CurlWriter = cli.curlWriter()
+				}
+				description := fmt.Sprintf("all documents matching '%s'", selection)
+				if clusters.cluster != "" {
+					description += " in cluster " + clusters.cluster
+				}
+				ok := force
+				if !ok {
+					cli.printWarning(fmt.Sprintf("This operation will irrecoverably remove %s", color.RedString(description)))
+					confirmation := clusters.cluster
+					if confirmation == "" {
+						confirmation = selection
+					}
+					ok, _ = cli.confirmExact(confirmation)
+				}
+				if !ok {
+					return errCode(codeConfirmationRequired, fmt.Errorf("refusing to remove %s without confirmation", description))
+				}
+				return removeSelection(cli, service, header, selection, &clusters, time.Duration(timeoutSecs)*time.Second)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("must provide either a document id, a file name or --selection")
+			}
 			if strings.HasPrefix(args[0], "id:") {
-				client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers)
+				client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers, &clusters)
 				if err != nil {
 					return err
 				}
@@ -306,13 +579,18 @@ $ vespa document remove id:mynamespace:music::a-head-full-of-dreams`,
 				}
 				doc := document.Document{Id: id, Operation: document.OperationRemove}
 				result := client.Send(doc)
-				return printResult(cli, operationResult(false, doc, service, result), false)
+				return printResult(cli, clusters.annotate(operationResult(false, doc, service, cli.selectAuthMethod(), result)), false)
 			} else {
-				return sendOperation(document.OperationRemove, args, timeoutSecs, waiter, printCurl, cli, headers, data)
+				return sendOperation(document.OperationRemove, args, timeoutSecs, waiter, printCurl, cli, headers, data, nil, nil, &clusters)
 			}
 		},
 	}
+	cmd.Flags().StringVar(&selection, "selection", "", "Remove all documents matching this document selection, instead of a single document")
+	cmd.Flags().StringVar(&clusters.cluster, "cluster", "", "Content cluster to remove documents from")
+	cmd.Flags().MarkHidden("cluster") // Replaced by --content-cluster
+	cmd.Flags().BoolVar(&force, "force", false, "Disable confirmation when removing documents by selection (default false)")
 	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
 	return cmd
 }
 
@@ -320,37 +598,354 @@ func newDocumentGetCmd(cli *CLI) *cobra.Command {
 	var (
 		printCurl      bool
 		ignoreNotFound bool
+		strict         bool
 		timeoutSecs    int
 		waitSecs       int
+		raw            bool
+		clusters       contentClusterFlags
 		fieldSet       string
+		offline        bool
+		fields         []string
+		format         string
 		headers        []string
 		data           string
+		transform      transformFlags
 	)
 	cmd := &cobra.Command{
-		Use:               "get id(s)",
-		Short:             "Gets one or more documents",
+		Use:   "get id(s)",
+		Short: "Gets one or more documents",
+		Long: `Gets one or more documents.
+
+If an id is a single dash ('-'), document ids will be read from standard input,
+one per line.
+
+A document which does not exist is reported on standard error, and the
+remaining documents are still read. Unless --ignore-missing is given, the
+command fails when all documents have been read. With --strict, reading stops
+at the first document which does not exist.
+
+Use --field-set to choose the fields returned by Vespa, and --fields to show
+only the named fields of the returned documents. The document type and fields
+of a field set like music:title,artist are checked against the schemas of the
+deployed application package before any document is read, unless --offline is
+given.
+
+With --format pretty, each document is printed as indented JSON, where tensor
+fields are summarized by their type, number of cells, a sample of their cells
+and their norm. Use --raw to print the exact responses from Vespa instead, e.g.
+to see all tensor cells or to save documents to a file.
+
+With --transform-cmd, each document is piped through the given shell command
+before it is printed, e.g. to decrypt fields which are stored encrypted. The
+command reads the document as JSON on standard input, and prints the document
+to show on standard output. A document for which the command fails, or does
+not finish within --transform-timeout, is reported on standard error, and the
+command fails when all documents have been read. With --transform-stream, the
+command is instead started once, and reads documents as JSON lines, printing
+one line per document. It must flush its output after each line.`,
 		Args:              cobra.MinimumNArgs(1),
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Example: `$ vespa document get id:mynamespace:music::song-1
-$ vespa document get id:mynamespace:music::song-1 id:mynamespace:music::song-2`,
+$ vespa document get id:mynamespace:music::song-1 id:mynamespace:music::song-2
+$ vespa document get --format jsonl - < ids.txt
+$ vespa document get --format pretty --fields title,embedding id:mynamespace:music::song-1
+$ vespa document get --raw id:mynamespace:music::song-1 > song-1.json
+$ vespa document get --content-cluster archive id:mynamespace:music::song-1
+$ vespa document get --transform-cmd ./decrypt.sh id:mynamespace:music::song-1`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if raw && cmd.Flags().Changed("format") {
+				return fmt.Errorf("option --raw cannot be combined with --format")
+			}
+			if err := checkFieldSet(cli, fieldSet, offline); err != nil {
+				return err
+			}
+			transformer, err := transform.transformer()
+			if err != nil {
+				return err
+			}
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			return readDocuments(args, timeoutSecs, waiter, printCurl, cli, fieldSet, headers, ignoreNotFound)
+			return readDocuments(args, timeoutSecs, waiter, printCurl, cli, fieldSet, fields, headers, ignoreNotFound, format, raw, strict, &clusters, transformer)
 		},
 	}
-	cmd.Flags().StringVar(&fieldSet, "field-set", "", "Fields to include when reading document")
+	bindFieldSetFlags(cmd, &fieldSet, &offline, "Fields to include when reading document")
 	cmd.Flags().BoolVar(&ignoreNotFound, "ignore-missing", false, "Do not treat non-existent document as an error")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Stop at the first non-existent document")
+	cmd.Flags().StringSliceVar(&fields, "fields", nil, "Comma-separated list of fields to show, of those returned by Vespa")
+	cmd.Flags().StringVar(&format, "format", "human", "Output format. Must be 'human' (human-readable), 'json' (array of documents), 'jsonl' (one document per line) or 'pretty' (indented, with tensors summarized)")
+	cmd.Flags().BoolVar(&raw, "raw", false, "Print the responses from Vespa exactly as received")
+	addTransformFlags(cmd, &transform)
 	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
 	return cmd
 }
 
-func documentService(cli *CLI, waiter *Waiter) (*vespa.Service, error) {
-	target, err := cli.target(targetOptions{})
+func newDocumentBatchCmd(cli *CLI) *cobra.Command {
+	var (
+		printCurl       bool
+		continueOnError bool
+		concurrency     int
+		timeoutSecs     int
+		waitSecs        int
+		headers         []string
+		data            string
+		clusters        contentClusterFlags
+	)
+	cmd := &cobra.Command{
+		Use:   "batch json-file",
+		Short: "Issue multiple document operations to Vespa",
+		Long: `Issue multiple document operations to Vespa, and print the result of each.
+
+The file must hold operations on the same format as for vespa feed, i.e. either
+a JSON array or JSON objects separated by newline (JSONL). Operations are sent
+in order, and the result of each operation is printed on a separate line.
+
+Sending stops at the first failed operation, unless --continue-on-error is
+given. When --concurrency is larger than 1, operations which were already sent
+when the failure occurred are completed and printed.
+
+If json-file is a single dash ('-'), operations will be read from standard input.
+
+To feed with high throughput, https://docs.vespa.ai/en/reference/vespa-cli/vespa_feed.html
+should be used instead of this.`,
+		Example: `$ vespa document batch corrections.jsonl
+$ vespa document batch --continue-on-error --concurrency 4 corrections.jsonl`,
+		Args:              cobra.RangeArgs(0, 1),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if concurrency < 1 {
+				return fmt.Errorf("invalid concurrency: %d: must be at least 1", concurrency)
+			}
+			var r io.ReadCloser
+			switch {
+			case data != "":
+				r = io.NopCloser(strings.NewReader(data))
+			case len(args) == 0:
+				return fmt.Errorf("Must provide either a file name or use the --data parameter")
+			case args[0] == "-":
+				r = io.NopCloser(cli.Stdin)
+			default:
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				r = f
+			}
+			defer r.Close()
+			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
+			client, _, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers, &clusters)
+			if err != nil {
+				return err
+			}
+			return sendBatch(document.NewDecoder(r), client, concurrency, continueOnError, cli)
+		},
+	}
+	cmd.Flags().BoolVar(&continueOnError, "continue-on-error", false, "Continue sending operations after an operation fails")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 1, "Maximum number of operations to send concurrently")
+	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
+	return cmd
+}
+
+type batchResult struct {
+	doc    document.Document
+	result document.Result
+	err    error
+	// skipped is set for operations which were never sent, because an earlier operation failed
+	skipped bool
+}
+
+// sendBatch sends all operations decoded by dec, using at most concurrency requests at a time, and prints their results
+// in order.
+func sendBatch(dec *document.Decoder, client *document.Client, concurrency int, continueOnError bool, cli *CLI) error {
+	pending := make(chan chan batchResult, concurrency-1)
+	stop := make(chan struct{})
+	go func() {
+		defer close(pending)
+		for {
+			doc, err := dec.Decode()
+			if err == io.EOF {
+				return
+			}
+			c := make(chan batchResult, 1)
+			select {
+			case pending <- c:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				c <- batchResult{err: err}
+				if skipErr := dec.Skip(); skipErr != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case <-stop:
+				c <- batchResult{skipped: true}
+				return
+			default:
+			}
+			go func() { c <- batchResult{doc: doc, result: client.Send(doc)} }()
+		}
+	}()
+	var total, failed int
+	stopped := false
+	for c := range pending {
+		r := <-c
+		if r.skipped {
+			continue
+		}
+		total++
+		if !printBatchResult(cli, r) {
+			failed++
+			if !continueOnError && !stopped {
+				stopped = true
+				close(stop)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d document operations failed", failed, total)
+	}
+	return nil
+}
+
+func printBatchResult(cli *CLI, r batchResult) bool {
+	if r.err != nil {
+		fmt.Fprintln(cli.Stderr, color.RedString("Error:"), "invalid document operation:", r.err)
+		return false
+	}
+	operation := r.doc.Operation.String() + " " + r.doc.Id.String()
+	if r.result.Err != nil {
+		fmt.Fprintln(cli.Stderr, color.RedString("Error:"), operation+":", r.result.Err)
+		return false
+	}
+	if r.result.HTTPStatus != 200 {
+		message := strings.TrimSpace(string(r.result.Body))
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(r.result.Body, &body); err == nil && body.Message != "" {
+			message = body.Message
+		}
+		fmt.Fprintln(cli.Stderr, color.RedString("Error:"), operation+": Status "+strconv.Itoa(r.result.HTTPStatus)+":", message)
+		return false
+	}
+	cli.printSuccess(operation)
+	return true
+}
+
+// documentHeader parses headers, together with those of the data-plane-headers option, and adds any authentication
+// required by docService to them.
+func documentHeader(cli *CLI, docService *vespa.Service, headers []string) (http.Header, error) {
+	authMethod := cli.selectAuthMethod()
+	header, err := cli.dataPlaneHeader(headers, authMethod)
 	if err != nil {
 		return nil, err
 	}
+	if authMethod == "token" {
+		docService.TLSOptions.CertificateFile = ""
+		docService.TLSOptions.PrivateKeyFile = ""
+	}
+	return header, nil
+}
+
+// removeSelection removes all documents matching selection, following continuation tokens until every bucket has been
+// processed. The number of buckets processed is read from the continuation tokens, when their format is known.
+func removeSelection(cli *CLI, service *vespa.Service, header http.Header, selection string, clusters *contentClusterFlags, timeout time.Duration) error {
+	query := url.Values{}
+	query.Set("selection", selection)
+	if clusters.cluster != "" {
+		query.Set("cluster", clusters.cluster)
+	}
+	if clusters.route != "" {
+		query.Set("route", clusters.route)
+	}
+	query.Set("timeout", strconv.FormatInt(timeout.Milliseconds(), 10)+"ms")
+	total, requests := 0, 0
+	buckets := int64(-1)
+	for {
+		u, err := url.Parse(service.BaseURL + "/document/v1/?" + query.Encode())
+		if err != nil {
+			return err
+		}
+		request := &http.Request{URL: u, Method: "DELETE", Header: header}
+		response, err := service.Do(request, timeout+5*time.Second) // Allow the server to respond before we time out
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		requests++
+		result := operationResult(false, document.Document{}, service, cli.selectAuthMethod(), document.Result{HTTPStatus: response.StatusCode, Body: body})
+		if !result.Success {
+			return printResult(cli, clusters.annotate(result), false)
+		}
+		var output VespaVisitOutput
+		if err := json.Unmarshal(body, &output); err != nil {
+			return fmt.Errorf("invalid response from %s: %w", service.BaseURL, err)
+		}
+		total += output.DocumentCount
+		if output.Continuation == "" {
+			break
+		}
+		if _, bucketTotal, ok := bucketProgress(output.Continuation); ok {
+			buckets = bucketTotal // Every bucket is processed once no continuation is returned
+		} else {
+			buckets = -1
+		}
+		query.Set("continuation", output.Continuation)
+	}
+	msg := fmt.Sprintf("Removed %d documents matching '%s' in %d requests", total, selection, requests)
+	if buckets >= 0 {
+		msg += fmt.Sprintf(", processing %d buckets", buckets)
+	}
+	cli.printSuccess(msg)
+	return nil
+}
+
+// bucketProgress returns the number of finished buckets, and the number of buckets in total, of the visit whose
+// progress is serialized in continuation, and whether these are known. The continuation is a serialized ProgressToken,
+// which starts with the distribution bits, the bucket cursor, and the number of finished and total buckets.
+func bucketProgress(continuation string) (finished, total int64, ok bool) {
+	data, err := base64.URLEncoding.DecodeString(continuation)
+	if err != nil {
+		if data, err = base64.RawURLEncoding.DecodeString(continuation); err != nil {
+			return 0, 0, false
+		}
+	}
+	if len(data) < 28 {
+		return 0, 0, false
+	}
+	bits := int32(binary.BigEndian.Uint32(data))
+	finished = int64(binary.BigEndian.Uint64(data[12:]))
+	total = int64(binary.BigEndian.Uint64(data[20:]))
+	if bits <= 0 || bits > 58 || finished < 0 || total <= 0 || finished > total {
+		return 0, 0, false
+	}
+	return finished, total, true
+}
+
+func documentService(cli *CLI, waiter *Waiter) (*vespa.Service, error) {
+	return checkedDocumentService(cli, waiter, &contentClusterFlags{})
+}
+
+// checkedDocumentService returns the service for document operations, after checking the content cluster given by
+// clusters against the deployment.
+func checkedDocumentService(cli *CLI, waiter *Waiter, clusters *contentClusterFlags) (*vespa.Service, error) {
 	authMethod := cli.selectAuthMethod()
+	target, err := cli.target(targetOptions{noCertificate: authMethod == "token"})
+	if err != nil {
+		return nil, err
+	}
+	if err := clusters.check(target); err != nil {
+		return nil, err
+	}
 	return waiter.ServiceWithAuthMethod(target, cli.config.cluster(), authMethod)
 }
 
@@ -363,7 +958,7 @@ func printResult(cli *CLI, result OperationResult, payloadOnlyOnSuccess bool) er
 	if !result.Success {
 		fmt.Fprintln(out, color.RedString("Error:"), result.Message)
 	} else if !payloadOnlyOnSuccess || result.Payload == "" {
-		fmt.Fprintln(out, color.GreenString("Success:"), result.Message)
+		cli.printSuccess(result.Message)
 	}
 
 	if result.Detail != "" {
@@ -377,7 +972,10 @@ func printResult(cli *CLI, result OperationResult, payloadOnlyOnSuccess bool) er
 		fmt.Fprintln(out, result.Payload)
 	}
 	if !result.Success {
-		err := errHint(fmt.Errorf("document operation failed"))
+		for _, hint := range result.Hints {
+			fmt.Fprintln(out, color.CyanString("Hint:"), hint)
+		}
+		err := errHint(fmt.Errorf("document operation failed"), result.Hints...)
 		err.quiet = true
 		return err
 	}
@@ -0,0 +1,85 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Selection of the content cluster and route of document operations
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// quotedNameRegexp matches the quoted cluster names listed in errors from /document/v1/.
+var quotedNameRegexp = regexp.MustCompile(`'([^']+)'`)
+
+// contentClusterFlags holds the flags choosing the content cluster and route of document operations.
+type contentClusterFlags struct {
+	cluster string
+	route   string
+	// target is the target the operations are sent to, once checked
+	target vespa.Target
+}
+
+func addContentClusterFlags(cmd *cobra.Command, flags *contentClusterFlags) {
+	cmd.PersistentFlags().StringVar(&flags.cluster, "content-cluster", "", "Content cluster to send document operations to. Required if the document type is in multiple content clusters")
+	cmd.PersistentFlags().StringVar(&flags.route, "route", "", `Target Vespa route for document operations (default "default")`)
+}
+
+// check returns an error if the content cluster given by these flags is not in the deployment of target. Nothing is
+// checked if the content clusters of the deployment can not be listed, e.g. because the target is not reachable.
+func (f *contentClusterFlags) check(target vespa.Target) error {
+	f.target = target
+	if f.cluster == "" {
+		return nil
+	}
+	clusters := f.deployedClusters()
+	if len(clusters) > 0 && !slices.Contains(clusters, f.cluster) {
+		return errHint(fmt.Errorf("content cluster %s not found", f.cluster), "Must be one of "+strings.Join(clusters, ", "))
+	}
+	return nil
+}
+
+// deployedClusters returns the content clusters of the target, or nil if these can not be listed.
+func (f *contentClusterFlags) deployedClusters() []string {
+	lister, ok := f.target.(vespa.ContentClusterTarget)
+	if !ok {
+		return nil
+	}
+	clusters, err := lister.ContentClusters()
+	if err != nil {
+		return nil
+	}
+	return clusters
+}
+
+// annotate adds the content cluster to the message of a successful result, and a hint listing the available content
+// clusters to a failure caused by a missing or unknown content cluster.
+func (f *contentClusterFlags) annotate(result OperationResult) OperationResult {
+	if result.Success {
+		if f.cluster != "" {
+			result.Message += " in content cluster " + f.cluster
+		}
+		return result
+	}
+	if !strings.Contains(strings.ToLower(result.Payload), "cluster") {
+		return result
+	}
+	clusters := f.deployedClusters()
+	if len(clusters) == 0 {
+		// The error from /document/v1/ lists the clusters, e.g. "no content cluster 'foo', only 'music', 'books'"
+		if _, only, ok := strings.Cut(result.Payload, " only "); ok {
+			for _, m := range quotedNameRegexp.FindAllStringSubmatch(only, -1) {
+				clusters = append(clusters, m[1])
+			}
+		}
+	}
+	if len(clusters) > 0 {
+		result.Hints = append(result.Hints, "Choose one of the content clusters with --content-cluster: "+strings.Join(clusters, ", "))
+	} else {
+		result.Hints = append(result.Hints, "Choose the content cluster with --content-cluster")
+	}
+	return result
+}
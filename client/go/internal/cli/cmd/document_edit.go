@@ -0,0 +1,388 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Read-modify-write of a single document, guarded by a test-and-set condition
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// maxConditionStringLength is the maximum length of a string field whose original value is asserted by the condition
+// of an edit.
+const maxConditionStringLength = 256
+
+// documentEditor edits a document by opening it in an editor, or by piping it through a command.
+type documentEditor struct {
+	cli       *CLI
+	transform string
+	timeout   time.Duration
+}
+
+// errEditAborted is returned when an edit is aborted, such that the document should not be written.
+var errEditAborted = errors.New("edit aborted")
+
+// edit returns content as edited. It returns errEditAborted if the editor fails, or leaves the content empty.
+func (e *documentEditor) edit(content []byte) ([]byte, error) {
+	if e.transform != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+		defer cancel()
+		cmd := shellCommand(ctx, e.transform)
+		cmd.Stdin = bytes.NewReader(content)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("transform command did not finish within %s", e.timeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("transform command failed: %w", processError(err, &stderr))
+		}
+		return out, nil
+	}
+	f, err := os.CreateTemp("", "vespa-edit-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	cmd := shellCommand(context.Background(), e.editorCommand()+" "+shellQuote(f.Name()))
+	cmd.Stdin = e.cli.Stdin
+	cmd.Stdout = e.cli.Stdout
+	cmd.Stderr = e.cli.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, errEditAborted
+		}
+		return nil, fmt.Errorf("could not run editor: %w", err)
+	}
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(edited)) == 0 {
+		return nil, errEditAborted
+	}
+	return edited, nil
+}
+
+// editorCommand returns the editor given by the environment, or the default editor of the operating system.
+func (e *documentEditor) editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := e.cli.Environment[name]; editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// shellQuote quotes path as a single argument to the shell run by shellCommand.
+func shellQuote(path string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + path + `"`
+	}
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// documentEdit is a document as read, and the fields it is edited to have.
+type documentEdit struct {
+	id       document.Id
+	original map[string]json.RawMessage
+	fields   map[string]json.RawMessage
+}
+
+// editContent returns the fields of the document in response, as the indented JSON which is edited.
+func editContent(response []byte) (map[string]json.RawMessage, []byte, error) {
+	var doc struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(response, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid document: %w", err)
+	}
+	if doc.Fields == nil {
+		doc.Fields = make(map[string]json.RawMessage)
+	}
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc.Fields, append(content, '\n'), nil
+}
+
+// parseEdit parses the edited content, and returns whether it changes the fields of the document.
+func parseEdit(edit *documentEdit, content []byte) (bool, error) {
+	var doc struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return false, fmt.Errorf("invalid document after editing: %w", err)
+	}
+	if doc.Fields == nil {
+		return false, fmt.Errorf("invalid document after editing: it must be a JSON object with the fields of the document in \"fields\"")
+	}
+	edit.fields = doc.Fields
+	return !sameJSON(edit.original, edit.fields), nil
+}
+
+// sameJSON returns whether a and b hold the same JSON values, ignoring formatting and the order of object members.
+func sameJSON(a, b map[string]json.RawMessage) bool {
+	decode := func(fields map[string]json.RawMessage) (map[string]any, bool) {
+		values := make(map[string]any, len(fields))
+		for name, raw := range fields {
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.UseNumber()
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				return nil, false
+			}
+			values[name] = v
+		}
+		return values, true
+	}
+	av, ok := decode(a)
+	if !ok {
+		return false
+	}
+	bv, ok := decode(b)
+	if !ok {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// condition returns the test-and-set condition asserting that the document is unchanged since it was read. If
+// versionField is non-empty, only that field is asserted, and it is incremented by the edit, unless the edit sets it.
+// Otherwise, the original values of the string and integer fields of the document are asserted.
+func (e *documentEdit) condition(versionField string) (string, error) {
+	docType := e.id.Type
+	if versionField != "" {
+		raw, ok := e.original[versionField]
+		if !ok {
+			return "", errHint(fmt.Errorf("document %s has no field %s", e.id, versionField), "Set the version field of the document before editing it, e.g. to 1")
+		}
+		version, ok := integerValue(raw)
+		if !ok {
+			return "", fmt.Errorf("invalid version field %s of document %s: %s is not an integer", versionField, e.id, raw)
+		}
+		if edited, ok := e.fields[versionField]; !ok || bytes.Equal(bytes.TrimSpace(edited), bytes.TrimSpace(raw)) {
+			e.fields[versionField] = json.RawMessage(strconv.FormatInt(version+1, 10))
+		}
+		return fmt.Sprintf("%s.%s==%d", docType, versionField, version), nil
+	}
+	names := make([]string, 0, len(e.original))
+	for name := range e.original {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var clauses []string
+	for _, name := range names {
+		raw := e.original[name]
+		if n, ok := integerValue(raw); ok {
+			clauses = append(clauses, fmt.Sprintf("%s.%s==%d", docType, name, n))
+			continue
+		}
+		var s string
+		if json.Unmarshal(raw, &s) == nil && len(s) <= maxConditionStringLength {
+			clauses = append(clauses, fmt.Sprintf("%s.%s==%s", docType, name, selectionString(s)))
+		}
+	}
+	if len(clauses) == 0 {
+		return "", errHint(fmt.Errorf("document %s has no string or integer field to assert the original value of", e.id),
+			"Give an integer field which is incremented by each edit with --version-field")
+	}
+	return strings.Join(clauses, " and "), nil
+}
+
+// body returns the put operation writing the edited fields.
+func (e *documentEdit) body() ([]byte, error) {
+	return json.Marshal(struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}{e.fields})
+}
+
+// integerValue returns the integer in raw, if it holds one.
+func integerValue(raw json.RawMessage) (int64, bool) {
+	n, err := strconv.ParseInt(string(bytes.TrimSpace(raw)), 10, 64)
+	return n, err == nil
+}
+
+// selectionString returns s as a string literal of the document selection language.
+func selectionString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c < 0x20:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func newDocumentEditCmd(cli *CLI) *cobra.Command {
+	var (
+		printCurl    bool
+		timeoutSecs  int
+		waitSecs     int
+		headers      []string
+		data         string
+		clusters     contentClusterFlags
+		transform    string
+		transformTTL time.Duration
+		versionField string
+		maxRetries   int
+	)
+	cmd := &cobra.Command{
+		Use:   "edit id",
+		Short: "Edits a document, writing it back only if it was not changed meanwhile",
+		Long: `Edits a document, writing it back only if it was not changed meanwhile.
+
+The document is read, and its fields are opened in the editor given by the
+VISUAL or EDITOR environment variable, or vi (notepad on Windows). With
+--transform-cmd, the document is instead piped through the given shell command,
+which reads the fields as JSON on standard input, and prints them as edited on
+standard output, e.g. "jq '.fields.count += 1'".
+
+The edited document is written back with a test-and-set condition asserting
+that it is unchanged since it was read. By default, the condition asserts the
+original value of each integer field, and of each string field of at most 256
+bytes, of the document. Other fields, such as arrays and tensors, are not
+compared. With --version-field, the condition instead asserts the value of the
+given integer field only, and the edit increments the field unless it sets it
+explicitly. All writers of the document should then increment the field.
+
+If the document was changed meanwhile, it is read and edited again, up to
+--max-retries times. Closing the editor without changes, leaving the document
+empty, or exiting the editor with a non-zero status aborts the edit, and the
+document is not written. When written, the number of attempts needed and the
+document as written are printed.`,
+		Example: `$ vespa document edit id:mynamespace:music::song-1
+$ vespa document edit --version-field version id:mynamespace:music::song-1
+$ vespa document edit --transform-cmd "jq '.fields.plays += 1'" --max-retries 10 id:mynamespace:music::song-1`,
+		Args:              cobra.ExactArgs(1),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := document.ParseId(args[0])
+			if err != nil {
+				return err
+			}
+			if maxRetries < 0 {
+				return fmt.Errorf("invalid --max-retries: %d: must be zero or positive", maxRetries)
+			}
+			if transformTTL <= 0 {
+				return fmt.Errorf("invalid --transform-timeout: %s: must be positive", transformTTL)
+			}
+			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
+			client, service, err := documentClient(cli, timeoutSecs, waiter, printCurl, headers, &clusters)
+			if err != nil {
+				return err
+			}
+			editor := &documentEditor{cli: cli, transform: transform, timeout: transformTTL}
+			authMethod := cli.selectAuthMethod()
+			for attempt := 1; ; attempt++ {
+				result := client.Get(id, "")
+				if result.Err != nil || result.HTTPStatus != 200 {
+					return printResult(cli, clusters.annotate(operationResult(true, document.Document{Id: id}, service, authMethod, result)), true)
+				}
+				original, content, err := editContent(result.Body)
+				if err != nil {
+					return err
+				}
+				edited, err := editor.edit(content)
+				if errors.Is(err, errEditAborted) {
+					cli.printInfo("Edit aborted, document ", id.String(), " was not written")
+					return nil
+				} else if err != nil {
+					return err
+				}
+				edit := &documentEdit{id: id, original: original}
+				changed, err := parseEdit(edit, edited)
+				if err != nil {
+					return err
+				}
+				if !changed {
+					cli.printInfo("Document ", id.String(), " was not changed, and was not written")
+					return nil
+				}
+				condition, err := edit.condition(versionField)
+				if err != nil {
+					return err
+				}
+				body, err := edit.body()
+				if err != nil {
+					return err
+				}
+				doc := document.Document{Id: id, Operation: document.OperationPut, Condition: condition, Body: body}
+				result = client.Send(doc)
+				if result.Err == nil && result.HTTPStatus == 412 {
+					if attempt <= maxRetries {
+						cli.printInfo("Document ", id.String(), " was changed since it was read, retrying (attempt ", strconv.Itoa(attempt+1), " of ", strconv.Itoa(maxRetries+1), ")")
+						continue
+					}
+					return errHint(fmt.Errorf("document %s was changed since it was read, in all %d attempts", id, attempt), "Use --max-retries to try more times")
+				}
+				if result.Err != nil || !result.Success() {
+					return printResult(cli, clusters.annotate(operationResult(false, doc, service, authMethod, result)), false)
+				}
+				attempts := "1 attempt"
+				if attempt > 1 {
+					attempts = fmt.Sprintf("%d attempts", attempt)
+				}
+				cli.printSuccess("Wrote ", id.String(), " in ", attempts)
+				var out bytes.Buffer
+				if err := json.Indent(&out, body, "", "  "); err != nil {
+					return err
+				}
+				fmt.Fprintln(cli.Stdout, out.String())
+				return nil
+			}
+		},
+	}
+	cmd.Flags().StringVar(&transform, "transform-cmd", "", "Edit the document by piping it, as JSON, through this shell command instead of opening an editor")
+	cmd.Flags().DurationVar(&transformTTL, "transform-timeout", 10*time.Second, "Maximum time --transform-cmd may spend on the document")
+	cmd.Flags().StringVar(&versionField, "version-field", "", "Assert the value of this integer field only, and increment it with each edit")
+	cmd.Flags().IntVar(&maxRetries, "max-retries", 3, "Maximum number of times to read and edit the document again, if it was changed since it was read")
+	addDocumentFlags(cli, cmd, &printCurl, &timeoutSecs, &waitSecs, &headers, &data)
+	addContentClusterFlags(cmd, &clusters)
+	return cmd
+}
@@ -0,0 +1,112 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestDocumentEdit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("transform commands and editors are run by sh")
+	}
+	doc := `{"id": "id:ns:music::a", "fields": {"title": "Say \"hi\"", "plays": 1, "tags": ["x"]}}`
+	client := &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(200, doc)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "--transform-cmd", `sed 's/"plays": 1/"plays": 2/'`, "id:ns:music::a"))
+	require.Equal(t, 2, len(client.Requests))
+	assert.Equal(t, "POST", client.LastRequest.Method)
+	assert.Equal(t, `music.plays==1 and music.title=="Say \"hi\""`, client.LastRequest.URL.Query().Get("condition"))
+	assert.Equal(t, `{"fields":{"plays":2,"tags":["x"],"title":"Say \"hi\""}}`, string(client.LastBody))
+	assert.Equal(t, `Success: Wrote id:ns:music::a in 1 attempt
+{
+  "fields": {
+    "plays": 2,
+    "tags": [
+      "x"
+    ],
+    "title": "Say \"hi\""
+  }
+}
+`, stdout.String())
+	assert.Equal(t, "", stderr.String())
+
+	// The document is read and edited again when changed meanwhile
+	client = &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "Hi", "plays": 1, "version": 6}}`)
+	client.NextResponseString(412, `{"message": "condition not met"}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "Bye", "plays": 1, "version": 7}}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "--transform-cmd", `sed 's/"plays": 1/"plays": 2/'`,
+		"--version-field", "version", "id:ns:music::a"))
+	assert.Equal(t, 4, len(client.Requests))
+	assert.Equal(t, "music.version==7", client.LastRequest.URL.Query().Get("condition"))
+	assert.Equal(t, `{"fields":{"plays":2,"title":"Bye","version":8}}`, string(client.LastBody))
+	assert.Contains(t, stdout.String(), "Success: Wrote id:ns:music::a in 2 attempts\n")
+	assert.Equal(t, "Document id:ns:music::a was changed since it was read, retrying (attempt 2 of 4)\n", stderr.String())
+
+	// Giving up after retrying
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, doc)
+	client.NextResponseString(412, `{"message": "condition not met"}`)
+	client.NextResponseString(200, doc)
+	client.NextResponseString(412, `{"message": "condition not met"}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.NotNil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "--transform-cmd", `sed 's/"plays": 1/"plays": 2/'`,
+		"--max-retries", "1", "id:ns:music::a"))
+	assert.Contains(t, stderr.String(), "Error: document id:ns:music::a was changed since it was read, in all 2 attempts\nHint: Use --max-retries to try more times\n")
+
+	// An edit without changes does not write the document
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, doc)
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "--transform-cmd", "cat", "id:ns:music::a"))
+	assert.Equal(t, 1, len(client.Requests))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "Document id:ns:music::a was not changed, and was not written\n", stderr.String())
+
+	// A version field must exist
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, doc)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.NotNil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "--transform-cmd", `sed 's/"plays": 1/"plays": 2/'`,
+		"--version-field", "version", "id:ns:music::a"))
+	assert.Equal(t, "Error: document id:ns:music::a has no field version\nHint: Set the version field of the document before editing it, e.g. to 1\n", stderr.String())
+}
+
+func TestDocumentEditEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("editors are run by sh")
+	}
+	doc := `{"id": "id:ns:music::a", "fields": {"title": "A"}}`
+	client := &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(200, doc)
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, stdout, _ := newTestCLI(t, `EDITOR=sh -c 'sed s/A/B/ "$0" > "$0.tmp" && mv "$0.tmp" "$0"'`)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "id:ns:music::a"))
+	assert.Equal(t, `music.title=="A"`, client.LastRequest.URL.Query().Get("condition"))
+	assert.Equal(t, `{"fields":{"title":"B"}}`, string(client.LastBody))
+	assert.Contains(t, stdout.String(), "Success: Wrote id:ns:music::a in 1 attempt\n")
+
+	// Exiting the editor with a failure aborts the edit
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, doc)
+	cli, _, stderr := newTestCLI(t, "VISUAL=false")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "edit", "-t", "http://127.0.0.1:8080", "id:ns:music::a"))
+	assert.Equal(t, 1, len(client.Requests))
+	assert.Equal(t, "Edit aborted, document id:ns:music::a was not written\n", stderr.String())
+}
@@ -0,0 +1,330 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tensorSampleCells is the number of cells shown when summarizing a tensor.
+const tensorSampleCells = 3
+
+// jsonMember is a member of a JSON object.
+type jsonMember struct {
+	name  string
+	value json.RawMessage
+}
+
+// decodeObject returns the members of the JSON object in data, in order.
+func decodeObject(data []byte) ([]jsonMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("expected object, got %v", t)
+	}
+	var members []jsonMember
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		members = append(members, jsonMember{name: t.(string), value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func encodeObject(members []jsonMember) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(marshalString(m.name))
+		buf.WriteString(":")
+		buf.Write(m.value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes()
+}
+
+// marshalString returns s as a JSON string, without escaping HTML characters such as those in tensor<float>.
+func marshalString(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s) // Encoding a string cannot fail
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// mapFields returns the document in body with each of its fields replaced by the result of f. A field is removed if f
+// returns a nil value.
+func mapFields(body []byte, f func(name string, value json.RawMessage) json.RawMessage) ([]byte, error) {
+	members, err := decodeObject(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid document in response: %w", err)
+	}
+	for i, m := range members {
+		if m.name != "fields" {
+			continue
+		}
+		fields, err := decodeObject(m.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid document in response: %w", err)
+		}
+		mapped := fields[:0]
+		for _, field := range fields {
+			if value := f(field.name, field.value); value != nil {
+				mapped = append(mapped, jsonMember{name: field.name, value: value})
+			}
+		}
+		members[i].value = encodeObject(mapped)
+	}
+	return encodeObject(members), nil
+}
+
+// projectFields returns the document in body with only the given fields. Other members of the document, such as its
+// ID, are kept.
+func projectFields(body []byte, fields []string) ([]byte, error) {
+	keep := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		keep[f] = true
+	}
+	return mapFields(body, func(name string, value json.RawMessage) json.RawMessage {
+		if keep[name] {
+			return value
+		}
+		return nil
+	})
+}
+
+// printPrettyDocument writes the document in body to w as indented JSON, where the value of each tensor field is
+// replaced by a summary of the tensor.
+func printPrettyDocument(w io.Writer, body []byte) error {
+	summarized, err := mapFields(body, func(name string, value json.RawMessage) json.RawMessage {
+		if summary, ok := summarizeTensor(value); ok {
+			return marshalString(summary)
+		}
+		return value
+	})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, summarized, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// tensorCell is a cell of a tensor, with a label describing its address.
+type tensorCell struct {
+	label string
+	value json.Number
+}
+
+// summarizeTensor returns a summary of the tensor in value, holding its type, number of cells, a sample of its cells and
+// its norm. The tensor may be on any of the JSON formats of Vespa, except those without the tensor type. If value is
+// not a tensor on a known format, false is returned.
+func summarizeTensor(value json.RawMessage) (string, bool) {
+	var tensor struct {
+		Type   string          `json:"type"`
+		Values json.RawMessage `json:"values"`
+		Cells  json.RawMessage `json:"cells"`
+		Blocks json.RawMessage `json:"blocks"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&tensor); err != nil || !strings.HasPrefix(tensor.Type, "tensor") {
+		return "", false
+	}
+	var (
+		cells  []tensorCell
+		blocks int
+		dense  bool
+		err    error
+	)
+	switch {
+	case tensor.Values != nil: // Dense, short form
+		dense = true
+		var values any
+		if err = decodeNumbers(tensor.Values, &values); err == nil {
+			cells, err = flattenValues(values, "", cells)
+		}
+	case tensor.Blocks != nil: // Mixed, short form
+		cells, blocks, err = decodeBlocks(tensor.Blocks)
+	case tensor.Cells != nil: // Mapped, short form, or any tensor on long form
+		cells, err = decodeCells(tensor.Cells)
+	default:
+		return "", false
+	}
+	if err != nil {
+		return "", false
+	}
+	var sumSquares float64
+	for _, cell := range cells {
+		v, err := cell.value.Float64()
+		if err != nil {
+			return "", false
+		}
+		sumSquares += v * v
+	}
+	var sample strings.Builder
+	for i, cell := range cells {
+		if i == tensorSampleCells {
+			sample.WriteString(", ...")
+			break
+		}
+		if i > 0 {
+			sample.WriteString(", ")
+		}
+		if !dense {
+			sample.WriteString(cell.label)
+			sample.WriteString(": ")
+		}
+		sample.WriteString(cell.value.String())
+	}
+	var sb strings.Builder
+	sb.WriteString(tensor.Type)
+	sb.WriteString(": ")
+	sb.WriteString(strconv.Itoa(len(cells)))
+	sb.WriteString(" cells")
+	if blocks > 0 {
+		sb.WriteString(" in ")
+		sb.WriteString(strconv.Itoa(blocks))
+		sb.WriteString(" blocks")
+	}
+	if dense {
+		sb.WriteString(" [" + sample.String() + "]")
+	} else {
+		sb.WriteString(" {" + sample.String() + "}")
+	}
+	sb.WriteString(", norm ")
+	sb.WriteString(strconv.FormatFloat(math.Sqrt(sumSquares), 'g', 6, 64))
+	return sb.String(), true
+}
+
+func decodeNumbers(data json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// flattenValues appends the numbers in the nested arrays of values to cells, in order.
+func flattenValues(values any, label string, cells []tensorCell) ([]tensorCell, error) {
+	switch v := values.(type) {
+	case json.Number:
+		return append(cells, tensorCell{label: label, value: v}), nil
+	case []any:
+		var err error
+		for _, e := range v {
+			if cells, err = flattenValues(e, label, cells); err != nil {
+				return nil, err
+			}
+		}
+		return cells, nil
+	}
+	return nil, fmt.Errorf("unexpected tensor value %v", values)
+}
+
+// decodeCells decodes cells on the short form, an object mapping labels to values, or on the long form, an array of
+// objects holding the address and value of each cell.
+func decodeCells(data json.RawMessage) ([]tensorCell, error) {
+	var mapped map[string]json.Number
+	if err := decodeNumbers(data, &mapped); err == nil {
+		return sortedCells(mapped), nil
+	}
+	var long []struct {
+		Address map[string]string `json:"address"`
+		Value   json.Number       `json:"value"`
+	}
+	if err := decodeNumbers(data, &long); err != nil {
+		return nil, err
+	}
+	cells := make([]tensorCell, 0, len(long))
+	for _, cell := range long {
+		cells = append(cells, tensorCell{label: formatAddress(cell.Address), value: cell.Value})
+	}
+	return cells, nil
+}
+
+// decodeBlocks decodes dense blocks on the short form of a mixed tensor, which is either an object mapping labels to
+// blocks, or an array of objects holding the address and values of each block.
+func decodeBlocks(data json.RawMessage) ([]tensorCell, int, error) {
+	var mapped map[string]any
+	if err := decodeNumbers(data, &mapped); err == nil {
+		labels := make([]string, 0, len(mapped))
+		for label := range mapped {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		var cells []tensorCell
+		for _, label := range labels {
+			if cells, err = flattenValues(mapped[label], label, cells); err != nil {
+				return nil, 0, err
+			}
+		}
+		return cells, len(labels), nil
+	}
+	var list []struct {
+		Address map[string]string `json:"address"`
+		Values  any               `json:"values"`
+	}
+	if err := decodeNumbers(data, &list); err != nil {
+		return nil, 0, err
+	}
+	var cells []tensorCell
+	for _, block := range list {
+		var err error
+		if cells, err = flattenValues(block.Values, formatAddress(block.Address), cells); err != nil {
+			return nil, 0, err
+		}
+	}
+	return cells, len(list), nil
+}
+
+func sortedCells(mapped map[string]json.Number) []tensorCell {
+	cells := make([]tensorCell, 0, len(mapped))
+	for label, value := range mapped {
+		cells = append(cells, tensorCell{label: label, value: value})
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].label < cells[j].label })
+	return cells
+}
+
+// formatAddress formats a cell address as {dim1:label1,dim2:label2}, ordered by dimension name.
+func formatAddress(address map[string]string) string {
+	dims := make([]string, 0, len(address))
+	for dim := range address {
+		dims = append(dims, dim)
+	}
+	sort.Strings(dims)
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, dim := range dims {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(dim)
+		sb.WriteString(":")
+		sb.WriteString(address[dim])
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
@@ -0,0 +1,64 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeTensor(t *testing.T) {
+	tests := []struct {
+		tensor  string
+		summary string
+	}{
+		{`{"type": "tensor<float>(x[5])", "values": [3, 4, 0, 0, 0]}`,
+			"tensor<float>(x[5]): 5 cells [3, 4, 0, ...], norm 5"},
+		{`{"type": "tensor(x[2],y[2])", "values": [[1.5, 2], [3, 4]]}`,
+			"tensor(x[2],y[2]): 4 cells [1.5, 2, 3, ...], norm 5.59017"},
+		{`{"type": "tensor(k{})", "cells": {"b": 4, "a": 3}}`,
+			"tensor(k{}): 2 cells {a: 3, b: 4}, norm 5"},
+		{`{"type": "tensor(k{},x[2])", "blocks": {"b": [0, 4], "a": [3, 0]}}`,
+			"tensor(k{},x[2]): 4 cells in 2 blocks {a: 3, a: 0, b: 0, ...}, norm 5"},
+		{`{"type": "tensor(k{},l{},x[1])", "blocks": [{"address": {"l": "1", "k": "a"}, "values": [3]}, {"address": {"k": "b", "l": "2"}, "values": [4]}]}`,
+			"tensor(k{},l{},x[1]): 2 cells in 2 blocks {{k:a,l:1}: 3, {k:b,l:2}: 4}, norm 5"},
+		{`{"type": "tensor(x[2])", "cells": [{"address": {"x": "0"}, "value": 3.0}, {"address": {"x": "1"}, "value": 4.0}]}`,
+			"tensor(x[2]): 2 cells {{x:0}: 3.0, {x:1}: 4.0}, norm 5"},
+		{`{"type": "tensor(k{})", "cells": {}}`,
+			"tensor(k{}): 0 cells {}, norm 0"},
+	}
+	for _, tt := range tests {
+		summary, ok := summarizeTensor(json.RawMessage(tt.tensor))
+		assert.True(t, ok, tt.tensor)
+		assert.Equal(t, tt.summary, summary, tt.tensor)
+	}
+	for _, notTensor := range []string{`"text"`, `42`, `{"type": "person", "values": [1]}`, `{"type": "tensor<int8>(x[2])", "values": "0102"}`, `[1, 2]`} {
+		_, ok := summarizeTensor(json.RawMessage(notTensor))
+		assert.False(t, ok, notTensor)
+	}
+}
+
+func TestPrintPrettyDocument(t *testing.T) {
+	body := []byte(`{"pathId":"/document/v1/ns/music/docid/a","id":"id:ns:music::a","fields":{"title":"A","embedding":{"type":"tensor(x[2])","values":[3,4]},"tags":["x"]}}`)
+	var buf bytes.Buffer
+	require.Nil(t, printPrettyDocument(&buf, body))
+	assert.Equal(t, `{
+  "pathId": "/document/v1/ns/music/docid/a",
+  "id": "id:ns:music::a",
+  "fields": {
+    "title": "A",
+    "embedding": "tensor(x[2]): 2 cells [3, 4], norm 5",
+    "tags": [
+      "x"
+    ]
+  }
+}
+`, buf.String())
+
+	projected, err := projectFields(body, []string{"tags", "title", "unknown"})
+	require.Nil(t, err)
+	assert.Equal(t, `{"pathId":"/document/v1/ns/music/docid/a","id":"id:ns:music::a","fields":{"title":"A","tags":["x"]}}`, string(projected))
+}
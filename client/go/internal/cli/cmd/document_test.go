@@ -6,8 +6,11 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -125,6 +128,78 @@ func TestDocumentSendPutWithDataAndId(t *testing.T) {
 	assertFieldsEqual(t, doc, body)
 }
 
+func TestDocumentUpdateWithFlags(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	id := "id:mynamespace:music::a-head-full-of-dreams"
+	assert.Nil(t, cli.Run("document", "update", id, "--set", "available=false", "--set", "year=2015", "--set", "title=A Head Full of Dreams",
+		"--add", "tracks=Up&Up", "--add", "tracks=1", "--add", "tags{pop}=10", "--add", "tags{britpop}", "--remove", "genres{rock}", "--remove-field", "label", "--dry-run"))
+	assert.Equal(t, `{
+  "update": "id:mynamespace:music::a-head-full-of-dreams",
+  "fields": {
+    "available": {
+      "assign": false
+    },
+    "year": {
+      "assign": 2015
+    },
+    "title": {
+      "assign": "A Head Full of Dreams"
+    },
+    "label": {
+      "assign": null
+    },
+    "tracks": {
+      "add": [
+        "Up&Up",
+        1
+      ]
+    },
+    "tags": {
+      "add": {
+        "britpop": 1,
+        "pop": 10
+      }
+    },
+    "genres": {
+      "remove": {
+        "rock": 0
+      }
+    }
+  }
+}
+`, stdout.String())
+	assert.Nil(t, client.LastRequest)
+
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "update", id, "--set", "year=2015", "--remove", "tracks=Up&Up", "--string"))
+	assert.Equal(t, "Success: update "+id+"\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "PUT", client.LastRequest.Method)
+	body, err := io.ReadAll(client.LastRequest.Body)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"fields": {"year": {"assign": "2015"}, "tracks": {"remove": ["Up&Up"]}}}`, string(body))
+}
+
+func TestDocumentUpdateWithInvalidFlags(t *testing.T) {
+	id := "id:mynamespace:music::a-head-full-of-dreams"
+	assertUpdateError := func(expected string, args ...string) {
+		t.Helper()
+		cli, _, stderr := newTestCLI(t)
+		assert.NotNil(t, cli.Run(append([]string{"document", "update"}, args...)...))
+		assert.Equal(t, expected, stderr.String())
+	}
+	assertUpdateError("Error: options --set, --add, --remove and --remove-field require a document id, and cannot be combined with a json file or --data\n"+
+		"Hint: Example: vespa document update id:mynamespace:music::a-head-full-of-dreams --set year=2015\n", "--set", "year=2015")
+	assertUpdateError("Error: invalid --set \"year\": must be on the form field=value\n", id, "--set", "year")
+	assertUpdateError("Error: field year cannot be both assigned and modified\n", id, "--set", "year=2015", "--add", "year=2016")
+	assertUpdateError("Error: invalid --add \"tags{pop}=many\": weight must be an integer\n", id, "--add", "tags{pop}=many")
+	assertUpdateError("Error: field tags cannot have both array elements and weighted set keys added\n", id, "--add", "tags{pop}", "--add", "tags=rock")
+	assertUpdateError("Error: option --dry-run requires --set, --add, --remove or --remove-field\n", id, "--dry-run")
+}
+
 func TestDocumentSendMissingId(t *testing.T) {
 	cli, _, stderr := newTestCLI(t)
 	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "testdata/A-Head-Full-of-Dreams-Without-Operation.json"))
@@ -133,6 +208,33 @@ func TestDocumentSendMissingId(t *testing.T) {
 		stderr.String())
 }
 
+func TestDocumentPutWithGeneratedId(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	data := `{"fields":{"title":"A Head/Full of Dreams?","year":2015}}`
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "--namespace", "mynamespace", "--document-type", "music", "--id-from", "title", "--data", data))
+	assert.Equal(t, "Success: put id:mynamespace:music::A Head/Full of Dreams?\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/mynamespace/music/docid/A%20Head%2FFull%20of%20Dreams%3F?timeout=60000ms", client.LastRequest.URL.String())
+
+	stdout.Reset()
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "--namespace", "mynamespace", "--document-type", "music", "--id", "uuid", "--id-from", "", "--data", data))
+	assert.Regexp(t, `^Success: put id:mynamespace:music::[0-9a-f-]{36}\n$`, stdout.String())
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "--namespace", "mynamespace", "--document-type", "music", "--id-from", "artist", "--data", data))
+	assert.Equal(t, "Error: cannot generate document id: field \"artist\" is missing\n", stderr.String())
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "--id-from", "title", "--data", data))
+	assert.Equal(t, "Error: options --namespace and --document-type are required to create document IDs\nHint: Example: --namespace mynamespace --document-type music\n", stderr.String())
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "put", "--namespace", "mynamespace", "--document-type", "music", "--id", "sequential", "--data", data))
+	assert.Equal(t, "Error: invalid id: sequential\nHint: Must be \"uuid\"\n", stderr.String())
+}
+
 func TestDocumentSendWithDisagreeingOperations(t *testing.T) {
 	cli, _, stderr := newTestCLI(t)
 	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "document", "update", "testdata/A-Head-Full-of-Dreams-Put.json"))
@@ -142,7 +244,7 @@ func TestDocumentSendWithDisagreeingOperations(t *testing.T) {
 }
 
 func TestDocumentPutDocumentError(t *testing.T) {
-	assertDocumentError(t, 401, "Document error")
+	assertDocumentError(t, 400, "Document error")
 }
 
 func TestDocumentPutServerError(t *testing.T) {
@@ -183,6 +285,282 @@ func TestDocumentGetWithMultipleIds(t *testing.T) {
 		[]string{"id:mynamespace:music::a-head-full-of-dreams", "id:mynamespace:music::everyday-life"}, t)
 }
 
+func TestDocumentGetFormats(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "A"}}`)
+	client.NextResponseString(404, `{"message": "not found"}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::c", "fields": {"title": "C"}}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString("id:ns:music::b\n\nid:ns:music::c\n")
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "jsonl", "id:ns:music::a", "-"))
+	assert.Equal(t, `{"id":"id:ns:music::a","fields":{"title":"A"}}
+{"id":"id:ns:music::c","fields":{"title":"C"}}
+`, stdout.String())
+	assert.Equal(t, "Error: Invalid document operation: Status 404\n{\n    \"message\": \"not found\"\n}\n", stderr.String())
+	require.Len(t, client.Requests, 3)
+	assert.Equal(t, "/document/v1/ns/music/docid/b", client.Requests[1].URL.Path)
+
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	client.NextResponseString(404, `{"message": "not found"}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::c"}`)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "json", "--ignore-missing",
+		"id:ns:music::a", "id:ns:music::b", "id:ns:music::c"))
+	assert.Equal(t, "[\n{\"id\":\"id:ns:music::a\"},\n{\"id\":\"id:ns:music::c\"}\n]\n", stdout.String())
+
+	client.NextResponseString(404, `{"message": "not found"}`)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "json", "--strict",
+		"id:ns:music::a", "id:ns:music::b"))
+	assert.Equal(t, "[]\n", stdout.String())
+	assert.Len(t, client.Requests, 7)
+
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "xml", "id:ns:music::a"))
+}
+
+func TestDocumentGetPrettyAndRaw(t *testing.T) {
+	response := `{"id": "id:ns:music::a", "fields": {"title": "A", "year": 2015, "embedding": {"type": "tensor<float>(x[4])", "values": [1, 2, 2, 4]}}}`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, response)
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "pretty", "--fields", "title,embedding", "--field-set", "music:[document]", "--offline", "id:ns:music::a"))
+	assert.Equal(t, `{
+  "id": "id:ns:music::a",
+  "fields": {
+    "title": "A",
+    "embedding": "tensor<float>(x[4]): 4 cells [1, 2, 2, ...], norm 5"
+  }
+}
+`, stdout.String())
+	assert.Equal(t, "music:[document]", client.LastRequest.URL.Query().Get("fieldSet"))
+
+	client.NextResponseString(200, response)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "jsonl", "--fields", "year", "id:ns:music::a"))
+	assert.Equal(t, `{"id":"id:ns:music::a","fields":{"year":2015}}`+"\n", stdout.String())
+
+	client.NextResponseString(200, response)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--raw", "id:ns:music::a"))
+	assert.Equal(t, response+"\n", stdout.String())
+
+	cli, _, stderr := newTestCLI(t)
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--raw", "--fields", "title", "id:ns:music::a"))
+	assert.Equal(t, "Error: option --raw cannot be combined with --fields\n", stderr.String())
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--raw", "--format", "json", "id:ns:music::a"))
+	assert.Equal(t, "Error: option --raw cannot be combined with --format\n", stderr.String())
+}
+
+func TestDocumentRemoveSelection(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponse(contentClustersResponse("music"))
+	client.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 3, "continuation": "AAA"}`)
+	client.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 2}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "music.year < 1990", "--cluster", "music", "--force"))
+	assert.Equal(t, "Success: Removed 5 documents matching 'music.year < 1990' in 2 requests\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	require.Len(t, client.Requests, 3)
+	assert.Equal(t, "DELETE", client.Requests[1].Method)
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/?cluster=music&selection=music.year+%3C+1990&timeout=60000ms", client.Requests[1].URL.String())
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/?cluster=music&continuation=AAA&selection=music.year+%3C+1990&timeout=60000ms", client.Requests[2].URL.String())
+
+	// The number of buckets is read from continuation tokens. The first is one returned by /document/v1, with 8 of 256
+	// buckets finished
+	visitToken := "AAAACAAAAAAAAAAJAAAAAAAAAAgAAAAAAAABAAAAAAEgAAAAAAAAEgAAAAAAAAAA"
+	var progress []byte
+	for _, v := range []int64{16, 32768, 32768, 65536} {
+		if len(progress) == 0 {
+			progress = binary.BigEndian.AppendUint32(progress, uint32(v))
+		} else {
+			progress = binary.BigEndian.AppendUint64(progress, uint64(v))
+		}
+	}
+	progressToken := base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint32(progress, 0))
+	bucketClient := &mock.HTTPClient{}
+	bucketClient.NextResponse(contentClustersResponse("music"))
+	bucketClient.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 3, "continuation": "`+visitToken+`"}`)
+	bucketClient.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 2, "continuation": "`+progressToken+`"}`)
+	bucketClient.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 1}`)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = bucketClient
+	require.Nil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "true", "--content-cluster", "music", "--force"))
+	assert.Equal(t, "Success: Removed 6 documents matching 'true' in 3 requests, processing 65536 buckets\n", stdout.String())
+	assert.Len(t, bucketClient.Requests, 4)
+	finished, total, ok := bucketProgress(visitToken)
+	assert.True(t, ok)
+	assert.Equal(t, []int64{8, 256}, []int64{finished, total})
+	finished, total, ok = bucketProgress(progressToken)
+	assert.True(t, ok)
+	assert.Equal(t, []int64{32768, 65536}, []int64{finished, total})
+	_, _, ok = bucketProgress("AAA")
+	assert.False(t, ok)
+
+	// Confirmation is required
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return false }
+	assert.NotNil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "true"))
+	assert.Contains(t, stderr.String(), "Error: refusing to remove all documents matching 'true' without confirmation [CONFIRMATION_REQUIRED]\n")
+	assert.Len(t, client.Requests, 3)
+
+	// Confirmation is given by typing the selection, or the content cluster
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = bytes.NewBufferString("y\n")
+	assert.NotNil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "true"))
+	assert.Contains(t, stderr.String(), "refusing to remove all documents matching 'true' without confirmation [CONFIRMATION_REQUIRED]\n")
+	assert.Len(t, client.Requests, 3)
+
+	var buf bytes.Buffer
+	buf.WriteString("true\n")
+	client.NextResponseString(400, `{"message": "Must specify cluster"}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = &buf
+	assert.NotNil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "true"))
+	assert.Contains(t, stderr.String(), "Invalid document operation: Status 400\n\n{\n    \"message\": \"Must specify cluster\"\n}\n")
+	assert.Contains(t, stderr.String(), "Choose the content cluster with --content-cluster\n")
+	assert.Len(t, client.Requests, 5) // Failure and listing of content clusters
+
+	assert.NotNil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--selection", "true", "id:ns:music::a"))
+	assert.Len(t, client.Requests, 5)
+}
+
+func contentClustersResponse(clusters ...string) mock.HTTPResponse {
+	var services []string
+	for i, cluster := range clusters {
+		services = append(services, fmt.Sprintf(`{"host": "host%d", "port": 19111, "type": "distributor", "clusterName": "%s", "currentGeneration": 2}`, i, cluster))
+	}
+	return mock.HTTPResponse{
+		URI:    "/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge",
+		Status: 200,
+		Body:   []byte(`{"currentGeneration": 2, "converged": true, "services": [` + strings.Join(services, ", ") + `]}`),
+	}
+}
+
+func TestDocumentContentCluster(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponse(contentClustersResponse("music", "books"))
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "put", "-t", "http://127.0.0.1:8080", "--content-cluster", "books", "--route", "books-route", "id:ns:music::a", "testdata/A-Head-Full-of-Dreams-Without-Operation.json"))
+	assert.Equal(t, "Success: put id:ns:music::a in content cluster books\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "http://127.0.0.1:8080/document/v1/ns/music/docid/a?timeout=60000ms&route=books-route&cluster=books", client.LastRequest.URL.String())
+
+	client.NextResponse(contentClustersResponse("music", "books"))
+	client.NextResponseString(200, `{"fields": {"title": "A"}}`)
+	stdout.Reset()
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--content-cluster", "music", "id:ns:music::a"))
+	assert.Equal(t, "music", client.LastRequest.URL.Query().Get("cluster"))
+	assert.Contains(t, stdout.String(), `"title": "A"`)
+
+	// Unknown clusters are rejected when the deployed clusters are known
+	client.NextResponse(contentClustersResponse("music", "books"))
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	requests := len(client.Requests)
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--content-cluster", "movies", "id:ns:music::a"))
+	assert.Equal(t, "Error: content cluster movies not found\nHint: Must be one of books, music\n", stderr.String())
+	assert.Len(t, client.Requests, requests+1)
+
+	// The clusters listed in an error from the server are given as a hint
+	client.NextResponseString(500, "")
+	client.NextResponseString(400, `{"message": "Your Vespa deployment has no content cluster 'foo', only 'music', 'books'"}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("document", "remove", "-t", "http://127.0.0.1:8080", "--content-cluster", "foo", "id:ns:music::a"))
+	assert.Contains(t, stderr.String(), "Hint: Choose one of the content clusters with --content-cluster: music, books\n")
+}
+
+func TestDocumentQuiet(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = bytes.NewBufferString("true\n")
+	assert.NotNil(t, cli.Run("document", "remove", "-q", "-t", "http://127.0.0.1:8080", "--selection", "true"))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "Error: refusing to remove all documents matching 'true' without confirmation [CONFIRMATION_REQUIRED]\n", stderr.String())
+	assert.Len(t, client.Requests, 0)
+
+	client.NextResponseString(200, `{"pathId": "/document/v1/", "documentCount": 1}`)
+	stderr.Reset()
+	require.Nil(t, cli.Run("document", "remove", "-q", "-t", "http://127.0.0.1:8080", "--selection", "true", "--force"))
+	assert.Equal(t, "", stdout.String())
+	assert.Equal(t, "", stderr.String())
+
+	client.NextResponseString(200, `{"fields":{"title":"A"}}`)
+	require.Nil(t, cli.Run("document", "get", "-q", "-t", "http://127.0.0.1:8080", "id:ns:music::a"))
+	assert.Equal(t, "", stderr.String())
+	assert.Contains(t, stdout.String(), `"title": "A"`)
+}
+
+func TestDocumentBatch(t *testing.T) {
+	ops := `{"put": "id:ns:music::a", "fields": {"title": "A"}}
+{"update": "id:ns:music::b", "fields": {"title": {"assign": "B"}}}
+{"remove": "id:ns:music::c"}
+`
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "batch", "-t", "http://127.0.0.1:8080", "--data", ops))
+	assert.Equal(t, "Success: put id:ns:music::a\nSuccess: update id:ns:music::b\nSuccess: remove id:ns:music::c\n", stdout.String())
+	assert.Equal(t, "", stderr.String())
+	require.Len(t, client.Requests, 3)
+	assert.Equal(t, "POST", client.Requests[0].Method)
+	assert.Equal(t, "PUT", client.Requests[1].Method)
+	assert.Equal(t, "DELETE", client.Requests[2].Method)
+}
+
+func TestDocumentBatchStopsAtFirstError(t *testing.T) {
+	ops := `{"put": "id:ns:music::a", "fields": {}}
+{"put": "id:ns:music::b", "fields": {}}
+{"put": "id:ns:music::c", "fields": {}}
+`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	client.NextResponseString(400, `{"message": "bad field"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("document", "batch", "-t", "http://127.0.0.1:8080", "--data", ops))
+	assert.Equal(t, "Success: put id:ns:music::a\n", stdout.String())
+	assert.Equal(t, "Error: put id:ns:music::b: Status 400: bad field\nError: 1 of 2 document operations failed\n", stderr.String())
+	assert.Len(t, client.Requests, 2)
+}
+
+func TestDocumentBatchContinueOnError(t *testing.T) {
+	ops := `{"put": "id:ns:music::a", "fields": {}}
+{"put": "id:ns:music::b", "fields": {"title": }}
+{"put": "id:ns:music::c", "fields": {}}
+`
+	client := &mock.HTTPClient{}
+	client.NextResponseError(errors.New("connection refused"))
+	client.NextResponseString(200, `{"id": "id:ns:music::c"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("document", "batch", "-t", "http://127.0.0.1:8080", "--continue-on-error", "--data", ops))
+	assert.Equal(t, "Success: put id:ns:music::c\n", stdout.String())
+	errLines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	require.Len(t, errLines, 3)
+	assert.Equal(t, "Error: put id:ns:music::a: connection refused", errLines[0])
+	assert.True(t, strings.HasPrefix(errLines[1], "Error: invalid document operation: "), errLines[1])
+	assert.Equal(t, "Error: 2 of 3 document operations failed", errLines[2])
+	assert.Len(t, client.Requests, 1)
+}
+
 func assertDocumentSend(args []string, expectedOperation string, expectedMethod string, expectedDocumentId string, expectedPayloadFile string, t *testing.T) {
 	t.Helper()
 	client := &mock.HTTPClient{}
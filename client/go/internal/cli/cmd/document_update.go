@@ -0,0 +1,243 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Partial updates of vespa document update built from flags
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// updateFlags holds the flags of vespa document update which build a partial update.
+type updateFlags struct {
+	set          []string
+	add          []string
+	remove       []string
+	removeFields []string
+	strings      bool
+	dryRun       bool
+}
+
+// fieldUpdate holds the update operations of a single field.
+type fieldUpdate struct {
+	name        string
+	assign      json.RawMessage
+	arrayAdd    []json.RawMessage
+	setAdd      map[string]int64
+	arrayRemove []json.RawMessage
+	setRemove   map[string]int
+}
+
+func addUpdateFlags(cmd *cobra.Command, flags *updateFlags) {
+	cmd.Flags().StringArrayVar(&flags.set, "set", nil, "Assign a value to a field, on the form field=value. May be repeated")
+	cmd.Flags().StringArrayVar(&flags.add, "add", nil, "Add an element to an array field, on the form field=value, or a key to a weighted set, on the form field{key}=weight. May be repeated")
+	cmd.Flags().StringArrayVar(&flags.remove, "remove", nil, "Remove an element from an array field, on the form field=value, or a key from a weighted set, on the form field{key}. May be repeated")
+	cmd.Flags().StringArrayVar(&flags.removeFields, "remove-field", nil, "Clear the value of a field. May be repeated")
+	cmd.Flags().BoolVar(&flags.strings, "string", false, "Treat all values given with --set, --add and --remove as strings, instead of inferring numbers and booleans")
+	cmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Print the update built from --set, --add, --remove and --remove-field, without sending it")
+}
+
+// given returns whether any flag building a partial update is given.
+func (f *updateFlags) given() bool {
+	return len(f.set) > 0 || len(f.add) > 0 || len(f.remove) > 0 || len(f.removeFields) > 0
+}
+
+// build returns the update operation of the document with given id, in the JSON format of document operations.
+func (f *updateFlags) build(id string) ([]byte, error) {
+	if _, err := document.ParseId(id); err != nil {
+		return nil, err
+	}
+	var updates []*fieldUpdate
+	byName := make(map[string]*fieldUpdate)
+	field := func(name string) (*fieldUpdate, error) {
+		if name == "" {
+			return nil, fmt.Errorf("field name cannot be empty")
+		}
+		update, ok := byName[name]
+		if !ok {
+			update = &fieldUpdate{name: name}
+			byName[name] = update
+			updates = append(updates, update)
+		} else if update.assign != nil {
+			return nil, fmt.Errorf("field %s cannot be both assigned and modified", name)
+		}
+		return update, nil
+	}
+	for _, arg := range f.set {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --set %q: must be on the form field=value", arg)
+		}
+		if _, exists := byName[name]; exists {
+			return nil, fmt.Errorf("field %s cannot be both assigned and modified", name)
+		}
+		update, err := field(name)
+		if err != nil {
+			return nil, err
+		}
+		update.assign = f.value(value)
+	}
+	for _, name := range f.removeFields {
+		if _, exists := byName[name]; exists {
+			return nil, fmt.Errorf("field %s cannot be both removed and modified", name)
+		}
+		update, err := field(name)
+		if err != nil {
+			return nil, err
+		}
+		update.assign = json.RawMessage("null")
+	}
+	for _, arg := range f.add {
+		name, key, isSet, rest := splitSetKey(arg)
+		if !isSet {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid --add %q: must be on the form field=value or field{key}=weight", arg)
+			}
+			update, err := field(name)
+			if err != nil {
+				return nil, err
+			}
+			update.arrayAdd = append(update.arrayAdd, f.value(value))
+			continue
+		}
+		weight := int64(1)
+		if rest != "" {
+			w, err := strconv.ParseInt(strings.TrimPrefix(rest, "="), 10, 64)
+			if !strings.HasPrefix(rest, "=") || err != nil {
+				return nil, fmt.Errorf("invalid --add %q: weight must be an integer", arg)
+			}
+			weight = w
+		}
+		update, err := field(name)
+		if err != nil {
+			return nil, err
+		}
+		if update.setAdd == nil {
+			update.setAdd = make(map[string]int64)
+		}
+		update.setAdd[key] = weight
+	}
+	for _, arg := range f.remove {
+		name, key, isSet, rest := splitSetKey(arg)
+		if !isSet {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid --remove %q: must be on the form field=value or field{key}", arg)
+			}
+			update, err := field(name)
+			if err != nil {
+				return nil, err
+			}
+			update.arrayRemove = append(update.arrayRemove, f.value(value))
+			continue
+		}
+		if rest != "" {
+			return nil, fmt.Errorf("invalid --remove %q: must be on the form field{key}", arg)
+		}
+		update, err := field(name)
+		if err != nil {
+			return nil, err
+		}
+		if update.setRemove == nil {
+			update.setRemove = make(map[string]int)
+		}
+		update.setRemove[key] = 0
+	}
+	var buf bytes.Buffer
+	idJSON, _ := marshalUnescaped(id)
+	buf.WriteString(`{"update":`)
+	buf.Write(idJSON)
+	buf.WriteString(`,"fields":{`)
+	for i, update := range updates {
+		operations, err := update.operations()
+		if err != nil {
+			return nil, err
+		}
+		nameJSON, _ := marshalUnescaped(update.name)
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(nameJSON)
+		buf.WriteByte(':')
+		buf.Write(operations)
+	}
+	buf.WriteString("}}")
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// value returns the JSON of given value, which is a number or a boolean if it parses as one, and a string otherwise.
+func (f *updateFlags) value(value string) json.RawMessage {
+	if !f.strings && json.Valid([]byte(value)) {
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err == nil {
+			switch v.(type) {
+			case float64, bool:
+				return json.RawMessage(value)
+			}
+		}
+	}
+	data, _ := marshalUnescaped(value)
+	return data
+}
+
+// marshalUnescaped returns the JSON encoding of v, without escaping HTML characters, such that printed updates read as
+// the values given.
+func marshalUnescaped(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// splitSetKey splits arg on the form field{key}rest into its parts, and returns whether arg is on this form.
+func splitSetKey(arg string) (field, key string, ok bool, rest string) {
+	start := strings.Index(arg, "{")
+	if start < 0 {
+		return "", "", false, ""
+	}
+	if eq := strings.Index(arg, "="); eq >= 0 && eq < start {
+		return "", "", false, "" // Brace is part of an array value
+	}
+	end := strings.Index(arg[start:], "}")
+	if end < 0 {
+		return "", "", false, ""
+	}
+	return arg[:start], arg[start+1 : start+end], true, arg[start+end+1:]
+}
+
+// operations returns the update operations of this field, in JSON.
+func (u *fieldUpdate) operations() ([]byte, error) {
+	operations := make(map[string]any)
+	if u.assign != nil {
+		operations["assign"] = u.assign
+	}
+	if u.arrayAdd != nil && u.setAdd != nil {
+		return nil, fmt.Errorf("field %s cannot have both array elements and weighted set keys added", u.name)
+	} else if u.arrayAdd != nil {
+		operations["add"] = u.arrayAdd
+	} else if u.setAdd != nil {
+		operations["add"] = u.setAdd
+	}
+	if u.arrayRemove != nil && u.setRemove != nil {
+		return nil, fmt.Errorf("field %s cannot have both array elements and weighted set keys removed", u.name)
+	} else if u.arrayRemove != nil {
+		operations["remove"] = u.arrayRemove
+	} else if u.setRemove != nil {
+		operations["remove"] = u.setRemove
+	}
+	return marshalUnescaped(operations)
+}
@@ -0,0 +1,146 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Download of remote application packages
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+const defaultMavenRepository = "https://repo.maven.apache.org/maven2"
+
+// mavenCoordinate matches a Maven coordinate of a zipped application package, i.e. groupId:artifactId:version[:zip].
+var mavenCoordinate = regexp.MustCompile(`^([A-Za-z0-9_.-]+):([A-Za-z0-9_.-]+):([A-Za-z0-9_.-]+)(:zip)?$`)
+
+// remotePackageOptions holds the options for downloading an application package given as a URL or Maven coordinate.
+type remotePackageOptions struct {
+	headers         []string
+	sha256          string
+	mavenRepository string
+}
+
+func addRemotePackageFlags(cmd *cobra.Command, options *remotePackageOptions) {
+	cmd.Flags().StringArrayVar(&options.headers, "header", nil, "Add a header to the request downloading the application package, e.g. 'Authorization: Bearer ...'. Can be repeated")
+	cmd.Flags().StringVar(&options.sha256, "sha256", "", "Verify that the downloaded application package has this SHA-256 checksum, in hex")
+	cmd.Flags().StringVar(&options.mavenRepository, "maven-repository", defaultMavenRepository, "The Maven repository to download an application package given as a Maven coordinate from")
+}
+
+// remotePackageURL returns the URL to download the application package given as arg from, and whether arg refers to a
+// remote application package at all.
+func remotePackageURL(arg string, options remotePackageOptions) (string, bool) {
+	if strings.HasPrefix(arg, "https://") {
+		return arg, true
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return "", false // An existing file always takes precedence
+	}
+	m := mavenCoordinate.FindStringSubmatch(arg)
+	if m == nil {
+		return "", false
+	}
+	groupID, artifactID, version := m[1], m[2], m[3]
+	return strings.TrimRight(options.mavenRepository, "/") + "/" + path.Join(strings.ReplaceAll(groupID, ".", "/"), artifactID, version, artifactID+"-"+version+".zip"), true
+}
+
+// applicationPackageFromRemote returns the application package given in args as applicationPackageFrom does, but also
+// supports an application package given as an HTTPS URL or Maven coordinate. A remote application package is
+// downloaded to a temporary file, which is removed by the returned cleanup function.
+func (c *CLI) applicationPackageFromRemote(args []string, packageOptions vespa.PackageOptions, options remotePackageOptions) (vespa.ApplicationPackage, func(), error) {
+	noop := func() {}
+	var (
+		url    string
+		remote bool
+	)
+	if len(args) == 1 {
+		url, remote = remotePackageURL(args[0], options)
+	}
+	if !remote {
+		if len(options.headers) > 0 || options.sha256 != "" {
+			return vespa.ApplicationPackage{}, noop, errHint(fmt.Errorf("--header and --sha256 require an application package URL or Maven coordinate"),
+				"Give the application package as an https:// URL, or a Maven coordinate such as com.example:my-app:1.2.3:zip")
+		}
+		pkg, err := c.applicationPackageFrom(args, packageOptions)
+		return pkg, noop, err
+	}
+	zipFile, err := c.downloadApplicationPackage(url, options)
+	if err != nil {
+		return vespa.ApplicationPackage{}, noop, err
+	}
+	cleanup := func() { os.RemoveAll(filepath.Dir(zipFile)) }
+	pkg, err := vespa.FindApplicationPackage(zipFile, packageOptions)
+	if err != nil {
+		cleanup()
+		return vespa.ApplicationPackage{}, noop, err
+	}
+	return pkg, cleanup, nil
+}
+
+// downloadApplicationPackage downloads the application package at url to a file in a new temporary directory, and
+// returns the path of the file.
+func (c *CLI) downloadApplicationPackage(url string, options remotePackageOptions) (string, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	for _, header := range options.headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return "", fmt.Errorf("invalid header %q: must be on the form 'Name: value'", header)
+		}
+		request.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	tmpDir, err := os.MkdirTemp("", "vespa-application")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	name := path.Base(request.URL.Path)
+	if !strings.HasSuffix(name, ".zip") {
+		name = "application.zip"
+	}
+	zipFile := filepath.Join(tmpDir, name)
+	err = c.spinner(c.Stderr, color.YellowString("Downloading application package from "+url+" ..."), func() error {
+		response, err := c.httpClient.Do(request, time.Minute*60)
+		if err != nil {
+			return fmt.Errorf("could not download application package: %w", err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("could not download application package: %s returned status %d", url, response.StatusCode)
+		}
+		f, err := os.Create(zipFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(f, hash), response.Body); err != nil {
+			return fmt.Errorf("could not download application package: %w", err)
+		}
+		if options.sha256 != "" {
+			if checksum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(checksum, options.sha256) {
+				return errHint(fmt.Errorf("checksum of application package from %s is %s, expected %s", url, checksum, strings.ToLower(options.sha256)),
+					"The application package may be corrupt, or the expected checksum wrong")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return zipFile, nil
+}
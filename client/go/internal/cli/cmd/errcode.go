@@ -0,0 +1,341 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// errorCode is a stable code identifying a class of errors returned to the user. Codes are part of the output of the
+// CLI, and must not be changed once added.
+type errorCode string
+
+const (
+	codeAuthExpired                errorCode = "AUTH_EXPIRED"
+	codeAuthFailed                 errorCode = "AUTH_FAILED"
+	codeApplicationPackageNotFound errorCode = "APPLICATION_PACKAGE_NOT_FOUND"
+	codeConfirmationRequired       errorCode = "CONFIRMATION_REQUIRED"
+	codeDeploymentFailed           errorCode = "DEPLOYMENT_FAILED"
+	codeDeploymentNotFound         errorCode = "DEPLOYMENT_NOT_FOUND"
+	codeEndpointUnreachable        errorCode = "ENDPOINT_UNREACHABLE"
+	codeFeedBlocked                errorCode = "FEED_BLOCKED"
+	codeInvalidApplicationPackage  errorCode = "INVALID_APPLICATION_PACKAGE"
+	codeLogEntriesFound            errorCode = "LOG_ENTRIES_FOUND"
+	codeOutOfCapacity              errorCode = "OUT_OF_CAPACITY"
+	codeProductionDestroy          errorCode = "PRODUCTION_DESTROY_REFUSED"
+	codeQuotaExceeded              errorCode = "QUOTA_EXCEEDED"
+	codeRestartRequired            errorCode = "RESTART_REQUIRED"
+	codeServerError                errorCode = "SERVER_ERROR"
+	codeServiceNotReady            errorCode = "SERVICE_NOT_READY"
+	codeWaitTimeout                errorCode = "WAIT_TIMEOUT"
+)
+
+// Exit statuses of Vespa CLI. Each error code maps to one status, such that scripts can tell whether retrying a failed
+// command may help. Statuses are part of the output of the CLI, and must not be changed once added.
+const (
+	exitSuccess    = 0
+	exitError      = 1
+	exitAuth       = 2
+	exitTransient  = 3
+	exitNotFound   = 4
+	exitLogEntries = 5
+	// exitTestsFailed is the status of failing tests, which it was before the other statuses were introduced
+	exitTestsFailed = exitTransient
+	exitInterrupted = 130
+)
+
+// exitStatusInfo explains an exit status to the user.
+type exitStatusInfo struct {
+	status      int
+	description string
+}
+
+// exitStatuses holds the exit statuses of Vespa CLI, ordered by status.
+var exitStatuses = []exitStatusInfo{
+	{exitSuccess, "The command succeeded."},
+	{exitError, "The command failed because of a usage or user error, such as an invalid flag, configuration or application package. Retrying does not help until the error is fixed."},
+	{exitAuth, "Authentication or authorization failed, because credentials are missing, expired or do not grant access."},
+	{exitTransient, "The command failed because of a transient failure, such as a network error, a server error, a service which is not ready yet, or waiting which timed out. Retrying may help. This is also the status of 'vespa test' and 'vespa test generate' when tests fail."},
+	{exitNotFound, "A resource, such as the application package or the deployment, does not exist."},
+	{exitLogEntries, "Log entries at or above the level given by --fail-on were found by 'vespa log'."},
+	{exitInterrupted, "The command was interrupted, e.g. by Ctrl-C."},
+}
+
+// errorCodeInfo explains an error code to the user.
+type errorCodeInfo struct {
+	// status is the exit status of errors with this code
+	status      int
+	summary     string
+	description string
+	remediation []string
+}
+
+var errorCodes = map[errorCode]errorCodeInfo{
+	codeAuthExpired: {
+		status:      exitAuth,
+		summary:     "The login session has expired",
+		description: "The access token of the current login session is missing, or has expired and could not be renewed. This happens when the session has been unused for a long time, or when the authentication scopes of Vespa CLI changed in an upgrade.",
+		remediation: []string{
+			"Run 'vespa auth login' to log in again",
+			"In automated environments, use an API key instead of logging in, see 'vespa auth api-key'",
+		},
+	},
+	codeAuthFailed: {
+		status:      exitAuth,
+		summary:     "The request was not authorized",
+		description: "The request was rejected because the credentials in use do not grant access to the tenant, application or deployment. Requests to Vespa Cloud are authenticated with an access token or an API key, while requests to the data plane of an application are authenticated with a certificate or a data plane token.",
+		remediation: []string{
+			"Run 'vespa auth show' to see the credentials in use",
+			"Check that the tenant exists, and that you are a member of it",
+			"Run 'vespa auth cert' and redeploy if the data plane certificate is missing or has been replaced",
+			"Run 'vespa status auth' to diagnose requests to the data plane",
+		},
+	},
+	codeApplicationPackageNotFound: {
+		status:      exitNotFound,
+		summary:     "No application package was found",
+		description: "No application package was found at the given path, or in the current directory. An application package is a directory holding services.xml, or a zip file of such a directory.",
+		remediation: []string{
+			"Run the command from the directory of the application package, or give its path as an argument",
+			"Run 'vespa clone' to create an application package from a sample application",
+		},
+	},
+	codeConfirmationRequired: {
+		status:      exitError,
+		summary:     "The operation was not confirmed",
+		description: "The operation cannot be undone, and requires interactive confirmation, which was not given. Confirmation cannot be given when standard input is not a terminal.",
+		remediation: []string{
+			"Run the command again and confirm the operation",
+			"Use --force to skip confirmation, in automated environments",
+		},
+	},
+	codeDeploymentFailed: {
+		status:      exitError,
+		summary:     "The deployment failed",
+		description: "The application package was accepted, but the deployment did not complete successfully. On Vespa Cloud, the run log holds the details of the failure.",
+		remediation: []string{
+			"Inspect the log messages printed with the error",
+			"On Vespa Cloud, see the run log in the console, or run 'vespa status deployment'",
+			"Fix the problem and deploy again",
+		},
+	},
+	codeDeploymentNotFound: {
+		status:      exitNotFound,
+		summary:     "The deployment does not exist",
+		description: "The application, instance or zone given does not have a deployment, or it has been removed.",
+		remediation: []string{
+			"Check the --application and --zone flags, and the application and zone config options",
+			"Run 'vespa application list' to see the existing deployments",
+			"Run 'vespa deploy' to create the deployment",
+		},
+	},
+	codeEndpointUnreachable: {
+		status:      exitTransient,
+		summary:     "A network connection could not be made",
+		description: "Vespa CLI could not connect to the config server, the Vespa Cloud API or an endpoint of the application. The service may not be running, the name may not resolve, or the network may block the connection.",
+		remediation: []string{
+			"For a local target, check that Vespa is running, e.g. with 'docker ps'",
+			"Check the target URL, given by --target or the target config option",
+			"Check network access, such as proxies and VPN, if the target is remote",
+		},
+	},
+	codeFeedBlocked: {
+		status:      exitError,
+		summary:     "Feed is blocked in a content cluster",
+		description: "A content node uses more disk or memory than the limit of its content cluster, so the cluster rejects writes to protect itself. Writes are accepted again once usage is below the limits.",
+		remediation: []string{
+			"Run 'vespa status' to see the resource usage of each content cluster",
+			"Add content nodes or larger nodes, or remove documents, to bring usage below the limits",
+			"See https://docs.vespa.ai/en/operations/feed-block.html",
+		},
+	},
+	codeInvalidApplicationPackage: {
+		status:      exitError,
+		summary:     "The application package was rejected",
+		description: "The config server rejected the application package because it is invalid, for example because of a schema error or an invalid services.xml.",
+		remediation: []string{
+			"Read the error message, which names the problem",
+			"Run 'vespa schema validate' to find common problems locally",
+			"Fix the application package and deploy again",
+		},
+	},
+	codeLogEntriesFound: {
+		status:      exitLogEntries,
+		summary:     "The log has entries at the failure level",
+		description: "The log entries fetched by 'vespa log --fail-on' include entries at or above the given level, such as errors logged by the application after a deployment. The entries are printed before the error, also with --quiet.",
+		remediation: []string{
+			"Inspect the log entries printed, and fix their cause",
+			"Narrow the entries considered with --since, --service, --host or --grep",
+		},
+	},
+	codeOutOfCapacity: {
+		status:      exitTransient,
+		summary:     "Not enough capacity for the deployment",
+		description: "The zone does not currently have enough capacity for the resources requested by the application package.",
+		remediation: []string{
+			"Wait and deploy again, as capacity may become available",
+			"Reduce the node count or resources in services.xml, or deploy to another zone",
+		},
+	},
+	codeProductionDestroy: {
+		status:      exitError,
+		summary:     "Production deployments cannot be removed with this command",
+		description: "Only deployments in the dev and perf environments can be removed with 'vespa destroy'. Production deployments are removed by removing them from deployment.xml, and deploying with a validation override.",
+		remediation: []string{
+			"See https://docs.vespa.ai/en/cloud/deleting-applications.html",
+		},
+	},
+	codeQuotaExceeded: {
+		status:      exitError,
+		summary:     "The deployment exceeds the quota of the tenant",
+		description: "The resources requested by the application package would exceed the quota of the tenant.",
+		remediation: []string{
+			"Reduce the node count or resources in services.xml",
+			"Remove unused deployments, e.g. with 'vespa destroy'",
+			"Contact Vespa Cloud support to increase the quota",
+		},
+	},
+	codeRestartRequired: {
+		status:      exitError,
+		summary:     "The deployment requires a restart or re-feed",
+		description: "The application package was prepared, but not activated, because --require-no-restart was given and the change requires services to be restarted, or documents to be re-fed.",
+		remediation: []string{
+			"Deploy without --require-no-restart to activate the change, and restart or re-feed as printed",
+		},
+	},
+	codeServerError: {
+		status:      exitTransient,
+		summary:     "Vespa failed to handle the request",
+		description: "Vespa responded with a server error, i.e. an HTTP status of 500 or above, or so many requests failed in a row that Vespa CLI stopped sending more. The service may be overloaded, restarting, or temporarily unavailable.",
+		remediation: []string{
+			"Retry the command, as the failure may be temporary",
+			"Reduce the rate of requests, e.g. with --max-ops-per-second for 'vespa feed'",
+			"Run 'vespa status' to check the health of the services",
+		},
+	},
+	codeServiceNotReady: {
+		status:      exitTransient,
+		summary:     "A service is not ready",
+		description: "A service of the application does not exist yet, or did not become ready. This is common shortly after a deployment, while nodes are started.",
+		remediation: []string{
+			"Wait for the service with the --wait flag, e.g. --wait 300",
+			"Run 'vespa status deployment' to see whether the deployment has completed",
+		},
+	},
+	codeWaitTimeout: {
+		status:      exitTransient,
+		summary:     "Waiting timed out",
+		description: "The deployment or service being waited for did not complete or become ready within the time given by --wait. It may complete later.",
+		remediation: []string{
+			"Increase the time given by --wait",
+			"Run 'vespa status deployment' to check progress",
+		},
+	},
+}
+
+// sortedErrorCodes returns all error codes, sorted.
+func sortedErrorCodes() []string {
+	codes := make([]string, 0, len(errorCodes))
+	for code := range errorCodes {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// exitStatus returns the exit status of errors with code c.
+func (c errorCode) exitStatus() int {
+	if info, ok := errorCodes[c]; ok {
+		return info.status
+	}
+	return exitError
+}
+
+// errCode creates a new CLI error with given code, and optional hints that will be printed after the error
+func errCode(code errorCode, err error, hints ...string) ErrCLI {
+	return ErrCLI{Status: code.exitStatus(), code: code, hints: hints, error: err}
+}
+
+// withErrorCode sets the code of err, if it has none and its code can be determined from the errors it wraps. The exit
+// status of err is then that of the code, unless err has another status than exitError.
+func withErrorCode(err error) error {
+	cliErr, ok := err.(ErrCLI)
+	if !ok {
+		cliErr = errHint(err)
+	}
+	if cliErr.code != "" {
+		return err
+	}
+	code := inferErrorCode(cliErr.error)
+	if code == "" {
+		return err
+	}
+	cliErr.code = code
+	if cliErr.Status == exitError {
+		cliErr.Status = code.exitStatus()
+	}
+	return cliErr
+}
+
+// inferErrorCode returns the code of err, based on the well-known errors it wraps, or an empty code if none is known.
+func inferErrorCode(err error) errorCode {
+	var deployErr *vespa.DeployError
+	if errors.As(err, &deployErr) {
+		switch {
+		case deployErr.ErrorCode == string(codeQuotaExceeded):
+			return codeQuotaExceeded
+		case deployErr.ErrorCode == string(codeOutOfCapacity):
+			return codeOutOfCapacity
+		case deployErr.ErrorCode == string(codeInvalidApplicationPackage):
+			return codeInvalidApplicationPackage
+		case deployErr.ErrorCode == "NOT_FOUND" || deployErr.StatusCode == 404:
+			return codeDeploymentNotFound
+		case deployErr.StatusCode == 401 || deployErr.StatusCode == 403:
+			return codeAuthFailed
+		case deployErr.StatusCode >= 500:
+			return codeServerError
+		}
+	}
+	var authErr vespa.AuthError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, auth0.ErrLoginRequired):
+		return codeAuthExpired
+	case errors.Is(err, vespa.ErrUnauthorized), errors.As(err, &authErr):
+		return codeAuthFailed
+	case errors.Is(err, vespa.ErrDeployment):
+		return codeDeploymentFailed
+	case errors.Is(err, vespa.ErrWaitTimeout):
+		return codeWaitTimeout
+	case errors.Is(err, vespa.ErrNoApplicationPackage):
+		return codeApplicationPackageNotFound
+	case errors.Is(err, document.ErrTooManyErrors):
+		return codeServerError
+	case errors.As(err, &dnsErr), errors.As(err, &opErr):
+		return codeEndpointUnreachable
+	case deployErr != nil && deployErr.StatusCode == 400:
+		return codeInvalidApplicationPackage
+	}
+	return ""
+}
+
+// codedMessage returns the message of err, with its code, if any, as a suffix of the first line.
+func codedMessage(err ErrCLI) string {
+	msg := err.Error()
+	if err.code == "" {
+		return msg
+	}
+	first, rest, multiline := strings.Cut(msg, "\n")
+	msg = first + " [" + string(err.code) + "]"
+	if multiline {
+		msg += "\n" + rest
+	}
+	return msg
+}
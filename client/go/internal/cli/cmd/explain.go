@@ -0,0 +1,164 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// explainWidth is the width at which explanations are wrapped.
+const explainWidth = 80
+
+type errorCodeExplanation struct {
+	Code        string   `json:"code"`
+	ExitStatus  int      `json:"exitStatus"`
+	Summary     string   `json:"summary"`
+	Description string   `json:"description"`
+	Remediation []string `json:"remediation"`
+}
+
+func newExplainCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "explain [error-code]",
+		Short: "Explain an error code",
+		Long: `Explain an error code.
+
+Errors printed by Vespa CLI may carry a stable error code, such as
+AUTH_EXPIRED, which is printed in brackets after the error message, and as the
+"code" of the error object with --output json. Error codes do not change
+between versions of Vespa CLI, and can be used by scripts to react to specific
+failures. Each error code also determines the exit status of the command, see
+'vespa help exit-codes'.
+
+This command prints a description of the given error code and the steps to
+resolve it. Without an argument, all error codes are listed. This command works
+offline.`,
+		Example: `$ vespa explain AUTH_EXPIRED
+$ vespa explain`,
+		Args:              cobra.MaximumNArgs(1),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return sortedErrorCodes(), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return listErrorCodes(cli)
+			}
+			code := errorCode(strings.ToUpper(args[0]))
+			info, ok := errorCodes[code]
+			if !ok {
+				return errHint(fmt.Errorf("unknown error code: %s", args[0]), "Available codes: "+strings.Join(sortedErrorCodes(), ", "))
+			}
+			if cli.jsonOutput() {
+				return cli.printResult(errorCodeExplanation{
+					Code:        string(code),
+					ExitStatus:  info.status,
+					Summary:     info.summary,
+					Description: info.description,
+					Remediation: info.remediation,
+				})
+			}
+			fmt.Fprintf(cli.Stdout, "%s: %s\n\n", color.CyanString(string(code)), info.summary)
+			writeWrapped(cli.Stdout, info.description, "")
+			fmt.Fprintln(cli.Stdout)
+			fmt.Fprintf(cli.Stdout, "Exit status: %d\n\n", info.status)
+			fmt.Fprintln(cli.Stdout, "To resolve this:")
+			for _, step := range info.remediation {
+				writeWrapped(cli.Stdout, "- "+step, "  ")
+			}
+			return nil
+		},
+	}
+}
+
+func listErrorCodes(cli *CLI) error {
+	if cli.jsonOutput() {
+		var codes []errorCodeExplanation
+		for _, code := range sortedErrorCodes() {
+			info := errorCodes[errorCode(code)]
+			codes = append(codes, errorCodeExplanation{Code: code, ExitStatus: info.status, Summary: info.summary, Description: info.description, Remediation: info.remediation})
+		}
+		return cli.printResult(codes)
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	for _, code := range sortedErrorCodes() {
+		fmt.Fprintf(w, "%s\t%s\n", code, errorCodes[errorCode(code)].summary)
+	}
+	return w.Flush()
+}
+
+func newExitCodesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:               "exit-codes",
+		Short:             "Exit codes of Vespa CLI",
+		Long:              exitCodesHelp(),
+		DisableAutoGenTag: true,
+	}
+}
+
+// exitCodesHelp describes the exit statuses of Vespa CLI, and the error codes giving each status.
+func exitCodesHelp() string {
+	var sb strings.Builder
+	sb.WriteString(`Exit codes of Vespa CLI.
+
+The exit status of Vespa CLI tells whether a failed command may succeed if it is
+run again, such that scripts can decide whether to retry it. A failed command
+whose error has an error code, see 'vespa explain', exits with the status of
+that code. Other failures exit with status 1.
+
+`)
+	const indent = "     "
+	for _, s := range exitStatuses {
+		var text strings.Builder
+		writeWrappedWidth(&text, s.description, "", explainWidth-len(indent))
+		var codes []string
+		for _, code := range sortedErrorCodes() {
+			if errorCodes[errorCode(code)].status == s.status {
+				codes = append(codes, code)
+			}
+		}
+		if len(codes) > 0 {
+			writeWrappedWidth(&text, "Error codes: "+strings.Join(codes, ", "), "", explainWidth-len(indent))
+		}
+		for i, line := range strings.Split(strings.TrimSuffix(text.String(), "\n"), "\n") {
+			prefix := indent
+			if i == 0 {
+				prefix = fmt.Sprintf("%-*d", len(indent), s.status)
+			}
+			sb.WriteString(prefix + line + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// writeWrapped writes text to w, wrapped at explainWidth. Lines following the first are prefixed by indent.
+func writeWrapped(w io.Writer, text, indent string) { writeWrappedWidth(w, text, indent, explainWidth) }
+
+// writeWrappedWidth writes text to w, wrapped at width. Lines following the first are prefixed by indent.
+func writeWrappedWidth(w io.Writer, text, indent string, width int) {
+	var line strings.Builder
+	for _, word := range strings.Fields(text) {
+		if line.Len() > 0 && line.Len()+1+len(word) > width {
+			fmt.Fprintln(w, line.String())
+			line.Reset()
+			line.WriteString(indent)
+		}
+		if line.Len() > 0 && line.String() != indent {
+			line.WriteString(" ")
+		}
+		line.WriteString(word)
+	}
+	if line.Len() > 0 {
+		fmt.Fprintln(w, line.String())
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+func TestExplain(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t)
+	require.Nil(t, cli.Run("explain", "restart_required"))
+	assert.Equal(t, `RESTART_REQUIRED: The deployment requires a restart or re-feed
+
+The application package was prepared, but not activated, because
+--require-no-restart was given and the change requires services to be restarted,
+or documents to be re-fed.
+
+Exit status: 1
+
+To resolve this:
+- Deploy without --require-no-restart to activate the change, and restart or
+  re-feed as printed
+`, stdout.String())
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("explain"))
+	assert.Contains(t, stdout.String(), "AUTH_EXPIRED                   The login session has expired\n")
+	assert.Equal(t, len(errorCodes), len(sortedErrorCodes()))
+
+	stdout.Reset()
+	require.Nil(t, cli.Run("explain", "-o", "json", "WAIT_TIMEOUT"))
+	assert.Contains(t, stdout.String(), `"code": "WAIT_TIMEOUT",`)
+	assert.Contains(t, stdout.String(), `"remediation": [`)
+	assert.Contains(t, stdout.String(), `"exitStatus": 3,`)
+
+	stdout.Reset()
+	assert.NotNil(t, cli.Run("explain", "-o", "human", "NO_SUCH_CODE"))
+	assert.Equal(t, "", stdout.String())
+	assert.Contains(t, stderr.String(), "Error: unknown error code: NO_SUCH_CODE\nHint: Available codes: APPLICATION_PACKAGE_NOT_FOUND, AUTH_EXPIRED, AUTH_FAILED, ")
+}
+
+func TestErrorCodeJSON(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	httpClient := cli.httpClient.(*mock.HTTPClient)
+	httpClient.NextResponseString(400, `{"error-code": "QUOTA_EXCEEDED", "message": "Quota exceeded"}`)
+	err := cli.Run("deploy", "-o", "json", "testdata/applications/withTarget/target/application.zip")
+	require.NotNil(t, err)
+	assert.Contains(t, stderr.String(), `"code": "QUOTA_EXCEEDED"`)
+	var cliErr ErrCLI
+	require.True(t, errors.As(err, &cliErr))
+	assert.Equal(t, codeQuotaExceeded, cliErr.code)
+}
+
+func TestInferErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code errorCode
+	}{
+		{errors.New("something else"), ""},
+		{fmt.Errorf("auth0: access token missing: %w", auth0.ErrLoginRequired), codeAuthExpired},
+		{fmt.Errorf("deployment failed: %w (status 401)", vespa.ErrUnauthorized), codeAuthFailed},
+		{vespa.AuthError("auth failed"), codeAuthFailed},
+		{fmt.Errorf("%w: run 1 failed", vespa.ErrDeployment), codeDeploymentFailed},
+		{fmt.Errorf("deployment not converged: %w", vespa.ErrWaitTimeout), codeWaitTimeout},
+		{fmt.Errorf("%w in '.'", vespa.ErrNoApplicationPackage), codeApplicationPackageNotFound},
+		{fmt.Errorf("get failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), codeEndpointUnreachable},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, codeEndpointUnreachable},
+	}
+	for i, tt := range tests {
+		assert.Equal(t, tt.code, inferErrorCode(tt.err), fmt.Sprintf("#%d: %v", i, tt.err))
+	}
+	// Codes given explicitly are kept
+	err := withErrorCode(errCode(codeConfirmationRequired, fmt.Errorf("%w", vespa.ErrDeployment)))
+	assert.Equal(t, codeConfirmationRequired, err.(ErrCLI).code)
+	assert.Equal(t, "failed [WAIT_TIMEOUT]\ndetails", codedMessage(errCode(codeWaitTimeout, errors.New("failed\ndetails"))))
+}
+
+func TestErrorExitStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{errors.New("something else"), exitError},
+		{fmt.Errorf("deployment failed: %w (status 401)", vespa.ErrUnauthorized), exitAuth},
+		{fmt.Errorf("get failed: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), exitTransient},
+		{fmt.Errorf("deployment not converged: %w", vespa.ErrWaitTimeout), exitTransient},
+		{fmt.Errorf("feed failed: %w", document.ErrTooManyErrors), exitTransient},
+		{fmt.Errorf("%w in '.'", vespa.ErrNoApplicationPackage), exitNotFound},
+		{errCode(codeDeploymentNotFound, errors.New("not found")), exitNotFound},
+		{errCode(codeRestartRequired, errors.New("restart required")), exitError},
+		// Statuses given explicitly are kept
+		{ErrCLI{Status: exitInterrupted, error: fmt.Errorf("interrupted: %w", vespa.ErrWaitTimeout)}, exitInterrupted},
+	}
+	for i, tt := range tests {
+		status := exitError
+		if cliErr, ok := withErrorCode(tt.err).(ErrCLI); ok {
+			status = cliErr.Status
+		}
+		assert.Equal(t, tt.status, status, fmt.Sprintf("#%d: %v", i, tt.err))
+	}
+	statuses := make(map[int]bool)
+	for _, s := range exitStatuses {
+		statuses[s.status] = true
+	}
+	for code, info := range errorCodes {
+		assert.True(t, statuses[info.status], "exit status of %s is documented", code)
+	}
+}
+
+func TestHelpExitCodes(t *testing.T) {
+	cli, stdout, _ := newTestCLI(t)
+	require.Nil(t, cli.Run("help", "exit-codes"))
+	assert.Contains(t, stdout.String(), "\n0    The command succeeded.\n")
+	assert.Contains(t, stdout.String(), "\n2    Authentication or authorization failed")
+	assert.Contains(t, stdout.String(), "\n     Error codes: AUTH_EXPIRED, AUTH_FAILED\n")
+	assert.Contains(t, stdout.String(), "\n130  The command was interrupted")
+}
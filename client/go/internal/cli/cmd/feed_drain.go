@@ -0,0 +1,170 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Graceful shutdown of vespa feed
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+var (
+	// errFeedStopped is returned when reading of operations stops, because the feed is shutting down
+	errFeedStopped = errors.New("feed stopped")
+	// errDrainTimeout is the cause of cancelling the requests still in flight when the drain timeout expires
+	errDrainTimeout = errors.New("drain timeout expired")
+)
+
+// feedDrain shuts down a feed gracefully when the CLI is interrupted or terminated: reading of operations stops, and
+// operations already in flight are given a timeout to complete. A second signal exits immediately.
+type feedDrain struct {
+	timeout  time.Duration
+	cli      *CLI
+	exit     func(status int)
+	signals  atomic.Int32
+	stopping chan struct{}
+	// mu is held while enqueueing an operation, such that no operation is enqueued once draining starts
+	mu sync.Mutex
+	// timedOut is whether operations in flight did not complete within the timeout
+	timedOut atomic.Bool
+
+	// input is the name of the input being read, and read the number of operations read from it, up to the last one
+	// enqueued
+	positionMu sync.Mutex
+	input      string
+	read       int64
+}
+
+// startFeedDrain makes the feed run by cli shut down gracefully on SIGINT and SIGTERM. The returned function stops the
+// handling of these signals.
+func startFeedDrain(cli *CLI, timeout time.Duration) (*feedDrain, func()) {
+	d := &feedDrain{timeout: timeout, cli: cli, exit: os.Exit, stopping: make(chan struct{})}
+	removeHandler := cli.onInterrupt(d.signal)
+	terminations := make(chan os.Signal, 1)
+	signal.Notify(terminations, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-terminations:
+				d.signal()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return d, func() {
+		signal.Stop(terminations)
+		close(done)
+		removeHandler()
+	}
+}
+
+// signal stops the feed on the first signal received, and exits on the second.
+func (d *feedDrain) signal() {
+	if d.signals.Add(1) > 1 {
+		fmt.Fprintln(d.cli.Stderr, "feed: exiting immediately")
+		d.exit(exitInterrupted)
+		return
+	}
+	fmt.Fprintf(d.cli.Stderr, "feed: stopping, waiting up to %s for operations in flight to complete. Signal again to exit immediately\n", d.timeout)
+	close(d.stopping)
+}
+
+// stopped returns whether this feed is shutting down.
+func (d *feedDrain) stopped() bool {
+	if d == nil {
+		return false
+	}
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// reading records that operations are now read from the input of given name.
+func (d *feedDrain) reading(name string) {
+	if d == nil {
+		return
+	}
+	d.positionMu.Lock()
+	defer d.positionMu.Unlock()
+	d.input = name
+	d.read = 0
+}
+
+// position describes the position in the input up to which operations were enqueued.
+func (d *feedDrain) position() string {
+	d.positionMu.Lock()
+	defer d.positionMu.Unlock()
+	name := d.input
+	if name == "" || name == "-" {
+		name = "standard input"
+	}
+	if d.read == 0 {
+		return "the start of " + name
+	}
+	return "document " + formatCount(d.read) + " of " + name
+}
+
+// enqueue enqueues doc, the operation of given number in its input, with dispatcher, unless this feed is shutting down.
+func (d *feedDrain) enqueue(dispatcher operationQueue, doc document.Document, operation int64) error {
+	if d == nil {
+		return dispatcher.Enqueue(doc)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped() {
+		doc.Reset()
+		return errFeedStopped
+	}
+	if err := dispatcher.Enqueue(doc); err != nil {
+		return err
+	}
+	d.positionMu.Lock()
+	d.read = operation
+	d.positionMu.Unlock()
+	return nil
+}
+
+// run runs enqueue until it returns, or this feed starts shutting down, and then closes dispatcher. When shutting down,
+// operations in flight are given the drain timeout to complete, before their requests are cancelled.
+func (d *feedDrain) run(dispatcher operationQueue, enqueue func() error) error {
+	enqueued := make(chan error, 1)
+	go func() { enqueued <- enqueue() }()
+	var err error
+	select {
+	case err = <-enqueued:
+		if !errors.Is(err, errFeedStopped) {
+			dispatcher.Close()
+			return err
+		}
+	case <-d.stopping:
+	}
+	closed := make(chan struct{})
+	go func() {
+		d.mu.Lock() // Wait for any operation being enqueued, and keep further operations from being enqueued
+		dispatcher.Close()
+		close(closed)
+	}()
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+	select {
+	case <-closed:
+		return ErrCLI{Status: exitInterrupted, error: fmt.Errorf("feed interrupted")}
+	case <-timer.C:
+		inflight := dispatcher.Stats().Inflight
+		d.timedOut.Store(true)
+		d.cli.cancel(errDrainTimeout)
+		return ErrCLI{Status: exitInterrupted, error: fmt.Errorf("feed interrupted: %d operations in flight did not complete within %s", inflight, d.timeout)}
+	}
+}
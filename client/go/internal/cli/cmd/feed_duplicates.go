@@ -0,0 +1,157 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Detection of duplicate document IDs in vespa feed
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// duplicatesMaxReported is the maximum number of duplicate document IDs printed by a feed.
+const duplicatesMaxReported = 100
+
+// duplicateFlags holds the flags choosing whether duplicate document IDs are detected.
+type duplicateFlags struct {
+	detect    bool
+	fail      bool
+	maxMemory string
+}
+
+func addDuplicateFlags(cmd *cobra.Command, flags *duplicateFlags) {
+	cmd.PersistentFlags().BoolVar(&flags.detect, "detect-duplicates", false, "Report operations whose document ID was already read in this feed")
+	cmd.PersistentFlags().BoolVar(&flags.fail, "fail-on-duplicates", false, "Fail on the first operation whose document ID is known to be already read in this feed. Implies --detect-duplicates")
+	cmd.PersistentFlags().StringVar(&flags.maxMemory, "duplicates-memory", "1G", "Maximum memory used to detect duplicates, optionally followed by K, M or G. "+
+		"Document IDs are kept exactly while they fit in half of this, using about 100 bytes each. Further IDs are kept in a Bloom filter, "+
+		"which reports about 1% of new IDs as possible duplicates with 1.2 bytes per ID, and 0.03% with 2.4 bytes per ID. 0 for unlimited, keeping all IDs exactly")
+}
+
+// tracker returns the tracker configured by these flags, or nil if duplicates should not be detected.
+func (f duplicateFlags) tracker(cli *CLI) (*duplicateTracker, error) {
+	if !f.detect && !f.fail {
+		return nil, nil
+	}
+	maxMemory, err := parseByteSize(f.maxMemory)
+	if err != nil {
+		return nil, errHint(fmt.Errorf("invalid duplicates memory: %s: %w", f.maxMemory, err), "Example: --duplicates-memory 4G")
+	}
+	return &duplicateTracker{cli: cli, fail: f.fail, detector: document.NewDuplicateDetector[feedPosition](maxMemory)}, nil
+}
+
+// feedPosition is the position of an operation in the input of a feed.
+type feedPosition struct {
+	// file is the index of the file holding the operation
+	file int
+	// line is where the operation starts in its file, or 0 if this is not known
+	line int64
+	// operation is the number of the operation in its file, starting at 1
+	operation int64
+}
+
+// duplicateTracker detects operations whose document ID was already read in a feed, and reports them.
+type duplicateTracker struct {
+	cli      *CLI
+	fail     bool
+	detector *document.DuplicateDetector[feedPosition]
+	// files holds the names of the files read, where standard input has an empty name
+	files []string
+
+	known    int64
+	possible int64
+}
+
+// addFile adds the file of given name, or standard input if name is empty, and returns its index.
+func (t *duplicateTracker) addFile(name string) int {
+	if t == nil {
+		return 0
+	}
+	t.files = append(t.files, name)
+	return len(t.files) - 1
+}
+
+// check checks the document ID of doc, read at pos. An operation which was skipped, because it was fed before, is only
+// recorded. An error is returned if the document ID is known to be read before, and this fails on duplicates.
+// Otherwise, the duplicate is printed.
+func (t *duplicateTracker) check(doc document.Document, pos feedPosition, skipped bool) error {
+	if t == nil {
+		return nil
+	}
+	id := doc.Id.String()
+	dup, ok := t.detector.Add(id, pos)
+	if !ok || skipped {
+		return nil
+	}
+	if !dup.Known {
+		t.possible++
+		if t.reported() {
+			fmt.Fprintf(t.cli.Stderr, "feed: possible duplicate document ID %s in %s\n", id, t.location(pos))
+		}
+		return nil
+	}
+	t.known++
+	if !t.fail && !t.reported() {
+		return nil
+	}
+	msg := fmt.Sprintf("duplicate document ID %s in %s, first read in %s", id, t.location(pos), t.location(dup.Previous))
+	if t.fail {
+		return errHint(errors.New(msg), "Use --detect-duplicates instead of --fail-on-duplicates to only report duplicates")
+	}
+	fmt.Fprintf(t.cli.Stderr, "feed: %s\n", msg)
+	return nil
+}
+
+// reported returns whether the last duplicate found is printed.
+func (t *duplicateTracker) reported() bool { return t.known+t.possible <= duplicatesMaxReported }
+
+// location returns a description of pos, naming its line where this is known.
+func (t *duplicateTracker) location(pos feedPosition) string {
+	return feedLocation(t.files[pos.file], pos)
+}
+
+// feedLocation returns a description of pos in the named file, or standard input if name is empty, naming its line
+// where this is known.
+func feedLocation(name string, pos feedPosition) string {
+	if name == "" {
+		return "standard input operation " + strconv.FormatInt(pos.operation, 10)
+	}
+	if pos.line > 0 {
+		return name + " line " + strconv.FormatInt(pos.line, 10)
+	}
+	return name + " operation " + strconv.FormatInt(pos.operation, 10)
+}
+
+// finish warns about duplicates which were not printed, and about a high rate of false positives.
+func (t *duplicateTracker) finish() {
+	if t == nil {
+		return
+	}
+	if n := t.known + t.possible - duplicatesMaxReported; n > 0 {
+		t.cli.printWarning(fmt.Sprintf("%d more duplicate document IDs not shown", n))
+	}
+	if rate := t.detector.FalsePositiveRate(); rate > 0.01 {
+		t.cli.printWarning(fmt.Sprintf("Duplicate detection ran out of memory, and reports about %.1f%% of new document IDs as possible duplicates", rate*100),
+			"Use a larger --duplicates-memory")
+	}
+}
+
+// summary returns the number of duplicates found, or nil if duplicates are not detected.
+func (t *duplicateTracker) summary() *duplicatesSummary {
+	if t == nil {
+		return nil
+	}
+	return &duplicatesSummary{DuplicateCount: t.known, PossibleDuplicateCount: t.possible}
+}
+
+// decodedLine returns the line where the operation last decoded by dec starts, or 0 if this is not known.
+func decodedLine(dec documentDecoder) int64 {
+	switch d := dec.(type) {
+	case *document.Decoder:
+		return d.LastLine()
+	case *idDecoder:
+		return d.dec.LastLine()
+	}
+	return 0
+}
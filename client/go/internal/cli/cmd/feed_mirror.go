@@ -0,0 +1,100 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// mirrorSummary holds the outcome of feeding every operation to both a primary and a mirror target.
+type mirrorSummary struct {
+	Target          string          `json:"feeder.mirror.target"`
+	PrimaryOkCount  int64           `json:"feeder.mirror.primary.ok.count"`
+	PrimaryErrCount int64           `json:"feeder.mirror.primary.error.count"`
+	OkCount         int64           `json:"feeder.mirror.ok.count"`
+	ErrorCount      int64           `json:"feeder.mirror.error.count"`
+	RequestCount    int64           `json:"feeder.mirror.http.request.count"`
+	ThrottleCount   int64           `json:"feeder.mirror.throttled.count"`
+	DivergedCount   int64           `json:"feeder.mirror.diverged.count"`
+	Diverged        []mirrorDiverge `json:"feeder.mirror.diverged,omitempty"`
+}
+
+// mirrorDiverge is an operation which succeeded on one target, but failed on the other.
+type mirrorDiverge struct {
+	Id        string `json:"id"`
+	Operation string `json:"operation"`
+	Primary   string `json:"primary"`
+	Mirror    string `json:"mirror"`
+}
+
+func newMirrorSummary(target string, stats document.MirrorStats, mirrorStats document.Stats) *mirrorSummary {
+	summary := &mirrorSummary{
+		Target:          target,
+		PrimaryOkCount:  stats.PrimarySucceeded,
+		PrimaryErrCount: stats.PrimaryFailed,
+		OkCount:         stats.MirrorSucceeded,
+		ErrorCount:      stats.MirrorFailed,
+		RequestCount:    mirrorStats.Requests,
+		ThrottleCount:   mirrorStats.Throttled,
+		DivergedCount:   stats.Diverged,
+	}
+	for _, d := range stats.Divergences {
+		summary.Diverged = append(summary.Diverged, mirrorDiverge{
+			Id:        d.Id.String(),
+			Operation: d.Operation.String(),
+			Primary:   describeResult(d.Primary),
+			Mirror:    describeResult(d.Mirror),
+		})
+	}
+	return summary
+}
+
+// describeResult returns a short description of the outcome of an operation.
+func describeResult(result document.Result) string {
+	if result.Err != nil {
+		return result.Err.Error()
+	}
+	return fmt.Sprintf("status %d", result.HTTPStatus)
+}
+
+// checkMirror warns about operations which diverged between the primary and mirror targets, and returns an error if
+// strict and any operation failed on the mirror target.
+func checkMirror(cli *CLI, stats document.MirrorStats, strict bool) error {
+	if stats.Diverged > 0 {
+		cli.printWarning(fmt.Sprintf("%d operations succeeded on one target, but failed on the other", stats.Diverged),
+			"See feeder.mirror.diverged in the summary for the operations which diverged")
+	}
+	if strict && stats.MirrorFailed > 0 {
+		return fmt.Errorf("%d operations failed on the mirror target", stats.MirrorFailed)
+	}
+	return nil
+}
+
+// prefixWriter writes to w, with prefix before each write. The prefix and the data are written together, such that
+// they are not separated by writes of others to w.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if _, err := p.w.Write(append([]byte(p.prefix), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// syncWriter serializes the writes to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
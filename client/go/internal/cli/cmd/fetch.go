@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"errors"
+	"strconv"
+
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 func newFetchCmd(cli *CLI) *cobra.Command {
+	var options vespa.FetchOptions
 	cmd := &cobra.Command{
 		Use:   "fetch [path]",
 		Short: "Download a deployed application package",
@@ -13,10 +18,22 @@ func newFetchCmd(cli *CLI) *cobra.Command {
 
 This command can be used to download an already deployed Vespa application
 package. The package is written as a ZIP file to the given path, or current
-directory if no path is given.`,
+directory if no path is given.
+
+With --extract, the package is instead extracted into the given directory. This
+can be used to compare the deployed application package with a local copy,
+e.g. using 'diff -r'.
+
+Existing files are never overwritten, and a non-empty directory is never
+extracted into, unless --force is given.
+
+The config generation (self-hosted) or build number (Vespa Cloud) of the
+fetched application package is printed when known.`,
 		Example: `$ vespa fetch
 $ vespa fetch mydir/
 $ vespa fetch -t cloud mycloudapp.zip
+$ vespa fetch --extract deployed-app/
+$ vespa fetch --extract --force deployed-app/
 `,
 		Args:              cobra.MaximumNArgs(1),
 		DisableAutoGenTag: true,
@@ -30,16 +47,32 @@ $ vespa fetch -t cloud mycloudapp.zip
 			if len(args) > 0 {
 				path = args[0]
 			}
-			dstPath := ""
+			var result vespa.FetchResult
 			if err := cli.spinner(cli.Stderr, "Downloading application package...", func() error {
-				dstPath, err = vespa.Fetch(vespa.DeploymentOptions{Target: target}, path)
+				result, err = vespa.Fetch(vespa.DeploymentOptions{Target: target}, path, options)
 				return err
 			}); err != nil {
+				if errors.Is(err, vespa.ErrDestinationExists) {
+					return errHint(err, "Use --force to overwrite existing files", "Or give a different path as argument")
+				}
 				return err
 			}
-			cli.printSuccess("Application package written to ", dstPath)
+			written := "written to "
+			if options.Extract {
+				written = "extracted to "
+			}
+			switch {
+			case result.Generation > 0:
+				cli.printSuccess("Application package at config generation ", color.CyanString(strconv.FormatInt(result.Generation, 10)), " ", written, result.Path)
+			case result.Build > 0:
+				cli.printSuccess("Application package build ", color.CyanString(strconv.FormatInt(result.Build, 10)), " ", written, result.Path)
+			default:
+				cli.printSuccess("Application package ", written, result.Path)
+			}
 			return nil
 		},
 	}
+	cmd.Flags().BoolVarP(&options.Extract, "extract", "x", false, "Extract the application package into the given directory, instead of writing a ZIP file")
+	cmd.Flags().BoolVarP(&options.Force, "force", "f", false, "Overwrite existing files")
 	return cmd
 }
@@ -0,0 +1,46 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestFetch(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+	dir := t.TempDir()
+	mockFetch(httpClient)
+	require.Nil(t, cli.Run("fetch", dir))
+	zipFile := filepath.Join(dir, "application.zip")
+	assert.Equal(t, "Success: Application package at config generation 7 written to "+zipFile+"\n", stdout.String())
+	assert.FileExists(t, zipFile)
+
+	require.NotNil(t, cli.Run("fetch", dir))
+	assert.Equal(t, "Error: refusing to overwrite: "+zipFile+" already exists\nHint: Use --force to overwrite existing files\nHint: Or give a different path as argument\n", stderr.String())
+
+	appDir := filepath.Join(dir, "app")
+	mockFetch(httpClient)
+	stdout.Reset()
+	require.Nil(t, cli.Run("fetch", "--extract", appDir))
+	assert.Equal(t, "Success: Application package at config generation 7 extracted to "+appDir+"\n", stdout.String())
+	data, err := os.ReadFile(filepath.Join(appDir, "services.xml"))
+	require.Nil(t, err)
+	assert.Equal(t, "<services/>", string(data))
+
+	mockFetch(httpClient)
+	require.Nil(t, cli.Run("fetch", "--extract", "--force", appDir))
+	assert.True(t, httpClient.Consumed())
+}
+
+func mockFetch(httpClient *mock.HTTPClient) {
+	httpClient.NextResponseString(200, `{"generation": 7}`)
+	httpClient.NextResponseString(200, `["http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content/services.xml"]`)
+	httpClient.NextResponseString(200, "<services/>")
+}
@@ -0,0 +1,191 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Checks of the fields of fed documents against the deployed schemas
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// fieldCheckFlags holds the flag choosing whether the fields of fed documents are checked.
+type fieldCheckFlags struct {
+	mode string
+}
+
+func addFieldCheckFlags(cmd *cobra.Command, flags *fieldCheckFlags) {
+	cmd.PersistentFlags().StringVar(&flags.mode, "strict-fields", "", `Check that the fields of each put and update exist in the deployed schema, with a value of a compatible JSON type. Must be "error", which fails the feed on the first mismatch, or "warn". Default is "error" when given without a value`)
+	cmd.PersistentFlags().Lookup("strict-fields").NoOptDefVal = "error"
+}
+
+// checker returns the checker configured by these flags, or nil if fields should not be checked.
+func (f fieldCheckFlags) checker(cli *CLI) (*fieldChecker, error) {
+	switch f.mode {
+	case "":
+		return nil, nil
+	case "error", "warn":
+	default:
+		return nil, errHint(fmt.Errorf("invalid value for --strict-fields: %s", f.mode), `Must be "error" or "warn"`)
+	}
+	return &fieldChecker{deployedDocumentTypes: deployedDocumentTypes{
+		cli:    cli,
+		strict: f.mode == "error",
+		check:  "fields",
+		hint:   "Use --strict-fields=warn to only warn",
+	}}, nil
+}
+
+// fieldChecker checks the fields of puts and updates against the schemas deployed to the current target. Each field
+// must be declared in the document type, and its value must be of a JSON type which Vespa accepts for the declared
+// type of the field. Only the top-level name of struct, map and tensor fields is checked, and the types of elements of
+// collections are not. The deployed schemas are fetched once, when the first operation is checked.
+type fieldChecker struct {
+	deployedDocumentTypes
+	// reported holds the mismatches already warned about, per document type
+	reported map[string]bool
+}
+
+// check checks the fields of doc. An error is returned for the first mismatch found, if this is strict. Otherwise, a
+// warning is printed the first time each mismatch is found for a document type.
+func (c *fieldChecker) check(doc document.Document) error {
+	if c == nil || doc.Body == nil || (doc.Operation != document.OperationPut && doc.Operation != document.OperationUpdate) {
+		return nil
+	}
+	docType, ok, err := c.lookup(doc.Id.Type)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil // Not deployed, so Vespa rejects the operation instead
+	}
+	var body struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(doc.Body, &body); err != nil {
+		return fmt.Errorf("invalid %s of %s: %w", doc.Operation, doc.Id, err)
+	}
+	names := make([]string, 0, len(body.Fields))
+	for name := range body.Fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		mismatch := checkField(docType, doc.Operation, name, body.Fields[name])
+		if mismatch == "" {
+			continue
+		}
+		msg := fmt.Sprintf("%s of %s: %s", doc.Operation, doc.Id, mismatch)
+		if c.strict {
+			return errHint(errors.New(msg), "Fix the field in the feed, or use --strict-fields=warn to only warn")
+		}
+		key := docType.Name + ":" + mismatch
+		if c.reported[key] {
+			continue
+		}
+		if c.reported == nil {
+			c.reported = make(map[string]bool)
+		}
+		c.reported[key] = true
+		c.cli.printWarning(strings.ToUpper(msg[:1])+msg[1:], "Further operations on document type '"+docType.Name+"' with this mismatch are not reported")
+	}
+	return nil
+}
+
+// checkField returns a description of how the field with given name and value in an operation does not match
+// docType, or an empty string if it matches.
+func checkField(docType vespa.DocumentType, op document.Operation, name string, value json.RawMessage) string {
+	partial := false
+	if op == document.OperationUpdate {
+		// Updates may address part of a field, like map{key}, array[0] or struct.field
+		if i := strings.IndexAny(name, "{[."); i > 0 {
+			name, partial = name[:i], true
+		}
+	}
+	if !docType.HasField(name) {
+		return fmt.Sprintf("document type '%s' has no field '%s'", docType.Name, name)
+	}
+	typ, ok := docType.FieldTypes[name]
+	if !ok || partial {
+		return ""
+	}
+	if op == document.OperationUpdate {
+		var update map[string]json.RawMessage
+		if json.Unmarshal(value, &update) != nil {
+			return ""
+		}
+		if value, ok = update["assign"]; !ok {
+			return "" // Other operations take values of other types than the field, such as elements
+		}
+	}
+	kind := jsonKind(value)
+	allowed := jsonKinds(typ)
+	if kind == "null" || allowed == nil || slices.Contains(allowed, kind) {
+		return ""
+	}
+	return fmt.Sprintf("field '%s' of type %s must be a JSON %s, not %s", name, typ, strings.Join(allowed, " or "), article(kind))
+}
+
+// jsonKind returns the kind of the JSON value in v: string, number, bool, array, object or null.
+func jsonKind(v json.RawMessage) string {
+	v = bytes.TrimSpace(v)
+	if len(v) == 0 {
+		return "null"
+	}
+	switch v[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// jsonKinds returns the kinds of JSON values Vespa accepts for a field of type typ, or nil if this is not known.
+func jsonKinds(typ string) []string {
+	base := typ
+	if i := strings.IndexAny(typ, "<("); i >= 0 {
+		base = typ[:i]
+	}
+	switch base {
+	case "string", "uri", "raw", "predicate", "reference":
+		return []string{"string"}
+	case "byte", "int", "long", "float", "double":
+		return []string{"number", "string"}
+	case "bool":
+		return []string{"bool", "string"}
+	case "array":
+		return []string{"array"}
+	case "weightedset", "map":
+		return []string{"object", "array"}
+	case "tensor":
+		return []string{"object", "array", "string"}
+	case "position":
+		return []string{"object", "string"}
+	case "annotationreference":
+		return nil
+	}
+	if base == typ {
+		return []string{"object"} // A struct
+	}
+	return nil
+}
+
+func article(kind string) string {
+	if kind == "array" || kind == "object" {
+		return "an " + kind
+	}
+	return "a " + kind
+}
@@ -0,0 +1,84 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func mockFieldSchemaFetch(client *mock.HTTPClient) {
+	contentURL := "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content"
+	client.NextResponseString(200, `{"generation": 3}`)
+	client.NextResponseString(200, `["`+contentURL+`/schemas/"]`)
+	client.NextResponseString(200, `["`+contentURL+`/schemas/music.sd"]`)
+	client.NextResponseString(200, `schema music {
+    document music {
+        field title type string {}
+        field year type int {}
+        field tags type array<string> {}
+        field embedding type tensor<float>(x[2]) {}
+        field info type info {}
+        struct info {
+            field label type string {}
+        }
+    }
+}`)
+}
+
+func TestFeedFieldCheck(t *testing.T) {
+	td := t.TempDir()
+	jsonFile := filepath.Join(td, "docs.jsonl")
+	require.Nil(t, os.WriteFile(jsonFile, []byte(`{"put": "id:ns:music::a", "fields": {"title": "A", "year": "2000", "tags": ["x"], "embedding": [1, 2], "info": {"label": "l"}}}
+{"put": "id:ns:music::b", "fields": {"title": "B", "genre": "rock"}}
+{"put": "id:ns:music::c", "fields": {"title": "C", "genre": "pop", "tags": "x"}}
+{"update": "id:ns:music::d", "fields": {"year": {"assign": [2000]}, "tags[0]": {"assign": "y"}, "tags": {"add": ["z"]}, "info.label": {"assign": "l"}}}
+{"update": "id:ns:music::e", "fields": {"album.name": {"assign": "l"}, "title": {"assign": null}}}
+{"remove": "id:ns:music::f"}
+`), 0644))
+
+	// Each mismatch is reported once per document type, and feeding continues when warning
+	cli, _, stderr := newTestCLI(t)
+	client := cli.httpClient.(*mock.HTTPClient)
+	queueFeedBlockCheck(client)
+	mockFieldSchemaFetch(client)
+	for range 6 {
+		client.NextResponseString(200, `{"message":"OK"}`)
+	}
+	require.Nil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--inflight", "1", "--strict-fields=warn", jsonFile))
+	assert.Equal(t, "Warning: Put of id:ns:music::b: document type 'music' has no field 'genre'\n"+
+		"Hint: Further operations on document type 'music' with this mismatch are not reported\n"+
+		"Warning: Put of id:ns:music::c: field 'tags' of type array<string> must be a JSON array, not a string\n"+
+		"Hint: Further operations on document type 'music' with this mismatch are not reported\n"+
+		"Warning: Update of id:ns:music::d: field 'year' of type int must be a JSON number or string, not an array\n"+
+		"Hint: Further operations on document type 'music' with this mismatch are not reported\n"+
+		"Warning: Update of id:ns:music::e: document type 'music' has no field 'album'\n"+
+		"Hint: Further operations on document type 'music' with this mismatch are not reported\n", stderr.String())
+	assert.Len(t, client.Requests, 11)
+
+	// Feeding stops at the first mismatch, by default
+	cli, _, stderr = newTestCLI(t)
+	client = cli.httpClient.(*mock.HTTPClient)
+	queueFeedBlockCheck(client)
+	mockFieldSchemaFetch(client)
+	assert.NotNil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--strict-fields", jsonFile))
+	assert.Equal(t, "Error: put of id:ns:music::b: document type 'music' has no field 'genre'\n"+
+		"Hint: Fix the field in the feed, or use --strict-fields=warn to only warn\n", stderr.String())
+	assert.Len(t, client.Requests, 6)
+
+	// Mismatches are invalid operations in a dry run
+	cli, stdout, stderr := newTestCLI(t)
+	client = cli.httpClient.(*mock.HTTPClient)
+	mockFieldSchemaFetch(client)
+	assert.NotNil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--dry-run", "--strict-fields", jsonFile))
+	assert.Contains(t, stdout.String(), `"feeder.invalid.count": 4`)
+	assert.Contains(t, stderr.String(), "Error: update of id:ns:music::e: document type 'music' has no field 'album'\n")
+	assert.Len(t, client.Requests, 4)
+
+	cli, _, _ = newTestCLI(t)
+	assert.NotNil(t, cli.Run("feed", "-t", "http://127.0.0.1:8080", "--strict-fields=fail", jsonFile))
+}
@@ -0,0 +1,99 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Validation of the field sets of commands reading documents
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// builtinFieldSets are the field sets which may be given without a document type.
+var builtinFieldSets = []string{"[all]", "[document]", "[id]"}
+
+const fieldSetHint = "Must be one of [all], [document] or [id], or on the form doctype:field1,field2 or doctype:[document]"
+
+// fieldSet is a field set naming the fields of a document type.
+type fieldSet struct {
+	docType string
+	// fields holds the names of the fields of the field set, or is empty if this is all document fields
+	fields []string
+}
+
+// bindFieldSetFlags binds the flags choosing the field set of a command reading documents to fieldSet and offline.
+func bindFieldSetFlags(cmd *cobra.Command, fieldSet *string, offline *bool, usage string) {
+	cmd.Flags().StringVar(fieldSet, "field-set", "", usage+`. Either "[all]", "[document]", "[id]", or on the form "doctype:field1,field2"`)
+	cmd.Flags().BoolVar(offline, "offline", false, "Do not check the document type and fields of the field set against the deployed schemas")
+}
+
+// parseFieldSet parses the field set given by s, and returns nil if it is empty, or one of the built-in field sets.
+func parseFieldSet(s string) (*fieldSet, error) {
+	if s == "" || slices.Contains(builtinFieldSets, s) {
+		return nil, nil
+	}
+	docType, list, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, errHint(fmt.Errorf("invalid field set: %s", s), fieldSetHint)
+	}
+	if docType == "" {
+		return nil, errHint(fmt.Errorf("invalid field set: %s: no document type given", s), fieldSetHint)
+	}
+	if list == "[document]" {
+		return &fieldSet{docType: docType}, nil
+	}
+	fields := strings.Split(list, ",")
+	for _, field := range fields {
+		if field == "" || strings.ContainsAny(field, "[] ") {
+			return nil, errHint(fmt.Errorf("invalid field set: %s: invalid field name '%s'", s, field), fieldSetHint)
+		}
+	}
+	return &fieldSet{docType: docType, fields: fields}, nil
+}
+
+// checkFieldSet checks that field set s is valid. Unless offline is true, the document type and fields of the field set
+// are also checked against the schemas deployed to the current target. If these schemas cannot be fetched, a warning
+// is printed, and the field set is not checked further.
+func checkFieldSet(cli *CLI, s string, offline bool) error {
+	fs, err := parseFieldSet(s)
+	if err != nil || fs == nil || offline {
+		return err
+	}
+	docTypes, err := cli.fetchDeployedDocumentTypes()
+	if err != nil {
+		cli.printWarning(fmt.Sprintf("Could not check field set %s against the deployed schemas: %s", s, err), "Use --offline to skip this check")
+		return nil
+	}
+	if len(docTypes) == 0 {
+		return nil
+	}
+	i := slices.IndexFunc(docTypes, func(d vespa.DocumentType) bool { return d.Name == fs.docType })
+	if i < 0 {
+		names := make([]string, 0, len(docTypes))
+		for _, d := range docTypes {
+			names = append(names, d.Name)
+		}
+		return errHint(fmt.Errorf("invalid field set: %s: document type '%s' is not deployed", s, fs.docType),
+			"Deployed document types: "+strings.Join(names, ", "),
+			"Use --offline to skip this check")
+	}
+	docType := docTypes[i]
+	var unknown []string
+	for _, field := range fs.fields {
+		if !docType.HasField(field) {
+			unknown = append(unknown, "'"+field+"'")
+		}
+	}
+	if len(unknown) > 0 {
+		noun := "field"
+		if len(unknown) > 1 {
+			noun = "fields"
+		}
+		return errHint(fmt.Errorf("invalid field set: %s: document type '%s' has no %s %s", s, docType.Name, noun, strings.Join(unknown, ", ")),
+			fmt.Sprintf("Fields of document type '%s': %s", docType.Name, strings.Join(docType.Fields, ", ")),
+			"Use --offline to skip this check")
+	}
+	return nil
+}
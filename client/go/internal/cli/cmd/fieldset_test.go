@@ -0,0 +1,93 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestParseFieldSet(t *testing.T) {
+	for _, s := range []string{"", "[all]", "[document]", "[id]"} {
+		fs, err := parseFieldSet(s)
+		assert.Nil(t, err)
+		assert.Nil(t, fs)
+	}
+	fs, err := parseFieldSet("music:[document]")
+	require.Nil(t, err)
+	assert.Equal(t, &fieldSet{docType: "music"}, fs)
+	fs, err = parseFieldSet("music:title,artist")
+	require.Nil(t, err)
+	assert.Equal(t, &fieldSet{docType: "music", fields: []string{"title", "artist"}}, fs)
+	for _, s := range []string{"[none]", "title", ":title", "music:", "music:title,", "music:[all]"} {
+		_, err := parseFieldSet(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestCheckFieldSet(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+
+	mockSchemaFetch(client)
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--field-set", "music:title,artst,yeer", "id:ns:music::a"))
+	assert.Equal(t, "Error: invalid field set: music:title,artst,yeer: document type 'music' has no fields 'artst', 'yeer'\n"+
+		"Hint: Fields of document type 'music': artist, title, year\n"+
+		"Hint: Use --offline to skip this check\n", stderr.String())
+	assert.True(t, client.Consumed())
+	assert.Len(t, client.Requests, 4)
+
+	mockSchemaFetch(client)
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--field-set", "lyrics:[document]"))
+	assert.Equal(t, "Error: invalid field set: lyrics:[document]: document type 'lyrics' is not deployed\n"+
+		"Hint: Deployed document types: music\n"+
+		"Hint: Use --offline to skip this check\n", stderr.String())
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--field-set", "[none]"))
+	assert.Equal(t, "Error: invalid field set: [none]\n"+
+		"Hint: Must be one of [all], [document] or [id], or on the form doctype:field1,field2 or doctype:[document]\n", stderr.String())
+	assert.Len(t, client.Requests, 8)
+
+	mockSchemaFetch(client)
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "A"}}`)
+	stderr.Reset()
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--field-set", "music:title", "id:ns:music::a"))
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "music:title", client.LastRequest.URL.Query().Get("fieldSet"))
+	assert.Contains(t, stdout.String(), `"title": "A"`)
+
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {}}`)
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--field-set", "music:titel", "--offline", "id:ns:music::a"))
+	assert.Equal(t, "music:titel", client.LastRequest.URL.Query().Get("fieldSet"))
+
+	client.NextResponseString(404, `{"error-code": "NOT_FOUND"}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {}}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--field-set", "music:titel", "id:ns:music::a"))
+	assert.Contains(t, stderr.String(), "Warning: Could not check field set music:titel against the deployed schemas: no application package is deployed\n"+
+		"Hint: Use --offline to skip this check\n")
+	assert.True(t, client.Consumed())
+}
+
+func mockSchemaFetch(client *mock.HTTPClient) {
+	contentURL := "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content"
+	client.NextResponseString(200, `{"generation": 3}`)
+	client.NextResponseString(200, `["`+contentURL+`/schemas/"]`)
+	client.NextResponseString(200, `["`+contentURL+`/schemas/music.sd"]`)
+	client.NextResponseString(200, `schema music {
+    document music {
+        field title type string {}
+        field artist type string {}
+        field year type int {}
+    }
+    field title_length type int {
+        indexing: input title | to_int | attribute
+    }
+}`)
+}
@@ -3,27 +3,82 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 )
 
 func newGendocCmd(cli *CLI) *cobra.Command {
-	return &cobra.Command{
-		Use:               "gendoc directory",
-		Short:             "Generate documentation from '--help' pages and write as Markdown files to a given directory",
-		Args:              cobra.ExactArgs(1),
+	var (
+		format string
+		output string
+	)
+	cmd := &cobra.Command{
+		Use:   "gendoc [directory]",
+		Short: "Generate documentation from '--help' pages and write as Markdown files or man pages to a given directory",
+		Long: `Generate documentation from '--help' pages and write as Markdown files or man pages to a given directory.
+
+One page is written for each command, including its subcommands, with the
+flags of the command and the flags it inherits from its parents. The output
+does not depend on when it is generated, except for the date in the header of
+man pages, which is read from the SOURCE_DATE_EPOCH environment variable when
+set.
+`,
+		Example: `$ vespa gendoc --format markdown --output docs
+$ vespa gendoc --format man --output share/man/man1`,
+		Args:              cobra.MaximumNArgs(1),
 		Hidden:            true, // Not intended to be called by users
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := args[0]
-			err := doc.GenMarkdownTree(cli.cmd, dir)
-			if err != nil {
-				return fmt.Errorf("failed to write documentation pages: %w", err)
+			dir := output
+			if len(args) > 0 {
+				if output != "" && output != args[0] {
+					return fmt.Errorf("cannot write to both %s and --output %s", args[0], output)
+				}
+				dir = args[0]
+			}
+			if dir == "" {
+				return errHint(fmt.Errorf("no output directory given"), "Example: vespa gendoc --output docs")
+			}
+			if err := generateDocs(cli.cmd, format, dir); err != nil {
+				return err
 			}
 			cli.printSuccess("Documentation pages written to ", dir)
 			return nil
 		},
 	}
+	cmd.Flags().StringVar(&format, "format", "markdown", `The format of the pages. Must be "markdown" or "man"`)
+	cmd.Flags().StringVar(&output, "output", "", "The directory to write the pages to")
+	return cmd
+}
+
+// generateDocs writes documentation of root and all its subcommands to dir, in given format.
+func generateDocs(root *cobra.Command, format, dir string) error {
+	if format != "markdown" && format != "man" {
+		return errHint(fmt.Errorf("invalid format: %q", format), `Must be "markdown" or "man"`)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	disableAutoGenTag(root)
+	var err error
+	if format == "man" {
+		err = doc.GenManTree(root, nil, dir)
+	} else {
+		err = doc.GenMarkdownTree(root, dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write documentation pages: %w", err)
+	}
+	return nil
+}
+
+// disableAutoGenTag removes the generation timestamp from the documentation of cmd and all its subcommands.
+func disableAutoGenTag(cmd *cobra.Command) {
+	cmd.DisableAutoGenTag = true
+	for _, c := range cmd.Commands() {
+		disableAutoGenTag(c)
+	}
 }
@@ -0,0 +1,50 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGendoc(t *testing.T) {
+	dir := t.TempDir()
+	cli, stdout, _ := newTestCLI(t)
+	assert.Nil(t, cli.Run("gendoc", "--format", "markdown", "--output", dir))
+	assert.Equal(t, "Success: Documentation pages written to "+dir+"\n", stdout.String())
+	query, err := os.ReadFile(filepath.Join(dir, "vespa_query.md"))
+	require.Nil(t, err)
+	assert.Contains(t, string(query), "## vespa query")
+	assert.Contains(t, string(query), "--format string") // Flag of the command
+	assert.Contains(t, string(query), "### Options inherited from parent commands")
+	assert.Contains(t, string(query), "-t, --target string") // Flag inherited from the root
+	assert.NotContains(t, string(query), "Auto generated by spf13/cobra")
+	_, err = os.Stat(filepath.Join(dir, "vespa_document_put.md"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "vespa_gendoc.md"))
+	assert.True(t, os.IsNotExist(err)) // Hidden commands are not documented
+
+	// Output is deterministic
+	otherDir := t.TempDir()
+	cli, _, _ = newTestCLI(t)
+	assert.Nil(t, cli.Run("gendoc", otherDir))
+	otherQuery, err := os.ReadFile(filepath.Join(otherDir, "vespa_query.md"))
+	require.Nil(t, err)
+	assert.Equal(t, string(query), string(otherQuery))
+
+	manDir := t.TempDir()
+	cli, _, _ = newTestCLI(t, "SOURCE_DATE_EPOCH=0")
+	assert.Nil(t, cli.Run("gendoc", "--format", "man", "--output", manDir))
+	feed, err := os.ReadFile(filepath.Join(manDir, "vespa-feed.1"))
+	require.Nil(t, err)
+	assert.Contains(t, string(feed), `\fB--drain-timeout\fP`)
+	assert.Contains(t, string(feed), "OPTIONS INHERITED FROM PARENT COMMANDS")
+	assert.Contains(t, string(feed), `\fB--target\fP`)
+
+	cli, _, stderr := newTestCLI(t)
+	assert.NotNil(t, cli.Run("gendoc", "--format", "html", "--output", dir))
+	assert.Equal(t, "Error: invalid format: \"html\"\nHint: Must be \"markdown\" or \"man\"\n", stderr.String())
+}
@@ -0,0 +1,237 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Detection of the container running a local Vespa instance
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+const (
+	// localProbeTimeout is the time to wait for a connection to the config server of a local target.
+	localProbeTimeout = 300 * time.Millisecond
+	// containerRuntimeTimeout is the time to wait for a container runtime to list its containers.
+	containerRuntimeTimeout = 500 * time.Millisecond
+	// configServerPort is the port of the config server inside a Vespa container.
+	configServerPort = 19071
+)
+
+// localDetector detects whether the config server of a local target is reachable, and which containers may run it.
+type localDetector interface {
+	// Reachable returns whether a TCP connection to address can be opened.
+	Reachable(address string) bool
+	// Containers returns the name of the container runtime on this host, and its containers. The name is empty if no
+	// container runtime is found.
+	Containers() (string, []localContainer, error)
+}
+
+// localContainer is a container of a container runtime.
+type localContainer struct {
+	Name    string
+	Image   string
+	Running bool
+	// Ports maps the ports of the container to the host ports they are published on.
+	Ports map[int]int
+}
+
+// isVespa returns whether this container publishes the config server port, or runs a Vespa image.
+func (c localContainer) isVespa() bool {
+	_, ok := c.Ports[configServerPort]
+	return ok || strings.Contains(c.Image, "vespaengine/vespa")
+}
+
+// socketDetector probes for local config servers over TCP, and queries the Docker compatible API of Docker or Podman
+// through its socket.
+type socketDetector struct {
+	environment map[string]string
+}
+
+func (d *socketDetector) Reachable(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, localProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// sockets returns the candidate sockets of container runtimes, and the name of each runtime.
+func (d *socketDetector) sockets() [][2]string {
+	var sockets [][2]string
+	for _, env := range []string{"DOCKER_HOST", "CONTAINER_HOST"} {
+		if path, ok := strings.CutPrefix(d.environment[env], "unix://"); ok {
+			runtime := "docker"
+			if env == "CONTAINER_HOST" || strings.Contains(path, "podman") {
+				runtime = "podman"
+			}
+			sockets = append(sockets, [2]string{path, runtime})
+		}
+	}
+	sockets = append(sockets, [2]string{"/var/run/docker.sock", "docker"})
+	if home, err := os.UserHomeDir(); err == nil {
+		sockets = append(sockets, [2]string{filepath.Join(home, ".docker", "run", "docker.sock"), "docker"})
+	}
+	if dir := d.environment["XDG_RUNTIME_DIR"]; dir != "" {
+		sockets = append(sockets, [2]string{filepath.Join(dir, "podman", "podman.sock"), "podman"})
+	}
+	sockets = append(sockets, [2]string{"/run/podman/podman.sock", "podman"})
+	return sockets
+}
+
+func (d *socketDetector) Containers() (string, []localContainer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), containerRuntimeTimeout)
+	defer cancel()
+	for _, socket := range d.sockets() {
+		path, runtime := socket[0], socket[1]
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		containers, err := listContainers(ctx, path)
+		if err != nil {
+			return runtime, nil, err
+		}
+		return runtime, containers, nil
+	}
+	return "", nil, nil
+}
+
+// listContainers lists all containers of the container runtime listening on the socket at path.
+func listContainers(ctx context.Context, path string) ([]localContainer, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://localhost/containers/json?all=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status %d from %s", response.StatusCode, path)
+	}
+	var entries []struct {
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+		State string   `json:"State"`
+		Ports []struct {
+			PrivatePort int    `json:"PrivatePort"`
+			PublicPort  int    `json:"PublicPort"`
+			Type        string `json:"Type"`
+		} `json:"Ports"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	containers := make([]localContainer, 0, len(entries))
+	for _, entry := range entries {
+		c := localContainer{Image: entry.Image, Running: entry.State == "running", Ports: make(map[int]int)}
+		if len(entry.Names) > 0 {
+			c.Name = strings.TrimPrefix(entry.Names[0], "/")
+		}
+		for _, port := range entry.Ports {
+			if port.PublicPort > 0 && (port.Type == "" || port.Type == "tcp") {
+				c.Ports[port.PrivatePort] = port.PublicPort
+			}
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// bindNoDetectFlag binds the flag disabling detection of the config server of a local target to value.
+func bindNoDetectFlag(cmd *cobra.Command, value *bool) {
+	cmd.Flags().BoolVar(value, "no-detect", false, "Do not check that the config server of a local target is reachable, nor look for a container running it")
+}
+
+// detectLocalTarget checks that the config server of local target t is reachable. If it is not, the containers of any
+// container runtime on this host are inspected: if a single running container publishes the config server port on
+// another host port, t is changed to use the ports published by that container. Otherwise, an error with hints on how
+// to start a Vespa container is returned.
+func detectLocalTarget(cli *CLI, t vespa.Target) error {
+	portMapTarget, ok := t.(vespa.PortMapTarget)
+	if !ok || t.Type() != vespa.TargetLocal {
+		return nil
+	}
+	deployService, err := t.DeployService()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(deployService.BaseURL)
+	if err != nil {
+		return err
+	}
+	if cli.localDetector.Reachable(u.Host) {
+		return nil
+	}
+	runtime, containers, err := cli.localDetector.Containers()
+	unreachable := fmt.Errorf("no config server is reachable at %s", deployService.BaseURL)
+	noDetectHint := "Use --no-detect to skip this check"
+	if err != nil {
+		return errHint(unreachable, fmt.Sprintf("Could not list containers of %s: %s", runtime, err), noDetectHint)
+	}
+	if runtime == "" {
+		runtime = "docker"
+	}
+	var running, unpublished, stopped []localContainer
+	for _, c := range containers {
+		if !c.isVespa() {
+			continue
+		}
+		_, published := c.Ports[configServerPort]
+		switch {
+		case c.Running && published:
+			running = append(running, c)
+		case c.Running:
+			unpublished = append(unpublished, c)
+		default:
+			stopped = append(stopped, c)
+		}
+	}
+	switch {
+	case len(running) == 1:
+		c := running[0]
+		hostPort := c.Ports[configServerPort]
+		if hostPort == configServerPort {
+			cli.printInfo("Container ", color.CyanString(c.Name), " publishes port ", configServerPort, ", but its config server is not yet reachable")
+			return nil
+		}
+		portMapTarget.SetPortMap(c.Ports)
+		cli.printInfo("Using config server of container ", color.CyanString(c.Name), " published on port ", hostPort)
+		return nil
+	case len(running) > 1:
+		hints := make([]string, 0, len(running)+1)
+		for _, c := range running {
+			hints = append(hints, fmt.Sprintf("Container %s publishes the config server on port %d. Select it with 'vespa config set target http://127.0.0.1:%d'", c.Name, c.Ports[configServerPort], c.Ports[configServerPort]))
+		}
+		sort.Strings(hints)
+		return errHint(fmt.Errorf("%s: found %d containers publishing port %d", unreachable, len(running), configServerPort), hints...)
+	case len(unpublished) > 0:
+		return errHint(unreachable, fmt.Sprintf("Container %s does not publish port %d. Recreate it with '--publish 127.0.0.1:%d:%d'", unpublished[0].Name, configServerPort, configServerPort, configServerPort), noDetectHint)
+	case len(stopped) > 0:
+		return errHint(unreachable, fmt.Sprintf("Container %s is not running. Start it with '%s start %s'", stopped[0].Name, runtime, stopped[0].Name), noDetectHint)
+	}
+	return errHint(unreachable,
+		"Start a Vespa container with '"+runtime+" run --detach --name vespa --hostname vespa-container --publish 127.0.0.1:8080:8080 --publish 127.0.0.1:"+strconv.Itoa(configServerPort)+":"+strconv.Itoa(configServerPort)+" vespaengine/vespa'",
+		"See https://docs.vespa.ai/en/vespa-quick-start.html",
+		noDetectHint)
+}
@@ -0,0 +1,132 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+type mockLocalDetector struct {
+	unreachable bool
+	runtime     string
+	containers  []localContainer
+	listed      int
+}
+
+func (d *mockLocalDetector) Reachable(address string) bool { return !d.unreachable }
+
+func (d *mockLocalDetector) Containers() (string, []localContainer, error) {
+	d.listed++
+	return d.runtime, d.containers, nil
+}
+
+func TestDetectLocalTarget(t *testing.T) {
+	run := func(detector *mockLocalDetector, args ...string) (*mock.HTTPClient, string, string, error) {
+		cli, stdout, stderr := newTestCLI(t)
+		client := &mock.HTTPClient{}
+		client.NextStatus(200)
+		cli.httpClient = client
+		cli.localDetector = detector
+		err := cli.Run(append([]string{"status", "deploy"}, args...)...)
+		return client, stdout.String(), stderr.String(), err
+	}
+
+	// Config server is published on another port
+	detector := &mockLocalDetector{unreachable: true, runtime: "podman", containers: []localContainer{
+		{Name: "other", Image: "nginx", Running: true, Ports: map[int]int{80: 8080}},
+		{Name: "vespa", Image: "docker.io/vespaengine/vespa:latest", Running: true, Ports: map[int]int{8080: 48080, 19071: 49071}},
+	}}
+	client, stdout, stderr, err := run(detector)
+	require.Nil(t, err)
+	assert.Equal(t, "http://127.0.0.1:49071/status.html", client.LastRequest.URL.String())
+	assert.Equal(t, "Deploy API at http://127.0.0.1:49071 is ready\n", stdout)
+	assert.Equal(t, "Using config server of container vespa published on port 49071\n", stderr)
+
+	// No container is found
+	detector = &mockLocalDetector{unreachable: true}
+	_, _, stderr, err = run(detector)
+	require.NotNil(t, err)
+	assert.Equal(t, "Error: no config server is reachable at http://127.0.0.1:19071\n"+
+		"Hint: Start a Vespa container with 'docker run --detach --name vespa --hostname vespa-container --publish 127.0.0.1:8080:8080 --publish 127.0.0.1:19071:19071 vespaengine/vespa'\n"+
+		"Hint: See https://docs.vespa.ai/en/vespa-quick-start.html\n"+
+		"Hint: Use --no-detect to skip this check\n", stderr)
+
+	// Container is stopped
+	detector = &mockLocalDetector{unreachable: true, runtime: "podman", containers: []localContainer{{Name: "vespa", Image: "vespaengine/vespa"}}}
+	_, _, stderr, err = run(detector)
+	require.NotNil(t, err)
+	assert.Equal(t, "Error: no config server is reachable at http://127.0.0.1:19071\n"+
+		"Hint: Container vespa is not running. Start it with 'podman start vespa'\n"+
+		"Hint: Use --no-detect to skip this check\n", stderr)
+
+	// Several containers publish the config server
+	detector = &mockLocalDetector{unreachable: true, runtime: "docker", containers: []localContainer{
+		{Name: "vespa2", Image: "vespaengine/vespa", Running: true, Ports: map[int]int{19071: 29071}},
+		{Name: "vespa1", Image: "vespaengine/vespa", Running: true, Ports: map[int]int{19071: 39071}},
+	}}
+	_, _, stderr, err = run(detector)
+	require.NotNil(t, err)
+	assert.Equal(t, "Error: no config server is reachable at http://127.0.0.1:19071: found 2 containers publishing port 19071\n"+
+		"Hint: Container vespa1 publishes the config server on port 39071. Select it with 'vespa config set target http://127.0.0.1:39071'\n"+
+		"Hint: Container vespa2 publishes the config server on port 29071. Select it with 'vespa config set target http://127.0.0.1:29071'\n", stderr)
+
+	// Detection is disabled
+	detector = &mockLocalDetector{unreachable: true}
+	client, _, _, err = run(detector, "--no-detect")
+	require.Nil(t, err)
+	assert.Equal(t, 0, detector.listed)
+	assert.Equal(t, "http://127.0.0.1:19071/status.html", client.LastRequest.URL.String())
+
+	// Custom targets are not detected
+	detector = &mockLocalDetector{unreachable: true}
+	_, _, _, err = run(detector, "-t", "http://127.0.0.1:19071")
+	require.Nil(t, err)
+	assert.Equal(t, 0, detector.listed)
+}
+
+func TestSocketDetector(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	require.Nil(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/json", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("all"))
+		w.Write([]byte(`[
+  {"Names": ["/vespa"], "Image": "vespaengine/vespa", "State": "running", "Ports": [
+    {"IP": "127.0.0.1", "PrivatePort": 19071, "PublicPort": 49071, "Type": "tcp"},
+    {"PrivatePort": 19092, "Type": "tcp"},
+    {"IP": "127.0.0.1", "PrivatePort": 8080, "PublicPort": 48080, "Type": "tcp"}
+  ]},
+  {"Names": ["/old"], "Image": "vespaengine/vespa:8", "State": "exited", "Ports": []}
+]`))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	detector := &socketDetector{environment: map[string]string{"DOCKER_HOST": "unix://" + socket}}
+	runtime, containers, err := detector.Containers()
+	require.Nil(t, err)
+	assert.Equal(t, "docker", runtime)
+	assert.Equal(t, []localContainer{
+		{Name: "vespa", Image: "vespaengine/vespa", Running: true, Ports: map[int]int{19071: 49071, 8080: 48080}},
+		{Name: "old", Image: "vespaengine/vespa:8", Ports: map[int]int{}},
+	}, containers)
+
+	// Unresponsive runtimes time out quickly
+	unresponsive, err := net.Listen("unix", filepath.Join(t.TempDir(), "podman.sock"))
+	require.Nil(t, err)
+	defer unresponsive.Close()
+	detector = &socketDetector{environment: map[string]string{"CONTAINER_HOST": "unix://" + unresponsive.Addr().String()}}
+	start := time.Now()
+	runtime, _, err = detector.Containers()
+	assert.NotNil(t, err)
+	assert.Equal(t, "podman", runtime)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -2,10 +2,19 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/version"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
@@ -14,9 +23,21 @@ func newLogCmd(cli *CLI) *cobra.Command {
 	var (
 		fromArg    string
 		toArg      string
+		sinceArg   string
 		levelArg   string
 		followArg  bool
 		dequoteArg bool
+		hosts      []string
+		services   []string
+		grepArg    string
+		ignoreCase bool
+		invert     bool
+		outputFile string
+		maxSize    string
+		fileFormat string
+		format     string
+		allZones   bool
+		failOnArg  string
 	)
 	cmd := &cobra.Command{
 		Use:   "log [relative-period]",
@@ -26,30 +47,161 @@ func newLogCmd(cli *CLI) *cobra.Command {
 The logs shown can be limited to a relative or fixed period. All timestamps are shown in UTC.
 
 Logs for the past hour are shown if no arguments are given.
+
+With --follow, new log entries are printed as they appear, starting 5 minutes
+ago unless --since is given. If the connection to the log service is lost, the
+command reconnects automatically, resuming after the last entry printed. Notes
+on lost and re-established connections are printed to standard error.
+
+The --since flag accepts either a duration relative to now, e.g. 15m, or a
+timestamp in RFC3339 format.
+
+Entries can be filtered by host with --host, by service with --service, and by
+message with --grep. These filters are combined with the level filter. The
+regular expression given to --grep is matched against the dequoted message.
+When any filter is given, the number of entries matching them out of those
+fetched is printed to standard error at exit, unless following logs.
+
+With --fail-on, the command fails with exit status 5 if any entry shown is at
+or above the given level, which lets scripts check for e.g. errors logged after
+a deployment. The number of such entries is included in the line printed at
+exit. With --quiet, only these entries are printed. --fail-on cannot be combined
+with --follow.
+
+With --output-file, the entries shown are also written to the given file, as
+text or, with --output-format json, as one JSON object per line. With
+--max-file-size, the file is rotated before it would exceed the given size: the
+file is renamed to FILE.1 after any previously rotated files are renamed to
+FILE.2, FILE.3 and so on, and a new file is started.
+
+With --format json, entries are printed as one JSON object per line, with the
+timestamp in RFC 3339 format with nanoseconds, and the host, service,
+component, level and message of the entry.
+
+The logs of several zones of a Vespa Cloud application are shown by repeating
+-z, or with --all-zones for all zones the instance is deployed in. Entries of
+all zones are merged in timestamp order, with the zone of each entry shown. When
+following logs, entries are held back for a few seconds to order them among
+entries from the other zones.
 `,
 		Example: `$ vespa log 1h
 $ vespa log --nldequote=false 10m
 $ vespa log --from 2021-08-25T15:00:00Z --to 2021-08-26T02:00:00Z
-$ vespa log --follow`,
+$ vespa log --since 15m
+$ vespa log --follow
+$ vespa log --follow --since 2021-08-25T15:00:00Z
+$ vespa log --follow --service container --host host1
+$ vespa log --grep 'query.*timed out' --ignore-case
+$ vespa log --service searchnode --grep 'debug' --invert
+$ vespa log --follow --output-file vespa.log --max-file-size 100M
+$ vespa log --follow --output-file vespa.jsonl --output-format json
+$ vespa log --format json 10m
+$ vespa log --follow -z dev.aws-us-east-1c -z perf.aws-us-east-1c
+$ vespa log --all-zones --level warning 1h
+$ vespa log --since 5m --service container --fail-on error`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			if allZones && cli.zones.given > 0 {
+				return fmt.Errorf("--all-zones cannot be combined with --%s", zoneFlag)
+			}
 			target, err := cli.target(targetOptions{logLevel: levelArg})
 			if err != nil {
 				return err
 			}
+			var zones []string
+			if allZones {
+				if zones, err = deployedZones(target); err != nil {
+					return err
+				}
+			} else if cli.zones.given > 1 {
+				zones = cli.zones.zones
+			}
+			if len(zones) > 0 && !target.IsCloud() {
+				return fmt.Errorf("logs of several zones are only supported by cloud targets")
+			}
 			options := vespa.LogOptions{
-				Level:   vespa.LogLevel(levelArg),
-				Follow:  followArg,
-				Writer:  cli.Stdout,
-				Dequote: dequoteArg,
+				Level:     vespa.LogLevel(levelArg),
+				Follow:    followArg,
+				Writer:    cli.Stdout,
+				JSON:      format == "json",
+				ErrWriter: cli.Stderr,
+				Dequote:   dequoteArg,
+				Filter:    vespa.LogFilter{Hosts: hosts, Services: services, Invert: invert},
+				Stats:     &vespa.LogStats{},
+			}
+			if grepArg != "" {
+				expr := grepArg
+				if ignoreCase {
+					expr = "(?i)" + expr
+				}
+				pattern, err := regexp.Compile(expr)
+				if err != nil {
+					return fmt.Errorf("invalid --grep: %w", err)
+				}
+				options.Filter.Pattern = pattern
+			} else if ignoreCase || invert {
+				return fmt.Errorf("--ignore-case and --invert require --grep")
+			}
+			if fileFormat != "text" && fileFormat != "json" {
+				return fmt.Errorf("invalid --output-format: %s: must be 'text' or 'json'", fileFormat)
 			}
-			if options.Follow {
+			if outputFile != "" {
+				maxFileSize, err := parseByteSize(maxSize)
+				if err != nil {
+					return fmt.Errorf("invalid --max-file-size: %w", err)
+				}
+				file, err := openLogFile(outputFile, maxFileSize, fileFormat == "json", dequoteArg)
+				if err != nil {
+					return fmt.Errorf("could not open log file: %w", err)
+				}
+				defer file.Close()
+				options.EntryWriter = file
+			} else if maxSize != "" || cmd.Flags().Changed("output-format") {
+				return fmt.Errorf("--max-file-size and --output-format require --output-file")
+			}
+			var failOn *logLevelCounter
+			if failOnArg != "" {
+				if options.Follow {
+					return fmt.Errorf("--fail-on cannot be combined with --follow")
+				}
+				if !slices.Contains(logLevels, failOnArg) {
+					return fmt.Errorf("invalid --fail-on: %s: must be %s", failOnArg, strings.Join(logLevels, ", "))
+				}
+				if vespa.LogLevel(failOnArg) > options.Level {
+					return fmt.Errorf("--fail-on %s includes entries not shown with --level %s", failOnArg, levelArg)
+				}
+				failOn = &logLevelCounter{level: vespa.LogLevel(failOnArg), next: options.EntryWriter}
+				if cli.config.isQuiet() {
+					failOn.writer = options.Writer
+					failOn.json = options.JSON
+					failOn.dequote = options.Dequote
+					options.Writer = nil
+				}
+				options.EntryWriter = failOn
+			}
+			if sinceArg != "" {
+				if fromArg != "" || toArg != "" || len(args) > 0 {
+					return fmt.Errorf("cannot combine --since with --from/--to or relative time")
+				}
+				since, err := parseSince(sinceArg, cli.now())
+				if err != nil {
+					return fmt.Errorf("invalid --since: %w", err)
+				}
+				options.From = since
+				if !options.Follow {
+					options.To = cli.now()
+				}
+			} else if options.Follow {
 				if fromArg != "" || toArg != "" || len(args) > 0 {
 					return fmt.Errorf("cannot combine --from/--to or relative time with --follow")
 				}
-				options.From = time.Now().Add(-5 * time.Minute)
+				options.From = cli.now().Add(-5 * time.Minute)
 			} else {
 				from, to, err := parsePeriod(fromArg, toArg, args)
 				if err != nil {
@@ -58,7 +210,12 @@ $ vespa log --follow`,
 				options.From = from
 				options.To = to
 			}
-			if err := target.PrintLog(options); err != nil {
+			if len(zones) > 0 {
+				err = printZoneLogs(cli, zones, levelArg, options)
+			} else {
+				err = target.PrintLog(options)
+			}
+			if err != nil {
 				versionWithLogContainer := version.MustParse("8.359.0")
 				var hints []string
 				if err := target.CompatibleWith(versionWithLogContainer); err != nil {
@@ -66,17 +223,243 @@ $ vespa log --follow`,
 				}
 				return errHint(fmt.Errorf("could not retrieve logs: %w", err), hints...)
 			}
+			if len(hosts) > 0 || len(services) > 0 || grepArg != "" || failOn != nil {
+				summary := fmt.Sprintf("%d of %d fetched log entries matched", options.Stats.Matched, options.Stats.Fetched)
+				if failOn != nil {
+					summary += fmt.Sprintf(", %d at level %s or above", failOn.count, failOnArg)
+					if failOn.count > 0 {
+						return errCode(codeLogEntriesFound, errors.New(summary))
+					}
+				}
+				cli.printInfo(summary)
+			}
 			return nil
 		},
 	}
 	cmd.Flags().StringVarP(&fromArg, "from", "F", "", "Include logs since this timestamp (RFC3339 format)")
 	cmd.Flags().StringVarP(&toArg, "to", "T", "", "Include logs until this timestamp (RFC3339 format)")
+	cmd.Flags().StringVarP(&sinceArg, "since", "", "", "Include logs since this duration ago, e.g. 15m, or this timestamp (RFC3339 format)")
 	cmd.Flags().StringVarP(&levelArg, "level", "l", "debug", `The maximum log level to show. Must be "error", "warning", "info" or "debug"`)
 	cmd.Flags().BoolVarP(&followArg, "follow", "f", false, "Follow logs")
 	cmd.Flags().BoolVarP(&dequoteArg, "nldequote", "n", true, "Dequote LF and TAB characters in log messages")
+	cmd.Flags().StringSliceVar(&hosts, "host", nil, "Show only logs from these comma-separated hosts. A host matches by full name, or its first label")
+	cmd.Flags().StringSliceVar(&services, "service", nil, "Show only logs from these comma-separated services, e.g. container or searchnode")
+	cmd.Flags().StringVar(&grepArg, "grep", "", "Show only logs whose message matches this regular expression")
+	cmd.Flags().BoolVar(&ignoreCase, "ignore-case", false, "Match --grep case-insensitively")
+	cmd.Flags().BoolVar(&invert, "invert", false, "Show only logs whose message does not match --grep")
+	cmd.Flags().StringVar(&outputFile, "output-file", "", "Also write logs to this file")
+	cmd.Flags().StringVar(&maxSize, "max-file-size", "", "Rotate the file given by --output-file before it exceeds this size in bytes, optionally followed by K, M or G")
+	cmd.Flags().StringVar(&fileFormat, "output-format", "text", "Format of logs written to --output-file. Must be 'text' or 'json'")
+	cmd.Flags().StringVar(&format, "format", "human", "Output format. Must be 'human' (human-readable) or 'json'")
+	cmd.Flags().BoolVar(&allZones, "all-zones", false, "Show logs of all zones the instance is deployed in (cloud only)")
+	cmd.Flags().StringVar(&failOnArg, "fail-on", "", `Fail if any entry shown is at or above this log level. Must be "fatal", "error", "warning", "info" or "debug"`)
 	return cmd
 }
 
+// logLevels holds the names of the log levels, from the most to the least severe.
+var logLevels = []string{"fatal", "error", "warning", "info", "debug"}
+
+// logLevelCounter counts the log entries at or above a level, and optionally prints them to writer, before passing
+// all entries on to next.
+type logLevelCounter struct {
+	level   int
+	count   int
+	writer  io.Writer
+	json    bool
+	dequote bool
+	next    vespa.LogEntryWriter
+}
+
+func (c *logLevelCounter) WriteEntry(entry vespa.LogEntry) error {
+	if vespa.LogLevel(entry.Level) <= c.level {
+		c.count++
+		if c.writer != nil {
+			line, err := entry.FormatAs(c.json, c.dequote)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(c.writer, line)
+		}
+	}
+	if c.next != nil {
+		return c.next.WriteEntry(entry)
+	}
+	return nil
+}
+
+// logMergeDelay is how long entries are held back when following the logs of several zones, to order them among
+// entries from the other zones.
+const logMergeDelay = 3 * time.Second
+
+// deployedZones returns the zones the instance of target is deployed in.
+func deployedZones(target vespa.Target) ([]string, error) {
+	if !target.IsCloud() {
+		return nil, fmt.Errorf("--all-zones is only supported by cloud targets")
+	}
+	deployed, err := vespa.DeployedZones(target)
+	if err != nil {
+		return nil, fmt.Errorf("could not list deployments of %s: %w", target.Deployment().Application, err)
+	}
+	if len(deployed) == 0 {
+		return nil, fmt.Errorf("%s is not deployed in any zone", target.Deployment().Application)
+	}
+	zones := make([]string, len(deployed))
+	for i, zone := range deployed {
+		zones[i] = zone.String()
+	}
+	return zones, nil
+}
+
+// printZoneLogs prints the logs of the deployments in zones, merged in timestamp order, as given by options.
+func printZoneLogs(cli *CLI, zones []string, logLevel string, options vespa.LogOptions) error {
+	merger := &logMerger{delay: logMergeDelay, now: cli.now, write: func(entry vespa.LogEntry) error {
+		line, err := entry.FormatAs(options.JSON, options.Dequote)
+		if err != nil {
+			return err
+		}
+		if options.Writer != nil {
+			fmt.Fprintln(options.Writer, line)
+		}
+		if options.EntryWriter != nil {
+			return options.EntryWriter.WriteEntry(entry)
+		}
+		return nil
+	}}
+	zoneOptions := make([]vespa.LogOptions, len(zones))
+	targets := make([]vespa.Target, len(zones))
+	for i, zone := range zones {
+		target, err := cli.target(targetOptions{logLevel: logLevel, zone: zone})
+		if err != nil {
+			return err
+		}
+		targets[i] = target
+		zoneOptions[i] = options
+		zoneOptions[i].Writer = nil
+		zoneOptions[i].Stats = &vespa.LogStats{}
+		zoneOptions[i].EntryWriter = zoneLogWriter{zone: zone, merger: merger}
+	}
+	defer func() {
+		for _, o := range zoneOptions {
+			options.Stats.Fetched += o.Stats.Fetched
+			options.Stats.Matched += o.Stats.Matched
+		}
+	}()
+	if !options.Follow {
+		for i, target := range targets {
+			if err := target.PrintLog(zoneOptions[i]); err != nil {
+				return fmt.Errorf("zone %s: %w", zones[i], err)
+			}
+		}
+		return merger.flush(true)
+	}
+	errs := make(chan error, len(zones))
+	for i, target := range targets {
+		go func() {
+			if err := target.PrintLog(zoneOptions[i]); err != nil {
+				errs <- fmt.Errorf("zone %s: %w", zones[i], err)
+			} else {
+				errs <- nil
+			}
+		}()
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for running := len(zones); ; {
+		select {
+		case err := <-errs:
+			running--
+			if err == nil && running > 0 {
+				continue
+			}
+			if flushErr := merger.flush(true); flushErr != nil {
+				return flushErr
+			}
+			return err
+		case <-ticker.C:
+			if err := merger.flush(false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// zoneLogWriter passes the log entries of a zone on to a logMerger.
+type zoneLogWriter struct {
+	zone   string
+	merger *logMerger
+}
+
+func (w zoneLogWriter) WriteEntry(entry vespa.LogEntry) error {
+	entry.Zone = w.zone
+	w.merger.add(entry)
+	return nil
+}
+
+// logMerger merges the log entries of several zones in timestamp order. Each entry is held back for up to delay after
+// it is added, such that entries added later, but with earlier timestamps, can be written before it.
+type logMerger struct {
+	delay time.Duration
+	now   func() time.Time
+	write func(entry vespa.LogEntry) error
+
+	mu      sync.Mutex
+	pending []pendingLogEntry
+}
+
+type pendingLogEntry struct {
+	entry vespa.LogEntry
+	added time.Time
+}
+
+func (m *logMerger) add(entry vespa.LogEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(m.pending, pendingLogEntry{entry: entry, added: m.now()})
+}
+
+// flush writes, in timestamp order, the entries which have been held back for delay, and any entries with earlier
+// timestamps. If all is true, all entries are written.
+func (m *logMerger) flush(all bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.SliceStable(m.pending, func(i, j int) bool { return m.pending[i].entry.Time.Before(m.pending[j].entry.Time) })
+	n := 0
+	if all {
+		n = len(m.pending)
+	} else {
+		heldSince := m.now().Add(-m.delay)
+		for i, p := range m.pending {
+			if !p.added.After(heldSince) {
+				n = i + 1
+			}
+		}
+	}
+	written := 0
+	defer func() { m.pending = m.pending[written:] }()
+	for _, p := range m.pending[:n] {
+		if err := m.write(p.entry); err != nil {
+			return err
+		}
+		written++
+	}
+	return nil
+}
+
+// parseSince parses s as either a duration before now, or an absolute timestamp.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d > 0 {
+			d = -d
+		}
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration nor a timestamp in RFC3339 format", s)
+	}
+	return t, nil
+}
+
 func parsePeriod(from, to string, args []string) (time.Time, time.Time, error) {
 	relativePeriod := from == "" || to == ""
 	if relativePeriod {
@@ -110,3 +493,88 @@ func parsePeriod(from, to string, args []string) (time.Time, time.Time, error) {
 	}
 	return t1, t2, nil
 }
+
+// logFile writes log entries to a file. When writing the next entry would make the file exceed maxSize bytes, the file
+// is synced and renamed to path.1, after shifting any existing path.1, path.2, ... to path.2, path.3, ..., and a new
+// file is started.
+type logFile struct {
+	path    string
+	maxSize int64
+	json    bool
+	dequote bool
+
+	file *os.File
+	size int64
+}
+
+// openLogFile opens the file at path for appending entries, creating it if necessary.
+func openLogFile(path string, maxSize int64, json, dequote bool) (*logFile, error) {
+	f := &logFile{path: path, maxSize: maxSize, json: json, dequote: dequote}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *logFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *logFile) WriteEntry(entry vespa.LogEntry) error {
+	line, err := entry.FormatAs(f.json, f.dequote)
+	if err != nil {
+		return err
+	}
+	line += "\n"
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.WriteString(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *logFile) rotate() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	last := 1
+	for ioutil.Exists(fmt.Sprintf("%s.%d", f.path, last)) {
+		last++
+	}
+	for i := last; i > 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", f.path, i-1), fmt.Sprintf("%s.%d", f.path, i)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close syncs and closes the current file.
+func (f *logFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
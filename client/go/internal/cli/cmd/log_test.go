@@ -2,11 +2,18 @@
 package cmd
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 	"github.com/vespa-engine/vespa/client/go/internal/version"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 func TestLogCloud(t *testing.T) {
@@ -30,6 +37,172 @@ func TestLogCloud(t *testing.T) {
 	assert.Contains(t, stderr.String(), "Error: invalid period: cannot combine --from/--to with relative value: 1h\n")
 }
 
+func TestLogCloudSince(t *testing.T) {
+	_, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	httpClient := &mock.HTTPClient{}
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = httpClient
+	cli.now = func() time.Time { return time.Date(2021, 9, 27, 11, 0, 0, 0, time.UTC) }
+
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+
+	assert.Nil(t, cli.Run("log", "--since", "15m"))
+	assert.Equal(t, "from=1632739500000&to=1632740400000", httpClient.LastRequest.URL.RawQuery)
+	assert.Nil(t, cli.Run("log", "--since", "2021-09-27T10:00:00Z"))
+	assert.Equal(t, "from=1632736800000&to=1632740400000", httpClient.LastRequest.URL.RawQuery)
+
+	assert.NotNil(t, cli.Run("log", "--since", "yesterday"))
+	assert.Contains(t, stderr.String(), `Error: invalid --since: "yesterday" is neither a duration nor a timestamp in RFC3339 format`)
+	assert.NotNil(t, cli.Run("log", "--since", "15m", "1h"))
+	assert.Contains(t, stderr.String(), "Error: cannot combine --since with --from/--to or relative time\n")
+}
+
+func TestLogCloudFilter(t *testing.T) {
+	_, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true")
+	cli.httpClient = httpClient
+
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+
+	logs := `1632738690.100000	host1.example.com	1/1	container	com.example.Handler	info	Query timed out
+1632738690.200000	host2.example.com	1/1	container	com.example.Handler	warning	QUERY TIMED OUT\nafter 10s
+1632738690.300000	host1.example.com	1/1	searchnode	proton	info	Query timed out
+1632738690.400000	host1.example.com	1/1	container	com.example.Handler	info	Feed succeeded
+`
+	httpClient.NextResponseString(200, logs)
+	stdout.Reset()
+	stderr.Reset()
+	assert.Nil(t, cli.Run("log", "--service", "container", "--grep", "timed out\nafter", "--ignore-case", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	assert.Equal(t, "[2021-09-27 10:31:30.200000] host2.example.com warning container        com.example.Handler\tQUERY TIMED OUT\nafter 10s\n", stdout.String())
+	assert.Equal(t, "1 of 4 fetched log entries matched\n", stderr.String())
+
+	httpClient.NextResponseString(200, logs)
+	stdout.Reset()
+	stderr.Reset()
+	assert.Nil(t, cli.Run("log", "--service", "", "--host", "host1", "--grep", "timed out", "--ignore-case=false", "--invert", "--level", "info"))
+	assert.Contains(t, stdout.String(), "Feed succeeded")
+	assert.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+	assert.Equal(t, "1 of 4 fetched log entries matched\n", stderr.String())
+
+	assert.NotNil(t, cli.Run("log", "--grep", "("))
+	assert.Contains(t, stderr.String(), "Error: invalid --grep: error parsing regexp")
+	assert.NotNil(t, cli.Run("log", "--grep", "", "--invert"))
+	assert.Contains(t, stderr.String(), "Error: --ignore-case and --invert require --grep\n")
+}
+
+func TestLogFailOn(t *testing.T) {
+	logs := `1632740200.100000	host1	1/1	container	com.example.Handler	info	Started
+1632740200.200000	host1	1/1	container	com.example.Handler	error	Failed to load model
+1632740200.300000	host2	1/1	searchnode	proton	warning	Low memory
+1632740200.400000	host2	1/1	container	com.example.Handler	error	Query failed
+`
+	httpClient := &mock.HTTPClient{}
+	newCLI := func() (*CLI, *bytes.Buffer, *bytes.Buffer) {
+		cli, stdout, stderr := newTestCLI(t)
+		cli.httpClient = httpClient
+		cli.now = func() time.Time { return time.Date(2021, 9, 27, 11, 0, 0, 0, time.UTC) }
+		return cli, stdout, stderr
+	}
+	cli, stdout, stderr := newCLI()
+
+	httpClient.NextResponseString(200, logs)
+	err := cli.Run("log", "--since", "5m", "--fail-on", "error")
+	require.NotNil(t, err)
+	assert.Equal(t, exitLogEntries, err.(ErrCLI).Status)
+	assert.Equal(t, 4, strings.Count(stdout.String(), "\n"))
+	assert.Equal(t, "Error: 4 of 4 fetched log entries matched, 2 at level error or above [LOG_ENTRIES_FOUND]\n", stderr.String())
+
+	// Only the offending entries are printed when quiet
+	httpClient.NextResponseString(200, logs)
+	cli, stdout, stderr = newCLI()
+	require.NotNil(t, cli.Run("log", "--since", "5m", "--fail-on", "warning", "--service", "searchnode", "-q"))
+	assert.Equal(t, "[2021-09-27 10:56:40.300000] host2    warning searchnode       proton\tLow memory\n", stdout.String())
+	assert.Equal(t, "Error: 1 of 4 fetched log entries matched, 1 at level warning or above [LOG_ENTRIES_FOUND]\n", stderr.String())
+
+	httpClient.NextResponseString(200, logs)
+	cli, stdout, stderr = newCLI()
+	require.NotNil(t, cli.Run("log", "--since", "5m", "--fail-on", "error", "--service", "container", "--host", "host1", "-q"))
+	assert.Equal(t, "[2021-09-27 10:56:40.200000] host1    error   container        com.example.Handler\tFailed to load model\n", stdout.String())
+
+	httpClient.NextResponseString(200, logs)
+	cli, stdout, stderr = newCLI()
+	require.Nil(t, cli.Run("log", "--since", "5m", "--fail-on", "error", "--service", "searchnode"))
+	assert.Contains(t, stdout.String(), "Low memory")
+	assert.Equal(t, "1 of 4 fetched log entries matched, 0 at level error or above\n", stderr.String())
+
+	// Fatal entries are more severe than errors
+	httpClient.NextResponseString(200, "1632740200.500000\thost3\t1/1\tsearchnode\tproton\tfatal\tOut of disk\n")
+	cli, stdout, stderr = newCLI()
+	require.NotNil(t, cli.Run("log", "--since", "5m", "--fail-on", "error", "-q"))
+	assert.Equal(t, "[2021-09-27 10:56:40.500000] host3    fatal   searchnode       proton\tOut of disk\n", stdout.String())
+	assert.Equal(t, "Error: 1 of 1 fetched log entries matched, 1 at level error or above [LOG_ENTRIES_FOUND]\n", stderr.String())
+	httpClient.NextResponseString(200, logs)
+	cli, _, stderr = newCLI()
+	require.Nil(t, cli.Run("log", "--since", "5m", "--fail-on", "fatal"))
+	assert.Equal(t, "4 of 4 fetched log entries matched, 0 at level fatal or above\n", stderr.String())
+
+	assert.NotNil(t, cli.Run("log", "--follow", "--fail-on", "error"))
+	assert.Contains(t, stderr.String(), "Error: --fail-on cannot be combined with --follow\n")
+	cli, _, stderr = newCLI()
+	assert.NotNil(t, cli.Run("log", "--fail-on", "critical"))
+	assert.Contains(t, stderr.String(), "Error: invalid --fail-on: critical: must be fatal, error, warning, info, debug\n")
+	cli, _, stderr = newCLI()
+	assert.NotNil(t, cli.Run("log", "--fail-on", "info", "--level", "warning"))
+	assert.Contains(t, stderr.String(), "Error: --fail-on info includes entries not shown with --level warning\n")
+}
+
+func TestLogOutputFile(t *testing.T) {
+	_, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true")
+	cli.httpClient = httpClient
+
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+
+	logs := `1632738690.100000	host1	1/1	container	Handler	info	first
+1632738690.200000	host1	1/1	container	Handler	info	second\nline
+1632738690.300000	host1	1/1	container	Handler	info	third
+`
+	line := func(ts, msg string) string {
+		return "[2021-09-27 10:31:30." + ts + "] host1    info    container        Handler\t" + msg + "\n"
+	}
+	logFile := filepath.Join(t.TempDir(), "vespa.log")
+	require.Nil(t, os.WriteFile(logFile+".1", []byte("old\n"), 0644))
+	httpClient.NextResponseString(200, logs)
+	stdout.Reset()
+	assert.Nil(t, cli.Run("log", "--output-file", logFile, "--max-file-size", "100", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	assert.Equal(t, line("100000", "first")+line("200000", "second\nline")+line("300000", "third"), stdout.String())
+	assert.Equal(t, line("300000", "third"), readFileString(t, logFile))
+	assert.Equal(t, line("200000", "second\nline"), readFileString(t, logFile+".1"))
+	assert.Equal(t, line("100000", "first"), readFileString(t, logFile+".2"))
+	assert.Equal(t, "old\n", readFileString(t, logFile+".3"))
+
+	jsonFile := filepath.Join(t.TempDir(), "vespa.jsonl")
+	httpClient.NextResponseString(200, logs)
+	assert.Nil(t, cli.Run("log", "--output-file", jsonFile, "--output-format", "json", "--max-file-size", "", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	assert.Equal(t, `{"time":"2021-09-27T10:31:30.1Z","host":"host1","pid":"1/1","service":"container","component":"Handler","level":"info","message":"first"}
+{"time":"2021-09-27T10:31:30.2Z","host":"host1","pid":"1/1","service":"container","component":"Handler","level":"info","message":"second\nline"}
+{"time":"2021-09-27T10:31:30.3Z","host":"host1","pid":"1/1","service":"container","component":"Handler","level":"info","message":"third"}
+`, readFileString(t, jsonFile))
+
+	assert.NotNil(t, cli.Run("log", "--output-format", "xml"))
+	assert.Contains(t, stderr.String(), "Error: invalid --output-format: xml: must be 'text' or 'json'\n")
+	assert.NotNil(t, cli.Run("log", "--output-file", "", "--output-format", "text", "--max-file-size", "1M"))
+	assert.Contains(t, stderr.String(), "Error: --max-file-size and --output-format require --output-file\n")
+	assert.NotNil(t, cli.Run("log", "--output-file", logFile, "--max-file-size", "-1"))
+	assert.Contains(t, stderr.String(), "Error: invalid --max-file-size: must be a non-negative number of bytes")
+}
+
 func TestLogCloudIncompatible(t *testing.T) {
 	cli, _, stderr := newTestCLI(t)
 	cli.version = version.MustParse("7.0.0")
@@ -79,3 +252,94 @@ func TestLogLocalIncompatible(t *testing.T) {
 Hint: This command requires a newer version of the Vespa platform: platform version is older than required version: 8.358.0 < 8.359.0
 `, stderr.String())
 }
+
+func TestLogCloudFormatJSON(t *testing.T) {
+	_, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = httpClient
+
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+
+	httpClient.NextResponseString(200, "1632738690.905535\thost1a.dev.aws-us-east-1c\t806/53\tcontainer\tcom.example.Handler\tinfo\tQuery timed out\n")
+	stdout.Reset()
+	assert.Nil(t, cli.Run("log", "--format", "json", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	assert.Equal(t, `{"time":"2021-09-27T10:31:30.905535Z","host":"host1a.dev.aws-us-east-1c","pid":"806/53","service":"container","component":"com.example.Handler","level":"info","message":"Query timed out"}`+"\n", stdout.String())
+
+	assert.NotNil(t, cli.Run("log", "--format", "xml", "1h"))
+	assert.Contains(t, stderr.String(), "Error: invalid format: xml\n")
+}
+
+func TestLogCloudZones(t *testing.T) {
+	_, pkgDir := mock.ApplicationPackageDir(t, false, false)
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = httpClient
+
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+
+	httpClient.NextResponseString(200, "1632738690.100000\thost1\t1/1\tcontainer\tcom.example.Handler\tinfo\tdev 1\n1632738690.300000\thost1\t1/1\tcontainer\tcom.example.Handler\tinfo\tdev 2\n")
+	httpClient.NextResponseString(200, "1632738690.200000\thost2\t1/1\tcontainer\tcom.example.Handler\twarning\tperf 1\n")
+	stdout.Reset()
+	assert.Nil(t, cli.Run("log", "-z", "dev.aws-us-east-1c", "-z", "perf.aws-us-east-1c", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	expected := "[2021-09-27 10:31:30.100000] dev.aws-us-east-1c host1    info    container        com.example.Handler\tdev 1\n" +
+		"[2021-09-27 10:31:30.200000] perf.aws-us-east-1c host2    warning container        com.example.Handler\tperf 1\n" +
+		"[2021-09-27 10:31:30.300000] dev.aws-us-east-1c host1    info    container        com.example.Handler\tdev 2\n"
+	assert.Equal(t, expected, stdout.String())
+	assert.Equal(t, 2, len(httpClient.Requests))
+	assert.Contains(t, httpClient.Requests[0].URL.Path, "/environment/dev/region/aws-us-east-1c/")
+	assert.Contains(t, httpClient.Requests[1].URL.Path, "/environment/perf/region/aws-us-east-1c/")
+
+	httpClient.NextResponseString(200, `{"deployments":[{"environment":"prod","region":"aws-us-east-1c"},{"environment":"dev","region":"aws-us-east-1c"}]}`)
+	httpClient.NextResponseString(200, "1632738690.300000\thost1\t1/1\tcontainer\tcom.example.Handler\tinfo\tdev\n")
+	httpClient.NextResponseString(200, "1632738690.200000\thost2\t1/1\tcontainer\tcom.example.Handler\tinfo\tprod\n")
+	stdout.Reset()
+	assert.Nil(t, cli.Run("log", "--all-zones", "--format", "json", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"))
+	expected = `{"time":"2021-09-27T10:31:30.2Z","host":"host2","pid":"1/1","service":"container","component":"com.example.Handler","level":"info","message":"prod","zone":"prod.aws-us-east-1c"}` + "\n" +
+		`{"time":"2021-09-27T10:31:30.3Z","host":"host1","pid":"1/1","service":"container","component":"com.example.Handler","level":"info","message":"dev","zone":"dev.aws-us-east-1c"}` + "\n"
+	assert.Equal(t, expected, stdout.String())
+
+	assert.NotNil(t, cli.Run("log", "--all-zones", "-z", "dev.aws-us-east-1c", "1h"))
+	assert.Contains(t, stderr.String(), "Error: --all-zones cannot be combined with --zone\n")
+
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("status", "-z", "dev.aws-us-east-1c", "-z", "perf.aws-us-east-1c"))
+	assert.Contains(t, stderr.String(), "Error: --zone may only be given once for vespa status\n")
+}
+
+func TestLogMerger(t *testing.T) {
+	now := time.Date(2021, 9, 27, 11, 0, 0, 0, time.UTC)
+	var written []string
+	merger := &logMerger{delay: 3 * time.Second, now: func() time.Time { return now }, write: func(entry vespa.LogEntry) error {
+		written = append(written, entry.Message)
+		return nil
+	}}
+	entry := func(second int, message string) vespa.LogEntry {
+		return vespa.LogEntry{Time: time.Date(2021, 9, 27, 10, 0, second, 0, time.UTC), Message: message}
+	}
+	merger.add(entry(2, "b"))
+	now = now.Add(2 * time.Second)
+	merger.add(entry(1, "a"))
+	merger.add(entry(4, "d"))
+	require.Nil(t, merger.flush(false))
+	assert.Empty(t, written)
+
+	now = now.Add(time.Second)
+	merger.add(entry(3, "c"))
+	require.Nil(t, merger.flush(false))
+	assert.Equal(t, []string{"a", "b"}, written)
+
+	now = now.Add(2 * time.Second)
+	require.Nil(t, merger.flush(false))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, written)
+
+	merger.add(entry(5, "e"))
+	require.Nil(t, merger.flush(true))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, written)
+}
@@ -2,23 +2,32 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
+	"github.com/fatih/color"
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/cli/auth"
 	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
 )
 
+// loginProgressInterval is the minimum interval between messages telling that login is still pending.
+var loginProgressInterval = 30 * time.Second
+
 // newLoginCmd runs the login flow guiding the user through the process
 // by showing the login instructions, opening the browser.
 // Use `expired` to run the login from other commands setup:
 // this will only affect the messages.
 func newLoginCmd(cli *CLI) *cobra.Command {
-	var useFileStorage bool
+	var (
+		useFileStorage bool
+		noBrowser      bool
+		timeoutSecs    int
+	)
 	cmd := &cobra.Command{
 		Use:   "login",
 		Args:  cobra.NoArgs,
@@ -27,22 +36,47 @@ func newLoginCmd(cli *CLI) *cobra.Command {
 
 This command runs a browser-based authentication flow for the Vespa Cloud control plane.
 
+Credentials are stored per system, keyed by its API URL, so that logins to several systems can
+be held at once. The credentials of the system of the current target are used automatically. To
+log in to a system with its own authentication endpoints, set VESPA_CLI_CLOUD_SYSTEM to its API
+URL. Use "vespa auth list" to see all stored logins.
+
+Use --no-browser flag to skip opening a browser on this machine, e.g. when logged in over SSH or
+working in a container. The confirmation URL and code are then printed, and the URL can be opened
+in a browser on any other device. Login is also done without a browser if the terminal is not
+interactive.
+
+Use --timeout flag to limit how long to wait for login to complete. By default the command waits
+until the confirmation code expires.
+
 Use --file-storage flag to store the refresh token in unencrypted files instead of the system keyring.
-This is useful in SSH/CI/Docker environments where keyring access may not be available.
+This is useful in SSH/CI/Docker environments where keyring access may not be available. This cannot
+be used with the keychain credential store, see "vespa help config".
 `,
-		Example:           "$ vespa auth login",
+		Example: `$ vespa auth login
+$ vespa auth login --no-browser --timeout 300`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return doLogin(cli, cmd, useFileStorage)
+			return doLogin(cli, cmd, useFileStorage, noBrowser, time.Duration(timeoutSecs)*time.Second)
 		},
 	}
 	cmd.Flags().BoolVar(&useFileStorage, "file-storage", false, "Use file storage (unencrypted) instead of keyring for storing refresh token")
+	cmd.Flags().BoolVar(&noBrowser, "no-browser", false, "Do not open a browser. Print the confirmation URL and code instead")
+	cmd.Flags().IntVar(&timeoutSecs, "timeout", 0, "Number of seconds to wait for login to complete. 0 to wait until the confirmation code expires")
 	return cmd
 }
 
-func doLogin(cli *CLI, cmd *cobra.Command, useFileStorage bool) error {
+func doLogin(cli *CLI, cmd *cobra.Command, useFileStorage, noBrowser bool, timeout time.Duration) error {
 	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	targetType, err := cli.targetType(cloudTargetOnly)
 	if err != nil {
 		return err
@@ -51,39 +85,30 @@ func doLogin(cli *CLI, cmd *cobra.Command, useFileStorage bool) error {
 	if err != nil {
 		return err
 	}
-	a, err := auth0.NewClient(cli.httpClient, auth0.Options{ConfigPath: cli.config.authConfigPath(), SystemName: system.Name, SystemURL: system.URL})
-	if err != nil {
-		return err
+	keychain := cli.config.credentialStore() == credentialStoreKeychain
+	if keychain && useFileStorage {
+		return errHint(fmt.Errorf("cannot store the refresh token in files with credential store %s", credentialStoreKeychain),
+			"Omit --file-storage, or run 'vespa config set credential-store file' to use files")
 	}
-	state, err := a.Authenticator.Start(ctx)
-	if err != nil {
-		return fmt.Errorf("could not start the authentication process: %w", err)
-	}
-
-	log.Printf("Your Device Confirmation code is: %s\n", state.UserCode)
-
-	autoOpen, err := cli.confirm("Automatically open confirmation page in your default browser?", true)
+	a, err := auth0.NewClient(cli.httpClient, cli.auth0Options(system))
 	if err != nil {
 		return err
 	}
-
-	if autoOpen {
-		log.Printf("Opened link in your browser: %s\n", state.VerificationURI)
-		err = browser.OpenURL(state.VerificationURI)
-		if err != nil {
-			log.Println("Couldn't open the URL, please do it manually")
+	var res auth.Result
+	for {
+		res, err = waitForLogin(ctx, cli, a.Authenticator, noBrowser)
+		if !errors.Is(err, auth.ErrExpiredToken) {
+			break
+		}
+		restart, confirmErr := cli.confirm("The confirmation code expired before login completed. Start a new login?", true)
+		if confirmErr != nil || !restart {
+			return errHint(fmt.Errorf("login failed: the confirmation code expired"), "Run 'vespa auth login' to try again")
 		}
-	} else {
-		log.Printf("Please open link in your browser: %s\n", state.VerificationURI)
 	}
-
-	var res auth.Result
-	err = cli.spinner(os.Stderr, "Waiting for login to complete in browser ...", func() error {
-		res, err = a.Authenticator.Wait(ctx, state)
-		return err
-	})
-
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return errHint(fmt.Errorf("login did not complete within %s", timeout), "Increase the timeout with --timeout")
+		}
 		switch err.Error() {
 		case "600":
 			return errHint(fmt.Errorf("Your organization require SSO for Vespa Cloud access"),
@@ -95,11 +120,14 @@ func doLogin(cli *CLI, cmd *cobra.Command, useFileStorage bool) error {
 
 	// store the refresh token
 	secretsStore := auth.NewKeyringWithOptions(useFileStorage)
-	err = secretsStore.Set(auth.SecretsNamespace, system.Name, res.RefreshToken)
+	if keychain {
+		secretsStore = cli.refreshTokenStore()
+	}
+	err = secretsStore.Set(auth.SecretsNamespace, a.RefreshTokenKey(), res.RefreshToken)
 	if err != nil {
 		// log the error but move on
 		cli.printWarning("Could not store the refresh token locally. You may need to login again once your access token expires (30 minutes).")
-		if !useFileStorage {
+		if !useFileStorage && !keychain {
 			cli.printWarning("To persist the refresh token using file storage (unencrypted), use --file-storage flag")
 			cli.printWarning("Note: Storing the refresh token unencrypted directly on your file system means someone with access to this file can get unauthorized access to your application for the life of the refresh token (24 hours)")
 		}
@@ -117,3 +145,49 @@ func doLogin(cli *CLI, cmd *cobra.Command, useFileStorage bool) error {
 	cli.printSuccess("Logged in")
 	return nil
 }
+
+// waitForLogin starts the device authorization flow and waits for the user to complete it.
+func waitForLogin(ctx context.Context, cli *CLI, authenticator *auth.Authenticator, noBrowser bool) (auth.Result, error) {
+	state, err := authenticator.Start(ctx)
+	if err != nil {
+		return auth.Result{}, fmt.Errorf("could not start the authentication process: %w", err)
+	}
+	if noBrowser || !cli.isTerminal() {
+		log.Print("To log in, open this link in a browser on any device:\n")
+		log.Printf("    %s\n", color.CyanString(state.VerificationURI))
+		log.Printf("Verify that the confirmation code shown is: %s\n", color.CyanString(state.UserCode))
+	} else {
+		log.Printf("Your Device Confirmation code is: %s\n", state.UserCode)
+		autoOpen, err := cli.confirm("Automatically open confirmation page in your default browser?", true)
+		if err != nil {
+			return auth.Result{}, err
+		}
+		if autoOpen {
+			log.Printf("Opened link in your browser: %s\n", state.VerificationURI)
+			if err := browser.OpenURL(state.VerificationURI); err != nil {
+				log.Println("Couldn't open the URL, please do it manually")
+			}
+		} else {
+			log.Printf("Please open link in your browser: %s\n", state.VerificationURI)
+		}
+	}
+	started := time.Now()
+	expiresAt := started.Add(time.Duration(state.ExpiresIn) * time.Second)
+	if deadline, ok := ctx.Deadline(); ok && (state.ExpiresIn == 0 || deadline.Before(expiresAt)) {
+		expiresAt = deadline
+	}
+	lastProgress := started
+	cli.printInfo("Waiting for login to complete ...")
+	return authenticator.Wait(ctx, state, func() {
+		now := time.Now()
+		if now.Sub(lastProgress) < loginProgressInterval {
+			return
+		}
+		lastProgress = now
+		if remaining := expiresAt.Sub(now); remaining > 0 {
+			cli.printInfo("Still waiting for login to complete, ", remaining.Round(time.Second), " remaining ...")
+		} else {
+			cli.printInfo("Still waiting for login to complete ...")
+		}
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestLoginNoBrowser(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // File storage of refresh token is relative to home directory
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	httpClient := &mock.HTTPClient{}
+	cli.httpClient = httpClient
+
+	flowConfig := `{"audience":"https://example.com/api/v2/","client-id":"some-id","device-code-endpoint":"https://example.com/oauth/device/code","oauth-token-endpoint":"https://example.com/oauth/token"}`
+	deviceCode := `{"device_code":"dc1","user_code":"ABCD-EFGH","verification_uri_complete":"https://example.com/activate?user_code=ABCD-EFGH","expires_in":900,"interval":0}`
+
+	// Confirmation code expires and login is restarted
+	httpClient.NextResponseString(200, flowConfig)
+	httpClient.NextResponseString(200, deviceCode)
+	httpClient.NextResponseString(400, `{"error":"expired_token","error_description":"Token expired"}`)
+	httpClient.NextResponseString(200, deviceCode)
+	httpClient.NextResponseString(200, `{"access_token":"at","refresh_token":"rt","expires_in":3600}`)
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = bytes.NewBufferString("y\n")
+	stdout.Reset()
+	require.Nil(t, cli.Run("auth", "login", "--no-browser", "--file-storage"))
+	instructions := "To log in, open this link in a browser on any device:\n" +
+		"    https://example.com/activate?user_code=ABCD-EFGH\n" +
+		"Verify that the confirmation code shown is: ABCD-EFGH\n"
+	assert.Equal(t, instructions+
+		"The confirmation code expired before login completed. Start a new login? [Y/n] "+
+		instructions+
+		"Success: Logged in\n", stdout.String())
+	assert.Equal(t, "Waiting for login to complete ...\nWaiting for login to complete ...\n", stderr.String())
+	assert.Equal(t, "https://example.com/oauth/token", httpClient.LastRequest.URL.String())
+	_, err := os.Stat(filepath.Join(cli.config.homeDir, "auth.json"))
+	assert.Nil(t, err)
+
+	// Timeout is reached before login completes, without interactive prompts
+	httpClient.NextResponseString(200, flowConfig)
+	httpClient.NextResponseString(200, deviceCode)
+	cli.isTerminal = func() bool { return false }
+	stderr.Reset()
+	require.NotNil(t, cli.Run("auth", "login", "--timeout", "1"))
+	assert.Equal(t, "Waiting for login to complete ...\n"+
+		"Error: login did not complete within 1s\n"+
+		"Hint: Increase the timeout with --timeout\n", stderr.String())
+}
@@ -2,36 +2,59 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/cli/auth/auth0"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
 
 func newLogoutCmd(cli *CLI) *cobra.Command {
-	return &cobra.Command{
-		Use:               "logout",
-		Args:              cobra.NoArgs,
-		Short:             "Sign out of Vespa Cloud",
-		Example:           "$ vespa auth logout",
+	var systemFlag string
+	cmd := &cobra.Command{
+		Use:   "logout",
+		Args:  cobra.NoArgs,
+		Short: "Sign out of Vespa Cloud",
+		Long: `Sign out of Vespa Cloud.
+
+This removes the credentials stored by "auth login" for the system of the
+current target. Use --system to sign out of another system, given by its API
+URL or name, as shown by "auth list". Credentials of other systems are kept.
+`,
+		Example: `$ vespa auth logout
+$ vespa auth logout --system https://api-ctl.vespa-cloud.com:4443`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			targetType, err := cli.targetType(cloudTargetOnly)
-			if err != nil {
-				return err
-			}
-			system, err := cli.system(targetType.name)
-			if err != nil {
-				return err
-			}
-			a, err := auth0.NewClient(cli.httpClient, auth0.Options{ConfigPath: cli.config.authConfigPath(), SystemName: system.Name, SystemURL: system.URL})
-			if err != nil {
-				return err
+			var system vespa.System
+			if systemFlag != "" {
+				var err error
+				system, err = vespa.GetSystem(systemFlag)
+				if err != nil {
+					return errHint(err, "Run 'vespa auth list' to see the systems with stored credentials")
+				}
+				if _, ok, err := auth0.ReadCredentials(cli.config.authConfigPath(), system.Name, system.URL); err != nil {
+					return err
+				} else if !ok {
+					return errHint(fmt.Errorf("not logged in to system %s", system.URL), "Run 'vespa auth list' to see the systems with stored credentials")
+				}
+			} else {
+				targetType, err := cli.targetType(cloudTargetOnly)
+				if err != nil {
+					return err
+				}
+				system, err = cli.system(targetType.name)
+				if err != nil {
+					return err
+				}
 			}
-			if err := a.RemoveCredentials(); err != nil {
+			if err := auth0.RemoveCredentials(cli.auth0Options(system)); err != nil {
 				return err
 			}
 			cli.printSuccess("Logged out")
 			return nil
 		},
 	}
+	cmd.Flags().StringVar(&systemFlag, "system", "", "Sign out of the system with given API URL or name, instead of the system of the current target")
+	return cmd
 }
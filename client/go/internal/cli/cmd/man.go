@@ -2,10 +2,7 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
-	"github.com/spf13/cobra/doc"
 )
 
 func newManCmd(cli *CLI) *cobra.Command {
@@ -18,9 +15,8 @@ func newManCmd(cli *CLI) *cobra.Command {
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dir := args[0]
-			err := doc.GenManTree(cli.cmd, nil, dir)
-			if err != nil {
-				return fmt.Errorf("failed to write man pages: %w", err)
+			if err := generateDocs(cli.cmd, "man", dir); err != nil {
+				return err
 			}
 			cli.printSuccess("Man pages written to ", dir)
 			return nil
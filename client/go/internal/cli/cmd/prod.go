@@ -10,8 +10,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -38,8 +41,23 @@ $ vespa prod deploy`,
 	}
 }
 
+// prodInitOptions holds the flags of prod init which replace its prompts.
+type prodInitOptions struct {
+	zones       []string
+	testRegions []string
+	nodes       []string
+	resources   []string
+	authorEmail string
+	addTests    bool
+	ci          string
+	force       bool
+}
+
+var prodInitFlags = []string{"zones", "test-regions", "nodes", "resources", "author-email", "add-tests"}
+
 func newProdInitCmd(cli *CLI) *cobra.Command {
-	return &cobra.Command{
+	var options prodInitOptions
+	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "Modify service.xml and deployment.xml for production deployment",
 		Long: `Modify service.xml and deployment.xml for production deployment.
@@ -48,12 +66,50 @@ Only basic deployment configuration is available through this command. For
 advanced configuration see the relevant Vespa Cloud documentation and make
 changes to deployment.xml and services.xml directly.
 
+By default, this command prompts for the configuration to use. If any of the
+flags --zones, --test-regions, --nodes, --resources, --author-email or
+--add-tests are given, their values are used instead, and anything not given
+defaults to the existing contents of deployment.xml and services.xml. Values
+without such a default are prompted for if the terminal is interactive, and
+otherwise the command fails, listing the flags which must be given.
+
+With --ci, deployment.xml and services.xml are left as they are. Instead, a CI
+workflow is written to the root of the git repository holding the application
+package, which deploys the application to dev and runs its system tests for
+each pull request, and submits it to production for each push to the main
+branch. The flavor of the workflow is given by --ci: "github" writes a GitHub
+Actions workflow to .github/workflows/vespa.yml, and "generic" writes a shell
+script, vespa-ci.sh, to be run by any CI system. The workflow installs this
+version of Vespa CLI, deploys the application configured with 'vespa config
+set application', and reads the API key and data plane certificate from
+secrets of the CI system. An existing workflow is not overwritten, unless
+--force is given.
+
 Reference:
 https://docs.vespa.ai/en/reference/services.html
 https://docs.vespa.ai/en/reference/deployment.html`,
+		Example: `$ vespa prod init
+$ vespa prod init --zones prod.aws-us-east-1c,prod.gcp-us-central1-f --nodes default=2 --nodes music=4
+$ vespa prod init --zones aws-us-east-1c --test-regions aws-us-east-1c --resources music=vcpu=4,memory=16Gb,disk=100Gb
+$ vespa prod init --author-email alice@example.com --add-tests=true
+$ vespa prod init --ci github`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if options.ci != "" {
+				for _, name := range prodInitFlags {
+					if cmd.Flags().Changed(name) {
+						return fmt.Errorf("option --ci cannot be combined with --%s", name)
+					}
+				}
+				pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{SourceOnly: true})
+				if err != nil {
+					return err
+				}
+				return initCI(cli, options.ci, options.force, pkg)
+			} else if options.force {
+				return fmt.Errorf("option --force requires --ci")
+			}
 			target, err := cli.target(targetOptions{noCertificate: true, supportedType: cloudTargetOnly})
 			if err != nil {
 				return err
@@ -75,6 +131,13 @@ https://docs.vespa.ai/en/reference/deployment.html`,
 			if err != nil {
 				return fmt.Errorf("a services.xml declaring your cluster(s) must exist: %w", err)
 			}
+			flagsGiven := false
+			for _, name := range prodInitFlags {
+				flagsGiven = flagsGiven || cmd.Flags().Changed(name)
+			}
+			if flagsGiven {
+				return initFromFlags(cli, pkg, options, deploymentXML, servicesXML, target.Deployment().System)
+			}
 
 			fmt.Fprint(cli.Stdout, "This will modify any existing ", color.YellowString("deployment.xml"), " and ", color.YellowString("services.xml"),
 				"!\nBefore modification a backup of the original file will be created.\n\n")
@@ -101,15 +164,289 @@ https://docs.vespa.ai/en/reference/deployment.html`,
 			return nil
 		},
 	}
+	cmd.Flags().StringSliceVar(&options.zones, "zones", nil, "Comma-separated production zones to deploy to, e.g. prod.aws-us-east-1c. The prod. prefix is optional")
+	cmd.Flags().StringSliceVar(&options.testRegions, "test-regions", nil, "Comma-separated regions, among those in --zones, to run production tests in after deployment")
+	cmd.Flags().StringArrayVar(&options.nodes, "nodes", nil, "Node count of a cluster, on the form cluster=count, e.g. music=4 or music=[2,8]. Can be repeated")
+	cmd.Flags().StringArrayVar(&options.resources, "resources", nil, "Resources of each node in a cluster, on the form cluster=auto or cluster=vcpu=4,memory=8Gb,disk=100Gb. Can be repeated")
+	cmd.Flags().StringVar(&options.authorEmail, "author-email", "", "Email address to notify when deployment of the application fails")
+	cmd.Flags().BoolVar(&options.addTests, "add-tests", false, "Add skeleton system, staging and production tests, unless the application package already has tests of the given kind")
+	cmd.Flags().StringVar(&options.ci, "ci", "", `Write a CI workflow deploying the application, instead of modifying deployment.xml and services.xml. Must be "github" or "generic"`)
+	cmd.Flags().BoolVar(&options.force, "force", false, "Overwrite an existing CI workflow")
+	return cmd
+}
+
+// initFromFlags updates deployment.xml and services.xml in pkg, using the values given as flags in options.
+func initFromFlags(cli *CLI, pkg vespa.ApplicationPackage, options prodInitOptions, deploymentXML xml.Deployment, servicesXML xml.Services, system vespa.System) error {
+	stdin := bufio.NewReader(cli.Stdin)
+	interactive := cli.isTerminal()
+	var missing []string
+	regions, err := parseZones(options.zones, system)
+	if err != nil {
+		return err
+	}
+	if len(regions) == 0 && ioutil.Exists(filepath.Join(pkg.Path, "deployment.xml")) {
+		regions = currentRegions(deploymentXML)
+	}
+	if len(regions) == 0 {
+		if interactive {
+			answer, err := promptRegions(cli, stdin, deploymentXML, system)
+			if err != nil {
+				return err
+			}
+			regions = strings.Split(answer, ",")
+		} else {
+			missing = append(missing, "--zones")
+		}
+	}
+	testRegions, err := parseZones(options.testRegions, system)
+	if err != nil {
+		return err
+	}
+	for _, r := range testRegions {
+		if len(regions) > 0 && !slices.Contains(regions, r) {
+			return errHint(fmt.Errorf("invalid test region %s: not among the regions to deploy to: %s", r, strings.Join(regions, ",")),
+				"Production tests can only run in regions given in --zones")
+		}
+	}
+
+	var clusters []string
+	currentNodes := make(map[string]xml.Nodes)
+	for _, c := range servicesXML.Container {
+		clusters = append(clusters, c.ID)
+		currentNodes[c.ID] = c.Nodes
+	}
+	for _, c := range servicesXML.Content {
+		clusters = append(clusters, c.ID)
+		currentNodes[c.ID] = c.Nodes
+	}
+	nodeCounts, err := parseClusterValues("nodes", options.nodes, clusters, validateNodeCount)
+	if err != nil {
+		return err
+	}
+	resources, err := parseClusterValues("resources", options.resources, clusters, validateResources)
+	if err != nil {
+		return err
+	}
+	nodes := make(map[string]xml.Nodes)
+	for _, id := range clusters {
+		current := currentNodes[id]
+		count, ok := nodeCounts[id]
+		if !ok {
+			count = current.Count
+		}
+		if count == "" {
+			if interactive {
+				if count, err = promptNodeCount(cli, stdin, id, ""); err != nil {
+					return err
+				}
+			} else {
+				missing = append(missing, "--nodes "+id+"=<count>")
+			}
+		}
+		spec, ok := resources[id]
+		if !ok {
+			spec = autoResources
+			if current.Resources != nil {
+				spec = current.Resources.String()
+			}
+		}
+		if nodes[id], err = newNodes(count, spec); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return errHint(fmt.Errorf("missing required flags: %s", strings.Join(missing, ", ")),
+			"These have no default in deployment.xml or services.xml, and cannot be prompted for in a non-interactive terminal")
+	}
+
+	deploymentXML, err = setRegions(deploymentXML, regions, testRegions)
+	if err != nil {
+		return err
+	}
+	if options.authorEmail != "" {
+		if err := deploymentXML.Replace("deployment", "notifications", nil); err != nil {
+			return fmt.Errorf("could not remove notifications element in deployment.xml: %w", err)
+		}
+		notifications := xml.Notifications{When: "failing", Emails: []xml.Email{{Address: options.authorEmail}}}
+		if err := deploymentXML.Insert("deployment", "notifications", notifications); err != nil {
+			return fmt.Errorf("could not add notifications element to deployment.xml: %w", err)
+		}
+	}
+	for _, c := range servicesXML.Container {
+		if err := setNodes(&servicesXML, "container#"+c.ID, c.Nodes, nodes[c.ID]); err != nil {
+			return err
+		}
+	}
+	for _, c := range servicesXML.Content {
+		if err := setNodes(&servicesXML, "content#"+c.ID, c.Nodes, nodes[c.ID]); err != nil {
+			return err
+		}
+	}
+	if err := writeWithBackup(cli.Stdout, pkg, "deployment.xml", deploymentXML.String()); err != nil {
+		return err
+	}
+	if err := writeWithBackup(cli.Stdout, pkg, "services.xml", servicesXML.String()); err != nil {
+		return err
+	}
+	if options.addTests {
+		return addTestSkeletons(cli.Stdout, pkg, len(testRegions) > 0)
+	}
+	return nil
+}
+
+// setNodes sets the nodes element of cluster to nodes, adding the element if the cluster has none.
+func setNodes(servicesXML *xml.Services, cluster string, current, nodes xml.Nodes) error {
+	if current.Count == "" && current.Resources == nil {
+		return servicesXML.Insert(cluster, "nodes", nodes)
+	}
+	return servicesXML.Replace(cluster, "nodes", nodes)
+}
+
+// parseZones parses the given production zones, on the form prod.region or region, into region names.
+func parseZones(zones []string, system vespa.System) ([]string, error) {
+	var regions []string
+	for _, z := range zones {
+		region := strings.TrimPrefix(strings.TrimSpace(z), "prod.")
+		if !xml.IsProdRegion(region, system) {
+			return nil, errHint(fmt.Errorf("invalid production zone %s", z), "See https://cloud.vespa.ai/en/reference/zones")
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// parseClusterValues parses flag values on the form cluster=value, and returns the values keyed by cluster.
+func parseClusterValues(flagName string, values []string, clusters []string, validator func(string) error) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, v := range values {
+		cluster, value, ok := strings.Cut(v, "=")
+		if !ok || cluster == "" || value == "" {
+			return nil, fmt.Errorf("invalid value for --%s: %q must be on the form cluster=value", flagName, v)
+		}
+		if !slices.Contains(clusters, cluster) {
+			return nil, errHint(fmt.Errorf("invalid value for --%s: no cluster %s in services.xml", flagName, cluster),
+				"Clusters in services.xml: "+strings.Join(clusters, ", "))
+		}
+		if err := validator(value); err != nil {
+			return nil, fmt.Errorf("invalid value for --%s %s: %w", flagName, cluster, err)
+		}
+		result[cluster] = value
+	}
+	return result, nil
+}
+
+// addTestSkeletons writes skeleton tests to pkg, for each kind of test it does not already have. Production tests are
+// added if productionTests is true.
+func addTestSkeletons(stdout io.Writer, pkg vespa.ApplicationPackage, productionTests bool) error {
+	testsParent := pkg.TestPath
+	if testsParent == "" {
+		testsParent = pkg.Path
+		if filepath.Base(pkg.Path) == "application" && filepath.Base(filepath.Dir(pkg.Path)) == "main" {
+			testsParent = filepath.Join(filepath.Dir(filepath.Dir(pkg.Path)), "test", "application")
+		}
+	}
+	suites := []string{"system-test", "staging-setup", "staging-test"}
+	if productionTests {
+		suites = append(suites, "production-test")
+	}
+	for _, suite := range suites {
+		dir := filepath.Join(testsParent, "tests", suite)
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+			fmt.Fprintf(stdout, "Not writing %s tests: %s already exists\n", color.YellowString(suite), dir)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, suite+".json")
+		fmt.Fprintf(stdout, "Writing %s\n", color.GreenString(dst))
+		if err := os.WriteFile(dst, []byte(testSkeleton(suite)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testSkeleton returns a test for suite, which verifies that the application is up and serves queries. As production
+// tests may not send requests to Vespa endpoints, the production test verifies an external service instead.
+func testSkeleton(suite string) string {
+	name := "TODO: replace with steps which feed documents to, and query, your application"
+	request := `{ "uri": "/search/", "parameters": { "yql": "select * from sources * where true" } }`
+	switch suite {
+	case "staging-setup":
+		request = `{ "uri": "/ApplicationStatus" }`
+	case "production-test":
+		name = "TODO: replace with steps which verify the application through the services depending on it"
+		request = `{ "uri": "https://example.com/" }`
+	}
+	return fmt.Sprintf(`{
+  "name": "%s",
+  "steps": [
+    {
+      "name": "%s",
+      "request": %s,
+      "response": { "code": 200 }
+    }
+  ]
+}
+`, suite, name, request)
 }
 
 type prodDeployOptions struct {
 	copyCert    bool
 	risk        int
 	commit      string
+	branch      string
 	description string
 	authorEmail string
 	sourceURL   string
+	testPackage string
+	excludes    []string
+	sets        []string
+	dryRun      bool
+	remote      remotePackageOptions
+	follow      bool
+	wait        bool
+	timeout     time.Duration
+	format      string
+	build       int64
+	pin         bool
+	unpin       bool
+	listBuilds  bool
+	printDigest bool
+	// failIfBlocked is whether to fail, rather than queue, deployments blocked by a change window
+	failIfBlocked bool
+	// ignoreChecks are the names of the pre-submission checks to skip
+	ignoreChecks []string
+}
+
+// prodDeployResult is the JSON result of prod deploy.
+type prodDeployResult struct {
+	Build       int64  `json:"build"`
+	Commit      string `json:"commit,omitempty"`
+	SourceURL   string `json:"sourceUrl,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	AuthorEmail string `json:"authorEmail,omitempty"`
+	Description string `json:"description,omitempty"`
+	Risk        int    `json:"risk,omitempty"`
+	TestPackage string `json:"testPackage,omitempty"`
+	SubmittedAt string `json:"submittedAt"`
+	ConsoleURL  string `json:"consoleUrl"`
+	Digest      string `json:"digest,omitempty"`
+	Pinned      bool   `json:"pinned,omitempty"`
+	// Rollout is "rolling-out" if the build rolls out now, or "blocked" if it is held back, e.g., by a change window
+	Rollout      string `json:"rollout,omitempty"`
+	BlockedUntil string `json:"blockedUntil,omitempty"`
+	// Steps holds the steps of submitting the application package
+	Steps []stepResult `json:"steps,omitempty"`
+}
+
+// prodBuildResult is a build in the JSON result of prod deploy --list-builds.
+type prodBuildResult struct {
+	Build       int64  `json:"build"`
+	Commit      string `json:"commit,omitempty"`
+	SourceURL   string `json:"sourceUrl,omitempty"`
+	SubmittedAt string `json:"submittedAt,omitempty"`
 }
 
 func newProdDeployCmd(cli *CLI) *cobra.Command {
@@ -130,12 +467,102 @@ For more information about production deployments in Vespa Cloud see:
 https://docs.vespa.ai/en/cloud/production-deployment.html
 https://docs.vespa.ai/en/cloud/automated-deployments.html
 https://cloud.vespa.ai/en/reference/vespa-cloud-api#submission-properties
+
+Files matching the patterns in .vespaignore, or given with --exclude, are not
+included in the application package. The application package can also be given
+as an https:// URL or a Maven coordinate. See 'vespa help deploy'.
+
+` + templateHelp + `
+
+The zip created from an application directory is deterministic, such that
+submitting the same sources gives the same bytes. With --print-digest, the
+SHA-256 digest of the application package is printed before it is uploaded, on
+the form sha256:<hex>, and included in the JSON result, so that pipelines can
+compare it to the digest of an earlier submission.
+
+With --follow, the command follows the deployment of the submitted build
+through system test, staging test and the production zones. The log of each job
+run is printed as it appears, together with the step each job is in. The
+command exits when all jobs have completed, and fails if any of them fails.
+Use --timeout to limit how long to follow the deployment. Interrupting the
+command (Ctrl-C) stops following, but does not cancel the deployment.
+
+With --wait, the command waits only until the submitted build has been accepted,
+i.e., until its first job, normally the system test, has started, and fails if
+the build is rejected before that.
+
+The commit, branch, author email and commit message subject of the source are
+read from the git repository holding the application package, and recorded
+with the submission, such that the console can link to them. Each may be
+overridden with --commit, --branch, --author-email and --description. If
+--commit names another commit than the one checked out, nothing is read from
+git. If git metadata is not available, e.g. in a build system without the git
+repository in the workspace, only what is given with flags is recorded, and a
+warning is printed unless --commit is given.
+
+Tests built separately from the application package, e.g. Java tests built to
+their own directory, are submitted from the directory or zip file given with
+--test-package, instead of the tests found next to the application package.
+
+With --format json, the build number, the source metadata and test package
+submitted, and the time of submission are printed as a JSON object to standard
+output, and any other output is printed to standard error.
+
+With --build, no application package is submitted. Instead, deployment of a
+previously submitted build to the production zones of the instance is
+triggered, e.g. to roll back to a build known to work. The builds which can be
+deployed are listed with --list-builds, with their build number, commit and
+time of submission. With --pin, the instance is kept on the given build, and
+newer submissions are not deployed to it, until it is unpinned with --unpin.
+--follow and --wait follow the production deployment jobs triggered for the
+build.
+
+After the build is submitted or triggered, the deployment status of the
+instance is checked, and the command prints whether the build is rolling out
+now, or is held back by a change window (a block-change element in
+deployment.xml), together with when the window ends, in local time. The state
+is included as "rollout" in the JSON result. With --fail-if-blocked, the
+command fails without deploying if a change window blocks application
+revisions of the instance, and fails if the build is held back after it is
+deployed, so that pipelines never leave changes queued.
+
+Before uploading, the application package is checked for problems which would
+make Vespa Cloud reject it, such as deployment.xml declaring no production
+regions, or security/clients.pem having no certificates. The checks are
+described in 'vespa prod validate', which runs them without submitting, and
+individual checks are skipped with --ignore-checks.
 `,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Example: `$ mvn package # when adding custom Java components
-$ vespa prod deploy`,
+$ vespa prod deploy
+$ vespa prod deploy --follow --timeout 2h
+$ vespa prod deploy --set nodes=4 --set env.REGION=aws-us-east-1c --dry-run
+$ vespa prod deploy --wait --format json --commit "$GIT_COMMIT" --source-url "$BUILD_URL"
+$ vespa prod deploy --list-builds
+$ vespa prod deploy --build 123 --pin --follow
+$ vespa prod deploy --unpin
+$ vespa prod deploy --fail-if-blocked`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format := cli.outputFormat(cmd, options.format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			if err := checkBuildOptions(cmd, options, args); err != nil {
+				return err
+			}
+			if err := checkIgnoredChecks(options.ignoreChecks); err != nil {
+				return err
+			}
+			stdout := cli.Stdout
+			if format == "json" {
+				// Keep standard output for the result only
+				cli.Stdout = cli.Stderr
+				defer func() { cli.Stdout = stdout }()
+				if !cli.config.isQuiet() {
+					log.SetOutput(cli.Stderr)
+				}
+			}
 			target, err := cli.target(targetOptions{noCertificate: true, supportedType: cloudTargetOnly})
 			if err != nil {
 				return err
@@ -144,34 +571,102 @@ $ vespa prod deploy`,
 				// TODO: Add support for hosted
 				return fmt.Errorf("prod deploy does not support %s target", target.Type())
 			}
-			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{Compiled: true})
+			switch {
+			case options.listBuilds:
+				cli.Stdout = stdout
+				return listBuilds(cli, target, format)
+			case options.unpin:
+				if err := vespa.UnpinBuild(target); err != nil {
+					return fmt.Errorf("could not unpin build: %w", err)
+				}
+				cli.printSuccess("Unpinned the build of instance ", color.CyanString(target.Deployment().Application.Instance), ". Newer submissions will be deployed to it")
+				return nil
+			case cmd.Flags().Changed("build"):
+				return deployBuild(cli, target, options, format, stdout)
+			}
+			pkg, cleanup, err := cli.applicationPackageFromRemote(args, vespa.PackageOptions{Compiled: true}, options.remote)
 			if err != nil {
 				return err
 			}
+			defer cleanup()
+			pkg.Exclude = options.excludes
+			if err := cli.applyTemplate(&pkg, options.sets, options.dryRun); err != nil {
+				return err
+			}
+			if options.dryRun {
+				return nil
+			}
 			if !pkg.HasDeploymentSpec() {
 				return errHint(fmt.Errorf("no deployment.xml found"), "Try creating one with vespa prod init")
 			}
+			if options.testPackage != "" {
+				if !ioutil.Exists(options.testPackage) {
+					return fmt.Errorf("test package %s does not exist", options.testPackage)
+				}
+				pkg.TestPath = options.testPackage
+			}
 			if err := verifyTests(cli, pkg); err != nil {
 				return err
 			}
 			if err := requireCertificate(options.copyCert, true, cli, target, pkg); err != nil {
 				return err
 			}
-			deployment := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target}
-			submission := vespa.Submission{
-				Risk:        options.risk,
-				Commit:      options.commit,
-				Description: options.description,
-				AuthorEmail: options.authorEmail,
-				SourceURL:   options.sourceURL,
+			if err := verifySubmission(cli, pkg, options.ignoreChecks); err != nil {
+				return err
+			}
+			if options.failIfBlocked {
+				if err := checkNotBlocked(cli, target); err != nil {
+					return err
+				}
 			}
-			build, err := vespa.Submit(deployment, submission)
+			var digest string
+			deployment := vespa.DeploymentOptions{ApplicationPackage: pkg, Target: target, PackageFunc: cli.packageFunc(options.printDigest, &digest), UploadFunc: cli.uploadFunc()}
+			submission := prodSubmission(cli, options, args)
+			var build int64
+			err = cli.step("Uploading application package", func() error {
+				build, err = vespa.Submit(deployment, submission)
+				return err
+			})
 			if err != nil {
 				return fmt.Errorf("could not deploy application: %w", err)
 			} else {
 				cli.printSuccess(fmt.Sprintf("Deployed '%s' with build number %s", color.CyanString(pkg.Path), color.CyanString(strconv.FormatInt(build, 10))))
-				log.Printf("See %s for deployment progress\n", color.CyanString(fmt.Sprintf("%s/tenant/%s/application/%s/prod/deployment",
-					deployment.Target.Deployment().System.ConsoleURL, deployment.Target.Deployment().Application.Tenant, deployment.Target.Deployment().Application.Application)))
+				log.Printf("See %s for deployment progress\n", color.CyanString(prodConsoleURL(target)))
+			}
+			rollout := reportRollout(cli, target, build)
+			if format == "json" {
+				result := prodDeployResult{
+					Build:       build,
+					Commit:      submission.Commit,
+					SourceURL:   submission.SourceURL,
+					Branch:      submission.Branch,
+					AuthorEmail: submission.AuthorEmail,
+					Description: submission.Description,
+					Risk:        submission.Risk,
+					TestPackage: pkg.TestPath,
+					SubmittedAt: cli.now().UTC().Format(time.RFC3339),
+					ConsoleURL:  prodConsoleURL(target),
+					Steps:       cli.steps,
+				}
+				if options.printDigest {
+					result.Digest = "sha256:" + digest
+				}
+				rollout.addTo(&result)
+				cli.Stdout = stdout
+				err := writeJSON(cli, result)
+				cli.Stdout = cli.Stderr
+				if err != nil {
+					return err
+				}
+			}
+			if err := rollout.check(options); err != nil {
+				return err
+			}
+			if options.follow {
+				return cli.followBuild(target, build, nil, options.timeout)
+			}
+			if options.wait {
+				return cli.waitForBuild(target, build, nil, options.timeout)
 			}
 			return nil
 		},
@@ -181,10 +676,642 @@ $ vespa prod deploy`,
 	cmd.Flags().StringVarP(&options.commit, "commit", "", "", "Identifier of the source code being deployed. For example a commit hash")
 	cmd.Flags().StringVarP(&options.description, "description", "", "", "Description of the source code being deployed. For example a git commit message")
 	cmd.Flags().StringVarP(&options.authorEmail, "author-email", "", "", "Email of the author of the commit being deployed")
+	cmd.Flags().StringVar(&options.branch, "branch", "", "Branch of the source code being deployed")
+	cmd.Flags().StringVar(&options.testPackage, "test-package", "", "Directory or zip file with the system and staging tests of the application, built separately from the application package")
+	addRemotePackageFlags(cmd, &options.remote)
+	cmd.Flags().StringArrayVar(&options.excludes, "exclude", nil, "Exclude files matching this pattern from the application package, in addition to those in .vespaignore. Can be repeated")
+	cmd.Flags().StringArrayVar(&options.sets, "set", nil, "Set the value of a placeholder in services.xml and deployment.xml, on the form name=value. Can be repeated")
+	cmd.Flags().BoolVar(&options.dryRun, "dry-run", false, "Print services.xml and deployment.xml with placeholders substituted, without deploying")
 	cmd.Flags().StringVarP(&options.sourceURL, "source-url", "", "", "URL which points to the source code being deployed. For example the build job running the submission")
+	cmd.Flags().BoolVarP(&options.follow, "follow", "f", false, "Follow the deployment of the submitted build until it completes, printing job logs")
+	cmd.Flags().BoolVarP(&options.wait, "wait", "", false, "Wait until the submitted build has been accepted, and its first job has started")
+	cmd.Flags().DurationVar(&options.timeout, "timeout", 0, "Stop following or waiting for the deployment after this duration, e.g. 2h. 0 to wait until completion")
+	cmd.Flags().StringVarP(&options.format, "format", "", "human", "Output format. Must be 'human' (human-readable) or 'json'")
+	cmd.Flags().Int64Var(&options.build, "build", 0, "Deploy this previously submitted build, instead of submitting an application package")
+	cmd.Flags().BoolVar(&options.pin, "pin", false, "Keep the instance on the build given with --build, until unpinned with --unpin")
+	cmd.Flags().BoolVar(&options.unpin, "unpin", false, "Unpin the build of the instance, such that newer submissions are deployed to it")
+	cmd.Flags().BoolVar(&options.listBuilds, "list-builds", false, "List the builds submitted for the application, which can be deployed with --build")
+	cmd.Flags().BoolVar(&options.printDigest, "print-digest", false, "Print the SHA-256 digest of the application package before uploading it")
+	cmd.Flags().StringSliceVar(&options.ignoreChecks, "ignore-checks", nil, "Comma-separated pre-submission checks to skip, see 'vespa prod validate'. Must be among "+strings.Join(submissionChecks, ", "))
+	cmd.Flags().BoolVar(&options.failIfBlocked, "fail-if-blocked", false, "Fail, instead of queueing the change, if a change window blocks deployment to the instance")
 	return cmd
 }
 
+// checkBuildOptions returns an error if the options for deploying a submitted build are invalid, or combined with
+// options they do not support.
+func checkBuildOptions(cmd *cobra.Command, options prodDeployOptions, args []string) error {
+	var given []string
+	for _, name := range []string{"build", "list-builds", "unpin"} {
+		if cmd.Flags().Changed(name) {
+			given = append(given, "--"+name)
+		}
+	}
+	if len(given) > 1 {
+		return fmt.Errorf("options %s cannot be combined", strings.Join(given, " and "))
+	}
+	if options.pin && !cmd.Flags().Changed("build") {
+		return errHint(fmt.Errorf("option --pin requires --build"), "See the builds which can be deployed with 'vespa prod deploy --list-builds'")
+	}
+	if len(given) == 0 {
+		return nil
+	}
+	if cmd.Flags().Changed("build") && options.build <= 0 {
+		return fmt.Errorf("invalid build: %d: must be positive", options.build)
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("option %s cannot be combined with an application package", given[0])
+	}
+	// These apply to the application package, which is already part of a submitted build
+	if len(options.sets) > 0 {
+		given = append(given, "--set")
+	}
+	if options.dryRun {
+		given = append(given, "--dry-run")
+	}
+	if len(given) > 1 {
+		return fmt.Errorf("options %s cannot be combined", strings.Join(given, " and "))
+	}
+	if (options.listBuilds || options.unpin) && (options.follow || options.wait || options.failIfBlocked) {
+		return fmt.Errorf("options --follow, --wait and --fail-if-blocked cannot be combined with %s", given[0])
+	}
+	return nil
+}
+
+// listBuilds prints the builds submitted for the application in target, newest first.
+func listBuilds(cli *CLI, target vespa.Target, format string) error {
+	builds, err := vespa.Builds(target)
+	if err != nil {
+		return fmt.Errorf("could not list builds: %w", err)
+	}
+	if format == "json" {
+		results := make([]prodBuildResult, 0, len(builds))
+		for _, build := range builds {
+			result := prodBuildResult{Build: build.Number, Commit: build.Commit, SourceURL: build.SourceURL}
+			if !build.SubmittedAt.IsZero() {
+				result.SubmittedAt = build.SubmittedAt.UTC().Format(time.RFC3339)
+			}
+			results = append(results, result)
+		}
+		return writeJSON(cli, results)
+	}
+	if len(builds) == 0 {
+		cli.printInfo("No builds are submitted for application ", target.Deployment().Application.Tenant, ".", target.Deployment().Application.Application)
+		return nil
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUILD\tCOMMIT\tSUBMITTED")
+	for _, build := range builds {
+		commit, submittedAt := "-", "-"
+		if build.Commit != "" {
+			commit = build.Commit
+		}
+		if !build.SubmittedAt.IsZero() {
+			submittedAt = build.SubmittedAt.UTC().Format("2006-01-02 15:04:05 UTC")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", build.Number, commit, submittedAt)
+	}
+	return w.Flush()
+}
+
+// deployBuild triggers deployment of the build given in options to the instance of target, writing the result to
+// stdout if format is json, and follows or waits for the deployment as requested.
+func deployBuild(cli *CLI, target vespa.Target, options prodDeployOptions, format string, stdout io.Writer) error {
+	application := target.Deployment().Application
+	builds, err := vespa.Builds(target)
+	if err != nil {
+		return fmt.Errorf("could not list builds: %w", err)
+	}
+	var build *vespa.Build
+	for i := range builds {
+		if builds[i].Number == options.build {
+			build = &builds[i]
+		}
+	}
+	if build == nil {
+		return errHint(fmt.Errorf("build %d does not exist for application %s.%s", options.build, application.Tenant, application.Application),
+			"See the builds which can be deployed with 'vespa prod deploy --list-builds'")
+	}
+	// Runs of the build which precede the deployment triggered here are not followed
+	var previous map[string]int64
+	if options.follow || options.wait {
+		status, err := vespa.GetProdStatus(target)
+		if err != nil {
+			return fmt.Errorf("could not get deployment status: %w", err)
+		}
+		previous = make(map[string]int64)
+		for _, job := range status.Jobs {
+			if job.LastRun != nil {
+				previous[job.Instance+"."+job.Job] = job.LastRun.ID
+			}
+		}
+	}
+	if options.failIfBlocked {
+		if err := checkNotBlocked(cli, target); err != nil {
+			return err
+		}
+	}
+	if err := vespa.DeployBuild(target, build.Number, options.pin); err != nil {
+		return fmt.Errorf("could not deploy build %d: %w", build.Number, err)
+	}
+	pinned := ""
+	if options.pin {
+		pinned = ", and pinned it until unpinned with 'vespa prod deploy --unpin'"
+	}
+	cli.printSuccess("Triggered deployment of build ", color.CyanString(strconv.FormatInt(build.Number, 10)), " to instance ", color.CyanString(application.Instance), pinned)
+	log.Printf("See %s for deployment progress\n", color.CyanString(prodConsoleURL(target)))
+	rollout := reportRollout(cli, target, build.Number)
+	if format == "json" {
+		result := prodDeployResult{Build: build.Number, Commit: build.Commit, SourceURL: build.SourceURL, ConsoleURL: prodConsoleURL(target), Pinned: options.pin}
+		if !build.SubmittedAt.IsZero() {
+			result.SubmittedAt = build.SubmittedAt.UTC().Format(time.RFC3339)
+		}
+		rollout.addTo(&result)
+		cli.Stdout = stdout
+		err := writeJSON(cli, result)
+		cli.Stdout = cli.Stderr
+		if err != nil {
+			return err
+		}
+	}
+	if err := rollout.check(options); err != nil {
+		return err
+	}
+	if options.follow {
+		return cli.followBuild(target, build.Number, previous, options.timeout)
+	}
+	if options.wait {
+		return cli.waitForBuild(target, build.Number, previous, options.timeout)
+	}
+	return nil
+}
+
+// checkNotBlocked returns an error if a change window blocks application revisions of the instance of target now.
+func checkNotBlocked(cli *CLI, target vespa.Target) error {
+	status, err := vespa.GetProdStatus(target)
+	if err != nil {
+		return fmt.Errorf("could not get deployment status: %w", err)
+	}
+	instance := target.Deployment().Application.Instance
+	opensAt, blocked := vespa.RevisionsBlockedUntil(status.ChangeBlockers, instance, cli.now())
+	if !blocked {
+		return nil
+	}
+	return errHint(fmt.Errorf("deployment to instance %s is blocked by a change window%s", instance, formatWindowEnd(opensAt)),
+		"Deploy again when the window ends, or omit --fail-if-blocked to queue the change until then")
+}
+
+// formatWindowEnd returns a description of when a change window ending at t ends, in local time.
+func formatWindowEnd(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return " until " + t.Local().Format("2006-01-02 15:04 MST")
+}
+
+// prodRollout is the rollout status of a deployed build, or nil if it could not be determined.
+type prodRollout struct {
+	status vespa.RolloutStatus
+}
+
+// reportRollout prints whether build rolls out to the instance of target now, or is held back by a change window. A
+// warning is printed if this cannot be determined.
+func reportRollout(cli *CLI, target vespa.Target, build int64) *prodRollout {
+	status, err := vespa.GetRolloutStatus(target, build, cli.now())
+	if err != nil {
+		cli.printWarning(fmt.Sprintf("Could not determine whether build %d is rolling out: %s", build, err))
+		return nil
+	}
+	if status.Blocked {
+		cli.printWarning(fmt.Sprintf("Build %d is held back from instance %s by a change window%s", build, status.Instance, formatWindowEnd(status.OpensAt)),
+			"The build rolls out when the window ends. See the change windows with 'vespa prod status'")
+	} else {
+		log.Printf("Build %s is rolling out to instance %s now\n", color.CyanString(strconv.FormatInt(build, 10)), color.CyanString(status.Instance))
+	}
+	return &prodRollout{status: status}
+}
+
+// addTo adds the rollout status to result.
+func (r *prodRollout) addTo(result *prodDeployResult) {
+	if r == nil {
+		return
+	}
+	result.Rollout = "rolling-out"
+	if r.status.Blocked {
+		result.Rollout = "blocked"
+		if !r.status.OpensAt.IsZero() {
+			result.BlockedUntil = r.status.OpensAt.UTC().Format(time.RFC3339)
+		}
+	}
+}
+
+// check returns an error if the build is held back, and options require it to roll out now.
+func (r *prodRollout) check(options prodDeployOptions) error {
+	if r == nil || !r.status.Blocked || !options.failIfBlocked {
+		return nil
+	}
+	return errHint(fmt.Errorf("build %d is held back from instance %s by a change window%s", r.status.Build, r.status.Instance, formatWindowEnd(r.status.OpensAt)),
+		"The build rolls out when the window ends, unless it is replaced by a newer build")
+}
+
+// prodStatusResult is the JSON result of prod status.
+type prodStatusResult struct {
+	Jobs           []prodJobResult       `json:"jobs"`
+	ChangeBlockers []changeBlockerResult `json:"changeBlockers,omitempty"`
+}
+
+type prodJobResult struct {
+	Instance   string         `json:"instance"`
+	Job        string         `json:"job"`
+	Zone       string         `json:"zone,omitempty"`
+	Build      int64          `json:"build,omitempty"`
+	Version    string         `json:"version,omitempty"`
+	DeployedAt *time.Time     `json:"deployedAt,omitempty"`
+	LastRun    *prodRunResult `json:"lastRun,omitempty"`
+}
+
+type prodRunResult struct {
+	ID         int64      `json:"id"`
+	Build      int64      `json:"build"`
+	Status     string     `json:"status"`
+	Step       string     `json:"step,omitempty"`
+	Start      *time.Time `json:"start,omitempty"`
+	End        *time.Time `json:"end,omitempty"`
+	DurationMs int64      `json:"durationMs,omitempty"`
+}
+
+type changeBlockerResult struct {
+	Instance  string   `json:"instance"`
+	Versions  bool     `json:"versions"`
+	Revisions bool     `json:"revisions"`
+	Days      []string `json:"days"`
+	Hours     []int    `json:"hours"`
+	TimeZone  string   `json:"timeZone"`
+}
+
+func newProdStatusCmd(cli *CLI) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of production deployment jobs",
+		Long: `Show the status of production deployment jobs.
+
+This command shows the build and Vespa version currently deployed in each
+production zone of the application, when it was deployed, and the state of the
+most recent run of each deployment job, including system and staging tests.
+Any windows in which changes to the application are blocked are also shown.
+
+The command fails if the most recent run of any job has failed.`,
+		Example: `$ vespa prod status
+$ vespa prod status -a mytenant.myapp --format json`,
+		Args:              cobra.ExactArgs(0),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			target, err := cli.target(targetOptions{noCertificate: true, supportedType: cloudTargetOnly})
+			if err != nil {
+				return err
+			}
+			var status vespa.ProdStatus
+			if err := cli.spinner(cli.Stderr, "Fetching deployment status...", func() error {
+				status, err = vespa.GetProdStatus(target)
+				return err
+			}); err != nil {
+				return fmt.Errorf("could not get deployment status: %w", err)
+			}
+			var failing []vespa.JobStatus
+			for _, job := range status.Jobs {
+				if job.LastRun != nil && job.LastRun.Failed() {
+					failing = append(failing, job)
+				}
+			}
+			if format == "json" {
+				if err := writeJSON(cli, newProdStatusResult(status, cli.now())); err != nil {
+					return err
+				}
+				if len(failing) > 0 {
+					return ErrCLI{Status: exitError, quiet: true, error: fmt.Errorf("%d jobs failing", len(failing))}
+				}
+				return nil
+			}
+			printProdStatus(cli, status)
+			if len(failing) == 0 {
+				return nil
+			}
+			var names, hints []string
+			for _, job := range failing {
+				names = append(names, job.Instance+"."+job.Job)
+				if job.Zone.Environment != "" {
+					application := target.Deployment().Application
+					application.Instance = job.Instance
+					hints = append(hints, fmt.Sprintf("Run 'vespa status deployment -a %s -z %s %d' to show the log of the failing run of %s", application, job.Zone, job.LastRun.ID, job.Job))
+				} else {
+					hints = append(hints, fmt.Sprintf("See %s for the log of the failing run of %s", color.CyanString(prodConsoleURL(target)), job.Job))
+				}
+			}
+			return ErrCLI{Status: exitError, warn: true, hints: hints, error: fmt.Errorf("jobs failing: %s", strings.Join(names, ", "))}
+		},
+	}
+	cmd.Flags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable) or 'json'")
+	return cmd
+}
+
+func newProdStatusResult(status vespa.ProdStatus, now time.Time) prodStatusResult {
+	result := prodStatusResult{Jobs: []prodJobResult{}}
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		t = t.UTC()
+		return &t
+	}
+	for _, job := range status.Jobs {
+		jobResult := prodJobResult{Instance: job.Instance, Job: job.Job, Build: job.Build, Version: job.Version, DeployedAt: optionalTime(job.DeployedAt)}
+		if job.Zone.Environment != "" {
+			jobResult.Zone = job.Zone.String()
+		}
+		if run := job.LastRun; run != nil {
+			jobResult.LastRun = &prodRunResult{
+				ID:         run.ID,
+				Build:      run.Build,
+				Status:     run.Status,
+				Step:       run.Step,
+				Start:      optionalTime(run.Start),
+				End:        optionalTime(run.End),
+				DurationMs: runDuration(*run, now).Milliseconds(),
+			}
+		}
+		result.Jobs = append(result.Jobs, jobResult)
+	}
+	for _, b := range status.ChangeBlockers {
+		result.ChangeBlockers = append(result.ChangeBlockers, changeBlockerResult(b))
+	}
+	return result
+}
+
+func runDuration(run vespa.JobRun, now time.Time) time.Duration {
+	if run.Start.IsZero() {
+		return 0
+	}
+	end := run.End
+	if end.IsZero() {
+		end = now
+	}
+	return end.Sub(run.Start).Round(time.Second)
+}
+
+func printProdStatus(cli *CLI, status vespa.ProdStatus) {
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tZONE\tBUILD\tVERSION\tDEPLOYED\tJOB")
+	orDash := func(s string) string {
+		if s == "" || s == "0" {
+			return "-"
+		}
+		return s
+	}
+	for _, job := range status.Jobs {
+		zone := job.Job
+		if job.Zone.Environment != "" {
+			zone = job.Zone.String()
+		}
+		deployedAt := ""
+		if !job.DeployedAt.IsZero() {
+			deployedAt = job.DeployedAt.UTC().Format("2006-01-02 15:04:05 UTC")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Instance, zone, orDash(strconv.FormatInt(job.Build, 10)), orDash(job.Version), orDash(deployedAt), formatJobState(job.LastRun, cli.now()))
+	}
+	w.Flush()
+	if len(status.ChangeBlockers) > 0 {
+		fmt.Fprintln(cli.Stdout, "\nChange blockers:")
+		for _, b := range status.ChangeBlockers {
+			var blocked []string
+			if b.Versions {
+				blocked = append(blocked, "version upgrades")
+			}
+			if b.Revisions {
+				blocked = append(blocked, "application revisions")
+			}
+			fmt.Fprintf(cli.Stdout, "  %s: %s blocked on %s, hours %s (%s)\n", b.Instance, strings.Join(blocked, " and "), strings.Join(b.Days, ", "), formatHours(b.Hours), b.TimeZone)
+		}
+	}
+}
+
+func formatJobState(run *vespa.JobRun, now time.Time) string {
+	if run == nil {
+		return "-"
+	}
+	details := fmt.Sprintf("(run %d, build %d, %s)", run.ID, run.Build, runDuration(*run, now))
+	switch {
+	case run.Active():
+		return color.YellowString("running") + " in step " + run.Step + " " + details
+	case run.Failed():
+		return color.RedString("failed") + ": " + run.Status + " in step " + run.Step + " " + details
+	default:
+		return color.GreenString("succeeded") + " " + details
+	}
+}
+
+// formatHours formats hours as a list of ranges, e.g. 0-8, 20-23.
+func formatHours(hours []int) string {
+	var ranges []string
+	for i := 0; i < len(hours); {
+		j := i
+		for j+1 < len(hours) && hours[j+1] == hours[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(hours[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", hours[i], hours[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+func prodConsoleURL(target vespa.Target) string {
+	return fmt.Sprintf("%s/tenant/%s/application/%s/prod/deployment",
+		target.Deployment().System.ConsoleURL, target.Deployment().Application.Tenant, target.Deployment().Application.Application)
+}
+
+// maxErrorLines is the number of error log lines kept per job run, to repeat if the run fails.
+const maxErrorLines = 10
+
+// followBuild follows the deployment of build until all its jobs have completed, printing the log of each job run and
+// the step it's in. Following stops without cancelling the deployment if interrupted, or when timeout is reached. See
+// buildRuns for previous.
+func (c *CLI) followBuild(target vespa.Target, build int64, previous map[string]int64, timeout time.Duration) error {
+	ctx := c.ctx
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = c.now().Add(timeout)
+	}
+	states := make(map[string]string)
+	lastIDs := make(map[string]int64)
+	logDone := make(map[string]bool)
+	errorLines := make(map[string][]string)
+	for {
+		runs, err := buildRuns(target, build, previous)
+		if err != nil && ctx.Err() != nil {
+			c.printInfo("Stopped following deployment of build ", build, ". The deployment continues")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not get status of build %d: %w", build, err)
+		}
+		done := len(runs) > 0
+		var failed []vespa.JobRun
+		for _, run := range runs {
+			if run.Pending() {
+				done = false
+				continue
+			}
+			name := run.Instance + "." + run.Job
+			if state := run.Status + "/" + run.Step; states[name] != state {
+				states[name] = state
+				printRunState(name, run)
+			}
+			if !logDone[name] {
+				if _, ok := lastIDs[name]; !ok {
+					lastIDs[name] = -1
+				}
+				runLog, err := vespa.JobRunLog(target, run, lastIDs[name])
+				if err != nil {
+					return fmt.Errorf("could not get log of %s run %d: %w", name, run.ID, err)
+				}
+				lastIDs[name] = runLog.LastID
+				logDone[name] = !run.Active()
+				for _, entry := range runLog.Entries {
+					if line, ok := formatRunLogEntry(name, entry); ok {
+						log.Print(line)
+						if entry.Level == "error" {
+							errorLines[name] = append(errorLines[name], line)
+							if len(errorLines[name]) > maxErrorLines {
+								errorLines[name] = errorLines[name][1:]
+							}
+						}
+					}
+				}
+			}
+			if run.Active() {
+				done = false
+			} else if run.Failed() {
+				failed = append(failed, run)
+			}
+		}
+		if len(failed) > 0 {
+			var names []string
+			for _, run := range failed {
+				name := run.Instance + "." + run.Job
+				names = append(names, fmt.Sprintf("%s run %d ended with status %s", name, run.ID, run.Status))
+				for _, line := range errorLines[name] {
+					fmt.Fprintln(c.Stderr, line)
+				}
+			}
+			return errHint(fmt.Errorf("deployment of build %d failed: %s", build, strings.Join(names, ", ")),
+				"See "+color.CyanString(prodConsoleURL(target))+" for details")
+		}
+		if done {
+			c.printSuccess("Deployment of build ", color.CyanString(strconv.FormatInt(build, 10)), " completed")
+			return nil
+		}
+		if !deadline.IsZero() && !c.now().Before(deadline) {
+			return errHint(fmt.Errorf("deployment of build %d did not complete within %s", build, timeout),
+				"The deployment continues. See "+color.CyanString(prodConsoleURL(target))+" for deployment progress")
+		}
+		select {
+		case <-ctx.Done():
+			c.printInfo("Stopped following deployment of build ", build, ". The deployment continues")
+			return nil
+		case <-time.After(c.retryInterval):
+		}
+	}
+}
+
+// waitForBuild waits until the first job of build has started running, and fails if the build is rejected before that.
+// See buildRuns for previous.
+func (c *CLI) waitForBuild(target vespa.Target, build int64, previous map[string]int64, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = c.now().Add(timeout)
+	}
+	for {
+		runs, err := buildRuns(target, build, previous)
+		if err != nil {
+			return fmt.Errorf("could not get status of build %d: %w", build, err)
+		}
+		for _, run := range runs {
+			if run.Pending() {
+				continue
+			}
+			name := run.Instance + "." + run.Job
+			if run.Failed() {
+				return errHint(fmt.Errorf("build %d was rejected: %s run %d ended with status %s", build, name, run.ID, run.Status),
+					"See "+color.CyanString(prodConsoleURL(target))+" for details")
+			}
+			c.printSuccess("Build ", color.CyanString(strconv.FormatInt(build, 10)), " accepted: ", name, " run ", run.ID, " started")
+			return nil
+		}
+		if !deadline.IsZero() && !c.now().Before(deadline) {
+			return errHint(fmt.Errorf("build %d was not accepted within %s", build, timeout),
+				"See "+color.CyanString(prodConsoleURL(target))+" for deployment progress")
+		}
+		if err := c.sleep(c.retryInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// buildRuns returns the runs of the jobs deploying build. If previous is non-nil, the build was deployed again, by
+// vespa prod deploy --build: only the production deployment jobs of the target instance are then included, and runs
+// with IDs up to those in previous, by instance and job, are reported as pending.
+func buildRuns(target vespa.Target, build int64, previous map[string]int64) ([]vespa.JobRun, error) {
+	runs, err := vespa.BuildRuns(target, build)
+	if err != nil || previous == nil {
+		return runs, err
+	}
+	var deployments []vespa.JobRun
+	for _, run := range runs {
+		if run.Instance != target.Deployment().Application.Instance || !strings.HasPrefix(run.Job, "production-") {
+			continue
+		}
+		if run.ID <= previous[run.Instance+"."+run.Job] {
+			run = vespa.JobRun{Instance: run.Instance, Job: run.Job, Status: "pending", Build: build}
+		}
+		deployments = append(deployments, run)
+	}
+	return deployments, nil
+}
+
+func printRunState(name string, run vespa.JobRun) {
+	switch {
+	case run.Active():
+		log.Printf("%s: run %d is %s, in step %s", color.CyanString(name), run.ID, color.YellowString("running"), run.Step)
+	case run.Failed():
+		log.Printf("%s: run %d %s with status %s, in step %s", color.CyanString(name), run.ID, color.RedString("failed"), run.Status, run.Step)
+	default:
+		log.Printf("%s: run %d %s", color.CyanString(name), run.ID, color.GreenString("succeeded"))
+	}
+}
+
+// formatRunLogEntry formats entry of the run of job name, and returns whether it should be printed.
+func formatRunLogEntry(name string, entry vespa.RunLogEntry) (string, bool) {
+	level := entry.Level
+	switch level {
+	case "debug":
+		return "", false
+	case "error":
+		level = color.RedString("%-7s", level)
+	case "warning":
+		level = color.YellowString("%-7s", level)
+	default:
+		level = fmt.Sprintf("%-7s", level)
+	}
+	return fmt.Sprintf("[%s] %s [%s] %s", entry.Time.Format("15:04:05"), level, name, entry.Message), true
+}
+
 func writeWithBackup(stdout io.Writer, pkg vespa.ApplicationPackage, filename, contents string) error {
 	dst := filepath.Join(pkg.Path, filename)
 	if ioutil.Exists(dst) {
@@ -221,34 +1348,41 @@ func updateRegions(cli *CLI, stdin *bufio.Reader, deploymentXML xml.Deployment,
 	if err != nil {
 		return xml.Deployment{}, err
 	}
-	parts := strings.Split(regions, ",")
-	regionElements := xml.Regions(parts...)
-	if err := deploymentXML.Replace("prod", "region", regionElements); err != nil {
-		return xml.Deployment{}, fmt.Errorf("could not update region elements in deployment.xml: %w", err)
-	}
 	// TODO: Some sample apps come with production <test> elements, but not necessarily working production tests, we
 	//       therefore remove <test> elements here.
-	//       This can be improved by supporting <test> elements in xml package and allow specifying testing as part of
-	//       region prompt, e.g. region1;test,region2
+	//       This can be improved by allowing specifying testing as part of region prompt, e.g. region1;test,region2
+	return setRegions(deploymentXML, strings.Split(regions, ","), nil)
+}
+
+// setRegions replaces the production steps in deploymentXML with regions, where each region in testRegions is followed
+// by production tests.
+func setRegions(deploymentXML xml.Deployment, regions, testRegions []string) (xml.Deployment, error) {
 	if err := deploymentXML.Replace("prod", "test", nil); err != nil {
 		return xml.Deployment{}, fmt.Errorf("could not remove test elements in deployment.xml: %w", err)
 	}
+	if err := deploymentXML.Replace("prod", "region", xml.ProdSteps(regions, testRegions)); err != nil {
+		return xml.Deployment{}, fmt.Errorf("could not update region elements in deployment.xml: %w", err)
+	}
 	return deploymentXML, nil
 }
 
-func promptRegions(cli *CLI, stdin *bufio.Reader, deploymentXML xml.Deployment, system vespa.System) (string, error) {
-	fmt.Fprintln(cli.Stdout, color.CyanString("> Deployment regions"))
-	fmt.Fprintf(cli.Stdout, "Documentation: %s\n", color.GreenString("https://cloud.vespa.ai/en/reference/zones"))
-	fmt.Fprintf(cli.Stdout, "Example: %s\n\n", color.YellowString("aws-us-east-1c,aws-us-west-2a"))
-	var currentRegions []string
+func currentRegions(deploymentXML xml.Deployment) []string {
+	var regions []string
 	for _, r := range deploymentXML.Prod.Regions {
-		currentRegions = append(currentRegions, r.Name)
+		regions = append(regions, r.Name)
 	}
 	if len(deploymentXML.Instance) > 0 {
 		for _, r := range deploymentXML.Instance[0].Prod.Regions {
-			currentRegions = append(currentRegions, r.Name)
+			regions = append(regions, r.Name)
 		}
 	}
+	return regions
+}
+
+func promptRegions(cli *CLI, stdin *bufio.Reader, deploymentXML xml.Deployment, system vespa.System) (string, error) {
+	fmt.Fprintln(cli.Stdout, color.CyanString("> Deployment regions"))
+	fmt.Fprintf(cli.Stdout, "Documentation: %s\n", color.GreenString("https://cloud.vespa.ai/en/reference/zones"))
+	fmt.Fprintf(cli.Stdout, "Example: %s\n\n", color.YellowString("aws-us-east-1c,aws-us-west-2a"))
 	validator := func(input string) error {
 		regions := strings.Split(input, ",")
 		for _, r := range regions {
@@ -258,7 +1392,7 @@ func promptRegions(cli *CLI, stdin *bufio.Reader, deploymentXML xml.Deployment,
 		}
 		return nil
 	}
-	return prompt(cli, stdin, "Which regions do you wish to deploy in?", strings.Join(currentRegions, ","), validator)
+	return prompt(cli, stdin, "Which regions do you wish to deploy in?", strings.Join(currentRegions(deploymentXML), ","), validator)
 }
 
 func updateNodes(cli *CLI, r *bufio.Reader, servicesXML xml.Services) (xml.Services, error) {
@@ -288,54 +1422,59 @@ func promptNodes(cli *CLI, r *bufio.Reader, clusterID string, defaultValue xml.N
 	if err != nil {
 		return xml.Nodes{}, err
 	}
-	const autoSpec = "auto"
-	defaultSpec := autoSpec
-	resources := defaultValue.Resources
-	if resources != nil {
+	defaultSpec := autoResources
+	if defaultValue.Resources != nil {
 		defaultSpec = defaultValue.Resources.String()
 	}
 	spec, err := promptResources(cli, r, clusterID, defaultSpec)
 	if err != nil {
 		return xml.Nodes{}, err
 	}
-	if spec == autoSpec {
-		resources = nil
-	} else {
-		r, err := xml.ParseResources(spec)
-		if err != nil {
-			return xml.Nodes{}, err // Should not happen as resources have already been validated
-		}
-		resources = &r
+	return newNodes(count, spec)
+}
+
+const autoResources = "auto"
+
+// newNodes returns a nodes element with count nodes, with the resources given in spec, or none if spec is "auto".
+func newNodes(count, spec string) (xml.Nodes, error) {
+	if spec == autoResources {
+		return xml.Nodes{Count: count}, nil
+	}
+	resources, err := xml.ParseResources(spec)
+	if err != nil {
+		return xml.Nodes{}, err // Should not happen as resources have already been validated
+	}
+	return xml.Nodes{Count: count, Resources: &resources}, nil
+}
+
+func validateNodeCount(input string) error {
+	min, _, err := xml.ParseNodeCount(input)
+	if min < 2 {
+		return errHint(fmt.Errorf("at least 2 nodes are required for all clusters in a production environment, got %d", min), "See https://docs.vespa.ai/en/cloud/production-deployment.html")
+	}
+	return err
+}
+
+func validateResources(input string) error {
+	if input == autoResources {
+		return nil
 	}
-	return xml.Nodes{Count: count, Resources: resources}, nil
+	_, err := xml.ParseResources(input)
+	return err
 }
 
 func promptNodeCount(cli *CLI, stdin *bufio.Reader, clusterID string, nodeCount string) (string, error) {
 	fmt.Fprintln(cli.Stdout, color.CyanString("\n> Node count: "+clusterID+" cluster"))
 	fmt.Fprintf(cli.Stdout, "Documentation: %s\n", color.GreenString("https://docs.vespa.ai/en/reference/services"))
 	fmt.Fprintf(cli.Stdout, "Example: %s\nExample: %s\n\n", color.YellowString("4"), color.YellowString("[2,8]"))
-	validator := func(input string) error {
-		min, _, err := xml.ParseNodeCount(input)
-		if min < 2 {
-			return errHint(fmt.Errorf("at least 2 nodes are required for all clusters in a production environment, got %d", min), "See https://docs.vespa.ai/en/cloud/production-deployment.html")
-		}
-		return err
-	}
-	return prompt(cli, stdin, fmt.Sprintf("How many nodes should the %s cluster have?", color.CyanString(clusterID)), nodeCount, validator)
+	return prompt(cli, stdin, fmt.Sprintf("How many nodes should the %s cluster have?", color.CyanString(clusterID)), nodeCount, validateNodeCount)
 }
 
 func promptResources(cli *CLI, stdin *bufio.Reader, clusterID string, resources string) (string, error) {
 	fmt.Fprintln(cli.Stdout, color.CyanString("\n> Node resources: "+clusterID+" cluster"))
 	fmt.Fprintf(cli.Stdout, "Documentation: %s\n", color.GreenString("https://docs.vespa.ai/en/reference/services.html"))
 	fmt.Fprintf(cli.Stdout, "Example: %s\nExample: %s\n\n", color.YellowString("auto"), color.YellowString("vcpu=4,memory=8Gb,disk=100Gb"))
-	validator := func(input string) error {
-		if input == "auto" {
-			return nil
-		}
-		_, err := xml.ParseResources(input)
-		return err
-	}
-	return prompt(cli, stdin, fmt.Sprintf("Which resources should each node in the %s cluster have?", color.CyanString(clusterID)), resources, validator)
+	return prompt(cli, stdin, fmt.Sprintf("Which resources should each node in the %s cluster have?", color.CyanString(clusterID)), resources, validateResources)
 }
 
 func readDeploymentXML(pkg vespa.ApplicationPackage) (xml.Deployment, error) {
@@ -400,7 +1539,7 @@ func verifyTests(cli *CLI, app vespa.ApplicationPackage) error {
 		"production-test": false,
 	}
 	testPath := app.TestPath
-	if app.IsZip() {
+	if app.IsTestZip() {
 		path, err := app.Unzip(true)
 		if err != nil {
 			return err
@@ -430,6 +1569,6 @@ func verifyTest(cli *CLI, testsParent string, suite string, required bool) error
 		}
 		return nil
 	}
-	_, _, err = runTests(cli, testDirectory, true, nil)
+	_, err = runTests(cli, testDirectory, testOptions{dryRun: true})
 	return err
 }
@@ -0,0 +1,239 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// CI workflows deploying an application to dev and production
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/vespa-engine/vespa/client/go/internal/build"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// ciSecrets are the secrets a CI workflow reads credentials from, as environment variables of the same name.
+var ciSecrets = []string{"VESPA_CLI_API_KEY", "VESPA_CLI_DATA_PLANE_CERT", "VESPA_CLI_DATA_PLANE_KEY"}
+
+// ciFlavor is a kind of CI workflow, written to path, relative to the root of the repository.
+type ciFlavor struct {
+	path     string
+	mode     os.FileMode
+	template *template.Template
+}
+
+var ciFlavors = map[string]ciFlavor{
+	"github":  {path: filepath.Join(".github", "workflows", "vespa.yml"), mode: 0644, template: template.Must(template.New("github").Parse(githubWorkflow))},
+	"generic": {path: "vespa-ci.sh", mode: 0755, template: template.Must(template.New("generic").Parse(genericWorkflow))},
+}
+
+// ciWorkflow holds the values of a CI workflow template.
+type ciWorkflow struct {
+	Version     string
+	Application string
+	// Package is the path of the application package, relative to the root of the repository
+	Package string
+	Branch  string
+	Secrets []string
+}
+
+const githubWorkflow = `# Generated by vespa prod init --ci github
+#
+# Deploys the application to dev, and runs its system tests, for each pull
+# request, and submits it to production for each push to {{.Branch}}. Requires
+# the repository secrets {{range $i, $s := .Secrets}}{{if $i}}, {{end}}{{$s}}{{end}}.
+name: Vespa
+
+on:
+  pull_request:
+  push:
+    branches: [{{.Branch}}]
+
+env:
+  VESPA_CLI_VERSION: "{{.Version}}"
+{{- range .Secrets}}
+  {{.}}: ${{"{{"}} secrets.{{.}} {{"}}"}}
+{{- end}}
+
+jobs:
+  dev:
+    if: github.event_name == 'pull_request'
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - name: Install Vespa CLI
+        run: |
+          curl -fsSL "https://github.com/vespa-engine/vespa/releases/download/v${VESPA_CLI_VERSION}/vespa-cli_${VESPA_CLI_VERSION}_linux_amd64.tar.gz" | tar -xz
+          echo "$PWD/vespa-cli_${VESPA_CLI_VERSION}_linux_amd64/bin" >> "$GITHUB_PATH"
+      - name: Configure Vespa CLI
+        run: |
+          vespa config set target cloud
+          vespa config set application {{.Application}}
+      - name: Add data plane certificate
+        run: |
+          mkdir -p "{{.Package}}/security"
+          printf '%s\n' "$VESPA_CLI_DATA_PLANE_CERT" > "{{.Package}}/security/clients.pem"
+      - name: Deploy to dev
+        run: vespa deploy --wait 1800 "{{.Package}}"
+      - name: Run system tests
+        run: |
+          if [ -d "{{.Package}}/tests/system-test" ]; then
+            vespa test "{{.Package}}/tests/system-test"
+          fi
+
+  prod:
+    if: github.event_name == 'push'
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - name: Install Vespa CLI
+        run: |
+          curl -fsSL "https://github.com/vespa-engine/vespa/releases/download/v${VESPA_CLI_VERSION}/vespa-cli_${VESPA_CLI_VERSION}_linux_amd64.tar.gz" | tar -xz
+          echo "$PWD/vespa-cli_${VESPA_CLI_VERSION}_linux_amd64/bin" >> "$GITHUB_PATH"
+      - name: Configure Vespa CLI
+        run: |
+          vespa config set target cloud
+          vespa config set application {{.Application}}
+      - name: Add data plane certificate
+        run: |
+          mkdir -p "{{.Package}}/security"
+          printf '%s\n' "$VESPA_CLI_DATA_PLANE_CERT" > "{{.Package}}/security/clients.pem"
+      - name: Submit to production
+        run: vespa prod deploy "{{.Package}}"
+`
+
+const genericWorkflow = `#!/bin/sh
+# Generated by vespa prod init --ci generic
+#
+# Usage: vespa-ci.sh dev|prod
+#
+# Run this from the root of the repository. With dev, deploys the application
+# to dev, and runs its system tests. Run this for each change to be reviewed.
+# With prod, submits the application to production. Run this for each change
+# merged to {{.Branch}}. Requires the environment variables
+# {{range $i, $s := .Secrets}}{{if $i}}, {{end}}{{$s}}{{end}}.
+
+set -eu
+
+VESPA_CLI_VERSION="{{.Version}}"
+APPLICATION="{{.Application}}"
+PACKAGE="{{.Package}}"
+
+for name in {{range $i, $s := .Secrets}}{{if $i}} {{end}}{{$s}}{{end}}; do
+    if eval "[ -z \"\${$name:-}\" ]"; then
+        echo "Environment variable $name must be set" >&2
+        exit 1
+    fi
+done
+
+# Install the pinned version of Vespa CLI
+os=$(uname -s | tr '[:upper:]' '[:lower:]')
+case $(uname -m) in
+    x86_64 | amd64) arch=amd64 ;;
+    aarch64 | arm64) arch=arm64 ;;
+    *) echo "Unsupported architecture: $(uname -m)" >&2; exit 1 ;;
+esac
+name="vespa-cli_${VESPA_CLI_VERSION}_${os}_${arch}"
+install_dir="${TMPDIR:-/tmp}/$name"
+if [ ! -x "$install_dir/bin/vespa" ]; then
+    curl -fsSL "https://github.com/vespa-engine/vespa/releases/download/v${VESPA_CLI_VERSION}/${name}.tar.gz" | tar -xz -C "${TMPDIR:-/tmp}"
+fi
+PATH="$install_dir/bin:$PATH"
+
+vespa config set target cloud
+vespa config set application "$APPLICATION"
+
+# Add the data plane certificate to the application package
+mkdir -p "$PACKAGE/security"
+printf '%s\n' "$VESPA_CLI_DATA_PLANE_CERT" > "$PACKAGE/security/clients.pem"
+
+case "${1:-}" in
+    dev)
+        vespa deploy --wait 1800 "$PACKAGE"
+        if [ -d "$PACKAGE/tests/system-test" ]; then
+            vespa test "$PACKAGE/tests/system-test"
+        fi
+        ;;
+    prod)
+        vespa prod deploy "$PACKAGE"
+        ;;
+    *)
+        echo "Usage: $0 dev|prod" >&2
+        exit 1
+        ;;
+esac
+`
+
+// ciRepositoryRoot returns the root of the git repository holding pkg, or the directory of pkg if this is not known.
+func ciRepositoryRoot(cli *CLI, pkg vespa.ApplicationPackage) string {
+	if _, err := cli.exec.LookPath("git"); err == nil {
+		if out, err := cli.exec.Run("git", "-C", pkg.Path, "rev-parse", "--show-toplevel"); err == nil {
+			if root := strings.TrimSpace(string(out)); root != "" {
+				return root
+			}
+		}
+	}
+	return pkg.Path
+}
+
+// initCI writes a CI workflow of given flavor, deploying pkg to dev and production, to the repository holding pkg.
+// An existing workflow is only overwritten if force is true.
+func initCI(cli *CLI, flavorName string, force bool, pkg vespa.ApplicationPackage) error {
+	flavor, ok := ciFlavors[flavorName]
+	if !ok {
+		return errHint(fmt.Errorf("invalid CI flavor: %s", flavorName), `Must be "github" or "generic"`)
+	}
+	if pkg.IsZip() {
+		return errHint(fmt.Errorf("cannot add CI workflow for compressed application package '%s'", pkg.Path),
+			"Give the directory of the application package")
+	}
+	app, err := cli.config.application()
+	if err != nil {
+		return err
+	}
+	root := ciRepositoryRoot(cli, pkg)
+	absPkg, err := filepath.Abs(pkg.Path)
+	if err != nil {
+		return err
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	pkgPath, err := filepath.Rel(absRoot, absPkg)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(root, flavor.path)
+	if err := checkOverwrite(path, force); err != nil {
+		return err
+	}
+	if build.Version == "0.0.0-devel" {
+		cli.printWarning(fmt.Sprintf("Vespa CLI version %s is not a release", build.Version), "Set VESPA_CLI_VERSION in "+path+" to the version to use")
+	}
+	var buf bytes.Buffer
+	if err := flavor.template.Execute(&buf, ciWorkflow{
+		Version:     build.Version,
+		Application: app.String(),
+		Package:     filepath.ToSlash(pkgPath),
+		Branch:      "main",
+		Secrets:     ciSecrets,
+	}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), flavor.mode); err != nil {
+		return err
+	}
+	cli.printSuccess("Wrote ", color.CyanString(path))
+	fmt.Fprintln(cli.Stdout, "\nThe workflow reads credentials from these secrets, which must be added to the CI system:")
+	fmt.Fprintf(cli.Stdout, "  %s: the contents of the API key of tenant %s\n", ciSecrets[0], app.Tenant)
+	fmt.Fprintf(cli.Stdout, "  %s: the contents of the data plane certificate\n", ciSecrets[1])
+	fmt.Fprintf(cli.Stdout, "  %s: the contents of the data plane private key\n", ciSecrets[2])
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Source metadata of production submissions
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// gitSource is the metadata of the commit checked out in a git repository.
+type gitSource struct {
+	commit      string
+	branch      string
+	authorEmail string
+	subject     string
+}
+
+// readGitSource reads the metadata of the commit checked out in the git repository holding dir. The branch is empty
+// if HEAD is detached.
+func readGitSource(cli *CLI, dir string) (gitSource, error) {
+	if _, err := cli.exec.LookPath("git"); err != nil {
+		return gitSource{}, fmt.Errorf("git is not installed")
+	}
+	out, err := cli.exec.Run("git", "-C", dir, "log", "-1", "--format=%H%n%ae%n%D%n%s")
+	if err != nil {
+		return gitSource{}, fmt.Errorf("%s is not in a git repository with commits", dir)
+	}
+	lines := strings.SplitN(strings.TrimRight(string(out), "\n"), "\n", 4)
+	if len(lines) < 4 || lines[0] == "" {
+		return gitSource{}, fmt.Errorf("unexpected output from git log: %q", string(out))
+	}
+	source := gitSource{commit: lines[0], authorEmail: lines[1], subject: lines[3]}
+	for _, ref := range strings.Split(lines[2], ", ") {
+		if branch, ok := strings.CutPrefix(ref, "HEAD -> "); ok {
+			source.branch = branch
+		}
+	}
+	return source, nil
+}
+
+// sourceDir returns the directory whose git repository holds the source of the application package given by args.
+// This is the working directory, unless a local application package is given. False is returned if the application
+// package is remote, as its source is then unknown.
+func sourceDir(args []string, options remotePackageOptions) (string, bool) {
+	if len(args) == 0 {
+		return ".", true
+	}
+	if _, remote := remotePackageURL(args[0], options); remote {
+		return "", false
+	}
+	if ioutil.IsDir(args[0]) {
+		return args[0], true
+	}
+	return filepath.Dir(args[0]), true
+}
+
+// prodSubmission returns the submission described by options, where the source metadata not given in options is read from
+// the git repository holding the local application package given by args. Metadata is only read when options give no
+// commit, or the commit checked out. If this metadata cannot be read, and no commit is given in options, a warning is
+// printed.
+func prodSubmission(cli *CLI, options prodDeployOptions, args []string) vespa.Submission {
+	s := vespa.Submission{
+		Risk:        options.risk,
+		Commit:      options.commit,
+		Branch:      options.branch,
+		Description: options.description,
+		AuthorEmail: options.authorEmail,
+		SourceURL:   options.sourceURL,
+	}
+	dir, local := sourceDir(args, options.remote)
+	if !local {
+		return s
+	}
+	source, err := readGitSource(cli, dir)
+	if err != nil {
+		if s.Commit == "" {
+			cli.printWarning(fmt.Sprintf("Could not read source metadata: %s", err),
+				"Give the source of the submission with --commit, --branch, --author-email and --description")
+		}
+		return s
+	}
+	if s.Commit != "" && s.Commit != source.commit {
+		return s // Metadata of another commit
+	}
+	s.Commit = source.commit
+	if s.Branch == "" {
+		s.Branch = source.branch
+	}
+	if s.AuthorEmail == "" {
+		s.AuthorEmail = source.authorEmail
+	}
+	if s.Description == "" {
+		s.Description = source.subject
+	}
+	return s
+}
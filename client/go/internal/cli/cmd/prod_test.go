@@ -3,10 +3,14 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -80,6 +84,161 @@ func TestProdInit(t *testing.T) {
 	assert.True(t, ioutil.Exists(servicesPath+".1.bak"))
 }
 
+func TestProdInitFlags(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, true)
+
+	cli, stdout, stderr := newTestCLI(t)
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("config", "set", "application", "foo.bar"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	stdout.Reset()
+	stderr.Reset()
+	assert.Nil(t, cli.Run("prod", "init", pkgDir,
+		"--zones", "prod.aws-us-west-2a,aws-eu-west-1a",
+		"--test-regions", "aws-eu-west-1a",
+		"--nodes", "qrs=[2,8]",
+		"--resources", "music=vcpu=16,memory=64Gb,disk=100Gb",
+		"--author-email", "alice@example.com",
+		"--add-tests=true"))
+	assert.NotContains(t, stderr.String(), "Error")
+	assert.NotContains(t, stdout.String(), "Which regions")
+
+	deploymentXML := readFileString(t, filepath.Join(pkgDir, "deployment.xml"))
+	assert.Equal(t, `<deployment version="1.0">
+  <notifications when="failing">
+    <email address="alice@example.com"></email>
+  </notifications>
+  <prod>
+    <region>aws-us-west-2a</region>
+    <region>aws-eu-west-1a</region>
+    <test>aws-eu-west-1a</test>
+  </prod>
+</deployment>
+`, deploymentXML)
+	servicesXML := readFileString(t, filepath.Join(pkgDir, "services.xml"))
+	assert.Contains(t, servicesXML, `<nodes count="[2,8]">
+      <resources vcpu="4" memory="8Gb" disk="100Gb"></resources>
+    </nodes>`)
+	assert.Contains(t, servicesXML, `<nodes count="4">
+      <resources vcpu="16" memory="64Gb" disk="100Gb"></resources>
+    </nodes>`)
+	for _, suite := range []string{"system-test", "staging-setup", "staging-test", "production-test"} {
+		assert.True(t, ioutil.Exists(filepath.Join(pkgDir, "tests", suite, suite+".json")), suite)
+	}
+	// Generated tests are valid
+	pkg, err := vespa.FindApplicationPackage(pkgDir, vespa.PackageOptions{})
+	require.Nil(t, err)
+	assert.Nil(t, verifyTests(cli, pkg))
+
+	// Existing tests are kept
+	stdout.Reset()
+	assert.Nil(t, cli.Run("prod", "init", pkgDir, "--add-tests=true"))
+	assert.Contains(t, stdout.String(), "Not writing system-test tests")
+
+	// Invalid values
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--zones", "dev.aws-us-east-1c"}, "invalid production zone dev.aws-us-east-1c"},
+		{[]string{"--zones", "aws-us-east-1c", "--test-regions", "aws-eu-west-1a"}, "invalid test region aws-eu-west-1a: not among the regions to deploy to: aws-us-east-1c"},
+		{[]string{"--nodes", "foo=2"}, "invalid value for --nodes: no cluster foo in services.xml"},
+		{[]string{"--nodes", "qrs"}, `invalid value for --nodes: "qrs" must be on the form cluster=value`},
+		{[]string{"--nodes", "qrs=1"}, "invalid value for --nodes qrs: at least 2 nodes are required"},
+		{[]string{"--resources", "qrs=foo"}, `invalid value for --resources qrs: invalid resources: "foo"`},
+	}
+	for _, tt := range tests {
+		cli, _, stderr := newTestCLI(t)
+		assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+		assert.Nil(t, cli.Run("config", "set", "application", "foo.bar"))
+		assert.Nil(t, cli.Run("auth", "api-key"))
+		assert.NotNil(t, cli.Run(append([]string{"prod", "init", pkgDir}, tt.args...)...))
+		assert.Contains(t, stderr.String(), "Error: "+tt.err)
+	}
+}
+
+func TestProdInitCI(t *testing.T) {
+	repoDir := t.TempDir()
+	pkgDir := filepath.Join(repoDir, "app")
+	createApplication(t, pkgDir, false, true)
+
+	deploymentXML := readFileString(t, filepath.Join(pkgDir, "deployment.xml"))
+
+	cli, stdout, stderr := newTestCLI(t)
+	assert.Nil(t, cli.Run("config", "set", "application", "foo.bar.ci"))
+	cli.exec = &mock.Exec{ProgramPath: "/usr/bin/git", CombinedOutput: repoDir + "\n"}
+	stdout.Reset()
+	require.Nil(t, cli.Run("prod", "init", pkgDir, "--ci", "github"))
+	workflowFile := filepath.Join(repoDir, ".github", "workflows", "vespa.yml")
+	assert.Contains(t, stdout.String(), "Success: Wrote "+workflowFile+"\n")
+	assert.Contains(t, stdout.String(), "VESPA_CLI_API_KEY: the contents of the API key of tenant foo\n")
+	assert.Equal(t, "Warning: Vespa CLI version 0.0.0-devel is not a release\nHint: Set VESPA_CLI_VERSION in "+workflowFile+" to the version to use\n", stderr.String())
+	workflow := readFileString(t, workflowFile)
+	assert.Contains(t, workflow, `  VESPA_CLI_VERSION: "0.0.0-devel"
+  VESPA_CLI_API_KEY: ${{ secrets.VESPA_CLI_API_KEY }}
+  VESPA_CLI_DATA_PLANE_CERT: ${{ secrets.VESPA_CLI_DATA_PLANE_CERT }}
+  VESPA_CLI_DATA_PLANE_KEY: ${{ secrets.VESPA_CLI_DATA_PLANE_KEY }}
+`)
+	assert.Contains(t, workflow, "vespa config set application foo.bar.ci\n")
+	assert.Contains(t, workflow, `run: vespa deploy --wait 1800 "app"`)
+	assert.Contains(t, workflow, `vespa test "app/tests/system-test"`)
+	assert.Contains(t, workflow, `run: vespa prod deploy "app"`)
+	// The application package is unchanged
+	assert.Equal(t, deploymentXML, readFileString(t, filepath.Join(pkgDir, "deployment.xml")))
+
+	// Existing workflows are only overwritten with --force
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("prod", "init", pkgDir, "--ci", "github"))
+	assert.Equal(t, "Error: refusing to overwrite "+workflowFile+"\nHint: Use --force to overwrite existing files\n", stderr.String())
+	require.Nil(t, cli.Run("prod", "init", pkgDir, "--ci", "github", "--force"))
+
+	// Without git, the workflow is written to the application package
+	cli.exec = &mock.Exec{}
+	require.Nil(t, cli.Run("prod", "init", pkgDir, "--ci", "generic"))
+	scriptFile := filepath.Join(pkgDir, "vespa-ci.sh")
+	script := readFileString(t, scriptFile)
+	assert.Contains(t, script, "APPLICATION=\"foo.bar.ci\"\nPACKAGE=\".\"\n")
+	assert.Contains(t, script, `vespa deploy --wait 1800 "$PACKAGE"`)
+	info, err := os.Stat(scriptFile)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// Invalid options
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("prod", "init", pkgDir, "--ci", "jenkins"))
+	assert.Equal(t, "Error: invalid CI flavor: jenkins\nHint: Must be \"github\" or \"generic\"\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("prod", "init", pkgDir, "--ci", "github", "--zones", "aws-us-east-1c"))
+	assert.Equal(t, "Error: option --ci cannot be combined with --zones\n", stderr.String())
+}
+
+func TestProdInitFlagsMissing(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	require.Nil(t, os.MkdirAll(pkgDir, 0755))
+	servicesXML := `<services version="1.0">
+  <container id="default" version="1.0">
+    <search/>
+  </container>
+</services>`
+	require.Nil(t, os.WriteFile(filepath.Join(pkgDir, "services.xml"), []byte(servicesXML), 0644))
+
+	cli, _, stderr := newTestCLI(t)
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("config", "set", "application", "foo.bar"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.NotNil(t, cli.Run("prod", "init", pkgDir, "--author-email", "alice@example.com"))
+	assert.Contains(t, stderr.String(), "Error: missing required flags: --zones, --nodes default=<count>\n")
+	assert.False(t, ioutil.Exists(filepath.Join(pkgDir, "deployment.xml")))
+
+	// Missing values are prompted for in an interactive terminal
+	cli.isTerminal = func() bool { return true }
+	cli.Stdin = bytes.NewBufferString("aws-us-east-1c\n3\n")
+	assert.Nil(t, cli.Run("prod", "init", pkgDir, "--author-email", "alice@example.com"))
+	assert.Contains(t, readFileString(t, filepath.Join(pkgDir, "deployment.xml")), "<region>aws-us-east-1c</region>")
+	assert.Contains(t, readFileString(t, filepath.Join(pkgDir, "services.xml")), `<nodes count="3"></nodes>`)
+}
+
 func readFileString(t *testing.T, filename string) string {
 	t.Helper()
 	content, err := os.ReadFile(filename)
@@ -163,24 +322,64 @@ func TestProdDeployWithoutCertificate(t *testing.T) {
 	createApplication(t, pkgDir, false, false)
 
 	httpClient := &mock.HTTPClient{}
-	cli, stdout, _ := newTestCLI(t, "CI=true")
+	cli, stdout, stderr := newTestCLI(t, "CI=true")
 	cli.httpClient = httpClient
 	app := vespa.ApplicationID{Tenant: "t1", Application: "a1", Instance: "i1"}
 	assert.Nil(t, cli.Run("config", "set", "application", app.String()))
 	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
 	assert.Nil(t, cli.Run("auth", "api-key"))
 	stdout.Reset()
+	stderr.Reset()
 	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
 
 	// We have clients.pem, but no key pair for the application
 	require.Nil(t, os.MkdirAll(filepath.Join(pkgDir, "security"), 0755))
 	require.Nil(t, os.WriteFile(filepath.Join(pkgDir, "security", "clients.pem"), []byte{}, 0644))
+	assert.NotNil(t, cli.Run("prod", "deploy", pkgDir))
+	assert.Equal(t, "fail certificate: security/clients.pem contains no certificates\n"+
+		"     Hint: Add a certificate with 'vespa auth cert add', or deploy with --add-cert\n"+
+		"Error: 1 of 4 pre-submission checks failed [INVALID_APPLICATION_PACKAGE]\n"+
+		"Hint: Fix the problems above, or skip the failing checks with --ignore-checks certificate\n", stderr.String())
+
+	keyPair, err := vespa.CreateKeyPair()
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(pkgDir, "security", "clients.pem"), keyPair.Certificate, 0644))
 	httpClient.NextResponseString(200, `{"build": 42}`)
 	assert.Nil(t, cli.Run("prod", "deploy", pkgDir))
 	assert.Contains(t, stdout.String(), "Success: Deployed '"+pkgDir+"' with build number 42")
 	assert.Contains(t, stdout.String(), "See https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment for deployment progress")
 }
 
+func TestProdDeployPrintDigest(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, false)
+
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true")
+	cli.httpClient = httpClient
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", pkgDir))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+
+	digestPattern := regexp.MustCompile(`(?m)^sha256:[0-9a-f]{64}$`)
+	stdout.Reset()
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	assert.Nil(t, cli.Run("prod", "deploy", "--print-digest", pkgDir))
+	digest := digestPattern.FindString(stdout.String())
+	require.NotEmpty(t, digest, stdout.String())
+
+	// Zipping the same files again gives the same digest
+	later := time.Now().Add(time.Hour)
+	require.Nil(t, os.Chtimes(filepath.Join(pkgDir, "services.xml"), later, later))
+	httpClient.NextResponseString(200, `{"build": 43}`)
+	stdout.Reset()
+	assert.Nil(t, cli.Run("prod", "deploy", "--print-digest", "--format", "json", pkgDir))
+	assert.Contains(t, stdout.String(), `"digest": "`+digest+`"`)
+	assert.Equal(t, digest, digestPattern.FindString(stderr.String()))
+}
+
 func TestProdDeployWithoutTests(t *testing.T) {
 	pkgDir := filepath.Join(t.TempDir(), "app")
 	createApplication(t, pkgDir, false, true)
@@ -222,12 +421,474 @@ func prodDeploy(pkgDir string, t *testing.T) {
 	assert.Contains(t, stdout.String(), "See https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment for deployment progress")
 }
 
+func TestProdDeployFollow(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, true)
+
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true", "NO_COLOR=true")
+	cli.httpClient = httpClient
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+	cli.retryInterval = 0
+	statusURL := "/application/v4/tenant/t1/application/a1/deployment"
+	status := func(testStatus, testStep, prodStatus, prodStep string) []byte {
+		prodRuns := ""
+		if prodStatus != "" {
+			prodRuns = `{"id": 7, "status": "` + prodStatus + `", "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployReal", "status": "` + prodStep + `"}]}`
+		}
+		return []byte(`{"steps": [
+  {"type": "instance", "instance": "i1"},
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "runs": [` + prodRuns + `]},
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": [
+    {"id": 3, "status": "success", "versions": {"targetApplication": {"build": 41}}},
+    {"id": 4, "status": "` + testStatus + `", "versions": {"targetApplication": {"build": 42}}, "steps": [
+      {"name": "deployTester", "status": "succeeded"}, {"name": "runTests", "status": "` + testStep + `"}]}
+  ]}
+]}`)
+	}
+
+	// Deployment succeeds
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("running", "unfinished", "", "")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/system-test/run/4?after=-1", Status: 200,
+		Body: []byte(`{"active": true, "status": "running", "lastId": 2, "log": {"runTests": [{"at": 1000, "type": "info", "message": "Running tests"}, {"at": 2000, "type": "debug", "message": "Hidden"}]}}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("success", "succeeded", "running", "unfinished")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/system-test/run/4?after=2", Status: 200,
+		Body: []byte(`{"active": false, "status": "success", "lastId": 3, "log": {"runTests": [{"at": 2500, "type": "info", "message": "Tests passed"}]}}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/production-aws-us-east-1c/run/7?after=-1", Status: 200,
+		Body: []byte(`{"active": true, "status": "running", "lastId": 1, "log": {"deployReal": [{"at": 3000, "type": "warning", "message": "Deploying"}]}}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("success", "succeeded", "success", "succeeded")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/production-aws-us-east-1c/run/7?after=1", Status: 200,
+		Body: []byte(`{"active": false, "status": "success", "lastId": 1}`)})
+	stdout.Reset()
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--follow", pkgDir))
+	assert.True(t, httpClient.Consumed())
+	out := stdout.String()
+	assert.Contains(t, out, "i1.system-test: run 4 is running, in step runTests\n")
+	assert.Contains(t, out, "] info    [i1.system-test] Running tests\n")
+	assert.NotContains(t, out, "Hidden")
+	assert.Contains(t, out, "i1.production-aws-us-east-1c: run 7 is running, in step deployReal\n")
+	assert.Contains(t, out, "] warning [i1.production-aws-us-east-1c] Deploying\n")
+	assert.Contains(t, out, "i1.system-test: run 4 succeeded\n")
+	assert.Contains(t, out, "] info    [i1.system-test] Tests passed\n")
+	assert.True(t, strings.HasSuffix(out, "i1.production-aws-us-east-1c: run 7 succeeded\nSuccess: Deployment of build 42 completed\n"), out)
+
+	// Deployment fails
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("success", "succeeded", "deploymentFailed", "failed")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/system-test/run/4?after=-1", Status: 200,
+		Body: []byte(`{"active": false, "status": "success", "lastId": 3}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/production-aws-us-east-1c/run/7?after=-1", Status: 200,
+		Body: []byte(`{"active": false, "status": "deploymentFailed", "lastId": 1, "log": {"deployReal": [{"at": 3000, "type": "error", "message": "Invalid application"}]}}`)})
+	stdout.Reset()
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--follow", pkgDir))
+	assert.Contains(t, stdout.String(), "i1.production-aws-us-east-1c: run 7 failed with status deploymentFailed, in step deployReal\n")
+	errOut := stderr.String()
+	assert.Contains(t, errOut, "] error   [i1.production-aws-us-east-1c] Invalid application\n")
+	assert.Contains(t, errOut, "Error: deployment of build 42 failed: i1.production-aws-us-east-1c run 7 ended with status deploymentFailed\n"+
+		"Hint: See https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment for details\n")
+
+	// Following times out
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("running", "unfinished", "", "")})
+	httpClient.NextResponseString(200, `{"active": true, "status": "running", "lastId": 2}`)
+	now := time.Now()
+	cli.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--follow", "--timeout", "10m", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: deployment of build 42 did not complete within 10m0s\n")
+}
+
+func TestProdDeployWaitJSON(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, true)
+
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true", "NO_COLOR=true")
+	cli.httpClient = httpClient
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+	cli.retryInterval = 0
+	cli.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	statusURL := "/application/v4/tenant/t1/application/a1/deployment"
+	status := func(runs string) []byte {
+		return []byte(`{"steps": [
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": [` + runs + `]},
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "runs": []}
+]}`)
+	}
+
+	// Build is accepted
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(`{"id": 3, "status": "success", "versions": {"targetApplication": {"build": 41}}}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(`{"id": 4, "status": "running", "versions": {"targetApplication": {"build": 42}}}`)})
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--wait", "--format", "json", "--commit", "abc123", "--source-url", "https://ci.example.com/build/7", pkgDir))
+	assert.True(t, httpClient.Consumed())
+	assert.Equal(t, `{
+  "build": 42,
+  "commit": "abc123",
+  "sourceUrl": "https://ci.example.com/build/7",
+  "submittedAt": "2024-01-02T03:04:05Z",
+  "consoleUrl": "https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment",
+  "rollout": "rolling-out",
+  "steps": [
+    {
+      "name": "Uploading application package",
+      "status": "done",
+      "durationSeconds": 0
+    }
+  ]
+}
+`, stdout.String())
+	assert.Contains(t, stderr.String(), "Success: Deployed '"+pkgDir+"' with build number 42\n")
+	assert.Contains(t, stderr.String(), "Success: Build 42 accepted: i1.system-test run 4 started\n")
+	require.Nil(t, httpClient.Requests[0].ParseMultipartForm(1<<20))
+	assert.Equal(t, `{"commit":"abc123","sourceUrl":"https://ci.example.com/build/7"}`, httpClient.Requests[0].FormValue("submitOptions"))
+
+	// Build is rejected
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(`{"id": 4, "status": "invalidApplication", "versions": {"targetApplication": {"build": 42}}}`)})
+	stdout.Reset()
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--wait", "--format", "human", pkgDir))
+	assert.Contains(t, stdout.String(), "Success: Deployed '"+pkgDir+"' with build number 42\n")
+	assert.Contains(t, stderr.String(), "Error: build 42 was rejected: i1.system-test run 4 ended with status invalidApplication\n")
+
+	// Build is not accepted in time
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("")})
+	now := time.Now()
+	cli.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--wait", "--timeout", "10m", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: build 42 was not accepted within 10m0s\n")
+
+	assert.NotNil(t, cli.Run("prod", "deploy", "--format", "foo", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: invalid format: foo\n")
+}
+
+func TestProdDeploySourceMetadata(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, true)
+	testDir := filepath.Join(t.TempDir(), "application-test")
+	writeTest(filepath.Join(testDir, "tests", "system-test", "test.json"), []byte(`{"steps":[{}]}`), t)
+
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true", "NO_COLOR=true")
+	cli.httpClient = httpClient
+	cli.exec = &mock.Exec{ProgramPath: "/usr/bin/git", CombinedOutput: "abc123\nauthor@example.com\nHEAD -> main, origin/main\nAdd a feature\n"}
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+	cli.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	statusURL := "/application/v4/tenant/t1/application/a1/deployment"
+
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--format", "json", "--branch", "release", "--test-package", testDir, pkgDir))
+	assert.Equal(t, `{
+  "build": 42,
+  "commit": "abc123",
+  "branch": "release",
+  "authorEmail": "author@example.com",
+  "description": "Add a feature",
+  "testPackage": "`+testDir+`",
+  "submittedAt": "2024-01-02T03:04:05Z",
+  "consoleUrl": "https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment",
+  "rollout": "rolling-out",
+  "steps": [
+    {
+      "name": "Uploading application package",
+      "status": "done",
+      "durationSeconds": 0
+    }
+  ]
+}
+`, stdout.String())
+	assert.NotContains(t, stderr.String(), "Warning")
+	request := httpClient.Requests[0]
+	require.Nil(t, request.ParseMultipartForm(1<<20))
+	assert.Equal(t, `{"commit":"abc123","branch":"release","description":"Add a feature","authorEmail":"author@example.com"}`, request.FormValue("submitOptions"))
+	assert.Len(t, request.MultipartForm.File["applicationTestZip"], 1)
+
+	// Metadata of another commit than the one checked out is not read from git
+	httpClient.NextResponseString(200, `{"build": 43}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: []byte(`{"steps": []}`)})
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--format", "human", "--branch", "", "--test-package", "", "--commit", "def456", pkgDir))
+	request = httpClient.Requests[2]
+	require.Nil(t, request.ParseMultipartForm(1<<20))
+	assert.Equal(t, `{"commit":"def456"}`, request.FormValue("submitOptions"))
+	assert.Empty(t, request.MultipartForm.File["applicationTestZip"])
+
+	assert.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--commit", "", "--test-package", filepath.Join(testDir, "missing"), pkgDir))
+	assert.Contains(t, stderr.String(), "Error: test package "+filepath.Join(testDir, "missing")+" does not exist\n")
+}
+
+func TestProdStatus(t *testing.T) {
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = httpClient
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+	cli.now = func() time.Time { return time.UnixMilli(1700001120000) }
+	response := `{"steps": [
+  {"type": "instance", "instance": "i1", "changeBlockers": [{"versions": true, "revisions": true, "window": {"days": ["sat", "sun"], "hours": [0, 1, 2, 3, 22, 23], "zone": "UTC"}}]},
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "environment": "prod", "region": "aws-us-east-1c",
+   "currentPlatform": "8.1.2", "currentApplication": {"build": 41}, "deployedAt": 1700000300000, "runs": [
+    {"id": 8, "status": "running", "start": 1700001000000, "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployReal", "status": "unfinished"}]}
+  ]},
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": [
+    {"id": 4, "status": "success", "start": 1700000000000, "end": 1700000300000, "versions": {"targetApplication": {"build": 42}}}
+  ]}
+]}`
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/deployment", Status: 200, Body: []byte(response)})
+	stdout.Reset()
+	require.Nil(t, cli.Run("prod", "status"))
+	assert.Equal(t, `INSTANCE  ZONE                 BUILD  VERSION  DEPLOYED                 JOB
+i1        system-test          -      -        -                        succeeded (run 4, build 42, 5m0s)
+i1        prod.aws-us-east-1c  41     8.1.2    2023-11-14 22:18:20 UTC  running in step deployReal (run 8, build 42, 2m0s)
+
+Change blockers:
+  i1: version upgrades and application revisions blocked on sat, sun, hours 0-3, 22-23 (UTC)
+`, stdout.String())
+
+	failedResponse := []byte(strings.Replace(response, `"status": "running"`, `"status": "deploymentFailed", "end": 1700001060000`, 1))
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/deployment", Status: 200, Body: failedResponse})
+	stdout.Reset()
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "status", "--format", "json")) // Fails for failing jobs
+	assert.Equal(t, "", stderr.String())
+	var result prodStatusResult
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	require.Equal(t, 2, len(result.Jobs))
+	assert.Equal(t, "prod.aws-us-east-1c", result.Jobs[1].Zone)
+	assert.Equal(t, int64(41), result.Jobs[1].Build)
+	assert.Equal(t, int64(60000), result.Jobs[1].LastRun.DurationMs)
+	assert.Equal(t, "deploymentFailed", result.Jobs[1].LastRun.Status)
+	assert.Equal(t, []int{0, 1, 2, 3, 22, 23}, result.ChangeBlockers[0].Hours)
+
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/deployment", Status: 200, Body: failedResponse})
+	stderr.Reset()
+	stdout.Reset()
+	require.NotNil(t, cli.Run("prod", "status", "--format", "human"))
+	assert.Contains(t, stdout.String(), "failed: deploymentFailed in step deployReal (run 8, build 42, 1m0s)\n")
+	assert.Equal(t, "Warning: jobs failing: i1.production-aws-us-east-1c\n"+
+		"Hint: Run 'vespa status deployment -a t1.a1.i1 -z prod.aws-us-east-1c 8' to show the log of the failing run of production-aws-us-east-1c\n", stderr.String())
+}
+
+func TestProdDeployBuild(t *testing.T) {
+	buildsURL := "/application/v4/tenant/t1/application/a1/build"
+	builds := []byte(`{"builds": [
+  {"build": 41, "commit": "abc123", "submittedAt": 1700000000000},
+  {"build": 42, "sourceUrl": "https://ci.example.com/42"}
+]}`)
+	newCLI := func() (*CLI, *bytes.Buffer, *bytes.Buffer, *mock.HTTPClient) {
+		httpClient := &mock.HTTPClient{}
+		cli, stdout, stderr := newTestCLI(t, "CI=true", "NO_COLOR=true")
+		cli.httpClient = httpClient
+		require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+		require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+		require.Nil(t, cli.Run("auth", "api-key"))
+		cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+		cli.retryInterval = 0
+		stdout.Reset()
+		stderr.Reset()
+		return cli, stdout, stderr, httpClient
+	}
+
+	// List builds
+	cli, stdout, _, httpClient := newCLI()
+	httpClient.NextResponse(mock.HTTPResponse{URI: buildsURL, Status: 200, Body: builds})
+	require.Nil(t, cli.Run("prod", "deploy", "--list-builds"))
+	assert.Equal(t, `BUILD  COMMIT  SUBMITTED
+42     -       -
+41     abc123  2023-11-14 22:13:20 UTC
+`, stdout.String())
+
+	cli, stdout, _, httpClient = newCLI()
+	httpClient.NextResponse(mock.HTTPResponse{URI: buildsURL, Status: 200, Body: builds})
+	require.Nil(t, cli.Run("prod", "deploy", "--list-builds", "--format", "json"))
+	assert.Equal(t, `[
+  {
+    "build": 42,
+    "sourceUrl": "https://ci.example.com/42"
+  },
+  {
+    "build": 41,
+    "commit": "abc123",
+    "submittedAt": "2023-11-14T22:13:20Z"
+  }
+]
+`, stdout.String())
+
+	// Deploy a build which does not exist
+	cli, _, stderr, httpClient := newCLI()
+	httpClient.NextResponse(mock.HTTPResponse{URI: buildsURL, Status: 200, Body: builds})
+	require.NotNil(t, cli.Run("prod", "deploy", "--build", "43"))
+	assert.Equal(t, "Error: build 43 does not exist for application t1.a1\nHint: See the builds which can be deployed with 'vespa prod deploy --list-builds'\n", stderr.String())
+	assert.True(t, httpClient.Consumed())
+
+	// Deploy and pin a build, and follow its deployment
+	statusURL := "/application/v4/tenant/t1/application/a1/deployment"
+	status := func(prodRuns string) []byte {
+		return []byte(`{"steps": [
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "runs": [` + prodRuns + `
+    {"id": 7, "status": "success", "versions": {"targetApplication": {"build": 41}}},
+    {"id": 5, "status": "success", "versions": {"targetApplication": {"build": 42}}}
+  ]},
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": [
+    {"id": 4, "status": "success", "versions": {"targetApplication": {"build": 42}}}
+  ]}
+]}`)
+	}
+	cli, stdout, _, httpClient = newCLI()
+	httpClient.NextResponse(mock.HTTPResponse{URI: buildsURL, Status: 200, Body: builds})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/deploying/application", Status: 200})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status("")})
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(`{"id": 8, "status": "success", "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployReal", "status": "succeeded"}]},`)})
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/job/production-aws-us-east-1c/run/8?after=-1", Status: 200,
+		Body: []byte(`{"active": false, "status": "success", "lastId": 1, "log": {"deployReal": [{"at": 3000, "type": "info", "message": "Deployed"}]}}`)})
+	require.Nil(t, cli.Run("prod", "deploy", "--build", "42", "--pin", "--follow"))
+	assert.True(t, httpClient.Consumed())
+	assert.Equal(t, "POST", httpClient.Requests[2].Method)
+	out := stdout.String()
+	assert.True(t, strings.HasPrefix(out, "Success: Triggered deployment of build 42 to instance i1, and pinned it until unpinned with 'vespa prod deploy --unpin'\n"+
+		"See https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment for deployment progress\n"+
+		"Build 42 is rolling out to instance i1 now\n"), out)
+	assert.NotContains(t, out, "system-test")
+	assert.Contains(t, out, "i1.production-aws-us-east-1c: run 8 succeeded\n")
+	assert.True(t, strings.HasSuffix(out, "] info    [i1.production-aws-us-east-1c] Deployed\nSuccess: Deployment of build 42 completed\n"), out)
+
+	// Unpin
+	cli, stdout, _, httpClient = newCLI()
+	httpClient.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/deploying/application/pin", Status: 200})
+	require.Nil(t, cli.Run("prod", "deploy", "--unpin"))
+	assert.Equal(t, "DELETE", httpClient.LastRequest.Method)
+	assert.Equal(t, "Success: Unpinned the build of instance i1. Newer submissions will be deployed to it\n", stdout.String())
+
+	// Invalid combinations
+	for _, args := range [][]string{
+		{"--build", "42", "--unpin"},
+		{"--pin"},
+		{"--build", "0"},
+		{"--build", "42", "my-app"},
+		{"--build", "42", "--set", "name=value"},
+		{"--list-builds", "--dry-run"},
+		{"--list-builds", "--follow"},
+	} {
+		cli, _, stderr, _ = newCLI()
+		require.NotNil(t, cli.Run(append([]string{"prod", "deploy"}, args...)...))
+		assert.Contains(t, stderr.String(), map[string]string{
+			"--unpin":    "Error: options --build and --unpin cannot be combined\n",
+			"--pin":      "Error: option --pin requires --build\n",
+			"0":          "Error: invalid build: 0: must be positive\n",
+			"my-app":     "Error: option --build cannot be combined with an application package\n",
+			"name=value": "Error: options --build and --set cannot be combined\n",
+			"--dry-run":  "Error: options --list-builds and --dry-run cannot be combined\n",
+			"--follow":   "Error: options --follow, --wait and --fail-if-blocked cannot be combined with --list-builds\n",
+		}[args[len(args)-1]])
+	}
+}
+
+func TestProdDeployBlocked(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false, true)
+
+	httpClient := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "CI=true", "NO_COLOR=true")
+	cli.httpClient = httpClient
+	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	assert.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	assert.Nil(t, cli.Run("auth", "api-key"))
+	assert.Nil(t, cli.Run("auth", "cert", "--no-add"))
+	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
+	// A Saturday, in the window blocking revisions
+	cli.now = func() time.Time { return time.Date(2024, 1, 6, 1, 30, 0, 0, time.UTC) }
+	opensAt := time.Date(2024, 1, 6, 4, 0, 0, 0, time.UTC).Local().Format("2006-01-02 15:04 MST")
+	statusURL := "/application/v4/tenant/t1/application/a1/deployment"
+	status := func(outstandingBuild int64) []byte {
+		return []byte(fmt.Sprintf(`{"steps": [
+  {"type": "instance", "instance": "i1", "outstandingChange": {"application": {"build": %d}}, "changeBlockers": [
+    {"versions": false, "revisions": true, "window": {"days": ["sat", "sun"], "hours": [0, 1, 2, 3], "zone": "UTC"}}
+  ]}
+]}`, outstandingBuild))
+	}
+
+	// Deployment is queued until the window ends
+	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(42)})
+	stdout.Reset()
+	stderr.Reset()
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--format", "json", pkgDir))
+	assert.Contains(t, stdout.String(), `  "rollout": "blocked",
+  "blockedUntil": "2024-01-06T04:00:00Z",
+`)
+	assert.Contains(t, stderr.String(), "Warning: Build 42 is held back from instance i1 by a change window until "+opensAt+"\n"+
+		"Hint: The build rolls out when the window ends. See the change windows with 'vespa prod status'\n")
+
+	// Deployment is not submitted while blocked
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(0)})
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--fail-if-blocked", pkgDir))
+	assert.True(t, httpClient.Consumed())
+	assert.Equal(t, 3, len(httpClient.Requests))
+	assert.Contains(t, stderr.String(), "Error: deployment to instance i1 is blocked by a change window until "+opensAt+"\n"+
+		"Hint: Deploy again when the window ends, or omit --fail-if-blocked to queue the change until then\n")
+
+	// Deployment rolls out outside the window, and fails if held back anyway
+	cli.now = func() time.Time { return time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC) }
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(0)})
+	httpClient.NextResponseString(200, `{"build": 43}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(0)})
+	stdout.Reset()
+	require.Nil(t, cli.Run("prod", "deploy", "--add-cert", "--fail-if-blocked", "--format", "human", pkgDir))
+	assert.Contains(t, stdout.String(), "Build 43 is rolling out to instance i1 now\n")
+
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(0)})
+	httpClient.NextResponseString(200, `{"build": 44}`)
+	httpClient.NextResponse(mock.HTTPResponse{URI: statusURL, Status: 200, Body: status(44)})
+	stderr.Reset()
+	require.NotNil(t, cli.Run("prod", "deploy", "--add-cert", "--fail-if-blocked", pkgDir))
+	assert.Contains(t, stderr.String(), "Error: build 44 is held back from instance i1 by a change window\n")
+}
+
 func TestProdDeployWithJava(t *testing.T) {
 	pkgDir := filepath.Join(t.TempDir(), "app")
 	createApplication(t, pkgDir, true, false)
 
 	httpClient := &mock.HTTPClient{}
 	httpClient.NextResponseString(200, `{"build": 42}`)
+	httpClient.NextResponseString(200, `{"steps": []}`)
 	cli, stdout, stderr := newTestCLI(t, "CI=true")
 	cli.httpClient = httpClient
 	assert.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
@@ -236,9 +897,12 @@ func TestProdDeployWithJava(t *testing.T) {
 	assert.Nil(t, cli.Run("auth", "cert", "--no-add"))
 
 	stdout.Reset()
+	stderr.Reset()
 	cli.Environment["VESPA_CLI_API_KEY_FILE"] = filepath.Join(cli.config.homeDir, "t1.api-key.pem")
 	assert.Nil(t, cli.Run("prod", "deploy", "--add-cert", pkgDir))
-	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, "Warning: Could not read source metadata: git is not installed\n"+
+		"Hint: Give the source of the submission with --commit, --branch, --author-email and --description\n"+
+		stepLines("Uploading application package", "done"), stderr.String())
 	assert.Contains(t, stdout.String(), "Success: Deployed '"+pkgDir+"/target/application' with build number 42")
 	assert.Contains(t, stdout.String(), "See https://console.vespa-cloud.com/tenant/t1/application/a1/prod/deployment for deployment progress")
 }
@@ -259,6 +923,7 @@ func TestProdDeployInvalidZip(t *testing.T) {
 	testAppDir := filepath.Join("testdata", "applications", "withInvalidEntries", "target")
 	zipFile := filepath.Join(testAppDir, "application.zip")
 
+	stderr.Reset()
 	assert.NotNil(t, cli.Run("prod", "deploy", zipFile))
 	assert.Equal(t, "Error: found invalid path inside zip: ../../../../../../../tmp/foo\n", stderr.String())
 }
@@ -0,0 +1,366 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/xml"
+)
+
+// maxSubmissionSize is the size limit of a zipped application package, as given by maxApplicationPackageSize of the
+// config servers.
+var maxSubmissionSize int64 = 8 << 30
+
+// submissionChecks are the names of the checks run on an application package before it is submitted, in order.
+var submissionChecks = []string{"deployment", "certificate", "tests", "size"}
+
+// submissionCheck is the result of a check of an application package, before it is submitted to Vespa Cloud.
+type submissionCheck struct {
+	Name    string   `json:"name"`
+	Passed  bool     `json:"passed"`
+	Skipped bool     `json:"skipped,omitempty"`
+	Message string   `json:"message"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+type submissionCheckJSON struct {
+	Path   string            `json:"path"`
+	Passed bool              `json:"passed"`
+	Checks []submissionCheck `json:"checks"`
+}
+
+func passed(name, format string, args ...any) submissionCheck {
+	return submissionCheck{Name: name, Passed: true, Message: fmt.Sprintf(format, args...)}
+}
+
+func failed(name, message string, hints ...string) submissionCheck {
+	return submissionCheck{Name: name, Message: message, Hints: hints}
+}
+
+func newProdValidateCmd(cli *CLI) *cobra.Command {
+	var (
+		ignoreChecks []string
+		testPackage  string
+		excludes     []string
+		sets         []string
+		format       string
+	)
+	cmd := &cobra.Command{
+		Use:   "validate [application-directory-or-file]",
+		Short: "Check an application package for problems which would make Vespa Cloud reject its submission",
+		Long: `Check an application package for problems which would make Vespa Cloud reject its submission.
+
+The checks are the same as those run by 'vespa prod deploy' before it uploads
+the application package, and do not require access to Vespa Cloud:
+
+deployment   deployment.xml exists, declares production regions for each
+             instance, declares each instance and region only once, with no
+             prod element outside the instance elements, and has production
+             tests only in regions which are deployed to
+certificate  security/clients.pem exists, and contains at least one certificate
+tests        the system and staging tests declared in deployment.xml exist,
+             i.e., tests/system-test for a test element, and tests/staging-setup
+             and tests/staging-test for a staging element, unless tests are
+             built with Java
+size         the zipped application package is within the size limit
+
+Each check prints whether it passed or failed, with hints on how to resolve a
+failure. The command fails if any check fails. Individual checks are skipped
+with --ignore-checks, which 'vespa prod deploy' also accepts.`,
+		Example: `$ vespa prod validate
+$ vespa prod validate --ignore-checks certificate,size
+$ vespa prod validate --format json target/application`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			if err := checkIgnoredChecks(ignoreChecks); err != nil {
+				return err
+			}
+			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{Compiled: true})
+			if err != nil {
+				return err
+			}
+			pkg.Exclude = excludes
+			if err := cli.applyTemplate(&pkg, sets, false); err != nil {
+				return err
+			}
+			if testPackage != "" {
+				if !ioutil.Exists(testPackage) {
+					return fmt.Errorf("test package %s does not exist", testPackage)
+				}
+				pkg.TestPath = testPackage
+			}
+			checks := checkSubmission(pkg, ignoreChecks)
+			if format == "json" {
+				result := submissionCheckJSON{Path: pkg.Path, Passed: len(failedChecks(checks)) == 0, Checks: checks}
+				if err := writeJSON(cli, result); err != nil {
+					return err
+				}
+			} else {
+				fmt.Fprintf(cli.Stdout, "Checking application package %s\n", color.CyanString(pkg.Path))
+				printSubmissionChecks(cli.Stdout, checks)
+			}
+			return submissionError(checks)
+		},
+	}
+	cmd.Flags().StringSliceVar(&ignoreChecks, "ignore-checks", nil, "Comma-separated checks to skip. Must be among "+strings.Join(submissionChecks, ", "))
+	cmd.Flags().StringVar(&testPackage, "test-package", "", "Directory or zip file with the system and staging tests of the application, built separately from the application package")
+	cmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Exclude files matching this pattern from the application package, in addition to those in .vespaignore. Can be repeated")
+	cmd.Flags().StringArrayVar(&sets, "set", nil, "Set the value of a placeholder in services.xml and deployment.xml, on the form name=value. Can be repeated")
+	cmd.Flags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	return cmd
+}
+
+// checkIgnoredChecks returns an error if any of the names given to --ignore-checks is not a check.
+func checkIgnoredChecks(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(submissionChecks, name) {
+			return errHint(fmt.Errorf("invalid check in --ignore-checks: %s", name), "Must be among "+strings.Join(submissionChecks, ", "))
+		}
+	}
+	return nil
+}
+
+// checkSubmission runs the checks of pkg before it is submitted, skipping those in ignored.
+func checkSubmission(pkg vespa.ApplicationPackage, ignored []string) []submissionCheck {
+	deploymentCheck, deploymentXML := checkDeploymentXML(pkg)
+	var checks []submissionCheck
+	for _, name := range submissionChecks {
+		if slices.Contains(ignored, name) {
+			checks = append(checks, submissionCheck{Name: name, Skipped: true, Message: "skipped by --ignore-checks"})
+			continue
+		}
+		switch name {
+		case "deployment":
+			checks = append(checks, deploymentCheck)
+		case "certificate":
+			checks = append(checks, checkClientsPEM(pkg))
+		case "tests":
+			checks = append(checks, checkTestLayout(pkg, deploymentXML))
+		case "size":
+			checks = append(checks, checkPackageSize(pkg))
+		}
+	}
+	return checks
+}
+
+// verifySubmission runs the checks of pkg before it is submitted, and prints those which failed, if any.
+func verifySubmission(cli *CLI, pkg vespa.ApplicationPackage, ignored []string) error {
+	checks := checkSubmission(pkg, ignored)
+	printSubmissionChecks(cli.Stderr, failedChecks(checks))
+	return submissionError(checks)
+}
+
+func failedChecks(checks []submissionCheck) []submissionCheck {
+	var failures []submissionCheck
+	for _, check := range checks {
+		if !check.Passed && !check.Skipped {
+			failures = append(failures, check)
+		}
+	}
+	return failures
+}
+
+func submissionError(checks []submissionCheck) error {
+	var names []string
+	for _, check := range failedChecks(checks) {
+		names = append(names, check.Name)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return errCode(codeInvalidApplicationPackage, fmt.Errorf("%d of %d pre-submission checks failed", len(names), len(checks)),
+		"Fix the problems above, or skip the failing checks with --ignore-checks "+strings.Join(names, ","))
+}
+
+func printSubmissionChecks(w io.Writer, checks []submissionCheck) {
+	for _, check := range checks {
+		status := color.GreenString("pass")
+		switch {
+		case check.Skipped:
+			status = color.YellowString("skip")
+		case !check.Passed:
+			status = color.RedString("fail")
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", status, check.Name, check.Message)
+		for _, hint := range check.Hints {
+			fmt.Fprintf(w, "     %s %s\n", color.CyanString("Hint:"), hint)
+		}
+	}
+}
+
+// checkDeploymentXML checks that deployment.xml of pkg declares a consistent set of instances and production regions.
+// The parsed deployment.xml is returned, if it could be read.
+func checkDeploymentXML(pkg vespa.ApplicationPackage) (submissionCheck, *xml.Deployment) {
+	const name = "deployment"
+	data, err := pkg.ReadFile("deployment.xml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return failed(name, "no deployment.xml found", "Try creating one with vespa prod init"), nil
+	} else if err != nil {
+		return failed(name, fmt.Sprintf("could not read deployment.xml: %s", err)), nil
+	}
+	deploymentXML, err := xml.ReadDeployment(bytes.NewReader(data))
+	if err != nil {
+		return failed(name, fmt.Sprintf("invalid deployment.xml: %s", err), "See https://docs.vespa.ai/en/reference/deployment.html"), nil
+	}
+	instances := deploymentXML.Instance
+	if len(instances) == 0 {
+		instances = []xml.Instance{{Prod: deploymentXML.Prod}}
+	} else if len(deploymentXML.Prod.AllRegions()) > 0 {
+		return failed(name, "deployment.xml has a prod element outside its instance elements",
+			"Move the prod element into each of the instance elements it applies to"), &deploymentXML
+	}
+	var ids []string
+	regionCount := 0
+	for _, instance := range instances {
+		where := "deployment.xml"
+		if instance.ID != "" {
+			where = fmt.Sprintf("instance %s in deployment.xml", instance.ID)
+			for _, id := range strings.Split(instance.ID, ",") {
+				id = strings.TrimSpace(id)
+				if slices.Contains(ids, id) {
+					return failed(name, fmt.Sprintf("instance %s is declared more than once in deployment.xml", id),
+						"Give each instance element its own id"), &deploymentXML
+				}
+				ids = append(ids, id)
+			}
+		}
+		regions := instance.Prod.AllRegions()
+		if len(regions) == 0 {
+			return failed(name, fmt.Sprintf("%s declares no production regions", where),
+				"Add the regions to deploy to in a prod element, e.g. <prod><region>aws-us-east-1c</region></prod>",
+				"Try setting the regions with vespa prod init"), &deploymentXML
+		}
+		var names []string
+		for _, region := range regions {
+			if slices.Contains(names, region.Name) {
+				return failed(name, fmt.Sprintf("region %s is declared more than once in %s", region.Name, where),
+					"Each region is deployed to only once per instance"), &deploymentXML
+			}
+			names = append(names, region.Name)
+		}
+		for _, test := range instance.Prod.AllTests() {
+			if !slices.Contains(names, test.Name) {
+				return failed(name, fmt.Sprintf("production test in region %s in %s, which is not deployed to", test.Name, where),
+					"Production tests run in regions deployed to before them, see https://docs.vespa.ai/en/reference/deployment.html#test"), &deploymentXML
+			}
+		}
+		regionCount += len(regions)
+	}
+	return passed(name, "deployment.xml declares %d production %s in %d %s", regionCount, plural(regionCount, "region"),
+		len(instances), plural(len(instances), "instance")), &deploymentXML
+}
+
+// checkClientsPEM checks that security/clients.pem of pkg contains at least one certificate.
+func checkClientsPEM(pkg vespa.ApplicationPackage) submissionCheck {
+	const name = "certificate"
+	hint := "Add a certificate with 'vespa auth cert add', or deploy with --add-cert"
+	data, err := pkg.ReadFile("security/clients.pem")
+	if errors.Is(err, fs.ErrNotExist) {
+		return failed(name, "security/clients.pem not found in the application package", hint,
+			"See https://docs.vespa.ai/en/cloud/security/guide.html")
+	} else if err != nil {
+		return failed(name, fmt.Sprintf("could not read security/clients.pem: %s", err))
+	}
+	count := 0
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return failed(name, fmt.Sprintf("invalid certificate in security/clients.pem: %s", err), hint)
+		}
+		count++
+	}
+	if count == 0 {
+		return failed(name, "security/clients.pem contains no certificates", hint)
+	}
+	return passed(name, "security/clients.pem contains %d %s", count, plural(count, "certificate"))
+}
+
+// checkTestLayout checks that pkg has the system and staging tests declared by deploymentXML, if any.
+func checkTestLayout(pkg vespa.ApplicationPackage, deploymentXML *xml.Deployment) submissionCheck {
+	const name = "tests"
+	if deploymentXML == nil {
+		return submissionCheck{Name: name, Skipped: true, Message: "skipped, as deployment.xml could not be read"}
+	}
+	systemTest := deploymentXML.SystemTest != nil
+	stagingTest := deploymentXML.StagingTest != nil
+	for _, instance := range deploymentXML.Instance {
+		systemTest = systemTest || instance.SystemTest != nil
+		stagingTest = stagingTest || instance.StagingTest != nil
+	}
+	var kinds, suites []string
+	if systemTest {
+		kinds = append(kinds, "system")
+		suites = append(suites, "system-test")
+	}
+	if stagingTest {
+		kinds = append(kinds, "staging")
+		suites = append(suites, "staging-setup", "staging-test")
+	}
+	if len(kinds) == 0 {
+		return passed(name, "deployment.xml declares no system or staging tests")
+	}
+	declared := fmt.Sprintf("deployment.xml declares %s tests", strings.Join(kinds, " and "))
+	if !pkg.HasTests() {
+		return failed(name, declared+", but the application package has no tests",
+			"Add skeleton tests with 'vespa prod init --add-tests', or build Java tests with 'mvn package'",
+			"See https://docs.vespa.ai/en/reference/testing.html")
+	}
+	testsDir := filepath.Join(pkg.TestPath, "tests")
+	if pkg.IsTestZip() || !ioutil.IsDir(testsDir) {
+		return passed(name, "tests are built with Java in %s", pkg.TestPath)
+	}
+	var missing []string
+	for _, suite := range suites {
+		if files, _ := filepath.Glob(filepath.Join(testsDir, suite, "*.json")); len(files) == 0 {
+			missing = append(missing, filepath.Join(testsDir, suite))
+		}
+	}
+	if len(missing) > 0 {
+		return failed(name, fmt.Sprintf("%s, but no tests were found in %s", declared, strings.Join(missing, ", ")),
+			"Add skeleton tests with 'vespa prod init --add-tests'",
+			"See https://docs.vespa.ai/en/reference/testing.html")
+	}
+	return passed(name, "found the %s tests in %s", strings.Join(kinds, " and "), testsDir)
+}
+
+// checkPackageSize checks that pkg, zipped, is within the size limit for submissions.
+func checkPackageSize(pkg vespa.ApplicationPackage) submissionCheck {
+	const name = "size"
+	stats, err := pkg.Stats()
+	if err != nil {
+		return failed(name, fmt.Sprintf("could not zip the application package: %s", err))
+	}
+	if stats.Size > maxSubmissionSize {
+		return failed(name, fmt.Sprintf("the application package is %s, which is more than the limit of %s", formatSize(stats.Size), formatSize(maxSubmissionSize)),
+			"Leave out files not needed by the application with .vespaignore or --exclude")
+	}
+	return passed(name, "the application package is %s, within the limit of %s", formatSize(stats.Size), formatSize(maxSubmissionSize))
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
@@ -0,0 +1,123 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+func writeSubmission(t *testing.T, deploymentXML string, withCertificate bool, suites ...string) string {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	writeTest(filepath.Join(pkgDir, "services.xml"), []byte(`<services version="1.0"><container id="default" version="1.0"/></services>`), t)
+	writeTest(filepath.Join(pkgDir, "deployment.xml"), []byte(deploymentXML), t)
+	if withCertificate {
+		keyPair, err := vespa.CreateKeyPair()
+		require.Nil(t, err)
+		writeTest(filepath.Join(pkgDir, "security", "clients.pem"), keyPair.Certificate, t)
+	}
+	for _, suite := range suites {
+		writeTest(filepath.Join(pkgDir, "tests", suite, "test.json"), []byte(`{"steps":[]}`), t)
+	}
+	return pkgDir
+}
+
+func TestProdValidate(t *testing.T) {
+	deploymentXML := `<deployment version="1.0">
+  <instance id="beta">
+    <test/>
+    <prod><region>aws-us-east-1c</region></prod>
+  </instance>
+  <instance id="default">
+    <staging/>
+    <prod>
+      <parallel><region>aws-us-east-1c</region><region>aws-eu-west-1a</region></parallel>
+      <test>aws-eu-west-1a</test>
+    </prod>
+  </instance>
+</deployment>`
+	pkgDir := writeSubmission(t, deploymentXML, true, "system-test", "staging-setup", "staging-test")
+	cli, stdout, stderr := newTestCLI(t)
+	require.Nil(t, cli.Run("prod", "validate", pkgDir))
+	assert.Regexp(t, `^Checking application package `+pkgDir+`
+pass deployment: deployment.xml declares 3 production regions in 2 instances
+pass certificate: security/clients.pem contains 1 certificate
+pass tests: found the system and staging tests in `+filepath.Join(pkgDir, "tests")+`
+pass size: the application package is \d+\.\d KiB, within the limit of 8.0 GiB
+$`, stdout.String())
+	assert.Equal(t, "", stderr.String())
+
+	// Failing checks are reported together, and can be skipped
+	pkgDir = writeSubmission(t, `<deployment version="1.0"><test/><prod><region>aws-us-east-1c</region><region>aws-us-east-1c</region></prod></deployment>`, false)
+	cli, stdout, stderr = newTestCLI(t)
+	maxSubmissionSize = 100
+	t.Cleanup(func() { maxSubmissionSize = 8 << 30 })
+	require.NotNil(t, cli.Run("prod", "validate", pkgDir))
+	assert.Regexp(t, `^Checking application package `+pkgDir+`
+fail deployment: region aws-us-east-1c is declared more than once in deployment.xml
+     Hint: Each region is deployed to only once per instance
+fail certificate: security/clients.pem not found in the application package
+     Hint: Add a certificate with 'vespa auth cert add', or deploy with --add-cert
+     Hint: See https://docs.vespa.ai/en/cloud/security/guide.html
+fail tests: deployment.xml declares system tests, but the application package has no tests
+     Hint: Add skeleton tests with 'vespa prod init --add-tests', or build Java tests with 'mvn package'
+     Hint: See https://docs.vespa.ai/en/reference/testing.html
+fail size: the application package is \d+ B, which is more than the limit of 100 B
+     Hint: Leave out files not needed by the application with .vespaignore or --exclude
+$`, stdout.String())
+	assert.Equal(t, "Error: 4 of 4 pre-submission checks failed [INVALID_APPLICATION_PACKAGE]\n"+
+		"Hint: Fix the problems above, or skip the failing checks with --ignore-checks deployment,certificate,tests,size\n", stderr.String())
+
+	cli, stdout, _ = newTestCLI(t)
+	require.Nil(t, cli.Run("prod", "validate", "--ignore-checks", "deployment,certificate,tests,size", "--format", "json", pkgDir))
+	var result submissionCheckJSON
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &result))
+	assert.True(t, result.Passed)
+	assert.Equal(t, 4, len(result.Checks))
+	assert.Equal(t, submissionCheck{Name: "size", Skipped: true, Message: "skipped by --ignore-checks"}, result.Checks[3])
+
+	cli, _, stderr = newTestCLI(t)
+	require.NotNil(t, cli.Run("prod", "validate", "--ignore-checks", "regions", pkgDir))
+	assert.Equal(t, "Error: invalid check in --ignore-checks: regions\nHint: Must be among deployment, certificate, tests, size\n", stderr.String())
+}
+
+func TestProdValidateDeploymentXML(t *testing.T) {
+	tests := []struct {
+		deploymentXML string
+		message       string
+	}{
+		{`<deployment version="1.0"><prod/></deployment>`, "deployment.xml declares no production regions"},
+		{`<deployment version="1.0"><instance id="a"><prod><region>aws-us-east-1c</region></prod></instance><instance id="b"/></deployment>`,
+			"instance b in deployment.xml declares no production regions"},
+		{`<deployment version="1.0"><instance id="a,b"><prod><region>aws-us-east-1c</region></prod></instance><instance id="b"><prod><region>aws-us-east-1c</region></prod></instance></deployment>`,
+			"instance b is declared more than once in deployment.xml"},
+		{`<deployment version="1.0"><instance id="a"/><prod><region>aws-us-east-1c</region></prod></deployment>`,
+			"deployment.xml has a prod element outside its instance elements"},
+		{`<deployment version="1.0"><prod><region>aws-us-east-1c</region><test>aws-us-west-2a</test></prod></deployment>`,
+			"production test in region aws-us-west-2a in deployment.xml, which is not deployed to"},
+		{`<deployment version="1.0"><prod>`, "invalid deployment.xml: XML syntax error on line 1: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		pkg := vespa.ApplicationPackage{Path: writeSubmission(t, tt.deploymentXML, false)}
+		check, _ := checkDeploymentXML(pkg)
+		assert.False(t, check.Passed, tt.deploymentXML)
+		assert.Equal(t, tt.message, check.Message)
+	}
+
+	// Tests declared for an instance must exist
+	pkgDir := writeSubmission(t, `<deployment version="1.0"><instance id="a"><staging/><prod><region>aws-us-east-1c</region></prod></instance></deployment>`, true, "staging-test")
+	pkg := vespa.ApplicationPackage{Path: pkgDir, TestPath: pkgDir}
+	_, deploymentXML := checkDeploymentXML(pkg)
+	check := checkTestLayout(pkg, deploymentXML)
+	assert.Equal(t, "deployment.xml declares staging tests, but no tests were found in "+filepath.Join(pkgDir, "tests", "staging-setup"), check.Message)
+
+	// Java tests are not inspected
+	require.Nil(t, os.RemoveAll(filepath.Join(pkgDir, "tests")))
+	check = checkTestLayout(pkg, deploymentXML)
+	assert.True(t, check.Passed)
+}
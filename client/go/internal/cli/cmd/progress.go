@@ -0,0 +1,145 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Progress of large uploads
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
+)
+
+const (
+	// progressDelay is the time an upload must take before its progress is shown in a terminal.
+	progressDelay = 500 * time.Millisecond
+	// progressBarInterval is the time between updates of a progress bar.
+	progressBarInterval = 100 * time.Millisecond
+	// progressLineInterval is the time between progress lines, when not writing to a terminal.
+	progressLineInterval = 10 * time.Second
+	// progressBarWidth is the number of characters in a progress bar.
+	progressBarWidth = 30
+	// documentProgressSize is the size of document operation bodies whose upload progress is shown.
+	documentProgressSize = 1 << 20
+)
+
+// progressReader reports the progress of reading a body of known size. In a terminal, it draws a progress bar, once
+// reading has taken progressDelay. Otherwise, it writes a line of progress every progressLineInterval. Nothing is
+// written for bodies which are read quickly.
+type progressReader struct {
+	r        io.Reader
+	cli      *CLI
+	message  string
+	size     int64
+	read     int64
+	terminal bool
+	now      func() time.Time
+	started  time.Time
+	reported time.Time
+	shown    bool
+	done     bool
+}
+
+// progressReader returns a reader which reads r, of size bytes, and reports the progress of this to standard error,
+// prefixed by message. Progress is not reported when quiet, or printing JSON.
+func (c *CLI) progressReader(r io.Reader, size int64, message string) io.Reader {
+	if c.config.isQuiet() || c.jsonOutput() || size <= 0 {
+		return r
+	}
+	_, screwdriver := c.Environment["SCREWDRIVER"]
+	now := c.now()
+	return &progressReader{
+		r:        r,
+		cli:      c,
+		message:  message,
+		size:     size,
+		terminal: c.isTerminal() && !screwdriver,
+		now:      c.now,
+		started:  now,
+		reported: now,
+	}
+}
+
+// uploadFunc returns a function reporting the progress of uploading an application package.
+func (c *CLI) uploadFunc() func(io.Reader, int64) io.Reader {
+	return func(r io.Reader, size int64) io.Reader {
+		return c.progressReader(r, size, "Uploading application package")
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.done {
+		return n, err
+	}
+	now := p.now()
+	if err != nil || p.read >= p.size {
+		p.done = true
+		if p.shown {
+			p.report(now)
+			if s := p.cli.activeSpinner; p.terminal && s != nil {
+				s.setProgress("")
+			} else if p.terminal {
+				fmt.Fprintln(p.cli.Stderr)
+			}
+		}
+		return n, err
+	}
+	interval, delay := progressLineInterval, progressLineInterval
+	if p.terminal {
+		interval, delay = progressBarInterval, progressDelay
+	}
+	if now.Sub(p.started) >= delay && now.Sub(p.reported) >= interval {
+		p.report(now)
+	}
+	return n, err
+}
+
+func (p *progressReader) report(now time.Time) {
+	p.shown = true
+	p.reported = now
+	elapsed := now.Sub(p.started)
+	fraction := min(1, float64(p.read)/float64(p.size))
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.read) / elapsed.Seconds()
+	}
+	eta := "-"
+	if rate > 0 {
+		eta = time.Duration(float64(p.size-p.read) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	amount := fmt.Sprintf("%3.0f%% %s of %s, %s/s, ETA %s", fraction*100, formatSize(p.read), formatSize(p.size), formatSize(int64(rate)), eta)
+	if !p.terminal {
+		fmt.Fprintf(p.cli.Stderr, "%s: %s\n", p.message, amount)
+		return
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] " + amount
+	if s := p.cli.activeSpinner; s != nil {
+		s.setProgress(" " + bar)
+	} else {
+		fmt.Fprintf(p.cli.Stderr, "\r\x1b[K%s %s", p.message, bar)
+	}
+}
+
+// progressClient is a HTTP client which reports the progress of uploading large request bodies.
+type progressClient struct {
+	httputil.Client
+	cli     *CLI
+	message string
+	minSize int64
+}
+
+func (c *progressClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	if request.Body != nil && request.ContentLength >= c.minSize {
+		request = request.Clone(request.Context())
+		request.Body = struct {
+			io.Reader
+			io.Closer
+		}{c.cli.progressReader(request.Body, request.ContentLength, c.message), request.Body}
+	}
+	return c.Client.Do(request, timeout)
+}
@@ -0,0 +1,95 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"encoding/base64"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+// readChunks reads all of r, size bytes at a time.
+func readChunks(t *testing.T, r io.Reader, size int) int {
+	t.Helper()
+	buf := make([]byte, size)
+	total := 0
+	for {
+		n, err := r.Read(buf)
+		total += n
+		if err == io.EOF {
+			return total
+		}
+		require.Nil(t, err)
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	now := time.Now()
+	cli.now = func() time.Time {
+		now = now.Add(4 * time.Second)
+		return now
+	}
+	r := cli.progressReader(strings.NewReader(strings.Repeat("a", 4096)), 4096, "Uploading")
+	assert.Equal(t, 4096, readChunks(t, r, 512))
+	assert.Equal(t, "Uploading:  38% 1.5 KiB of 4.0 KiB, 128 B/s, ETA 20s\n"+
+		"Uploading:  75% 3.0 KiB of 4.0 KiB, 128 B/s, ETA 8s\n"+
+		"Uploading: 100% 4.0 KiB of 4.0 KiB, 128 B/s, ETA 0s\n", stderr.String())
+
+	// Nothing is written for quick uploads
+	stderr.Reset()
+	cli.now = time.Now
+	_, err := io.ReadAll(cli.progressReader(strings.NewReader("foo"), 3, "Uploading"))
+	require.Nil(t, err)
+	assert.Equal(t, "", stderr.String())
+
+	// A progress bar is drawn in a terminal
+	cli.isTerminal = func() bool { return true }
+	cli.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	readChunks(t, cli.progressReader(strings.NewReader(strings.Repeat("a", 2048)), 2048, "Uploading"), 512)
+	assert.Equal(t, "\r\x1b[KUploading [=======                       ]  25% 512 B of 2.0 KiB, 512 B/s, ETA 3s"+
+		"\r\x1b[KUploading [===============               ]  50% 1.0 KiB of 2.0 KiB, 512 B/s, ETA 2s"+
+		"\r\x1b[KUploading [======================        ]  75% 1.5 KiB of 2.0 KiB, 512 B/s, ETA 1s"+
+		"\r\x1b[KUploading [==============================] 100% 2.0 KiB of 2.0 KiB, 512 B/s, ETA 0s\n", stderr.String())
+
+	// Progress is not shown when printing JSON, or when quiet
+	r = strings.NewReader("foo")
+	assert.Nil(t, cli.Run("config", "set", "quiet", "true"))
+	assert.Equal(t, r, cli.progressReader(r, 3, "Uploading"))
+}
+
+func TestDocumentPutProgress(t *testing.T) {
+	client := &mock.HTTPClient{ReadBody: true}
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	now := time.Now()
+	cli.now = func() time.Time {
+		now = now.Add(11 * time.Second)
+		return now
+	}
+	docFile := filepath.Join(t.TempDir(), "doc.json")
+	// Random text compresses poorly, so the body sent is larger than documentProgressSize
+	random := make([]byte, 2*documentProgressSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := base64.StdEncoding.EncodeToString(random)
+	require.Nil(t, os.WriteFile(docFile, []byte(`{"put": "id:ns:music::1", "fields": {"text": "`+text+`"}}`), 0644))
+	client.NextResponseString(200, `{"id": "id:ns:music::1"}`)
+	require.Nil(t, cli.Run("document", "put", "-t", "http://127.0.0.1:8080", docFile))
+	assert.Contains(t, stderr.String(), "Uploading document: 100% ")
+
+	stderr.Reset()
+	client.NextResponseString(200, `{"id": "id:ns:music::2"}`)
+	require.Nil(t, cli.Run("document", "put", "-t", "http://127.0.0.1:8080", "id:ns:music::2", "--data", `{"fields": {"text": "short"}}`))
+	assert.Equal(t, "", stderr.String())
+}
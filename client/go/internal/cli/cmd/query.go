@@ -6,14 +6,21 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -34,6 +41,28 @@ type queryOptions struct {
 	headers          []string
 	profile          bool
 	profileFile      string
+	repeat           int
+	concurrency      int
+	warmup           int
+	stream           bool
+	selectFields     string
+	all              bool
+	maxHits          int
+	maxOffset        int
+	save             string
+	saved            string
+	listSaved        bool
+	deleteSaved      string
+	cacheTTL         time.Duration
+	noCache          bool
+	clearCache       bool
+	queriesFile      string
+	hitFields        string
+	strict           bool
+	near             string
+	radius           string
+	positionField    string
+	dryRun           bool
 }
 
 func newQueryCmd(cli *CLI) *cobra.Command {
@@ -44,37 +73,188 @@ func newQueryCmd(cli *CLI) *cobra.Command {
 		Example: `$ vespa query 'yql=select * from music where album contains "head"' hits=5
 $ vespa query --format=plain 'yql=select * from music where album contains "head"' hits=5
 $ vespa query --file q-vector.json
-$ vespa query --header='X-First-Name: Joe' 'yql=select * from music where album contains "head"' hits=5`,
+$ vespa query --header='X-First-Name: Joe' 'yql=select * from music where album contains "head"' hits=5
+$ vespa query --repeat 1000 --concurrency 4 'yql=select * from music where album contains "head"'
+$ vespa query --format table --select fields.title,fields.year 'yql=select * from music where album contains "head"'
+$ vespa query --select id,relevance,fields.title 'yql=select * from music where album contains "head"'
+$ vespa query --all 'yql=select * from music where album contains "head"' > hits.jsonl
+$ vespa query --format trace 'yql=select * from music where album contains "head"' tracelevel=3 trace.timestamps=true
+$ vespa query --save heads 'yql=select * from music where album contains "head"' ranking=bm25 hits=5
+$ vespa query --saved heads hits=20
+$ vespa query --saved queries/heads.json
+$ vespa query --list-saved
+$ vespa query --cache 10m 'yql=select * from music where album contains "head"'
+$ vespa query --clear-cache
+$ vespa query --near 59.91,10.75 --radius 10km --position-field location 'yql=select * from restaurants where cuisine contains "pizza"'
+$ vespa query --dry-run --near 59.91,10.75 --radius 500m --position-field location
+$ vespa query --queries-file queries.txt --concurrency 4 --fields fields.title hits=10 > results.jsonl`,
 		Long: `Issue a query to Vespa.
 
 Any parameter from https://docs.vespa.ai/en/reference/query-api-reference.html
-can be set by the syntax [parameter-name]=[value].`,
-		// TODO: Support referencing a query json file
+can be set by the syntax [parameter-name]=[value].
+
+With --repeat, the query is issued the given number of times, and latency
+statistics are printed instead of the result. The queries are preceded by
+--warmup queries which are not measured. Use --format json to print the
+statistics as JSON. Interrupting the command prints the statistics gathered so
+far.
+
+Responses of type text/event-stream, e.g. from an LLM searcher, are printed as
+the events arrive. Use --stream to request such a response, and to print any
+response as a stream of events. The timeout then applies to the time between
+events, rather than to the whole response.
+
+With --select, only the given comma-separated fields of each hit are printed,
+one hit per line. Fields are given as paths into a hit, e.g. fields.title or
+relevance. Values are separated by tabs, or printed as a JSON object per hit
+with --format json. Fields missing from a hit are printed as empty values. The
+total hit count and query time are printed to standard error.
+
+With --format table, the hits are printed as a table with one row per hit, and
+a column for relevance, id and each field given with --select, or each string,
+number and boolean field of the first hit if --select is not given. Long
+strings are truncated, numbers are right-aligned, and arrays, structs and
+tensors are printed as a summary of their type. When standard output is not a
+terminal, the table is printed as tab-separated values, with a header line.
+
+With --format trace, the trace of the query is printed as an indented timeline,
+instead of the result. The time spent in each step is printed next to it, and
+the slowest steps are highlighted. The query must set tracelevel, and should set
+trace.timestamps=true to get the time of each step.
+
+With --all or --max-hits, the query is repeated with increasing offset until
+all matching hits, or the given number of hits, have been fetched. Each hit is
+printed as a JSON object per line. The hits parameter sets the number of hits
+fetched per query, up to 400, which is the maximum of Vespa by default. Vespa
+also limits the offset of a query, to 1000 by default, so use 'vespa visit' to
+export all documents of a larger result. Use --max-offset if this limit is
+changed in the query profile.
+
+With --save, the query parameters are saved under the given name, instead of
+issuing the query. Parameters read with --file or --saved are saved too, with
+overrides from arguments. Saved queries are stored as JSON files in the queries
+directory of the Vespa CLI home directory, and can be committed to a repository
+and shared. Use --saved to issue a saved query, given by name or as the path of
+such a file, with parameters given as arguments appended to those saved. The
+query is sent as a POST request, as with --file. Use --list-saved to list the
+saved queries, and --delete-saved to delete one.
+
+With --cache, the response is stored in the cache directory of Vespa CLI, and
+an identical query within the given duration is answered from the cache,
+without sending it to Vespa. "(cached)" is then printed to standard error.
+Responses are cached per endpoint, application and credentials, and only
+successful responses up to 10 MiB are cached. Use --no-cache to send the query
+anyway, and cache the new response, and --clear-cache to remove all cached
+responses. Caching is not supported with --repeat, --all, --max-hits, --stream
+or --profile.
+
+For an application in Vespa Cloud, the endpoints of the deployment are
+discovered from the control plane, and cached in the Vespa CLI home directory
+per application, zone and credentials, such that repeated queries need not wait
+for this. Cached endpoints expire after the endpoint-cache-ttl option, 10
+minutes by default, and are discovered again when a request to one fails to
+connect or gets status 404. Use --no-endpoint-cache to neither use nor update
+the cache, and see 'vespa help config' for the option.
+
+With --queries-file, each query in the given file is issued, --concurrency at a
+time, and the result of each is printed as a JSON line, in the order of the
+file. A query is either a JSON object of query parameters, which may span
+several lines, or a line holding a YQL statement. Empty lines and lines starting
+with # are ignored. Parameters given as arguments apply to every query, and
+override those in the file. Each result holds the query, the ID and relevance of
+its hits, and its latency in milliseconds, or the error of a failed query. Use
+--fields to include more fields of each hit, given as paths like with --select.
+A failed query does not stop the others, unless --strict is given. The number of
+queries and failures, and latency percentiles, are printed to standard error
+when all queries are done.
+
+With --near, the query matches documents whose --position-field is within
+--radius of the given latitude and longitude, in decimal degrees. The radius
+is given with a unit of km, m or mi. A geoLocation clause is added to the
+where clause of the YQL of the query, or a query for all documents within the
+radius is generated if no YQL is given. Use --dry-run to print the query
+parameters which would be sent, without issuing the query.`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.MinimumNArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 && opts.postFile == "" {
+			if err := checkSavedQueryOptions(cmd, &opts, args); err != nil {
+				return err
+			}
+			if err := checkQueriesFileOptions(cmd, &opts); err != nil {
+				return err
+			}
+			geo, err := checkGeoOptions(cmd, &opts)
+			if err != nil {
+				return err
+			}
+			switch {
+			case opts.listSaved:
+				return listSavedQueries(cli)
+			case opts.deleteSaved != "":
+				return deleteSavedQuery(cli, opts.deleteSaved)
+			case opts.clearCache:
+				return clearQueryCache(cli)
+			}
+			runOpts := opts
+			if opts.saved != "" {
+				path, err := cli.config.findSavedQuery(opts.saved)
+				if err != nil {
+					return err
+				}
+				runOpts.postFile = path
+			}
+			if opts.save != "" {
+				return saveQuery(cli, opts.save, args, runOpts.postFile)
+			}
+			if opts.queriesFile != "" {
+				waiter := cli.waiter(time.Duration(opts.waitSecs)*time.Second, cmd)
+				return queryBatch(cli, args, &runOpts, waiter)
+			}
+			if len(args) == 0 && runOpts.postFile == "" && geo == nil {
 				return fmt.Errorf("requires at least 1 arg")
 			}
 			waiter := cli.waiter(time.Duration(opts.waitSecs)*time.Second, cmd)
-			return query(cli, args, &opts, waiter)
+			return query(cli, args, &runOpts, geo, waiter)
 		},
 	}
 	cmd.Flags().BoolVarP(&opts.printCurl, "verbose", "v", false, "Print the equivalent curl command for the query")
-	cmd.Flags().StringVarP(&opts.postFile, "file", "", "", "Read query parameters from the given JSON file and send a POST request, with overrides from arguments")
-	cmd.Flags().StringVarP(&opts.format, "format", "", "human", "Output format. Must be 'human' (human-readable) or 'plain' (no formatting)")
+	cmd.Flags().StringVarP(&opts.postFile, "file", "", "", "Read query parameters from the given JSON file, or standard input if '-', and send a POST request, with overrides from arguments")
+	cmd.Flags().StringVarP(&opts.format, "format", "", "human", "Output format. Must be 'human' (human-readable), 'plain' (no formatting), 'table' (a table of hits) or 'trace' (timeline of the query trace). With --repeat or --select, 'json' is also allowed")
 	cmd.Flags().StringSliceVarP(&opts.headers, "header", "", nil, "Add a header to the HTTP request, on the format 'Header: Value'. This can be specified multiple times")
 	cmd.Flags().IntVarP(&opts.queryTimeoutSecs, "timeout", "T", 10, "Timeout for the query in seconds")
 	cmd.Flags().BoolVarP(&opts.profile, "profile", "", false, "Enable profiling mode (Note: this feature is experimental)")
 	cmd.Flags().StringVarP(&opts.profileFile, "profile-file", "", "vespa_query_profile_result.json", "Profiling result file")
+	cmd.Flags().StringVar(&opts.selectFields, "select", "", "Print only these comma-separated fields of each hit, e.g. 'fields.title,relevance'")
+	cmd.Flags().BoolVar(&opts.stream, "stream", false, "Request a response of server-sent events, and print events as they arrive")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Fetch all matching hits by repeating the query with increasing offset, and print them as JSON lines")
+	cmd.Flags().IntVar(&opts.maxHits, "max-hits", 0, "Fetch up to this many hits by repeating the query with increasing offset, and print them as JSON lines")
+	cmd.Flags().IntVar(&opts.maxOffset, "max-offset", defaultMaxOffset, "Maximum offset of the queries issued with --all or --max-hits. Must match the maxOffset of the query profile")
+	cmd.Flags().IntVar(&opts.repeat, "repeat", 0, "Issue the query this many times, and print latency statistics instead of the result")
+	cmd.Flags().IntVar(&opts.concurrency, "concurrency", 1, "Number of queries to issue concurrently, with --repeat or --queries-file")
+	cmd.Flags().StringVar(&opts.queriesFile, "queries-file", "", "Issue each query in this file, or standard input if '-', and print the hits of each as a JSON line")
+	cmd.Flags().StringVar(&opts.hitFields, "fields", "", "Include these comma-separated fields of each hit in the output of --queries-file, e.g. 'fields.title,matchfeatures'")
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "Stop at the first failed query, with --queries-file")
+	cmd.Flags().IntVar(&opts.warmup, "warmup", 1, "Number of queries to issue before measuring latency, with --repeat")
+	cmd.Flags().StringVar(&opts.save, "save", "", "Save the query parameters under this name, instead of issuing the query")
+	cmd.Flags().StringVar(&opts.saved, "saved", "", "Issue the saved query of this name, or in this JSON file, with overrides from arguments")
+	cmd.Flags().BoolVar(&opts.listSaved, "list-saved", false, "List the saved queries")
+	cmd.Flags().StringVar(&opts.deleteSaved, "delete-saved", "", "Delete the saved query of this name")
+	cmd.Flags().DurationVar(&opts.cacheTTL, "cache", 0, "Answer the query from responses cached within this duration, e.g. 10m, and cache the response otherwise. 0 to disable (default 0)")
+	cmd.Flags().BoolVar(&opts.noCache, "no-cache", false, "Send the query even if a response is cached, and cache the new response with --cache")
+	cmd.Flags().BoolVar(&opts.clearCache, "clear-cache", false, "Remove all cached query responses")
+	cmd.Flags().StringVar(&opts.near, "near", "", "Match documents near this position, given as latitude and longitude in decimal degrees, e.g. '59.91,10.75'")
+	cmd.Flags().StringVar(&opts.radius, "radius", "", "Match documents within this distance of the position given by --near, with unit km, m or mi, e.g. 10km")
+	cmd.Flags().StringVar(&opts.positionField, "position-field", "", "Name of the position field to match with --near")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Print the query parameters which would be sent, without issuing the query")
 	cmd.Flags().MarkHidden("profile")
 	cmd.Flags().MarkHidden("profile-file")
 	cli.bindWaitFlag(cmd, 0, &opts.waitSecs)
 	return cmd
 }
 
-func printCurl(stderr io.Writer, req *http.Request, postFile string, service *vespa.Service) error {
+// printCurl prints the curl command equivalent to req, with the values of the headers named in redact redacted.
+func printCurl(stderr io.Writer, req *http.Request, postFile string, service *vespa.Service, redact []string) error {
 	cmd, err := curl.RawArgs(req.URL.String())
 	if err != nil {
 		return err
@@ -83,7 +263,7 @@ func printCurl(stderr io.Writer, req *http.Request, postFile string, service *ve
 	if postFile != "" {
 		cmd.WithBodyFile(postFile)
 	}
-	for k, vl := range req.Header {
+	for k, vl := range httputil.RedactHeader(req.Header, redact) {
 		for _, v := range vl {
 			cmd.Header(k, v)
 		}
@@ -96,22 +276,72 @@ func printCurl(stderr io.Writer, req *http.Request, postFile string, service *ve
 	return err
 }
 
-func query(cli *CLI, arguments []string, opts *queryOptions, waiter *Waiter) error {
-	target, err := cli.target(targetOptions{})
+// printQueryParameters prints the parameters of a query, one per line, or body, if it is sent as a POST request.
+func printQueryParameters(cli *CLI, urlQuery url.Values, body []byte) error {
+	if body != nil {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "    "); err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.Stdout, buf.String())
+		return nil
+	}
+	keys := make([]string, 0, len(urlQuery))
+	for k := range urlQuery {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		for _, v := range urlQuery[k] {
+			fmt.Fprintf(cli.Stdout, "%s=%s\n", k, v)
+		}
+	}
+	return nil
+}
+
+func query(cli *CLI, arguments []string, opts *queryOptions, geo *geoQuery, waiter *Waiter) error {
+	authMethod := cli.selectAuthMethod()
+	target, err := cli.target(targetOptions{noCertificate: authMethod == "token"})
 	if err != nil {
 		return err
 	}
-	authMethod := cli.selectAuthMethod()
 	service, err := waiter.ServiceWithAuthMethod(target, cli.config.cluster(), authMethod)
 	if err != nil {
 		return err
 	}
 
 	switch opts.format {
-	case "plain", "human":
+	case "plain", "human", "trace":
+	case "table":
+		if opts.repeat > 0 || opts.stream || opts.profile {
+			return fmt.Errorf("--format table cannot be combined with --repeat, --stream or --profile")
+		}
+	case "json":
+		if opts.repeat == 0 && opts.selectFields == "" {
+			return fmt.Errorf("invalid format: %s: only allowed with --repeat or --select", opts.format)
+		}
 	default:
 		return fmt.Errorf("invalid format: %s", opts.format)
 	}
+	if opts.repeat < 0 || opts.concurrency < 1 || opts.warmup < 0 {
+		return fmt.Errorf("invalid benchmark options: --repeat and --warmup must be non-negative, and --concurrency positive")
+	}
+	paginate := opts.all || opts.maxHits != 0
+	if opts.maxHits < 0 {
+		return fmt.Errorf("invalid --max-hits: %d: must be positive", opts.maxHits)
+	}
+	if opts.maxOffset < 0 {
+		return fmt.Errorf("invalid --max-offset: %d: must be non-negative", opts.maxOffset)
+	}
+	if paginate && (opts.repeat > 0 || opts.stream || opts.selectFields != "" || opts.profile || opts.format == "trace" || opts.format == "table") {
+		return fmt.Errorf("--all and --max-hits cannot be combined with --repeat, --stream, --select, --profile, --format trace or --format table")
+	}
+	if opts.cacheTTL < 0 {
+		return fmt.Errorf("invalid --cache: %s: must be positive", opts.cacheTTL)
+	}
+	if opts.cacheTTL > 0 && (opts.repeat > 0 || paginate || opts.stream || opts.profile) {
+		return fmt.Errorf("--cache cannot be combined with --repeat, --all, --max-hits, --stream or --profile")
+	}
 	url, _ := url.Parse(strings.TrimSuffix(service.BaseURL, "/") + "/search/")
 	urlQuery := url.Query()
 	for i := range len(arguments) {
@@ -127,36 +357,49 @@ func query(cli *CLI, arguments []string, opts *queryOptions, waiter *Waiter) err
 		urlQuery.Set("trace.timestamps", "true")
 		urlQuery.Set("presentation.timing", "true")
 	}
+	var fileQuery map[string]any
+	if opts.postFile != "" {
+		fileQuery, err = readQueryFile(opts.postFile, cli.Stdin)
+		if err != nil {
+			return fmt.Errorf("bad JSON in postFile '%s': %w", opts.postFile, err)
+		}
+	}
+	if geo != nil {
+		if err := geo.expand(urlQuery, fileQuery); err != nil {
+			return err
+		}
+	}
 	queryTimeout := urlQuery.Get("timeout")
-	if queryTimeout == "" {
+	if queryTimeout == "" && fileQuery["timeout"] != nil {
+		// Timeout set in query file
+		queryTimeout = fmt.Sprint(fileQuery["timeout"])
+	} else if queryTimeout == "" {
 		// No timeout set by user, use the timeout option
 		queryTimeout = fmt.Sprintf("%ds", opts.queryTimeoutSecs)
 		urlQuery.Set("timeout", queryTimeout)
 	}
-	deadline, err := time.ParseDuration(queryTimeout)
+	deadline, err := parseQueryTimeout(queryTimeout)
 	if err != nil {
-		return fmt.Errorf("invalid query timeout: %w", err)
+		return err
 	}
-	header, err := httputil.ParseHeader(opts.headers)
+	header, err := cli.dataPlaneHeader(opts.headers, authMethod)
 	if err != nil {
 		return err
 	}
 	if authMethod == "token" {
-		err = cli.addBearerToken(&header)
-		if err != nil {
-			return err
-		}
 		service.TLSOptions.CertificateFile = ""
 		service.TLSOptions.PrivateKeyFile = ""
 	}
 	hReq := &http.Request{Header: header, URL: url}
+	var body []byte
 	if opts.postFile != "" {
-		json, err := getJsonFrom(opts.postFile, urlQuery)
+		json, err := getJsonFrom(fileQuery, urlQuery)
 		if err != nil {
 			return fmt.Errorf("bad JSON in postFile '%s': %w", opts.postFile, err)
 		}
 		header.Set("Content-Type", "application/json")
 		hReq.Method = "POST"
+		body = json
 		hReq.Body = io.NopCloser(bytes.NewBuffer(bytes.Clone(json)))
 		if err != nil {
 			return fmt.Errorf("bad postFile '%s': %w", opts.postFile, err)
@@ -164,22 +407,80 @@ func query(cli *CLI, arguments []string, opts *queryOptions, waiter *Waiter) err
 	}
 	url.RawQuery = urlQuery.Encode()
 	if opts.printCurl {
-		if err := printCurl(cli.Stderr, hReq, opts.postFile, service); err != nil {
+		if err := printCurl(cli.Stderr, hReq, opts.postFile, service, cli.secretHeaders); err != nil {
 			return err
 		}
 	}
+	if opts.dryRun {
+		return printQueryParameters(cli, urlQuery, body)
+	}
 
-	response, err := service.Do(hReq, deadline+time.Second) // Slightly longer than query timeout
-	if err != nil {
-		// Hint for timeout exception in cloud
-		if err, ok := err.(net.Error); ok && err.Timeout() && target.IsCloud() {
-			return errHint(err, "No nodes are responsive", "Check application status in the console")
+	ctx, stop := context.WithCancel(cli.ctx)
+	defer stop()
+	timeout := deadline + time.Second // Slightly longer than query timeout
+	if opts.repeat > 0 {
+		return benchmarkQuery(ctx, cli, service, hReq, body, timeout, opts)
+	}
+	if paginate {
+		// The hits are printed as they are fetched, so no result is recorded
+		return paginateQuery(ctx, cli, service, target, hReq, fileQuery, urlQuery, timeout, opts)
+	}
+	if opts.stream {
+		header.Set("Accept", "text/event-stream")
+	}
+
+	// The timeout is enforced by cancelling the request, so that it can be reset while a streamed response is read
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+	start := time.Now()
+	var (
+		cache    *queryCache
+		cacheKey string
+		response *http.Response
+		cached   bool
+	)
+	if opts.cacheTTL > 0 {
+		cache = &queryCache{cli: cli, ttl: opts.cacheTTL, refresh: opts.noCache}
+		cacheKey = queryCacheKey(hReq, body, target.Deployment(), service)
+		if response, cached = cache.lookup(cacheKey); cached {
+			cli.printInfo("(cached)")
+		}
+	}
+	if !cached {
+		response, err = service.Do(hReq.WithContext(ctx), 0)
+		if err != nil {
+			return queryError(err, target, timedOut.Load(), timeout)
+		}
+		if cache != nil {
+			response = cache.store(cacheKey, response)
 		}
-		return fmt.Errorf("request failed: %w", err)
 	}
 	defer response.Body.Close()
+	contentType := strings.Split(response.Header.Get("Content-Type"), ";")[0]
+	stream := opts.stream || contentType == "text/event-stream"
+	var responseBody io.Reader = response.Body
+	if stream {
+		timer.Reset(timeout)
+		responseBody = &idleTimeoutReader{r: response.Body, timer: timer, timeout: timeout}
+	}
 
 	if response.StatusCode == 200 {
+		if cli.recordResults && !stream {
+			// The response is recorded as the result of the query when it is read completely
+			var recorded bytes.Buffer
+			responseBody = io.TeeReader(responseBody, &recorded)
+			defer func() {
+				if json.Valid(recorded.Bytes()) {
+					cli.recordResult(json.RawMessage(recorded.Bytes()))
+				}
+			}()
+		}
 		var output io.Writer = cli.Stdout
 		if opts.profile {
 			profileFile, err := os.Create(opts.profileFile)
@@ -190,24 +491,511 @@ func query(cli *CLI, arguments []string, opts *queryOptions, waiter *Waiter) err
 			fmt.Fprintf(cli.Stderr, "writing profiling results to: %s\n", opts.profileFile)
 			output = profileFile
 		}
-		if err := printResponse(response.Body, response.Header.Get("Content-Type"), opts.format, output); err != nil {
-			return err
+		if opts.format == "trace" && !stream {
+			return printTrace(cli, responseBody)
+		}
+		if opts.format == "table" && !stream {
+			var paths []string
+			if opts.selectFields != "" {
+				paths = strings.Split(opts.selectFields, ",")
+			}
+			return printHitTable(cli, responseBody, paths, start)
+		}
+		if opts.selectFields != "" && !stream {
+			return printSelectedFields(cli, responseBody, strings.Split(opts.selectFields, ","), opts.format, start)
 		}
+		if err := printResponse(responseBody, stream, opts.format, output); err != nil {
+			return queryError(err, target, timedOut.Load(), timeout)
+		}
+	} else if authRejected(response.StatusCode) {
+		err := fmt.Errorf("query rejected using %s: %s\n%s", authMethodDescription(authMethod), response.Status, ioutil.ReaderToJSON(response.Body))
+		return errCode(codeAuthFailed, err, cli.authRejectedHints(authMethod)...)
 	} else if response.StatusCode/100 == 4 {
-		err := fmt.Errorf("invalid query: %s\n%s", response.Status, ioutil.ReaderToJSON(response.Body))
-		if response.StatusCode == 403 && authMethod == "token" {
-			return errHint(err, "Make sure the VESPA_CLI_DATA_PLANE_TOKEN environment variable is set to a valid token")
+		return fmt.Errorf("invalid query: %s\n%s", response.Status, ioutil.ReaderToJSON(response.Body))
+	} else {
+		return errCode(codeServerError, fmt.Errorf("%s from container at %s\n%s", response.Status, color.CyanString(url.Host), ioutil.ReaderToJSON(response.Body)))
+	}
+	return nil
+}
+
+const (
+	// defaultPageHits is the number of hits fetched per query when paginating, unless the hits parameter is set.
+	defaultPageHits = 100
+	// maxPageHits is the maximum number of hits fetched per query when paginating, which is the default maxHits of the
+	// query profile.
+	maxPageHits = 400
+	// defaultMaxOffset is the default maxOffset of the query profile.
+	defaultMaxOffset = 1000
+)
+
+// paginateQuery repeats the query in template with increasing offset, and prints the hits as JSON lines, until all
+// hits, or opts.maxHits hits, are printed. The query parameters are given in urlQuery, and fileQuery for POST requests.
+// An error is returned if hits remain when the offset of the next query would exceed opts.maxOffset.
+func paginateQuery(ctx context.Context, cli *CLI, service *vespa.Service, target vespa.Target, template *http.Request,
+	fileQuery map[string]any, urlQuery url.Values, timeout time.Duration, opts *queryOptions) error {
+	pageHits := defaultPageHits
+	if hits := urlQuery.Get("hits"); hits != "" {
+		n, err := strconv.Atoi(hits)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid hits: %s: must be a positive integer", hits)
+		}
+		pageHits = min(n, maxPageHits)
+	}
+	offset := 0
+	if s := urlQuery.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid offset: %s: must be a non-negative integer", s)
+		}
+		offset = n
+	}
+	printed, requests := 0, 0
+	totalCount := int64(-1)
+	for opts.maxHits == 0 || printed < opts.maxHits {
+		if offset > opts.maxOffset {
+			cli.printInfo(fmt.Sprintf("Fetched %d of %d hits in %d requests", printed, totalCount, requests))
+			return errHint(fmt.Errorf("stopped at offset %d, as queries beyond the maximum offset %d are rejected", offset, opts.maxOffset),
+				"Use 'vespa visit' to export all documents matching a selection",
+				"Use --max-offset if the maxOffset of the query profile is raised")
+		}
+		hits := pageHits
+		if opts.maxHits > 0 {
+			hits = min(hits, opts.maxHits-printed)
+		}
+		urlQuery.Set("offset", strconv.Itoa(offset))
+		urlQuery.Set("hits", strconv.Itoa(hits))
+		request := template.Clone(ctx)
+		request.URL.RawQuery = urlQuery.Encode()
+		if fileQuery != nil {
+			body, err := getJsonFrom(fileQuery, urlQuery)
+			if err != nil {
+				return fmt.Errorf("bad JSON in postFile '%s': %w", opts.postFile, err)
+			}
+			request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		response, err := service.Do(request, timeout)
+		if err != nil {
+			return queryError(err, target, false, timeout)
+		}
+		requests++
+		if response.StatusCode != 200 {
+			body, err := io.ReadAll(response.Body)
+			response.Body.Close()
+			if err != nil {
+				return queryError(err, target, false, timeout)
+			}
+			message := ioutil.ReaderToJSON(bytes.NewReader(body))
+			if response.StatusCode == 400 && requests > 1 && isPageRejection(body) {
+				// The maximum offset or hits of the query profile is lower than assumed
+				cli.printInfo(fmt.Sprintf("Fetched %d of %d hits in %d requests", printed, totalCount, requests))
+				return errHint(fmt.Errorf("query at offset %d was rejected, the maximum offset is likely reached:\n%s", offset, message),
+					"Use 'vespa visit' to export all documents matching a selection",
+					"Use --max-offset to match the maxOffset of the query profile")
+			}
+			err = fmt.Errorf("%s from container at %s\n%s", response.Status, color.CyanString(request.URL.Host), message)
+			if response.StatusCode/100 == 5 {
+				return errCode(codeServerError, err)
+			}
+			return err
+		}
+		var result struct {
+			Root struct {
+				Fields struct {
+					TotalCount int64 `json:"totalCount"`
+				} `json:"fields"`
+				Children []json.RawMessage `json:"children"`
+			} `json:"root"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return fmt.Errorf("invalid query result: %w", err)
+		}
+		totalCount = result.Root.Fields.TotalCount
+		for _, hit := range result.Root.Children {
+			var buf bytes.Buffer
+			if err := json.Compact(&buf, hit); err != nil {
+				return fmt.Errorf("invalid hit in query result: %w", err)
+			}
+			buf.WriteByte('\n')
+			if _, err := cli.Stdout.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		printed += len(result.Root.Children)
+		offset += len(result.Root.Children)
+		if len(result.Root.Children) < hits || int64(offset) >= totalCount {
+			break
+		}
+	}
+	cli.printInfo(fmt.Sprintf("Fetched %d of %d hits in %d requests", printed, totalCount, requests))
+	return nil
+}
+
+// isPageRejection returns whether the body of a response to a query says the query was rejected for its offset or
+// number of hits.
+func isPageRejection(body []byte) bool {
+	var result struct {
+		Root struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"root"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false
+	}
+	for _, e := range result.Root.Errors {
+		if strings.Contains(e.Message, "offset") || strings.Contains(e.Message, "hits") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQueryTimeout parses the value of the timeout query parameter, where a value without unit is in seconds.
+func parseQueryTimeout(timeout string) (time.Duration, error) {
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(timeout, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("invalid query timeout: %w", err)
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	return d, nil
+}
+
+// queryError returns err, which occurred while querying target, with any hints. If timedOut is true, the error was
+// caused by no response being received within timeout.
+func queryError(err error, target vespa.Target, timedOut bool, timeout time.Duration) error {
+	if timedOut {
+		err = fmt.Errorf("request failed: no response within %s", timeout)
+		if target.IsCloud() {
+			return errHint(err, "No nodes are responsive", "Check application status in the console")
 		}
 		return err
-	} else {
-		return fmt.Errorf("%s from container at %s\n%s", response.Status, color.CyanString(url.Host), ioutil.ReaderToJSON(response.Body))
 	}
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("query interrupted")
+	}
+	// Hint for timeout exception in cloud
+	if err, ok := err.(net.Error); ok && err.Timeout() && target.IsCloud() {
+		return errHint(err, "No nodes are responsive", "Check application status in the console")
+	}
+	return fmt.Errorf("request failed: %w", err)
+}
+
+// idleTimeoutReader resets timer to timeout after each successful read from r.
+type idleTimeoutReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// printSelectedFields prints the values of given paths in each hit of the query result in body.
+func printSelectedFields(cli *CLI, body io.Reader, paths []string, format string, start time.Time) error {
+	result, err := decodeHits(body)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	for _, hit := range result.Root.Children {
+		if format == "json" {
+			values := make(map[string]any, len(paths))
+			for _, path := range paths {
+				values[path] = selectPath(hit, path)
+			}
+			b, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cli.Stdout, string(b))
+		} else {
+			values := make([]string, 0, len(paths))
+			for _, path := range paths {
+				values = append(values, tsvValue(selectPath(hit, path)))
+			}
+			fmt.Fprintln(cli.Stdout, strings.Join(values, "\t"))
+		}
+	}
+	cli.printInfo(fmt.Sprintf("Total hit count: %d, printed %d hits in %d ms", result.Root.Fields.TotalCount, len(result.Root.Children), elapsed.Milliseconds()))
 	return nil
 }
 
-func printResponse(body io.Reader, contentType, format string, output io.Writer) error {
-	contentType = strings.Split(contentType, ";")[0]
-	if contentType == "text/event-stream" {
+// queryHits is the total hit count and the hits of a query result.
+type queryHits struct {
+	Root struct {
+		Fields struct {
+			TotalCount int64 `json:"totalCount"`
+		} `json:"fields"`
+		Children []any `json:"children"`
+	} `json:"root"`
+}
+
+// decodeHits decodes the hits of the query result in body, with numbers kept as they are.
+func decodeHits(body io.Reader) (queryHits, error) {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	var result queryHits
+	if err := dec.Decode(&result); err != nil {
+		return queryHits{}, fmt.Errorf("invalid query result: %w", err)
+	}
+	return result, nil
+}
+
+// selectPath returns the value at the dot-separated path in value, or nil if there is no such value. Elements of arrays
+// are selected by index.
+func selectPath(value any, path string) any {
+	for _, name := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[name]
+		case []any:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+func tsvValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r").Replace(v)
+	case json.Number:
+		return v.String()
+	default:
+		b, _ := json.Marshal(v) // Values decoded from JSON can always be encoded
+		return string(b)
+	}
+}
+
+// traceStep is a single message in a query trace.
+type traceStep struct {
+	depth        int
+	message      string
+	timestamp    float64
+	hasTimestamp bool
+}
+
+// slowTraceSteps is the number of slowest steps to highlight in a trace.
+const slowTraceSteps = 3
+
+// printTrace prints the trace in the query result in body as an indented timeline.
+func printTrace(cli *CLI, body io.Reader) error {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	var result struct {
+		Trace map[string]any `json:"trace"`
+	}
+	if err := dec.Decode(&result); err != nil {
+		return fmt.Errorf("invalid query result: %w", err)
+	}
+	if result.Trace == nil {
+		return errHint(fmt.Errorf("query result has no trace"), "Set tracelevel to a value above 0, e.g. tracelevel=3")
+	}
+	var steps []traceStep
+	collectTraceSteps(result.Trace, -1, &steps)
+	// The duration of a step is the time until the next step in the timeline
+	durations := make([]float64, len(steps))
+	var sorted []float64
+	for i := range steps {
+		durations[i] = -1
+		if !steps[i].hasTimestamp {
+			continue
+		}
+		for j := i + 1; j < len(steps); j++ {
+			if steps[j].hasTimestamp {
+				durations[i] = steps[j].timestamp - steps[i].timestamp
+				sorted = append(sorted, durations[i])
+				break
+			}
+		}
+	}
+	slices.Sort(sorted)
+	slowThreshold := math.Inf(1)
+	if len(sorted) > 0 {
+		slowThreshold = math.Max(sorted[max(0, len(sorted)-slowTraceSteps)], math.SmallestNonzeroFloat64)
+	}
+	for i, step := range steps {
+		timestamp, duration := "", ""
+		if step.hasTimestamp {
+			timestamp = strconv.FormatFloat(step.timestamp, 'f', -1, 64) + " ms"
+		}
+		if durations[i] >= 0 {
+			duration = "+" + strconv.FormatFloat(durations[i], 'f', -1, 64) + " ms"
+		}
+		duration = fmt.Sprintf("%10s", duration)
+		if durations[i] >= slowThreshold {
+			duration = color.RedString(duration)
+		}
+		fmt.Fprintf(cli.Stdout, "%10s %s  %s%s\n", timestamp, duration, strings.Repeat("  ", step.depth), step.message)
+	}
+	return nil
+}
+
+// collectTraceSteps appends the steps of the trace node at depth to steps, in order. The children of a node are one
+// level deeper than the node itself.
+func collectTraceSteps(node map[string]any, depth int, steps *[]traceStep) {
+	if message, ok := node["message"]; ok {
+		step := traceStep{depth: depth}
+		if s, ok := message.(string); ok {
+			step.message = s
+		} else {
+			b, _ := json.Marshal(message) // Values decoded from JSON can always be encoded
+			step.message = string(b)
+		}
+		if timestamp, ok := node["timestamp"].(json.Number); ok {
+			if f, err := timestamp.Float64(); err == nil {
+				step.timestamp = f
+				step.hasTimestamp = true
+			}
+		}
+		*steps = append(*steps, step)
+	}
+	children, _ := node["children"].([]any)
+	for _, child := range children {
+		if c, ok := child.(map[string]any); ok {
+			collectTraceSteps(c, depth+1, steps)
+		}
+	}
+}
+
+// queryBenchmark holds the statistics of repeated queries.
+type queryBenchmark struct {
+	Queries     int64  `json:"query.count"`
+	Seconds     number `json:"query.seconds"`
+	OK          int64  `json:"query.ok.count"`
+	Rate        number `json:"query.ok.rate"`
+	Errors      int64  `json:"query.error.count"`
+	Interrupted bool   `json:"query.interrupted"`
+
+	MinLatency    number `json:"query.latency.millis.min"`
+	MedianLatency number `json:"query.latency.millis.p50"`
+	P95Latency    number `json:"query.latency.millis.p95"`
+	P99Latency    number `json:"query.latency.millis.p99"`
+	MaxLatency    number `json:"query.latency.millis.max"`
+}
+
+// benchmarkQuery issues the query in template opts.repeat times, opts.concurrency at a time, and prints latency
+// statistics for the successful queries. Cancelling ctx stops the benchmark, and prints the statistics gathered so far.
+func benchmarkQuery(ctx context.Context, cli *CLI, service *vespa.Service, template *http.Request, body []byte, timeout time.Duration, opts *queryOptions) error {
+	run := func() (time.Duration, bool) {
+		request := template.Clone(ctx)
+		if body != nil {
+			request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		start := cli.now()
+		response, err := service.Do(request, timeout)
+		if err != nil {
+			return 0, false
+		}
+		defer response.Body.Close()
+		if _, err := io.Copy(io.Discard, response.Body); err != nil {
+			return 0, false
+		}
+		return cli.now().Sub(start), response.StatusCode == 200
+	}
+	for i := 0; i < opts.warmup && ctx.Err() == nil; i++ {
+		run()
+	}
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		next      atomic.Int64
+		latencies []time.Duration
+		failures  int64
+	)
+	start := cli.now()
+	for range opts.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && next.Add(1) <= int64(opts.repeat) {
+				latency, ok := run()
+				if ctx.Err() != nil {
+					return // Interrupted while running, so the result is not valid
+				}
+				mu.Lock()
+				if ok {
+					latencies = append(latencies, latency)
+				} else {
+					failures++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := cli.now().Sub(start)
+	slices.Sort(latencies)
+	millis := func(p float64) number { return latencyPercentile(latencies, p) }
+	ok := int64(len(latencies))
+	benchmark := queryBenchmark{
+		Queries:     ok + failures,
+		Seconds:     number(elapsed.Seconds()),
+		OK:          ok,
+		Rate:        number(float64(ok) / math.Max(0.001, elapsed.Seconds())),
+		Errors:      failures,
+		Interrupted: ctx.Err() != nil,
+
+		MinLatency:    millis(0),
+		MedianLatency: millis(50),
+		P95Latency:    millis(95),
+		P99Latency:    millis(99),
+		MaxLatency:    millis(100),
+	}
+	if err := printQueryBenchmark(cli, benchmark, opts.format); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d queries failed", failures, benchmark.Queries)
+	}
+	return nil
+}
+
+// latencyPercentile returns the p-th percentile of the sorted latencies, in milliseconds, or 0 if there are none.
+func latencyPercentile(latencies []time.Duration, p float64) number {
+	if len(latencies) == 0 {
+		return 0
+	}
+	rank := max(1, int(math.Ceil(p/100*float64(len(latencies)))))
+	return number(float64(latencies[rank-1].Microseconds()) / 1000)
+}
+
+func printQueryBenchmark(cli *CLI, benchmark queryBenchmark, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(cli.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(benchmark)
+	}
+	if benchmark.Interrupted {
+		cli.printWarning(fmt.Sprintf("Interrupted after %d queries", benchmark.Queries))
+	}
+	fmt.Fprintf(cli.Stdout, "Queries: %d (%d errors) in %.3f s, %.1f QPS\n", benchmark.Queries, benchmark.Errors, benchmark.Seconds, benchmark.Rate)
+	fmt.Fprintf(cli.Stdout, "Latency: min %.3f ms, median %.3f ms, p95 %.3f ms, p99 %.3f ms, max %.3f ms\n",
+		benchmark.MinLatency, benchmark.MedianLatency, benchmark.P95Latency, benchmark.P99Latency, benchmark.MaxLatency)
+	return nil
+}
+
+func printResponse(body io.Reader, stream bool, format string, output io.Writer) error {
+	if stream {
 		return printResponseBody(body, printOptions{
 			plainStream: format == "plain",
 			tokenStream: format == "human",
@@ -257,6 +1045,10 @@ func printResponseBody(body io.Reader, options printOptions, output io.Writer) e
 				fmt.Fprint(output, event.String())
 			} else {
 				fmt.Fprintln(output)
+				if event.Data != "" {
+					// Trailer sent with the end event
+					fmt.Fprintln(output, ioutil.StringToJSON(event.Data))
+				}
 				break
 			}
 		}
@@ -287,13 +1079,18 @@ func splitArg(argument string) (string, string) {
 	return parts[0], parts[1]
 }
 
-func getJsonFrom(fn string, query url.Values) ([]byte, error) {
-	parsed := make(map[string]any)
-	f, err := os.Open(fn)
-	if err != nil {
-		return nil, err
+// readQueryFile reads the JSON query in file fn, or from stdin if fn is "-".
+func readQueryFile(fn string, stdin io.Reader) (map[string]any, error) {
+	r := stdin
+	if fn != "-" {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
 	}
-	body, err := io.ReadAll(f)
+	body, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
@@ -302,11 +1099,20 @@ func getJsonFrom(fn string, query url.Values) ([]byte, error) {
 			body[i] = ' '
 		}
 	}
-	err = json.Unmarshal(body, &parsed)
-	if err != nil {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // Keep numbers as given in the file
+	parsed := make(map[string]any)
+	if err := dec.Decode(&parsed); err != nil {
 		return nil, err
 	}
+	return parsed, nil
+}
+
+// getJsonFrom returns the JSON query in parsed, with the parameters in query merged on top of it. The parameters are
+// removed from query.
+func getJsonFrom(parsed map[string]any, query url.Values) ([]byte, error) {
 	for k, vl := range query {
+		removeNestedParameter(parsed, strings.Split(k, "."))
 		if len(vl) == 1 {
 			parsed[k] = vl[0]
 		} else {
@@ -320,3 +1126,23 @@ func getJsonFrom(fn string, query url.Values) ([]byte, error) {
 	}
 	return b, nil
 }
+
+// removeNestedParameter removes the parameter given by path, e.g. [ranking, profile], from the nested objects in
+// parsed, such that a parameter given as "ranking.profile" overrides it.
+func removeNestedParameter(parsed map[string]any, path []string) {
+	if len(path) < 2 {
+		return
+	}
+	for i := 1; i < len(path); i++ {
+		prefix := strings.Join(path[:i], ".")
+		nested, ok := parsed[prefix].(map[string]any)
+		if !ok {
+			continue
+		}
+		removeNestedParameter(nested, path[i:])
+		delete(nested, strings.Join(path[i:], "."))
+		if len(nested) == 0 {
+			delete(parsed, prefix)
+		}
+	}
+}
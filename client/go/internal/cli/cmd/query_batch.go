@@ -0,0 +1,338 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Issuing of the queries in a file, with the results printed as JSON lines
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// checkQueriesFileOptions returns an error if options which do not apply to --queries-file are combined with it, or
+// if options which only apply to it are given without it.
+func checkQueriesFileOptions(cmd *cobra.Command, opts *queryOptions) error {
+	if opts.queriesFile == "" {
+		for _, name := range []string{"fields", "strict"} {
+			if cmd.Flags().Changed(name) {
+				return fmt.Errorf("option --%s requires --queries-file", name)
+			}
+		}
+		return nil
+	}
+	for _, name := range []string{"file", "save", "saved", "select", "repeat", "warmup", "all", "max-hits", "stream", "format", "cache", "no-cache", "profile", "verbose", "near", "dry-run"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("options --queries-file and --%s cannot be combined", name)
+		}
+	}
+	if opts.concurrency < 1 {
+		return fmt.Errorf("invalid --concurrency: %d: must be positive", opts.concurrency)
+	}
+	return nil
+}
+
+// batchQuery is a query read from a queries file.
+type batchQuery struct {
+	// line is the line of the file where the query starts
+	line       int
+	parameters map[string]any
+}
+
+// readBatchQueries reads the queries in file fn, or from stdin if fn is "-". A query is either a JSON object of query
+// parameters, or a line holding a YQL statement. Empty lines, and lines starting with #, are skipped.
+func readBatchQueries(fn string, stdin io.Reader) ([]batchQuery, error) {
+	r := stdin
+	if fn != "-" {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var queries []batchQuery
+	line := 1
+	for {
+		trimmed := bytes.TrimLeft(data, " \t\r\n")
+		line += bytes.Count(data[:len(data)-len(trimmed)], []byte("\n"))
+		data = trimmed
+		if len(data) == 0 {
+			return queries, nil
+		}
+		if data[0] == '{' {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber() // Keep numbers as given in the file
+			var parameters map[string]any
+			if err := dec.Decode(&parameters); err != nil {
+				return nil, fmt.Errorf("invalid query at line %d: %w", line, err)
+			}
+			queries = append(queries, batchQuery{line: line, parameters: parameters})
+			n := int(dec.InputOffset())
+			line += bytes.Count(data[:n], []byte("\n"))
+			data = data[n:]
+			continue
+		}
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			end = len(data)
+		}
+		if yql := strings.TrimSpace(string(data[:end])); !strings.HasPrefix(yql, "#") {
+			queries = append(queries, batchQuery{line: line, parameters: map[string]any{"yql": yql}})
+		}
+		data = data[end:]
+	}
+}
+
+// batchQueryRunner issues the queries of a queries file.
+type batchQueryRunner struct {
+	service  *vespa.Service
+	target   vespa.Target
+	template *http.Request
+	// arguments are the query parameters given as arguments, which override those of each query
+	arguments      []string
+	defaultTimeout time.Duration
+	paths          []string
+}
+
+// run issues query, and returns its result as a JSON object, its latency, and the error of the query, if it failed.
+// The error is also held by the result.
+func (r *batchQueryRunner) run(ctx context.Context, query batchQuery) (map[string]any, time.Duration, error) {
+	result := map[string]any{"line": query.line, "query": query.parameters}
+	latency, err := r.issue(ctx, query, result)
+	if err != nil {
+		result["error"] = err.Error()
+		return result, 0, err
+	}
+	result["latencyMillis"] = number(float64(latency.Microseconds()) / 1000)
+	return result, latency, nil
+}
+
+func (r *batchQueryRunner) issue(ctx context.Context, query batchQuery, result map[string]any) (time.Duration, error) {
+	// The parameters of the query are copied, as merging the arguments modifies them
+	b, err := json.Marshal(query.parameters)
+	if err != nil {
+		return 0, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var parameters map[string]any
+	if err := dec.Decode(&parameters); err != nil {
+		return 0, err
+	}
+	urlQuery := make(url.Values)
+	for _, argument := range r.arguments {
+		key, value := splitArg(argument)
+		urlQuery.Set(key, value)
+	}
+	timeout := r.defaultTimeout
+	if t := urlQuery.Get("timeout"); t != "" {
+		timeout, err = parseQueryTimeout(t)
+	} else if t, ok := parameters["timeout"]; ok {
+		timeout, err = parseQueryTimeout(fmt.Sprint(t))
+	} else {
+		urlQuery.Set("timeout", fmt.Sprintf("%ds", int(timeout.Seconds())))
+	}
+	if err != nil {
+		return 0, err
+	}
+	body, err := getJsonFrom(parameters, urlQuery)
+	if err != nil {
+		return 0, err
+	}
+	request := r.template.Clone(ctx)
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	start := time.Now()
+	timeout += time.Second // Slightly longer than query timeout
+	response, err := r.service.Do(request, timeout)
+	if err != nil {
+		return 0, queryError(err, r.target, false, timeout)
+	}
+	defer response.Body.Close()
+	resultBody, err := io.ReadAll(response.Body)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, queryError(err, r.target, false, timeout)
+	}
+	if response.StatusCode != 200 {
+		return 0, fmt.Errorf("%s: %s", response.Status, batchErrorMessage(resultBody))
+	}
+	dec = json.NewDecoder(bytes.NewReader(resultBody))
+	dec.UseNumber()
+	var parsed struct {
+		Root struct {
+			Fields struct {
+				TotalCount int64 `json:"totalCount"`
+			} `json:"fields"`
+			Children []any `json:"children"`
+		} `json:"root"`
+	}
+	if err := dec.Decode(&parsed); err != nil {
+		return 0, fmt.Errorf("invalid query result: %w", err)
+	}
+	hits := make([]map[string]any, 0, len(parsed.Root.Children))
+	for _, child := range parsed.Root.Children {
+		hit := map[string]any{"id": selectPath(child, "id"), "relevance": selectPath(child, "relevance")}
+		for _, path := range r.paths {
+			hit[path] = selectPath(child, path)
+		}
+		hits = append(hits, hit)
+	}
+	result["totalCount"] = parsed.Root.Fields.TotalCount
+	result["hits"] = hits
+	return latency, nil
+}
+
+// batchErrorMessage returns the messages of the errors in the query result in body, or the body itself if it holds
+// no such errors.
+func batchErrorMessage(body []byte) string {
+	var result struct {
+		Root struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"root"`
+	}
+	if json.Unmarshal(body, &result) == nil && len(result.Root.Errors) > 0 {
+		messages := make([]string, 0, len(result.Root.Errors))
+		for _, e := range result.Root.Errors {
+			messages = append(messages, e.Message)
+		}
+		return strings.Join(messages, "; ")
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// queryBatch issues the queries in opts.queriesFile, opts.concurrency at a time, and prints the result of each as a
+// JSON line, in the order of the file. The number of queries and failures, and latency statistics, are printed when
+// all queries are done. Unless opts.strict is set, failed queries do not stop the others.
+func queryBatch(cli *CLI, arguments []string, opts *queryOptions, waiter *Waiter) error {
+	queries, err := readBatchQueries(opts.queriesFile, cli.Stdin)
+	if err != nil {
+		return fmt.Errorf("could not read queries from '%s': %w", opts.queriesFile, err)
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("no queries in '%s'", opts.queriesFile)
+	}
+	authMethod := cli.selectAuthMethod()
+	target, err := cli.target(targetOptions{noCertificate: authMethod == "token"})
+	if err != nil {
+		return err
+	}
+	service, err := waiter.ServiceWithAuthMethod(target, cli.config.cluster(), authMethod)
+	if err != nil {
+		return err
+	}
+	header, err := cli.dataPlaneHeader(opts.headers, authMethod)
+	if err != nil {
+		return err
+	}
+	if authMethod == "token" {
+		service.TLSOptions.CertificateFile = ""
+		service.TLSOptions.PrivateKeyFile = ""
+	}
+	header.Set("Content-Type", "application/json")
+	url, _ := url.Parse(strings.TrimSuffix(service.BaseURL, "/") + "/search/")
+	runner := &batchQueryRunner{
+		service:        service,
+		target:         target,
+		template:       &http.Request{Method: "POST", Header: header, URL: url},
+		arguments:      arguments,
+		defaultTimeout: time.Duration(opts.queryTimeoutSecs) * time.Second,
+	}
+	if opts.hitFields != "" {
+		runner.paths = strings.Split(opts.hitFields, ",")
+	}
+
+	ctx, stop := context.WithCancel(cli.ctx)
+	defer stop()
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		next      atomic.Int64
+		results   = make([]map[string]any, len(queries))
+		printed   int
+		latencies []time.Duration
+		failures  int
+		strictErr error
+		writeErr  error
+	)
+	start := time.Now()
+	for range opts.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				i := int(next.Add(1)) - 1
+				if i >= len(queries) {
+					return
+				}
+				result, latency, err := runner.run(ctx, queries[i])
+				if ctx.Err() != nil {
+					return // Stopped while running, so the result is not valid
+				}
+				mu.Lock()
+				results[i] = result
+				if err != nil {
+					failures++
+					if opts.strict {
+						strictErr = fmt.Errorf("query at line %d failed: %w", queries[i].line, err)
+						stop()
+					}
+				} else {
+					latencies = append(latencies, latency)
+				}
+				// Print results in the order of the file, as soon as all preceding results are printed
+				for ; printed < len(results) && results[printed] != nil && writeErr == nil; printed++ {
+					b, err := json.Marshal(results[printed])
+					if err == nil {
+						_, err = cli.Stdout.Write(append(b, '\n'))
+					}
+					if err != nil {
+						writeErr = err
+						stop()
+					}
+					results[printed] = nil
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if writeErr != nil {
+		return writeErr
+	}
+	slices.Sort(latencies)
+	issued := len(latencies) + failures
+	if strictErr == nil && issued < len(queries) {
+		cli.printWarning(fmt.Sprintf("Interrupted after %d of %d queries", issued, len(queries)))
+	}
+	cli.printInfo(fmt.Sprintf("Queries: %d (%d failed) in %.3f s", issued, failures, elapsed.Seconds()))
+	cli.printInfo(fmt.Sprintf("Latency: min %.3f ms, median %.3f ms, p95 %.3f ms, p99 %.3f ms, max %.3f ms",
+		latencyPercentile(latencies, 0), latencyPercentile(latencies, 50), latencyPercentile(latencies, 95),
+		latencyPercentile(latencies, 99), latencyPercentile(latencies, 100)))
+	if strictErr != nil {
+		return errHint(strictErr, "Omit --strict to issue the remaining queries regardless of failures")
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d queries failed", failures, issued)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Client-side caching of responses of vespa query
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// queryCacheMaxSize is the size of the largest response body stored in the query cache.
+const queryCacheMaxSize = 10 << 20
+
+// queryCache stores successful responses to queries in the cache directory of the CLI, keyed by the request, the
+// deployment it is sent to and the credentials it is sent with.
+type queryCache struct {
+	cli *CLI
+	ttl time.Duration
+	// refresh is whether to ignore cached responses, and only store new ones
+	refresh bool
+}
+
+type queryCacheEntry struct {
+	StoredAt    time.Time `json:"storedAt"`
+	ContentType string    `json:"contentType,omitempty"`
+	Body        []byte    `json:"body"`
+}
+
+func queryCacheDir(cli *CLI) string { return filepath.Join(cli.config.cacheDir, "queries") }
+
+// queryCacheKey returns the key of the response to request, with given body, sent to deployment by service. The key
+// is a hash, such that neither the query nor the credentials are stored.
+func queryCacheKey(request *http.Request, body []byte, deployment vespa.Deployment, service *vespa.Service) string {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(deployment.System.Name)
+	write(deployment.Application.SerializedForm())
+	write(deployment.Zone.String())
+	write(service.AuthMethod)
+	if service.AuthMethod == "mtls" {
+		if len(service.TLSOptions.CertificatePEM) > 0 {
+			write(string(service.TLSOptions.CertificatePEM))
+		} else {
+			write(service.TLSOptions.CertificateFile)
+		}
+	}
+	write(request.Method)
+	write(request.URL.String()) // Query parameters are encoded in sorted order
+	names := make([]string, 0, len(request.Header))
+	for name := range request.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		write(name + ": " + strings.Join(request.Header.Values(name), ", "))
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *queryCache) path(key string) string { return filepath.Join(queryCacheDir(c.cli), key+".json") }
+
+// lookup returns the cached response of given key, if it was stored within the TTL of this.
+func (c *queryCache) lookup(key string) (*http.Response, bool) {
+	if c.refresh {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry queryCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false // A corrupt entry is just missing
+	}
+	if c.cli.now().Sub(entry.StoredAt) >= c.ttl {
+		return nil, false
+	}
+	header := make(http.Header)
+	if entry.ContentType != "" {
+		header.Set("Content-Type", entry.ContentType)
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(entry.Body)),
+	}, true
+}
+
+// store stores the body of response under given key, and returns the response with a body which reads the same. Bodies
+// larger than queryCacheMaxSize, and streams of events, are not stored.
+func (c *queryCache) store(key string, response *http.Response) *http.Response {
+	contentType := response.Header.Get("Content-Type")
+	if response.StatusCode != 200 || strings.HasPrefix(contentType, "text/event-stream") {
+		return response
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, queryCacheMaxSize+1))
+	body := response.Body
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil || len(data) > queryCacheMaxSize {
+		return response
+	}
+	entry, err := json.Marshal(queryCacheEntry{StoredAt: c.cli.now(), ContentType: contentType, Body: data})
+	if err == nil {
+		err = writeQueryCacheEntry(c.path(key), entry)
+	}
+	if err != nil {
+		c.cli.printWarning(fmt.Sprintf("Could not cache query response: %s", err))
+	}
+	return response
+}
+
+func writeQueryCacheEntry(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// clearQueryCache removes all cached query responses.
+func clearQueryCache(cli *CLI) error {
+	dir := queryCacheDir(cli)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not clear query cache: %w", err)
+	}
+	cli.printSuccess(fmt.Sprintf("Removed %d cached query responses", len(entries)))
+	return nil
+}
@@ -0,0 +1,232 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	geoRadiusPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*(km|m|mi)$`)
+	geoFieldPattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	// yqlTailWords are the words which may follow the where clause of a YQL statement
+	yqlTailWords = map[string]bool{"order": true, "limit": true, "offset": true, "timeout": true, "|": true, ";": true}
+)
+
+// geoQuery holds the position which a query should match documents near.
+type geoQuery struct {
+	field     string
+	latitude  float64
+	longitude float64
+	radius    string
+}
+
+// checkGeoOptions checks the options for matching documents near a position, and returns the position to match, or nil
+// if --near is not given.
+func checkGeoOptions(cmd *cobra.Command, opts *queryOptions) (*geoQuery, error) {
+	if opts.near == "" {
+		for _, name := range []string{"radius", "position-field"} {
+			if cmd.Flags().Changed(name) {
+				return nil, fmt.Errorf("option --%s requires --near", name)
+			}
+		}
+		return nil, nil
+	}
+	if opts.save != "" {
+		return nil, fmt.Errorf("options --near and --save cannot be combined")
+	}
+	example := "Example: vespa query --near 59.91,10.75 --radius 10km --position-field location"
+	if opts.positionField == "" {
+		return nil, errHint(fmt.Errorf("option --near requires --position-field"), "Set --position-field to the name of a position field of the schema", example)
+	}
+	if !geoFieldPattern.MatchString(opts.positionField) {
+		return nil, fmt.Errorf("invalid --position-field: %q: must be a field name", opts.positionField)
+	}
+	if opts.radius == "" {
+		return nil, errHint(fmt.Errorf("option --near requires --radius"), example)
+	}
+	latitude, longitude, err := parseNear(opts.near)
+	if err != nil {
+		return nil, errHint(err, example)
+	}
+	radius, err := parseRadius(opts.radius)
+	if err != nil {
+		return nil, errHint(err, example)
+	}
+	return &geoQuery{field: opts.positionField, latitude: latitude, longitude: longitude, radius: radius}, nil
+}
+
+// parseNear parses a position given as latitude and longitude in decimal degrees, separated by a comma.
+func parseNear(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid --near: %q: must be latitude and longitude separated by a comma", s)
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --near: %q: latitude must be a number", s)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --near: %q: longitude must be a number", s)
+	}
+	if latitude < -90 || latitude > 90 {
+		return 0, 0, fmt.Errorf("invalid --near: %q: latitude must be between -90 and 90", s)
+	}
+	if longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("invalid --near: %q: longitude must be between -180 and 180", s)
+	}
+	return latitude, longitude, nil
+}
+
+// parseRadius parses a distance with a unit suffix of km, m or mi, and returns it as written in YQL.
+func parseRadius(s string) (string, error) {
+	match := geoRadiusPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return "", fmt.Errorf("invalid --radius: %q: must be a positive number with unit km, m or mi, e.g. 10km", s)
+	}
+	if value, _ := strconv.ParseFloat(match[1], 64); value == 0 {
+		return "", fmt.Errorf("invalid --radius: %q: must be positive", s)
+	}
+	return match[1] + " " + match[2], nil
+}
+
+// clause returns the YQL geoLocation clause matching documents within the radius of this.
+func (g *geoQuery) clause() string {
+	return fmt.Sprintf("geoLocation(%s, %s, %s, %q)", g.field,
+		strconv.FormatFloat(g.latitude, 'f', -1, 64),
+		strconv.FormatFloat(g.longitude, 'f', -1, 64),
+		g.radius)
+}
+
+// expand sets the yql parameter of urlQuery to the YQL of the query given by urlQuery and fileQuery, with the
+// geoLocation clause of this added to its where clause. A query without YQL becomes a query for all documents within
+// the radius, combined with the query parameter if given.
+func (g *geoQuery) expand(urlQuery url.Values, fileQuery map[string]any) error {
+	yql := urlQuery.Get("yql")
+	if yql == "" && fileQuery["yql"] != nil {
+		s, ok := fileQuery["yql"].(string)
+		if !ok {
+			return fmt.Errorf("invalid yql in query file: must be a string")
+		}
+		yql = s
+	}
+	if yql == "" {
+		where := g.clause()
+		if urlQuery.Get("query") != "" || fileQuery["query"] != nil {
+			where += " and userQuery()"
+		}
+		urlQuery.Set("yql", "select * from sources * where "+where)
+		return nil
+	}
+	expanded, err := addWhereClause(yql, g.clause())
+	if err != nil {
+		return err
+	}
+	urlQuery.Set("yql", expanded)
+	return nil
+}
+
+// yqlWord is a word, or a pipe or semicolon, outside any parentheses or quotes of a YQL statement.
+type yqlWord struct {
+	text       string
+	start, end int
+}
+
+// topLevelWords returns the words of yql which are outside any parentheses and quoted strings.
+func topLevelWords(yql string) ([]yqlWord, error) {
+	var (
+		words []yqlWord
+		quote rune
+		depth int
+		start = -1
+	)
+	endWord := func(i int) {
+		if start >= 0 {
+			words = append(words, yqlWord{text: strings.ToLower(yql[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	escaped := false
+	for i, r := range yql {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+		if isWord && depth == 0 {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		endWord(i)
+		switch r {
+		case '"', '\'':
+			quote = r
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '|', ';':
+			if depth == 0 {
+				words = append(words, yqlWord{text: string(r), start: i, end: i + 1})
+			}
+		}
+	}
+	endWord(len(yql))
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("invalid yql: %q: unbalanced quotes or parentheses", yql)
+	}
+	return words, nil
+}
+
+// addWhereClause returns yql with clause added to its where clause, or with a where clause consisting of clause, if it
+// has none.
+func addWhereClause(yql, clause string) (string, error) {
+	words, err := topLevelWords(yql)
+	if err != nil {
+		return "", err
+	}
+	from, where, tail := -1, -1, len(yql)
+	for i, w := range words {
+		switch {
+		case w.text == "from" && from < 0:
+			from = i
+		case w.text == "where" && from >= 0 && where < 0:
+			where = i
+		case from >= 0 && yqlTailWords[w.text]:
+			tail = w.start
+		}
+		if tail < len(yql) {
+			break
+		}
+	}
+	if from < 0 {
+		return "", fmt.Errorf("invalid yql: %q: no from clause", yql)
+	}
+	rest := strings.TrimSpace(yql[tail:])
+	if rest != "" {
+		rest = " " + rest
+	}
+	if where < 0 {
+		return strings.TrimSpace(yql[:tail]) + " where " + clause + rest, nil
+	}
+	condition := strings.TrimSpace(yql[words[where].end:tail])
+	if condition == "" {
+		return "", fmt.Errorf("invalid yql: %q: empty where clause", yql)
+	}
+	return yql[:words[where].end] + " " + clause + " and (" + condition + ")" + rest, nil
+}
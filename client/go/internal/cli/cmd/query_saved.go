@@ -0,0 +1,128 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Saved queries of vespa query
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// savedQuery is a saved query in the list of saved queries.
+type savedQuery struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	YQL  string `json:"yql,omitempty"`
+}
+
+// checkSavedQueryOptions returns an error if the options managing saved queries are combined with options they do not
+// support.
+func checkSavedQueryOptions(cmd *cobra.Command, opts *queryOptions, args []string) error {
+	var given []string
+	for _, name := range []string{"save", "list-saved", "delete-saved", "clear-cache"} {
+		if cmd.Flags().Changed(name) {
+			given = append(given, "--"+name)
+		}
+	}
+	if len(given) > 1 {
+		return fmt.Errorf("options %s cannot be combined", strings.Join(given, " and "))
+	}
+	if opts.saved != "" && opts.postFile != "" {
+		return fmt.Errorf("options --saved and --file cannot be combined")
+	}
+	if (opts.listSaved || opts.deleteSaved != "" || opts.clearCache) && (len(args) > 0 || opts.saved != "" || opts.postFile != "") {
+		return fmt.Errorf("option %s cannot be combined with query parameters", given[0])
+	}
+	if opts.save != "" && len(args) == 0 && opts.saved == "" && opts.postFile == "" {
+		return errHint(fmt.Errorf("no query parameters to save"), "Example: vespa query --save "+opts.save+" 'yql=select * from music where true' hits=5")
+	}
+	return nil
+}
+
+// saveQuery saves the query parameters in arguments as the query of given name, on top of those read from postFile,
+// if any.
+func saveQuery(cli *CLI, name string, arguments []string, postFile string) error {
+	if !queryName.MatchString(name) {
+		return fmt.Errorf("invalid query name: %q: must consist of letters, digits, '-' and '_'", name)
+	}
+	parameters := make(map[string]any)
+	if postFile != "" {
+		var err error
+		if parameters, err = readQueryFile(postFile, cli.Stdin); err != nil {
+			return fmt.Errorf("bad JSON in postFile '%s': %w", postFile, err)
+		}
+	}
+	for _, argument := range arguments {
+		key, value := splitArg(argument)
+		parameters[key] = value
+	}
+	data, err := json.MarshalIndent(parameters, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := cli.config.savedQueryPath(name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not save query: %w", err)
+	}
+	cli.printSuccess("Saved query ", color.CyanString(name), " to ", color.CyanString(filename))
+	cli.printInfo("Run it with 'vespa query --saved ", name, "'")
+	return nil
+}
+
+// listSavedQueries prints the saved queries, and their YQL.
+func listSavedQueries(cli *CLI) error {
+	names, err := cli.config.listSavedQueries()
+	if err != nil {
+		return err
+	}
+	queries := make([]savedQuery, 0, len(names))
+	for _, name := range names {
+		query := savedQuery{Name: name, Path: cli.config.savedQueryPath(name)}
+		if parameters, err := readQueryFile(query.Path, nil); err == nil {
+			if yql, ok := parameters["yql"].(string); ok {
+				query.YQL = yql
+			}
+		}
+		queries = append(queries, query)
+	}
+	if cli.jsonOutput() {
+		return cli.printResult(queries)
+	}
+	if len(queries) == 0 {
+		cli.printInfo("No saved queries. Save one with 'vespa query --save <name> <query-parameters>'")
+		return nil
+	}
+	for _, query := range queries {
+		if query.YQL != "" {
+			fmt.Fprintf(cli.Stdout, "%s\t%s\n", color.CyanString(query.Name), query.YQL)
+		} else {
+			fmt.Fprintln(cli.Stdout, color.CyanString(query.Name))
+		}
+	}
+	return nil
+}
+
+// deleteSavedQuery deletes the saved query of given name.
+func deleteSavedQuery(cli *CLI, name string) error {
+	if !queryName.MatchString(name) {
+		return fmt.Errorf("invalid query name: %q: must consist of letters, digits, '-' and '_'", name)
+	}
+	filename, err := cli.config.findSavedQuery(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filename); err != nil {
+		return fmt.Errorf("could not delete saved query: %w", err)
+	}
+	cli.printSuccess("Deleted saved query ", color.CyanString(name))
+	return nil
+}
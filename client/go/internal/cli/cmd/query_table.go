@@ -0,0 +1,160 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTableCellWidth is the number of characters a cell of a table of hits is truncated to.
+const maxTableCellWidth = 40
+
+// tableColumn is a column of a table of hits, holding the value at path in each hit.
+type tableColumn struct {
+	path   string
+	header string
+}
+
+// printHitTable prints the hits of the query result in body as a table, with a column for each of given paths, after
+// relevance and id, unless these are among the paths. If no paths are given, the columns are the scalar fields of the
+// first hit. When standard output is not a terminal, the table is printed as tab-separated values instead.
+func printHitTable(cli *CLI, body io.Reader, paths []string, start time.Time) error {
+	result, err := decodeHits(body)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	hits := result.Root.Children
+	if len(paths) == 0 && len(hits) > 0 {
+		paths = scalarFieldPaths(hits[0])
+	}
+	var columns []tableColumn
+	for _, path := range []string{"relevance", "id"} {
+		if !slices.Contains(paths, path) {
+			columns = append(columns, tableColumn{path: path, header: path})
+		}
+	}
+	for _, path := range paths {
+		columns = append(columns, tableColumn{path: path, header: strings.TrimPrefix(path, "fields.")})
+	}
+	if cli.isTerminal() {
+		printAlignedTable(cli.Stdout, columns, hits)
+	} else {
+		printTSVTable(cli.Stdout, columns, hits)
+	}
+	cli.printInfo(fmt.Sprintf("Total hit count: %d, printed %d hits in %d ms", result.Root.Fields.TotalCount, len(hits), elapsed.Milliseconds()))
+	return nil
+}
+
+// scalarFieldPaths returns the paths of the fields of hit holding strings, numbers or booleans, sorted by name.
+func scalarFieldPaths(hit any) []string {
+	fields, _ := selectPath(hit, "fields").(map[string]any)
+	var paths []string
+	for name, value := range fields {
+		if name == "documentid" { // Same as the id column
+			continue
+		}
+		switch value.(type) {
+		case string, json.Number, bool:
+			paths = append(paths, "fields."+name)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func printTSVTable(w io.Writer, columns []tableColumn, hits []any) {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = tsvValue(column.header)
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+	for _, hit := range hits {
+		for i, column := range columns {
+			values[i] = tsvValue(selectPath(hit, column.path))
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+}
+
+func printAlignedTable(w io.Writer, columns []tableColumn, hits []any) {
+	cells := make([][]string, len(hits))
+	numeric := make([]bool, len(columns))
+	widths := make([]int, len(columns))
+	for i, column := range columns {
+		widths[i] = utf8.RuneCountInString(column.header)
+		numeric[i] = len(hits) > 0
+	}
+	for row, hit := range hits {
+		cells[row] = make([]string, len(columns))
+		for i, column := range columns {
+			value := selectPath(hit, column.path)
+			if _, ok := value.(json.Number); !ok && value != nil {
+				numeric[i] = false
+			}
+			cells[row][i] = tableCell(value)
+			widths[i] = max(widths[i], utf8.RuneCountInString(cells[row][i]))
+		}
+	}
+	printRow := func(values []string) {
+		var sb strings.Builder
+		for i, value := range values {
+			if i > 0 {
+				sb.WriteString("  ")
+			}
+			padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value))
+			if numeric[i] {
+				sb.WriteString(padding + value)
+			} else {
+				sb.WriteString(value + padding)
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(sb.String(), " "))
+	}
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		headers[i] = column.header
+	}
+	printRow(headers)
+	for _, row := range cells {
+		printRow(row)
+	}
+}
+
+// tableCell formats value for a cell of a table. Long strings are truncated, and structured values are summarised by
+// their type.
+func tableCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		v = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(v)
+		if utf8.RuneCountInString(v) > maxTableCellWidth {
+			return string([]rune(v)[:maxTableCellWidth-1]) + "…"
+		}
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	case []any:
+		return fmt.Sprintf("array[%d]", len(v))
+	case map[string]any:
+		if t, ok := v["type"].(string); ok && strings.HasPrefix(t, "tensor") {
+			return t
+		}
+		for _, key := range []string{"cells", "values", "blocks"} {
+			if _, ok := v[key]; ok {
+				return "tensor"
+			}
+		}
+		return fmt.Sprintf("struct{%d fields}", len(v))
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -5,14 +5,25 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 )
 
@@ -75,7 +86,7 @@ func TestQueryWithExplicitYqlParameter(t *testing.T) {
 }
 
 func TestIllegalQuery(t *testing.T) {
-	assertQueryError(t, 401, "query error message")
+	assertQueryError(t, 400, "query error message")
 }
 
 func TestServerError(t *testing.T) {
@@ -102,6 +113,56 @@ func TestQueryHeader(t *testing.T) {
 	assert.Equal(t, "Error: invalid header \"X-Foo\": missing colon separator\n", stderr.String())
 }
 
+func TestQueryDataPlaneHeaders(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("config", "set", "data-plane-headers", `{"X-Org-Token": "secret", "x-foo": "config"}`))
+
+	// Headers given by flag replace those of the option, and values are redacted from verbose output
+	client.NextResponseString(200, `{"query": "result"}`)
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "-v", "--header", "X-Foo: flag", "select something"))
+	assert.Equal(t, "secret", client.LastRequest.Header.Get("X-Org-Token"))
+	assert.Equal(t, []string{"flag"}, client.LastRequest.Header.Values("X-Foo"))
+	assert.Contains(t, stderr.String(), "-H 'X-Org-Token: [REDACTED]'")
+	assert.Contains(t, stderr.String(), "-H 'X-Foo: [REDACTED]'")
+	assert.NotContains(t, stderr.String(), "secret")
+
+	stderr.Reset()
+	client.NextResponseString(200, `{"query": "result"}`)
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "-v", "--show-secrets", "select something"))
+	assert.Contains(t, stderr.String(), "-H 'X-Org-Token: secret'")
+
+	// Headers set by Vespa CLI itself cannot be given
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--header", "content-type: text/plain", "select something"))
+	assert.Equal(t, "Error: header 'Content-Type' cannot be set, as it is set by Vespa CLI\nHint: Remove the header from --header, or from the data-plane-headers option\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("config", "set", "data-plane-headers", `{"Content-Type": "text/plain"}`))
+	assert.Equal(t, "Error: header 'Content-Type' cannot be set, as it is set by Vespa CLI\nHint: Remove the header from --header, or from the data-plane-headers option\n", stderr.String())
+	stderr.Reset()
+	assert.NotNil(t, cli.Run("config", "set", "data-plane-headers", `["X-Org-Token"]`))
+	assert.Contains(t, stderr.String(), "Error: invalid value for data-plane-headers: json: cannot unmarshal array")
+}
+
+func TestQueryTraceFileSecretHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"root": {"fields": {"totalCount": 0}}}`))
+	}))
+	defer server.Close()
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	cli, _, _ := newTestCLI(t)
+	cli.httpClient = httputil.NewClient(time.Minute)
+	require.Nil(t, cli.Run("config", "set", "data-plane-headers", `{"X-Org-Token": "secret"}`))
+	require.Nil(t, cli.Run("-t", server.URL, "--trace-file", traceFile, "query", "select something"))
+	data, err := os.ReadFile(traceFile)
+	require.Nil(t, err)
+	var entry httputil.TraceEntry
+	require.Nil(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "[REDACTED]", entry.RequestHeaders.Get("X-Org-Token"))
+	assert.NotContains(t, string(data), "secret")
+}
+
 func TestStreamingQuery(t *testing.T) {
 	body := `
 event: token
@@ -182,6 +243,317 @@ func TestQueryPostFileWithArgs(t *testing.T) {
 	assert.Equal(t, "http://foo.bar:1234/search/", client.LastRequest.URL.String())
 }
 
+func TestQueryRepeat(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"warmup":"result"}`)
+	for range 4 {
+		client.NextResponseString(200, `{"query":"result"}`)
+	}
+	client.NextResponseString(500, `{"error":"overloaded"}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	err := cli.Run("-t", "http://127.0.0.1:8080", "query", "--repeat", "5", "select from sources * where title contains 'foo'")
+	require.NotNil(t, err)
+	assert.Equal(t, "1 of 5 queries failed", err.Error())
+	assert.Len(t, client.Requests, 6)
+	assert.Regexp(t, `^Queries: 5 \(1 errors\) in [0-9.]+ s, [0-9.]+ QPS
+Latency: min [0-9.]+ ms, median [0-9.]+ ms, p95 [0-9.]+ ms, p99 [0-9.]+ ms, max [0-9.]+ ms
+$`, stdout.String())
+	assert.Equal(t, "Error: 1 of 5 queries failed\n", stderr.String())
+
+	client = &mock.HTTPClient{}
+	for range 22 {
+		client.NextResponseString(200, `{"query":"result"}`)
+	}
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--repeat", "20", "--concurrency", "4", "--warmup", "2", "--format", "json", "select from sources * where title contains 'foo'"))
+	assert.Len(t, client.Requests, 22)
+	assert.True(t, client.Consumed())
+	var benchmark map[string]any
+	require.Nil(t, json.Unmarshal(stdout.Bytes(), &benchmark))
+	assert.Equal(t, float64(20), benchmark["query.count"])
+	assert.Equal(t, float64(20), benchmark["query.ok.count"])
+	assert.Equal(t, float64(0), benchmark["query.error.count"])
+	assert.Equal(t, false, benchmark["query.interrupted"])
+	for _, key := range []string{"query.seconds", "query.ok.rate", "query.latency.millis.min", "query.latency.millis.p50", "query.latency.millis.p95", "query.latency.millis.p99", "query.latency.millis.max"} {
+		assert.Contains(t, benchmark, key)
+	}
+
+	cli, _, _ = newTestCLI(t)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "json", "select from sources * where title contains 'foo'"))
+}
+
+func TestQueryRepeatInterrupted(t *testing.T) {
+	client := &mock.HTTPClient{}
+	mockServiceStatus(client, "container")
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.now = func() time.Time { return time.Unix(0, 0) }
+	service, err := documentService(cli, &Waiter{cli: cli})
+	require.Nil(t, err)
+	client.Requests = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := &http.Request{URL: &url.URL{Scheme: "http", Host: "127.0.0.1:8080", Path: "/search/"}, Header: make(http.Header)}
+	require.Nil(t, benchmarkQuery(ctx, cli, service, request, nil, time.Second, &queryOptions{repeat: 10, concurrency: 1, warmup: 1}))
+	assert.Len(t, client.Requests, 0)
+	assert.Equal(t, "Queries: 0 (0 errors) in 0.000 s, 0.0 QPS\nLatency: min 0.000 ms, median 0.000 ms, p95 0.000 ms, p99 0.000 ms, max 0.000 ms\n", stdout.String())
+	assert.Equal(t, "Warning: Interrupted after 0 queries\n", stderr.String())
+}
+
+func TestQueryPostFileFromStdin(t *testing.T) {
+	client := &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(200, `{"query":"result"}`)
+	cli, _, _ := newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString(`{
+	"yql": "select * from music
+	        where album contains 'head'",
+	"hits": 10,
+	"input.query(q)": [0.1, 0.25],
+	"ranking": {"profile": "fancy", "features": {"query(w)": 2}},
+	"timeout": "2500ms"
+}`)
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--file", "-", "ranking.profile=simple", "hits=5"))
+	assert.Equal(t,
+		`{"hits":"5","input.query(q)":[0.1,0.25],"ranking":{"features":{"query(w)":2}},"ranking.profile":"simple","timeout":"2500ms","yql":"select * from music          where album contains 'head'"}`,
+		string(client.LastBody))
+
+	client.NextResponseString(400, `{"root":{"errors":[{"message":"bad yql"}]}}`)
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString(`{"yql": "select", "timeout": 3}`)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--file", "-"))
+	assert.Equal(t, `{"timeout":3,"yql":"select"}`, string(client.LastBody))
+	assert.Equal(t, "Error: invalid query: Status 400\n{\n    \"root\": {\n        \"errors\": [\n            {\n                \"message\": \"bad yql\"\n            }\n        ]\n    }\n}\n", stderr.String())
+
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString(`{"yql": `)
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--file", "-"))
+	assert.Contains(t, stderr.String(), "bad JSON in postFile '-'")
+}
+
+func TestQueryTable(t *testing.T) {
+	response := `{"root": {"fields": {"totalCount": 42}, "children": [
+  {"id": "id:ns:music::1", "relevance": 0.5, "fields": {"documentid": "id:ns:music::1", "title": "A title which is too long to fit in a table cell", "year": 1997, "t": {"type": "tensor<float>(x[2])", "values": [1, 2]}, "tags": ["a"]}},
+  {"id": "id:ns:music::2", "relevance": 0.25, "fields": {"title": "Ok\tComputer", "year": 10, "tags": ["a", "b"]}},
+  {"id": "id:ns:music::3", "relevance": 0.125, "fields": {"year": 2000}}
+]}}`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, response)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return true }
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "table", "select something"))
+	assert.Equal(t, `relevance  id              title                                     year
+      0.5  id:ns:music::1  A title which is too long to fit in a t…  1997
+     0.25  id:ns:music::2  Ok Computer                                 10
+    0.125  id:ns:music::3                                            2000
+`, stdout.String())
+	assert.Regexp(t, `^Total hit count: 42, printed 3 hits in [0-9]+ ms\n$`, stderr.String())
+
+	client.NextResponseString(200, response)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	cli.isTerminal = func() bool { return true }
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "table", "--select", "id,fields.t,fields.tags,fields.missing", "select something"))
+	assert.Equal(t, `relevance  id              t                    tags      missing
+      0.5  id:ns:music::1  tensor<float>(x[2])  array[1]
+     0.25  id:ns:music::2                       array[2]
+    0.125  id:ns:music::3
+`, stdout.String())
+
+	// Without a terminal, tab-separated values are printed
+	client.NextResponseString(200, response)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "table", "--select", "fields.title", "select something"))
+	assert.Equal(t, "relevance\tid\ttitle\n"+
+		"0.5\tid:ns:music::1\tA title which is too long to fit in a table cell\n"+
+		"0.25\tid:ns:music::2\tOk\\tComputer\n"+
+		"0.125\tid:ns:music::3\t\n", stdout.String())
+
+	cli, _, stderr = newTestCLI(t)
+	require.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "table", "--repeat", "2", "select something"))
+	assert.Equal(t, "Error: --format table cannot be combined with --repeat, --stream or --profile\n", stderr.String())
+}
+
+func TestQuerySelectFields(t *testing.T) {
+	response := `{"root": {"fields": {"totalCount": 42}, "children": [
+  {"id": "id:ns:music::1", "relevance": 0.5, "fields": {"title": "Head\tFull", "t": {"cells": [{"address": {"x": "0"}, "value": 1.0}]}, "tags": ["a", "b"]}},
+  {"id": "id:ns:music::2", "relevance": 0.25, "fields": {}}
+]}}`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, response)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--select", "id,relevance,fields.title,fields.t.cells,fields.tags.1,fields.missing.x", "select something"))
+	assert.Equal(t, "id:ns:music::1\t0.5\tHead\\tFull\t[{\"address\":{\"x\":\"0\"},\"value\":1.0}]\tb\t\n"+
+		"id:ns:music::2\t0.25\t\t\t\t\n", stdout.String())
+	assert.Regexp(t, `^Total hit count: 42, printed 2 hits in [0-9]+ ms\n$`, stderr.String())
+
+	client.NextResponseString(200, response)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--select", "id,fields.title", "--format", "json", "select something"))
+	assert.Equal(t, `{"fields.title":"Head\tFull","id":"id:ns:music::1"}
+{"fields.title":null,"id":"id:ns:music::2"}
+`, stdout.String())
+}
+
+func TestQueryTraceFormat(t *testing.T) {
+	response := `{"trace": {"children": [
+  {"message": "Query parsed", "timestamp": 0},
+  {"children": [
+    {"message": "Invoking chain 'vespa'", "timestamp": 1},
+    {"message": "Dispatching to group 0", "timestamp": 3},
+    {"children": [{"message": {"distribution-key": 0, "duration_ms": 40}, "timestamp": 4}]}
+  ]},
+  {"message": "Fill hits", "timestamp": 45},
+  {"message": "Rendering", "timestamp": 50},
+  {"message": "Done"}
+]}, "root": {"fields": {"totalCount": 1}}}`
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, response)
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "trace", "select something", "tracelevel=3", "trace.timestamps=true"))
+	assert.Equal(t, "      0 ms      +1 ms  Query parsed\n"+
+		"      1 ms      +2 ms    Invoking chain 'vespa'\n"+
+		"      3 ms      +1 ms    Dispatching to group 0\n"+
+		"      4 ms     +41 ms      {\"distribution-key\":0,\"duration_ms\":40}\n"+
+		"     45 ms      +5 ms  Fill hits\n"+
+		"     50 ms             Rendering\n"+
+		"                       Done\n", stripANSI(stdout.String()))
+	assert.Contains(t, stdout.String(), color.RedString("%10s", "+41 ms"))
+
+	client.NextResponseString(200, `{"root": {"fields": {"totalCount": 1}}}`)
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--format", "trace", "select something"))
+	assert.Equal(t, "Error: query result has no trace\nHint: Set tracelevel to a value above 0, e.g. tracelevel=3\n", stderr.String())
+}
+
+func stripANSI(s string) string { return regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(s, "") }
+
+func TestQueryAll(t *testing.T) {
+	page := func(ids ...int) string {
+		hits := make([]string, 0, len(ids))
+		for _, id := range ids {
+			hits = append(hits, fmt.Sprintf(`{"id": "id:ns:music::%d", "relevance": 1.0}`, id))
+		}
+		return `{"root": {"fields": {"totalCount": 5}, "children": [` + strings.Join(hits, ",") + `]}}`
+	}
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, page(1, 2))
+	client.NextResponseString(200, page(3, 4))
+	client.NextResponseString(200, page(5))
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--all", "select something", "hits=2"))
+	assert.Equal(t, `{"id":"id:ns:music::1","relevance":1.0}
+{"id":"id:ns:music::2","relevance":1.0}
+{"id":"id:ns:music::3","relevance":1.0}
+{"id":"id:ns:music::4","relevance":1.0}
+{"id":"id:ns:music::5","relevance":1.0}
+`, stdout.String())
+	assert.Equal(t, "Fetched 5 of 5 hits in 3 requests\n", stderr.String())
+	require.Len(t, client.Requests, 3)
+	for i, offset := range []string{"0", "2", "4"} {
+		assert.Equal(t, offset, client.Requests[i].URL.Query().Get("offset"))
+		assert.Equal(t, "2", client.Requests[i].URL.Query().Get("hits"))
+	}
+
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, page(1, 2))
+	client.NextResponseString(200, page(3))
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--max-hits", "3", "select something", "hits=2"))
+	assert.Equal(t, 3, strings.Count(stdout.String(), "\n"))
+	assert.Equal(t, "Fetched 3 of 5 hits in 2 requests\n", stderr.String())
+	assert.Equal(t, "1", client.LastRequest.URL.Query().Get("hits"))
+
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, page(1, 2))
+	client.NextResponseString(400, `{"root": {"errors": [{"code": 4, "message": "offset must be in range [0, 1]"}]}}`)
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--all", "select something", "hits=2"))
+	assert.Equal(t, 2, strings.Count(stdout.String(), "\n"))
+	assert.Contains(t, stderr.String(), "Fetched 2 of 5 hits in 2 requests\n")
+	assert.Contains(t, stderr.String(), "Error: query at offset 2 was rejected, the maximum offset is likely reached")
+	assert.Contains(t, stderr.String(), "Hint: Use 'vespa visit' to export all documents matching a selection\n")
+
+	// Other rejections are query errors
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, page(1, 2))
+	client.NextResponseString(400, `{"root": {"errors": [{"code": 4, "message": "Could not parse continuation"}]}}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--all", "select something", "hits=2"))
+	assert.Contains(t, stderr.String(), "Error: Status 400 from container at 127.0.0.1:8080\n")
+	assert.NotContains(t, stderr.String(), "maximum offset")
+
+	// Queries beyond the maximum offset are not issued, and pages are at most the default maximum hits
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, page(1, 2))
+	client.NextResponseString(200, page(3, 4))
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	require.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--all", "--max-offset", "3", "select something", "hits=2"))
+	assert.Equal(t, 4, strings.Count(stdout.String(), "\n"))
+	assert.Len(t, client.Requests, 2)
+	assert.Contains(t, stderr.String(), "Fetched 4 of 5 hits in 2 requests\n")
+	assert.Contains(t, stderr.String(), "Error: stopped at offset 4, as queries beyond the maximum offset 3 are rejected\n")
+
+	client = &mock.HTTPClient{}
+	client.NextResponseString(200, page(1))
+	cli, _, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--all", "select something", "hits=1000"))
+	assert.Equal(t, "400", client.LastRequest.URL.Query().Get("hits"))
+}
+
+func TestStreamingQueryIdleTimeout(t *testing.T) {
+	newServer := func(gap time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, token := range []string{"The", " Manhattan", " Project", ""} {
+				select {
+				case <-time.After(gap):
+				case <-r.Context().Done():
+					return
+				}
+				if token == "" {
+					fmt.Fprint(w, "event: end\ndata: {\"timing\": {\"total\": 1.6}}\n\n")
+				} else {
+					fmt.Fprintf(w, "event: token\ndata: {\"token\": %q}\n\n", token)
+				}
+				w.(http.Flusher).Flush()
+			}
+		}))
+	}
+
+	// Total response time exceeds the timeout of 1.1 seconds, but the time between events does not
+	server := newServer(400 * time.Millisecond)
+	defer server.Close()
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = httputil.NewClient(time.Minute)
+	require.Nil(t, cli.Run("-t", server.URL, "query", "--stream", "select something", "timeout=100ms"))
+	assert.Equal(t, "The Manhattan Project\n{\n    \"timing\": {\n        \"total\": 1.6\n    }\n}\n", stdout.String())
+
+	slowServer := newServer(1500 * time.Millisecond)
+	defer slowServer.Close()
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = httputil.NewClient(time.Minute)
+	require.NotNil(t, cli.Run("-t", slowServer.URL, "query", "--stream", "select something", "timeout=100ms"))
+	assert.Equal(t, "Error: request failed: no response within 1.1s\n", stderr.String())
+}
+
 func assertStreamingQuery(t *testing.T, expectedOutput, body string, args ...string) {
 	t.Helper()
 	client := &mock.HTTPClient{}
@@ -245,9 +617,420 @@ func assertQueryServiceError(t *testing.T, status int, errorMessage string) {
 	client.NextResponseString(status, errorMessage)
 	cli, _, stderr := newTestCLI(t)
 	cli.httpClient = client
-	assert.NotNil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "yql=select from sources * where title contains 'foo'"))
+	err := cli.Run("-t", "http://127.0.0.1:8080", "query", "yql=select from sources * where title contains 'foo'")
+	require.NotNil(t, err)
 	assert.Equal(t,
-		"Error: Status "+strconv.Itoa(status)+" from container at 127.0.0.1:8080\n"+errorMessage+"\n",
+		"Error: Status "+strconv.Itoa(status)+" from container at 127.0.0.1:8080 [SERVER_ERROR]\n"+errorMessage+"\n",
 		stderr.String(),
 		"error output")
+	assert.Equal(t, exitTransient, err.(ErrCLI).Status)
+}
+
+func TestQueryTraceFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"root": {"fields": {"totalCount": 0}}}`))
+	}))
+	defer server.Close()
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = httputil.NewClient(time.Minute)
+	require.Nil(t, cli.Run("-t", server.URL, "--trace-file", traceFile, "query", "select something", "--header", "Authorization: Bearer secret"))
+
+	data, err := os.ReadFile(traceFile)
+	require.Nil(t, err)
+	var entry httputil.TraceEntry
+	require.Nil(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "GET", entry.Method)
+	assert.Equal(t, server.URL+"/search/?timeout=10s&yql=select+something", entry.URL)
+	assert.Equal(t, "[REDACTED]", entry.RequestHeaders.Get("Authorization"))
+	assert.Equal(t, 200, entry.Status)
+	assert.Equal(t, `{"root": {"fields": {"totalCount": 0}}}`, entry.ResponseBody)
+	assert.NotContains(t, string(data), "secret")
+	assert.Nil(t, cli.tracer)
+
+	// Only data plane commands can be traced
+	cli, _, stderr = newTestCLI(t)
+	require.NotNil(t, cli.Run("--trace-file", traceFile, "status"))
+	assert.Equal(t, "Error: --trace-file is not supported by vespa status\nHint: Supported commands are document, feed, query, visit\n", stderr.String())
+}
+
+func TestQuerySaved(t *testing.T) {
+	// Each command runs with a new CLI, as flags keep their values between runs
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	client := &mock.HTTPClient{ReadBody: true}
+	run := func(args ...string) (string, string, error) {
+		cli, stdout, stderr := newTestCLI(t, "VESPA_CLI_HOME="+homeDir)
+		cli.httpClient = client
+		err := cli.Run(args...)
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, _, err := run("query", "--save", "heads", `yql=select * from music where album contains "head"`, "ranking=bm25", "hits=5")
+	require.Nil(t, err)
+	path := filepath.Join(homeDir, "queries", "heads.json")
+	assert.Equal(t, "Success: Saved query heads to "+path+"\n", stdout)
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, `{
+  "hits": "5",
+  "ranking": "bm25",
+  "yql": "select * from music where album contains \"head\""
+}
+`, string(data))
+
+	client.NextResponseString(200, `{"query":"result"}`)
+	_, _, err = run("-t", "http://127.0.0.1:8080", "query", "--saved", "heads", "hits=20")
+	require.Nil(t, err)
+	assert.Equal(t, `{"hits":"20","ranking":"bm25","timeout":"10s","yql":"select * from music where album contains \"head\""}`, string(client.LastBody))
+	assert.Equal(t, "POST", client.LastRequest.Method)
+
+	// A saved query can be given as a path, and be saved under another name with overrides
+	file := filepath.Join(t.TempDir(), "shared.json")
+	require.Nil(t, os.WriteFile(file, []byte(`{"yql": "select * from music where true", "hits": 3}`), 0644))
+	client.NextResponseString(200, `{"query":"result"}`)
+	_, _, err = run("-t", "http://127.0.0.1:8080", "query", "--saved", file)
+	require.Nil(t, err)
+	assert.Equal(t, `{"hits":3,"timeout":"10s","yql":"select * from music where true"}`, string(client.LastBody))
+	_, _, err = run("query", "--saved", file, "--save", "all", "ranking=native")
+	require.Nil(t, err)
+	data, err = os.ReadFile(filepath.Join(homeDir, "queries", "all.json"))
+	require.Nil(t, err)
+	assert.Equal(t, "{\n  \"hits\": 3,\n  \"ranking\": \"native\",\n  \"yql\": \"select * from music where true\"\n}\n", string(data))
+	_, _, err = run("query", "--save", "empty", "hits=1")
+	require.Nil(t, err)
+
+	stdout, _, err = run("query", "--list-saved")
+	require.Nil(t, err)
+	assert.Equal(t, "all\tselect * from music where true\nempty\nheads\tselect * from music where album contains \"head\"\n", stdout)
+
+	stdout, _, err = run("query", "--delete-saved", "empty")
+	require.Nil(t, err)
+	assert.Equal(t, "Success: Deleted saved query empty\n", stdout)
+	_, stderr, err := run("query", "--delete-saved", "empty")
+	assert.NotNil(t, err)
+	assert.Contains(t, stderr, "Error: no query named empty is saved\nHint: Save it with 'vespa query --save empty <query-parameters>'\n")
+	_, stderr, err = run("query", "--saved", "missing.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, stderr, "Error: could not read saved query: stat missing.json: no such file or directory\n")
+}
+
+func TestQuerySavedInvalid(t *testing.T) {
+	assertQuerySavedError(t, "Error: invalid query name: \"my query\": must consist of letters, digits, '-' and '_'\n", "--save", "my query", "hits=5")
+	assertQuerySavedError(t, "Error: no query parameters to save\n", "--save", "q1")
+	assertQuerySavedError(t, "Error: options --save and --list-saved cannot be combined\n", "--save", "q1", "--list-saved", "hits=5")
+	assertQuerySavedError(t, "Error: options --saved and --file cannot be combined\n", "--saved", "q1", "--file", "q1.json")
+	assertQuerySavedError(t, "Error: option --delete-saved cannot be combined with query parameters\n", "--delete-saved", "q1", "hits=5")
+}
+
+func assertQuerySavedError(t *testing.T, expectedErr string, args ...string) {
+	t.Helper()
+	cli, _, stderr := newTestCLI(t)
+	assert.NotNil(t, cli.Run(append([]string{"query"}, args...)...))
+	assert.Contains(t, stderr.String(), expectedErr)
+}
+
+func TestQueryEndpointCache(t *testing.T) {
+	cli, _, stderr := newTestCLI(t, "NO_COLOR=true", "CI=true")
+	require.Nil(t, cli.Run("config", "set", "application", "t1.a1.i1"))
+	require.Nil(t, cli.Run("config", "set", "target", "cloud"))
+	require.Nil(t, cli.Run("config", "set", "zone", "dev.us-north-1"))
+	require.Nil(t, cli.Run("auth", "api-key"))
+	require.Nil(t, cli.Run("auth", "cert", "-N"))
+	client := &mock.HTTPClient{}
+	cli.httpClient = client
+	deploymentURI := "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1"
+	nextEndpoints := func(host string) {
+		client.NextResponse(mock.HTTPResponse{
+			URI:    deploymentURI,
+			Status: 200,
+			Body:   []byte(`{"endpoints": [{"cluster": "search", "url": "https://` + host + `", "scope": "zone", "authMethod": "mtls"}]}`),
+		})
+	}
+	discoveries := func() int {
+		n := 0
+		for _, r := range client.Requests {
+			if r.URL.Path == deploymentURI {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Endpoints are discovered once, and then read from the cache
+	nextEndpoints("a.example.com")
+	client.NextResponseString(200, "{}")
+	require.Nil(t, cli.Run("query", "select * from music"))
+	client.NextResponseString(200, "{}")
+	require.Nil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, 1, discoveries())
+	assert.Equal(t, "a.example.com", client.LastRequest.URL.Host)
+
+	// Endpoints are discovered again when the cache is disabled for a command
+	nextEndpoints("b.example.com")
+	client.NextResponseString(200, "{}")
+	require.Nil(t, cli.Run("query", "--no-endpoint-cache", "select * from music"))
+	assert.Equal(t, 2, discoveries())
+	assert.Equal(t, "b.example.com", client.LastRequest.URL.Host)
+	cli.noEndpointCache = false
+
+	// A cached endpoint which is not found is discovered again on the next command
+	client.NextResponseString(404, "{}")
+	assert.NotNil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, 2, discoveries())
+	nextEndpoints("c.example.com")
+	client.NextResponseString(200, "{}")
+	stderr.Reset()
+	require.Nil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, 3, discoveries())
+	assert.Equal(t, "c.example.com", client.LastRequest.URL.Host)
+	assert.Equal(t, "", stderr.String())
+
+	// Endpoints expire
+	cli.now = func() time.Time { return time.Now().Add(11 * time.Minute) }
+	nextEndpoints("d.example.com")
+	client.NextResponseString(200, "{}")
+	require.Nil(t, cli.Run("query", "select * from music"))
+	assert.Equal(t, 4, discoveries())
+	assert.Equal(t, "d.example.com", client.LastRequest.URL.Host)
+}
+
+func TestQueryCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	client := &mock.HTTPClient{}
+	newCLI := func() (*CLI, *bytes.Buffer, *bytes.Buffer) {
+		cli, stdout, stderr := newTestCLI(t, "VESPA_CLI_CACHE_DIR="+cacheDir)
+		cli.httpClient = client
+		return cli, stdout, stderr
+	}
+	query := func(args ...string) (string, string, error) {
+		cli, stdout, stderr := newCLI()
+		err := cli.Run(append([]string{"-t", "http://127.0.0.1:8080", "query"}, args...)...)
+		return stdout.String(), stderr.String(), err
+	}
+	sent := func() int { return len(client.Requests) }
+
+	// The first query is sent, and its response cached
+	client.NextResponseString(200, `{"query":"foo"}`)
+	stdout, stderr, err := query("--cache", "10m", "select something")
+	require.Nil(t, err)
+	assert.Equal(t, "{\n    \"query\": \"foo\"\n}\n", stdout)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, 1, sent())
+
+	// An identical query is answered from the cache
+	stdout, stderr, err = query("--cache", "10m", "select something")
+	require.Nil(t, err)
+	assert.Equal(t, "{\n    \"query\": \"foo\"\n}\n", stdout)
+	assert.Equal(t, "(cached)\n", stderr)
+	assert.Equal(t, 1, sent())
+
+	// A query with other parameters is sent
+	client.NextResponseString(200, `{"query":"bar"}`)
+	stdout, _, err = query("--cache", "10m", "select something", "hits=5")
+	require.Nil(t, err)
+	assert.Equal(t, "{\n    \"query\": \"bar\"\n}\n", stdout)
+	assert.Equal(t, 2, sent())
+
+	// With --no-cache, the query is sent, and the new response cached
+	client.NextResponseString(200, `{"query":"baz"}`)
+	stdout, _, err = query("--cache", "10m", "--no-cache", "select something")
+	require.Nil(t, err)
+	assert.Equal(t, "{\n    \"query\": \"baz\"\n}\n", stdout)
+	assert.Equal(t, 3, sent())
+	stdout, _, err = query("--cache", "10m", "select something")
+	require.Nil(t, err)
+	assert.Equal(t, "{\n    \"query\": \"baz\"\n}\n", stdout)
+	assert.Equal(t, 3, sent())
+
+	// Without --cache, the query is sent
+	client.NextResponseString(200, `{"query":"baz"}`)
+	_, stderr, err = query("select something")
+	require.Nil(t, err)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, 4, sent())
+
+	// Cached responses expire
+	cli, _, stderr2 := newCLI()
+	cli.now = func() time.Time { return time.Now().Add(11 * time.Minute) }
+	client.NextResponseString(200, `{"query":"baz"}`)
+	require.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--cache", "10m", "select something"))
+	assert.Equal(t, "", stderr2.String())
+	assert.Equal(t, 5, sent())
+
+	// Errors and large responses are not cached
+	client.NextResponseString(500, `{"error":"bad"}`)
+	_, _, err = query("--cache", "10m", "select other")
+	assert.NotNil(t, err)
+	client.NextResponseString(200, strings.Repeat("x", queryCacheMaxSize+1))
+	_, _, err = query("--cache", "10m", "select other")
+	require.Nil(t, err)
+	client.NextResponseString(200, `{"query":"other"}`)
+	_, stderr, err = query("--cache", "10m", "select other")
+	require.Nil(t, err)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, 8, sent())
+
+	// All cached responses are removed
+	stdout, _, err = query("--clear-cache")
+	require.Nil(t, err)
+	assert.Equal(t, "Success: Removed 3 cached query responses\n", stdout)
+	client.NextResponseString(200, `{"query":"foo"}`)
+	_, stderr, err = query("--cache", "10m", "select something")
+	require.Nil(t, err)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, 9, sent())
+
+	// Caching is not supported for repeated and paginated queries
+	_, stderr, err = query("--cache", "10m", "--repeat", "2", "select something")
+	assert.NotNil(t, err)
+	assert.Equal(t, "Error: --cache cannot be combined with --repeat, --all, --max-hits, --stream or --profile\n", stderr)
+	_, stderr, err = query("--cache", "-1m", "select something")
+	assert.NotNil(t, err)
+	assert.Equal(t, "Error: invalid --cache: -1m0s: must be positive\n", stderr)
+	_, stderr, err = query("--clear-cache", "select something")
+	assert.NotNil(t, err)
+	assert.Equal(t, "Error: option --clear-cache cannot be combined with query parameters\n", stderr)
+}
+
+func TestQueriesFile(t *testing.T) {
+	dir := t.TempDir()
+	queriesFile := filepath.Join(dir, "queries.txt")
+	require.Nil(t, os.WriteFile(queriesFile, []byte(`# Benchmark queries
+select * from music where album contains "head"
+
+{"yql": "select * from music where true",
+ "ranking": {"profile": "bm25"}, "timeout": "3s"}
+select * from music where bad
+`), 0644))
+	client := &mock.HTTPClient{ReadBody: true}
+	client.NextResponseString(200, `{"root": {"fields": {"totalCount": 7}, "children": [
+  {"id": "id:ns:music::1", "relevance": 0.5, "fields": {"title": "Head"}},
+  {"id": "id:ns:music::2", "relevance": 0.25, "fields": {}}
+]}}`)
+	client.NextResponseString(200, `{"root": {"fields": {"totalCount": 0}}}`)
+	client.NextResponseString(400, `{"root": {"errors": [{"code": 4, "message": "Could not parse query"}]}}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	err := cli.Run("-t", "http://127.0.0.1:8080", "query", "--queries-file", queriesFile, "--fields", "fields.title", "hits=2")
+	require.NotNil(t, err)
+	assert.Equal(t, "1 of 3 queries failed", err.Error())
+	require.Len(t, client.Requests, 3)
+	assert.Equal(t, "POST", client.Requests[0].Method)
+	assert.Equal(t, `{"hits":"2","timeout":"10s","yql":"select * from music where bad"}`, string(client.LastBody))
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	latency := regexp.MustCompile(`"latencyMillis":[0-9.]+,`)
+	assert.Equal(t, `{"hits":[{"fields.title":"Head","id":"id:ns:music::1","relevance":0.5},{"fields.title":null,"id":"id:ns:music::2","relevance":0.25}],"line":2,"query":{"yql":"select * from music where album contains \"head\""},"totalCount":7}`,
+		latency.ReplaceAllString(lines[0], ""))
+	assert.Equal(t, `{"hits":[],"line":4,"query":{"ranking":{"profile":"bm25"},"timeout":"3s","yql":"select * from music where true"},"totalCount":0}`,
+		latency.ReplaceAllString(lines[1], ""))
+	assert.Equal(t, `{"error":"Status 400: Could not parse query","line":6,"query":{"yql":"select * from music where bad"}}`, lines[2])
+	assert.Regexp(t, `^Queries: 3 \(1 failed\) in [0-9.]+ s
+Latency: min [0-9.]+ ms, median [0-9.]+ ms, p95 [0-9.]+ ms, p99 [0-9.]+ ms, max [0-9.]+ ms
+Error: 1 of 3 queries failed
+$`, stderr.String())
+
+	client = &mock.HTTPClient{}
+	client.NextResponseString(500, `{"root": {"errors": [{"message": "overloaded"}]}}`)
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.Stdin = bytes.NewBufferString("select * from music where true\nselect * from music where false\n")
+	err = cli.Run("-t", "http://127.0.0.1:8080", "query", "--queries-file", "-", "--strict")
+	require.NotNil(t, err)
+	assert.Len(t, client.Requests, 1)
+	assert.Equal(t, `{"error":"Status 500: overloaded","line":1,"query":{"yql":"select * from music where true"}}`+"\n", stdout.String())
+	assert.Contains(t, stderr.String(), "Queries: 1 (1 failed)")
+	assert.Contains(t, stderr.String(), "Error: query at line 1 failed: Status 500: overloaded\nHint: Omit --strict to issue the remaining queries regardless of failures\n")
+
+	cli, _, _ = newTestCLI(t)
+	assert.Equal(t, "options --queries-file and --select cannot be combined", cli.Run("query", "--queries-file", queriesFile, "--select", "id").Error())
+	cli, _, _ = newTestCLI(t)
+	assert.Equal(t, "option --fields requires --queries-file", cli.Run("query", "--fields", "id", "select something").Error())
+}
+
+func TestQueryNear(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, "{\"query\":\"result\"}")
+	cli, _, _ := newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--near", "59.91,10.75", "--radius", "10km", "--position-field", "location",
+		`select * from restaurants where cuisine contains "pizza" or cuisine contains "pasta" order by rating limit 5`))
+	assert.Equal(t, `select * from restaurants where geoLocation(location, 59.91, 10.75, "10 km") and (cuisine contains "pizza" or cuisine contains "pasta") order by rating limit 5`,
+		client.LastRequest.URL.Query().Get("yql"))
+
+	client.NextResponseString(200, "{\"query\":\"result\"}")
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--near", "59.91,10.75", "--radius", "2.5 mi", "--position-field", "location", "query=pizza"))
+	assert.Equal(t, `select * from sources * where geoLocation(location, 59.91, 10.75, "2.5 mi") and userQuery()`, client.LastRequest.URL.Query().Get("yql"))
+}
+
+func TestQueryNearDryRun(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--dry-run", "--near", "-33.87,151.21", "--radius", "500m", "--position-field", "pos", "hits=5"))
+	assert.Equal(t, `hits=5
+timeout=10s
+yql=select * from sources * where geoLocation(pos, -33.87, 151.21, "500 m")
+`, stdout.String())
+	assert.Empty(t, client.Requests)
+
+	queryFile := filepath.Join(t.TempDir(), "query.json")
+	require.Nil(t, os.WriteFile(queryFile, []byte(`{"yql": "select * from sources * limit 10"}`), 0644))
+	stdout.Reset()
+	assert.Nil(t, cli.Run("-t", "http://127.0.0.1:8080", "query", "--dry-run", "--near", "0,0", "--radius", "1km", "--position-field", "pos", "--file", queryFile))
+	assert.Equal(t, `{
+    "timeout": "10s",
+    "yql": "select * from sources * where geoLocation(pos, 0, 0, \"1 km\") limit 10"
+}
+`, stdout.String())
+	assert.Empty(t, client.Requests)
+}
+
+func TestQueryNearInvalid(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--radius", "10km", "select * from sources * where true"}, "option --radius requires --near"},
+		{[]string{"--near", "59.91,10.75", "--radius", "10km"}, "option --near requires --position-field"},
+		{[]string{"--near", "59.91,10.75", "--position-field", "location"}, "option --near requires --radius"},
+		{[]string{"--near", "59.91", "--radius", "10km", "--position-field", "location"}, `invalid --near: "59.91": must be latitude and longitude separated by a comma`},
+		{[]string{"--near", "N59.91,10.75", "--radius", "10km", "--position-field", "location"}, `invalid --near: "N59.91,10.75": latitude must be a number`},
+		{[]string{"--near", "91,10.75", "--radius", "10km", "--position-field", "location"}, `invalid --near: "91,10.75": latitude must be between -90 and 90`},
+		{[]string{"--near", "59.91,-181", "--radius", "10km", "--position-field", "location"}, `invalid --near: "59.91,-181": longitude must be between -180 and 180`},
+		{[]string{"--near", "59.91,10.75", "--radius", "10", "--position-field", "location"}, `invalid --radius: "10": must be a positive number with unit km, m or mi, e.g. 10km`},
+		{[]string{"--near", "59.91,10.75", "--radius", "10ft", "--position-field", "location"}, `invalid --radius: "10ft": must be a positive number with unit km, m or mi, e.g. 10km`},
+		{[]string{"--near", "59.91,10.75", "--radius", "0km", "--position-field", "location"}, `invalid --radius: "0km": must be positive`},
+		{[]string{"--near", "59.91,10.75", "--radius", "10km", "--position-field", "location", "select * where true"}, `invalid yql: "select * where true": no from clause`},
+	}
+	for _, tt := range tests {
+		client := &mock.HTTPClient{}
+		cli, _, stderr := newTestCLI(t)
+		cli.httpClient = client
+		assert.NotNil(t, cli.Run(append([]string{"-t", "http://127.0.0.1:8080", "query"}, tt.args...)...))
+		assert.Equal(t, "Error: "+tt.err, strings.Split(stderr.String(), "\n")[0], strings.Join(tt.args, " "))
+		assert.Empty(t, client.Requests)
+	}
+}
+
+func TestAddWhereClause(t *testing.T) {
+	clause := `geoLocation(location, 1, 2, "3 km")`
+	tests := []struct {
+		yql, expanded string
+	}{
+		{"select * from sources *", `select * from sources * where geoLocation(location, 1, 2, "3 km")`},
+		{"select * from music limit 5", `select * from music where geoLocation(location, 1, 2, "3 km") limit 5`},
+		{"SELECT * FROM music WHERE true", `SELECT * FROM music WHERE geoLocation(location, 1, 2, "3 km") and (true)`},
+		{`select * from music where title contains "order by" | all(group(year) each(output(count())))`,
+			`select * from music where geoLocation(location, 1, 2, "3 km") and (title contains "order by") | all(group(year) each(output(count())))`},
+		{`select * from music where (a > 1 or b < 2) and c contains 'it''s limit'`, `select * from music where geoLocation(location, 1, 2, "3 km") and ((a > 1 or b < 2) and c contains 'it''s limit')`},
+	}
+	for _, tt := range tests {
+		expanded, err := addWhereClause(tt.yql, clause)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.expanded, expanded)
+	}
+	_, err := addWhereClause(`select * from music where title contains "head`, clause)
+	assert.NotNil(t, err)
 }
@@ -2,9 +2,10 @@ package cmd
 
 type OperationResult struct {
 	Success bool
-	Message string // Mandatory message
-	Detail  string // Optional detail message
-	Payload string // Optional payload - may be present whether or not the operation was success
+	Message string   // Mandatory message
+	Detail  string   // Optional detail message
+	Payload string   // Optional payload - may be present whether or not the operation was success
+	Hints   []string // Optional hints, printed after a failure
 }
 
 func Success(message string) OperationResult {
@@ -26,3 +27,12 @@ func FailureWithPayload(message string, payload string) OperationResult {
 func FailureWithDetail(message string, detail string) OperationResult {
 	return OperationResult{Success: false, Message: message, Detail: detail}
 }
+
+// Result is the result of a command executed by CLI.Execute.
+type Result struct {
+	// Command is the path of the executed command below the root command, e.g. "query" or "status deploy"
+	Command string
+	// Value is the structured result of the command, or nil if it has none. It is encoded as JSON like the result the
+	// command prints with JSON output
+	Value any
+}
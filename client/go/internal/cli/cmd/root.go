@@ -2,15 +2,25 @@
 package cmd
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/briandowns/spinner"
@@ -35,13 +45,31 @@ const (
 	targetFlag      = "target"
 	colorFlag       = "color"
 	quietFlag       = "quiet"
+	outputFlag      = "output"
+	profileFlag     = "profile"
 	debugModeFlag   = "debug"
 
+	waitIntervalFlag = "wait-interval"
+	noRetryFlag      = "no-retry"
+	traceFileFlag    = "trace-file"
+	timeoutFlag      = "timeout"
+	noEarlyAbortFlag = "no-early-abort"
+
+	noEndpointCacheFlag  = "no-endpoint-cache"
+	endpointOverrideFlag = "endpoint-override"
+
 	anyTarget = iota
 	localTargetOnly
 	cloudTargetOnly
 )
 
+// traceBodySize is the maximum number of bytes of each request and response body written to a trace file.
+const traceBodySize = 64 * 1024
+
+// dataPlaneCommands are the commands sending requests to the data plane of an application, which support the trace-file
+// and auth flags.
+var dataPlaneCommands = []string{"document", "feed", "query", "visit"}
+
 // CLI holds the Vespa CLI command tree, configuration and dependencies.
 type CLI struct {
 	// Environment holds the process environment.
@@ -53,6 +81,17 @@ type CLI struct {
 	exec       executor
 	isTerminal func() bool
 	spinner    func(w io.Writer, message string, fn func() error) error
+	// activeSpinner is the spinner being displayed, if any. Progress of uploads is shown after it
+	activeSpinner progressLine
+	// steps holds the outcome of the steps of the running command
+	steps []stepResult
+
+	verbose bool // Whether the verbose flag of the running command is set
+
+	executable         func() (string, error) // Returns the path of the running binary
+	pendingUpdateCheck <-chan string          // Receives the result of a background check for new releases
+
+	credentialSources []credentialSource // The credentials used by the current target
 
 	now           func() time.Time
 	retryInterval time.Duration
@@ -61,30 +100,106 @@ type CLI struct {
 	cmd     *cobra.Command
 	config  *Config
 	version version.Version
+	zones   *zonesValue // The zones given to the running command
+
+	// ctx is the context of the running command. It is cancelled when the command is interrupted, or exceeds the
+	// duration given by the timeout flag.
+	ctx              context.Context
+	cancel           context.CancelCauseFunc
+	cancelTimeout    context.CancelFunc
+	commandTimeout   time.Duration
+	interruptHandler atomic.Pointer[func()] // Handles interrupts instead of cancelling ctx, if non-nil
+
+	noEndpointCache bool
+	showSecrets     bool
+	// secretHeaders are the names of the headers given by the header flag or the data-plane-headers option to the
+	// running command, whose values are redacted from verbose and trace output
+	secretHeaders []string
+	// endpointOverrides holds the addresses connected to instead of others, as given by the endpoint-overrides option
+	// and flag
+	endpointOverrides httputil.EndpointOverrides
+	// endpointHealth tracks the health of the endpoints connected to by the running command, if it is a data plane
+	// command
+	endpointHealth *httputil.EndpointHealth
 
 	httpClient        httputil.Client
 	httpClientFactory func(timeout time.Duration) httputil.Client
+	tracer            *httputil.Tracer // Traces HTTP requests of the running command, if non-nil
+	traceFile         io.Closer
 	auth0Factory      auth0Factory
 	ztsFactory        ztsFactory
+	localDetector     localDetector
+
+	// recordResults is set when the CLI is run by Execute, and the results of commands are recorded in result
+	recordResults bool
+	result        Result
 }
 
-// ErrCLI is an error returned to the user. It wraps an exit status, a regular error and optional hints for resolving
-// the error.
+// Options holds the dependencies of a CLI created by NewCLI.
+type Options struct {
+	// Stdin is read by commands reading standard input. It defaults to os.Stdin
+	Stdin io.ReadWriter
+	// Stdout and Stderr receive the output of commands. Output is discarded if they are nil
+	Stdout io.Writer
+	Stderr io.Writer
+	// Environment holds the environment variables read by the CLI, by name
+	Environment map[string]string
+	// HTTPClientFactory creates the HTTP clients used by commands, with the given timeout. It defaults to creating clients
+	// supporting the proxy, TLS and tracing configuration of the CLI. These are not applied to clients created by other
+	// factories
+	HTTPClientFactory func(timeout time.Duration) httputil.Client
+}
+
+// ErrCLI is an error returned to the user. It wraps an exit status, a regular error, an optional error code and optional
+// hints for resolving the error.
 type ErrCLI struct {
 	Status int
 	warn   bool
 	quiet  bool
+	code   errorCode
 	hints  []string
 	error
 }
 
+// Code returns the error code of this error, or the empty string if it has none.
+func (e ErrCLI) Code() string { return string(e.code) }
+
+// Hints returns the hints for resolving this error.
+func (e ErrCLI) Hints() []string { return e.hints }
+
+// Unwrap returns the error wrapped by this.
+func (e ErrCLI) Unwrap() error { return e.error }
+
+var (
+	// errInterrupted is the cause of cancelling the context of a command which is interrupted
+	errInterrupted = errors.New("interrupted")
+	// errTimeout is the cause of cancelling the context of a command exceeding the duration of the timeout flag
+	errTimeout = errors.New("command timed out")
+)
+
+// credentialSource describes where a credential used by a target was read from.
+type credentialSource struct {
+	description string
+	dataPlane   bool
+}
+
 type targetOptions struct {
 	// logLevel sets the log level to use for this target. If empty, it defaults to "info".
 	logLevel string
+	// zone overrides the configured zone of a cloud target, if non-empty.
+	zone string
+	// target overrides the configured target, i.e. 'local', 'cloud', 'hosted' or an URL, if non-empty.
+	target string
+	// application overrides the configured application of a cloud target, if non-empty. The zone is then the default
+	// zone of the system, unless zone is set.
+	application string
 	// noCertificate declares that no client certificate should be required when using this target.
 	noCertificate bool
 	// supportedType specifies what type of target to allow.
 	supportedType int
+	// detectLocal declares that the config server of a local target should be checked, and be detected among local
+	// containers if it is not reachable.
+	detectLocal bool
 }
 
 type targetType struct {
@@ -92,8 +207,37 @@ type targetType struct {
 	url  string
 }
 
+// zonesValue is the value of the zone flag, which may be repeated for commands supporting several zones. The value of
+// the flag is the last zone given.
+type zonesValue struct {
+	zones []string
+	// given is the number of zones given to the running command. Zones given to a previous command are replaced by the
+	// first zone given to this
+	given int
+}
+
+func (v *zonesValue) String() string {
+	if len(v.zones) == 0 {
+		return ""
+	}
+	return v.zones[len(v.zones)-1]
+}
+
+func (v *zonesValue) Set(s string) error {
+	if v.given == 0 {
+		v.zones = nil
+	}
+	v.given++
+	v.zones = append(v.zones, s)
+	return nil
+}
+
+func (v *zonesValue) Type() string { return "string" }
+
 // errHint creates a new CLI error, with optional hints that will be printed after the error
-func errHint(err error, hints ...string) ErrCLI { return ErrCLI{Status: 1, hints: hints, error: err} }
+func errHint(err error, hints ...string) ErrCLI {
+	return ErrCLI{Status: exitError, hints: hints, error: err}
+}
 
 type executor interface {
 	LookPath(name string) (string, error)
@@ -112,8 +256,8 @@ type auth0Factory func(httpClient httputil.Client, options auth0.Options) (vespa
 type ztsFactory func(httpClient httputil.Client, domain, url string) (vespa.Authenticator, error)
 
 // newSpinner writes message to writer w and executes function fn. While fn is running a spinning animation will be
-// displayed after message.
-func newSpinner(w io.Writer, message string, fn func() error) error {
+// displayed after message, followed by the progress of any upload done by fn.
+func (c *CLI) newSpinner(w io.Writer, message string, fn func() error) error {
 	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(w))
 	// Cursor is hidden by default. Hiding cursor requires Stop() to be called to restore cursor (i.e. if the process is
 	// interrupted), however we don't want to bother with a signal handler just for this
@@ -127,7 +271,9 @@ func newSpinner(w io.Writer, message string, fn func() error) error {
 	s.Prefix = message
 	s.FinalMSG = "\r" + message + "done\n"
 	s.Start()
+	c.activeSpinner = spinnerLine{s}
 	err := fn()
+	c.activeSpinner = nil
 	if err != nil {
 		s.FinalMSG = "\r" + message + "failed\n"
 	}
@@ -137,6 +283,30 @@ func newSpinner(w io.Writer, message string, fn func() error) error {
 
 // New creates the Vespa CLI, writing output to stdout and stderr, and reading environment variables from environment.
 func New(stdout, stderr io.Writer, environment []string) (*CLI, error) {
+	env := make(map[string]string)
+	for _, entry := range environment {
+		parts := strings.SplitN(entry, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	return NewCLI(Options{Stdout: stdout, Stderr: stderr, Environment: env})
+}
+
+// NewCLI creates the Vespa CLI with the given options, for running it as a library.
+func NewCLI(opts Options) (*CLI, error) {
+	stdout, stderr, env := opts.Stdout, opts.Stderr, opts.Environment
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if env == nil {
+		env = make(map[string]string)
+	}
+	var stdin io.ReadWriter = os.Stdin
+	if opts.Stdin != nil {
+		stdin = opts.Stdin
+	}
 	cmd := &cobra.Command{
 		Use:   "vespa",
 		Short: "The command-line tool for Vespa.ai",
@@ -147,6 +317,11 @@ use a token for Vespa Cloud access:
 
 $ export VESPA_CLI_DATA_PLANE_TOKEN='value-of-token'
 
+or store the token, and select token authentication for all commands:
+
+$ vespa auth token set mytoken < token.txt
+$ vespa config set data-plane-auth token
+
 To get started, see the following quick start guides:
 
 - Local Vespa instance: https://docs.vespa.ai/en/vespa-quick-start.html
@@ -213,44 +388,54 @@ For detailed description of flags and configuration, see 'vespa help config'.
 		},
 	}
 	cmd.CompletionOptions.HiddenDefaultCmd = true // Do not show the 'completion' command in help output
-	env := make(map[string]string)
-	for _, entry := range environment {
-		parts := strings.SplitN(entry, "=", 2)
-		env[parts[0]] = parts[1]
-	}
+	cmd.SetOut(stdout)
 	version, err := version.Parse(build.Version)
 	if err != nil {
 		return nil, err
 	}
-	httpClientFactory := httputil.NewClient
 	cli := CLI{
 		Environment: env,
-		Stdin:       os.Stdin,
+		Stdin:       stdin,
 		Stdout:      stdout,
 		Stderr:      stderr,
 
 		exec:          &execSubprocess{},
+		executable:    executablePath,
 		now:           time.Now,
 		retryInterval: 2 * time.Second,
 
 		version: version,
 		cmd:     cmd,
+		ctx:     context.Background(),
 
-		httpClient:        httpClientFactory(time.Second * 10),
-		httpClientFactory: httpClientFactory,
 		auth0Factory: func(httpClient httputil.Client, options auth0.Options) (vespa.Authenticator, error) {
 			return auth0.NewClient(httpClient, options)
 		},
+		localDetector: &socketDetector{environment: env},
 		ztsFactory: func(httpClient httputil.Client, domain, url string) (vespa.Authenticator, error) {
 			return zts.NewClient(httpClient, domain, url)
 		},
 	}
+	cli.httpClientFactory = func(timeout time.Duration) httputil.Client {
+		client := httputil.NewClient(timeout)
+		httputil.ConfigureProxy(client, cli.proxyFunc())
+		httputil.ConfigureEndpointOverrides(client, cli.endpointOverrides)
+		httputil.ConfigureEndpointHealth(client, cli.endpointHealth)
+		httputil.ConfigureTrace(client, cli.tracer)
+		httputil.ConfigureContext(client, cli.ctx)
+		return client
+	}
+	if opts.HTTPClientFactory != nil {
+		cli.httpClientFactory = opts.HTTPClientFactory
+	}
+	cli.httpClient = cli.httpClientFactory(time.Second * 10)
 	cli.isTerminal = func() bool { return isTerminal(cli.Stdout) && isTerminal(cli.Stderr) }
 	if err := cli.loadConfig(); err != nil {
 		return nil, err
 	}
 	cli.configureSpinner()
 	cli.configureCommands()
+	cli.configureCompletions()
 	cmd.PersistentPreRunE = cli.configureOutput
 	cmd.FParseErrWhitelist.UnknownFlags = true // Ignore unknown flags, so that we can pass them to external commands
 	return &cli, nil
@@ -272,17 +457,27 @@ func (c *CLI) configureOutput(cmd *cobra.Command, args []string) error {
 	if f, ok := c.Stderr.(*os.File); ok {
 		c.Stderr = colorable.NewColorable(f)
 	}
-	if c.config.isQuiet() {
-		c.Stdout = io.Discard
+	if _, err := c.config.profileValues(); err != nil && !isProfileCommand(cmd) {
+		return err
+	}
+	output, _ := c.config.get(outputFlag)
+	if output != "human" && output != "json" {
+		return fmt.Errorf("invalid output option: %s", output)
 	}
+	c.steps = nil
 	log.SetFlags(0) // No timestamps
-	log.SetOutput(c.Stdout)
+	log.SetOutput(c.textOutput())
+	if c.config.isQuiet() {
+		log.SetOutput(io.Discard)
+	}
+	verbose := cmd.Flags().Lookup("verbose")
+	c.verbose = verbose != nil && verbose.Value.String() == "true"
 	colorValue, _ := c.config.get(colorFlag)
 	colorize := false
 	switch colorValue {
 	case "auto":
-		_, nocolor := c.Environment["NO_COLOR"] // https://no-color.org
-		colorize = !nocolor && c.isTerminal()
+		nocolor := c.Environment["NO_COLOR"] != "" // https://no-color.org
+		colorize = !nocolor && c.isTerminal() && !c.jsonOutput()
 	case "always":
 		colorize = true
 	case "never":
@@ -290,6 +485,32 @@ func (c *CLI) configureOutput(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("invalid color option: %s", colorValue)
 	}
 	color.NoColor = !colorize
+	c.configureRetries(cmd)
+	c.configureTimeout(cmd)
+	if err := c.configureTrace(cmd); err != nil {
+		return err
+	}
+	if err := c.configureEndpointOverrides(cmd); err != nil {
+		return err
+	}
+	c.configureEndpointHealth(cmd)
+	if err := c.checkAuthFlag(cmd); err != nil {
+		return err
+	}
+	if c.zones.given > 1 && cmd.CommandPath() != "vespa log" {
+		return errHint(fmt.Errorf("--%s may only be given once for %s", zoneFlag, cmd.CommandPath()), "Only vespa log supports several zones")
+	}
+	c.startUpdateCheck(cmd)
+	if f := cmd.Flags().Lookup(waitIntervalFlag); f != nil && f.Changed {
+		secs, err := cmd.Flags().GetInt(waitIntervalFlag)
+		if err != nil {
+			return err
+		}
+		if secs <= 0 {
+			return fmt.Errorf("invalid %s: %d: must be positive", waitIntervalFlag, secs)
+		}
+		c.retryInterval = time.Duration(secs) * time.Second
+	}
 	return nil
 }
 
@@ -299,18 +520,22 @@ func (c *CLI) configureFlags() map[string]*pflag.Flag {
 		application string
 		instance    string
 		cluster     string
-		zone        string
 		color       string
 		quiet       bool
+		output      string
+		profile     string
 		debugMode   bool
 	)
 	c.cmd.PersistentFlags().StringVarP(&target, targetFlag, "t", "local", `The target platform to use. Must be "local", "cloud", "hosted" or an URL`)
 	c.cmd.PersistentFlags().StringVarP(&application, applicationFlag, "a", "", `The application to use (cloud only). Format "tenant.application.instance" - instance is optional`)
 	c.cmd.PersistentFlags().StringVarP(&instance, instanceFlag, "i", "", "The instance of the application to use (cloud only)")
 	c.cmd.PersistentFlags().StringVarP(&cluster, clusterFlag, "C", "", "The container cluster to use. This is only required for applications with multiple clusters")
-	c.cmd.PersistentFlags().StringVarP(&zone, zoneFlag, "z", "", "The zone to use. This defaults to a dev zone (cloud only)")
-	c.cmd.PersistentFlags().StringVarP(&color, colorFlag, "c", "auto", `Whether to use colors in output. Must be "auto", "never", or "always"`)
-	c.cmd.PersistentFlags().BoolVarP(&quiet, quietFlag, "q", false, "Print only errors")
+	c.zones = &zonesValue{}
+	c.cmd.PersistentFlags().VarP(c.zones, zoneFlag, "z", "The zone to use. This defaults to a dev zone (cloud only)")
+	c.cmd.PersistentFlags().StringVarP(&color, colorFlag, "c", "auto", `Whether to use colors in output. Must be "auto", "never", or "always". With "auto", colors are used when writing to a terminal, unless NO_COLOR is set`)
+	c.cmd.PersistentFlags().BoolVarP(&quiet, quietFlag, "q", false, "Print only errors and command results. Commands requiring confirmation fail instead of prompting")
+	c.cmd.PersistentFlags().StringVar(&profile, profileFlag, defaultProfile, "The configuration profile to use, instead of the active profile")
+	c.cmd.PersistentFlags().StringVarP(&output, outputFlag, "o", "human", `The output format of command results. Must be "human" or "json"`)
 	c.cmd.PersistentFlags().BoolVar(&debugMode, debugModeFlag, false, `Print debugging output`)
 	c.cmd.PersistentFlags().MarkHidden(debugModeFlag)
 
@@ -318,6 +543,15 @@ func (c *CLI) configureFlags() map[string]*pflag.Flag {
 	c.cmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
 		flags[flag.Name] = flag
 	})
+	// Not a config option. Commands may define their own verbose flag, which then takes precedence
+	c.cmd.PersistentFlags().Bool("verbose", false, "Print more details, such as which config server is used when the target has several")
+	c.cmd.PersistentFlags().Bool(noRetryFlag, false, "Do not retry requests failing with a transient error. See 'vespa help config' for the http-retries option")
+	c.cmd.PersistentFlags().String(authFlag, "", `The authentication method to use for requests to the data plane. Must be "cert" or "token". See 'vespa help config' for the data-plane-auth option. Supported by the document, feed, query, visit, curl and status auth commands`)
+	c.cmd.PersistentFlags().DurationVar(&c.commandTimeout, timeoutFlag, 0, "Stop the command if it has not completed within this duration, e.g. 30s or 5m. 0 to disable. Commands with a --timeout option of their own use that instead")
+	c.cmd.PersistentFlags().BoolVar(&c.noEndpointCache, noEndpointCacheFlag, false, "Discover the endpoints of an application in Vespa Cloud, instead of using those cached by a previous command. See 'vespa help config' for the endpoint-cache-ttl option")
+	c.cmd.PersistentFlags().String(traceFileFlag, "", "Append each HTTP request and response to this file, as JSON lines, with credentials redacted. Supported by the document, feed, query and visit commands")
+	c.cmd.PersistentFlags().BoolVar(&c.showSecrets, showSecretsFlag, false, "Show the values of headers given by --header, or the data-plane-headers option, in the output of --verbose and --trace-file")
+	c.cmd.PersistentFlags().StringArray(endpointOverrideFlag, nil, "Connect to another address instead of the given one, e.g. through an SSH tunnel, on the format 'host:port=host:port'. This can be specified multiple times, and takes precedence over the endpoint-overrides option. See 'vespa help config'")
 	return flags
 }
 
@@ -330,7 +564,7 @@ func (c *CLI) configureSpinner() {
 			return fn()
 		}
 	} else {
-		c.spinner = newSpinner
+		c.spinner = c.newSpinner
 	}
 }
 
@@ -338,43 +572,73 @@ func (c *CLI) configureCommands() {
 	rootCmd := c.cmd
 	authCmd := newAuthCmd()
 	certCmd := newCertCmd(c)
+	tokenCmd := newAuthTokenCmd()
 	configCmd := newConfigCmd()
+	profileCmd := newConfigProfileCmd()
 	documentCmd := newDocumentCmd(c)
 	prodCmd := newProdCmd()
 	statusCmd := newStatusCmd(c)
 	applicationCmd := newApplicationCmd()
+	schemaCmd := newSchemaCmd()
 
 	certCmd.AddCommand(newCertAddCmd(c))                // auth cert add
+	certCmd.AddCommand(newCertInfoCmd(c))               // auth cert info
+	certCmd.AddCommand(newCertListCmd(c))               // auth cert list
+	certCmd.AddCommand(newCertRemoveCmd(c))             // auth cert remove
 	authCmd.AddCommand(certCmd)                         // auth cert
 	authCmd.AddCommand(newAPIKeyCmd(c))                 // auth api-key
 	authCmd.AddCommand(newLoginCmd(c))                  // auth login
+	authCmd.AddCommand(newAuthListCmd(c))               // auth list
+	authCmd.AddCommand(newAuthListCredentialsCmd(c))    // auth list-credentials
+	authCmd.AddCommand(newAuthPruneCmd(c))              // auth prune
 	authCmd.AddCommand(newAuthShowCmd(c))               // auth show
 	authCmd.AddCommand(newLogoutCmd(c))                 // auth logout
+	tokenCmd.AddCommand(newAuthTokenSetCmd(c))          // auth token set
+	authCmd.AddCommand(tokenCmd)                        // auth token
 	rootCmd.AddCommand(authCmd)                         // auth
 	rootCmd.AddCommand(newCloneCmd(c))                  // clone
 	configCmd.AddCommand(newConfigGetCmd(c))            // config get
 	configCmd.AddCommand(newConfigSetCmd(c))            // config set
 	configCmd.AddCommand(newConfigUnsetCmd(c))          // config unset
+	configCmd.AddCommand(newConfigExportCmd(c))         // config export
+	configCmd.AddCommand(newConfigImportCmd(c))         // config import
+	profileCmd.AddCommand(newConfigProfileCreateCmd(c)) // config profile create
+	profileCmd.AddCommand(newConfigProfileUseCmd(c))    // config profile use
+	profileCmd.AddCommand(newConfigProfileListCmd(c))   // config profile list
+	configCmd.AddCommand(profileCmd)                    // config profile
 	rootCmd.AddCommand(configCmd)                       // config
 	rootCmd.AddCommand(newCurlCmd(c))                   // curl
 	rootCmd.AddCommand(newDeployCmd(c))                 // deploy
 	rootCmd.AddCommand(newDestroyCmd(c))                // destroy
+	rootCmd.AddCommand(newDiffCmd(c))                   // diff
+	rootCmd.AddCommand(newDoctorCmd(c))                 // doctor
+	rootCmd.AddCommand(newExplainCmd(c))                // explain
+	rootCmd.AddCommand(newExitCodesCmd())               // exit-codes
 	rootCmd.AddCommand(newPrepareCmd(c))                // prepare
 	rootCmd.AddCommand(newActivateCmd(c))               // activate
 	documentCmd.AddCommand(newDocumentPutCmd(c))        // document put
 	documentCmd.AddCommand(newDocumentUpdateCmd(c))     // document update
 	documentCmd.AddCommand(newDocumentRemoveCmd(c))     // document remove
 	documentCmd.AddCommand(newDocumentGetCmd(c))        // document get
+	documentCmd.AddCommand(newDocumentBatchCmd(c))      // document batch
+	documentCmd.AddCommand(newDocumentEditCmd(c))       // document edit
 	rootCmd.AddCommand(documentCmd)                     // document
 	rootCmd.AddCommand(newLogCmd(c))                    // log
 	rootCmd.AddCommand(newManCmd(c))                    // man
 	rootCmd.AddCommand(newGendocCmd(c))                 // gendoc
 	prodCmd.AddCommand(newProdInitCmd(c))               // prod init
 	prodCmd.AddCommand(newProdDeployCmd(c))             // prod deploy
+	prodCmd.AddCommand(newProdValidateCmd(c))           // prod validate
+	prodCmd.AddCommand(newProdStatusCmd(c))             // prod status
 	rootCmd.AddCommand(prodCmd)                         // prod
+	schemaCmd.AddCommand(newSchemaValidateCmd(c))       // schema validate
+	rootCmd.AddCommand(schemaCmd)                       // schema
 	rootCmd.AddCommand(newQueryCmd(c))                  // query
+	statusCmd.AddCommand(newStatusAuthCmd(c))           // status auth
 	statusCmd.AddCommand(newStatusDeployCmd(c))         // status deploy
 	statusCmd.AddCommand(newStatusDeploymentCmd(c))     // status deployment
+	statusCmd.AddCommand(newStatusRedistributionCmd(c)) // status redistribution
+	statusCmd.AddCommand(newStatusVersionsCmd(c))       // status versions
 	rootCmd.AddCommand(statusCmd)                       // status
 	rootCmd.AddCommand(newTestCmd(c))                   // test
 	rootCmd.AddCommand(newVersionCmd(c))                // version
@@ -388,13 +652,40 @@ func (c *CLI) configureCommands() {
 }
 
 func (c *CLI) bindWaitFlag(cmd *cobra.Command, defaultSecs int, value *int) {
-	desc := "Number of seconds to wait for service(s) to become ready. 0 to disable"
+	desc := "Time to wait for service(s) to become ready, in seconds or as a duration such as 5m. 0 to disable"
 	if defaultSecs == 0 {
 		desc += " (default 0)"
 	}
-	cmd.PersistentFlags().IntVarP(value, "wait", "w", defaultSecs, desc)
+	*value = defaultSecs
+	cmd.PersistentFlags().VarP(&waitValue{secs: value}, "wait", "w", desc)
+	cmd.PersistentFlags().Int(waitIntervalFlag, 2, "Number of seconds between each poll while waiting")
+}
+
+// bindNoEarlyAbortFlag binds a flag which makes waiting for a deployment continue when a service keeps failing.
+func bindNoEarlyAbortFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool(noEarlyAbortFlag, false, "Wait for the full timeout, even if a service stays down or crash-loops while the deployment converges")
+}
+
+// waitValue is the value of a wait flag. It accepts a number of seconds, or a duration such as 5m or 1h30m.
+type waitValue struct{ secs *int }
+
+func (v *waitValue) String() string { return strconv.Itoa(*v.secs) }
+
+func (v *waitValue) Set(s string) error {
+	if secs, err := strconv.Atoi(s); err == nil {
+		*v.secs = secs
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %q: must be a number of seconds or a duration such as 5m", s)
+	}
+	*v.secs = int(math.Ceil(d.Seconds()))
+	return nil
 }
 
+func (v *waitValue) Type() string { return "duration" }
+
 func (c *CLI) printErr(err error, hints ...string) {
 	fmt.Fprintln(c.Stderr, color.RedString("Error:"), err)
 	for _, hint := range hints {
@@ -403,10 +694,58 @@ func (c *CLI) printErr(err error, hints ...string) {
 }
 
 func (c *CLI) printSuccess(msg ...interface{}) {
-	fmt.Fprintln(c.Stdout, color.GreenString("Success:"), fmt.Sprint(msg...))
+	if c.config.isQuiet() {
+		return
+	}
+	fmt.Fprintln(c.textOutput(), color.GreenString("Success:"), fmt.Sprint(msg...))
+}
+
+// recordResult records v as the result of the running command, if the CLI is run by Execute. The result must be
+// encodable as JSON.
+func (c *CLI) recordResult(v any) {
+	if c.recordResults {
+		c.result.Value = v
+	}
+}
+
+// jsonOutput returns whether command results should be printed as JSON.
+func (c *CLI) jsonOutput() bool {
+	output, _ := c.config.get(outputFlag)
+	return output == "json"
+}
+
+// textOutput returns the writer for human-readable text. This is standard error when results are printed as JSON, so
+// that standard output contains only the result.
+func (c *CLI) textOutput() io.Writer {
+	if c.jsonOutput() {
+		return c.Stderr
+	}
+	return c.Stdout
+}
+
+// printResult prints the result v of a command as JSON, if JSON output is selected. Otherwise, printing the result is
+// left to the command.
+func (c *CLI) printResult(v any) error {
+	c.recordResult(v)
+	if !c.jsonOutput() {
+		return nil
+	}
+	return writeJSON(c, v)
+}
+
+// outputFormat returns the value of the format flag of cmd, which is json if the flag is not set explicitly and JSON
+// output is selected.
+func (c *CLI) outputFormat(cmd *cobra.Command, format string) string {
+	if c.jsonOutput() && !cmd.Flags().Changed("format") {
+		return "json"
+	}
+	return format
 }
 
 func (c *CLI) printInfo(msg ...interface{}) {
+	if c.config.isQuiet() {
+		return
+	}
 	fmt.Fprintln(c.Stderr, fmt.Sprint(msg...))
 }
 
@@ -417,6 +756,9 @@ func (c *CLI) printDebug(msg ...interface{}) {
 }
 
 func (c *CLI) printWarning(msg interface{}, hints ...string) {
+	if c.config.isQuiet() && !c.verbose {
+		return
+	}
 	fmt.Fprintln(c.Stderr, color.YellowString("Warning:"), msg)
 	for _, hint := range hints {
 		fmt.Fprintln(c.Stderr, color.CyanString("Hint:"), hint)
@@ -424,8 +766,8 @@ func (c *CLI) printWarning(msg interface{}, hints ...string) {
 }
 
 func (c *CLI) confirm(question string, confirmByDefault bool) (bool, error) {
-	if !c.isTerminal() {
-		return false, fmt.Errorf("terminal is not interactive")
+	if err := c.checkInteractive(); err != nil {
+		return false, err
 	}
 	for {
 		var answer string
@@ -433,7 +775,7 @@ func (c *CLI) confirm(question string, confirmByDefault bool) (bool, error) {
 		if !confirmByDefault {
 			choice = "[y/N]"
 		}
-		fmt.Fprintf(c.Stdout, "%s %s ", question, choice)
+		fmt.Fprintf(c.textOutput(), "%s %s ", question, choice)
 		fmt.Fscanln(c.Stdin, &answer)
 		answer = strings.TrimSpace(answer)
 		if answer == "" {
@@ -450,13 +792,262 @@ func (c *CLI) confirm(question string, confirmByDefault bool) (bool, error) {
 	}
 }
 
+// confirmExact prompts the user to type expected, and returns whether the typed line matches it exactly.
+func (c *CLI) confirmExact(expected string) (bool, error) {
+	if err := c.checkInteractive(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(c.textOutput(), "Type %s to confirm: ", color.CyanString(expected))
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := c.Stdin.Read(b)
+		if n == 0 || err != nil || b[0] == '\n' {
+			break
+		}
+		sb.WriteByte(b[0])
+	}
+	answer := strings.TrimSpace(sb.String())
+	if answer != expected {
+		c.printErr(fmt.Errorf("confirmation does not match: expected %q, got %q", expected, answer))
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkInteractive returns an error if the user cannot be prompted. Quiet mode is treated as non-interactive.
+func (c *CLI) checkInteractive() error {
+	if !c.isTerminal() {
+		return fmt.Errorf("terminal is not interactive")
+	}
+	if c.config.isQuiet() {
+		return fmt.Errorf("prompts are disabled in quiet mode")
+	}
+	return nil
+}
+
+// configureRetries configures the HTTP client of this to retry requests according to the http-retries option, unless
+// the no-retry flag of command cmd is set.
+func (c *CLI) configureRetries(cmd *cobra.Command) {
+	retries := c.config.httpRetries()
+	if noRetry, err := cmd.Flags().GetBool(noRetryFlag); err == nil && noRetry {
+		retries = 0
+	}
+	policy := httputil.DefaultRetryPolicy(retries)
+	policy.OnRetry = func(request *http.Request, reason string, delay time.Duration, retriesLeft int) {
+		if c.verbose {
+			c.printInfo("Retrying ", request.Method, " ", color.CyanString(request.URL.String()), " in ", delay.Round(time.Millisecond), ": ", reason,
+				fmt.Sprintf(" (%d left)", retriesLeft))
+		}
+	}
+	httputil.ConfigureRetry(c.httpClient, policy)
+}
+
+// startContext creates the context of the running command, which is cancelled when the CLI is interrupted. The
+// returned function releases the context and stops handling of interrupts.
+func (c *CLI) startContext() func() {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	c.ctx, c.cancel, c.cancelTimeout = ctx, cancel, nil
+	c.interruptHandler.Store(nil)
+	httputil.ConfigureContext(c.httpClient, ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			// Stop handling interrupts, such that a second interrupt stops the CLI immediately
+			signal.Stop(signals)
+			c.interrupt()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		if c.cancelTimeout != nil {
+			c.cancelTimeout()
+		}
+		cancel(nil)
+	}
+}
+
+// interrupt interrupts the running command, by calling the handler set with onInterrupt, or by cancelling its context.
+func (c *CLI) interrupt() {
+	if handler := c.interruptHandler.Load(); handler != nil {
+		(*handler)()
+		return
+	}
+	c.cancel(errInterrupted)
+}
+
+// onInterrupt makes the running command handle an interrupt with handler, instead of cancelling its context. The
+// returned function removes the handler.
+func (c *CLI) onInterrupt(handler func()) func() {
+	c.interruptHandler.Store(&handler)
+	return func() { c.interruptHandler.Store(nil) }
+}
+
+// configureTimeout bounds the runtime of command cmd by the timeout flag, if set, and not overridden by a timeout flag
+// of cmd itself.
+func (c *CLI) configureTimeout(cmd *cobra.Command) {
+	if c.commandTimeout <= 0 || cmd.Flags().Lookup(timeoutFlag) != c.cmd.PersistentFlags().Lookup(timeoutFlag) {
+		return
+	}
+	c.ctx, c.cancelTimeout = context.WithTimeoutCause(c.ctx, c.commandTimeout, errTimeout)
+	httputil.ConfigureContext(c.httpClient, c.ctx)
+}
+
+// sleep waits for d to elapse, or returns an error if the running command is interrupted, or times out, before that.
+func (c *CLI) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// withContextError returns err replaced by an error explaining why the running command was stopped, if it was
+// interrupted or timed out.
+func (c *CLI) withContextError(err error) error {
+	cause := context.Cause(c.ctx)
+	if errors.Is(cause, errInterrupted) {
+		return ErrCLI{Status: exitInterrupted, error: errInterrupted}
+	}
+	if errors.Is(cause, errTimeout) {
+		return errHint(fmt.Errorf("%w after %s", errTimeout, c.commandTimeout), "Allow the command to run longer with --timeout")
+	}
+	return err
+}
+
+// configureTrace configures the HTTP clients of this to write each request and response to the file given by the
+// trace-file flag of command cmd, if set.
+func (c *CLI) configureTrace(cmd *cobra.Command) error {
+	path, err := cmd.Flags().GetString(traceFileFlag)
+	if err != nil || path == "" {
+		return nil
+	}
+	if !isDataPlaneCommand(cmd) {
+		return errHint(fmt.Errorf("--%s is not supported by %s", traceFileFlag, cmd.CommandPath()), "Supported commands are "+strings.Join(dataPlaneCommands, ", "))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open trace file: %w", err)
+	}
+	c.traceFile = f
+	c.tracer = httputil.NewTracer(f, traceBodySize)
+	httputil.ConfigureTrace(c.httpClient, c.tracer)
+	return nil
+}
+
+// configureEndpointOverrides configures the addresses to connect to instead of others, from the endpoint-overrides
+// option, and the flag of given command. In verbose mode, the first connection to each overridden address is printed.
+func (c *CLI) configureEndpointOverrides(cmd *cobra.Command) error {
+	addrs, insecure := c.config.endpointOverrides()
+	flagValues, _ := cmd.Flags().GetStringArray(endpointOverrideFlag)
+	for _, value := range flagValues {
+		addr, override, ok := strings.Cut(value, "=")
+		if !ok || checkEndpointOverride(addr, override) != nil {
+			return errHint(fmt.Errorf("invalid endpoint override: %q", value), "Must be on the format 'host:port=host:port', such as 'internal-host:8080=localhost:18080'")
+		}
+		if addrs == nil {
+			addrs = make(map[string]string)
+		}
+		addrs[addr] = override
+	}
+	var printed sync.Map
+	c.endpointOverrides = httputil.EndpointOverrides{
+		Addrs:    addrs,
+		Insecure: insecure,
+		OnOverride: func(addr, override string) {
+			if _, loaded := printed.LoadOrStore(addr, true); !loaded && c.verbose {
+				c.printInfo("Connecting to ", color.CyanString(override), " instead of ", color.CyanString(addr))
+			}
+		},
+	}
+	httputil.ConfigureEndpointOverrides(c.httpClient, c.endpointOverrides)
+	return nil
+}
+
+// configureEndpointHealth makes data plane commands avoid the endpoints which failed recently, and fail fast when all
+// endpoints of an address have. In verbose mode, endpoints marked unhealthy, and the choice of endpoint when an address
+// has several, are printed.
+func (c *CLI) configureEndpointHealth(cmd *cobra.Command) {
+	c.endpointHealth = nil
+	if isDataPlaneCommand(cmd) {
+		c.endpointHealth = httputil.NewEndpointHealth()
+		c.endpointHealth.OnUnhealthy = func(endpoint string, err error) {
+			if c.verbose {
+				c.printInfo("Marking endpoint ", color.CyanString(endpoint), " unhealthy: ", err)
+			}
+		}
+		c.endpointHealth.OnSelect = func(addr, endpoint string, skipped []string) {
+			if !c.verbose {
+				return
+			}
+			msg := []any{"Connecting to ", color.CyanString(endpoint), " of ", addr}
+			if len(skipped) > 0 {
+				msg = append(msg, ", skipping ", strings.Join(skipped, ", "))
+			}
+			c.printInfo(msg...)
+		}
+	}
+	httputil.ConfigureEndpointHealth(c.httpClient, c.endpointHealth)
+}
+
+// isDataPlaneCommand returns whether cmd is one of the data plane commands, or a subcommand of one.
+func isDataPlaneCommand(cmd *cobra.Command) bool {
+	name := cmd.Name()
+	for p := cmd; p.HasParent() && p.Parent().HasParent(); p = p.Parent() {
+		name = p.Parent().Name()
+	}
+	return slices.Contains(dataPlaneCommands, name)
+}
+
+// finishTrace stops tracing of requests, and closes the trace file, if any.
+func (c *CLI) finishTrace() {
+	if c.traceFile == nil {
+		return
+	}
+	httputil.ConfigureTrace(c.httpClient, nil)
+	c.traceFile.Close()
+	c.tracer = nil
+	c.traceFile = nil
+}
+
+// disableRetries disables retrying of requests, for commands handling retries themselves.
+func (c *CLI) disableRetries() { httputil.ConfigureRetry(c.httpClient, httputil.RetryPolicy{}) }
+
+// proxyFunc returns a function choosing the proxy of a request from the environment of this CLI. In verbose mode, the
+// first use of each proxy for each host is printed.
+func (c *CLI) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := httputil.ProxyFunc(c.Environment)
+	var printed sync.Map
+	return func(request *http.Request) (*url.URL, error) {
+		proxyURL, err := proxy(request)
+		if proxyURL != nil && c.verbose {
+			if _, loaded := printed.LoadOrStore(proxyURL.String()+" "+request.URL.Host, true); !loaded {
+				c.printInfo("Using proxy ", color.CyanString(proxyURL.Redacted()), " for ", request.URL.Host)
+			}
+		}
+		return proxyURL, err
+	}
+}
+
 func (c *CLI) waiter(timeout time.Duration, cmd *cobra.Command) *Waiter {
-	return &Waiter{Timeout: timeout, cli: c, cmd: cmd}
+	w := &Waiter{Timeout: timeout, cli: c, cmd: cmd}
+	if f := cmd.Flags().Lookup(noEarlyAbortFlag); f != nil {
+		w.NoEarlyAbort, _ = cmd.Flags().GetBool(noEarlyAbortFlag)
+	}
+	return w
 }
 
 // target creates a target according the configuration of this CLI and given opts.
 func (c *CLI) target(opts targetOptions) (vespa.Target, error) {
-	targetType, err := c.targetType(opts.supportedType)
+	targetType, err := c.targetTypeOf(opts.target, opts.supportedType)
 	if err != nil {
 		return nil, err
 	}
@@ -472,6 +1063,11 @@ func (c *CLI) target(opts targetOptions) (vespa.Target, error) {
 	if err != nil {
 		return nil, err
 	}
+	if opts.detectLocal && targetType.name == vespa.TargetLocal {
+		if err := detectLocalTarget(c, target); err != nil {
+			return nil, err
+		}
+	}
 	if target.IsCloud() && !c.isCloudCI() { // Vespa Cloud always runs an up-to-date version
 		if err := target.CompatibleWith(c.version); err != nil {
 			var authError vespa.AuthError
@@ -486,17 +1082,34 @@ func (c *CLI) target(opts targetOptions) (vespa.Target, error) {
 
 // targetType resolves the real target type and its custom URL (if any)
 func (c *CLI) targetType(targetTypeRestriction int) (targetType, error) {
-	v, err := c.config.targetOrURL()
-	if err != nil {
-		return targetType{}, err
+	return c.targetTypeOf("", targetTypeRestriction)
+}
+
+// targetTypeOf works like targetType, but resolves the given target instead of the configured one, if non-empty.
+func (c *CLI) targetTypeOf(target string, targetTypeRestriction int) (targetType, error) {
+	if target == "" {
+		var err error
+		if target, err = c.config.targetOrURL(); err != nil {
+			return targetType{}, err
+		}
 	}
-	tt := targetType{name: v}
-	if strings.HasPrefix(tt.name, "http://") || strings.HasPrefix(tt.name, "https://") {
+	var err error
+	tt := targetType{name: target}
+	if isURL(tt.name) {
 		tt.url = tt.name
-		tt.name, err = c.targetFromURL(tt.url)
+		urls := vespa.SplitURLs(tt.url)
+		for _, u := range urls {
+			if !isURL(u) {
+				return targetType{}, fmt.Errorf("invalid target url: %s", u)
+			}
+		}
+		tt.name, err = c.targetFromURL(urls[0])
 		if err != nil {
 			return targetType{}, err
 		}
+		if len(urls) > 1 && tt.name != vespa.TargetCustom {
+			return targetType{}, fmt.Errorf("multiple target urls are only supported for self-hosted Vespa, but %s is a %s url", urls[0], tt.name)
+		}
 	}
 	unsupported := (targetTypeRestriction == cloudTargetOnly && tt.name != vespa.TargetCloud && tt.name != vespa.TargetHosted) ||
 		(targetTypeRestriction == localTargetOnly && tt.name != vespa.TargetLocal && tt.name != vespa.TargetCustom)
@@ -508,6 +1121,11 @@ func (c *CLI) targetType(targetTypeRestriction int) (targetType, error) {
 	return tt, nil
 }
 
+// isURL returns whether target, or the first of a comma-separated list of targets, is an URL.
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
 func (c *CLI) targetFromURL(customURL string) (string, error) {
 	u, err := url.Parse(customURL)
 	if err != nil {
@@ -531,30 +1149,107 @@ func (c *CLI) createCustomTarget(targetType, customURL string) (vespa.Target, er
 	if err != nil {
 		return nil, err
 	}
+	c.checkCertificateExpiry(tlsOptions)
 	switch targetType {
 	case vespa.TargetLocal:
-		return vespa.LocalTarget(c.httpClient, tlsOptions, c.retryInterval), nil
+		target := vespa.LocalTarget(c.httpClient, tlsOptions, c.retryInterval)
+		if ct, ok := target.(vespa.ClockTarget); ok {
+			ct.SetNowFunc(c.now)
+		}
+		return target, nil
 	case vespa.TargetCustom:
-		return vespa.CustomTarget(c.httpClient, customURL, tlsOptions, c.retryInterval), nil
+		target := vespa.CustomTarget(c.httpClient, customURL, tlsOptions, c.retryInterval)
+		if ct, ok := target.(vespa.ClockTarget); ok {
+			ct.SetNowFunc(c.now)
+		}
+		if ft, ok := target.(vespa.FailoverTarget); ok && c.verbose {
+			ft.SetFailoverFunc(func(url string, err error) {
+				if err != nil {
+					c.printInfo("Config server ", color.CyanString(url), " is unreachable: ", err)
+				} else {
+					c.printInfo("Using config server ", color.CyanString(url))
+				}
+			})
+		}
+		return target, nil
 	default:
 		return nil, fmt.Errorf("invalid custom target: %s", targetType)
 	}
 }
 
-func (c *CLI) cloudApiAuthenticator(deployment vespa.Deployment, system vespa.System) (vespa.Authenticator, error) {
+// checkCertificateExpiry prints a warning if the data plane certificate in tlsOptions expires within the configured
+// number of days.
+func (c *CLI) checkCertificateExpiry(tlsOptions vespa.TLSOptions) {
+	days := c.config.certWarningDays()
+	if days == 0 || len(tlsOptions.KeyPair) == 0 {
+		return
+	}
+	cert, err := x509.ParseCertificate(tlsOptions.KeyPair[0].Certificate[0])
+	if err != nil {
+		return
+	}
+	remaining := cert.NotAfter.Sub(c.now())
+	if remaining < 0 || remaining > time.Duration(days)*24*time.Hour {
+		return
+	}
+	c.printWarning(fmt.Sprintf("data plane certificate %s expires at %s (in %d days)",
+		c.config.describeSource(tlsOptions.CertificateFile), cert.NotAfter.UTC().Format(time.RFC3339), int(remaining.Hours()/24)),
+		"Run 'vespa auth cert -f' to create a new certificate, and deploy the application to use it")
+}
+
+// cloudApiAuthenticator returns the authenticator of requests to the Vespa Cloud API, and the identity of its
+// credentials. The identity is empty if it cannot be determined.
+func (c *CLI) cloudApiAuthenticator(deployment vespa.Deployment, system vespa.System) (vespa.Authenticator, string, error) {
 	apiKey, err := c.config.readAPIKey(c, deployment.Application.Tenant)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if apiKey == nil {
 		authConfigPath := c.config.authConfigPath()
-		auth0, err := c.auth0Factory(c.httpClient, auth0.Options{ConfigPath: authConfigPath, SystemName: system.Name, SystemURL: system.URL})
+		auth0Client, err := c.auth0Factory(c.httpClient, c.auth0Options(system))
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
-		return auth0, nil
+		c.credentialSources = append(c.credentialSources, credentialSource{description: "access token " + c.config.describeSource(authConfigPath)})
+		identity := ""
+		if creds, ok, err := auth0.ReadCredentials(authConfigPath, system.Name, system.URL); err == nil && ok {
+			if subject := tokenSubject(creds.AccessToken); subject != "" {
+				identity = "token:" + subject
+			}
+		}
+		return auth0Client, identity, nil
+	}
+	c.credentialSources = append(c.credentialSources, credentialSource{description: "API key " + c.describeAPIKeySource(deployment.Application.Tenant)})
+	return vespa.NewRequestSigner(deployment.Application.SerializedForm(), apiKey), "api-key:" + string(apiKey), nil
+}
+
+// tokenSubject returns the subject of given JWT access token, or an empty string if it has none.
+func tokenSubject(accessToken string) string {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
 	}
-	return vespa.NewRequestSigner(deployment.Application.SerializedForm(), apiKey), nil
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// endpointCache returns the cache of endpoints discovered with the credentials of given identity, or nil if endpoints
+// should not be cached.
+func (c *CLI) endpointCache(identity string) *vespa.EndpointCache {
+	ttl := c.config.endpointCacheTTL()
+	if c.noEndpointCache || identity == "" || ttl <= 0 {
+		return nil
+	}
+	return &vespa.EndpointCache{Path: c.config.endpointCachePath(), TTL: ttl, Identity: identity, NowFunc: c.now}
 }
 
 func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL string) (vespa.Target, error) {
@@ -562,10 +1257,21 @@ func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL
 	if err != nil {
 		return nil, err
 	}
-	deployment, err := c.config.deploymentIn(system)
-	if err != nil {
+	var deployment vespa.Deployment
+	if opts.application != "" {
+		app, err := vespa.ApplicationFromString(opts.application)
+		if err != nil {
+			return nil, err
+		}
+		deployment = vespa.Deployment{System: system, Application: app, Zone: system.DefaultZone}
+	} else if deployment, err = c.config.deploymentIn(system); err != nil {
 		return nil, err
 	}
+	if opts.zone != "" {
+		if deployment.Zone, err = vespa.ZoneFromString(opts.zone); err != nil {
+			return nil, err
+		}
+	}
 	endpoints, err := c.endpointsFromEnv()
 	if err != nil {
 		return nil, err
@@ -575,12 +1281,13 @@ func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL
 		deploymentAuth       vespa.Authenticator
 		apiTLSOptions        vespa.TLSOptions
 		deploymentTLSOptions vespa.TLSOptions
+		identity             string
 	)
 	switch targetType {
 	case vespa.TargetCloud:
 		// Only setup API authentication if we're using "cloud" target, and not a direct URL
 		if customURL == "" {
-			apiAuth, err = c.cloudApiAuthenticator(deployment, system)
+			apiAuth, identity, err = c.cloudApiAuthenticator(deployment, system)
 			if err != nil {
 				return nil, err
 			}
@@ -589,9 +1296,20 @@ func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL
 		if !opts.noCertificate {
 			kp, err := c.config.readTLSOptions(deployment.Application, targetType)
 			if err != nil {
+				var cliErr ErrCLI
+				if errors.As(err, &cliErr) {
+					return nil, err
+				}
 				return nil, errHint(err, "Deployment to cloud requires a certificate", "Try 'vespa auth cert' to create a self-signed certificate")
 			}
+			c.checkCertificateExpiry(kp)
 			deploymentTLSOptions = kp
+			if kp.CertificateFile != "" {
+				c.credentialSources = append(c.credentialSources, credentialSource{description: "data plane certificate " + c.config.describeSource(kp.CertificateFile), dataPlane: true})
+			}
+			if kp.PrivateKeyFile != "" {
+				c.credentialSources = append(c.credentialSources, credentialSource{description: "data plane private key " + c.config.describeSource(kp.PrivateKeyFile), dataPlane: true})
+			}
 		}
 	case vespa.TargetHosted:
 		kp, err := c.config.readTLSOptions(deployment.Application, targetType)
@@ -605,6 +1323,7 @@ func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL
 		deploymentAuth = zts
 		apiTLSOptions = kp
 		deploymentTLSOptions = kp
+		identity = "cert:" + string(kp.CertificatePEM)
 	default:
 		return nil, fmt.Errorf("invalid cloud target: %s", targetType)
 	}
@@ -613,17 +1332,18 @@ func (c *CLI) createCloudTarget(targetType string, opts targetOptions, customURL
 		TLSOptions: apiTLSOptions,
 	}
 	deploymentOptions := vespa.CloudDeploymentOptions{
-		Deployment:  deployment,
-		TLSOptions:  deploymentTLSOptions,
-		CustomURL:   customURL,
-		ClusterURLs: endpoints,
+		Deployment:    deployment,
+		TLSOptions:    deploymentTLSOptions,
+		CustomURL:     customURL,
+		ClusterURLs:   endpoints,
+		EndpointCache: c.endpointCache(identity),
 	}
 	logLevel := opts.logLevel
 	if logLevel == "" {
 		logLevel = "info"
 	}
 	logOptions := vespa.LogOptions{
-		Writer: c.Stdout,
+		Writer: c.textOutput(),
 		Level:  vespa.LogLevel(logLevel),
 	}
 	return vespa.CloudTarget(c.httpClient, apiAuth, deploymentAuth, apiOptions, deploymentOptions, logOptions, c.retryInterval)
@@ -677,22 +1397,134 @@ func (c *CLI) endpointsFromEnv() (map[string]string, error) {
 
 // Run executes the CLI with given args. If args is nil, it defaults to os.Args[1:].
 func (c *CLI) Run(args ...string) error {
+	_, err := c.run(args)
+	return err
+}
+
+// Execute executes the CLI with the given args, like Run, and returns the result of the executed command. The result
+// holds a value for commands which produce a structured result, such as deploy, feed, query and status.
+func (c *CLI) Execute(args []string) (Result, error) {
+	if args == nil {
+		args = []string{}
+	}
+	c.resetFlags()
+	c.recordResults = true
+	c.result = Result{}
+	defer func() { c.recordResults = false }()
+	executed, err := c.run(args)
+	if executed != nil {
+		c.result.Command = strings.TrimPrefix(strings.TrimPrefix(executed.CommandPath(), c.cmd.Name()), " ")
+	}
+	return c.result, err
+}
+
+// resetFlags sets the flags of all commands to their default values, such that flags given to a command executed before
+// do not apply to the next.
+func (c *CLI) resetFlags() {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			var values []string
+			if s := strings.Trim(f.DefValue, "[]"); s != "" {
+				values = strings.Split(s, ",")
+			}
+			v.Replace(values)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	var resetCommand func(cmd *cobra.Command)
+	resetCommand = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(reset)
+		cmd.PersistentFlags().VisitAll(reset)
+		for _, sub := range cmd.Commands() {
+			resetCommand(sub)
+		}
+	}
+	resetCommand(c.cmd)
+	c.zones.zones = nil
+}
+
+func (c *CLI) run(args []string) (*cobra.Command, error) {
 	c.cmd.SetArgs(args)
-	err := c.cmd.Execute()
+	c.credentialSources = nil
+	c.secretHeaders = nil
+	c.zones.given = 0
+	stopContext := c.startContext()
+	defer stopContext()
+	executed, err := c.cmd.ExecuteC()
+	c.finishTrace()
+	defer c.finishUpdateCheck()
 	if err != nil {
+		err = withErrorCode(c.withCredentialHints(c.withContextError(err)))
+		if c.jsonOutput() {
+			c.printErrJSON(err)
+			return executed, err
+		}
 		if cliErr, ok := err.(ErrCLI); ok {
 			if !cliErr.quiet {
 				if cliErr.warn {
-					c.printWarning(cliErr, cliErr.hints...)
+					c.printWarning(codedMessage(cliErr), cliErr.hints...)
 				} else {
-					c.printErr(cliErr, cliErr.hints...)
+					c.printErr(errors.New(codedMessage(cliErr)), cliErr.hints...)
 				}
 			}
 		} else {
 			c.printErr(err)
 		}
 	}
-	return err
+	return executed, err
+}
+
+// withCredentialHints adds hints naming the credentials in use to err, if err is an authentication failure. Data plane
+// credentials are only named if the failure may have happened in the data plane.
+func (c *CLI) withCredentialHints(err error) error {
+	cliErr, ok := err.(ErrCLI)
+	if !ok {
+		cliErr = errHint(err)
+	}
+	var authErr vespa.AuthError
+	dataPlane := errors.As(cliErr.error, &authErr)
+	if !dataPlane && !errors.Is(cliErr.error, vespa.ErrUnauthorized) {
+		return err
+	}
+	added := false
+	for _, source := range c.credentialSources {
+		if source.dataPlane && !dataPlane {
+			continue
+		}
+		cliErr.hints = append(cliErr.hints, "Authenticated with "+source.description)
+		added = true
+	}
+	if !added {
+		return err
+	}
+	return cliErr
+}
+
+// errorJSON is the JSON representation of an error returned to the user.
+type errorJSON struct {
+	Message string   `json:"message"`
+	Code    string   `json:"code,omitempty"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+// printErrJSON prints err as JSON to standard error, unless err is quiet.
+func (c *CLI) printErrJSON(err error) {
+	e := errorJSON{Message: err.Error()}
+	if cliErr, ok := err.(ErrCLI); ok {
+		if cliErr.quiet {
+			return
+		}
+		e.Code = string(cliErr.code)
+		e.Hints = cliErr.hints
+	}
+	enc := json.NewEncoder(c.Stderr)
+	enc.SetIndent("", "  ")
+	enc.Encode(e)
 }
 
 type endpoints struct {
@@ -0,0 +1,77 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa schema command
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+func newSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Work with the schemas of an application package",
+		Long: `Work with the schemas of an application package.
+
+Check schemas and services.xml locally, without deploying them.`,
+		Example:           `$ vespa schema validate`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      false,
+		Args:              cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fmt.Errorf("invalid command: %s", args[0])
+		},
+	}
+}
+
+func newSchemaValidateCmd(cli *CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:     "validate [application-directory-or-file]",
+		Aliases: []string{"lint"},
+		Short:   "Check an application package for common errors, locally",
+		Long: `Check an application package for common errors, locally.
+
+This is a fast lint which runs without a target, and catches common mistakes
+before deploying:
+
+- services.xml, hosts.xml, deployment.xml and validation-overrides.xml must be
+  well-formed XML with the expected root element
+- Schemas must have balanced braces, fields of known types, no duplicate fields,
+  and rank profiles may only reference fields which exist
+- Each document type in services.xml must have a schema
+
+Each problem is printed with its file and line, and the command fails if any
+problem is found. Passing these checks does not mean the application package
+is valid: this is only decided when the application package is deployed.
+
+If application directory is not specified, it defaults to working directory.`,
+		Example: `$ vespa schema validate
+$ vespa schema validate my-app`,
+		Args:              cobra.MaximumNArgs(1),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pkg, err := cli.applicationPackageFrom(args, vespa.PackageOptions{SourceOnly: true})
+			if err != nil {
+				return err
+			}
+			problems, err := pkg.Lint()
+			if err != nil {
+				return err
+			}
+			for _, problem := range problems {
+				problem.Path = filepath.Join(pkg.Path, problem.Path)
+				fmt.Fprintln(cli.Stdout, problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problems in application package %s", len(problems), pkg.Path)
+			}
+			cli.printSuccess("No problems found in application package ", pkg.Path)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	cli, stdout, stderr := newTestCLI(t)
+	appDir := filepath.Join("testdata", "applications", "withSource", "src", "main", "application")
+	require.Nil(t, cli.Run("schema", "validate", appDir))
+	assert.Equal(t, "Success: No problems found in application package "+appDir+"\n", stdout.String())
+
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "services.xml"), []byte("<services>\n  <content id=\"c\">\n    <documents>\n      <document type=\"music\"/>\n    </documents>\n  </content>\n</services>\n"), 0644))
+	cli, stdout, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("schema", "validate", dir))
+	assert.Equal(t, filepath.Join(dir, "services.xml")+":4: document type 'music' has no schema: expected schemas/music.sd\n", stdout.String())
+	assert.Equal(t, "Error: found 1 problems in application package "+dir+"\n", stderr.String())
+}
@@ -4,28 +4,105 @@ package cmd
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/admin/envvars"
 )
 
+// authFlag is the flag choosing the data plane authentication method of a single command.
+const authFlag = "auth"
+
+// selectAuthMethod returns the method used to authenticate to the data plane, either "mtls" or "token". The method is
+// chosen by the auth flag, the data-plane-auth option, or whether VESPA_CLI_DATA_PLANE_TOKEN is set, in that order.
 func (cli *CLI) selectAuthMethod() (authMethod string) {
-	token := cli.Environment[envvars.VESPA_CLI_DATA_PLANE_TOKEN]
+	value := ""
+	if f := cli.cmd.PersistentFlags().Lookup(authFlag); f != nil && f.Changed {
+		value = f.Value.String()
+	} else if option, ok := cli.config.get(dataPlaneAuthOption); ok {
+		value = option
+	}
+	switch value {
+	case "cert":
+		return "mtls"
+	case "token":
+		return "token"
+	}
 	authMethod = "mtls"
-	if token != "" {
+	if cli.Environment[envvars.VESPA_CLI_DATA_PLANE_TOKEN] != "" {
 		cli.printDebug("The VESPA_CLI_DATA_PLANE_TOKEN environment variable is set, using token authentication")
 		authMethod = "token"
 	}
 	return
 }
 
+// checkAuthFlag returns an error if the auth flag is set to an invalid value, or for a command which does not support it.
+func (cli *CLI) checkAuthFlag(cmd *cobra.Command) error {
+	f := cli.cmd.PersistentFlags().Lookup(authFlag)
+	if f == nil || !f.Changed {
+		return nil
+	}
+	if !isDataPlaneCommand(cmd) && cmd.CommandPath() != "vespa status auth" && cmd.CommandPath() != "vespa curl" {
+		return errHint(fmt.Errorf("--%s is not supported by %s", authFlag, cmd.CommandPath()), "Supported commands are "+strings.Join(dataPlaneCommands, ", ")+", curl and status auth")
+	}
+	_, err := checkEnum("--"+authFlag, f.Value.String(), "cert", "token")
+	return err
+}
+
+// dataPlaneToken returns the data plane token to use with token authentication, and a description of where it was
+// read from.
+func (cli *CLI) dataPlaneToken() (string, string, error) {
+	if token := cli.Environment[envvars.VESPA_CLI_DATA_PLANE_TOKEN]; token != "" {
+		return token, "the " + envvars.VESPA_CLI_DATA_PLANE_TOKEN + " environment variable", nil
+	}
+	name, ok := cli.config.get(dataPlaneTokenOption)
+	if !ok {
+		err := fmt.Errorf("token authentication is selected, but no data plane token is set")
+		return "", "", errHint(err, "Store a token with 'vespa auth token set <name>'", "Or set the VESPA_CLI_DATA_PLANE_TOKEN environment variable")
+	}
+	token, err := cli.config.readDataPlaneToken(name)
+	if err != nil {
+		return "", "", err
+	}
+	return token, "the stored token " + name, nil
+}
+
 func (cli *CLI) addBearerToken(header *http.Header) error {
-	token := cli.Environment[envvars.VESPA_CLI_DATA_PLANE_TOKEN]
-	if token != "" {
-		if header.Get("Authorization") != "" {
-			err := fmt.Errorf("header 'Authorization' cannot be set in combination with VESPA_CLI_DATA_PLANE_TOKEN")
-			return errHint(err, "Unset the VESPA_CLI_DATA_PLANE_TOKEN environment variable or remove the Authorization header")
-		}
-		header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	token, source, err := cli.dataPlaneToken()
+	if err != nil {
+		return err
+	}
+	if header.Get("Authorization") != "" {
+		err := fmt.Errorf("header 'Authorization' cannot be set in combination with token authentication")
+		return errHint(err, "Remove the Authorization header, or use --auth cert", "The token was read from "+source)
 	}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	return nil
 }
+
+// authRejected returns whether a request to the data plane which got given status was rejected because of its
+// authentication.
+func authRejected(status int) bool { return status == 401 || status == 403 }
+
+// authMethodDescription describes authMethod, as returned by selectAuthMethod, for use in messages.
+func authMethodDescription(authMethod string) string {
+	if authMethod == "token" {
+		return "token authentication"
+	}
+	return "certificate authentication"
+}
+
+// authRejectedHints returns hints for a request to the data plane which was rejected, when authenticated with authMethod.
+func (cli *CLI) authRejectedHints(authMethod string) []string {
+	if authMethod == "token" {
+		hints := []string{"Check that the token is valid, and is allowed to access this application"}
+		if _, source, err := cli.dataPlaneToken(); err == nil {
+			hints = append(hints, "The token was read from "+source)
+		}
+		return append(hints, "Use --auth cert to authenticate with the data plane certificate instead")
+	}
+	return []string{
+		"Check that the certificate is included in the deployed application package, with 'vespa status auth'",
+		"Use --auth token to authenticate with a data plane token instead",
+	}
+}
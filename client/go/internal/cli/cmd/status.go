@@ -5,11 +5,13 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
@@ -21,6 +23,7 @@ func newStatusCmd(cli *CLI) *cobra.Command {
 	var (
 		waitSecs int
 		format   string
+		noDetect bool
 	)
 	cmd := &cobra.Command{
 		Use: "status",
@@ -33,58 +36,75 @@ func newStatusCmd(cli *CLI) *cobra.Command {
 		Long: `Show Vespa endpoints and status.
 
 This command shows the current endpoints, and their status, of a deployed Vespa
-application.`,
+application. All endpoints of all container clusters are shown, with the
+authentication method of each, unless --cluster is given to show only the
+endpoints of that cluster. With --wait, the command waits for all the shown
+endpoints to become ready.
+
+For a local target, whether each content cluster accepts feed is also shown,
+with the disk and memory usage of its fullest content node, relative to the
+limits at which the cluster blocks feed. The command fails with FEED_BLOCKED
+when this is the case for any content cluster.
+
+When the target is local and its config server is not reachable, the
+containers of Docker or Podman are inspected to find the port the config server
+is published on. Use --no-detect to skip this.`,
 		Example: `$ vespa status
 $ vespa status --cluster mycluster
 $ vespa status --cluster mycluster --wait 600
-$ vepsa status --format plain --cluster mycluster`,
+$ vepsa status --format plain --cluster mycluster
+$ vespa status --format json`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cluster := cli.config.cluster()
-			t, err := cli.target(targetOptions{})
+			t, err := cli.target(targetOptions{detectLocal: !noDetect})
 			if err != nil {
 				return err
 			}
+			format = cli.outputFormat(cmd, format)
 			if err := verifyFormat(format); err != nil {
 				return err
 			}
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			var failingContainers []*vespa.Service
-			if cluster == "" {
-				services, err := waiter.Services(t)
-				if err != nil {
-					return err
-				}
-				if len(services) == 0 {
-					return errHint(fmt.Errorf("no services exist"), "Deployment may not be ready yet", "Try 'vespa status deployment'")
-				}
-				for _, s := range services {
-					if !printServiceStatus(s, format, waiter, cli) {
-						failingContainers = append(failingContainers, s)
-					}
+			services, err := waiter.ClusterServices(t, cluster)
+			if err != nil {
+				return err
+			}
+			if len(services) == 0 {
+				return errCode(codeServiceNotReady, fmt.Errorf("no services exist"), "Deployment may not be ready yet", "Try 'vespa status deployment'")
+			}
+			var feedBlocks []vespa.FeedBlock
+			readContentClusters := func() []vespa.FeedBlock {
+				if cluster != "" || format == "plain" {
+					return nil
 				}
-			} else {
-				s, err := waiter.Service(t, cluster)
+				feedBlocks, err = readFeedBlocks(t)
 				if err != nil {
-					return err
-				}
-				if !printServiceStatus(s, format, waiter, cli) {
-					failingContainers = append(failingContainers, s)
+					cli.printWarning(fmt.Sprintf("Could not read the feed block state of content clusters: %s", err))
 				}
+				return feedBlocks
+			}
+			failing, err := printServiceStatus(services, readContentClusters, nil, format, waiter, cli)
+			if err != nil {
+				return err
+			}
+			if err := failingServicesErr(failing...); err != nil {
+				return err
 			}
-			return failingServicesErr(failingContainers...)
+			return feedBlockedErr(feedBlocks)
 		},
 	}
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
-	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable) or 'plain' (cluster URL only)")
+	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable), 'plain' (cluster URL only) or 'json'")
+	bindNoDetectFlag(cmd, &noDetect)
 	return cmd
 }
 
 func verifyFormat(format string) error {
 	switch format {
-	case "human", "plain":
+	case "human", "plain", "json":
 		return nil
 	default:
 		return fmt.Errorf("invalid format: %s", format)
@@ -97,19 +117,23 @@ func failingServicesErr(services ...*vespa.Service) error {
 	}
 	var nameOrURL []string
 	for _, s := range services {
+		name := s.BaseURL
 		if s.Name != "" {
-			nameOrURL = append(nameOrURL, s.Name)
-		} else {
-			nameOrURL = append(nameOrURL, s.BaseURL)
+			name = s.Name
+		}
+		if s.AuthMethod != "" {
+			name += " (" + s.AuthMethod + ")"
 		}
+		nameOrURL = append(nameOrURL, name)
 	}
-	return fmt.Errorf("services not ready: %s", strings.Join(nameOrURL, ", "))
+	return errCode(codeServiceNotReady, fmt.Errorf("services not ready: %s", strings.Join(nameOrURL, ", ")))
 }
 
 func newStatusDeployCmd(cli *CLI) *cobra.Command {
 	var (
 		waitSecs int
 		format   string
+		noDetect bool
 	)
 	cmd := &cobra.Command{
 		Use:               "deploy",
@@ -119,10 +143,11 @@ func newStatusDeployCmd(cli *CLI) *cobra.Command {
 		SilenceUsage:      true,
 		Args:              cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			t, err := cli.target(targetOptions{})
+			t, err := cli.target(targetOptions{detectLocal: !noDetect})
 			if err != nil {
 				return err
 			}
+			format = cli.outputFormat(cmd, format)
 			if err := verifyFormat(format); err != nil {
 				return err
 			}
@@ -131,19 +156,37 @@ func newStatusDeployCmd(cli *CLI) *cobra.Command {
 			if err != nil {
 				return err
 			}
-			if !printServiceStatus(s, format, waiter, cli) {
-				return failingServicesErr(s)
+			var generation func() int64
+			if !t.IsCloud() && (format == "json" || cli.recordResults) {
+				generation = func() int64 {
+					// Only a converged generation is reported
+					id, err := t.AwaitDeployment(vespa.LatestDeployment, 0)
+					if err != nil {
+						return 0
+					}
+					return id
+				}
 			}
-			return nil
+			failing, err := printServiceStatus([]*vespa.Service{s}, nil, generation, format, waiter, cli)
+			if err != nil {
+				return err
+			}
+			return failingServicesErr(failing...)
 		},
 	}
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
-	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'plain' (cluster URL only)")
+	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text), 'plain' (cluster URL only) or 'json'")
+	bindNoDetectFlag(cmd, &noDetect)
 	return cmd
 }
 
 func newStatusDeploymentCmd(cli *CLI) *cobra.Command {
-	var waitSecs int
+	var (
+		waitSecs int
+		format   string
+		detail   bool
+		noDetect bool
+	)
 	cmd := &cobra.Command{
 		Use:   "deployment",
 		Short: "Show status of a Vespa deployment",
@@ -152,11 +195,27 @@ func newStatusDeploymentCmd(cli *CLI) *cobra.Command {
 This commands shows whether a Vespa deployment has converged on the latest run
 (Vespa Cloud) or config generation (self-hosted). If an argument is given,
 show the convergence status of that particular run or generation.
+
+With --detail, the convergence of each service of the deployment is shown as
+well, with services which have not converged listed first. For self-hosted
+deployments this shows the config generation each service runs on, and the
+generation it should run on. For Vespa Cloud this shows the state of each node,
+and its current and wanted Vespa version, when the node repository is
+accessible with the configured credentials.
+
+When waiting for a self-hosted deployment to converge, the wait is aborted
+early if a service stays down for 30 consecutive polls, or goes down more than
+3 times, as when it crash-loops. The service and its host are then reported,
+together with the last errors it logged. Use --no-early-abort to wait for the
+full timeout regardless.
 `,
 		Example: `$ vespa status deployment
 $ vespa status deployment -t cloud [run-id]
 $ vespa status deployment -t local [session-id]
 $ vespa status deployment -t local [session-id] --wait 600
+$ vespa status deployment --wait 900 --no-early-abort
+$ vespa status deployment --format json
+$ vespa status deployment --detail
 `,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
@@ -170,13 +229,36 @@ $ vespa status deployment -t local [session-id] --wait 600
 				}
 				wantedID = n
 			}
-			t, err := cli.target(targetOptions{logLevel: "none"})
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			t, err := cli.target(targetOptions{logLevel: "none", detectLocal: !noDetect})
 			if err != nil {
 				return err
 			}
 			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
 			id, err := waiter.Deployment(t, wantedID)
+			var details []vespa.ServiceDetail
+			if detail {
+				var detailErr error
+				if details, detailErr = serviceDetails(cli, t); detailErr != nil {
+					return detailErr
+				}
+			}
+			if format == "json" {
+				if jsonErr := printDeploymentStatusJSON(cli, t, id, err, details); jsonErr != nil {
+					return jsonErr
+				}
+				if err != nil {
+					return ErrCLI{Status: exitError, quiet: true, error: err}
+				}
+				return nil
+			}
 			if err != nil {
+				if printErr := printServiceDetails(cli, t, details); printErr != nil {
+					return printErr
+				}
 				if errors.Is(err, vespa.ErrWaitTimeout) && t.IsCloud() {
 					cli.printInfo("Deployment is still running. See ", color.CyanString(t.Deployment().System.ConsoleRunURL(t.Deployment(), id)), " for more details")
 				}
@@ -184,7 +266,14 @@ $ vespa status deployment -t local [session-id] --wait 600
 				if waiter.Timeout == 0 && !errors.Is(err, vespa.ErrDeployment) {
 					hints = []string{"Consider using the --wait flag to increase the wait period", "--wait 120 will make this command wait for completion up to 2 minutes"}
 				}
-				return ErrCLI{Status: 1, warn: true, hints: hints, error: err}
+				var code errorCode
+				var cliErr ErrCLI
+				if errors.As(err, &cliErr) {
+					hints = append(hints, cliErr.hints...)
+					code = cliErr.code
+					err = cliErr.error
+				}
+				return ErrCLI{Status: code.exitStatus(), warn: true, code: code, hints: hints, error: err}
 			}
 			if t.IsCloud() {
 				log.Printf("Deployment run %s has completed", color.CyanString(strconv.FormatInt(id, 10)))
@@ -192,15 +281,173 @@ $ vespa status deployment -t local [session-id] --wait 600
 			} else {
 				log.Printf("Deployment is %s on config generation %s", color.GreenString("ready"), color.CyanString(strconv.FormatInt(id, 10)))
 			}
-			return nil
+			return printServiceDetails(cli, t, details)
 		},
 	}
 	cli.bindWaitFlag(cmd, 0, &waitSecs)
+	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	cmd.Flags().BoolVarP(&detail, "detail", "", false, "Show the convergence of each service of the deployment")
+	bindNoDetectFlag(cmd, &noDetect)
+	bindNoEarlyAbortFlag(cmd)
 	return cmd
 }
 
-func printServiceStatus(s *vespa.Service, format string, waiter *Waiter, cli *CLI) bool {
-	err := s.Wait(waiter.Timeout)
+// serviceDetails returns the state of each service of the deployment of t. Failure to read the node repository of
+// Vespa Cloud is only warned about, as it may not be accessible with the configured credentials.
+func serviceDetails(cli *CLI, t vespa.Target) ([]vespa.ServiceDetail, error) {
+	detailTarget, ok := t.(vespa.DetailTarget)
+	if !ok {
+		return nil, fmt.Errorf("target %s does not support --detail", t.Type())
+	}
+	details, err := detailTarget.ServiceDetails()
+	if err != nil {
+		if t.IsCloud() {
+			cli.printWarning(err, "The node repository may not be accessible with the configured credentials")
+			return nil, nil
+		}
+		return nil, err
+	}
+	return details, nil
+}
+
+// printServiceDetails prints a table of the given services of the deployment of t, if any were retrieved.
+func printServiceDetails(cli *CLI, t vespa.Target, details []vespa.ServiceDetail) error {
+	if details == nil {
+		return nil
+	}
+	if len(details) == 0 {
+		cli.printInfo("No services found in deployment")
+		return nil
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	restartPending := func(d vespa.ServiceDetail) string {
+		if d.RestartPending == nil {
+			return "-"
+		}
+		return strconv.FormatBool(*d.RestartPending)
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	if t.IsCloud() {
+		fmt.Fprintln(w, "HOST\tCLUSTER\tSTATE\tCURRENT VERSION\tWANTED VERSION\tRESTART PENDING\tCONVERGED")
+		for _, d := range details {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n", d.Host, orDash(strings.Trim(d.Type+"/"+d.Cluster, "/")), orDash(d.State),
+				orDash(d.CurrentVersion), orDash(d.WantedVersion), restartPending(d), d.Converged())
+		}
+	} else {
+		fmt.Fprintln(w, "HOST\tSERVICE\tCURRENT GENERATION\tWANTED GENERATION\tRESTART PENDING\tCONVERGED")
+		for _, d := range details {
+			fmt.Fprintf(w, "%s:%d\t%s\t%d\t%d\t%s\t%t\n", d.Host, d.Port, orDash(strings.Trim(d.Cluster+"/"+d.Type, "/")),
+				d.CurrentGeneration, d.WantedGeneration, restartPending(d), d.Converged())
+		}
+	}
+	return w.Flush()
+}
+
+type serviceStatusJSON struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	AuthMethod string `json:"authMethod,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Status     int    `json:"status"`
+	Ready      bool   `json:"ready"`
+	// Generation is the config generation the deployment has converged on, if known
+	Generation int64  `json:"generation,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type statusJSON struct {
+	Ready           bool                `json:"ready"`
+	Services        []serviceStatusJSON `json:"services"`
+	ContentClusters []feedBlockJSON     `json:"contentClusters,omitempty"`
+}
+
+type deploymentStatusJSON struct {
+	Ready      bool                  `json:"ready"`
+	Generation int64                 `json:"generation,omitempty"`
+	Run        int64                 `json:"run,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	Services   []vespa.ServiceDetail `json:"services,omitempty"`
+}
+
+func writeJSON(cli *CLI, v any) error {
+	enc := json.NewEncoder(cli.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printDeploymentStatusJSON(cli *CLI, t vespa.Target, id int64, err error, details []vespa.ServiceDetail) error {
+	status := deploymentStatusJSON{Ready: err == nil, Services: details}
+	if t.IsCloud() {
+		status.Run = id
+	} else {
+		status.Generation = id
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return writeJSON(cli, status)
+}
+
+// printServiceStatus prints the status of given services in format, and returns the services which are not ready. The
+// feed block state of content clusters returned by contentClusters, if non-nil, is printed after the services, when
+// all of them are ready. The generation returned by generation, if non-nil, is the converged generation of each ready
+// service.
+func printServiceStatus(services []*vespa.Service, contentClusters func() []vespa.FeedBlock, generation func() int64, format string, waiter *Waiter, cli *CLI) ([]*vespa.Service, error) {
+	var (
+		failing []*vespa.Service
+		status  statusJSON
+	)
+	status.Ready = true
+	for _, s := range services {
+		httpStatus, err := s.WaitStatus(waiter.Timeout)
+		if err != nil {
+			failing = append(failing, s)
+		}
+		ss := serviceStatusJSON{
+			Name:       s.Name,
+			URL:        s.BaseURL,
+			AuthMethod: s.AuthMethod,
+			Status:     httpStatus,
+			Ready:      err == nil,
+		}
+		if err != nil {
+			ss.Error = err.Error()
+			status.Ready = false
+		} else if generation != nil {
+			ss.Generation = generation()
+		}
+		if cli.config.isEnvSource(s.TLSOptions.CertificateFile) {
+			ss.Source = "environment"
+		}
+		status.Services = append(status.Services, ss)
+		if format != "json" {
+			printServiceStatusText(s, format, err, cli)
+		}
+	}
+	var feedBlocks []vespa.FeedBlock
+	if contentClusters != nil && len(failing) == 0 {
+		feedBlocks = contentClusters()
+	}
+	for _, b := range feedBlocks {
+		status.ContentClusters = append(status.ContentClusters, newFeedBlockJSON(b))
+	}
+	cli.recordResult(status)
+	if format == "json" {
+		if err := writeJSON(cli, status); err != nil {
+			return nil, err
+		}
+	} else if format == "human" {
+		printFeedBlocks(cli, feedBlocks)
+	}
+	return failing, nil
+}
+
+func printServiceStatusText(s *vespa.Service, format string, err error, cli *CLI) {
 	var sb strings.Builder
 	switch format {
 	case "human":
@@ -215,7 +462,11 @@ func printServiceStatus(s *vespa.Service, format string, waiter *Waiter, cli *CL
 			sb.WriteString(err.Error())
 		}
 		if s.AuthMethod != "" {
-			sb.WriteString(color.CyanString(fmt.Sprintf(" (%s)", s.AuthMethod)))
+			authMethod := s.AuthMethod
+			if s.AuthMethod == "mtls" && cli.config.isEnvSource(s.TLSOptions.CertificateFile) {
+				authMethod += ", certificate " + cli.config.describeSource(s.TLSOptions.CertificateFile)
+			}
+			sb.WriteString(color.CyanString(fmt.Sprintf(" (%s)", authMethod)))
 		}
 	case "plain":
 		sb.WriteString(s.BaseURL)
@@ -223,5 +474,4 @@ func printServiceStatus(s *vespa.Service, format string, waiter *Waiter, cli *CL
 		panic("invalid format: " + format)
 	}
 	fmt.Fprintln(cli.Stdout, sb.String())
-	return err == nil
 }
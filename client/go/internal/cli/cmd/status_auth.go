@@ -0,0 +1,321 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// authCheck is the result of a check of data plane authentication.
+type authCheck struct {
+	Name    string   `json:"name"`
+	Passed  bool     `json:"passed"`
+	Skipped bool     `json:"skipped,omitempty"`
+	Message string   `json:"message"`
+	Hints   []string `json:"hints,omitempty"`
+}
+
+type authCheckJSON struct {
+	AuthMethod string      `json:"authMethod"`
+	Passed     bool        `json:"passed"`
+	Checks     []authCheck `json:"checks"`
+}
+
+func newStatusAuthCmd(cli *CLI) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Check authentication to the endpoints of a Vespa Cloud deployment",
+		Long: `Check authentication to the endpoints of a Vespa Cloud deployment.
+
+This command runs a series of checks for diagnosing requests to the data plane
+of an application that are rejected, e.g. with "403 Forbidden". With
+certificate (mTLS) authentication, it checks that a certificate and private
+key are present locally, and that the certificate is one of those in
+security/clients.pem of the deployed application package, which is fetched
+from Vespa Cloud. With token authentication, chosen by --auth token, the
+data-plane-auth option, or when the VESPA_CLI_DATA_PLANE_TOKEN environment
+variable is set, it checks that a token is set, and that the deployment has an
+endpoint for token authentication.
+
+Finally, a request is sent to the endpoint of the container cluster given by
+--cluster, and the layer at which it is rejected, if any, is reported: the TLS
+handshake, the certificate, or authorization of the request (status 401 and
+403).
+
+Each check prints whether it passed or failed, with hints on how to resolve a
+failure. The command fails if any check fails.`,
+		Example: `$ vespa status auth
+$ vespa status auth --cluster mycluster
+$ vespa status auth --format json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			target, err := cli.target(targetOptions{supportedType: cloudTargetOnly, noCertificate: true})
+			if err != nil {
+				return err
+			}
+			if target.Type() != vespa.TargetCloud {
+				return errHint(fmt.Errorf("authentication checks are only supported for Vespa Cloud"), "Use --target cloud")
+			}
+			authMethod := cli.selectAuthMethod()
+			checks := cli.checkAuth(target, authMethod, cmd)
+			failed := 0
+			for _, check := range checks {
+				if !check.Passed && !check.Skipped {
+					failed++
+				}
+			}
+			if format == "json" {
+				if err := writeJSON(cli, authCheckJSON{AuthMethod: authMethod, Passed: failed == 0, Checks: checks}); err != nil {
+					return err
+				}
+			} else {
+				printAuthChecks(cli, target, authMethod, checks)
+			}
+			if failed > 0 {
+				return errCode(codeAuthFailed, fmt.Errorf("%d of %d authentication checks failed", failed, len(checks)))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	return cmd
+}
+
+// checkAuth runs the checks of data plane authentication with authMethod to the deployment of target.
+func (c *CLI) checkAuth(target vespa.Target, authMethod string, cmd *cobra.Command) []authCheck {
+	var (
+		checks     []authCheck
+		tlsOptions vespa.TLSOptions
+		endpointOK = true
+	)
+	if authMethod == "mtls" {
+		var check authCheck
+		tlsOptions, check = c.checkLocalCertificate(target.Deployment().Application)
+		checks = append(checks, check)
+		if check.Passed {
+			checks = append(checks, checkDeployedCertificate(target, tlsOptions))
+		} else {
+			endpointOK = false
+			checks = append(checks, authCheck{Name: "deployed certificate", Skipped: true, Message: "skipped, as no local certificate is present"})
+		}
+	} else {
+		_, source, err := c.dataPlaneToken()
+		if err != nil {
+			check := authCheck{Name: "token", Message: err.Error()}
+			var cliErr ErrCLI
+			if errors.As(err, &cliErr) {
+				check.Message = cliErr.error.Error()
+				check.Hints = cliErr.hints
+			}
+			return append(checks, check)
+		}
+		checks = append(checks, authCheck{Name: "token", Passed: true, Message: "token is read from " + source})
+	}
+	waiter := c.waiter(0, cmd)
+	service, err := waiter.ServiceWithAuthMethod(target, c.config.cluster(), authMethod)
+	if err != nil {
+		check := authCheck{Name: "endpoint", Message: err.Error()}
+		var cliErr ErrCLI
+		if errors.As(err, &cliErr) {
+			check.Message = cliErr.error.Error()
+			check.Hints = cliErr.hints
+		}
+		return append(checks, check)
+	}
+	if !endpointOK {
+		return append(checks, authCheck{Name: "endpoint", Skipped: true, Message: "skipped request to " + service.BaseURL + ", as no local certificate is present"})
+	}
+	if authMethod == "mtls" {
+		service.TLSOptions = tlsOptions
+	}
+	return append(checks, c.checkEndpoint(service, authMethod))
+}
+
+// checkLocalCertificate checks that a valid certificate and private key exist for app, and returns them.
+func (c *CLI) checkLocalCertificate(app vespa.ApplicationID) (vespa.TLSOptions, authCheck) {
+	check := authCheck{Name: "local certificate"}
+	tlsOptions, err := c.config.readTLSOptions(app, vespa.TargetCloud)
+	if err != nil {
+		check.Message = "no usable certificate and private key found: " + err.Error()
+		check.Hints = []string{"Run 'vespa auth cert' to create a certificate, and deploy the application to use it"}
+		if errors.Is(err, os.ErrNotExist) {
+			check.Message = "no certificate and private key found for " + app.String()
+		}
+		return vespa.TLSOptions{}, check
+	}
+	if len(tlsOptions.KeyPair) == 0 {
+		check.Message = "no certificate and private key found for " + app.String()
+		check.Hints = []string{"Run 'vespa auth cert' to create a certificate, and deploy the application to use it"}
+		return vespa.TLSOptions{}, check
+	}
+	certificate, err := x509.ParseCertificate(tlsOptions.KeyPair[0].Certificate[0])
+	if err != nil {
+		check.Message = "invalid certificate: " + err.Error()
+		return vespa.TLSOptions{}, check
+	}
+	fingerprint := vespa.FingerprintSHA256(certificate)
+	source := c.config.describeSource(tlsOptions.CertificateFile)
+	if now := c.now(); now.After(certificate.NotAfter) {
+		check.Message = fmt.Sprintf("certificate %s %s expired at %s", fingerprint, source, certificate.NotAfter.UTC().Format(time.RFC3339))
+		check.Hints = []string{"Run 'vespa auth cert -f' to create a new certificate, and deploy the application to use it"}
+		return vespa.TLSOptions{}, check
+	}
+	check.Passed = true
+	check.Message = fmt.Sprintf("certificate %s %s, valid until %s", fingerprint, source, certificate.NotAfter.UTC().Format(time.RFC3339))
+	return tlsOptions, check
+}
+
+// checkDeployedCertificate checks that the certificate in tlsOptions is in security/clients.pem of the application
+// package deployed to target.
+func checkDeployedCertificate(target vespa.Target, tlsOptions vespa.TLSOptions) authCheck {
+	check := authCheck{Name: "deployed certificate"}
+	tmpDir, err := os.MkdirTemp("", "vespa-auth")
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	defer os.RemoveAll(tmpDir)
+	fetched, err := vespa.Fetch(vespa.DeploymentOptions{Target: target}, tmpDir, vespa.FetchOptions{})
+	if err != nil {
+		check.Message = "could not fetch deployed application package: " + err.Error()
+		if errors.Is(err, vespa.ErrNotFound) {
+			check.Message = "no application package is deployed to " + target.Deployment().String()
+			check.Hints = []string{"Run 'vespa deploy' to deploy the application"}
+		}
+		return check
+	}
+	deployedPkg := vespa.ApplicationPackage{Path: fetched.Path}
+	files, err := deployedPkg.Files()
+	if err != nil {
+		check.Message = "could not read deployed application package: " + err.Error()
+		return check
+	}
+	certificate, err := x509.ParseCertificate(tlsOptions.KeyPair[0].Certificate[0])
+	if err != nil {
+		check.Message = "invalid certificate: " + err.Error()
+		return check
+	}
+	fingerprint := vespa.FingerprintSHA256(certificate)
+	clientsPEM := files["security/clients.pem"].Content
+	var deployed []string
+	for rest := clientsPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if deployedCertificate, err := x509.ParseCertificate(block.Bytes); err == nil {
+			deployed = append(deployed, vespa.FingerprintSHA256(deployedCertificate))
+		}
+	}
+	hint := "Run 'vespa auth cert' to add the certificate to the application package, and deploy it"
+	switch {
+	case len(deployed) == 0:
+		check.Message = "deployed application package has no certificates in security/clients.pem"
+		check.Hints = []string{hint}
+	case !slices.Contains(deployed, fingerprint):
+		check.Message = fmt.Sprintf("certificate %s is not among the %d certificates in security/clients.pem of the deployed application package", fingerprint, len(deployed))
+		check.Hints = []string{hint, "Run 'vespa diff' to compare the local and deployed application packages"}
+	default:
+		check.Passed = true
+		check.Message = fmt.Sprintf("certificate %s is in security/clients.pem of the deployed application package", fingerprint)
+	}
+	return check
+}
+
+// checkEndpoint sends a request to service, and reports at which layer it was rejected, if any.
+func (c *CLI) checkEndpoint(service *vespa.Service, authMethod string) authCheck {
+	check := authCheck{Name: "endpoint"}
+	url := strings.TrimRight(service.BaseURL, "/") + "/"
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	if authMethod == "token" {
+		if err := c.addBearerToken(&request.Header); err != nil {
+			check.Message = err.Error()
+			return check
+		}
+	}
+	response, err := service.Do(request, 10*time.Second)
+	if err != nil {
+		var (
+			authErr        vespa.AuthError
+			verifyErr      *tls.CertificateVerificationError
+			unknownAuthErr x509.UnknownAuthorityError
+			hostnameErr    x509.HostnameError
+			recordErr      tls.RecordHeaderError
+			netErr         net.Error
+		)
+		switch {
+		case errors.As(err, &authErr):
+			check.Message = "certificate was rejected by " + url + ": " + err.Error()
+			check.Hints = []string{"The certificate must be in security/clients.pem of the deployed application package", "Run 'vespa auth cert' and deploy the application, if the deployed certificate check failed"}
+		case errors.As(err, &verifyErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr), errors.As(err, &recordErr):
+			check.Message = "TLS handshake with " + url + " failed: " + err.Error()
+			check.Hints = []string{"Check that the endpoint URL is correct", "If the endpoint uses a custom CA certificate, set VESPA_CLI_DATA_PLANE_CA_CERT_FILE"}
+		case errors.As(err, &netErr):
+			check.Message = "could not connect to " + url + ": " + err.Error()
+			check.Hints = []string{"Check that the endpoint URL is correct, and that the deployment is ready, e.g. with 'vespa status'"}
+		default:
+			check.Message = "request to " + url + " failed: " + err.Error()
+		}
+		return check
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	switch response.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		check.Message = fmt.Sprintf("request to %s was rejected with status %d", url, response.StatusCode)
+		if authMethod == "token" {
+			check.Hints = []string{"Check that the token is valid and has not expired",
+				"The token must be granted access to the endpoint, by the clients element of services.xml"}
+		} else {
+			check.Hints = []string{"The certificate was accepted, but does not grant access to this endpoint",
+				"Check the clients element of services.xml, and whether the endpoint requires token authentication"}
+		}
+	default:
+		check.Passed = true
+		check.Message = fmt.Sprintf("request to %s was authenticated (status %d)", url, response.StatusCode)
+	}
+	return check
+}
+
+func printAuthChecks(cli *CLI, target vespa.Target, authMethod string, checks []authCheck) {
+	fmt.Fprintf(cli.Stdout, "Checking %s authentication to %s\n", color.CyanString(authMethod), target.Deployment())
+	for _, check := range checks {
+		status := color.GreenString("pass")
+		switch {
+		case check.Skipped:
+			status = color.YellowString("skip")
+		case !check.Passed:
+			status = color.RedString("fail")
+		}
+		fmt.Fprintf(cli.Stdout, "%s %s: %s\n", status, check.Name, check.Message)
+		for _, hint := range check.Hints {
+			fmt.Fprintf(cli.Stdout, "     %s %s\n", color.CyanString("Hint:"), hint)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// feedBlockWarnUtilization is the utilization of a resource limit above which a content cluster is close to blocking
+// feed.
+const feedBlockWarnUtilization = 0.8
+
+type resourceUsageJSON struct {
+	Usage       float64 `json:"usage"`
+	Limit       float64 `json:"limit"`
+	Utilization float64 `json:"utilization"`
+}
+
+type feedBlockJSON struct {
+	Cluster         string             `json:"cluster"`
+	FeedBlocked     bool               `json:"feedBlocked"`
+	NodesAboveLimit int                `json:"nodesAboveLimit"`
+	Disk            *resourceUsageJSON `json:"disk,omitempty"`
+	Memory          *resourceUsageJSON `json:"memory,omitempty"`
+}
+
+func newFeedBlockJSON(b vespa.FeedBlock) feedBlockJSON {
+	fb := feedBlockJSON{Cluster: b.Cluster, FeedBlocked: b.Blocked(), NodesAboveLimit: b.NodesAboveLimit}
+	if b.Reported {
+		fb.Disk = &resourceUsageJSON{Usage: b.Disk.Usage(), Limit: b.Disk.Limit, Utilization: b.Disk.Utilization}
+		fb.Memory = &resourceUsageJSON{Usage: b.Memory.Usage(), Limit: b.Memory.Limit, Utilization: b.Memory.Utilization}
+	}
+	return fb
+}
+
+// readFeedBlocks returns the feed block state of the content clusters of target t. This is read from the cluster
+// controllers, which are reachable only for local and custom targets, so nothing is returned for other targets.
+func readFeedBlocks(t vespa.Target) ([]vespa.FeedBlock, error) {
+	ft, ok := t.(vespa.FeedBlockTarget)
+	if !ok {
+		return nil, nil
+	}
+	return ft.FeedBlocks()
+}
+
+// printFeedBlocks prints whether each content cluster accepts feed, and its resource usage.
+func printFeedBlocks(cli *CLI, blocks []vespa.FeedBlock) {
+	for _, b := range blocks {
+		var state string
+		switch {
+		case !b.Reported:
+			state = "of unknown feed state, as its resource usage is not reported by any cluster controller"
+		case b.Blocked():
+			state = color.RedString("blocking feed")
+		case max(b.Disk.Utilization, b.Memory.Utilization) > feedBlockWarnUtilization:
+			state = color.YellowString("close to blocking feed")
+		default:
+			state = color.GreenString("accepting feed")
+		}
+		line := fmt.Sprintf("Content cluster %s is %s", color.CyanString(b.Cluster), state)
+		if b.Reported {
+			line += fmt.Sprintf(" (disk %s, memory %s)", describeResourceUsage(b.Disk), describeResourceUsage(b.Memory))
+		}
+		fmt.Fprintln(cli.Stdout, line)
+	}
+}
+
+// describeResourceUsage describes usage as its percentage used of the limit, coloured yellow when close to the limit,
+// and red when above it.
+func describeResourceUsage(usage vespa.ResourceUsage) string {
+	s := fmt.Sprintf("%s used of %s limit", percent(usage.Usage()), percent(usage.Limit))
+	switch {
+	case usage.Utilization >= 1:
+		return color.RedString(s)
+	case usage.Utilization > feedBlockWarnUtilization:
+		return color.YellowString(s)
+	}
+	return s
+}
+
+func percent(fraction float64) string { return fmt.Sprintf("%.1f%%", fraction*100) }
+
+// feedBlockedErr returns an error naming the content clusters of blocks which block feed, if any.
+func feedBlockedErr(blocks []vespa.FeedBlock, hints ...string) error {
+	var blocked []string
+	for _, b := range blocks {
+		if b.Blocked() {
+			blocked = append(blocked, b.Cluster+" ("+describeFeedBlock(b)+")")
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+	return errCode(codeFeedBlocked, fmt.Errorf("feed is blocked in content cluster %s", strings.Join(blocked, ", ")), hints...)
+}
+
+// describeFeedBlock describes why feed is blocked in a content cluster.
+func describeFeedBlock(b vespa.FeedBlock) string {
+	var reasons []string
+	for _, r := range []struct {
+		name  string
+		usage vespa.ResourceUsage
+	}{{"disk", b.Disk}, {"memory", b.Memory}} {
+		if r.usage.Utilization >= 1 {
+			reasons = append(reasons, fmt.Sprintf("%s usage %s is above the limit of %s", r.name, percent(r.usage.Usage()), percent(r.usage.Limit)))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("%d content nodes are above a resource limit", b.NodesAboveLimit)
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// checkFeedBlock returns an error if feed is blocked in the given content cluster of target t, or in any content
+// cluster if none is given, and warns about clusters which are close to blocking feed. Nothing is checked if the
+// state cannot be read.
+func checkFeedBlock(cli *CLI, t vespa.Target, cluster string) error {
+	blocks, err := readFeedBlocks(t)
+	if err != nil {
+		return nil
+	}
+	var checked []vespa.FeedBlock
+	for _, b := range blocks {
+		if cluster != "" && b.Cluster != cluster {
+			continue
+		}
+		checked = append(checked, b)
+		if !b.Blocked() && max(b.Disk.Utilization, b.Memory.Utilization) > feedBlockWarnUtilization {
+			cli.printWarning(fmt.Sprintf("Content cluster %s is close to blocking feed: disk %s, memory %s", b.Cluster,
+				describeResourceUsage(b.Disk), describeResourceUsage(b.Memory)), "Feed is blocked when a content node uses more than the limit of a resource")
+		}
+	}
+	return feedBlockedErr(checked, "Add content nodes or resources, or remove documents, to bring usage below the limits, see https://docs.vespa.ai/en/operations/feed-block.html",
+		"Run 'vespa status' to see the resource usage of each content cluster")
+}
@@ -0,0 +1,173 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+type redistributionJSON struct {
+	Cluster        string           `json:"cluster"`
+	State          string           `json:"state"`
+	Done           bool             `json:"done"`
+	Distributors   int              `json:"distributors"`
+	PendingBuckets int64            `json:"pendingBuckets"`
+	PendingMerges  int64            `json:"pendingMerges"`
+	Counters       map[string]int64 `json:"counters"`
+}
+
+func newStatusRedistributionCmd(cli *CLI) *cobra.Command {
+	var (
+		waitSecs int
+		format   string
+		noDetect bool
+	)
+	cmd := &cobra.Command{
+		Use:   "redistribution",
+		Short: "Show status of bucket redistribution in a content cluster",
+		Long: `Show status of bucket redistribution in a content cluster.
+
+After nodes are added to, or removed from, a content cluster, its buckets are
+redistributed among the nodes, and queries may return partial coverage until
+this completes. This command shows whether redistribution in the content
+cluster given by --cluster is done. The cluster may be omitted if the
+deployment has a single content cluster.
+
+The state of the cluster is read from its cluster controllers, and the number
+of buckets with too few or too many replicas, and of pending merges, from the
+metrics of its distributors. Redistribution is done when the cluster is up,
+and none of these remain. With --wait, the command waits for redistribution to
+complete, printing the remaining buckets and merges, and the estimated time of
+completion, as they change. The command fails if redistribution is not done.
+
+The cluster controllers and distributors are reached on the ports they listen
+on, so this command requires a local or custom target from which these are
+accessible, and fails before contacting a cloud target. With --format json, the raw counters of the distributor metrics
+are included in the output.`,
+		Example: `$ vespa status redistribution
+$ vespa status redistribution --cluster music
+$ vespa status redistribution --cluster music --wait 30m
+$ vespa status redistribution --cluster music --format json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			targetType, err := cli.targetType(anyTarget)
+			if err != nil {
+				return err
+			}
+			if targetType.name != vespa.TargetLocal && targetType.name != vespa.TargetCustom {
+				return errHint(fmt.Errorf("target %s does not support reading the state of redistribution", targetType.name),
+					"The cluster controllers and distributors of the content cluster must be reachable from a local or custom target")
+			}
+			t, err := cli.target(targetOptions{logLevel: "none", detectLocal: !noDetect})
+			if err != nil {
+				return err
+			}
+			rt, ok := t.(vespa.RedistributionTarget)
+			if !ok {
+				return fmt.Errorf("target %s does not support reading the state of redistribution", t.Type())
+			}
+			cluster := cli.config.cluster()
+			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
+			var progress func(vespa.Redistribution)
+			if waiter.Timeout > 0 {
+				description := "redistribution"
+				if cluster != "" {
+					description += " in content cluster " + cluster
+				}
+				cli.printInfo("Waiting up to ", color.CyanString(waiter.Timeout.String()), " for ", description, "...")
+				progress = (&redistributionPrinter{cli: cli}).report
+			}
+			r, err := rt.AwaitRedistribution(cluster, waiter.Timeout, progress)
+			if errors.Is(err, vespa.ErrContentCluster) {
+				return errHint(err, "The --cluster option specifies the content cluster")
+			} else if err != nil {
+				return err
+			}
+			if format == "json" {
+				if err := writeJSON(cli, redistributionJSON{
+					Cluster:        r.Cluster,
+					State:          r.State,
+					Done:           r.Done(),
+					Distributors:   r.Distributors,
+					PendingBuckets: r.PendingBuckets(),
+					PendingMerges:  r.PendingMerges(),
+					Counters:       r.Counters,
+				}); err != nil {
+					return err
+				}
+			}
+			if !r.Done() {
+				err := fmt.Errorf("redistribution in content cluster %s is not done: %s", r.Cluster, describeRedistribution(r))
+				if format == "json" {
+					return ErrCLI{Status: codeServiceNotReady.exitStatus(), quiet: true, code: codeServiceNotReady, error: err}
+				}
+				if waiter.Timeout == 0 {
+					return errCode(codeServiceNotReady, err, "Use --wait to wait for redistribution to complete, e.g. --wait 30m")
+				}
+				return errCode(codeServiceNotReady, err)
+			}
+			if format == "human" {
+				fmt.Fprintf(cli.Stdout, "Redistribution in content cluster %s is %s (%s buckets)\n",
+					color.CyanString(r.Cluster), color.GreenString("done"), formatCount(r.Counters[vespa.MetricBuckets]))
+			}
+			return nil
+		},
+	}
+	cli.bindWaitFlag(cmd, 0, &waitSecs)
+	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	bindNoDetectFlag(cmd, &noDetect)
+	return cmd
+}
+
+// describeRedistribution describes what remains of redistribution r.
+func describeRedistribution(r vespa.Redistribution) string {
+	var parts []string
+	if r.State != "up" {
+		parts = append(parts, "cluster is "+r.State)
+	}
+	parts = append(parts, fmt.Sprintf("%s buckets and %s merges pending", formatCount(r.PendingBuckets()), formatCount(r.PendingMerges())))
+	if diff := r.Counters[vespa.MetricIdealStateDiff]; diff > 0 && r.PendingBuckets() == 0 && r.PendingMerges() == 0 {
+		parts = append(parts, fmt.Sprintf("ideal state difference of %s", formatCount(diff)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// redistributionPrinter prints the progress of redistribution, and an estimate of its completion, whenever it changes.
+type redistributionPrinter struct {
+	cli *CLI
+
+	started      time.Time
+	firstPending int64
+	last         string
+}
+
+func (p *redistributionPrinter) report(r vespa.Redistribution) {
+	now := p.cli.now()
+	pending := r.PendingBuckets()
+	if p.started.IsZero() {
+		p.started = now
+		p.firstPending = pending
+	}
+	line := describeRedistribution(r)
+	if line == p.last {
+		return
+	}
+	p.last = line
+	if done := p.firstPending - pending; done > 0 && pending > 0 {
+		eta := time.Duration(float64(now.Sub(p.started)) * float64(pending) / float64(done)).Round(time.Second)
+		line += ", estimated completion in " + eta.String()
+	}
+	p.cli.printInfo(line)
+}
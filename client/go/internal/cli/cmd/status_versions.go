@@ -0,0 +1,156 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+type versionsJSON struct {
+	ConfigServerVersion string            `json:"configServerVersion,omitempty"`
+	Skew                bool              `json:"skew"`
+	Upgrading           bool              `json:"upgrading"`
+	Nodes               []nodeVersionJSON `json:"nodes"`
+}
+
+type nodeVersionJSON struct {
+	vespa.NodeVersion
+	Skew      bool `json:"skew,omitempty"`
+	Upgrading bool `json:"upgrading,omitempty"`
+}
+
+func newStatusVersionsCmd(cli *CLI) *cobra.Command {
+	var (
+		format   string
+		noDetect bool
+	)
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "Show the Vespa version of each service of the deployment",
+		Long: `Show the Vespa version of each service of the deployment.
+
+For a local or custom target, the version of each service is read from its
+state API, and compared to the version of the config server. Services running
+another version than the config server, e.g. because they have not yet been
+restarted after an upgrade, are highlighted as skewed. The services are
+reached on the ports they listen on, so these must be accessible from where
+the command runs.
+
+For Vespa Cloud, the current and wanted version of each node is read from the
+node repository, and nodes which do not yet run their wanted version are shown
+as upgrading.
+
+With --format json, the version of each service or node is printed as JSON,
+for use by other tools. The command does not fail because of skew, only if
+the versions cannot be read.`,
+		Example: `$ vespa status versions
+$ vespa status versions --format json`,
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		Args:              cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format = cli.outputFormat(cmd, format)
+			if format != "human" && format != "json" {
+				return fmt.Errorf("invalid format: %s", format)
+			}
+			t, err := cli.target(targetOptions{logLevel: "none", detectLocal: !noDetect})
+			if err != nil {
+				return err
+			}
+			vt, ok := t.(vespa.VersionTarget)
+			if !ok {
+				return fmt.Errorf("target %s does not support reading versions of services", t.Type())
+			}
+			versions, err := vt.Versions()
+			if err != nil {
+				if t.IsCloud() {
+					return errHint(err, "The node repository may not be accessible with the configured credentials")
+				}
+				return err
+			}
+			if format == "json" {
+				result := versionsJSON{ConfigServerVersion: versions.ConfigServer, Nodes: make([]nodeVersionJSON, 0, len(versions.Nodes))}
+				for _, v := range versions.Nodes {
+					node := nodeVersionJSON{NodeVersion: v, Skew: versions.Skewed(v), Upgrading: v.Upgrading()}
+					result.Skew = result.Skew || node.Skew
+					result.Upgrading = result.Upgrading || node.Upgrading
+					result.Nodes = append(result.Nodes, node)
+				}
+				return writeJSON(cli, result)
+			}
+			return printVersions(cli, t, versions)
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&format, "format", "", "human", "Output format. Must be 'human' (human-readable text) or 'json'")
+	bindNoDetectFlag(cmd, &noDetect)
+	return cmd
+}
+
+// printVersions prints a table of the versions of the services of the deployment of t, followed by a summary of any
+// skew or upgrade in progress.
+func printVersions(cli *CLI, t vespa.Target, versions vespa.Versions) error {
+	if len(versions.Nodes) == 0 {
+		cli.printInfo("No services found in deployment")
+		return nil
+	}
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	if t.IsCloud() {
+		upgrading := 0
+		fmt.Fprintln(w, "HOST\tCLUSTER\tCURRENT VERSION\tWANTED VERSION\tUPGRADING")
+		for _, v := range versions.Nodes {
+			state := "no"
+			if v.Upgrading() {
+				state = color.YellowString("yes")
+				upgrading++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.Host, orDash(strings.Trim(v.Type+"/"+v.Cluster, "/")), orDash(v.Version), orDash(v.WantedVersion), state)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if upgrading > 0 {
+			cli.printInfo(fmt.Sprintf("Upgrade in progress: %d of %d nodes do not yet run their wanted version", upgrading, len(versions.Nodes)))
+		}
+		return nil
+	}
+	fmt.Fprintf(cli.Stdout, "Config server runs Vespa %s\n", color.CyanString(versions.ConfigServer))
+	skewed, failed := 0, 0
+	fmt.Fprintln(w, "HOST\tSERVICE\tVERSION")
+	for _, v := range versions.Nodes {
+		version := orDash(v.Version)
+		if versions.Skewed(v) {
+			version = color.YellowString(v.Version + " (skew)")
+			skewed++
+		}
+		if v.Error != "" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s:%d\t%s\t%s\n", v.Host, v.Port, orDash(strings.Trim(v.Cluster+"/"+v.Type, "/")), version)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, v := range versions.Nodes {
+		if v.Error != "" {
+			cli.printWarning(fmt.Sprintf("Could not read version of %s on %s:%d: %s", v.Type, v.Host, v.Port, v.Error))
+		}
+	}
+	if skewed > 0 {
+		cli.printWarning(fmt.Sprintf("%d of %d services run another Vespa version than the config server", skewed, len(versions.Nodes)),
+			"Services run the new version when they are restarted after an upgrade")
+	} else if failed == 0 {
+		cli.printSuccess("All services run Vespa ", color.CyanString(versions.ConfigServer))
+	}
+	return nil
+}
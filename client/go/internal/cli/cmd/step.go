@@ -0,0 +1,176 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Reporting of the steps of multi-step commands
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/briandowns/spinner"
+	"github.com/fatih/color"
+)
+
+// stepInterval is the time between updates of the spinner of a step, in a terminal.
+const stepInterval = 100 * time.Millisecond
+
+// stepResult is the outcome of a step of a command, as included in its JSON result.
+type stepResult struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"` // done or failed
+	Duration float64 `json:"durationSeconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// stepError is the error of a failed step, naming the step.
+type stepError struct {
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string { return lowerFirst(e.step) + " failed: " + e.err.Error() }
+
+func (e *stepError) Unwrap() error { return e.err }
+
+// progressLine is a line showing the status of a running command, such as a spinner, which can show the progress of an
+// upload after it.
+type progressLine interface {
+	setProgress(progress string)
+}
+
+// spinnerLine shows progress after a spinner.
+type spinnerLine struct{ s *spinner.Spinner }
+
+func (l spinnerLine) setProgress(progress string) {
+	l.s.Lock()
+	l.s.Suffix = progress
+	l.s.Unlock()
+}
+
+// step runs fn as a step of the running command, described by message, e.g. "Uploading application package". In a
+// terminal, a spinner and the time elapsed are shown after message while fn runs. Otherwise, a line is printed when the
+// step starts, and another when it completes, with its duration. Nothing is printed when quiet, or printing JSON.
+// The outcome of each step is recorded in c.steps, for the JSON result of the command. An error returned by fn is
+// returned with the step named in its message.
+func (c *CLI) step(message string, fn func() error) error {
+	_, screwdriver := c.Environment["SCREWDRIVER"]
+	silent := c.config.isQuiet() || c.jsonOutput()
+	terminal := !silent && c.isTerminal() && !screwdriver
+	started := c.now()
+	var err error
+	switch {
+	case silent:
+		err = fn()
+	case terminal:
+		err = c.spinStep(message, started, fn)
+	default:
+		fmt.Fprintf(c.Stderr, "%s ...\n", message)
+		err = fn()
+	}
+	elapsed := c.now().Sub(started)
+	result := stepResult{Name: message, Status: "done", Duration: elapsed.Round(time.Millisecond).Seconds()}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	c.steps = append(c.steps, result)
+	if !silent && !terminal {
+		fmt.Fprintf(c.Stderr, "%s ... %s (%s)\n", message, result.Status, formatStepDuration(elapsed))
+	}
+	if err != nil {
+		return withStep(message, err)
+	}
+	return nil
+}
+
+// spinStep shows a spinner line for the step described by message, which started at started, while running fn.
+func (c *CLI) spinStep(message string, started time.Time, fn func() error) error {
+	line := &stepSpinner{w: c.Stderr, message: message, started: started, now: c.now, stop: make(chan struct{}), stopped: make(chan struct{})}
+	line.start()
+	c.activeSpinner = line
+	err := fn()
+	c.activeSpinner = nil
+	line.finish(err)
+	return err
+}
+
+// withStep returns err with the step described by message named in its message. The exit status, hints and code of
+// a CLI error are kept.
+func withStep(message string, err error) error {
+	if cliErr, ok := err.(ErrCLI); ok {
+		cliErr.error = &stepError{step: message, err: cliErr.error}
+		return cliErr
+	}
+	return &stepError{step: message, err: err}
+}
+
+// stepSpinner draws a spinner, the time elapsed and the progress of any upload after the message of a step.
+type stepSpinner struct {
+	w       io.Writer
+	message string
+	started time.Time
+	now     func() time.Time
+
+	mu       sync.Mutex
+	frame    int
+	progress string
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func (s *stepSpinner) start() {
+	s.draw()
+	go func() {
+		defer close(s.stopped)
+		ticker := time.NewTicker(stepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.draw()
+			}
+		}
+	}()
+}
+
+func (s *stepSpinner) draw() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chars := spinner.CharSets[11]
+	frame := color.New(color.FgBlue, color.Bold).Sprint(chars[s.frame%len(chars)])
+	s.frame++
+	elapsed := s.now().Sub(s.started).Truncate(time.Second)
+	fmt.Fprintf(s.w, "\r\x1b[K%s %s %s%s", s.message, frame, elapsed, s.progress)
+}
+
+func (s *stepSpinner) setProgress(progress string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress = progress
+}
+
+// finish stops the spinner, and replaces its line with the outcome of the step.
+func (s *stepSpinner) finish(err error) {
+	close(s.stop)
+	<-s.stopped
+	status := "done"
+	if err != nil {
+		status = "failed"
+	}
+	fmt.Fprintf(s.w, "\r\x1b[K%s ... %s (%s)\n", s.message, status, formatStepDuration(s.now().Sub(s.started)))
+}
+
+// formatStepDuration formats the duration of a step in seconds, with one decimal.
+func formatStepDuration(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
@@ -0,0 +1,116 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stepLines returns the lines printed for a step described by message, which completes quickly with status, when not
+// writing to a terminal.
+func stepLines(message, status string) string {
+	return message + " ...\n" + message + " ... " + status + " (0.0s)\n"
+}
+
+// stepClock is a clock which only advances when told to.
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestStep(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	clock := &stepClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cli.now = clock.Now
+
+	// Plain lines are printed when not in a terminal
+	require.Nil(t, cli.step("Uploading application package", func() error {
+		clock.advance(3200 * time.Millisecond)
+		return nil
+	}))
+	assert.Equal(t, "Uploading application package ...\nUploading application package ... done (3.2s)\n", stderr.String())
+
+	// Failures name the step, and keep the status and hints of the error
+	stderr.Reset()
+	err := cli.step("Activating application package", func() error {
+		clock.advance(time.Second)
+		return errCode(codeServerError, errors.New("status 500"), "Try again")
+	})
+	require.NotNil(t, err)
+	cliErr, ok := err.(ErrCLI)
+	require.True(t, ok)
+	assert.Equal(t, "activating application package failed: status 500", cliErr.Error())
+	assert.Equal(t, exitTransient, cliErr.Status)
+	assert.Equal(t, []string{"Try again"}, cliErr.hints)
+	assert.Equal(t, "Activating application package ...\nActivating application package ... failed (1.0s)\n", stderr.String())
+
+	cause := errors.New("disk full")
+	err = cli.step("Writing certificate", func() error { return cause })
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "writing certificate failed: disk full", err.Error())
+
+	assert.Equal(t, []stepResult{
+		{Name: "Uploading application package", Status: "done", Duration: 3.2},
+		{Name: "Activating application package", Status: "failed", Duration: 1, Error: "status 500"},
+		{Name: "Writing certificate", Status: "failed", Duration: 0, Error: "disk full"},
+	}, cli.steps)
+}
+
+func TestStepTerminal(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	cli.isTerminal = func() bool { return true }
+	clock := &stepClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cli.now = clock.Now
+
+	// A spinner is drawn, and replaced by the outcome of the step
+	require.Nil(t, cli.step("Uploading application package", func() error {
+		cli.activeSpinner.setProgress(" [=====] 50%")
+		clock.advance(1500 * time.Millisecond)
+		return nil
+	}))
+	out := stderr.String()
+	assert.True(t, strings.HasPrefix(out, "\r\x1b[KUploading application package "), out)
+	assert.True(t, strings.HasSuffix(out, "\r\x1b[KUploading application package ... done (1.5s)\n"), out)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+	assert.Nil(t, cli.activeSpinner)
+
+	stderr.Reset()
+	require.NotNil(t, cli.step("Activating application package", func() error { return errors.New("failed") }))
+	assert.True(t, strings.HasSuffix(stderr.String(), "\r\x1b[KActivating application package ... failed (0.0s)\n"), stderr.String())
+}
+
+func TestStepQuietAndJSON(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	cli.isTerminal = func() bool { return true }
+
+	// Steps are only recorded when printing JSON
+	require.Nil(t, cli.Run("config", "set", "output", "json"))
+	require.Nil(t, cli.step("Uploading application package", func() error { return nil }))
+	assert.Equal(t, "", stderr.String())
+	require.Len(t, cli.steps, 1)
+	assert.Equal(t, "done", cli.steps[0].Status)
+
+	require.Nil(t, cli.Run("config", "set", "output", "human"))
+	require.Nil(t, cli.Run("config", "set", "quiet", "true"))
+	stderr.Reset()
+	require.NotNil(t, cli.step("Uploading application package", func() error { return errors.New("failed") }))
+	assert.Equal(t, "", stderr.String())
+}
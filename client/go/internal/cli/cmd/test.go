@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -15,8 +16,11 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -27,7 +31,20 @@ import (
 )
 
 func newTestCmd(cli *CLI) *cobra.Command {
-	var waitSecs int
+	var (
+		waitSecs     int
+		reports      []string
+		parallel     int
+		params       []string
+		paramFile    string
+		skipTeardown bool
+		record       bool
+		recordIgnore []string
+		force        bool
+		coverage     bool
+		coverageFmt  string
+		headers      []string
+	)
 	testCmd := &cobra.Command{
 		Use:   "test test-directory-or-file",
 		Short: "Run a test suite, or a single test",
@@ -35,86 +52,434 @@ func newTestCmd(cli *CLI) *cobra.Command {
 
 Runs all JSON test files in the specified directory, or the single JSON test file specified.
 
+A test directory may contain a setup.json and a teardown.json, with steps like
+any other test, which are run once before and once after the other tests. If
+setup fails, no tests are run. Teardown is run even if tests fail, or the run
+is interrupted, unless --skip-teardown is given.
+
+A step which reads data may be retried until it passes, e.g. to wait for fed
+documents to become searchable, by giving it a retry, like
+"retry": {"timeout": "30s", "interval": "1s"}. Only GET requests and POST
+queries to /search/ may be retried. The summary shows the total duration of
+the run, including the time spent retrying.
+
+Use --report to write a report of the test run for CI systems, in JUnit XML
+(junit) or JSON (json) format. The report has one test case per step of each
+test, with its duration, and the failure message, request and response for
+failed steps. Steps following a failed step are reported as skipped. The flag
+may be repeated to write several reports.
+
+Use --parallel to run the test files of a suite in parallel. The steps of each
+test are still run in order, and the output of each test is printed when it
+completes. Tests with an "order" run first, one at a time, by ascending order,
+e.g. to set up data used by the other tests.
+
+String values in tests may contain placeholders, which are replaced before the
+test is run: {{ env.NAME }} is replaced by the value of environment variable
+NAME, and {{ param.name }} by the value of parameter name, set with --param or
+--param-file. A test using an undefined variable fails.
+
+Use --header to add a header to every request to Vespa, e.g. one required by
+a gateway in front of the application. Headers of the data-plane-headers option
+are added too, see 'vespa help config'. Headers given in the request of a step
+take precedence, and requests to external endpoints get no added headers.
+
+Use --record to record the actual response to each step as its expected
+response, e.g. to create the expectations of a new test, or to update them
+after an intended change. The code and body of the response clause of each
+step are replaced, and bodies read from a file are written to that file. Each
+request must get a response for the step to be recorded, but the steps pass
+regardless of their expectations. Members of response bodies which change
+between runs are left out of the recorded bodies, by JSON pointers given with
+--record-ignore, where * matches any member or element. A unified diff of each
+changed file is printed, so the changes can be reviewed. Placeholders in
+recorded clauses are replaced by their values. Recording against a production
+deployment requires --force. Use 'vespa test generate' to generate a test
+suite from a log of queries, with their recorded responses.
+
+Use --coverage to print which parts of the deployed application the run
+exercised, compared to its application package: the document types fed with
+document/v1 requests or searched by queries, the handlers of services.xml hit
+by requests, and the rank profiles of the schemas referenced by queries.
+Items which no request covered are listed at the end. Requests to external
+endpoints are not counted. The coverage is printed as a table, or as JSON with
+--coverage-format json.
+
+The code of a response clause may be a class of status codes, like "2xx". Its
+headers member lists expected response headers, by case-insensitive name. A
+value in the body or headers of a response clause may be an object of
+operators instead of a literal value:
+
+  {"$gt": 1}, {"$gte": 1}, {"$lt": 1}, {"$lte": 1} compare numbers,
+  {"$regex": "^id:"} matches strings against a regular expression,
+  {"$exists": false} requires the member to be absent (or present, if true),
+  {"$length": 10} or {"$length": {"$gte": 1}} checks the number of characters
+  of a string, elements of an array, or members of an object.
+
+For example, "response": {"code": "2xx", "headers": {"content-type":
+{"$regex": "json"}}, "body": {"root": {"fields": {"totalCount": {"$gte": 1}},
+"children": {"$length": {"$lte": 10}}}}}. Several operators in one object must
+all match. A failure shows the path of the value, the operator, and the
+expected and actual value.
+
 See https://docs.vespa.ai/en/reference/testing.html for details.`,
 		Example: `$ vespa test src/test/application/tests/system-test
-$ vespa test src/test/application/tests/system-test/feed-and-query.json`,
+$ vespa test src/test/application/tests/system-test/feed-and-query.json
+$ vespa test src/test/application/tests/system-test --report junit=target/test-report.xml --report json=report.json
+$ vespa test src/test/application/tests/system-test/feed-and-query.json --record
+$ vespa test src/test/application/tests/system-test --record --record-ignore /timing --record-ignore /root/children/*/relevance
+$ vespa test src/test/application/tests/system-test --coverage --coverage-format json`,
 		Args:              cobra.ExactArgs(1),
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
-			count, failed, err := runTests(cli, args[0], false, waiter)
+			// Tests verify the responses they get, and may retry steps themselves
+			cli.disableRetries()
+			if parallel < 1 {
+				return fmt.Errorf("invalid parallel: %d: must be at least 1", parallel)
+			}
+			outputs, err := parseTestReports(reports)
+			if err != nil {
+				return err
+			}
+			variables, err := newTestVariables(cli.Environment, params, paramFile)
 			if err != nil {
 				return err
 			}
-			if len(failed) != 0 {
-				plural := "s"
-				if count == 1 {
-					plural = ""
+			// Tests authenticate with the data plane certificate only
+			header, err := cli.dataPlaneHeader(headers, "mtls")
+			if err != nil {
+				return err
+			}
+			var recorder *testRecorder
+			if record {
+				if recorder, err = newTestRecorder(recordIgnore); err != nil {
+					return err
+				}
+				if err := checkRecordTarget(cli, force); err != nil {
+					return err
 				}
-				fmt.Fprintf(cli.Stdout, "\n%s %d of %d test%s failed:\n", color.RedString("Failure:"), len(failed), count, plural)
-				for _, test := range failed {
+			}
+			if coverageFmt != "table" && coverageFmt != "json" {
+				return fmt.Errorf("invalid coverage format: %s: must be 'table' or 'json'", coverageFmt)
+			}
+			var runCoverage *testCoverage
+			if coverage {
+				runCoverage = newTestCoverage()
+			}
+			var report *testReport
+			if len(outputs) > 0 {
+				report = &testReport{}
+			}
+			waiter := cli.waiter(time.Duration(waitSecs)*time.Second, cmd)
+			start := cli.now()
+			summary, err := runTests(cli, args[0], testOptions{waiter: waiter, report: report, parallel: parallel, variables: variables, skipTeardown: skipTeardown, recorder: recorder, coverage: runCoverage, header: header})
+			if report != nil {
+				if reportErr := report.write(outputs); reportErr != nil && err == nil {
+					err = reportErr
+				}
+			}
+			if err != nil {
+				return err
+			}
+			plural := "s"
+			if summary.count == 1 {
+				plural = ""
+			}
+			outcome := "OK"
+			if recorder != nil {
+				outcome = "recorded"
+			}
+			duration := cli.now().Sub(start).Round(time.Second)
+			if summary.setupFailure != "" {
+				fmt.Fprintf(cli.Stdout, "\n%s setup failed, so no tests were run:\n%s\n", color.RedString("Failure:"), summary.setupFailure)
+			} else if len(summary.failed) != 0 {
+				fmt.Fprintf(cli.Stdout, "\n%s %d of %d test%s failed in %s:\n", color.RedString("Failure:"), len(summary.failed), summary.count, plural, duration)
+				for _, test := range summary.failed {
 					fmt.Fprintln(cli.Stdout, test)
 				}
-				return ErrCLI{Status: 3, error: fmt.Errorf("tests failed"), quiet: true}
 			} else {
-				plural := "s"
-				if count == 1 {
-					plural = ""
+				fmt.Fprintf(cli.Stdout, "\n%s %d test%s %s in %s\n", color.GreenString("Success:"), summary.count, plural, outcome, duration)
+			}
+			if summary.teardownFailure != "" {
+				fmt.Fprintf(cli.Stdout, "%s teardown failed:\n%s\n", color.RedString("Failure:"), summary.teardownFailure)
+			}
+			if runCoverage != nil {
+				if cli.jsonOutput() && !cmd.Flags().Changed("coverage-format") {
+					coverageFmt = "json"
+				}
+				fetch := func() (vespa.Inventory, error) {
+					target, err := cli.target(targetOptions{})
+					if err != nil {
+						return vespa.Inventory{}, err
+					}
+					return vespa.FetchInventory(target)
+				}
+				if err := printCoverage(cli, runCoverage, fetch, coverageFmt); err != nil {
+					return err
 				}
-				fmt.Fprintf(cli.Stdout, "\n%s %d test%s OK\n", color.GreenString("Success:"), count, plural)
-				return nil
 			}
+			if !summary.ok() {
+				return ErrCLI{Status: exitTestsFailed, error: fmt.Errorf("tests failed"), quiet: true}
+			}
+			return nil
 		},
 	}
 	cli.bindWaitFlag(testCmd, 0, &waitSecs)
+	testCmd.Flags().StringArrayVar(&reports, "report", nil, "Write a report of the test run, on the form format=path, where format is 'junit' or 'json'. May be repeated")
+	testCmd.Flags().IntVar(&parallel, "parallel", 1, "Number of test files to run in parallel when running a test suite")
+	testCmd.Flags().StringArrayVar(&params, "param", nil, "Set a parameter used in tests, on the form name=value. May be repeated")
+	testCmd.Flags().BoolVar(&skipTeardown, "skip-teardown", false, "Skip the teardown of a test suite, e.g. to inspect the state it leaves")
+	testCmd.Flags().StringVar(&paramFile, "param-file", "", "Read parameters used in tests from this JSON file. Parameters set with --param take precedence")
+	testCmd.Flags().BoolVar(&record, "record", false, "Record the actual responses as the expected responses of the steps, and print the changes")
+	testCmd.Flags().StringArrayVar(&recordIgnore, "record-ignore", defaultRecordIgnore, "JSON pointer to a member of response bodies which is not recorded. May be repeated")
+	testCmd.Flags().BoolVar(&force, "force", false, "Allow --record against a production deployment")
+	testCmd.Flags().BoolVar(&coverage, "coverage", false, "Print which document types, handlers and rank profiles of the deployed application the run exercised")
+	testCmd.Flags().StringVar(&coverageFmt, "coverage-format", "table", "Format of the coverage printed by --coverage. Must be 'table' or 'json'")
+	testCmd.Flags().StringSliceVarP(&headers, "header", "", nil, "Add a header to all requests to Vespa, on the format 'Header: Value'. This can be specified multiple times")
+	testCmd.AddCommand(newTestGenerateCmd(cli))
 	return testCmd
 }
 
-func runTests(cli *CLI, rootPath string, dryRun bool, waiter *Waiter) (int, []string, error) {
-	count := 0
-	failed := make([]string, 0)
+// checkRecordTarget returns an error if responses would be recorded from a production deployment, unless force is true.
+func checkRecordTarget(cli *CLI, force bool) error {
+	if force {
+		return nil
+	}
+	target, err := cli.target(targetOptions{})
+	if err != nil {
+		return err
+	}
+	if target.IsCloud() && target.Deployment().Zone.Environment == "prod" {
+		return errHint(fmt.Errorf("refusing to record responses from production %s", target.Deployment()), "Record against a dev or perf deployment instead", "Use --force to record anyway")
+	}
+	return nil
+}
+
+// testOptions are the options for running tests.
+type testOptions struct {
+	dryRun    bool
+	waiter    *Waiter
+	report    *testReport
+	parallel  int
+	variables *testVariables
+	// skipTeardown is whether to skip the teardown of a test suite
+	skipTeardown bool
+	// recorder records the responses as the expectations of the tests, or nil if these are verified
+	recorder *testRecorder
+	// coverage records what the requests of the tests exercise, or nil if this is not wanted
+	coverage *testCoverage
+	// header holds the headers added to requests to Vespa
+	header http.Header
+}
+
+// testSummary is the outcome of running a test suite, or a single test.
+type testSummary struct {
+	count  int
+	failed []string
+	// setupFailure and teardownFailure are the failures of the setup and teardown of a test suite, if any
+	setupFailure    string
+	teardownFailure string
+}
+
+func (s testSummary) ok() bool {
+	return len(s.failed) == 0 && s.setupFailure == "" && s.teardownFailure == ""
+}
+
+func runTests(cli *CLI, rootPath string, options testOptions) (testSummary, error) {
+	summary := testSummary{failed: make([]string, 0)}
 	if stat, err := os.Stat(rootPath); err != nil {
-		return 0, nil, errHint(err, "See https://docs.vespa.ai/en/reference/testing")
+		return testSummary{}, errHint(err, "See https://docs.vespa.ai/en/reference/testing")
 	} else if stat.IsDir() {
 		tests, err := os.ReadDir(rootPath)
 		if err != nil {
-			return 0, nil, errHint(err, "See https://docs.vespa.ai/en/reference/testing")
+			return testSummary{}, errHint(err, "See https://docs.vespa.ai/en/reference/testing")
 		}
-		context := testContext{testsPath: rootPath, dryRun: dryRun, cli: cli, clusters: map[string]*vespa.Service{}}
-		previousFailed := false
+		var testPaths []string
+		var setupPath, teardownPath string
 		for _, test := range tests {
 			if !test.IsDir() && filepath.Ext(test.Name()) == ".json" {
 				testPath := filepath.Join(rootPath, test.Name())
-				if previousFailed {
-					fmt.Fprintln(cli.Stdout, "")
-					previousFailed = false
+				switch test.Name() {
+				case "setup.json":
+					setupPath = testPath
+				case "teardown.json":
+					teardownPath = testPath
+				default:
+					testPaths = append(testPaths, testPath)
 				}
-				failure, err := runTest(testPath, context, waiter)
-				if err != nil {
-					return 0, nil, err
-				}
-				if failure != "" {
-					failed = append(failed, failure)
-					previousFailed = true
+			}
+		}
+		if len(testPaths) > 0 {
+			runner := testRunner{context: newTestContext(cli, rootPath, options), waiter: options.waiter}
+			if !options.dryRun {
+				defer runner.stopOnInterrupt()()
+			}
+			if setupPath != "" {
+				summary.setupFailure, err = runner.run(setupPath, false)
+			}
+			if err == nil && summary.setupFailure == "" {
+				var failures []string
+				failures, err = runner.runAll(testPaths, options.parallel)
+				summary.failed = append(summary.failed, failures...)
+			}
+			if teardownPath != "" && !options.skipTeardown {
+				var teardownErr error
+				summary.teardownFailure, teardownErr = runner.run(teardownPath, false)
+				if err == nil {
+					err = teardownErr
 				}
-				count++
 			}
+			if err == nil && runner.interrupted.Load() {
+				err = fmt.Errorf("interrupted")
+			}
+			if err != nil {
+				return testSummary{}, err
+			}
+			summary.count = len(testPaths)
 		}
 	} else if strings.HasSuffix(stat.Name(), ".json") {
-		failure, err := runTest(rootPath, testContext{testsPath: filepath.Dir(rootPath), dryRun: dryRun, cli: cli, clusters: map[string]*vespa.Service{}}, waiter)
+		failure, err := runTest(rootPath, newTestContext(cli, filepath.Dir(rootPath), options), options.waiter)
+		if err != nil {
+			return testSummary{}, err
+		}
+		if failure != "" {
+			summary.failed = append(summary.failed, failure)
+		}
+		summary.count++
+	}
+	if summary.count == 0 {
+		return testSummary{}, errHint(fmt.Errorf("failed to find any tests at %s", rootPath), "See https://docs.vespa.ai/en/reference/testing")
+	}
+	return summary, nil
+}
+
+// testRunner runs the test files of a directory, printing a blank line after the output of each failed test.
+type testRunner struct {
+	context        testContext
+	waiter         *Waiter
+	mu             sync.Mutex
+	previousFailed bool
+	// stopped is set when no more tests should be started, because one failed with an error, or the run was interrupted
+	stopped     atomic.Bool
+	interrupted atomic.Bool
+}
+
+// stopOnInterrupt makes this stop starting tests when interrupted, such that the teardown of the suite can run. A
+// second interrupt stops the CLI as usual. The returned function releases the interrupt handler.
+func (r *testRunner) stopOnInterrupt() func() {
+	return r.context.cli.onInterrupt(func() {
+		r.interrupted.Store(true)
+		r.stopped.Store(true)
+	})
+}
+
+// runAll runs the tests at testPaths, and returns the failures in the order the tests are run. Tests with an order run
+// first, one at a time, by ascending order. The other tests are then run by parallel workers, with their output
+// buffered and printed as each test completes.
+func (r *testRunner) runAll(testPaths []string, parallel int) ([]string, error) {
+	var ordered, unordered []string
+	orders := make(map[string]int)
+	for _, testPath := range testPaths {
+		if order := readTestOrder(testPath); order != nil {
+			ordered = append(ordered, testPath)
+			orders[testPath] = *order
+		} else {
+			unordered = append(unordered, testPath)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return orders[ordered[i]] < orders[ordered[j]] })
+	var failures []string
+	for _, testPath := range ordered {
+		if r.stopped.Load() {
+			break
+		}
+		failure, err := r.run(testPath, false)
 		if err != nil {
-			return 0, nil, err
+			return nil, err
 		}
 		if failure != "" {
-			failed = append(failed, failure)
+			failures = append(failures, failure)
+		}
+	}
+	results := make([]string, len(unordered))
+	errs := make([]error, len(unordered))
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for range min(max(parallel, 1), len(unordered)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if r.stopped.Load() {
+					continue
+				}
+				results[i], errs[i] = r.run(unordered[i], parallel > 1)
+				if errs[i] != nil {
+					r.stopped.Store(true)
+				}
+			}
+		}()
+	}
+	for i := range unordered {
+		if r.stopped.Load() {
+			break
 		}
-		count++
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+		if results[i] != "" {
+			failures = append(failures, results[i])
+		}
+	}
+	return failures, nil
+}
+
+// run runs the test at testPath, writing its output directly, or when it completes if buffered is true.
+func (r *testRunner) run(testPath string, buffered bool) (string, error) {
+	context := r.context
+	if !buffered {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.printSeparator()
+		failure, err := runTest(testPath, context, r.waiter)
+		r.previousFailed = failure != ""
+		return failure, err
+	}
+	var buf bytes.Buffer
+	context.stdout = &buf
+	failure, err := runTest(testPath, context, r.waiter)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.printSeparator()
+	r.context.stdout.Write(buf.Bytes())
+	r.previousFailed = failure != ""
+	return failure, err
+}
+
+func (r *testRunner) printSeparator() {
+	if r.previousFailed {
+		fmt.Fprintln(r.context.stdout, "")
+		r.previousFailed = false
+	}
+}
+
+// readTestOrder returns the order of the test at testPath, or nil if it has none, or cannot be read.
+func readTestOrder(testPath string) *int {
+	testBytes, err := os.ReadFile(testPath)
+	if err != nil {
+		return nil
 	}
-	if count == 0 {
-		return 0, nil, errHint(fmt.Errorf("failed to find any tests at %s", rootPath), "See https://docs.vespa.ai/en/reference/testing")
+	var test test
+	if err := json.Unmarshal(testBytes, &test); err != nil {
+		return nil
 	}
-	return count, failed, nil
+	return test.Order
 }
 
 // Runs the test at the given path, and returns the specified test name if the test fails
@@ -127,16 +492,22 @@ func runTest(testPath string, context testContext, waiter *Waiter) (string, erro
 	if err = json.Unmarshal(testBytes, &test); err != nil {
 		return "", errHint(fmt.Errorf("failed parsing test at %s: %w", testPath, err), "See https://docs.vespa.ai/en/reference/testing")
 	}
+	context.source = testSource{path: testPath, data: testBytes}
 
 	testName := test.Name
 	if test.Name == "" {
 		testName = filepath.Base(testPath)
 	}
 	if !context.dryRun {
-		fmt.Fprintf(context.cli.Stdout, "%s:", testName)
+		fmt.Fprintf(context.stdout, "%s:", testName)
 	}
 
-	defaultParameters, err := getParameters(test.Defaults.ParametersRaw, filepath.Dir(testPath))
+	defaultParameters, err := getParameters(test.Defaults.ParametersRaw, context)
+	var undefined *undefinedVariableError
+	if errors.As(err, &undefined) {
+		fmt.Fprintf(context.stdout, " %s\n%s\n", color.RedString("failed"), undefined.Error())
+		return fmt.Sprintf("%s: %s", testName, undefined.Error()), nil
+	}
 	if err != nil {
 		fmt.Fprintln(context.cli.Stderr)
 		return "", errHint(fmt.Errorf("invalid default parameters for %s: %w", testName, err), "See https://docs.vespa.ai/en/reference/testing")
@@ -146,41 +517,153 @@ func runTest(testPath string, context testContext, waiter *Waiter) (string, erro
 		fmt.Fprintln(context.cli.Stderr)
 		return "", errHint(fmt.Errorf("a test must have at least one step, but none were found in %s", testPath), "See https://docs.vespa.ai/en/reference/testing")
 	}
+	stepNames := make([]string, len(test.Steps))
+	retries := make([]stepRetry, len(test.Steps))
 	for i, step := range test.Steps {
-		stepName := fmt.Sprintf("Step %d", i+1)
+		stepNames[i] = fmt.Sprintf("Step %d", i+1)
 		if step.Name != "" {
-			stepName += ": " + step.Name
+			stepNames[i] += ": " + step.Name
 		}
-		failure, longFailure, err := verify(step, test.Defaults.Cluster, defaultParameters, context, waiter)
+		if retries[i], err = parseRetry(step); err != nil {
+			fmt.Fprintln(context.cli.Stderr)
+			return "", errHint(fmt.Errorf("invalid retry in %s of %s: %w", stepNames[i], testPath, err), "See https://docs.vespa.ai/en/reference/testing")
+		}
+	}
+	var retried []string
+	var recorded []recordedResponse
+	if context.recorder != nil && !context.dryRun {
+		recorded = make([]recordedResponse, len(test.Steps))
+	}
+	result := context.report.startFile(testName, testPath)
+	for i, step := range test.Steps {
+		stepName := stepNames[i]
+		start := context.cli.now()
+		if recorded != nil {
+			context.recorded = &recorded[i]
+		}
+		failure, longFailure, attempts, err := verifyWithRetry(step, retries[i], test.Defaults.Cluster, defaultParameters, context, waiter)
+		if attempts > 1 {
+			if failure != "" {
+				failure += fmt.Sprintf(" (after %d attempts)", attempts)
+				longFailure += fmt.Sprintf("\nFailed after %d attempts in %s", attempts, context.cli.now().Sub(start).Round(time.Millisecond))
+			} else {
+				retried = append(retried, fmt.Sprintf("%s passed after %d attempts", stepName, attempts))
+			}
+		}
+		if errors.As(err, &undefined) {
+			failure, longFailure, err = undefined.Error(), undefined.Error(), nil
+		} else if recorded != nil && err == nil {
+			failure, longFailure = "", "" // The response is recorded as the expected one
+		}
+		result.addStep(stepName, context.cli.now().Sub(start), failure, longFailure, err)
 		if err != nil {
 			fmt.Fprintln(context.cli.Stderr)
 			return "", errHint(fmt.Errorf("error in %s: %w", stepName, err), "See https://docs.vespa.ai/en/reference/testing")
 		}
 		if !context.dryRun {
 			if failure != "" {
-				fmt.Fprintf(context.cli.Stdout, " %s\n%s:\n%s\n", color.RedString("failed"), stepName, longFailure)
+				result.skipSteps(stepNames[i+1:])
+				fmt.Fprintf(context.stdout, " %s\n%s:\n%s\n", color.RedString("failed"), stepName, longFailure)
 				return fmt.Sprintf("%s: %s: %s", testName, stepName, failure), nil
 			}
 			if i == 0 {
-				fmt.Fprintf(context.cli.Stdout, " ")
+				fmt.Fprintf(context.stdout, " ")
 			}
-			fmt.Fprint(context.cli.Stdout, ".")
+			fmt.Fprint(context.stdout, ".")
 		}
 	}
+	if recorded != nil {
+		changed, err := context.recorder.record(testPath, testBytes, test.Steps, recorded, context.testsPath)
+		if err != nil {
+			fmt.Fprintln(context.cli.Stderr)
+			return "", fmt.Errorf("failed to record responses of %s: %w", testPath, err)
+		}
+		if len(changed) == 0 && !context.recorder.generated {
+			fmt.Fprintln(context.stdout, color.GreenString(" unchanged"))
+		} else {
+			fmt.Fprintln(context.stdout, color.GreenString(" recorded"))
+			if !context.recorder.generated {
+				for _, file := range changed {
+					printLinesDiff(context.stdout, file.path, file.path, file.old, file.new)
+				}
+			}
+		}
+		return "", nil
+	}
 	if !context.dryRun {
-		fmt.Fprintln(context.cli.Stdout, color.GreenString(" OK"))
+		fmt.Fprint(context.stdout, color.GreenString(" OK"))
+		if len(retried) > 0 {
+			fmt.Fprintf(context.stdout, " (%s)", strings.Join(retried, ", "))
+		}
+		fmt.Fprintln(context.stdout)
 	}
 	return "", nil
 }
 
+// stepRetry is how long to retry a step which fails, and how long to wait between attempts.
+type stepRetry struct {
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// parseRetry parses the retry of step, if any. Only steps which don't change any data may be retried.
+func parseRetry(step step) (stepRetry, error) {
+	if step.Retry == nil {
+		return stepRetry{}, nil
+	}
+	method := strings.ToUpper(step.Request.Method)
+	if method == "" {
+		method = "GET"
+	}
+	uri := step.Request.URI
+	if uri == "" {
+		uri = "/search/"
+	}
+	path := uri
+	if u, err := url.Parse(uri); err == nil {
+		path = u.Path
+	}
+	if method != "GET" && !(method == "POST" && strings.HasPrefix(path, "/search/")) {
+		return stepRetry{}, fmt.Errorf("only GET requests and POST queries to /search/ may be retried, but this is a %s to %s", method, uri)
+	}
+	timeout, err := time.ParseDuration(step.Retry.Timeout)
+	if err != nil || timeout <= 0 {
+		return stepRetry{}, fmt.Errorf("timeout must be a positive duration, but was '%s'", step.Retry.Timeout)
+	}
+	retry := stepRetry{timeout: timeout, interval: time.Second}
+	if step.Retry.Interval != "" {
+		interval, err := time.ParseDuration(step.Retry.Interval)
+		if err != nil || interval <= 0 {
+			return stepRetry{}, fmt.Errorf("interval must be a positive duration, but was '%s'", step.Retry.Interval)
+		}
+		retry.interval = interval
+	}
+	return retry, nil
+}
+
+// verifyWithRetry verifies step until it passes, or its retry timeout expires, and returns the number of attempts made.
+// Errors are not retried.
+func verifyWithRetry(step step, retry stepRetry, defaultCluster string, defaultParameters map[string]string, context testContext, waiter *Waiter) (string, string, int, error) {
+	deadline := context.cli.now().Add(retry.timeout)
+	for attempts := 1; ; attempts++ {
+		failure, longFailure, err := verify(step, defaultCluster, defaultParameters, context, waiter)
+		if err != nil || failure == "" || context.dryRun || !context.cli.now().Add(retry.interval).Before(deadline) {
+			return failure, longFailure, attempts, err
+		}
+		if err := context.cli.sleep(retry.interval); err != nil {
+			return failure, longFailure, attempts, err
+		}
+	}
+}
+
 // Asserts specified response is obtained for request, or returns a failure message, or an error if this fails
 func verify(step step, defaultCluster string, defaultParameters map[string]string, context testContext, waiter *Waiter) (string, string, error) {
-	requestBody, err := getBody(step.Request.BodyRaw, context.testsPath)
+	requestBody, err := getBody(step.Request.BodyRaw, context)
 	if err != nil {
 		return "", "", err
 	}
 
-	parameters, err := getParameters(step.Request.ParametersRaw, context.testsPath)
+	parameters, err := getParameters(step.Request.ParametersRaw, context)
 	if err != nil {
 		return "", "", err
 	}
@@ -202,6 +685,9 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 
 	header := http.Header{}
 	for k, v := range step.Request.Headers {
+		if v, err = context.variables.replace(v, context.source); err != nil {
+			return "", "", err
+		}
 		header.Set(k, v)
 	}
 	if header.Get("Content-Type") == "" { // Set default if not specified by test
@@ -209,7 +695,10 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 	}
 
 	var service *vespa.Service
-	requestUri := step.Request.URI
+	requestUri, err := context.variables.replace(step.Request.URI, context.source)
+	if err != nil {
+		return "", "", err
+	}
 	if requestUri == "" {
 		requestUri = "/search/"
 	}
@@ -218,23 +707,26 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 		return "", "", err
 	}
 	externalEndpoint := requestUrl.IsAbs()
+	if !externalEndpoint {
+		for name, values := range context.header {
+			if header.Get(name) == "" {
+				header[name] = values
+			}
+		}
+	}
 	if !externalEndpoint && filepath.Base(context.testsPath) == "production-test" {
 		return "", "", fmt.Errorf("production tests may not specify requests against Vespa endpoints")
 	}
 	if !externalEndpoint && !context.dryRun {
+		context.mu.Lock()
 		target, err := context.target()
+		context.mu.Unlock()
 		if err != nil {
 			return "", "", err
 		}
-		ok := false
-		service, ok = context.clusters[cluster]
-		if !ok && waiter != nil {
-			// Cache service so we don't have to discover it for every step
-			service, err = waiter.Service(target, cluster)
-			if err != nil {
-				return "", "", err
-			}
-			context.clusters[cluster] = service
+		service, err = context.service(target, cluster, waiter)
+		if err != nil {
+			return "", "", err
 		}
 		requestUrl, err = url.ParseRequestURI(service.BaseURL + requestUri)
 		if err != nil {
@@ -256,11 +748,7 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 	defer request.Body.Close()
 
 	statusCode := step.Response.Code
-	if statusCode == 0 {
-		statusCode = 200
-	}
-
-	responseBodySpecBytes, err := getBody(step.Response.BodyRaw, context.testsPath)
+	responseBodySpecBytes, err := getBody(step.Response.BodyRaw, context)
 	if err != nil {
 		return "", "", err
 	}
@@ -278,8 +766,7 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 
 	var response *http.Response
 	if externalEndpoint {
-		httputil.ConfigureTLS(context.cli.httpClient, []tls.Certificate{}, nil, false)
-		response, err = context.cli.httpClient.Do(request, 60*time.Second)
+		response, err = context.externalClient.Do(request, 60*time.Second)
 	} else {
 		response, err = service.Do(request, 600*time.Second) // Vespa should provide a response within the given request timeout
 	}
@@ -287,17 +774,36 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 		return "", "", err
 	}
 	defer response.Body.Close()
+	if !externalEndpoint {
+		context.coverage.record(method, requestUrl, requestBody)
+	}
+	if context.recorded != nil {
+		if err := context.recorded.capture(response); err != nil {
+			return "", "", err
+		}
+	}
 
-	if statusCode != response.StatusCode {
+	if !statusCode.matches(response.StatusCode) {
 		return fmt.Sprintf("Unexpected status code: %s", color.RedString(strconv.Itoa(response.StatusCode))),
 			fmt.Sprintf("Unexpected status code\nExpected: %s\nActual:   %s\nRequested: %s at %s\nResponse:\n%s",
-				color.CyanString(strconv.Itoa(statusCode)),
+				color.CyanString(statusCode.String()),
 				color.RedString(strconv.Itoa(response.StatusCode)),
 				color.CyanString(method),
 				color.CyanString(requestUrl.String()),
 				ioutil.ReaderToJSON(response.Body)), nil
 	}
 
+	if failure, expected, actual, err := compareHeaders(step.Response.Headers, response.Header); failure != "" || err != nil {
+		return failure + ": " + actual,
+			fmt.Sprintf("%s\nExpected: %s\nActual:   %s\nRequested: %s at %s\nResponse:\n%s",
+				failure,
+				expected,
+				actual,
+				color.CyanString(method),
+				color.CyanString(requestUrl.String()),
+				ioutil.ReaderToJSON(response.Body)), err
+	}
+
 	if responseBodySpec == nil {
 		return "", "", nil
 	}
@@ -367,12 +873,21 @@ func compare(expected interface{}, actual interface{}, path string) (string, str
 			}
 		}
 	case map[string]interface{}:
+		if isOperators(u) {
+			return matchOperators(u, actual, true, path)
+		}
 		v, ok := actual.(map[string]interface{})
 		typeMatch = ok
 		if ok {
 			for n, e := range u {
 				childPath := fmt.Sprintf("%s/%s", path, strings.ReplaceAll(strings.ReplaceAll(n, "~", "~0"), "/", "~1"))
 				f, ok := v[n]
+				if operators, isMap := e.(map[string]interface{}); !ok && isMap && isOperators(operators) {
+					if failure, expected, actual, err := matchOperators(operators, nil, false, childPath); failure != "" || err != nil {
+						return failure, expected, actual, err
+					}
+					continue
+				}
 				if !ok {
 					return fmt.Sprintf("Missing expected field at %s", color.RedString(childPath)), "", "", nil
 				}
@@ -404,41 +919,52 @@ func compare(expected interface{}, actual interface{}, path string) (string, str
 	return "", "", "", nil
 }
 
-func getParameters(parametersRaw []byte, testsPath string) (map[string]string, error) {
+func getParameters(parametersRaw []byte, context testContext) (map[string]string, error) {
 	if parametersRaw != nil {
+		source := context.source
 		var parametersPath string
 		if err := json.Unmarshal(parametersRaw, &parametersPath); err == nil {
 			if err = validateRelativePath(parametersPath); err != nil {
 				return nil, err
 			}
-			resolvedParametersPath := filepath.Join(testsPath, parametersPath)
+			resolvedParametersPath := filepath.Join(context.testsPath, parametersPath)
 			parametersRaw, err = os.ReadFile(resolvedParametersPath)
 			if err != nil {
 				return nil, fmt.Errorf("failed to read request parameters at %s: %w", resolvedParametersPath, err)
 			}
+			source = testSource{path: resolvedParametersPath, data: parametersRaw}
 		}
 		var parameters map[string]string
 		if err := json.Unmarshal(parametersRaw, &parameters); err != nil {
 			return nil, fmt.Errorf("request parameters must be JSON with only string values: %w", err)
 		}
+		for name, value := range parameters {
+			replaced, err := context.variables.replace(value, source)
+			if err != nil {
+				return nil, err
+			}
+			parameters[name] = replaced
+		}
 		return parameters, nil
 	}
 	return make(map[string]string), nil
 }
 
-func getBody(bodyRaw []byte, testsPath string) ([]byte, error) {
+func getBody(bodyRaw []byte, context testContext) ([]byte, error) {
+	source := context.source
 	var bodyPath string
 	if err := json.Unmarshal(bodyRaw, &bodyPath); err == nil {
 		if err = validateRelativePath(bodyPath); err != nil {
 			return nil, err
 		}
-		resolvedBodyPath := filepath.Join(testsPath, bodyPath)
+		resolvedBodyPath := filepath.Join(context.testsPath, bodyPath)
 		bodyRaw, err = os.ReadFile(resolvedBodyPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read body file at %s: %w", resolvedBodyPath, err)
 		}
+		source = testSource{path: resolvedBodyPath, data: bodyRaw}
 	}
-	return bodyRaw, nil
+	return context.variables.substitute(bodyRaw, source)
 }
 
 func validateRelativePath(relPath string) error {
@@ -453,7 +979,9 @@ func validateRelativePath(relPath string) error {
 }
 
 type test struct {
-	Name     string   `json:"name"`
+	Name string `json:"name"`
+	// Order makes this test run before those without an order, and not in parallel with other tests
+	Order    *int     `json:"order"`
 	Defaults defaults `json:"defaults"`
 	Steps    []step   `json:"steps"`
 }
@@ -464,9 +992,15 @@ type defaults struct {
 }
 
 type step struct {
-	Name     string   `json:"name"`
-	Request  request  `json:"request"`
-	Response response `json:"response"`
+	Name     string     `json:"name"`
+	Request  request    `json:"request"`
+	Response response   `json:"response"`
+	Retry    *retrySpec `json:"retry"`
+}
+
+type retrySpec struct {
+	Timeout  string `json:"timeout"`
+	Interval string `json:"interval"`
 }
 
 type request struct {
@@ -479,8 +1013,9 @@ type request struct {
 }
 
 type response struct {
-	Code    int             `json:"code"`
-	BodyRaw json.RawMessage `json:"body"`
+	Code    statusCode             `json:"code"`
+	Headers map[string]interface{} `json:"headers"`
+	BodyRaw json.RawMessage        `json:"body"`
 }
 
 type testContext struct {
@@ -490,6 +1025,51 @@ type testContext struct {
 	dryRun     bool
 	// Cache of services by their cluster name
 	clusters map[string]*vespa.Service
+	// Results of the steps run, or nil if no report is wanted
+	report *testReport
+	// Writer for the output of the test being run
+	stdout io.Writer
+	// Guards the target and the cluster cache, which are shared by tests running in parallel
+	mu *sync.Mutex
+	// The HTTP client of requests to external endpoints, which are sent without client certificates
+	externalClient httputil.Client
+	// Values of placeholders in tests, or nil to leave these as-is
+	variables *testVariables
+	// The test file being run
+	source testSource
+	// Records the responses of the tests, or nil if these are verified
+	recorder *testRecorder
+	// The recorded response of the step being run, or nil if not recording
+	recorded *recordedResponse
+	// Records what the requests exercise, or nil if coverage is not wanted
+	coverage *testCoverage
+	// Headers added to requests to Vespa, unless set by the step
+	header http.Header
+}
+
+func newTestContext(cli *CLI, testsPath string, options testOptions) testContext {
+	externalClient := httputil.Clone(cli.httpClient)
+	httputil.ConfigureTLS(externalClient, []tls.Certificate{}, nil, false)
+	return testContext{cli: cli, testsPath: testsPath, dryRun: options.dryRun, clusters: map[string]*vespa.Service{}, report: options.report, stdout: cli.Stdout, mu: &sync.Mutex{}, externalClient: externalClient, variables: options.variables, recorder: options.recorder, coverage: options.coverage, header: options.header}
+}
+
+// service returns the service of the given cluster, discovering it with waiter if it is not already cached.
+func (t *testContext) service(target vespa.Target, cluster string, waiter *Waiter) (*vespa.Service, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	service, ok := t.clusters[cluster]
+	if !ok && waiter != nil {
+		// Cache service so we don't have to discover it for every step
+		var err error
+		service, err = waiter.Service(target, cluster)
+		if err != nil {
+			return nil, err
+		}
+		// Tests running in parallel share the service, which must not configure its client for each request
+		service.UseOwnClient()
+		t.clusters[cluster] = service
+	}
+	return service, nil
 }
 
 func (t *testContext) target() (vespa.Target, error) {
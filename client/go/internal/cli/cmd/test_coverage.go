@@ -0,0 +1,271 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Coverage of the deployed application by vespa test
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+)
+
+// yqlSourcesRegexp matches the sources a YQL query selects from.
+var yqlSourcesRegexp = regexp.MustCompile(`(?is)\bfrom\s+(?:sources\s+)?([\w\s,*-]+?)\s*(?:\bwhere\b|\border\b|\blimit\b|;|$)`)
+
+// testCoverage records which document types, handlers and rank profiles the requests of a test run exercise.
+type testCoverage struct {
+	mu sync.Mutex
+	// fed and queried hold the document types written by document/v1 requests, and searched by queries
+	fed     map[string]bool
+	queried map[string]bool
+	// queriedAll is whether a query searched all document types
+	queriedAll bool
+	// rankProfiles holds the rank profiles referenced by queries, where an empty schema means all schemas searched
+	rankProfiles map[vespa.RankProfile]bool
+	// paths holds the number of requests to each path
+	paths map[string]int
+}
+
+func newTestCoverage() *testCoverage {
+	return &testCoverage{fed: make(map[string]bool), queried: make(map[string]bool), rankProfiles: make(map[vespa.RankProfile]bool), paths: make(map[string]int)}
+}
+
+// record records a request to a Vespa endpoint, with the given method, URL and body.
+func (c *testCoverage) record(method string, u *url.URL, body []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths[u.Path]++
+	if rest, ok := strings.CutPrefix(u.Path, "/document/v1/"); ok {
+		// /document/v1/<namespace>/<document-type>/docid/<id>, or similar
+		parts := strings.Split(rest, "/")
+		if len(parts) > 1 && parts[1] != "" && !strings.EqualFold(method, "GET") {
+			c.fed[parts[1]] = true
+		}
+		return
+	}
+	if !strings.HasPrefix(u.Path, "/search/") {
+		return
+	}
+	parameters := queryParameters(u, body)
+	var schemas []string
+	for _, name := range []string{"model.restrict", "restrict", "model.sources", "sources"} {
+		if value, ok := parameters[name]; ok {
+			schemas = splitSources(value)
+			break
+		}
+	}
+	if schemas == nil {
+		if m := yqlSourcesRegexp.FindStringSubmatch(parameters["yql"]); m != nil {
+			schemas = splitSources(m[1])
+		}
+	}
+	profile := "default"
+	for _, name := range []string{"ranking.profile", "ranking"} {
+		if value, ok := parameters[name]; ok {
+			profile = value
+			break
+		}
+	}
+	if len(schemas) == 0 || slices.Contains(schemas, "*") {
+		c.queriedAll = true
+		c.rankProfiles[vespa.RankProfile{Name: profile}] = true
+		return
+	}
+	for _, schema := range schemas {
+		c.queried[schema] = true
+		c.rankProfiles[vespa.RankProfile{Schema: schema, Name: profile}] = true
+	}
+}
+
+// queryParameters returns the query parameters of a query with the given URL and body. Members of nested objects in a
+// JSON body are named by their path, like ranking.profile for {"ranking": {"profile": "p"}}. Parameters in the URL take
+// precedence.
+func queryParameters(u *url.URL, body []byte) map[string]string {
+	parameters := make(map[string]string)
+	var root map[string]any
+	if json.Unmarshal(body, &root) == nil {
+		flattenParameters("", root, parameters)
+	}
+	for name, values := range u.Query() {
+		if len(values) > 0 {
+			parameters[name] = values[0]
+		}
+	}
+	return parameters
+}
+
+func flattenParameters(prefix string, object map[string]any, parameters map[string]string) {
+	for name, value := range object {
+		switch v := value.(type) {
+		case map[string]any:
+			flattenParameters(prefix+name+".", v, parameters)
+		case string:
+			parameters[prefix+name] = v
+		default:
+			if data, err := json.Marshal(v); err == nil {
+				parameters[prefix+name] = string(data)
+			}
+		}
+	}
+}
+
+func splitSources(value string) []string {
+	var sources []string
+	for _, source := range strings.Split(value, ",") {
+		if source = strings.TrimSpace(source); source != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// coverageReport is the coverage of the deployed application by a test run.
+type coverageReport struct {
+	DocumentTypes []documentTypeCoverage `json:"documentTypes"`
+	Handlers      []handlerCoverage      `json:"handlers"`
+	RankProfiles  []rankProfileCoverage  `json:"rankProfiles"`
+	// Uncovered lists the items above which no request exercised
+	Uncovered uncoveredItems `json:"uncovered"`
+}
+
+type documentTypeCoverage struct {
+	Name    string `json:"name"`
+	Fed     bool   `json:"fed"`
+	Queried bool   `json:"queried"`
+}
+
+type handlerCoverage struct {
+	Binding  string `json:"binding"`
+	Requests int    `json:"requests"`
+}
+
+type rankProfileCoverage struct {
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Referenced bool   `json:"referenced"`
+}
+
+type uncoveredItems struct {
+	DocumentTypes []string `json:"documentTypes"`
+	Handlers      []string `json:"handlers"`
+	RankProfiles  []string `json:"rankProfiles"`
+}
+
+// report compares the requests recorded to the inventory of the deployed application. A document type is covered if
+// it is fed or queried, a handler if any request has a path matching its binding, and a rank profile if a query
+// searching its schema references it.
+func (c *testCoverage) report(inventory vespa.Inventory) coverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := coverageReport{
+		DocumentTypes: []documentTypeCoverage{},
+		Handlers:      []handlerCoverage{},
+		RankProfiles:  []rankProfileCoverage{},
+		Uncovered:     uncoveredItems{DocumentTypes: []string{}, Handlers: []string{}, RankProfiles: []string{}},
+	}
+	for _, name := range inventory.DocumentTypes {
+		d := documentTypeCoverage{Name: name, Fed: c.fed[name], Queried: c.queriedAll || c.queried[name]}
+		report.DocumentTypes = append(report.DocumentTypes, d)
+		if !d.Fed && !d.Queried {
+			report.Uncovered.DocumentTypes = append(report.Uncovered.DocumentTypes, name)
+		}
+	}
+	for _, binding := range inventory.Handlers {
+		h := handlerCoverage{Binding: binding}
+		for path, n := range c.paths {
+			if vespa.MatchesHandler(binding, path) {
+				h.Requests += n
+			}
+		}
+		report.Handlers = append(report.Handlers, h)
+		if h.Requests == 0 {
+			report.Uncovered.Handlers = append(report.Uncovered.Handlers, binding)
+		}
+	}
+	for _, p := range inventory.RankProfiles {
+		r := rankProfileCoverage{Schema: p.Schema, Name: p.Name, Referenced: c.rankProfiles[p] || c.rankProfiles[vespa.RankProfile{Name: p.Name}]}
+		report.RankProfiles = append(report.RankProfiles, r)
+		if !r.Referenced {
+			report.Uncovered.RankProfiles = append(report.Uncovered.RankProfiles, p.Schema+"/"+p.Name)
+		}
+	}
+	return report
+}
+
+// printCoverage fetches the inventory of the deployed application with fetch, and prints its coverage in format, which
+// is table or json. A warning is printed instead if the application can not be fetched.
+func printCoverage(cli *CLI, coverage *testCoverage, fetch func() (vespa.Inventory, error), format string) error {
+	inventory, err := fetchDeployed(cli, "Fetching deployed application package...", fetch)
+	if err != nil {
+		cli.printWarning(fmt.Sprintf("Could not compute coverage of the deployed application: %s", err))
+		return nil
+	}
+	report := coverage.report(inventory)
+	if format == "json" {
+		return writeJSON(cli, report)
+	}
+	fmt.Fprintln(cli.Stdout)
+	w := tabwriter.NewWriter(cli.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tCOVERED\tDETAILS")
+	for _, d := range report.DocumentTypes {
+		var details []string
+		if d.Fed {
+			details = append(details, "fed")
+		}
+		if d.Queried {
+			details = append(details, "queried")
+		}
+		if len(details) == 0 {
+			details = append(details, "-")
+		}
+		fmt.Fprintf(w, "document type\t%s\t%s\t%s\n", d.Name, yesNo(d.Fed || d.Queried), strings.Join(details, ", "))
+	}
+	for _, h := range report.Handlers {
+		details := "-"
+		if h.Requests == 1 {
+			details = "1 request"
+		} else if h.Requests > 1 {
+			details = fmt.Sprintf("%d requests", h.Requests)
+		}
+		fmt.Fprintf(w, "handler\t%s\t%s\t%s\n", h.Binding, yesNo(h.Requests > 0), details)
+	}
+	for _, r := range report.RankProfiles {
+		fmt.Fprintf(w, "rank profile\t%s/%s\t%s\t-\n", r.Schema, r.Name, yesNo(r.Referenced))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	uncovered := len(report.Uncovered.DocumentTypes) + len(report.Uncovered.Handlers) + len(report.Uncovered.RankProfiles)
+	total := len(report.DocumentTypes) + len(report.Handlers) + len(report.RankProfiles)
+	if uncovered == 0 {
+		fmt.Fprintf(cli.Stdout, "\nAll %d items of the deployed application are covered\n", total)
+		return nil
+	}
+	fmt.Fprintf(cli.Stdout, "\n%d of %d items of the deployed application are not covered:\n", uncovered, total)
+	for _, name := range report.Uncovered.DocumentTypes {
+		fmt.Fprintf(cli.Stdout, "  document type %s\n", name)
+	}
+	for _, binding := range report.Uncovered.Handlers {
+		fmt.Fprintf(cli.Stdout, "  handler %s\n", binding)
+	}
+	for _, name := range report.Uncovered.RankProfiles {
+		fmt.Fprintf(cli.Stdout, "  rank profile %s\n", name)
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
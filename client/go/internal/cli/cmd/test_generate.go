@@ -0,0 +1,311 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Generation of test suites from queries for vespa test
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// generatedNameLength is the maximum length of the names of generated tests, which are made from their queries.
+const generatedNameLength = 60
+
+func newTestGenerateCmd(cli *CLI) *cobra.Command {
+	var (
+		fromLog      string
+		outputDir    string
+		limit        int
+		force        bool
+		recordIgnore []string
+		headers      []string
+		waitSecs     int
+	)
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a test suite from a log of queries",
+		Long: `Generate a test suite from a log of queries.
+
+The queries are read from the file given by --from-log, or standard input if
+'-'. This may be a query access log of a Vespa container, with one JSON entry
+per line, or a file with one query per line, given as a URL to /search/, a YQL
+string, or a JSON query body. Requests which are not queries, failed requests,
+and duplicate queries are skipped. Use --limit to generate at most that many
+tests, from the first queries read.
+
+Each query is run against the current target, and is written as a test with
+one step in the directory given by --output, with the response as its expected
+response. Members of response bodies which change between runs are left out,
+as when recording with 'vespa test --record'. The generated tests may be run
+right away with 'vespa test', and should be reviewed and trimmed to the
+members which matter, e.g. with operators matching a range of values.
+
+Existing test files are not overwritten, unless --force is given. Generating
+tests against a production deployment requires --force too.`,
+		Example: `$ vespa test generate --from-log logs/vespa/access/JsonAccessLog.default.20240101000000 --output tests/
+$ vespa test generate --from-log queries.txt --output src/test/application/tests/system-test --limit 20
+$ vespa test src/test/application/tests/system-test`,
+		Args:              cobra.ExactArgs(0),
+		DisableAutoGenTag: true,
+		SilenceUsage:      true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli.disableRetries()
+			if fromLog == "" {
+				return errHint(fmt.Errorf("no queries given"), "Use --from-log to give a file of queries, or '-' to read them from standard input")
+			}
+			if limit < 0 {
+				return fmt.Errorf("invalid limit: %d: must be at least 0", limit)
+			}
+			recorder, err := newTestRecorder(recordIgnore)
+			if err != nil {
+				return err
+			}
+			recorder.generated = true
+			header, err := cli.dataPlaneHeader(headers, "mtls")
+			if err != nil {
+				return err
+			}
+			var r io.Reader = cli.Stdin
+			if fromLog != "-" {
+				f, err := os.Open(fromLog)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			queries, err := readGeneratedQueries(r)
+			if err != nil {
+				return fmt.Errorf("could not read queries from %s: %w", fromLog, err)
+			}
+			if len(queries) == 0 {
+				return errHint(fmt.Errorf("no queries found in %s", fromLog), "Give a query access log, or a file with one query URL, YQL string or JSON query body per line")
+			}
+			if limit > 0 && len(queries) > limit {
+				queries = queries[:limit]
+			}
+			if err := checkRecordTarget(cli, force); err != nil {
+				return err
+			}
+			testPaths, err := writeGeneratedTests(outputDir, queries, force)
+			if err != nil {
+				return err
+			}
+			options := testOptions{waiter: cli.waiter(time.Duration(waitSecs)*time.Second, cmd), recorder: recorder, header: header}
+			runner := testRunner{context: newTestContext(cli, outputDir, options), waiter: options.waiter}
+			defer runner.stopOnInterrupt()()
+			failures, err := runner.runAll(testPaths, 1)
+			if err == nil && runner.interrupted.Load() {
+				err = fmt.Errorf("interrupted")
+			}
+			if err != nil {
+				return err
+			}
+			if len(failures) > 0 {
+				fmt.Fprintf(cli.Stdout, "\n%s %d of %d tests could not be generated:\n", color.RedString("Failure:"), len(failures), len(testPaths))
+				for _, failure := range failures {
+					fmt.Fprintln(cli.Stdout, failure)
+				}
+				return ErrCLI{Status: exitTestsFailed, error: fmt.Errorf("tests failed"), quiet: true}
+			}
+			plural := "s"
+			if len(testPaths) == 1 {
+				plural = ""
+			}
+			fmt.Fprintf(cli.Stdout, "\n%s %d test%s generated in %s\n", color.GreenString("Success:"), len(testPaths), plural, outputDir)
+			cli.printInfo("Run them with 'vespa test ", outputDir, "'")
+			return nil
+		},
+	}
+	cli.bindWaitFlag(cmd, 0, &waitSecs)
+	cmd.Flags().StringVar(&fromLog, "from-log", "", "Read queries from this query access log or file of queries, or standard input if '-'")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Write the generated tests to this directory")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Generate at most this many tests. 0 generates one for every distinct query")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite existing test files, and allow generating tests against a production deployment")
+	cmd.Flags().StringArrayVar(&recordIgnore, "record-ignore", defaultRecordIgnore, "JSON pointer to a member of response bodies which is not recorded. May be repeated")
+	cmd.Flags().StringSliceVarP(&headers, "header", "", nil, "Add a header to all requests to Vespa, on the format 'Header: Value'. This can be specified multiple times")
+	return cmd
+}
+
+// generatedQuery is a query read from a log, which is given by either its parameters or its JSON body.
+type generatedQuery struct {
+	parameters map[string]string
+	body       map[string]any
+}
+
+// key returns a canonical form of the query, which is equal for duplicate queries.
+func (q generatedQuery) key() string {
+	if q.body != nil {
+		data, _ := json.Marshal(q.body)
+		return "POST " + string(data)
+	}
+	values := make(url.Values)
+	for name, value := range q.parameters {
+		values.Set(name, value)
+	}
+	return "GET " + values.Encode()
+}
+
+// name returns the name of a test of the query, which is its YQL or query string, shortened if long.
+func (q generatedQuery) name() string {
+	var name string
+	if q.body != nil {
+		if s, ok := q.body["yql"].(string); ok {
+			name = s
+		} else if s, ok := q.body["query"].(string); ok {
+			name = s
+		} else {
+			data, _ := json.Marshal(q.body)
+			name = string(data)
+		}
+	} else if s, ok := q.parameters["yql"]; ok {
+		name = s
+	} else if s, ok := q.parameters["query"]; ok {
+		name = s
+	} else {
+		name = strings.TrimPrefix(q.key(), "GET ")
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > generatedNameLength {
+		name = string(runes[:generatedNameLength-3]) + "..."
+	}
+	return name
+}
+
+// readGeneratedQueries reads the distinct queries of the log in r, in the order they are first read.
+func readGeneratedQueries(r io.Reader) ([]generatedQuery, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var queries []generatedQuery
+	seen := make(map[string]bool)
+	for scanner.Scan() {
+		query, ok := parseGeneratedQuery(strings.TrimSpace(scanner.Text()))
+		if !ok {
+			continue
+		}
+		if key := query.key(); !seen[key] {
+			seen[key] = true
+			queries = append(queries, query)
+		}
+	}
+	return queries, scanner.Err()
+}
+
+// parseGeneratedQuery parses a line of a query log, and returns whether it holds a query.
+func parseGeneratedQuery(line string) (generatedQuery, bool) {
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return generatedQuery{}, false
+	case strings.HasPrefix(line, "{"):
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		var entry map[string]any
+		if err := dec.Decode(&entry); err != nil {
+			return generatedQuery{}, false
+		}
+		if uri, ok := entry["uri"].(string); ok { // An entry of a JSON access log
+			if code, ok := entry["code"].(json.Number); ok && !strings.HasPrefix(code.String(), "2") {
+				return generatedQuery{}, false
+			}
+			return parseQueryURI(uri)
+		}
+		if entry["yql"] == nil && entry["query"] == nil {
+			return generatedQuery{}, false
+		}
+		return generatedQuery{body: entry}, true
+	case strings.HasPrefix(strings.ToLower(line), "select "):
+		return generatedQuery{parameters: map[string]string{"yql": line}}, true
+	}
+	// A line with a query URL, such as one of an access log in the common log format
+	i := strings.Index(line, "/search/")
+	if i < 0 {
+		return generatedQuery{}, false
+	}
+	start := strings.LastIndexAny(line[:i], " \t\"'") + 1
+	end := len(line)
+	if n := strings.IndexAny(line[i:], " \t\"'"); n >= 0 {
+		end = i + n
+	}
+	return parseQueryURI(line[start:end])
+}
+
+// parseQueryURI returns the query given by the parameters of uri, if it is a query URI.
+func parseQueryURI(uri string) (generatedQuery, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || strings.TrimSuffix(u.Path, "/") != "/search" {
+		return generatedQuery{}, false
+	}
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil || len(values) == 0 {
+		return generatedQuery{}, false
+	}
+	parameters := make(map[string]string, len(values))
+	for name := range values {
+		parameters[name] = values.Get(name)
+	}
+	return generatedQuery{parameters: parameters}, true
+}
+
+// writeGeneratedTests writes a test of each query to dir, and returns the paths of the tests. No files are written if
+// any of them exists, unless force is true.
+func writeGeneratedTests(dir string, queries []generatedQuery, force bool) ([]string, error) {
+	width := max(3, len(fmt.Sprint(len(queries))))
+	paths := make([]string, len(queries))
+	for i := range queries {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("query-%0*d.json", width, i+1))
+		if _, err := os.Stat(paths[i]); err == nil && !force {
+			return nil, errHint(fmt.Errorf("refusing to overwrite existing test %s", paths[i]), "Use --force to overwrite it", "Use --output to write the tests to another directory")
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for i, query := range queries {
+		request := map[string]any{"uri": "/search/"}
+		if query.body != nil {
+			request["method"] = "POST"
+			request["body"] = query.body
+		} else {
+			request["parameters"] = query.parameters
+		}
+		data, err := encodeGeneratedTest(query.name(), request)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(paths[i], data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// encodeGeneratedTest returns a test with the given name and a single step with the given request.
+func encodeGeneratedTest(name string, request map[string]any) ([]byte, error) {
+	var members []jsonMember
+	for _, name := range []string{"method", "uri", "parameters", "body"} {
+		if value, ok := request[name]; ok {
+			data, err := marshalUnescaped(value)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, jsonMember{name: name, value: data})
+		}
+	}
+	nameValue, err := marshalUnescaped(name)
+	if err != nil {
+		return nil, err
+	}
+	step := encodeObject([]jsonMember{{name: "request", value: encodeObject(members)}})
+	test := []jsonMember{{name: "name", value: nameValue}, {name: "steps", value: encodeArray([]json.RawMessage{step})}}
+	return indentJSON(encodeObject(test), "    ")
+}
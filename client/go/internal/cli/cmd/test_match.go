@@ -0,0 +1,242 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// statusCode is the expected status code of a response: either an exact code, like 404, or a class of codes, like
+// "2xx". The zero value expects 200.
+type statusCode struct {
+	code  int
+	class int
+}
+
+func (c *statusCode) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		*c = statusCode{code: code}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid status code %s: must be a number, or a string like \"404\" or \"2xx\"", string(data))
+	}
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+		*c = statusCode{class: int(s[0] - '0')}
+		return nil
+	}
+	if code, err := strconv.Atoi(s); err == nil {
+		*c = statusCode{code: code}
+		return nil
+	}
+	return fmt.Errorf("invalid status code %q: must be a number, or a string like \"404\" or \"2xx\"", s)
+}
+
+// matches returns whether actual is the code, or in the class of codes, of this.
+func (c statusCode) matches(actual int) bool {
+	if c.class > 0 {
+		return actual/100 == c.class
+	}
+	return actual == c.expected()
+}
+
+func (c statusCode) expected() int {
+	if c.code == 0 {
+		return 200
+	}
+	return c.code
+}
+
+func (c statusCode) String() string {
+	if c.class > 0 {
+		return strconv.Itoa(c.class) + "xx"
+	}
+	return strconv.Itoa(c.expected())
+}
+
+// isOperators returns whether spec is an object of operators, i.e., a non-empty object whose member names all start
+// with "$".
+func isOperators(spec map[string]interface{}) bool {
+	if len(spec) == 0 {
+		return false
+	}
+	for name := range spec {
+		if !strings.HasPrefix(name, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// matchOperators checks actual against each of the operators in spec, in order of their names, and returns a failure
+// message for the first one which does not match, or an error if spec is invalid. Present is whether the actual
+// value exists at all, which only the $exists operator accepts to be false.
+func matchOperators(spec map[string]interface{}, actual interface{}, present bool, path string) (string, string, string, error) {
+	if path == "" {
+		path = "root"
+	}
+	operators := make([]string, 0, len(spec))
+	for operator := range spec {
+		operators = append(operators, operator)
+	}
+	sort.Strings(operators)
+	for _, operator := range operators {
+		operand := spec[operator]
+		if operator == "$exists" {
+			exists, ok := operand.(bool)
+			if !ok {
+				return "", "", "", fmt.Errorf("operand of $exists at %s must be a boolean, got %s", path, toJSON(operand))
+			}
+			if exists != present {
+				actualJson := "missing"
+				if present {
+					actualJson = toJSON(actual)
+				}
+				return operatorFailure(operator, operand, path, color.RedString(actualJson))
+			}
+			continue
+		}
+		if !present {
+			return fmt.Sprintf("Missing expected field at %s", color.RedString(path)), "", "", nil
+		}
+		if operator == "$length" {
+			length, ok := lengthOf(actual)
+			if !ok {
+				return operatorFailure(operator, operand, path, color.RedString(toJSON(actual)))
+			}
+			if lengthSpec, ok := operand.(map[string]interface{}); ok && isOperators(lengthSpec) {
+				if failure, expected, actual, err := matchOperators(lengthSpec, float64(length), true, path+" ($length)"); failure != "" || err != nil {
+					return failure, expected, actual, err
+				}
+				continue
+			}
+			expected, ok := operand.(float64)
+			if !ok {
+				return "", "", "", fmt.Errorf("operand of $length at %s must be a number, or an object of operators, got %s", path, toJSON(operand))
+			}
+			if float64(length) != expected {
+				return operatorFailure(operator, operand, path, color.RedString(strconv.Itoa(length)))
+			}
+			continue
+		}
+		match, err := matchOperator(operator, operand, actual, path)
+		if err != nil {
+			return "", "", "", err
+		}
+		if !match {
+			return operatorFailure(operator, operand, path, color.RedString(toJSON(actual)))
+		}
+	}
+	return "", "", "", nil
+}
+
+// matchOperator returns whether actual matches the comparison or regular expression operator with the given operand.
+func matchOperator(operator string, operand interface{}, actual interface{}, path string) (bool, error) {
+	switch operator {
+	case "$gt", "$gte", "$lt", "$lte":
+		bound, ok := operand.(float64)
+		if !ok {
+			return false, fmt.Errorf("operand of %s at %s must be a number, got %s", operator, path, toJSON(operand))
+		}
+		v, ok := actual.(float64)
+		if !ok {
+			return false, nil
+		}
+		switch operator {
+		case "$gt":
+			return v > bound, nil
+		case "$gte":
+			return v >= bound || math.Abs(v-bound) < 1e-9, nil
+		case "$lt":
+			return v < bound, nil
+		default:
+			return v <= bound || math.Abs(v-bound) < 1e-9, nil
+		}
+	case "$regex":
+		pattern, ok := operand.(string)
+		if !ok {
+			return false, fmt.Errorf("operand of $regex at %s must be a string, got %s", path, toJSON(operand))
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression of $regex at %s: %w", path, err)
+		}
+		v, ok := actual.(string)
+		return ok && re.MatchString(v), nil
+	}
+	return false, fmt.Errorf("unknown operator %s at %s: must be one of $exists, $gt, $gte, $length, $lt, $lte or $regex", operator, path)
+}
+
+// lengthOf returns the number of characters of a string, elements of an array, or members of an object.
+func lengthOf(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []interface{}:
+		return len(v), true
+	case map[string]interface{}:
+		return len(v), true
+	}
+	return 0, false
+}
+
+func operatorFailure(operator string, operand interface{}, path, actual string) (string, string, string, error) {
+	return fmt.Sprintf("Unexpected value for %s at %s", color.CyanString(operator), color.CyanString(path)),
+		color.CyanString(operator + " " + toJSON(operand)),
+		actual,
+		nil
+}
+
+func toJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// compareHeaders compares the expected headers with the actual ones, by case-insensitive name. An expected header is
+// either a string, which must equal the actual value, or an object of operators, which the actual value must match.
+// Repeated headers are joined by ", ".
+func compareHeaders(expected map[string]interface{}, actual http.Header) (string, string, string, error) {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := actual.Values(name)
+		path := "header " + http.CanonicalHeaderKey(name)
+		switch spec := expected[name].(type) {
+		case string:
+			if len(values) == 0 {
+				return fmt.Sprintf("Missing expected %s", color.RedString(path)), "", "", nil
+			}
+			if value := strings.Join(values, ", "); value != spec {
+				return fmt.Sprintf("Unexpected value at %s", color.CyanString(path)),
+					color.CyanString(toJSON(spec)),
+					color.RedString(toJSON(value)),
+					nil
+			}
+		case map[string]interface{}:
+			if !isOperators(spec) {
+				return "", "", "", fmt.Errorf("expected %s must be a string, or an object of operators, got %s", path, toJSON(spec))
+			}
+			if failure, expected, actual, err := matchOperators(spec, strings.Join(values, ", "), len(values) > 0, path); failure != "" || err != nil {
+				return failure, expected, actual, err
+			}
+		default:
+			return "", "", "", fmt.Errorf("expected %s must be a string, or an object of operators, got %s", path, toJSON(spec))
+		}
+	}
+	return "", "", "", nil
+}
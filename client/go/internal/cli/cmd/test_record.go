@@ -0,0 +1,281 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Recording of expected responses for vespa test
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultRecordIgnore are the members of response bodies which are not recorded by default, as they change between runs.
+var defaultRecordIgnore = []string{"/timing", "/root/coverage"}
+
+// testRecorder records the actual responses to the steps of tests, and writes these as the expected responses.
+type testRecorder struct {
+	// ignore are JSON pointers to members of response bodies which are left out of recorded bodies. A "*" token
+	// matches any member or element.
+	ignore [][]string
+	// generated is whether the tests are generated by 'vespa test generate', so their changes are not printed
+	generated bool
+}
+
+func newTestRecorder(ignore []string) (*testRecorder, error) {
+	recorder := &testRecorder{}
+	for _, pointer := range ignore {
+		if !strings.HasPrefix(pointer, "/") {
+			return nil, errHint(fmt.Errorf("invalid ignore path: %q: must be a JSON pointer starting with '/'", pointer), "Example: --record-ignore /root/children/*/relevance")
+		}
+		tokens := strings.Split(pointer[1:], "/")
+		for i, token := range tokens {
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		}
+		recorder.ignore = append(recorder.ignore, tokens)
+	}
+	return recorder, nil
+}
+
+// recordedResponse is the actual response to a step.
+type recordedResponse struct {
+	code int
+	// body is the JSON body of the response, or nil if it had none, or it was not JSON
+	body []byte
+}
+
+// capture records response, and replaces its body such that it can still be read.
+func (r *recordedResponse) capture(response *http.Response) error {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	r.code = response.StatusCode
+	r.body = nil
+	if len(bytes.TrimSpace(body)) > 0 && json.Valid(body) {
+		r.body = body
+	}
+	return nil
+}
+
+// recordedFile is a file changed by recording.
+type recordedFile struct {
+	path     string
+	old, new []byte
+}
+
+// record replaces the expected responses of the steps of the test at testPath by the responses recorded for them, and
+// returns the files which were changed. Response bodies read from a file are written to that file.
+func (r *testRecorder) record(testPath string, testBytes []byte, steps []step, recorded []recordedResponse, testsPath string) ([]recordedFile, error) {
+	test, err := decodeObject(testBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid test at %s: %w", testPath, err)
+	}
+	var stepValues []json.RawMessage
+	if err := json.Unmarshal(getMember(test, "steps"), &stepValues); err != nil || len(stepValues) != len(steps) {
+		return nil, fmt.Errorf("unexpected steps in %s", testPath)
+	}
+	var changed []recordedFile
+	for i, stepValue := range stepValues {
+		stepMembers, err := decodeObject(stepValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %d of %s: %w", i+1, testPath, err)
+		}
+		var response []jsonMember
+		if responseValue := getMember(stepMembers, "response"); responseValue != nil {
+			if response, err = decodeObject(responseValue); err != nil {
+				return nil, fmt.Errorf("invalid response of step %d of %s: %w", i+1, testPath, err)
+			}
+		}
+		if recorded[i].code != 200 || getMember(response, "code") != nil {
+			response = setMember(response, "code", json.RawMessage(strconv.Itoa(recorded[i].code)))
+		}
+		var body json.RawMessage
+		if recorded[i].body != nil {
+			body = recorded[i].body
+			for _, tokens := range r.ignore {
+				if body, err = removeJSONPath(body, tokens); err != nil {
+					return nil, err
+				}
+			}
+		}
+		var bodyPath string
+		if err := json.Unmarshal(steps[i].Response.BodyRaw, &bodyPath); err == nil && body != nil {
+			if err := validateRelativePath(bodyPath); err != nil {
+				return nil, err
+			}
+			file, err := recordFile(filepath.Join(testsPath, bodyPath), body, "  ")
+			if err != nil {
+				return nil, err
+			}
+			if file != nil {
+				changed = append(changed, *file)
+			}
+		} else if body != nil {
+			response = setMember(response, "body", body)
+		} else {
+			response = removeMember(response, "body")
+		}
+		if len(response) > 0 || getMember(stepMembers, "response") != nil {
+			stepValues[i] = encodeObject(setMember(stepMembers, "response", encodeObject(response)))
+		}
+	}
+	test = setMember(test, "steps", encodeArray(stepValues))
+	newBytes, err := indentJSON(encodeObject(test), detectIndent(testBytes))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(testBytes, newBytes) {
+		if err := os.WriteFile(testPath, newBytes, 0644); err != nil {
+			return nil, err
+		}
+		changed = append([]recordedFile{{path: testPath, old: testBytes, new: newBytes}}, changed...)
+	}
+	return changed, nil
+}
+
+// recordFile writes the JSON value to the file at path, indented by defaultIndent unless the file is indented
+// otherwise, and returns the change, or nil if the file is unchanged.
+func recordFile(path string, value json.RawMessage, defaultIndent string) (*recordedFile, error) {
+	old, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	indent := defaultIndent
+	if len(old) > 0 {
+		indent = detectIndent(old)
+	}
+	data, err := indentJSON(value, indent)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(old, data) {
+		return nil, nil
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return &recordedFile{path: path, old: old, new: data}, nil
+}
+
+// detectIndent returns the indentation of the first indented line of the JSON in data, or four spaces if none are.
+func detectIndent(data []byte) string {
+	lines := bytes.Split(data, []byte("\n"))
+	for _, line := range lines[1:] {
+		if trimmed := bytes.TrimLeft(line, " \t"); len(trimmed) > 0 && len(trimmed) < len(line) {
+			return string(line[:len(line)-len(trimmed)])
+		}
+	}
+	return "    "
+}
+
+// indentJSON returns the JSON in data indented by indent, ending with a newline.
+func indentJSON(data []byte, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", indent); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// removeJSONPath returns the JSON value without the members and elements matching the given JSON pointer tokens.
+func removeJSONPath(value json.RawMessage, tokens []string) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(value)
+	if len(tokens) == 0 || len(trimmed) == 0 {
+		return value, nil
+	}
+	switch trimmed[0] {
+	case '{':
+		members, err := decodeObject(value)
+		if err != nil {
+			return nil, err
+		}
+		var kept []jsonMember
+		for _, member := range members {
+			if tokens[0] == "*" || tokens[0] == member.name {
+				if len(tokens) == 1 {
+					continue
+				}
+				if member.value, err = removeJSONPath(member.value, tokens[1:]); err != nil {
+					return nil, err
+				}
+			}
+			kept = append(kept, member)
+		}
+		return encodeObject(kept), nil
+	case '[':
+		index, err := strconv.Atoi(tokens[0])
+		if tokens[0] != "*" && err != nil {
+			return value, nil
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return nil, err
+		}
+		var kept []json.RawMessage
+		for i, element := range elements {
+			if tokens[0] == "*" || i == index {
+				if len(tokens) == 1 {
+					continue
+				}
+				if element, err = removeJSONPath(element, tokens[1:]); err != nil {
+					return nil, err
+				}
+			}
+			kept = append(kept, element)
+		}
+		return encodeArray(kept), nil
+	}
+	return value, nil
+}
+
+func encodeArray(elements []json.RawMessage) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, element := range elements {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.Write(element)
+	}
+	buf.WriteString("]")
+	return buf.Bytes()
+}
+
+func getMember(members []jsonMember, name string) json.RawMessage {
+	for _, m := range members {
+		if m.name == name {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// setMember returns members with the member of given name set to value, replacing the member if it exists, or
+// appending it.
+func setMember(members []jsonMember, name string, value json.RawMessage) []jsonMember {
+	for i, m := range members {
+		if m.name == name {
+			members[i].value = value
+			return members
+		}
+	}
+	return append(members, jsonMember{name: name, value: value})
+}
+
+func removeMember(members []jsonMember, name string) []jsonMember {
+	var kept []jsonMember
+	for _, m := range members {
+		if m.name != name {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
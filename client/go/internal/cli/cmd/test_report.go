@@ -0,0 +1,261 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Reports of vespa test runs
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var colorCode = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// testReport collects the results of each step of the tests run by vespa test.
+type testReport struct {
+	mu    sync.Mutex
+	files []*testFileResult
+}
+
+type testFileResult struct {
+	name  string
+	path  string
+	steps []testStepResult
+}
+
+type testStepResult struct {
+	name     string
+	status   string // One of passed, failed, error and skipped
+	duration time.Duration
+	// message is the short description of a failure or error, and details the longer one, including the request and
+	// response causing a failure
+	message string
+	details string
+}
+
+// startFile records the start of the test named name at path. It returns nil if r is nil, i.e. if no report is wanted.
+func (r *testReport) startFile(name, path string) *testFileResult {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file := &testFileResult{name: name, path: path}
+	r.files = append(r.files, file)
+	return file
+}
+
+// addStep records the result of a step. An error takes precedence over a failure.
+func (f *testFileResult) addStep(name string, duration time.Duration, failure, longFailure string, err error) {
+	if f == nil {
+		return
+	}
+	result := testStepResult{name: name, status: "passed", duration: duration}
+	if err != nil {
+		result.status = "error"
+		result.message = err.Error()
+	} else if failure != "" {
+		result.status = "failed"
+		result.message = stripColor(failure)
+		result.details = stripColor(longFailure)
+	}
+	f.steps = append(f.steps, result)
+}
+
+// skipSteps records the given steps as skipped, because a previous step failed.
+func (f *testFileResult) skipSteps(names []string) {
+	if f == nil {
+		return
+	}
+	for _, name := range names {
+		f.steps = append(f.steps, testStepResult{name: name, status: "skipped"})
+	}
+}
+
+func (f *testFileResult) count(status string) int {
+	n := 0
+	for _, s := range f.steps {
+		if s.status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *testFileResult) duration() time.Duration {
+	var d time.Duration
+	for _, s := range f.steps {
+		d += s.duration
+	}
+	return d
+}
+
+func stripColor(s string) string { return colorCode.ReplaceAllString(s, "") }
+
+// testReportOutput is a report to write, in format junit or json.
+type testReportOutput struct {
+	format string
+	path   string
+}
+
+// parseTestReports parses report flag values on the form format=path.
+func parseTestReports(values []string) ([]testReportOutput, error) {
+	var outputs []testReportOutput
+	for _, v := range values {
+		format, path, ok := strings.Cut(v, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid report %q: must be on the form format=path", v)
+		}
+		if format != "junit" && format != "json" {
+			return nil, fmt.Errorf("invalid report format %q: must be 'junit' or 'json'", format)
+		}
+		outputs = append(outputs, testReportOutput{format: format, path: path})
+	}
+	return outputs, nil
+}
+
+// write writes report r to each of outputs, with tests sorted by path, as they may have run in parallel.
+func (r *testReport) write(outputs []testReportOutput) error {
+	sort.SliceStable(r.files, func(i, j int) bool { return r.files[i].path < r.files[j].path })
+	for _, output := range outputs {
+		var data []byte
+		var err error
+		switch output.format {
+		case "junit":
+			data, err = r.junit()
+		case "json":
+			data, err = json.MarshalIndent(r.jsonReport(), "", "  ")
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(output.path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("could not write %s report: %w", output.format, err)
+		}
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	File      string          `xml:"file,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",cdata"`
+}
+
+func junitTime(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
+
+func (r *testReport) junit() ([]byte, error) {
+	suites := junitTestSuites{}
+	var total time.Duration
+	for _, f := range r.files {
+		suite := junitTestSuite{
+			Name:     f.name,
+			File:     f.path,
+			Tests:    len(f.steps),
+			Failures: f.count("failed"),
+			Errors:   f.count("error"),
+			Skipped:  f.count("skipped"),
+			Time:     junitTime(f.duration()),
+		}
+		for _, s := range f.steps {
+			testCase := junitTestCase{Name: s.name, ClassName: f.name, Time: junitTime(s.duration)}
+			switch s.status {
+			case "failed":
+				testCase.Failure = &junitProblem{Message: s.message, Body: s.details}
+			case "error":
+				testCase.Error = &junitProblem{Message: s.message}
+			case "skipped":
+				testCase.Skipped = &struct{}{}
+			}
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		total += f.duration()
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = junitTime(total)
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+type jsonTestReport struct {
+	Tests      int              `json:"tests"`
+	Failures   int              `json:"failures"`
+	Errors     int              `json:"errors"`
+	Skipped    int              `json:"skipped"`
+	DurationMs int64            `json:"durationMs"`
+	Files      []jsonTestResult `json:"files"`
+}
+
+type jsonTestResult struct {
+	Name       string           `json:"name"`
+	Path       string           `json:"path"`
+	DurationMs int64            `json:"durationMs"`
+	Steps      []jsonStepResult `json:"steps"`
+}
+
+type jsonStepResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	Message    string `json:"message,omitempty"`
+	Details    string `json:"details,omitempty"`
+}
+
+func (r *testReport) jsonReport() jsonTestReport {
+	report := jsonTestReport{Files: []jsonTestResult{}}
+	for _, f := range r.files {
+		result := jsonTestResult{Name: f.name, Path: f.path, DurationMs: f.duration().Milliseconds(), Steps: []jsonStepResult{}}
+		for _, s := range f.steps {
+			result.Steps = append(result.Steps, jsonStepResult{Name: s.name, Status: s.status, DurationMs: s.duration.Milliseconds(), Message: s.message, Details: s.details})
+		}
+		report.Tests += len(f.steps)
+		report.Failures += f.count("failed")
+		report.Errors += f.count("error")
+		report.Skipped += f.count("skipped")
+		report.DurationMs += result.DurationMs
+		report.Files = append(report.Files, result)
+	}
+	return report
+}
@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
@@ -12,6 +13,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -53,6 +55,293 @@ func TestSuite(t *testing.T) {
 	assert.Equal(t, "", stderr.String())
 }
 
+func TestSuiteReport(t *testing.T) {
+	client := &mock.HTTPClient{}
+	searchResponse, _ := os.ReadFile("testdata/tests/response.json")
+	mockServiceStatus(client, "container")
+	client.NextStatus(200)
+	client.NextStatus(200)
+	for range 2 {
+		client.NextResponseString(200, string(searchResponse))
+	}
+	mockServiceStatus(client, "container")
+	for range 9 {
+		client.NextResponseString(200, string(searchResponse))
+	}
+	cli, _, _ := newTestCLI(t)
+	cli.httpClient = client
+	cli.now = func() time.Time { return time.Unix(0, 0) }
+	dir := t.TempDir()
+	junitFile := filepath.Join(dir, "report.xml")
+	jsonFile := filepath.Join(dir, "report.json")
+	assert.NotNil(t, cli.Run("test", "testdata/tests/system-test", "--report", "junit="+junitFile, "--report", "json="+jsonFile))
+
+	junit, err := os.ReadFile(junitFile)
+	require.Nil(t, err)
+	assert.Contains(t, string(junit), `<testsuites tests="13" failures="9" errors="0" skipped="0" time="0.000">`)
+	assert.Contains(t, string(junit), `<testsuite name="My test" file="testdata/tests/system-test/test.json" tests="4" failures="0" errors="0" skipped="0" time="0.000">`)
+	assert.Contains(t, string(junit), `<testcase name="Step 1" classname="wrong-code.json" time="0.000">
+      <failure message="Unexpected status code: 200"><![CDATA[Unexpected status code
+Expected: 123
+Actual:   200
+Requested: GET at http://127.0.0.1:8080/search/?foo=%2F
+Response:
+{`)
+
+	var report jsonTestReport
+	data, err := os.ReadFile(jsonFile)
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &report))
+	assert.Equal(t, 13, report.Tests)
+	assert.Equal(t, 9, report.Failures)
+	require.Len(t, report.Files, 10)
+	assert.Equal(t, "My test", report.Files[0].Name)
+	assert.Equal(t, "passed", report.Files[0].Steps[0].Status)
+	assert.Equal(t, "failed", report.Files[2].Steps[0].Status)
+}
+
+func TestReportSkipsStepsAfterFailure(t *testing.T) {
+	client := &mock.HTTPClient{}
+	mockServiceStatus(client, "container")
+	client.NextStatus(500)
+	cli, _, _ := newTestCLI(t)
+	cli.httpClient = client
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	assert.NotNil(t, cli.Run("test", "testdata/tests/system-test/test.json", "--report", "json="+reportFile))
+	var report jsonTestReport
+	data, err := os.ReadFile(reportFile)
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &report))
+	require.Len(t, report.Files, 1)
+	steps := report.Files[0].Steps
+	require.Len(t, steps, 4)
+	assert.Equal(t, "failed", steps[0].Status)
+	assert.Equal(t, "Unexpected status code: 500", steps[0].Message)
+	for _, step := range steps[1:] {
+		assert.Equal(t, "skipped", step.Status)
+	}
+}
+
+func TestInvalidReport(t *testing.T) {
+	cli, _, stderr := newTestCLI(t)
+	assert.NotNil(t, cli.Run("test", "testdata/tests/system-test/test.json", "--report", "xml=report.xml"))
+	assert.Equal(t, "Error: invalid report format \"xml\": must be 'junit' or 'json'\n", stderr.String())
+}
+
+func TestParallelSuite(t *testing.T) {
+	client := &mock.HTTPClient{}
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	err := cli.Run("test", "testdata/tests/parallel", "--parallel", "3")
+	require.NotNil(t, err)
+	assert.Equal(t, exitTestsFailed, err.(ErrCLI).Status)
+	assert.Equal(t, "", stderr.String())
+	assert.True(t, strings.HasPrefix(stdout.String(), "prepare: . OK\n"), stdout.String())
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.Contains(t, stdout.String(), "\nquery "+name+": .. OK\n")
+	}
+	assert.Contains(t, stdout.String(), "wrong code: failed\nStep 1:\nUnexpected status code\nExpected: 201\nActual:   200\n")
+	assert.True(t, strings.HasSuffix(stdout.String(), "\nFailure: 1 of 6 tests failed in 0s:\nwrong code: Step 1: Unexpected status code: 200\n"), stdout.String())
+	require.Len(t, client.Requests, 10)
+	assert.Equal(t, "https://my.service/prepare", client.Requests[0].URL.String())
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("test", "testdata/tests/parallel", "--parallel", "0"))
+	assert.Equal(t, "Error: invalid parallel: 0: must be at least 1\n", stderr.String())
+}
+
+func TestVariables(t *testing.T) {
+	run := func(responseID string, args ...string) (*mock.HTTPClient, string, string, error) {
+		client := &mock.HTTPClient{ReadBody: true}
+		client.NextResponseString(200, `{"id": "id:ns:music::`+responseID+`"}`)
+		cli, stdout, stderr := newTestCLI(t, "SERVICE_HOST=my.service", "NO_COLOR=true")
+		cli.httpClient = client
+		err := cli.Run(append([]string{"test"}, args...)...)
+		return client, stdout.String(), stderr.String(), err
+	}
+	client, stdout, stderr, err := run("42", "testdata/tests/variables/variables.json", "--param-file", "testdata/tests/params.json", "--param", "id=42", "--param", "hits=10")
+	assert.Nil(t, err)
+	assert.Equal(t, "variables: . OK\n\nSuccess: 1 test OK in 0s\n", stdout)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, "https://my.service/document/v1/ns/music/docid/42?hits=10", client.LastRequest.URL.String())
+	assert.Equal(t, "small", client.LastRequest.Header.Get("X-Dataset"))
+	assert.Equal(t, `{"fields":{"title":"Song 42 of 100"}}`, string(client.LastBody))
+
+	_, stdout, _, err = run("42", "testdata/tests/variables/variables.json", "--param-file", "testdata/tests/params.json", "--param", "id=43", "--param", "hits=10")
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Expected: \"id:ns:music::43\"\nActual:   \"id:ns:music::42\"")
+
+	_, stdout, _, err = run("42", "testdata/tests/variables/undefined.json")
+	assert.NotNil(t, err)
+	assert.Equal(t, "undefined: failed\nStep 1:\nUndefined variable param.query at testdata/tests/variables/undefined.json:8\n\nFailure: 1 of 1 test failed in 0s:\nundefined: Step 1: Undefined variable param.query at testdata/tests/variables/undefined.json:8\n", stdout)
+
+	_, stdout, _, err = run("42", "testdata/tests/variables/variables.json", "--param", "hits=10")
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Undefined variable param.id at testdata/tests/variables/variables.json:18")
+
+	_, _, stderr, err = run("42", "testdata/tests/variables/variables.json", "--param", "hits")
+	assert.NotNil(t, err)
+	assert.Equal(t, "Error: invalid parameter \"hits\": must be on the form name=value\n", stderr)
+}
+
+func TestHeaders(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "headers.json")
+	require.Nil(t, os.WriteFile(testFile, []byte(`{"steps": [
+  {"request": {"uri": "/search/", "headers": {"X-Dataset": "small"}}},
+  {"request": {"uri": "https://my.service/search/"}}
+]}`), 0644))
+	client := &mock.HTTPClient{}
+	mockServiceStatus(client, "container")
+	client.NextStatus(200)
+	client.NextStatus(200)
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("config", "set", "data-plane-headers", `{"X-Org-Token": "config", "X-Dataset": "large"}`))
+	require.Nil(t, cli.Run("test", testFile, "--header", "X-Org-Token: flag"))
+	assert.Equal(t, "", stderr.String())
+	require.Len(t, client.Requests, 3)
+	// Headers of the step take precedence, and external endpoints get no added headers
+	assert.Equal(t, "flag", client.Requests[1].Header.Get("X-Org-Token"))
+	assert.Equal(t, "small", client.Requests[1].Header.Get("X-Dataset"))
+	assert.Equal(t, "", client.Requests[2].Header.Get("X-Org-Token"))
+}
+
+func TestSuiteSetupAndTeardown(t *testing.T) {
+	run := func(statuses []int, args ...string) ([]string, string, error) {
+		client := &mock.HTTPClient{}
+		for _, status := range statuses {
+			client.NextStatus(status)
+		}
+		cli, stdout, _ := newTestCLI(t, "NO_COLOR=true")
+		cli.httpClient = client
+		err := cli.Run(append([]string{"test", "testdata/tests/suite"}, args...)...)
+		var paths []string
+		for _, request := range client.Requests {
+			paths = append(paths, request.URL.Path)
+		}
+		return paths, stdout.String(), err
+	}
+
+	paths, stdout, err := run(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/setup", "/query", "/teardown"}, paths)
+	assert.Equal(t, "setup.json: . OK\nquery: . OK\nteardown.json: . OK\n\nSuccess: 1 test OK in 0s\n", stdout)
+
+	paths, stdout, err = run([]int{200, 500})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"/setup", "/query", "/teardown"}, paths)
+	assert.True(t, strings.HasSuffix(stdout, "\nFailure: 1 of 1 test failed in 0s:\nquery: Step 1: Unexpected status code: 500\n"), stdout)
+
+	paths, stdout, err = run([]int{500})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"/setup", "/teardown"}, paths)
+	assert.True(t, strings.HasSuffix(stdout, "\nFailure: setup failed, so no tests were run:\nsetup.json: Step 1: Unexpected status code: 500\n"), stdout)
+
+	paths, stdout, err = run([]int{200, 200, 500})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"/setup", "/query", "/teardown"}, paths)
+	assert.True(t, strings.HasSuffix(stdout, "\nSuccess: 1 test OK in 0s\nFailure: teardown failed:\nteardown.json: Step 1: Unexpected status code: 500\n"), stdout)
+
+	paths, _, err = run(nil, "--skip-teardown")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/setup", "/query"}, paths)
+}
+
+func TestRetry(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"root":{"fields":{"totalCount":0}}}`)
+	client.NextResponseString(200, `{"root":{"fields":{"totalCount":0}}}`)
+	client.NextResponseString(200, `{"root":{"fields":{"totalCount":1}}}`)
+	cli, stdout, _ := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("test", "testdata/tests/retry/retry.json"))
+	assert.Equal(t, "retry: . OK (Step 1 passed after 3 attempts)\n\nSuccess: 1 test OK in 0s\n", stdout.String())
+	assert.Len(t, client.Requests, 3)
+
+	client = &mock.HTTPClient{}
+	for range 200 {
+		client.NextResponseString(200, `{"root":{"fields":{"totalCount":0}}}`)
+	}
+	cli, stdout, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("test", "testdata/tests/retry/retry.json"))
+	assert.Regexp(t, `\nFailed after \d+ attempts in \d+ms\n`, stdout.String())
+	assert.Regexp(t, `retry: Step 1: Unexpected value at /root/fields/totalCount: 0 \(after \d+ attempts\)\n$`, stdout.String())
+
+	cli, _, stderr := newTestCLI(t)
+	assert.NotNil(t, cli.Run("test", "testdata/tests/retry/write.json"))
+	assert.Equal(t, "\nError: invalid retry in Step 1 of testdata/tests/retry/write.json: only GET requests and POST queries to /search/ may be retried, but this is a POST to /document/v1/ns/music/docid/1\nHint: See https://docs.vespa.ai/en/reference/testing\n", stderr.String())
+}
+
+func TestOperators(t *testing.T) {
+	testPath := filepath.Join(t.TempDir(), "operators.json")
+	writeTest := func(response string) {
+		testJSON := `{"name": "operators", "steps": [{"request": {"uri": "https://my.service/search/"}, "response": ` + response + `}]}`
+		require.Nil(t, os.WriteFile(testPath, []byte(testJSON), 0644))
+	}
+	searchResponse := `{"root":{"id":"toplevel","fields":{"totalCount":3},"children":[{"id":"id:ns:music::1"},{"id":"id:ns:music::2"}]}}`
+	run := func(response string, status int) (string, string, error) {
+		writeTest(response)
+		client := &mock.HTTPClient{}
+		client.NextResponse(mock.HTTPResponse{Status: status, Body: []byte(searchResponse), Header: http.Header{"Content-Type": []string{"application/json; charset=UTF-8"}}})
+		cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+		cli.httpClient = client
+		err := cli.Run("test", testPath)
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, _, err := run(`{"code": "2xx",
+                            "headers": {"content-type": {"$regex": "^application/json"}, "X-Missing": {"$exists": false}},
+                            "body": {"root": {"id": {"$regex": "top"},
+                                              "fields": {"totalCount": {"$gte": 3, "$lt": 4}, "missing": {"$exists": false}},
+                                              "children": {"$length": {"$gt": 1, "$lte": 2}},
+                                              "coverage": {"$exists": false}}}}`, 201)
+	assert.Nil(t, err)
+	assert.Equal(t, "operators: . OK\n\nSuccess: 1 test OK in 0s\n", stdout)
+
+	stdout, _, err = run(`{"body": {"root": {"fields": {"totalCount": {"$gt": 3}}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "operators: Step 1: Unexpected value for $gt at /root/fields/totalCount: 3\n")
+	assert.Contains(t, stdout, "\nExpected: $gt 3\nActual:   3\n")
+
+	stdout, _, err = run(`{"body": {"root": {"children": {"$length": 3}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Unexpected value for $length at /root/children: 2\n")
+
+	stdout, _, err = run(`{"body": {"root": {"children": {"$length": {"$gte": 3}}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Unexpected value for $gte at /root/children ($length): 2\n")
+
+	stdout, _, err = run(`{"body": {"root": {"id": {"$exists": false}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Unexpected value for $exists at /root/id: \"toplevel\"\n")
+
+	stdout, _, err = run(`{"body": {"root": {"missing": {"$regex": "foo"}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Missing expected field at /root/missing\n")
+
+	stdout, _, err = run(`{"code": "4xx"}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "\nExpected: 4xx\nActual:   200\n")
+
+	stdout, _, err = run(`{"headers": {"CONTENT-TYPE": "text/plain"}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Unexpected value at header Content-Type: \"application/json; charset=UTF-8\"\n")
+	assert.Contains(t, stdout, "\nExpected: \"text/plain\"\n")
+
+	stdout, _, err = run(`{"headers": {"X-Missing": "foo"}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stdout, "Missing expected header X-Missing")
+
+	_, stderr, err := run(`{"body": {"root": {"id": {"$starts": "top"}}}}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stderr, "Error: error in Step 1: unknown operator $starts at /root/id: must be one of $exists, $gt, $gte, $length, $lt, $lte or $regex\n")
+
+	_, stderr, err = run(`{"code": "2yy"}`, 200)
+	assert.NotNil(t, err)
+	assert.Contains(t, stderr, `invalid status code "2yy"`)
+}
+
 func TestIllegalFileReference(t *testing.T) {
 	client := &mock.HTTPClient{}
 	client.NextStatus(200)
@@ -81,7 +370,7 @@ func TestProductionTest(t *testing.T) {
 	cli, stdout, stderr := newTestCLI(t)
 	cli.httpClient = client
 	assert.Nil(t, cli.Run("test", "testdata/tests/production-test/external.json"))
-	assert.Equal(t, "external.json: . OK\n\nSuccess: 1 test OK\n", stdout.String())
+	assert.Equal(t, "external.json: . OK\n\nSuccess: 1 test OK in 0s\n", stdout.String())
 	assert.Equal(t, "", stderr.String())
 	assertRequests([]*http.Request{createRequest("GET", "https://my.service:123/path?query=wohoo", "")}, client, t)
 }
@@ -159,6 +448,357 @@ func TestSingleTestWithCloudAndEndpoints(t *testing.T) {
 	assertRequests([]*http.Request{createFeedRequest(baseUrl), createFeedRequest(baseUrl), createSearchRequest(rawUrl), createRequestWithCustomHeader(rawUrl)}, client, t)
 }
 
+func TestRecord(t *testing.T) {
+	testPath := filepath.Join(t.TempDir(), "record.json")
+	testJSON := `{
+    "name": "record",
+    "steps": [
+        {
+            "request": {
+                "uri": "https://my.service/search/?query=foo"
+            },
+            "response": {
+                "body": {
+                    "root": {
+                        "fields": {
+                            "totalCount": 0
+                        }
+                    }
+                }
+            }
+        },
+        {
+            "request": {
+                "method": "POST",
+                "uri": "https://my.service/document/v1/ns/music/docid/1",
+                "body": {
+                    "fields": {
+                        "title": "foo"
+                    }
+                }
+            }
+        }
+    ]
+}
+`
+	require.Nil(t, os.WriteFile(testPath, []byte(testJSON), 0644))
+	searchResponse := `{"timing":{"querytime":0.011},"root":{"id":"toplevel","coverage":{"full":true},"fields":{"totalCount":1},"children":[{"id":"id:ns:music::1","relevance":0.25}]}}`
+	mockResponses := func(client *mock.HTTPClient) {
+		client.NextResponseString(200, searchResponse)
+		client.NextResponseString(400, `{"message":"invalid document"}`)
+	}
+
+	client := &mock.HTTPClient{}
+	mockResponses(client)
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("test", testPath, "--record", "--record-ignore", "/timing", "--record-ignore", "/root/children/*/relevance"))
+	assert.Equal(t, "", stderr.String())
+	recorded, err := os.ReadFile(testPath)
+	require.Nil(t, err)
+	assert.Equal(t, `{
+    "name": "record",
+    "steps": [
+        {
+            "request": {
+                "uri": "https://my.service/search/?query=foo"
+            },
+            "response": {
+                "body": {
+                    "root": {
+                        "id": "toplevel",
+                        "coverage": {
+                            "full": true
+                        },
+                        "fields": {
+                            "totalCount": 1
+                        },
+                        "children": [
+                            {
+                                "id": "id:ns:music::1"
+                            }
+                        ]
+                    }
+                }
+            }
+        },
+        {
+            "request": {
+                "method": "POST",
+                "uri": "https://my.service/document/v1/ns/music/docid/1",
+                "body": {
+                    "fields": {
+                        "title": "foo"
+                    }
+                }
+            },
+            "response": {
+                "code": 400,
+                "body": {
+                    "message": "invalid document"
+                }
+            }
+        }
+    ]
+}
+`, string(recorded))
+	output := stdout.String()
+	assert.True(t, strings.HasPrefix(output, "record: .. recorded\n"), output)
+	assert.Contains(t, output, "    --- "+testPath+"\n")
+	assert.Contains(t, output, "    -                            \"totalCount\": 0\n")
+	assert.Contains(t, output, "    +                            \"totalCount\": 1\n")
+	assert.Contains(t, output, "    +                \"code\": 400,\n")
+	assert.True(t, strings.HasSuffix(output, "\nSuccess: 1 test recorded in 0s\n"), output)
+
+	client = &mock.HTTPClient{}
+	mockResponses(client)
+	cli, stdout, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("test", testPath))
+	assert.Equal(t, "record: .. OK\n\nSuccess: 1 test OK in 0s\n", stdout.String())
+
+	client = &mock.HTTPClient{}
+	mockResponses(client)
+	cli, stdout, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("test", testPath, "--record", "--record-ignore", "/timing", "--record-ignore", "/root/children/*/relevance"))
+	assert.Equal(t, "record: .. unchanged\n\nSuccess: 1 test recorded in 0s\n", stdout.String())
+
+	// Volatile members are not recorded by default
+	client = &mock.HTTPClient{}
+	mockResponses(client)
+	cli, _, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("test", testPath, "--record"))
+	recorded, err = os.ReadFile(testPath)
+	require.Nil(t, err)
+	assert.NotContains(t, string(recorded), "coverage")
+	assert.NotContains(t, string(recorded), "timing")
+	assert.Contains(t, string(recorded), "relevance")
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("test", testPath, "--record", "--record-ignore", "timing"))
+	assert.Equal(t, "Error: invalid ignore path: \"timing\": must be a JSON pointer starting with '/'\nHint: Example: --record-ignore /root/children/*/relevance\n", stderr.String())
+}
+
+func TestRecordProduction(t *testing.T) {
+	apiKey, err := vespa.CreateAPIKey()
+	require.Nil(t, err)
+	cli, _, stderr := newTestCLI(t, "VESPA_CLI_API_KEY="+string(apiKey))
+	assert.NotNil(t, cli.Run("test", "testdata/tests/production-test/external.json", "--record", "-t", "cloud", "-a", "t.a.i", "-z", "prod.aws-us-east-1c"))
+	assert.Equal(t, "Error: refusing to record responses from production deployment of t.a.i in prod.aws-us-east-1c\nHint: Record against a dev or perf deployment instead\nHint: Use --force to record anyway\n", stderr.String())
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+	require.Nil(t, os.WriteFile(logPath, []byte(`{"ip":"::1","time":1704067200.0,"duration":0.011,"code":200,"method":"GET","uri":"/search/?yql=select+*+from+music+where+year+%3C+2000&hits=5"}
+{"ip":"::1","time":1704067201.0,"duration":0.012,"code":200,"method":"GET","uri":"/search/?hits=5&yql=select+*+from+music+where+year+%3C+2000"}
+{"ip":"::1","time":1704067202.0,"duration":0.001,"code":400,"method":"GET","uri":"/search/?yql=select"}
+{"ip":"::1","time":1704067203.0,"duration":0.002,"code":200,"method":"GET","uri":"/document/v1/ns/music/docid/1"}
+select * from music where title contains "love"
+{"yql": "select * from music where true", "ranking": {"profile": "popular"}}
+127.0.0.1 - - [01/Jan/2024:00:00:00 +0000] "GET /search/?query=foo HTTP/1.1" 200 123
+`), 0644))
+	testsDir := filepath.Join(dir, "tests")
+	searchResponse := `{"timing":{"querytime":0.011},"root":{"id":"toplevel","coverage":{"full":true},"fields":{"totalCount":1}}}`
+	mockResponses := func(client *mock.HTTPClient) {
+		mockServiceStatus(client, "container")
+		for range 3 {
+			client.NextResponseString(200, searchResponse)
+		}
+	}
+
+	client := &mock.HTTPClient{}
+	mockResponses(client)
+	cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("test", "generate", "--from-log", logPath, "--output", testsDir, "--limit", "3"))
+	assert.True(t, client.Consumed())
+	assert.Equal(t, `select * from music where year < 2000: . recorded
+select * from music where title contains "love": . recorded
+select * from music where true: . recorded
+
+Success: 3 tests generated in `+testsDir+"\n", stdout.String())
+	assert.Equal(t, "Run them with 'vespa test "+testsDir+"'\n", stderr.String())
+	assert.Equal(t, "POST", client.Requests[3].Method)
+	generated, err := os.ReadFile(filepath.Join(testsDir, "query-001.json"))
+	require.Nil(t, err)
+	assert.Equal(t, `{
+    "name": "select * from music where year < 2000",
+    "steps": [
+        {
+            "request": {
+                "uri": "/search/",
+                "parameters": {
+                    "hits": "5",
+                    "yql": "select * from music where year < 2000"
+                }
+            },
+            "response": {
+                "body": {
+                    "root": {
+                        "id": "toplevel",
+                        "fields": {
+                            "totalCount": 1
+                        }
+                    }
+                }
+            }
+        }
+    ]
+}
+`, string(generated))
+	generated, err = os.ReadFile(filepath.Join(testsDir, "query-003.json"))
+	require.Nil(t, err)
+	assert.Contains(t, string(generated), `"method": "POST"`)
+	assert.Contains(t, string(generated), `"profile": "popular"`)
+
+	// The generated tests pass
+	client = &mock.HTTPClient{}
+	mockResponses(client)
+	cli, stdout, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("test", testsDir))
+	assert.True(t, strings.HasSuffix(stdout.String(), "\nSuccess: 3 tests OK in 0s\n"), stdout.String())
+
+	// Existing tests are not overwritten without --force
+	cli, _, stderr = newTestCLI(t, "NO_COLOR=true")
+	assert.NotNil(t, cli.Run("test", "generate", "--from-log", logPath, "--output", testsDir))
+	assert.Equal(t, "Error: refusing to overwrite existing test "+filepath.Join(testsDir, "query-001.json")+"\nHint: Use --force to overwrite it\nHint: Use --output to write the tests to another directory\n", stderr.String())
+	_, err = os.Stat(filepath.Join(testsDir, "query-004.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	client = &mock.HTTPClient{}
+	mockServiceStatus(client, "container")
+	cli, stdout, _ = newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("test", "generate", "--from-log", logPath, "--output", testsDir, "--force"))
+	assert.Contains(t, stdout.String(), "foo: . recorded\n\nSuccess: 4 tests generated")
+
+	cli, _, stderr = newTestCLI(t)
+	assert.NotNil(t, cli.Run("test", "generate", "--from-log", filepath.Join(testsDir, "query-001.json")))
+	assert.Equal(t, "Error: no queries found in "+filepath.Join(testsDir, "query-001.json")+"\nHint: Give a query access log, or a file with one query URL, YQL string or JSON query body per line\n", stderr.String())
+}
+
+func TestCoverage(t *testing.T) {
+	testsDir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(testsDir, "feed-and-query.json"), []byte(`{
+  "steps": [
+    {"request": {"method": "POST", "uri": "/document/v1/ns/music/docid/a", "body": {"fields": {"title": "A"}}}},
+    {"request": {"method": "POST", "uri": "/search/", "body": {"yql": "select * from music where true", "ranking": {"profile": "semantic"}}}},
+    {"request": {"uri": "/search/?yql=select+*+from+sources+*+where+true"}},
+    {"request": {"uri": "/my-handler/a"}},
+    {"request": {"uri": "https://external.example/status"}}
+  ]
+}`), 0644))
+	contentURL := "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content"
+	mockFetch := func(client *mock.HTTPClient) {
+		client.NextResponseString(200, `{"generation": 3}`)
+		client.NextResponseString(200, `["`+contentURL+`/schemas/", "`+contentURL+`/services.xml"]`)
+		client.NextResponseString(200, `["`+contentURL+`/schemas/music.sd", "`+contentURL+`/schemas/lyrics.sd"]`)
+		client.NextResponseString(200, `schema music {
+    document music {
+        field title type string {}
+    }
+    rank-profile semantic {}
+    rank-profile popular {}
+}`)
+		client.NextResponseString(200, `schema lyrics { document lyrics {} }`)
+		client.NextResponseString(200, `<services version="1.0">
+  <container id="default" version="1.0">
+    <search/>
+    <document-api/>
+    <handler id="com.example.Handler">
+      <binding>http://*/my-handler/*</binding>
+    </handler>
+    <handler id="com.example.Other">
+      <binding>http://*/other</binding>
+    </handler>
+  </container>
+</services>`)
+	}
+	run := func(args ...string) (string, string) {
+		client := &mock.HTTPClient{}
+		mockServiceStatus(client, "container")
+		for range 5 {
+			client.NextStatus(200)
+		}
+		mockFetch(client)
+		cli, stdout, stderr := newTestCLI(t, "NO_COLOR=true")
+		cli.httpClient = client
+		require.Nil(t, cli.Run(append([]string{"test", testsDir, "--coverage"}, args...)...))
+		assert.True(t, client.Consumed())
+		return stdout.String(), stderr.String()
+	}
+
+	stdout, stderr := run()
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, `feed-and-query.json: ..... OK
+
+Success: 1 test OK in 0s
+
+KIND           NAME            COVERED  DETAILS
+document type  lyrics          yes      queried
+document type  music           yes      fed, queried
+handler        /document/v1/*  yes      1 request
+handler        /my-handler/*   yes      1 request
+handler        /other          no       -
+handler        /search/*       yes      2 requests
+rank profile   music/popular   no       -
+rank profile   music/semantic  yes      -
+
+2 of 8 items of the deployed application are not covered:
+  handler /other
+  rank profile music/popular
+`, stdout)
+
+	stdout, _ = run("--coverage-format", "json")
+	var report coverageReport
+	require.Nil(t, json.Unmarshal([]byte(stdout[strings.Index(stdout, "{"):]), &report))
+	assert.Equal(t, []documentTypeCoverage{{Name: "lyrics", Queried: true}, {Name: "music", Fed: true, Queried: true}}, report.DocumentTypes)
+	assert.Equal(t, uncoveredItems{DocumentTypes: []string{}, Handlers: []string{"/other"}, RankProfiles: []string{"music/popular"}}, report.Uncovered)
+
+	// Missing applications only give a warning
+	client := &mock.HTTPClient{}
+	mockServiceStatus(client, "container")
+	for range 5 {
+		client.NextStatus(200)
+	}
+	client.NextResponseString(404, `{"error-code": "NOT_FOUND"}`)
+	cli, _, stderrBuf := newTestCLI(t, "NO_COLOR=true")
+	cli.httpClient = client
+	require.Nil(t, cli.Run("test", testsDir, "--coverage"))
+	assert.Equal(t, "Warning: Could not compute coverage of the deployed application: no application package is deployed\n", stderrBuf.String())
+
+	assert.NotNil(t, cli.Run("test", testsDir, "--coverage", "--coverage-format", "xml"))
+}
+
+func TestCoverageRecord(t *testing.T) {
+	coverage := newTestCoverage()
+	record := func(method, rawURL, body string) {
+		u, err := url.Parse(rawURL)
+		require.Nil(t, err)
+		coverage.record(method, u, []byte(body))
+	}
+	record("GET", "/document/v1/ns/album/docid/a", "")
+	record("PUT", "/document/v1/ns/music/docid/a", "")
+	record("GET", "/search/?query=a&restrict=music,lyrics&ranking=popular", "")
+	record("POST", "/search/", `{"yql": "select * from sources album where true", "ranking.profile": "semantic"}`)
+	assert.Equal(t, map[string]bool{"music": true}, coverage.fed)
+	assert.Equal(t, map[string]bool{"music": true, "lyrics": true, "album": true}, coverage.queried)
+	assert.False(t, coverage.queriedAll)
+	assert.Equal(t, map[vespa.RankProfile]bool{
+		{Schema: "music", Name: "popular"}:  true,
+		{Schema: "lyrics", Name: "popular"}: true,
+		{Schema: "album", Name: "semantic"}: true,
+	}, coverage.rankProfiles)
+
+	record("POST", "/search/", `{"yql": "select * from sources * where true"}`)
+	assert.True(t, coverage.queriedAll)
+	assert.True(t, coverage.rankProfiles[vespa.RankProfile{Name: "default"}])
+}
+
 func createFeedRequest(urlPrefix string) *http.Request {
 	return createRequest("POST",
 		urlPrefix+"/document/v1/test/music/docid/doc?timeout=3.4s",
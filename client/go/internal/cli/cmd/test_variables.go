@@ -0,0 +1,153 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Variable substitution in vespa test files
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(env|param)\.([^\s{}]+)\s*\}\}`)
+
+// testVariables holds the values substituted for placeholders like {{ env.NAME }} and {{ param.name }} in tests.
+type testVariables struct {
+	env    map[string]string
+	params map[string]string
+}
+
+// testSource is a file read by a test, used to locate placeholders for undefined variables.
+type testSource struct {
+	path string
+	data []byte
+}
+
+// line returns the line number of the first occurrence of text in this, or 0 if it is not found.
+func (s testSource) line(text string) int {
+	i := bytes.Index(s.data, []byte(text))
+	if i < 0 {
+		return 0
+	}
+	return bytes.Count(s.data[:i], []byte("\n")) + 1
+}
+
+type undefinedVariableError struct {
+	name string
+	path string
+	line int
+}
+
+func (e *undefinedVariableError) Error() string {
+	location := e.path
+	if e.line > 0 {
+		location += fmt.Sprintf(":%d", e.line)
+	}
+	return fmt.Sprintf("Undefined variable %s at %s", e.name, location)
+}
+
+// newTestVariables creates variables from environment env and parameters, where each parameter is on the form
+// name=value. Parameters are first read from the JSON object in paramFile, if non-empty, and then from params.
+func newTestVariables(env map[string]string, params []string, paramFile string) (*testVariables, error) {
+	v := &testVariables{env: env, params: make(map[string]string)}
+	if paramFile != "" {
+		data, err := os.ReadFile(paramFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read parameter file: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var values map[string]any
+		if err := dec.Decode(&values); err != nil {
+			return nil, fmt.Errorf("parameter file %s must be a JSON object: %w", paramFile, err)
+		}
+		for name, value := range values {
+			switch value := value.(type) {
+			case string:
+				v.params[name] = value
+			case json.Number, bool:
+				v.params[name] = fmt.Sprint(value)
+			default:
+				return nil, fmt.Errorf("parameter %s in %s must be a string, number or boolean", name, paramFile)
+			}
+		}
+	}
+	for _, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q: must be on the form name=value", param)
+		}
+		v.params[name] = value
+	}
+	return v, nil
+}
+
+// replace replaces all placeholders in s, which is read from source.
+func (v *testVariables) replace(s string, source testSource) (string, error) {
+	if v == nil {
+		return s, nil
+	}
+	var err error
+	replaced := placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		values := v.params
+		if match[1] == "env" {
+			values = v.env
+		}
+		value, ok := values[match[2]]
+		if !ok && err == nil {
+			err = &undefinedVariableError{name: match[1] + "." + match[2], path: source.path, line: source.line(placeholder)}
+		}
+		return value
+	})
+	return replaced, err
+}
+
+// substitute replaces all placeholders in the string values of the JSON in data, which is read from source. Data
+// without placeholders is returned as-is.
+func (v *testVariables) substitute(data []byte, source testSource) ([]byte, error) {
+	if v == nil || !placeholderPattern.Match(data) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	value, err := v.substituteValue(value, source)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (v *testVariables) substituteValue(value any, source testSource) (any, error) {
+	var err error
+	switch value := value.(type) {
+	case string:
+		return v.replace(value, source)
+	case []any:
+		for i := range value {
+			if value[i], err = v.substituteValue(value[i], source); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]any:
+		for k := range value {
+			if value[k], err = v.substituteValue(value[k], source); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,263 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Transformation of read documents by an external command, before they are printed
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// transformFlags holds the flags of a command which documents are piped through before they are printed.
+type transformFlags struct {
+	command     string
+	stream      bool
+	timeout     time.Duration
+	concurrency int
+}
+
+func addTransformFlags(cmd *cobra.Command, flags *transformFlags) {
+	cmd.Flags().StringVar(&flags.command, "transform-cmd", "", "Pipe each document, as JSON, through this shell command before printing it, e.g. to decrypt or redact fields")
+	cmd.Flags().BoolVar(&flags.stream, "transform-stream", false, "Run --transform-cmd once, writing documents to it as JSON lines, and reading one line of output per document")
+	cmd.Flags().DurationVar(&flags.timeout, "transform-timeout", 10*time.Second, "Maximum time --transform-cmd may spend on a document")
+	cmd.Flags().IntVar(&flags.concurrency, "transform-concurrency", 4, "Maximum number of --transform-cmd processes running at once")
+}
+
+// transformer returns the transformer configured by these flags, or nil if documents should not be transformed.
+func (f transformFlags) transformer() (*documentTransformer, error) {
+	if f.command == "" {
+		if f.stream {
+			return nil, fmt.Errorf("option --transform-stream requires --transform-cmd")
+		}
+		return nil, nil
+	}
+	if f.timeout <= 0 {
+		return nil, fmt.Errorf("invalid --transform-timeout: %s: must be positive", f.timeout)
+	}
+	if f.concurrency < 1 {
+		return nil, fmt.Errorf("invalid --transform-concurrency: %d: must be positive", f.concurrency)
+	}
+	return &documentTransformer{flags: f, slots: make(chan struct{}, f.concurrency)}, nil
+}
+
+// documentTransformer pipes documents through an external command. By default, the command is run once per document,
+// with the document on its standard input, and what it prints on standard output replaces the document. A non-zero
+// exit status fails that document only. With stream, a single process reads documents as JSON lines, and must print
+// one line per document, in order. A failure of that process fails all remaining documents.
+type documentTransformer struct {
+	flags transformFlags
+	// slots limits the number of processes running at once
+	slots    chan struct{}
+	failures atomic.Int64
+
+	mu     sync.Mutex
+	proc   *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	broken error
+}
+
+// transformError is the error of transforming a single document, such that other documents may still be transformed.
+type transformError struct {
+	id  string
+	err error
+}
+
+func (e *transformError) Error() string {
+	if e.id == "" {
+		return "transform failed: " + e.err.Error()
+	}
+	return "transform of " + e.id + " failed: " + e.err.Error()
+}
+
+func (e *transformError) Unwrap() error { return e.err }
+
+// shellCommand returns a command running command in the shell of the operating system.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// Processes started by the shell may keep its output open after it is killed
+	cmd.WaitDelay = time.Second
+	return cmd
+}
+
+// transform returns the document with given ID and JSON, as transformed by the command. Output which is valid JSON is
+// compacted, such that each document can be printed as a single line. The error is a *transformError if only this
+// document failed.
+func (t *documentTransformer) transform(id string, doc []byte) ([]byte, error) {
+	var out []byte
+	var err error
+	if t.flags.stream {
+		out, err = t.transformStream(id, doc)
+	} else {
+		out, err = t.transformOne(id, doc)
+	}
+	if err != nil {
+		t.failures.Add(1)
+		return nil, err
+	}
+	out = bytes.TrimRight(out, "\r\n")
+	var buf bytes.Buffer
+	if json.Compact(&buf, out) == nil {
+		return buf.Bytes(), nil
+	}
+	return out, nil
+}
+
+// transformAll transforms docs, concurrently up to the configured number of processes, and returns them in order. The
+// IDs of the documents are given in ids.
+func (t *documentTransformer) transformAll(ids []string, docs [][]byte) ([][]byte, []error) {
+	outs := make([][]byte, len(docs))
+	errs := make([]error, len(docs))
+	if t.flags.stream {
+		for i, doc := range docs {
+			outs[i], errs[i] = t.transform(ids[i], doc)
+		}
+		return outs, errs
+	}
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outs[i], errs[i] = t.transform(ids[i], doc)
+		}()
+	}
+	wg.Wait()
+	return outs, errs
+}
+
+func (t *documentTransformer) transformOne(id string, doc []byte) ([]byte, error) {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+	ctx, cancel := context.WithTimeout(context.Background(), t.flags.timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, t.flags.command)
+	cmd.Stdin = bytes.NewReader(doc)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, &transformError{id: id, err: fmt.Errorf("no result within %s", t.flags.timeout)}
+	}
+	if err != nil {
+		return nil, &transformError{id: id, err: processError(err, &stderr)}
+	}
+	return out, nil
+}
+
+// processError returns an error describing how a process failed with err, including what it printed to stderr.
+func processError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+func (t *documentTransformer) transformStream(id string, doc []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.broken != nil {
+		return nil, t.broken
+	}
+	if t.proc == nil {
+		if err := t.start(); err != nil {
+			t.broken = fmt.Errorf("could not start transform command: %w", err)
+			return nil, t.broken
+		}
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, doc); err != nil {
+		line.Reset()
+		line.Write(bytes.ReplaceAll(doc, []byte("\n"), []byte(" ")))
+	}
+	line.WriteByte('\n')
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if _, err := t.stdin.Write(line.Bytes()); err != nil {
+			done <- result{err: err}
+			return
+		}
+		out, err := t.stdout.ReadBytes('\n')
+		done <- result{out: out, err: err}
+	}()
+	select {
+	case r := <-done:
+		if r.err == nil {
+			return r.out, nil
+		}
+		// The process exited, or closed its output
+		t.proc.Process.Kill()
+		err := t.proc.Wait()
+		if err == nil {
+			err = errors.New("transform command exited before printing all documents")
+		} else {
+			err = fmt.Errorf("transform command failed: %w", processError(err, &t.stderr))
+		}
+		t.broken = err
+	case <-time.After(t.flags.timeout):
+		t.proc.Process.Kill()
+		t.proc.Wait()
+		t.broken = fmt.Errorf("transform command printed no result for %s within %s", id, t.flags.timeout)
+	}
+	return nil, t.broken
+}
+
+func (t *documentTransformer) start() error {
+	t.proc = shellCommand(context.Background(), t.flags.command)
+	var err error
+	if t.stdin, err = t.proc.StdinPipe(); err != nil {
+		return err
+	}
+	stdout, err := t.proc.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	t.stdout = bufio.NewReader(stdout)
+	t.proc.Stderr = &t.stderr
+	return t.proc.Start()
+}
+
+// close stops any process transforming a stream of documents, and returns an error if it failed. It also fails if any
+// document failed to be transformed.
+func (t *documentTransformer) close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.proc != nil && t.broken == nil {
+		t.stdin.Close()
+		if err := t.proc.Wait(); err != nil {
+			return fmt.Errorf("transform command failed: %w", processError(err, &t.stderr))
+		}
+	}
+	if t.broken != nil {
+		return t.broken
+	}
+	if n := t.failures.Load(); n > 0 {
+		return fmt.Errorf("transform failed for %d documents", n)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestDocumentGetTransform(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("transform commands are run by sh")
+	}
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "a"}}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::b", "fields": {"title": "fail"}}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::c", "fields": {"title": "c"}}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	command := `input=$(cat); case "$input" in *fail*) echo bad input >&2; exit 1;; esac; echo "$input" | sed 's/"title": "\(.\)"/"title": "\1\1"/'`
+	err := cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "jsonl", "--transform-cmd", command,
+		"id:ns:music::a", "id:ns:music::b", "id:ns:music::c")
+	require.NotNil(t, err)
+	assert.Equal(t, "transform failed for 1 documents", err.Error())
+	assert.Equal(t, `{"id":"id:ns:music::a","fields":{"title":"aa"}}
+{"id":"id:ns:music::c","fields":{"title":"cc"}}
+`, stdout.String())
+	assert.Equal(t, "Error: transform of id:ns:music::b failed: exit status 1: bad input\nError: transform failed for 1 documents\n", stderr.String())
+
+	client.NextResponseString(200, `{"id": "id:ns:music::a", "fields": {"title": "a"}}`)
+	client.NextResponseString(200, `{"id": "id:ns:music::b", "fields": {"title": "b"}}`)
+	cli, stdout, _ = newTestCLI(t)
+	cli.httpClient = client
+	require.Nil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--format", "jsonl", "--transform-cmd", `while read -r line; do echo "$line" | tr a-z A-Z; done`, "--transform-stream",
+		"id:ns:music::a", "id:ns:music::b"))
+	assert.Equal(t, `{"ID":"ID:NS:MUSIC::A","FIELDS":{"TITLE":"A"}}
+{"ID":"ID:NS:MUSIC::B","FIELDS":{"TITLE":"B"}}
+`, stdout.String())
+
+	client.NextResponseString(200, `{"id": "id:ns:music::a"}`)
+	cli, _, _ = newTestCLI(t)
+	cli.httpClient = client
+	err = cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--transform-cmd", "exec sleep 5", "--transform-timeout", "100ms", "id:ns:music::a")
+	require.NotNil(t, err)
+	assert.Equal(t, "transform failed for 1 documents", err.Error())
+
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--transform-stream", "id:ns:music::a"))
+	assert.NotNil(t, cli.Run("document", "get", "-t", "http://127.0.0.1:8080", "--transform-cmd", "cat", "--transform-concurrency", "0", "id:ns:music::a"))
+}
+
+func TestVisitTransform(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("transform commands are run by sh")
+	}
+	visit := func(args ...string) (string, string, error) {
+		cli, stdout, stderr := newTestCLI(t)
+		client := cli.httpClient.(*mock.HTTPClient)
+		client.NextResponseString(200, handlersResponse)
+		client.NextResponseString(200, normalpre+document1+","+document2+`],"documentCount":2,"continuation":"CAFE"}`)
+		client.NextResponseString(200, normalpre+document3+`],"documentCount":1}`)
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default"}, args...)
+		err := cli.Run(args...)
+		return stdout.String(), stderr.String(), err
+	}
+	// Output spanning several lines is printed as a single line
+	stdout, _, err := visit("--transform-cmd", `sed 's/"title":"t"/"title":"T"/' | awk '{ gsub(/,/, ",\n"); print }'`, "--transform-concurrency", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"id:t:m::1","fields":{"title":"T"}}
+`+document2+"\n"+document3+"\n", stdout)
+
+	stdout, stderr, err := visit("--transform-cmd", `grep -v xyzzy`)
+	require.NotNil(t, err)
+	assert.Equal(t, "visit failed: transform failed for 1 documents", err.Error())
+	assert.Equal(t, document1+"\n"+document2+"\n", stdout)
+	assert.Contains(t, stderr, "Error: transform of id:t:m::3 failed: exit status 1\n")
+
+	stdout, _, err = visit("--transform-cmd", `while read -r line; do echo "$line" | tr t T; done`, "--transform-stream")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":"id:T:m::1","fields":{"TiTle":"T"}}
+{"id":"id:T:m::2","fields":{"TiTle":"T2"}}
+{"id":"id:T:m::3","fields":{"ar":"xyz","w":63,"TiTle":"xyzzy","year":2000}}
+`, stdout)
+
+	_, _, err = visit("--transform-cmd", "head -n 1", "--transform-stream")
+	require.NotNil(t, err)
+	assert.Equal(t, "visit failed: Could not write documents: transform command exited before printing all documents", err.Error())
+
+	cli, _, _ := newTestCLI(t)
+	for _, args := range [][]string{
+		{"--transform-cmd", "cat", "--count"},
+		{"--transform-cmd", "cat", "--destination", "http://127.0.0.1:9090"},
+		{"--transform-stream"},
+	} {
+		assert.NotNil(t, cli.Run(append([]string{"visit", "-t", "http://127.0.0.1:8080"}, args...)...), args)
+	}
+}
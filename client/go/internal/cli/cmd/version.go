@@ -3,6 +3,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -15,26 +16,55 @@ import (
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/internal/build"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/version"
 )
 
+const (
+	// updateCheckInterval is the minimum interval between the checks for new releases made by other commands than
+	// version.
+	updateCheckInterval = 24 * time.Hour
+	// updateCheckWait is how long a command waits for a pending check for new releases, after it has completed.
+	updateCheckWait = time.Second
+)
+
 func newVersionCmd(cli *CLI) *cobra.Command {
-	var skipVersionCheck bool
+	var (
+		skipVersionCheck bool
+		upgradeArg       bool
+	)
 	cmd := &cobra.Command{
-		Use:               "version",
-		Short:             "Show current CLI version and check for updates",
+		Use:   "version",
+		Short: "Show current CLI version and check for updates",
+		Long: `Show current CLI version and check for updates.
+
+When running interactively, this checks whether a newer release of Vespa CLI is
+available and shows how to upgrade to it. Other commands make the same check no
+more than once per day. This can be disabled with
+'vespa config set update-check false'.
+
+With --upgrade, the latest release is downloaded from GitHub, verified against
+its published checksum, and replaces the running binary. This is refused if
+Vespa CLI was installed with a package manager, such as Homebrew, which should
+be used to upgrade it instead.`,
+		Example: `$ vespa version
+$ vespa version --upgrade`,
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		Args:              cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if upgradeArg {
+				return upgrade(cli)
+			}
 			log.Printf("Vespa CLI version %s compiled with %v on %v/%v", build.Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
-			if !skipVersionCheck && cli.isTerminal() {
+			if !skipVersionCheck && cli.isTerminal() && cli.config.updateCheck() {
 				return checkVersion(cli)
 			}
 			return nil
 		},
 	}
 	cmd.Flags().BoolVarP(&skipVersionCheck, "no-check", "n", false, "Do not check if a new version is available")
+	cmd.Flags().BoolVar(&upgradeArg, "upgrade", false, "Upgrade to the latest release of Vespa CLI")
 	return cmd
 }
 
@@ -43,7 +73,7 @@ func checkVersion(cli *CLI) error {
 	if err != nil {
 		return err
 	}
-	latest, err := latestRelease(cli)
+	latest, err := latestRelease(cli.httpClient, time.Minute)
 	if err != nil {
 		return err
 	}
@@ -56,22 +86,89 @@ func checkVersion(cli *CLI) error {
 	}
 	log.Printf("\nNew release available: %s", color.GreenString(latest.Version.String()))
 	log.Printf("https://github.com/vespa-engine/vespa/releases/tag/v%s", latest.Version)
+	log.Printf("\nUpgrade by running:\n%s", color.CyanString(upgradeCommand(usingHomebrew)))
+	return nil
+}
+
+func upgradeCommand(usingHomebrew bool) string {
 	if usingHomebrew {
-		log.Printf("\nUpgrade by running:\n%s", color.CyanString("brew update && brew upgrade vespa-cli"))
+		return "brew update && brew upgrade vespa-cli"
 	}
-	return nil
+	return "vespa version --upgrade"
 }
 
-func latestRelease(cli *CLI) (release, error) {
+// startUpdateCheck checks for a new release in the background, if checking is enabled and the last check was made
+// more than updateCheckInterval ago. The check is made only when running interactively, and any failure is ignored.
+func (c *CLI) startUpdateCheck(cmd *cobra.Command) {
+	c.pendingUpdateCheck = nil
+	switch cmd.Name() {
+	case "version", "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return
+	}
+	if !c.config.updateCheck() || !c.isTerminal() || c.isCI() || c.config.isQuiet() || c.jsonOutput() {
+		return
+	}
+	path := c.config.updateCheckPath()
+	if data, err := os.ReadFile(path); err == nil {
+		if checkedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data))); err == nil && c.now().Sub(checkedAt) < updateCheckInterval {
+			return
+		}
+	}
+	// Record the check before making it, so that a slow or failing check is not repeated by every command
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	if err := os.WriteFile(path, []byte(c.now().UTC().Format(time.RFC3339)+"\n"), 0600); err != nil {
+		return
+	}
+	hint := make(chan string, 1)
+	c.pendingUpdateCheck = hint
+	client := c.httpClientFactory(10 * time.Second)
+	httputil.ConfigureRetry(client, httputil.RetryPolicy{})
+	go func() {
+		defer close(hint)
+		latest, err := latestRelease(client, 10*time.Second)
+		if err != nil || !c.version.Less(latest.Version) {
+			return
+		}
+		usingHomebrew := usingHomebrew(c)
+		if usingHomebrew && latest.isRecent() {
+			return
+		}
+		hint <- fmt.Sprintf("New release of Vespa CLI available: %s (current: %s)\nUpgrade by running: %s",
+			color.GreenString(latest.Version.String()), c.version, color.CyanString(upgradeCommand(usingHomebrew)))
+	}()
+}
+
+// finishUpdateCheck prints the result of any pending check for a new release, waiting at most updateCheckWait for it
+// to complete.
+func (c *CLI) finishUpdateCheck() {
+	if c.pendingUpdateCheck == nil {
+		return
+	}
+	select {
+	case hint, ok := <-c.pendingUpdateCheck:
+		if ok {
+			c.printInfo("\n", hint, "\nDisable this check with 'vespa config set update-check false'")
+		}
+	case <-time.After(updateCheckWait):
+	}
+	c.pendingUpdateCheck = nil
+}
+
+func latestRelease(client httputil.Client, timeout time.Duration) (release, error) {
 	req, err := http.NewRequest("GET", "https://api.github.com/repos/vespa-engine/vespa/releases", nil)
 	if err != nil {
 		return release{}, err
 	}
-	response, err := cli.httpClient.Do(req, time.Minute)
+	response, err := client.Do(req, timeout)
 	if err != nil {
 		return release{}, err
 	}
 	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return release{}, fmt.Errorf("could not list releases: got status %d", response.StatusCode)
+	}
 
 	var ghReleases []githubRelease
 	dec := json.NewDecoder(response.Body)
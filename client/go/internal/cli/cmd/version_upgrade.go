@@ -0,0 +1,232 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	releaseDownloadURL = "https://github.com/vespa-engine/vespa/releases/download"
+	// maxReleaseAssetSize is the largest release asset that is downloaded.
+	maxReleaseAssetSize = 256 << 20
+)
+
+// executablePath returns the path of the running binary, with any symlinks resolved.
+func executablePath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// upgrade replaces the running binary with the latest release of Vespa CLI.
+func upgrade(cli *CLI) error {
+	latest, err := latestRelease(cli.httpClient, time.Minute)
+	if err != nil {
+		return fmt.Errorf("could not find latest release: %w", err)
+	}
+	if !cli.version.Less(latest.Version) {
+		cli.printSuccess("Vespa CLI ", cli.version, " is the latest release")
+		return nil
+	}
+	self, err := cli.executable()
+	if err != nil {
+		return fmt.Errorf("could not find path of Vespa CLI: %w", err)
+	}
+	if manager, command := packageManager(cli, self); manager != "" {
+		hint := "Upgrade with " + manager
+		if command != "" {
+			hint = "Upgrade by running: " + command
+		}
+		return errHint(fmt.Errorf("refusing to upgrade %s: it was installed with %s", self, manager), hint)
+	}
+	assetName := releaseAssetName(latest.Version.String(), runtime.GOOS, runtime.GOARCH)
+	baseURL := fmt.Sprintf("%s/v%s/", releaseDownloadURL, latest.Version)
+	cli.printInfo("Downloading ", baseURL, assetName, " ...")
+	checksums, err := download(cli, baseURL+fmt.Sprintf("vespa-cli_%s_sha256sums.txt", latest.Version))
+	if err != nil {
+		return err
+	}
+	checksum, err := findChecksum(checksums, assetName)
+	if err != nil {
+		return err
+	}
+	asset, err := download(cli, baseURL+assetName)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(asset)
+	if got := hex.EncodeToString(sum[:]); got != checksum {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", assetName, got, checksum)
+	}
+	binary, err := extractBinary(asset, assetName)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(self, binary); err != nil {
+		return fmt.Errorf("could not replace %s: %w", self, err)
+	}
+	cli.printSuccess("Upgraded Vespa CLI from ", cli.version, " to ", color.GreenString(latest.Version.String()))
+	return nil
+}
+
+// packageManager returns the name of the package manager which installed the binary at path, and the command which
+// upgrades it, if known. The name is empty if no package manager is detected.
+func packageManager(cli *CLI, binPath string) (string, string) {
+	p := strings.ToLower(filepath.ToSlash(binPath))
+	switch {
+	case usingHomebrew(cli) || strings.Contains(p, "/cellar/") || strings.Contains(p, "/homebrew/") || strings.Contains(p, "/linuxbrew/"):
+		return "Homebrew", upgradeCommand(true)
+	case strings.HasPrefix(p, "/nix/store/"):
+		return "Nix", ""
+	case strings.HasPrefix(p, "/snap/"):
+		return "Snap", "snap refresh vespa-cli"
+	case strings.Contains(p, "/chocolatey/"):
+		return "Chocolatey", "choco upgrade vespa-cli"
+	case strings.Contains(p, "/scoop/"):
+		return "Scoop", "scoop update vespa-cli"
+	}
+	for _, dir := range []string{"/usr/bin/", "/usr/sbin/", "/bin/", "/sbin/"} {
+		if strings.HasPrefix(p, dir) {
+			return "the system package manager", ""
+		}
+	}
+	return "", ""
+}
+
+// releaseAssetName returns the name of the release archive for given version, operating system and architecture.
+func releaseAssetName(version, goos, goarch string) string {
+	ext := "tar.gz"
+	if goos == "windows" {
+		ext = "zip"
+	}
+	return fmt.Sprintf("vespa-cli_%s_%s_%s.%s", version, goos, goarch, ext)
+}
+
+func download(cli *CLI, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := cli.httpClient.Do(req, 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("could not download %s: %w", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %s: got status %d", url, response.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxReleaseAssetSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not download %s: %w", url, err)
+	}
+	if len(data) > maxReleaseAssetSize {
+		return nil, fmt.Errorf("could not download %s: larger than %d bytes", url, maxReleaseAssetSize)
+	}
+	return data, nil
+}
+
+// findChecksum returns the SHA-256 checksum of the file name listed in checksums, which holds lines on the format
+// written by sha256sum.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum published for %s", name)
+}
+
+// extractBinary returns the contents of the vespa binary in the release archive named name.
+func extractBinary(archive []byte, name string) ([]byte, error) {
+	if strings.HasSuffix(name, ".zip") {
+		r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", name, err)
+		}
+		for _, f := range r.File {
+			if path.Base(f.Name) == "vespa.exe" && !f.FileInfo().IsDir() {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+		return nil, fmt.Errorf("no vespa.exe found in %s", name)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", name, err)
+	}
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no vespa binary found in %s", name)
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", name, err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) == "vespa" {
+			return io.ReadAll(r)
+		}
+	}
+}
+
+// replaceExecutable atomically replaces the file at binPath with data, by renaming a file written next to it.
+func replaceExecutable(binPath string, data []byte) error {
+	info, err := os.Stat(binPath)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(binPath), ".vespa-upgrade-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0111); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// A running binary cannot be replaced on Windows, but it can be moved out of the way
+		old := binPath + ".old"
+		os.Remove(old)
+		if err := os.Rename(binPath, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), binPath)
+}
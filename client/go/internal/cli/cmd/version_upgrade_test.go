@@ -0,0 +1,174 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+	"github.com/vespa-engine/vespa/client/go/internal/version"
+)
+
+const releasesResponse = `[{"tag_name": "v1.2.3", "published_at": "2021-09-10T12:00:00Z"}]`
+
+func releaseArchive(t *testing.T, binary string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	dir := fmt.Sprintf("vespa-cli_1.2.3_%s_%s", runtime.GOOS, runtime.GOARCH)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: dir + "/LICENSE", Mode: 0644, Size: 3, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("ASL"))
+	require.Nil(t, err)
+	require.Nil(t, tw.WriteHeader(&tar.Header{Name: dir + "/bin/vespa", Mode: 0755, Size: int64(len(binary)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte(binary))
+	require.Nil(t, err)
+	require.Nil(t, tw.Close())
+	require.Nil(t, gz.Close())
+	return buf.Bytes()
+}
+
+func checksumsOf(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("0123abcd  vespa-cli_1.2.3_sha256sums.txt.sig\n%s  %s\n", hex.EncodeToString(sum[:]), name)
+}
+
+func newUpgradeCLI(t *testing.T) (*CLI, *mock.HTTPClient, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("release archives are zip files on windows")
+	}
+	cli, _, _ := newTestCLI(t)
+	self := filepath.Join(t.TempDir(), "vespa")
+	require.Nil(t, os.WriteFile(self, []byte("old binary"), 0755))
+	cli.executable = func() (string, error) { return self, nil }
+	return cli, cli.httpClient.(*mock.HTTPClient), self
+}
+
+func TestVersionUpgrade(t *testing.T) {
+	cli, httpClient, self := newUpgradeCLI(t)
+	stdout, stderr := cli.Stdout.(*bytes.Buffer), cli.Stderr.(*bytes.Buffer)
+	assetName := releaseAssetName("1.2.3", runtime.GOOS, runtime.GOARCH)
+	archive := releaseArchive(t, "new binary")
+	httpClient.NextResponseString(200, releasesResponse)
+	httpClient.NextResponseString(200, checksumsOf(assetName, archive))
+	httpClient.NextResponseBytes(200, archive)
+	require.Nil(t, cli.Run("version", "--upgrade", "--color", "never"))
+
+	baseURL := "https://github.com/vespa-engine/vespa/releases/download/v1.2.3/"
+	require.Equal(t, 3, len(httpClient.Requests))
+	assert.Equal(t, baseURL+"vespa-cli_1.2.3_sha256sums.txt", httpClient.Requests[1].URL.String())
+	assert.Equal(t, baseURL+assetName, httpClient.Requests[2].URL.String())
+	assert.Equal(t, "Downloading "+baseURL+assetName+" ...\n", stderr.String())
+	assert.Equal(t, "Success: Upgraded Vespa CLI from 0.0.0-devel to 1.2.3\n", stdout.String())
+	data, err := os.ReadFile(self)
+	require.Nil(t, err)
+	assert.Equal(t, "new binary", string(data))
+	info, err := os.Stat(self)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(self))
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	// Already up to date
+	stdout.Reset()
+	cli.version = version.MustParse("1.2.3")
+	httpClient.NextResponseString(200, releasesResponse)
+	require.Nil(t, cli.Run("version", "--upgrade", "--color", "never"))
+	assert.Equal(t, "Success: Vespa CLI 1.2.3 is the latest release\n", stdout.String())
+}
+
+func TestVersionUpgradeChecksumMismatch(t *testing.T) {
+	cli, httpClient, self := newUpgradeCLI(t)
+	stderr := cli.Stderr.(*bytes.Buffer)
+	assetName := releaseAssetName("1.2.3", runtime.GOOS, runtime.GOARCH)
+	httpClient.NextResponseString(200, releasesResponse)
+	httpClient.NextResponseString(200, checksumsOf(assetName, releaseArchive(t, "new binary")))
+	httpClient.NextResponseBytes(200, releaseArchive(t, "tampered binary"))
+	require.NotNil(t, cli.Run("version", "--upgrade", "--color", "never"))
+	assert.Contains(t, stderr.String(), "Error: checksum mismatch for "+assetName)
+	data, err := os.ReadFile(self)
+	require.Nil(t, err)
+	assert.Equal(t, "old binary", string(data))
+
+	// Checksum not published
+	cli, httpClient, _ = newUpgradeCLI(t)
+	stderr = cli.Stderr.(*bytes.Buffer)
+	httpClient.NextResponseString(200, releasesResponse)
+	httpClient.NextResponseString(200, "0123abcd  vespa-cli_1.2.3_plan9_386.tar.gz\n")
+	require.NotNil(t, cli.Run("version", "--upgrade", "--color", "never"))
+	assert.Contains(t, stderr.String(), "Error: no checksum published for "+assetName+"\n")
+	assert.Equal(t, 2, len(httpClient.Requests))
+}
+
+func TestVersionUpgradePackageManager(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		hint string
+	}{
+		{"/usr/bin/vespa", "Hint: Upgrade with the system package manager\n"},
+		{"/opt/homebrew/Cellar/vespa-cli/1.0.0/bin/vespa", "Hint: Upgrade by running: brew update && brew upgrade vespa-cli\n"},
+		{"/nix/store/abc-vespa-cli-1.0.0/bin/vespa", "Hint: Upgrade with Nix\n"},
+	} {
+		cli, stdout, stderr := newTestCLI(t)
+		httpClient := cli.httpClient.(*mock.HTTPClient)
+		cli.executable = func() (string, error) { return tt.path, nil }
+		httpClient.NextResponseString(200, releasesResponse)
+		require.NotNil(t, cli.Run("version", "--upgrade", "--color", "never"), tt.path)
+		assert.Contains(t, stderr.String(), "Error: refusing to upgrade "+tt.path+": it was installed with ", tt.path)
+		assert.Contains(t, stderr.String(), tt.hint, tt.path)
+		assert.Equal(t, "", stdout.String())
+		assert.Equal(t, 1, len(httpClient.Requests), tt.path)
+	}
+}
+
+func TestUpdateCheck(t *testing.T) {
+	clock := &manualClock{t: time.Date(2021, 9, 20, 12, 0, 0, 0, time.UTC)}
+	cli, _, stderr := newTestCLI(t)
+	httpClient := cli.httpClient.(*mock.HTTPClient)
+	cli.isTerminal = func() bool { return true }
+	cli.now = clock.now
+	httpClient.NextResponseString(200, releasesResponse)
+	require.Nil(t, cli.Run("config", "get", "target", "--color", "never"))
+	assert.Equal(t, "\nNew release of Vespa CLI available: 1.2.3 (current: 0.0.0-devel)\n"+
+		"Upgrade by running: vespa version --upgrade\n"+
+		"Disable this check with 'vespa config set update-check false'\n", stderr.String())
+	assert.Equal(t, 1, len(httpClient.Requests))
+
+	// Checked at most once per day
+	stderr.Reset()
+	clock.t = clock.t.Add(23 * time.Hour)
+	require.Nil(t, cli.Run("config", "get", "target"))
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, 1, len(httpClient.Requests))
+
+	// Failures are silent
+	clock.t = clock.t.Add(2 * time.Hour)
+	httpClient.NextResponseError(fmt.Errorf("network is down"))
+	require.Nil(t, cli.Run("config", "get", "target"))
+	assert.Equal(t, "", stderr.String())
+	clock.t = clock.t.Add(25 * time.Hour)
+	httpClient.NextResponseString(500, "oops")
+	require.Nil(t, cli.Run("config", "get", "target"))
+	assert.Equal(t, "", stderr.String())
+	assert.Equal(t, 2, len(httpClient.Requests))
+
+	// Disabled by config
+	cli, _, stderr = newTestCLI(t)
+	httpClient = cli.httpClient.(*mock.HTTPClient)
+	require.Nil(t, cli.Run("config", "set", "update-check", "false"))
+	cli.isTerminal = func() bool { return true }
+	require.Nil(t, cli.Run("config", "get", "target"))
+	assert.Equal(t, "", stderr.String())
+	assert.Empty(t, httpClient.Requests)
+}
@@ -6,17 +6,24 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"github.com/spf13/cobra"
-	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
 )
@@ -24,6 +31,7 @@ import (
 type visitArgs struct {
 	contentCluster string
 	fieldSet       string
+	offline        bool
 	selection      string
 	makeFeed       bool
 	jsonLines      bool
@@ -33,19 +41,55 @@ type visitArgs struct {
 	to             string
 	slices         int
 	sliceId        int
+	sliceOutput    string
+	progressFile   string
+	outputPrefix   string
+	compression    string
+	maxFileSize    string
+	now            time.Time
 	bucketSpace    string
 	bucketSpaces   []string
 	waitSecs       int
 	verbose        bool
 	headers        []string
 	stream         bool
+	count          bool
+	stats          bool
+	destination    destinationArgs
+	transform      transformFlags
+	apply          applyFlags
 
 	cli    *CLI
 	header http.Header
+
+	// The following are set when visiting slices in parallel
+	ctx     context.Context
+	out     io.Writer
+	mu      *sync.Mutex
+	visited *atomic.Int64
+	// appending is set when out appends to a slice output file written before by the visit being resumed
+	appending bool
+
+	progress *visitProgress
+	output   *visitOutput
+	copier   *visitDestination
+	counter  *visitCounter
+	// transformer is set when documents are piped through a command before they are printed
+	transformer *documentTransformer
+	// applier is set when the fields of documents are transformed by a jq expression before they are output
+	applier *documentApplier
 }
 
 func (v *visitArgs) writeBytes(b []byte) {
-	v.cli.Stdout.Write(b)
+	if v.mu != nil {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+	}
+	if v.out != nil {
+		v.out.Write(b)
+	} else {
+		v.cli.Stdout.Write(b)
+	}
 }
 
 func (v *visitArgs) writeString(s string) {
@@ -53,39 +97,142 @@ func (v *visitArgs) writeString(s string) {
 }
 
 func (v *visitArgs) debugPrint(s string) {
+	if v.mu != nil {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+	}
 	v.cli.printDebug(s)
 }
 
-func (v *visitArgs) dumpDocuments(documents []DocumentBlob) {
+// parallelSlices returns whether all slices should be visited in parallel by this command.
+func (v *visitArgs) parallelSlices() bool { return v.slices > 0 && v.sliceId < 0 }
+
+func (v *visitArgs) dumpDocuments(documents []DocumentBlob) error {
+	if v.counter != nil {
+		v.counter.add(documents)
+		return nil
+	}
+	if v.applier != nil {
+		documents = v.applyDocuments(documents)
+	}
+	if v.copier != nil {
+		return v.copier.feed(documents)
+	}
+	if v.transformer != nil {
+		var err error
+		if documents, err = v.transformDocuments(documents); err != nil {
+			return err
+		}
+	}
 	comma := false
 	pretty := false
 	if v.makeFeed {
 		comma = true
 		pretty = v.pretty
 	} else if !v.jsonLines {
-		return
+		return nil
 	}
+	// Write all documents at once, so output of slices visited in parallel is not interleaved
+	var buf bytes.Buffer
+	ends := make([]int, 0, len(documents))
 	for _, value := range documents {
 		if pretty {
 			var prettyJSON bytes.Buffer
 			parseError := json.Indent(&prettyJSON, value.blob, "", "    ")
 			if parseError != nil {
-				v.writeBytes(value.blob)
+				buf.Write(value.blob)
 			} else {
-				v.writeBytes(prettyJSON.Bytes())
+				buf.Write(prettyJSON.Bytes())
 			}
 		} else {
-			v.writeBytes(value.blob)
+			buf.Write(value.blob)
 		}
 		if comma {
-			v.writeString(",\n")
+			buf.WriteString(",\n")
 		} else {
-			v.writeString("\n")
+			buf.WriteString("\n")
+		}
+		ends = append(ends, buf.Len())
+	}
+	if v.output != nil {
+		if v.mu != nil {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+		}
+		start := 0
+		for _, end := range ends {
+			if err := v.output.writeDocument(buf.Bytes()[start:end]); err != nil {
+				return err
+			}
+			start = end
+		}
+		if v.progress != nil {
+			// The documents must be written before the progress past them is stored
+			return v.output.flush()
+		}
+		return nil
+	}
+	if buf.Len() > 0 {
+		v.writeBytes(buf.Bytes())
+	}
+	return nil
+}
+
+// transformDocuments returns the given documents as transformed by the transform command, and prints an error for
+// each document which failed to be transformed, leaving it out.
+func (v *visitArgs) transformDocuments(documents []DocumentBlob) ([]DocumentBlob, error) {
+	ids := make([]string, len(documents))
+	docs := make([][]byte, len(documents))
+	for i, d := range documents {
+		var doc struct {
+			Id string `json:"id"`
+		}
+		json.Unmarshal(d.blob, &doc)
+		ids[i] = doc.Id
+		docs[i] = d.blob
+	}
+	outs, errs := v.transformer.transformAll(ids, docs)
+	transformed := make([]DocumentBlob, 0, len(documents))
+	for i, err := range errs {
+		if err != nil {
+			var transformErr *transformError
+			if !errors.As(err, &transformErr) {
+				return nil, err
+			}
+			v.cli.printErr(err)
+			continue
+		}
+		transformed = append(transformed, DocumentBlob{blob: outs[i]})
+	}
+	return transformed, nil
+}
+
+// applyDocuments returns the given documents with their fields transformed by the apply expression, leaving out those
+// it drops, and prints an error for each document it fails for, leaving it out too.
+func (v *visitArgs) applyDocuments(documents []DocumentBlob) []DocumentBlob {
+	blobs := make([][]byte, len(documents))
+	for i, d := range documents {
+		blobs[i] = d.blob
+	}
+	outs, errs := v.applier.applyAll(blobs)
+	applied := make([]DocumentBlob, 0, len(documents))
+	for i, err := range errs {
+		if err != nil {
+			var doc struct {
+				Id string `json:"id"`
+			}
+			json.Unmarshal(documents[i].blob, &doc)
+			v.cli.printErr(fmt.Errorf("--apply failed for %s: %w", doc.Id, err))
+			continue
+		}
+		if outs[i] != nil {
+			applied = append(applied, DocumentBlob{blob: outs[i]})
 		}
 	}
+	return applied
 }
 
-var totalDocCount int
+var totalDocCount atomic.Int64
 
 func newVisitCmd(cli *CLI) *cobra.Command {
 	var (
@@ -97,21 +244,109 @@ func newVisitCmd(cli *CLI) *cobra.Command {
 		Long: `Retrieve and print all documents from Vespa.
 
 By default, prints each document received on its own line (JSONL format).
+
+With --field-set, only the given fields of each document are visited: one of
+[all], [document] or [id], or a list of fields of a document type, like
+music:title,artist. The document type and fields are checked against the
+schemas of the deployed application package before visiting starts, so a
+misspelled field fails fast instead of silently being left out of the visited
+documents. Use --offline to skip this check.
+
+With --slices, but without --slice-id, the given number of slices are visited
+in parallel. Documents from all slices are then printed to standard output, or
+to a file per slice with --slice-output-prefix. A failure in one slice stops
+visiting of all the others.
+
+With --continuation-file, the progress of the visit is stored in the given
+file after each chunk of documents. If the file exists when the visit starts,
+the visit resumes from where it stopped, in the time window given by --from and
+--to as it was when the visit started, and output files of slices are appended
+to. The file is removed when the visit completes.
+
+With --output, documents are written to numbered files with the given prefix
+instead of standard output, optionally compressed with --compress gzip. With
+--max-file-size, a new file is started before a file would exceed the given
+size. The files written, and the number of documents in each, are printed when
+the visit completes.
+
+With --destination, visited documents are fed to another Vespa cluster instead
+of being printed. The destination is either 'local', the URL of a container
+cluster, or an application in Vespa Cloud, on the form
+tenant.application.instance, optionally in the zone given by
+--destination-zone. The destination is fed over mTLS, using the certificate of
+the application, or the one given by --destination-cert and --destination-key.
+Visiting is slowed down to the pace at which the destination accepts
+documents. When the copy completes, the number of documents visited and fed
+successfully is printed, and the command fails if any document was not fed.
+
+With --count, documents are counted instead of printed. Only the IDs of the
+documents are fetched, and the number of documents of each document type is
+printed on a single line when the visit completes, together with the time it
+took. With --stats, documents are fetched in full, and the distribution of
+their sizes is printed as well. The result is printed as JSON if the output
+format is json, as set by 'vespa config set output json'.
+
+With --transform-cmd, each visited document is piped through the given shell
+command before it is printed, e.g. to decrypt fields which are stored
+encrypted. The command reads the document as JSON on standard input, and
+prints the document to output on standard output. At most
+--transform-concurrency commands run at once, each for at most
+--transform-timeout. A document for which the command fails is reported as
+an error and left out, and the visit then fails when it completes. With
+--transform-stream, the command is instead started once, and reads documents
+as JSON lines, printing one line per document, in order. It must flush its
+output after each line, like jq --unbuffered does.
+
+With --apply, the fields of each visited document are transformed by the given
+jq expression before the document is printed, or fed to --destination, e.g. to
+rename or drop a field when copying between schema versions. The expression is
+given the fields object, and must return the fields to output, or null to drop
+the document. Longer programs can be read from a file with --apply-file. The
+expression is compiled once, and evaluated for several documents at once. A
+document for which it fails, or returns something other than an object, is
+reported with its ID and left out, and the visit then fails when it completes.
+The number of dropped documents is printed when the visit completes. With
+--transform-cmd too, documents are transformed by --apply first.
 `,
 		Example: `$ vespa visit # get documents from any cluster
 $ vespa visit --content-cluster search # get documents from cluster named "search"
 $ vespa visit --field-set "[id]" # list document IDs
+$ vespa visit --from -24h # get documents modified during the last 24 hours
+$ vespa visit --from 2024-01-01T00:00:00+01:00 --to 2024-02-01T00:00:00+01:00
+$ vespa visit --slices 8 --slice-output-prefix docs- # visit in parallel, writing docs-0.jsonl to docs-7.jsonl
+$ vespa visit --continuation-file visit.json >> docs.jsonl # resumable visit
+$ vespa visit --output dump --compress gzip --max-file-size 1G # write dump-00001.jsonl.gz, dump-00002.jsonl.gz, ...
+$ vespa visit --selection music --destination mytenant.myapp.default --destination-zone prod.aws-us-east-1c # copy to another application
+$ vespa visit --destination https://other.example.com:8080 --destination-cert cert.pem --destination-key key.pem
+$ vespa visit --count --selection 'music.language=="sv"' # count documents with language sv
+$ vespa visit --stats # count documents, and report the distribution of their sizes
+$ vespa visit --transform-cmd ./decrypt.sh # pipe each document through decrypt.sh
+$ vespa visit --transform-cmd 'jq --unbuffered -c "del(.fields.secret)"' --transform-stream
+$ vespa visit --apply 'del(.secret)' # leave out the field secret
+$ vespa visit --apply '.artist = .artist_name | del(.artist_name)' --destination mytenant.newapp.default
 `,
 		Args:              cobra.MaximumNArgs(0),
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			vArgs.cli = cli
+			vArgs.now = cli.now()
 			result := checkArguments(vArgs)
 			if !result.Success {
 				return fmt.Errorf("argument error: %s", result.Message)
 			}
-			header, err := httputil.ParseHeader(vArgs.headers)
+			if err := checkDestinationArguments(&vArgs); err != nil {
+				return err
+			}
+			if vArgs.count {
+				// Only the document IDs are needed to count documents
+				vArgs.fieldSet = "[id]"
+			}
+			if err := checkFieldSet(cli, vArgs.fieldSet, vArgs.offline); err != nil {
+				return err
+			}
+			authMethod := cli.selectAuthMethod()
+			header, err := cli.dataPlaneHeader(vArgs.headers, authMethod)
 			if err != nil {
 				return err
 			}
@@ -121,80 +356,243 @@ $ vespa visit --field-set "[id]" # list document IDs
 			if err != nil {
 				return err
 			}
-			if service.AuthMethod == "token" {
-				err = cli.addBearerToken(&header)
-				if err != nil {
-					return err
-				}
+			if authMethod == "token" {
 				service.TLSOptions.CertificateFile = ""
 				service.TLSOptions.PrivateKeyFile = ""
 			}
 			if vArgs.verbose {
-				service.CurlWriter = vespa.CurlWriter{Writer: cli.Stderr}
+				service.CurlWriter = cli.curlWriter()
+			}
+			if vArgs.progressFile != "" {
+				vArgs.progress, err = readVisitProgress(vArgs.progressFile, &vArgs)
+				if err != nil {
+					return err
+				}
+				if vArgs.progress.resumed {
+					cli.printInfo("Resuming visit from ", vArgs.progressFile)
+				}
+			}
+			if vArgs.from != "" || vArgs.to != "" {
+				cli.printInfo("Visiting documents modified ", vArgs.timeWindowString())
+			}
+			if vArgs.outputPrefix != "" {
+				maxSize, err := parseByteSize(vArgs.maxFileSize)
+				if err != nil {
+					return fmt.Errorf("invalid max file size: %s: %w", vArgs.maxFileSize, err)
+				}
+				vArgs.output, err = newVisitOutput(vArgs.outputPrefix, vArgs.compression == "gzip", maxSize, vArgs.progress != nil && vArgs.progress.resumed)
+				if err != nil {
+					return err
+				}
+			}
+			if vArgs.destination.spec != "" {
+				if vArgs.copier, err = newVisitDestination(cli, vArgs.destination, waiter); err != nil {
+					return err
+				}
+			}
+			if vArgs.count || vArgs.stats {
+				vArgs.counter = newVisitCounter(cli.now(), vArgs.stats)
+			}
+			if vArgs.transformer, err = vArgs.transform.transformer(); err != nil {
+				return err
+			}
+			if vArgs.applier, err = vArgs.apply.applier(); err != nil {
+				return err
 			}
 			result = probeHandler(&vArgs, service, cli)
 			if result.Success {
 				result = visitClusters(&vArgs, service)
 			}
+			if vArgs.copier != nil {
+				if err := vArgs.copier.close(cli); err != nil && result.Success {
+					return err
+				}
+			}
+			if err := vArgs.transformer.close(); err != nil && result.Success {
+				result = Failure(err.Error())
+			}
+			if vArgs.applier != nil {
+				if n := vArgs.applier.dropped.Load(); n > 0 {
+					cli.printInfo(fmt.Sprintf("Dropped %d documents for which --apply returned null", n))
+				}
+				if err := vArgs.applier.close(); err != nil && result.Success {
+					result = Failure(err.Error())
+				}
+			}
+			if vArgs.output != nil {
+				if err := vArgs.output.Close(); err != nil && result.Success {
+					result = Failure("Could not write output: " + err.Error())
+				}
+				for _, f := range vArgs.output.files {
+					cli.printInfo(fmt.Sprintf("Wrote %d documents to %s", f.documents, f.name))
+				}
+			}
 			if !result.Success {
+				if vArgs.progress != nil {
+					return errHint(fmt.Errorf("visit failed: %s", result.Message), "Run the same command again to resume from "+vArgs.progressFile)
+				}
 				return fmt.Errorf("visit failed: %s", result.Message)
 			}
-			vArgs.debugPrint(fmt.Sprintf("sum of 'documentCount': %d", totalDocCount))
+			if vArgs.progress != nil {
+				if err := os.Remove(vArgs.progressFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+			vArgs.debugPrint(fmt.Sprintf("sum of 'documentCount': %d", totalDocCount.Load()))
+			if vArgs.counter != nil {
+				return vArgs.counter.print(cli)
+			}
 			return nil
 		},
 	}
 	cmd.Flags().StringVar(&vArgs.contentCluster, "content-cluster", "*", `Which content cluster to visit documents from`)
-	cmd.Flags().StringVar(&vArgs.fieldSet, "field-set", "", `Which fieldset to ask for`)
+	bindFieldSetFlags(cmd, &vArgs.fieldSet, &vArgs.offline, "Which fieldset to ask for")
 	cmd.Flags().StringVar(&vArgs.selection, "selection", "", `Select subset of cluster`)
 	cmd.Flags().BoolVar(&vArgs.jsonLines, "json-lines", true, `Output documents as JSON lines`)
 	cmd.Flags().BoolVar(&vArgs.makeFeed, "make-feed", false, `Output JSON array suitable for vespa-feeder`)
 	cmd.Flags().BoolVar(&vArgs.pretty, "pretty-json", false, `Format pretty JSON`)
 	cmd.Flags().IntVar(&vArgs.chunkCount, "chunk-count", 1000, `Chunk by count`)
-	cmd.Flags().StringVar(&vArgs.from, "from", "", `Timestamp to visit from, in seconds`)
-	cmd.Flags().StringVar(&vArgs.to, "to", "", `Timestamp to visit up to, in seconds`)
+	cmd.Flags().StringVar(&vArgs.from, "from", "", `Visit documents modified at or after this time. Given in seconds since epoch, as a RFC 3339 timestamp, or as a duration relative to now, e.g. -24h`)
+	cmd.Flags().StringVar(&vArgs.to, "to", "", `Visit documents modified before this time. Given in seconds since epoch, as a RFC 3339 timestamp, or as a duration relative to now, e.g. -1h`)
 	cmd.Flags().IntVar(&vArgs.sliceId, "slice-id", -1, `The number of the slice this visit invocation should fetch`)
-	cmd.Flags().IntVar(&vArgs.slices, "slices", -1, `Split the document corpus into this number of independent slices`)
+	cmd.Flags().IntVar(&vArgs.slices, "slices", -1, `Split the document corpus into this number of independent slices. Without --slice-id, all slices are visited in parallel`)
+	cmd.Flags().StringVar(&vArgs.sliceOutput, "slice-output-prefix", "", `Write the documents of each slice visited in parallel to a file with this prefix, followed by the slice id`)
 	cmd.Flags().StringSliceVar(&vArgs.bucketSpaces, "bucket-space", []string{"global", "default"}, `The "default" or "global" bucket space`)
 	cmd.Flags().BoolVarP(&vArgs.verbose, "verbose", "v", false, `Print the equivalent curl command for the visit operation`)
 	cmd.Flags().StringSliceVarP(&vArgs.headers, "header", "", nil, "Add a header to the HTTP request, on the format 'Header: Value'. This can be specified multiple times")
 	cmd.Flags().BoolVar(&vArgs.stream, "stream", false, "Stream the HTTP responses")
+	cmd.Flags().BoolVar(&vArgs.count, "count", false, "Count documents per document type instead of printing them. Only document IDs are fetched")
+	cmd.Flags().BoolVar(&vArgs.stats, "stats", false, "Count documents per document type, and report the distribution of their sizes, instead of printing them")
+	cmd.Flags().StringVar(&vArgs.outputPrefix, "output", "", "Write documents to numbered files with this prefix, instead of standard output")
+	cmd.Flags().StringVar(&vArgs.compression, "compress", "none", `Compression of files written with --output. Must be "none" or "gzip"`)
+	cmd.Flags().StringVar(&vArgs.maxFileSize, "max-file-size", "", "Start a new file before a file written with --output exceeds this size, e.g. 512M or 1G. Unlimited by default")
+	cmd.Flags().StringVar(&vArgs.progressFile, "continuation-file", "", "Store progress of the visit in this file, and resume from it if it exists")
+	cmd.Flags().StringVar(&vArgs.destination.spec, "destination", "", `Feed visited documents to this destination instead of printing them: "local", an URL, or an application on the form tenant.application.instance`)
+	cmd.Flags().StringVar(&vArgs.destination.zone, "destination-zone", "", "The zone of the destination application. Defaults to the default zone of the system")
+	cmd.Flags().StringVar(&vArgs.destination.cluster, "destination-cluster", "", "The container cluster of the destination to feed to. Required if it has more than one")
+	cmd.Flags().StringVar(&vArgs.destination.certFile, "destination-cert", "", "The certificate used to feed the destination. Defaults to the certificate of the application")
+	cmd.Flags().StringVar(&vArgs.destination.keyFile, "destination-key", "", "The private key of the certificate given by --destination-cert")
+	cmd.Flags().IntVar(&vArgs.destination.progressSec, "progress", 0, "Print progress of copying to --destination every this many seconds")
+	addTransformFlags(cmd, &vArgs.transform)
+	addApplyFlags(cmd, &vArgs.apply)
 	cli.bindWaitFlag(cmd, 0, &vArgs.waitSecs)
 	return cmd
 }
 
-func getEpoch(timeStamp string) (int64, error) {
-	t, err := strconv.ParseInt(timeStamp, 10, 64)
+// parseVisitTime parses timeStamp as seconds since epoch, a RFC 3339 timestamp, or a duration relative to now, e.g.
+// "-24h".
+func parseVisitTime(timeStamp string, now time.Time) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(timeStamp, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if d, err := time.ParseDuration(timeStamp); err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, timeStamp)
 	if err != nil {
-		t, err := time.Parse(time.RFC3339, timeStamp)
-		if err != nil {
-			return 0, err
-		}
-		return t.Unix(), nil
+		return time.Time{}, fmt.Errorf("must be seconds since epoch, a RFC 3339 timestamp or a duration relative to now, such as -24h")
 	}
 	return t, nil
 }
 
+// isRelativeVisitTime returns whether timeStamp is a duration relative to now, which parseVisitTime resolves differently
+// as time passes.
+func isRelativeVisitTime(timeStamp string) bool {
+	if _, err := strconv.ParseInt(timeStamp, 10, 64); err == nil {
+		return false
+	}
+	_, err := time.ParseDuration(timeStamp)
+	return err == nil
+}
+
+// formatVisitTime formats t such that parseVisitTime parses it back, or returns the empty string if t is zero.
+func formatVisitTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// timeWindow returns the time window to visit documents in, as parsed from the from and to arguments. A zero time
+// means the window is unbounded in that direction.
+func (v *visitArgs) timeWindow() (from, to time.Time, err error) {
+	if v.from != "" {
+		if from, err = parseVisitTime(v.from, v.now); err != nil {
+			return from, to, fmt.Errorf("Invalid 'from' argument: '%s': %w", v.from, err)
+		}
+	}
+	if v.to != "" {
+		if to, err = parseVisitTime(v.to, v.now); err != nil {
+			return from, to, fmt.Errorf("Invalid 'to' argument: '%s': %w", v.to, err)
+		}
+	}
+	return from, to, nil
+}
+
+// timeWindowString returns a description of the time window to visit documents in.
+func (v *visitArgs) timeWindowString() string {
+	from, to, _ := v.timeWindow()
+	fromString, toString := "the beginning", "now"
+	if !from.IsZero() {
+		fromString = from.UTC().Format(time.RFC3339Nano)
+	}
+	if !to.IsZero() {
+		toString = to.UTC().Format(time.RFC3339Nano)
+	}
+	return "from " + fromString + " to " + toString
+}
+
 func checkArguments(vArgs visitArgs) (res OperationResult) {
-	if vArgs.slices > 0 || vArgs.sliceId > -1 {
-		if vArgs.slices <= 0 || vArgs.sliceId <= -1 {
+	if vArgs.sliceId > -1 {
+		if vArgs.slices <= 0 {
 			return Failure("Both 'slices' and 'slice-id' must be set")
 		}
 		if vArgs.sliceId >= vArgs.slices {
 			return Failure("The 'slice-id' must be in range [0, slices)")
 		}
 	}
-	// to and from will support RFC3339 format soon, add more validation then
-	if vArgs.from != "" {
-		_, err := getEpoch(vArgs.from)
-		if err != nil {
-			return Failure("Invalid 'from' argument: '" + vArgs.from + "': " + err.Error())
+	if vArgs.sliceOutput != "" && !vArgs.parallelSlices() {
+		return Failure("The 'slice-output-prefix' requires 'slices' to be set, and 'slice-id' to be unset")
+	}
+	from, to, err := vArgs.timeWindow()
+	if err != nil {
+		return Failure(err.Error())
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return Failure("The 'from' argument must be before the 'to' argument: " + vArgs.timeWindowString())
+	}
+	if vArgs.outputPrefix != "" {
+		if vArgs.makeFeed {
+			return Failure("The 'output' argument cannot be combined with 'make-feed'")
 		}
+		if vArgs.sliceOutput != "" {
+			return Failure("The 'output' argument cannot be combined with 'slice-output-prefix'")
+		}
+		if _, err := parseByteSize(vArgs.maxFileSize); err != nil {
+			return Failure("Invalid 'max-file-size' argument: '" + vArgs.maxFileSize + "': " + err.Error())
+		}
+	} else if vArgs.compression != "none" || vArgs.maxFileSize != "" {
+		return Failure("The 'compress' and 'max-file-size' arguments require 'output' to be set")
 	}
-	if vArgs.to != "" {
-		_, err := getEpoch(vArgs.to)
-		if err != nil {
-			return Failure("Invalid 'to' argument: '" + vArgs.to + "': " + err.Error())
+	if vArgs.count || vArgs.stats {
+		if vArgs.count && vArgs.stats {
+			return Failure("The 'count' and 'stats' arguments cannot be combined")
 		}
+		if vArgs.makeFeed || vArgs.outputPrefix != "" || vArgs.sliceOutput != "" || vArgs.destination.spec != "" {
+			return Failure("The 'count' and 'stats' arguments cannot be combined with 'make-feed', 'output', 'slice-output-prefix' or 'destination'")
+		}
+		if vArgs.count && vArgs.fieldSet != "" && vArgs.fieldSet != "[id]" {
+			return Failure("The 'count' argument only fetches document IDs, and cannot be combined with 'field-set'")
+		}
+	}
+	if vArgs.transform.command != "" && (vArgs.count || vArgs.stats || vArgs.destination.spec != "") {
+		return Failure("The 'transform-cmd' argument cannot be combined with 'count', 'stats' or 'destination'")
+	}
+	if (vArgs.apply.expression != "" || vArgs.apply.file != "") && (vArgs.count || vArgs.stats) {
+		return Failure("The 'apply' and 'apply-file' arguments cannot be combined with 'count' or 'stats'")
+	}
+	if vArgs.compression != "none" && vArgs.compression != "gzip" {
+		return Failure("Invalid 'compress' argument '" + vArgs.compression + "', must be 'none' or 'gzip'")
 	}
 	for _, b := range vArgs.bucketSpaces {
 		switch b {
@@ -273,14 +671,37 @@ func visitClusters(vArgs *visitArgs, service *vespa.Service) (res OperationResul
 	if vArgs.contentCluster == "*" {
 		clusters = probeVisit(vArgs, service)
 	}
+	visitors := []*visitArgs{vArgs}
+	outputs := visitors
+	if vArgs.parallelSlices() {
+		var err error
+		visitors, err = sliceVisitors(vArgs)
+		if err != nil {
+			return Failure(err.Error())
+		}
+		defer func() {
+			if err := closeSliceOutputs(visitors); err != nil && res.Success {
+				res = Failure(err.Error())
+			}
+		}()
+		if vArgs.sliceOutput != "" {
+			outputs = visitors
+		}
+	}
 	if vArgs.makeFeed {
-		vArgs.writeString("[\n")
+		for _, o := range outputs {
+			if !o.appending {
+				o.writeString("[\n")
+			}
+		}
 	}
 	for _, b := range vArgs.bucketSpaces {
 		for _, c := range clusters {
-			vArgs.bucketSpace = b
-			vArgs.contentCluster = c
-			res = runVisit(vArgs, service)
+			for _, v := range visitors {
+				v.bucketSpace = b
+				v.contentCluster = c
+			}
+			res = runSlices(visitors, service)
 			if !res.Success {
 				return res
 			}
@@ -288,11 +709,96 @@ func visitClusters(vArgs *visitArgs, service *vespa.Service) (res OperationResul
 		}
 	}
 	if vArgs.makeFeed {
-		vArgs.writeString("{}\n]\n")
+		for _, o := range outputs {
+			o.writeString("{}\n]\n")
+		}
 	}
 	return res
 }
 
+// sliceVisitors returns a copy of vArgs for each slice, which can be visited in parallel.
+func sliceVisitors(vArgs *visitArgs) ([]*visitArgs, error) {
+	var (
+		mu      sync.Mutex
+		visited atomic.Int64
+	)
+	vArgs.mu = &mu
+	visitors := make([]*visitArgs, 0, vArgs.slices)
+	for i := range vArgs.slices {
+		v := *vArgs
+		v.sliceId = i
+		v.visited = &visited
+		if vArgs.sliceOutput != "" {
+			suffix := ".jsonl"
+			if vArgs.makeFeed {
+				suffix = ".json"
+			}
+			flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			if vArgs.progress != nil && vArgs.progress.resumed {
+				flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+			f, err := os.OpenFile(vArgs.sliceOutput+strconv.Itoa(i)+suffix, flags, 0644)
+			if err != nil {
+				closeSliceOutputs(visitors)
+				return nil, err
+			}
+			v.out = f
+			if info, err := f.Stat(); err == nil && info.Size() > 0 {
+				v.appending = vArgs.progress != nil && vArgs.progress.resumed
+			}
+		}
+		visitors = append(visitors, &v)
+	}
+	return visitors, nil
+}
+
+func closeSliceOutputs(visitors []*visitArgs) error {
+	var firstErr error
+	for _, v := range visitors {
+		if c, ok := v.out.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// runSlices visits the slices of given visitors concurrently. The first failure cancels visiting of all other slices,
+// and is returned.
+func runSlices(visitors []*visitArgs, service *vespa.Service) OperationResult {
+	if len(visitors) == 1 {
+		return runVisit(visitors[0], service)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		once    sync.Once
+		failure *OperationResult
+	)
+	visitedBefore := visitors[0].visited.Load()
+	for _, v := range visitors {
+		v.ctx = ctx
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res := runVisit(v, service); !res.Success {
+				once.Do(func() {
+					failure = &res
+					cancel()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if failure != nil {
+		return *failure
+	}
+	visited := visitors[0].visited.Load() - visitedBefore
+	return Success(fmt.Sprintf("visited %s [%d documents visited in %d slices]", visitors[0].contentCluster, visited, len(visitors)))
+}
+
 func probeVisit(vArgs *visitArgs, service *vespa.Service) []string {
 	clusters := make([]string, 0, 3)
 	vvo, _ := runOneVisit(vArgs, service, "")
@@ -316,7 +822,17 @@ func runVisit(vArgs *visitArgs, service *vespa.Service) (res OperationResult) {
 	vArgs.debugPrint(fmt.Sprintf("trying to visit: '%s'", vArgs.contentCluster))
 	var totalDocuments = 0
 	var continuationToken string
+	if vArgs.progress != nil {
+		stream := vArgs.progress.stream(vArgs)
+		if stream.Done {
+			return Success("visited " + vArgs.contentCluster + " [already completed]")
+		}
+		continuationToken = stream.Continuation
+	}
 	for {
+		if vArgs.ctx != nil && vArgs.ctx.Err() != nil {
+			return Failure("visit of slice " + strconv.Itoa(vArgs.sliceId) + " cancelled")
+		}
 		var vvo *VespaVisitOutput
 		vvo, res = runOneVisit(vArgs, service, continuationToken)
 		if !res.Success {
@@ -325,15 +841,31 @@ func runVisit(vArgs *visitArgs, service *vespa.Service) (res OperationResult) {
 			}
 			return res
 		}
-		vArgs.dumpDocuments(vvo.Documents)
-		vArgs.debugPrint(fmt.Sprintf("got %d documents", len(vvo.Documents)))
+		if err := vArgs.dumpDocuments(vvo.Documents); err != nil {
+			return Failure("Could not write documents: " + err.Error())
+		}
+		if vArgs.visited != nil {
+			total := vArgs.visited.Add(int64(len(vvo.Documents)))
+			vArgs.debugPrint(fmt.Sprintf("got %d documents from slice %d, %d documents from all slices", len(vvo.Documents), vArgs.sliceId, total))
+		} else {
+			vArgs.debugPrint(fmt.Sprintf("got %d documents", len(vvo.Documents)))
+		}
 		totalDocuments += len(vvo.Documents)
 		continuationToken = vvo.Continuation
+		if vArgs.progress != nil {
+			if err := vArgs.progress.update(vArgs, continuationToken); err != nil {
+				return Failure("Could not write continuation file: " + err.Error())
+			}
+		}
 		if continuationToken == "" {
 			break
 		}
 	}
-	res.Message = fmt.Sprintf("%s [%d documents visited]", res.Message, totalDocuments)
+	if vArgs.from != "" || vArgs.to != "" {
+		res.Message = fmt.Sprintf("%s [%d documents visited, modified %s]", res.Message, totalDocuments, vArgs.timeWindowString())
+	} else {
+		res.Message = fmt.Sprintf("%s [%d documents visited]", res.Message, totalDocuments)
+	}
 	return
 }
 
@@ -372,15 +904,14 @@ func runOneVisit(vArgs *visitArgs, service *vespa.Service, contToken string) (*V
 	if vArgs.chunkCount > 0 {
 		urlPath = urlPath + fmt.Sprintf("&wantedDocumentCount=%d", vArgs.chunkCount)
 	}
-	if vArgs.from != "" {
-		fromSeconds, _ := getEpoch(vArgs.from)
-		urlPath = urlPath + fmt.Sprintf("&fromTimestamp=%d", fromSeconds*1000000)
+	from, to, _ := vArgs.timeWindow()
+	if !from.IsZero() {
+		urlPath = urlPath + fmt.Sprintf("&fromTimestamp=%d", from.UnixMicro())
 	}
-	if vArgs.to != "" {
-		toSeconds, _ := getEpoch(vArgs.to)
-		urlPath = urlPath + fmt.Sprintf("&toTimestamp=%d", toSeconds*1000000)
+	if !to.IsZero() {
+		urlPath = urlPath + fmt.Sprintf("&toTimestamp=%d", to.UnixMicro())
 	}
-	if vArgs.slices > 0 {
+	if vArgs.slices > 0 && vArgs.sliceId >= 0 {
 		urlPath = urlPath + fmt.Sprintf("&slices=%d&sliceId=%d", vArgs.slices, vArgs.sliceId)
 	}
 	if vArgs.bucketSpace != "" {
@@ -396,6 +927,9 @@ func runOneVisit(vArgs *visitArgs, service *vespa.Service, contToken string) (*V
 		Method: "GET",
 		Header: vArgs.header,
 	}
+	if vArgs.ctx != nil {
+		request = request.WithContext(vArgs.ctx)
+	}
 	timeout := time.Duration(900) * time.Second
 	response, err := service.Do(request, timeout)
 	if err != nil {
@@ -405,7 +939,7 @@ func runOneVisit(vArgs *visitArgs, service *vespa.Service, contToken string) (*V
 	vvo, err := parseVisitOutput(response.Body)
 	if response.StatusCode == 200 {
 		if err == nil {
-			totalDocCount += vvo.DocumentCount
+			totalDocCount.Add(int64(vvo.DocumentCount))
 			if vvo.DocumentCount != len(vvo.Documents) {
 				vArgs.cli.printWarning(fmt.Sprintf("Inconsistent contents from: %v", url))
 				vArgs.cli.printWarning(fmt.Sprintf("claimed count: %d", vvo.DocumentCount))
@@ -416,6 +950,8 @@ func runOneVisit(vArgs *visitArgs, service *vespa.Service, contToken string) (*V
 		} else {
 			return nil, Failure("error reading response: " + err.Error())
 		}
+	} else if authRejected(response.StatusCode) {
+		return vvo, FailureWithPayload("Visit rejected using "+authMethodDescription(vArgs.cli.selectAuthMethod())+": "+response.Status, ioutil.ReaderToJSON(response.Body))
 	} else if response.StatusCode/100 == 4 {
 		return vvo, FailureWithPayload("Invalid document operation: "+response.Status, ioutil.ReaderToJSON(response.Body))
 	} else {
@@ -454,3 +990,232 @@ func parseVisitOutput(r io.Reader) (*VespaVisitOutput, error) {
 	}
 	return &parsedJson, nil
 }
+
+// visitProgress is the progress of a visit, as stored in a continuation file. The progress of each visited bucket space,
+// cluster and slice is stored separately, so that slices visited in parallel can be resumed independently. The time
+// window is stored as resolved when the visit started, so that a window relative to now does not move on resume.
+type visitProgress struct {
+	Selection string                 `json:"selection"`
+	Cluster   string                 `json:"cluster"`
+	Slices    int                    `json:"slices"`
+	SliceId   int                    `json:"sliceId"`
+	From      string                 `json:"from,omitempty"`
+	To        string                 `json:"to,omitempty"`
+	Streams   map[string]visitStream `json:"streams"`
+
+	path    string
+	resumed bool
+	mu      sync.Mutex
+}
+
+type visitStream struct {
+	Continuation string `json:"continuation,omitempty"`
+	Done         bool   `json:"done,omitempty"`
+}
+
+// readVisitProgress reads the progress stored in path, or returns empty progress if path does not exist. An error is
+// returned if the stored progress is for a visit with different parameters than vArgs. When resuming, the time window
+// of vArgs is replaced by the one stored, if vArgs gives it relative to now.
+func readVisitProgress(path string, vArgs *visitArgs) (*visitProgress, error) {
+	from, to, err := vArgs.timeWindow()
+	if err != nil {
+		return nil, err
+	}
+	progress := &visitProgress{
+		Selection: vArgs.selection,
+		Cluster:   vArgs.contentCluster,
+		Slices:    vArgs.slices,
+		SliceId:   vArgs.sliceId,
+		From:      formatVisitTime(from),
+		To:        formatVisitTime(to),
+		Streams:   make(map[string]visitStream),
+		path:      path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return progress, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored visitProgress
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid continuation file %s: %w", path, err)
+	}
+	sameFrom := stored.From == progress.From || (stored.From != "" && isRelativeVisitTime(vArgs.from))
+	sameTo := stored.To == progress.To || (stored.To != "" && isRelativeVisitTime(vArgs.to))
+	if stored.Selection != progress.Selection || stored.Cluster != progress.Cluster || stored.Slices != progress.Slices || stored.SliceId != progress.SliceId || !sameFrom || !sameTo {
+		return nil, errHint(fmt.Errorf("continuation file %s is for a different visit: selection %q, cluster %q, slices %d, slice id %d, from %q, to %q",
+			path, stored.Selection, stored.Cluster, stored.Slices, stored.SliceId, stored.From, stored.To),
+			"Use the same --selection, --content-cluster, --slices, --slice-id, --from and --to as the visit being resumed, or remove the file to start over")
+	}
+	progress.From, progress.To = stored.From, stored.To
+	vArgs.from, vArgs.to = stored.From, stored.To
+	if stored.Streams != nil {
+		progress.Streams = stored.Streams
+	}
+	progress.resumed = true
+	return progress, nil
+}
+
+func visitStreamKey(vArgs *visitArgs) string {
+	return vArgs.bucketSpace + "/" + vArgs.contentCluster + "/" + strconv.Itoa(vArgs.sliceId)
+}
+
+func (p *visitProgress) stream(vArgs *visitArgs) visitStream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Streams[visitStreamKey(vArgs)]
+}
+
+// update stores continuation as the progress of the stream visited by vArgs. An empty continuation marks the stream
+// as done.
+func (p *visitProgress) update(vArgs *visitArgs, continuation string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Streams[visitStreamKey(vArgs)] = visitStream{Continuation: continuation, Done: continuation == ""}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that the file is never left partially written
+	tmpPath := p.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, p.path)
+}
+
+// parseByteSize parses a size in bytes, optionally followed by one of the binary suffixes K, M or G. An empty size is
+// parsed as zero, which means unlimited to the flags taking a size.
+func parseByteSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative number of bytes, optionally followed by K, M or G")
+	}
+	return n * multiplier, nil
+}
+
+// visitOutput writes documents to a sequence of numbered files. A new file is started when writing the next document
+// would make the current one exceed maxSize bytes. The size of a compressed file is measured as the output of the
+// compressor, and is therefore approximate.
+type visitOutput struct {
+	prefix   string
+	compress bool
+	maxSize  int64
+	index    int
+
+	file  *os.File
+	gz    *gzip.Writer
+	w     io.Writer
+	size  int64
+	files []visitOutputFile
+}
+
+type visitOutputFile struct {
+	name      string
+	documents int
+}
+
+// newVisitOutput creates a visitOutput for files with given prefix. If resume is true, numbering continues after any
+// existing files with this prefix, instead of overwriting them.
+func newVisitOutput(prefix string, compress bool, maxSize int64, resume bool) (*visitOutput, error) {
+	o := &visitOutput{prefix: prefix, compress: compress, maxSize: maxSize}
+	if resume {
+		matches, err := filepath.Glob(prefix + "-[0-9][0-9][0-9][0-9][0-9].jsonl*")
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			name := strings.TrimPrefix(m, prefix+"-")
+			if index, err := strconv.Atoi(name[:5]); err == nil && index > o.index {
+				o.index = index
+			}
+		}
+	}
+	return o, nil
+}
+
+func (o *visitOutput) Write(p []byte) (int, error) {
+	n, err := o.file.Write(p)
+	o.size += int64(n)
+	return n, err
+}
+
+func (o *visitOutput) writeDocument(doc []byte) error {
+	if o.file == nil || (o.maxSize > 0 && o.size+int64(len(doc)) > o.maxSize && o.files[len(o.files)-1].documents > 0) {
+		if err := o.next(); err != nil {
+			return err
+		}
+	}
+	if _, err := o.w.Write(doc); err != nil {
+		return err
+	}
+	o.files[len(o.files)-1].documents++
+	return nil
+}
+
+// flush writes all documents written so far to the current file, including those buffered by the compressor.
+func (o *visitOutput) flush() error {
+	if o.gz == nil {
+		return nil
+	}
+	return o.gz.Flush()
+}
+
+// next closes the current file, if any, and opens the next one.
+func (o *visitOutput) next() error {
+	if err := o.closeFile(); err != nil {
+		return err
+	}
+	o.index++
+	name := fmt.Sprintf("%s-%05d.jsonl", o.prefix, o.index)
+	if o.compress {
+		name += ".gz"
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	o.file = f
+	o.size = 0
+	o.w = o
+	if o.compress {
+		o.gz = gzip.NewWriter(o)
+		o.w = o.gz
+	}
+	o.files = append(o.files, visitOutputFile{name: name})
+	return nil
+}
+
+func (o *visitOutput) closeFile() error {
+	if o.file == nil {
+		return nil
+	}
+	if o.gz != nil {
+		if err := o.gz.Close(); err != nil {
+			return err
+		}
+		o.gz = nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// Close closes the file currently being written.
+func (o *visitOutput) Close() error { return o.closeFile() }
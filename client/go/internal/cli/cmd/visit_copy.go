@@ -0,0 +1,199 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Copying of visited documents to another Vespa cluster
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa"
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+const (
+	// copyConnections and copyStreams are the number of connections, and streams per connection, used to feed
+	// documents to a destination.
+	copyConnections = 8
+	copyStreams     = 512
+)
+
+// destinationArgs specifies the Vespa cluster documents are copied to.
+type destinationArgs struct {
+	// spec is the destination: 'local', an URL, or the ID of an application in Vespa Cloud
+	spec        string
+	zone        string
+	cluster     string
+	certFile    string
+	keyFile     string
+	progressSec int
+}
+
+// visitDestination feeds visited documents to another Vespa cluster. Enqueueing documents blocks while the maximum
+// number of operations are in flight, such that the visit is slowed down to the pace of the destination.
+type visitDestination struct {
+	description string
+	dispatcher  *document.Dispatcher
+	visited     atomic.Int64
+	ticker      *time.Ticker
+}
+
+// checkDestinationArguments returns an error if options of vArgs cannot be combined with copying to a destination.
+func checkDestinationArguments(vArgs *visitArgs) error {
+	dest := vArgs.destination
+	if dest.spec == "" {
+		if dest.zone != "" || dest.cluster != "" || dest.certFile != "" || dest.keyFile != "" {
+			return fmt.Errorf("options --destination-zone, --destination-cluster, --destination-cert and --destination-key require --destination")
+		}
+		return nil
+	}
+	if vArgs.outputPrefix != "" || vArgs.sliceOutput != "" || vArgs.makeFeed || vArgs.pretty {
+		return fmt.Errorf("option --destination cannot be combined with --output, --slice-output-prefix, --make-feed or --pretty-json")
+	}
+	if (dest.certFile == "") != (dest.keyFile == "") {
+		return fmt.Errorf("options --destination-cert and --destination-key must be given together")
+	}
+	if isURL(dest.spec) || dest.spec == vespa.TargetLocal {
+		if dest.zone != "" {
+			return fmt.Errorf("option --destination-zone requires an application as destination, not %s", dest.spec)
+		}
+		if isURL(dest.spec) && dest.cluster != "" {
+			return fmt.Errorf("option --destination-cluster cannot be combined with an URL as destination")
+		}
+	} else if _, err := vespa.ApplicationFromString(dest.spec); err != nil {
+		return errHint(fmt.Errorf("invalid destination: %q", dest.spec), "Give 'local', an URL of the destination container, or an application on the form tenant.application.instance")
+	}
+	if vArgs.fieldSet != "" && vArgs.fieldSet != "[all]" && vArgs.fieldSet != "[document]" {
+		return errHint(fmt.Errorf("copying documents with field set %s would only copy some of their fields", vArgs.fieldSet), "Use --field-set [document] or leave it unset")
+	}
+	return nil
+}
+
+// destinationTarget returns the target of the destination, and its description.
+func destinationTarget(cli *CLI, dest destinationArgs) (vespa.Target, string, error) {
+	opts := targetOptions{noCertificate: dest.certFile != ""}
+	description := dest.spec
+	if isURL(dest.spec) || dest.spec == vespa.TargetLocal {
+		opts.target = dest.spec
+	} else {
+		opts.target = vespa.TargetCloud
+		if configured, err := cli.targetType(anyTarget); err == nil && configured.name == vespa.TargetHosted {
+			opts.target = vespa.TargetHosted
+		}
+		opts.application = dest.spec
+		opts.zone = dest.zone
+	}
+	target, err := cli.target(opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid destination: %w", err)
+	}
+	if target.IsCloud() {
+		deployment := target.Deployment()
+		description = deployment.Application.String() + " in " + deployment.Zone.String()
+	}
+	return target, description, nil
+}
+
+// newVisitDestination prepares feeding of visited documents to the destination given in dest.
+func newVisitDestination(cli *CLI, dest destinationArgs, waiter *Waiter) (*visitDestination, error) {
+	target, description, err := destinationTarget(cli, dest)
+	if err != nil {
+		return nil, err
+	}
+	var keyPair []tls.Certificate
+	if dest.certFile != "" {
+		kp, err := tls.LoadX509KeyPair(dest.certFile, dest.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read destination certificate: %w", err)
+		}
+		keyPair = []tls.Certificate{kp}
+	}
+	services, _, baseURL, err := createFeedServices(copyConnections, copyStreams, 0, "mtls", cli, func() (*vespa.Service, error) {
+		services, err := waiter.services(target)
+		if err != nil {
+			return nil, err
+		}
+		service, err := vespa.FindService(dest.cluster, "mtls", services)
+		if err != nil {
+			return nil, errHint(err, "The --destination-cluster option specifies the container cluster to feed to")
+		}
+		if keyPair != nil {
+			service.TLSOptions.KeyPair = keyPair
+			service.TLSOptions.CertificateFile = dest.certFile
+			service.TLSOptions.PrivateKeyFile = dest.keyFile
+		}
+		return service, waiter.maybeWaitFor(service)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not find destination %s: %w", description, err)
+	}
+	client, err := document.NewClient(document.ClientOptions{
+		Compression: document.CompressionAuto,
+		BaseURL:     baseURL,
+		Header:      http.Header{},
+		NowFunc:     cli.now,
+	}, services)
+	if err != nil {
+		return nil, err
+	}
+	throttler := document.NewThrottler(document.ThrottlerOptions{Connections: copyConnections, Streams: copyStreams})
+	circuitBreaker := document.NewCircuitBreaker(10*time.Second, 0)
+	d := &visitDestination{
+		description: description,
+		dispatcher:  document.NewDispatcher(client, throttler, circuitBreaker, cli.Stderr, false),
+	}
+	d.dispatcher.SetNowFunc(cli.now)
+	if dest.progressSec > 0 && !cli.config.isQuiet() {
+		d.ticker = time.NewTicker(time.Duration(dest.progressSec) * time.Second)
+		go func() {
+			for range d.ticker.C {
+				cli.printInfo(d.progress())
+			}
+		}()
+	}
+	cli.printInfo("Copying documents to ", description)
+	return d, nil
+}
+
+// feed enqueues the given visited documents for feeding, blocking while too many operations are in flight.
+func (d *visitDestination) feed(documents []DocumentBlob) error {
+	for _, blob := range documents {
+		doc, err := document.NewDecoder(bytes.NewReader(blob.blob)).Decode()
+		if err != nil {
+			return fmt.Errorf("could not decode visited document: %w", err)
+		}
+		d.visited.Add(1)
+		if err := d.dispatcher.Enqueue(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// progress describes the progress of visiting and feeding.
+func (d *visitDestination) progress() string {
+	stats := d.dispatcher.Stats()
+	return fmt.Sprintf("Visited %d documents, fed %d successfully, %d requests failed, %d in flight", d.visited.Load(), stats.Successful(), stats.Unsuccessful(), stats.Inflight)
+}
+
+// close waits for all operations to complete, and returns an error if any visited document was not fed successfully.
+func (d *visitDestination) close(cli *CLI) error {
+	if d.ticker != nil {
+		d.ticker.Stop()
+	}
+	d.dispatcher.Close()
+	stats := d.dispatcher.Stats()
+	visited := d.visited.Load()
+	fed := stats.Successful()
+	if fed < visited {
+		return errHint(fmt.Errorf("copy incomplete: %d visited, %d fed successfully to %s, %d failed", visited, fed, d.description, visited-fed),
+			"The failed operations are printed above",
+			"Documents which were fed successfully are overwritten when copying again")
+	}
+	cli.printSuccess(fmt.Sprintf("Copied documents to %s: %d visited, %d fed successfully", d.description, visited, fed))
+	return nil
+}
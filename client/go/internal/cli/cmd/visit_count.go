@@ -0,0 +1,156 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Counting of visited documents, and statistics of their sizes
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/vespa/document"
+)
+
+// visitSizeBuckets are the upper bounds, in bytes, of the buckets of the size distribution of documents. Larger
+// documents are counted in a final bucket.
+var visitSizeBuckets = []int64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// visitCounter counts visited documents per document type, and optionally the distribution of their sizes, instead
+// of printing them.
+type visitCounter struct {
+	mu      sync.Mutex
+	started time.Time
+	sizes   bool
+
+	total         int64
+	documentTypes map[string]int64
+	totalSize     int64
+	minSize       int64
+	maxSize       int64
+	buckets       []int64
+}
+
+func newVisitCounter(started time.Time, sizes bool) *visitCounter {
+	return &visitCounter{started: started, sizes: sizes, documentTypes: make(map[string]int64), buckets: make([]int64, len(visitSizeBuckets)+1)}
+}
+
+// add counts given documents. Documents whose ID cannot be parsed are counted with an empty document type.
+func (c *visitCounter) add(documents []DocumentBlob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range documents {
+		var doc struct {
+			Id string `json:"id"`
+		}
+		docType := ""
+		if json.Unmarshal(d.blob, &doc) == nil {
+			if id, err := document.ParseId(doc.Id); err == nil {
+				docType = id.Type
+			}
+		}
+		c.documentTypes[docType]++
+		c.total++
+		if !c.sizes {
+			continue
+		}
+		size := int64(len(d.blob))
+		c.totalSize += size
+		if c.total == 1 || size < c.minSize {
+			c.minSize = size
+		}
+		c.maxSize = max(c.maxSize, size)
+		bucket, _ := slices.BinarySearch(visitSizeBuckets, size)
+		c.buckets[bucket]++
+	}
+}
+
+// visitCount is the result of a visit with --count or --stats.
+type visitCount struct {
+	Documents      int64            `json:"documents"`
+	DocumentTypes  map[string]int64 `json:"documentTypes"`
+	ElapsedSeconds float64          `json:"elapsedSeconds"`
+	Sizes          *visitSizes      `json:"sizes,omitempty"`
+}
+
+type visitSizes struct {
+	Total   int64             `json:"totalBytes"`
+	Min     int64             `json:"minBytes"`
+	Mean    int64             `json:"meanBytes"`
+	Max     int64             `json:"maxBytes"`
+	Buckets []visitSizeBucket `json:"buckets"`
+}
+
+type visitSizeBucket struct {
+	// UpTo is the upper bound of the bucket, in bytes, or zero for the bucket of the largest documents
+	UpTo      int64 `json:"upToBytes,omitempty"`
+	Documents int64 `json:"documents"`
+}
+
+func (c *visitCounter) result(now time.Time) visitCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := visitCount{Documents: c.total, DocumentTypes: make(map[string]int64), ElapsedSeconds: now.Sub(c.started).Round(100 * time.Millisecond).Seconds()}
+	for docType, n := range c.documentTypes {
+		result.DocumentTypes[docType] = n
+	}
+	if c.sizes {
+		sizes := &visitSizes{Total: c.totalSize, Min: c.minSize, Max: c.maxSize, Buckets: []visitSizeBucket{}}
+		if c.total > 0 {
+			sizes.Mean = c.totalSize / c.total
+		}
+		for i, n := range c.buckets {
+			if n == 0 {
+				continue
+			}
+			bucket := visitSizeBucket{Documents: n}
+			if i < len(visitSizeBuckets) {
+				bucket.UpTo = visitSizeBuckets[i]
+			}
+			sizes.Buckets = append(sizes.Buckets, bucket)
+		}
+		result.Sizes = sizes
+	}
+	return result
+}
+
+// print prints the number of documents visited per document type on a single line, e.g.
+// "3 documents in 1.5s: books 1, music 2". With sizes, the size distribution follows on separate lines.
+func (c *visitCounter) print(cli *CLI) error {
+	result := c.result(cli.now())
+	if cli.jsonOutput() {
+		return writeJSON(cli, result)
+	}
+	docTypes := make([]string, 0, len(result.DocumentTypes))
+	for docType := range result.DocumentTypes {
+		docTypes = append(docTypes, docType)
+	}
+	slices.Sort(docTypes)
+	counts := make([]string, 0, len(docTypes))
+	for _, docType := range docTypes {
+		name := docType
+		if name == "" {
+			name = "unknown"
+		}
+		counts = append(counts, fmt.Sprintf("%s %d", name, result.DocumentTypes[docType]))
+	}
+	line := fmt.Sprintf("%d documents in %.1fs", result.Documents, result.ElapsedSeconds)
+	if len(counts) > 0 {
+		line += ": " + strings.Join(counts, ", ")
+	}
+	fmt.Fprintln(cli.Stdout, line)
+	if result.Sizes != nil && result.Documents > 0 {
+		s := result.Sizes
+		fmt.Fprintf(cli.Stdout, "Document sizes: total %s, min %s, mean %s, max %s\n", formatSize(s.Total), formatSize(s.Min), formatSize(s.Mean), formatSize(s.Max))
+		for _, b := range s.Buckets {
+			bound := "larger"
+			if b.UpTo > 0 {
+				bound = "up to " + formatSize(b.UpTo)
+			}
+			fmt.Fprintf(cli.Stdout, "  %-14s %d\n", bound+":", b.Documents)
+		}
+	}
+	return nil
+}
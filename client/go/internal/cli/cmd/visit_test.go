@@ -2,10 +2,19 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/gzip"
 	"github.com/stretchr/testify/assert"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa"
@@ -53,6 +62,186 @@ func TestQuoteFunc(t *testing.T) {
 	}
 }
 
+func TestParseVisitTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in     string
+		micros int64
+	}{
+		{"1700000000", 1700000000000000},
+		{"2024-01-01T00:00:00Z", 1704067200000000},
+		{"2024-01-01T01:00:00+01:00", 1704067200000000},
+		{"2023-12-31T19:00:00-05:00", 1704067200000000},
+		{"2024-01-01T00:00:00.000123Z", 1704067200000123},
+		{"-24h", now.Add(-24 * time.Hour).UnixMicro()},
+		{"-1h30m", now.Add(-90 * time.Minute).UnixMicro()},
+		{"0s", now.UnixMicro()},
+	}
+	for _, tt := range tests {
+		got, err := parseVisitTime(tt.in, now)
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, tt.micros, got.UnixMicro(), tt.in)
+	}
+	for _, in := range []string{"yesterday", "2024-01-01", "2024-01-01T00:00:00", "24 hours"} {
+		_, err := parseVisitTime(in, now)
+		assert.NotNil(t, err, in)
+	}
+}
+
+func TestVisitTimeWindow(t *testing.T) {
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, handlersResponse)
+	client.NextResponseString(200, normalpre+document1+`],"documentCount":1}`)
+	cli, stdout, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default",
+		"--from", "2024-01-01T01:00:00+01:00", "--to", "2024-01-02T00:00:00Z"))
+	assert.Equal(t, document1+"\n", stdout.String())
+	assert.Equal(t, "Visiting documents modified from 2024-01-01T00:00:00Z to 2024-01-02T00:00:00Z\n", stderr.String())
+	assert.Equal(t, "cluster=fooCC&wantedDocumentCount=1000&fromTimestamp=1704067200000000&toTimestamp=1704153600000000&bucketSpace=default&stream=false",
+		client.LastRequest.URL.RawQuery)
+
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--from", "-1h", "--to", "2024-01-02T00:00:00Z"))
+	assert.Contains(t, stderr.String(), "The 'from' argument must be before the 'to' argument")
+
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--to", "tomorrow"))
+	assert.Contains(t, stderr.String(), "Invalid 'to' argument: 'tomorrow'")
+}
+
+func TestVisitOutputFiles(t *testing.T) {
+	visit := func(args ...string) (string, string) {
+		client := &mock.HTTPClient{}
+		client.NextResponseString(200, handlersResponse)
+		client.NextResponseString(200, normalpre+document1+","+document2+`],"documentCount":2,"continuation":"CAFE"}`)
+		client.NextResponseString(200, normalpre+document3+`],"documentCount":1}`)
+		cli, stdout, stderr := newTestCLI(t)
+		cli.httpClient = client
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default"}, args...)
+		assert.Nil(t, cli.Run(args...))
+		return stdout.String(), stderr.String()
+	}
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "dump")
+	stdout, stderr := visit("--output", prefix, "--max-file-size", "100")
+	assert.Equal(t, "", stdout)
+	assert.Equal(t, "Wrote 2 documents to "+prefix+"-00001.jsonl\nWrote 1 documents to "+prefix+"-00002.jsonl\n", stderr)
+	data, err := os.ReadFile(prefix + "-00001.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, document1+"\n"+document2+"\n", string(data))
+	data, err = os.ReadFile(prefix + "-00002.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, document3+"\n", string(data))
+
+	// A document larger than the max size gets a file of its own
+	prefix = filepath.Join(dir, "small")
+	_, stderr = visit("--output", prefix, "--max-file-size", "1")
+	assert.Equal(t, 3, strings.Count(stderr, "Wrote 1 documents"))
+
+	prefix = filepath.Join(dir, "compressed")
+	_, stderr = visit("--output", prefix, "--compress", "gzip")
+	assert.Equal(t, "Wrote 3 documents to "+prefix+"-00001.jsonl.gz\n", stderr)
+	f, err := os.Open(prefix + "-00001.jsonl.gz")
+	assert.Nil(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	data, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, document1+"\n"+document2+"\n"+document3+"\n", string(data))
+
+	cli, _, _ := newTestCLI(t)
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--compress", "gzip"))
+	assert.NotNil(t, cli.Run("visit", "-t", "http://127.0.0.1:8080", "--output", prefix, "--max-file-size", "1T"))
+}
+
+func TestVisitOutputFlush(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "dump")
+	output, err := newVisitOutput(prefix, true, 0, false)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, output.writeDocument([]byte(document1+"\n")))
+	assert.Nil(t, output.flush())
+	// Flushed documents can be read while the file is being written
+	f, err := os.Open(prefix + "-00001.jsonl.gz")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if !assert.Nil(t, err) {
+		return
+	}
+	data, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, document1+"\n", string(data))
+	assert.Nil(t, output.Close())
+}
+
+func TestVisitDestination(t *testing.T) {
+	visit := func(feedStatus int, args ...string) (*mock.HTTPClient, string, string, error) {
+		cli, stdout, stderr := newTestCLI(t)
+		client := cli.httpClient.(*mock.HTTPClient)
+		client.NextResponseString(200, handlersResponse)
+		client.NextResponseString(200, normalpre+document1+","+document2+`],"documentCount":2}`)
+		client.NextResponseString(feedStatus, `{"message":"fed"}`)
+		client.NextResponseString(200, `{"message":"fed"}`)
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default",
+			"--destination", "http://127.0.0.1:9090"}, args...)
+		err := cli.Run(args...)
+		return client, stdout.String(), stderr.String(), err
+	}
+	client, stdout, stderr, err := visit(200, "--selection", "music")
+	assert.Nil(t, err)
+	assert.Equal(t, "Success: Copied documents to http://127.0.0.1:9090: 2 visited, 2 fed successfully\n", stdout)
+	assert.Equal(t, "Copying documents to http://127.0.0.1:9090\n", stderr)
+	assert.Equal(t, 4, len(client.Requests))
+	assert.Contains(t, client.Requests[1].URL.String(), "selection=music")
+	var fed []string
+	for _, request := range client.Requests[2:] {
+		assert.Equal(t, "POST", request.Method)
+		fed = append(fed, request.URL.String())
+	}
+	sort.Strings(fed)
+	assert.Equal(t, []string{
+		"http://127.0.0.1:9090/document/v1/t/m/docid/1",
+		"http://127.0.0.1:9090/document/v1/t/m/docid/2",
+	}, fed)
+
+	_, _, stderr, err = visit(400)
+	assert.NotNil(t, err)
+	assert.Equal(t, "copy incomplete: 2 visited, 1 fed successfully to http://127.0.0.1:9090, 1 failed", err.Error())
+	assert.Contains(t, stderr, "got status 400")
+
+	cli, _, _ := newTestCLI(t)
+	for _, args := range [][]string{
+		{"--destination-zone", "prod.aws-us-east-1c"},
+		{"--destination", "http://127.0.0.1:9090", "--make-feed"},
+		{"--destination", "http://127.0.0.1:9090", "--destination-cert", "cert.pem"},
+		{"--destination", "http://127.0.0.1:9090", "--destination-zone", "prod.aws-us-east-1c"},
+		{"--destination", "not-an-application"},
+		{"--destination", "http://127.0.0.1:9090", "--field-set", "[id]"},
+	} {
+		assert.NotNil(t, cli.Run(append([]string{"visit", "-t", "http://127.0.0.1:8080"}, args...)...), args)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{"": 0, "0": 0, "0M": 0, "100": 100, "2K": 2048, "512M": 512 << 20, "1g": 1 << 30} {
+		got, err := parseByteSize(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"M", "-1", "1.5G", "1T"} {
+		_, err := parseByteSize(in)
+		assert.NotNil(t, err, in)
+	}
+}
+
 // low-level (unit) test
 func TestRunOneVisit(t *testing.T) {
 	withResponse := func(client *mock.HTTPClient) {
@@ -133,6 +322,141 @@ func TestVisitCommand(t *testing.T) {
 			document3+"\n")
 }
 
+// sliceHTTPClient responds to visit requests according to the slice they are for.
+type sliceHTTPClient struct {
+	mu        sync.Mutex
+	responses map[string][]string
+	requests  []string
+}
+
+func (c *sliceHTTPClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, request.URL.RawQuery)
+	body := handlersResponse
+	status := 200
+	if request.URL.Path == "/document/v1/" {
+		sliceId := request.URL.Query().Get("sliceId")
+		responses := c.responses[sliceId]
+		if len(responses) == 0 {
+			return nil, fmt.Errorf("no more responses for slice %s", sliceId)
+		}
+		body = responses[0]
+		c.responses[sliceId] = responses[1:]
+		if strings.Contains(body, `"message"`) {
+			status = 500
+		}
+	}
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+}
+
+func TestVisitParallelSlices(t *testing.T) {
+	newClient := func() *sliceHTTPClient {
+		return &sliceHTTPClient{responses: map[string][]string{
+			"0": {normalpre + document1 + `],"documentCount":1,"continuation":"CAFE"}`, normalpre + document2 + `],"documentCount":1}`},
+			"1": {normalpre + document3 + `],"documentCount":1}`},
+		}}
+	}
+	visit := func(client *sliceHTTPClient, args ...string) (*bytes.Buffer, error) {
+		cli, stdout, _ := newTestCLI(t)
+		cli.httpClient = client
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default", "--slices", "2"}, args...)
+		return stdout, cli.Run(args...)
+	}
+	sortedLines := func(s string) []string {
+		lines := strings.Split(strings.TrimSpace(s), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+
+	client := newClient()
+	stdout, err := visit(client)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{document1, document2, document3}, sortedLines(stdout.String()))
+	assert.Contains(t, client.requests, "cluster=fooCC&continuation=CAFE&wantedDocumentCount=1000&slices=2&sliceId=0&bucketSpace=default&stream=false")
+
+	client = newClient()
+	prefix := filepath.Join(t.TempDir(), "slice-")
+	stdout, err = visit(client, "--slice-output-prefix", prefix)
+	assert.Nil(t, err)
+	assert.Equal(t, "", stdout.String())
+	data, err := os.ReadFile(prefix + "0.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, document1+"\n"+document2+"\n", string(data))
+	data, err = os.ReadFile(prefix + "1.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, document3+"\n", string(data))
+
+	client = newClient()
+	client.responses["1"] = []string{`{"pathId":"/document/v1/","message":"slice failed"}`}
+	_, err = visit(client)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "visit failed")
+
+	_, err = visit(newClient(), "--slice-id", "0", "--slice-output-prefix", prefix)
+	assert.NotNil(t, err)
+}
+
+func TestVisitContinuationFile(t *testing.T) {
+	progressFile := filepath.Join(t.TempDir(), "visit.json")
+	args := []string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default", "--continuation-file", progressFile}
+	client := &mock.HTTPClient{}
+	client.NextResponseString(200, handlersResponse)
+	client.NextResponseString(200, normalpre+document1+`],"documentCount":1,"continuation":"CAFE"}`)
+	client.NextResponseString(500, `{"pathId":"/document/v1/","message":"timeout"}`)
+	cli, stdout, _ := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run(args...))
+	assert.Equal(t, document1+"\n", stdout.String())
+	data, err := os.ReadFile(progressFile)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"selection":"","cluster":"fooCC","slices":-1,"sliceId":-1,"streams":{"default/fooCC/-1":{"continuation":"CAFE"}}}`, string(data))
+
+	// Resuming with different parameters fails
+	cli, _, stderr := newTestCLI(t)
+	cli.httpClient = client
+	assert.NotNil(t, cli.Run(append(args, "--selection", "music")...))
+	assert.Contains(t, stderr.String(), "is for a different visit")
+
+	// Resume from stored continuation
+	client.NextResponseString(200, handlersResponse)
+	client.NextResponseString(200, normalpre+document2+`],"documentCount":1}`)
+	cli, stdout, stderr = newTestCLI(t)
+	cli.httpClient = client
+	assert.Nil(t, cli.Run(args...))
+	assert.Equal(t, document2+"\n", stdout.String())
+	assert.Equal(t, "Resuming visit from "+progressFile+"\n", stderr.String())
+	assert.Equal(t, "cluster=fooCC&continuation=CAFE&wantedDocumentCount=1000&bucketSpace=default&stream=false", client.LastRequest.URL.RawQuery)
+	_, err = os.Stat(progressFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// A relative time window is kept when resuming, and slice output is appended to
+	prefix := filepath.Join(t.TempDir(), "slice-")
+	args = append(args, "--slices", "1", "--slice-output-prefix", prefix, "--make-feed", "--from", "-1h")
+	client.NextResponseString(200, handlersResponse)
+	client.NextResponseString(200, normalpre+document1+`],"documentCount":1,"continuation":"CAFE"}`)
+	client.NextResponseString(500, `{"pathId":"/document/v1/","message":"timeout"}`)
+	cli, _, _ = newTestCLI(t)
+	cli.httpClient = client
+	cli.now = func() time.Time { return time.Unix(7200, 0) }
+	assert.NotNil(t, cli.Run(args...))
+	data, err = os.ReadFile(progressFile)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"selection":"","cluster":"fooCC","slices":1,"sliceId":-1,"from":"1970-01-01T01:00:00Z","streams":{"default/fooCC/0":{"continuation":"CAFE"}}}`, string(data))
+
+	client.NextResponseString(200, handlersResponse)
+	client.NextResponseString(200, normalpre+document2+`],"documentCount":1}`)
+	cli, _, stderr = newTestCLI(t)
+	cli.httpClient = client
+	cli.now = func() time.Time { return time.Unix(10800, 0) }
+	assert.Nil(t, cli.Run(args...))
+	assert.Equal(t, "Resuming visit from "+progressFile+"\nVisiting documents modified from 1970-01-01T01:00:00Z to now\n", stderr.String())
+	assert.Equal(t, "cluster=fooCC&continuation=CAFE&wantedDocumentCount=1000&fromTimestamp=3600000000&slices=1&sliceId=0&bucketSpace=default&stream=false", client.LastRequest.URL.RawQuery)
+	data, err = os.ReadFile(prefix + "0.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "[\n"+document1+",\n"+document2+",\n{}\n]\n", string(data))
+}
+
 func assertVisitResults(arguments []string, t *testing.T, responses []string, queryPart, output string) {
 	t.Helper()
 	client := &mock.HTTPClient{}
@@ -151,3 +475,53 @@ func assertVisitResults(arguments []string, t *testing.T, responses []string, qu
 	assert.Equal(t, "/document/v1/", client.LastRequest.URL.Path)
 	assert.Equal(t, "GET", client.LastRequest.Method)
 }
+
+func TestVisitCount(t *testing.T) {
+	document4 := `{"id":"id:t:books::4","fields":{"title":"a longer title, which makes this document larger"}}`
+	visit := func(output string, args ...string) (*mock.HTTPClient, string, string, error) {
+		cli, stdout, stderr := newTestCLI(t)
+		assert.Nil(t, cli.Run("config", "set", "output", output))
+		cli.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+		client := cli.httpClient.(*mock.HTTPClient)
+		client.NextResponseString(200, handlersResponse)
+		client.NextResponseString(200, normalpre+document1+","+document4+`],"documentCount":2,"continuation":"CAFE"}`)
+		client.NextResponseString(200, normalpre+document2+`],"documentCount":1}`)
+		args = append([]string{"visit", "-t", "http://127.0.0.1:8080", "--content-cluster", "fooCC", "--bucket-space", "default"}, args...)
+		err := cli.Run(args...)
+		return client, stdout.String(), stderr.String(), err
+	}
+	client, stdout, stderr, err := visit("human", "--count", "--selection", "music")
+	assert.Nil(t, err)
+	assert.Equal(t, "3 documents in 0.0s: books 1, m 2\n", stdout)
+	assert.Equal(t, "", stderr)
+	assert.Equal(t, 3, len(client.Requests))
+	assert.Equal(t, "cluster=fooCC&fieldSet=%5Bid%5D&selection=music&wantedDocumentCount=1000&bucketSpace=default&stream=false", client.Requests[1].URL.RawQuery)
+
+	client, stdout, _, err = visit("human", "--stats")
+	assert.Nil(t, err)
+	assert.Equal(t, `3 documents in 0.0s: books 1, m 2
+Document sizes: total 175 B, min 41 B, mean 58 B, max 92 B
+  up to 1.0 KiB: 3
+`, stdout)
+	assert.NotContains(t, client.Requests[1].URL.RawQuery, "fieldSet")
+
+	_, stdout, _, err = visit("json", "--stats")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+  "documents": 3,
+  "documentTypes": {"books": 1, "m": 2},
+  "elapsedSeconds": 0,
+  "sizes": {"totalBytes": 175, "minBytes": 41, "meanBytes": 58, "maxBytes": 92, "buckets": [{"upToBytes": 1024, "documents": 3}]}
+}`, stdout)
+
+	cli, _, _ := newTestCLI(t)
+	for _, args := range [][]string{
+		{"--count", "--stats"},
+		{"--count", "--make-feed"},
+		{"--stats", "--output", "dump"},
+		{"--count", "--destination", "http://127.0.0.1:9090"},
+		{"--count", "--field-set", "[document]"},
+	} {
+		assert.NotNil(t, cli.Run(append([]string{"visit", "-t", "http://127.0.0.1:8080"}, args...)...), args)
+	}
+}
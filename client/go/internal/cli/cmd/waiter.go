@@ -3,6 +3,9 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -14,6 +17,8 @@ import (
 type Waiter struct {
 	// Timeout specifies how long we should wait for an operation to complete.
 	Timeout time.Duration // TODO(mpolden): Consider making this a budget
+	// NoEarlyAbort disables aborting the wait for a deployment when a service keeps failing.
+	NoEarlyAbort bool
 
 	cli *CLI
 	cmd *cobra.Command
@@ -66,10 +71,31 @@ func (w *Waiter) ServiceWithAuthMethod(target vespa.Target, cluster string, auth
 
 // Services returns all container services available on target.
 func (w *Waiter) Services(target vespa.Target) ([]*vespa.Service, error) {
+	return w.ClusterServices(target, "")
+}
+
+// ClusterServices returns the container services of the cluster identified by cluster ID, one for each of its
+// endpoints, available on target. If cluster is empty, the services of all clusters are returned.
+func (w *Waiter) ClusterServices(target vespa.Target, cluster string) ([]*vespa.Service, error) {
+	if cluster != "" {
+		targetType, err := w.cli.targetType(anyTarget)
+		if err != nil {
+			return nil, err
+		}
+		if targetType.url != "" {
+			return nil, fmt.Errorf("cluster cannot be specified when target is an URL")
+		}
+	}
 	services, err := w.services(target)
 	if err != nil {
 		return nil, err
 	}
+	if cluster != "" {
+		services, err = vespa.FindServices(cluster, services)
+		if err != nil {
+			return nil, errHint(err, "The --cluster option specifies the service to use")
+		}
+	}
 	for _, s := range services {
 		if err := w.maybeWaitFor(s); err != nil {
 			return nil, err
@@ -122,5 +148,198 @@ func (w *Waiter) Deployment(target vespa.Target, wantedID int64) (int64, error)
 		// invalid application package
 		timeout = 3 * time.Second
 	}
+	if pt, ok := target.(vespa.ProgressTarget); ok && w.Timeout > 0 {
+		progress := &progressPrinter{cli: w.cli, redraw: w.cli.isTerminal()}
+		var detector *failureDetector
+		if !w.NoEarlyAbort {
+			detector = newFailureDetector()
+		}
+		pt.SetProgressFunc(func(p vespa.ConvergenceProgress) error {
+			progress.report(p)
+			if detector != nil {
+				return detector.check(p)
+			}
+			return nil
+		})
+		defer pt.SetProgressFunc(nil)
+		start := w.cli.now()
+		id, err := target.AwaitDeployment(wantedID, timeout)
+		progress.done()
+		if detector != nil && detector.failed != nil {
+			w.printErrorLog(target, *detector.failed)
+			return id, detector.err
+		}
+		if err == nil {
+			elapsed := w.cli.now().Sub(start).Round(time.Second)
+			w.cli.printInfo("Deployment converged on generation ", color.CyanString(fmt.Sprint(id)), " in ", color.CyanString(elapsed.String()))
+		} else if progress.printed {
+			return id, errHint(err, "Last known state: "+progress.lastState())
+		}
+		return id, err
+	}
 	return target.AwaitDeployment(wantedID, timeout)
 }
+
+// progressPrinter prints progress of a deployment converging on a config generation.
+type progressPrinter struct {
+	cli    *CLI
+	redraw bool
+
+	last      vespa.ConvergenceProgress
+	printed   bool
+	unchanged int
+}
+
+// pendingAfterPolls is the number of polls without change which triggers printing of pending hosts.
+const pendingAfterPolls = 5
+
+func (p *progressPrinter) report(progress vespa.ConvergenceProgress) {
+	changed := !p.printed || progress.Generation != p.last.Generation || progress.Converged != p.last.Converged ||
+		progress.Total != p.last.Total
+	p.last = progress
+	line := fmt.Sprintf("%d/%d services on generation %d", progress.Converged, progress.Total, progress.Generation)
+	if changed {
+		p.unchanged = 0
+	} else {
+		p.unchanged++
+		if p.unchanged < pendingAfterPolls {
+			if !p.redraw {
+				return
+			}
+		} else {
+			p.unchanged = 0
+			if len(progress.Pending) > 0 {
+				line += ", pending: " + strings.Join(progress.Pending, ", ")
+			}
+		}
+	}
+	p.printed = true
+	if p.redraw {
+		fmt.Fprintf(p.cli.Stderr, "\r\033[K%s", line)
+	} else {
+		fmt.Fprintln(p.cli.Stderr, line)
+	}
+}
+
+// lastState returns a description of the last reported progress, including any pending services.
+func (p *progressPrinter) lastState() string {
+	state := fmt.Sprintf("%d/%d services on generation %d", p.last.Converged, p.last.Total, p.last.Generation)
+	if len(p.last.Pending) > 0 {
+		state += ", pending: " + strings.Join(p.last.Pending, ", ")
+	}
+	return state
+}
+
+func (p *progressPrinter) done() {
+	if p.redraw && p.printed {
+		fmt.Fprintln(p.cli.Stderr)
+	}
+}
+
+const (
+	// failedPolls is the number of consecutive polls a service can be down before the wait for a deployment is
+	// aborted.
+	failedPolls = 30
+	// failedRestarts is the number of times a service can come up and go down again before the wait for a deployment
+	// is aborted.
+	failedRestarts = 3
+	// errorLogEntries is the number of error log entries of a failing service which are printed.
+	errorLogEntries = 20
+	// errorLogPeriod is how far back error log entries of a failing service are read.
+	errorLogPeriod = 10 * time.Minute
+)
+
+// failureDetector detects services which stay down, or crash-loop, while a deployment converges.
+type failureDetector struct {
+	down  map[string]int // Number of consecutive polls each service has been down
+	downs map[string]int // Number of times each service has gone down
+
+	failed *vespa.ServiceDetail
+	err    error
+}
+
+func newFailureDetector() *failureDetector {
+	return &failureDetector{down: make(map[string]int), downs: make(map[string]int)}
+}
+
+// check records the services which are down in progress, and returns an error if any of them has been down for too
+// many consecutive polls, or has gone down too many times.
+func (d *failureDetector) check(progress vespa.ConvergenceProgress) error {
+	failing := make(map[string]bool)
+	for _, s := range progress.Failing {
+		failing[serviceHostPort(s)] = true
+	}
+	for key := range d.down {
+		if !failing[key] {
+			delete(d.down, key)
+		}
+	}
+	for _, s := range progress.Failing {
+		key := serviceHostPort(s)
+		if d.down[key] == 0 {
+			d.downs[key]++
+		}
+		d.down[key]++
+		var reason string
+		if d.down[key] >= failedPolls {
+			reason = fmt.Sprintf("it has been down for %d consecutive polls", d.down[key])
+		} else if d.downs[key] > failedRestarts {
+			reason = fmt.Sprintf("it has gone down %d times, and appears to be crash-looping", d.downs[key])
+		} else {
+			continue
+		}
+		d.failed = &s
+		d.err = errHint(fmt.Errorf("service %s on %s is failing: %s", serviceDescription(s), key, reason),
+			"Use --no-early-abort to wait for the full timeout")
+		return d.err
+	}
+	return nil
+}
+
+func serviceHostPort(s vespa.ServiceDetail) string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+func serviceDescription(s vespa.ServiceDetail) string {
+	if s.Cluster == "" {
+		return s.Type
+	}
+	return s.Type + " in cluster " + s.Cluster
+}
+
+// lastLogEntries holds the last n log entries written to it.
+type lastLogEntries struct {
+	n       int
+	entries []vespa.LogEntry
+}
+
+func (l *lastLogEntries) WriteEntry(entry vespa.LogEntry) error {
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.n {
+		l.entries = l.entries[1:]
+	}
+	return nil
+}
+
+// printErrorLog prints the last error log entries of service, as context for why it failed.
+func (w *Waiter) printErrorLog(target vespa.Target, service vespa.ServiceDetail) {
+	last := &lastLogEntries{n: errorLogEntries}
+	options := vespa.LogOptions{
+		From:        w.cli.now().Add(-errorLogPeriod),
+		Level:       vespa.LogLevel("error"),
+		Filter:      vespa.LogFilter{Hosts: []string{service.Host}, Services: []string{service.Type}},
+		EntryWriter: last,
+	}
+	if err := target.PrintLog(options); err != nil {
+		w.cli.printWarning(fmt.Errorf("could not read log of failing service: %w", err))
+		return
+	}
+	if len(last.entries) == 0 {
+		w.cli.printInfo("No errors logged by ", serviceDescription(service), " on ", service.Host, " in the last ", errorLogPeriod.String())
+		return
+	}
+	w.cli.printInfo("Last errors logged by ", serviceDescription(service), " on ", service.Host, ":")
+	for _, entry := range last.entries {
+		fmt.Fprintln(w.cli.Stderr, entry.Format(true))
+	}
+}
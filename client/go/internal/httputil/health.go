@@ -0,0 +1,250 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minDialTimeout is the shortest time given to a connection attempt, when the time left of a request is divided
+// between the endpoints it may connect to.
+const minDialTimeout = 2 * time.Second
+
+// EndpointHealth tracks the health of endpoints, i.e., the resolved addresses connections are made to, and keeps
+// requests away from endpoints which failed recently. An endpoint failing Failures times in a row, by refusing or
+// timing out a connection, or by failing a request sent over one, is marked unhealthy. Unhealthy endpoints are not
+// connected to until Cooldown has passed, when a single connection is tried again, and a request succeeding over
+// it marks the endpoint healthy again. A host resolving to several endpoints is connected to at a healthy one, if
+// any, and requests fail immediately when all its endpoints are unhealthy, instead of waiting for their timeout.
+//
+// Health is kept in memory only, and is shared by all clients configured with the same EndpointHealth.
+type EndpointHealth struct {
+	// Failures is the number of consecutive failures which marks an endpoint unhealthy
+	Failures int
+	// Cooldown is how long an unhealthy endpoint is avoided before it is tried again
+	Cooldown time.Duration
+	// OnSelect is called, if non-nil, before connecting to an endpoint of an address resolving to several, with the
+	// chosen endpoint and the endpoints which were skipped as unhealthy, or failed to connect before it
+	OnSelect func(addr, endpoint string, skipped []string)
+	// OnUnhealthy is called, if non-nil, when an endpoint is marked unhealthy, with the failure marking it
+	OnUnhealthy func(endpoint string, err error)
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointState
+	now       func() time.Time
+	lookup    func(ctx context.Context, host string) ([]string, error)
+}
+
+type endpointState struct {
+	failures       int
+	unhealthySince time.Time // Zero if healthy
+	retryAt        time.Time
+	err            error
+}
+
+// UnhealthyError is returned for requests to addresses whose endpoints are all marked unhealthy.
+type UnhealthyError struct {
+	Endpoint string
+	Since    time.Time
+	RetryAt  time.Time
+	Err      error // The last failure of the endpoint
+}
+
+func (e *UnhealthyError) Error() string {
+	return fmt.Sprintf("endpoint %s marked unhealthy since %s, not trying it again until %s: %s",
+		e.Endpoint, e.Since.Format(time.TimeOnly), e.RetryAt.Format(time.TimeOnly), e.Err)
+}
+
+// NewEndpointHealth returns a health tracker which marks endpoints unhealthy after 2 consecutive failures, and tries
+// them again after 30 seconds.
+func NewEndpointHealth() *EndpointHealth {
+	return &EndpointHealth{Failures: 2, Cooldown: 30 * time.Second}
+}
+
+// ConfigureEndpointHealth configures the given client to track the health of the endpoints it connects to with
+// health, and to avoid the unhealthy ones. A nil health disables tracking.
+func ConfigureEndpointHealth(client Client, health *EndpointHealth) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.health = health
+}
+
+func (h *EndpointHealth) clock() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+func (h *EndpointHealth) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if h.lookup != nil {
+		return h.lookup(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// choose returns the endpoints to try connecting to, in order, and the unhealthy endpoints which were skipped. Healthy
+// endpoints with fewer recent failures are tried first, and unhealthy ones due to be tried again last. An error is
+// returned if all endpoints are unhealthy.
+func (h *EndpointHealth) choose(endpoints []string) ([]string, []string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.clock()
+	var healthy, probed, skipped []string
+	var unhealthy *endpointState
+	for _, endpoint := range endpoints {
+		s := h.endpoints[endpoint]
+		switch {
+		case s == nil || s.unhealthySince.IsZero():
+			healthy = append(healthy, endpoint)
+		case !now.Before(s.retryAt):
+			s.retryAt = now.Add(h.Cooldown) // Let only one connection through until the endpoint is healthy again
+			probed = append(probed, endpoint)
+		default:
+			skipped = append(skipped, endpoint)
+			if unhealthy == nil || s.retryAt.Before(unhealthy.retryAt) {
+				unhealthy = s
+			}
+		}
+	}
+	slices.SortStableFunc(healthy, func(a, b string) int { return h.failures(a) - h.failures(b) })
+	candidates := append(healthy, probed...)
+	if len(candidates) == 0 {
+		return nil, skipped, &UnhealthyError{Endpoint: skipped[0], Since: unhealthy.unhealthySince, RetryAt: unhealthy.retryAt, Err: unhealthy.err}
+	}
+	return candidates, skipped, nil
+}
+
+func (h *EndpointHealth) failures(endpoint string) int {
+	if s := h.endpoints[endpoint]; s != nil {
+		return s.failures
+	}
+	return 0
+}
+
+// fail records a failure of endpoint, and returns whether it was marked unhealthy by it.
+func (h *EndpointHealth) fail(endpoint string, err error) bool {
+	h.mu.Lock()
+	if h.endpoints == nil {
+		h.endpoints = make(map[string]*endpointState)
+	}
+	s := h.endpoints[endpoint]
+	if s == nil {
+		s = &endpointState{}
+		h.endpoints[endpoint] = s
+	}
+	now := h.clock()
+	s.failures++
+	s.err = err
+	marked := false
+	if s.failures >= max(h.Failures, 1) {
+		if s.unhealthySince.IsZero() {
+			s.unhealthySince = now
+			marked = true
+		}
+		s.retryAt = now.Add(h.Cooldown)
+	}
+	h.mu.Unlock()
+	if marked && h.OnUnhealthy != nil {
+		h.OnUnhealthy(endpoint, err)
+	}
+	return marked
+}
+
+// succeed records a success of endpoint, marking it healthy.
+func (h *EndpointHealth) succeed(endpoint string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.endpoints, endpoint)
+}
+
+// dial connects to addr with dial, at the healthy endpoints it resolves to first.
+func (h *EndpointHealth) dial(ctx context.Context, network, addr string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return dial(ctx, network, addr)
+	}
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		hosts, err = h.lookupHost(ctx, host)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+	}
+	endpoints := make([]string, len(hosts))
+	for i, ip := range hosts {
+		endpoints[i] = net.JoinHostPort(ip, port)
+	}
+	candidates, skipped, err := h.choose(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for i, endpoint := range candidates {
+		if len(endpoints) > 1 && h.OnSelect != nil {
+			h.OnSelect(addr, endpoint, skipped)
+		}
+		dialCtx, cancel := partialDeadline(ctx, len(candidates)-i)
+		conn, err := dial(dialCtx, network, endpoint)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, context.Canceled) {
+			h.fail(endpoint, err)
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		skipped = append(skipped, endpoint)
+	}
+	return nil, lastErr
+}
+
+// partialDeadline returns a context for one of given number of connection attempts remaining, which share the time
+// left of ctx.
+func partialDeadline(ctx context.Context, attempts int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || attempts <= 1 {
+		return ctx, func() {}
+	}
+	timeout := max(time.Until(deadline)/time.Duration(attempts), minDialTimeout)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// wrap returns a function sending requests with send, and recording their outcome for the endpoint they were sent
+// to. Requests cancelled by their caller are not recorded. onUnhealthy is called when an endpoint is marked unhealthy.
+func (h *EndpointHealth) wrap(send func(*http.Request) (*http.Response, error), onUnhealthy func()) func(*http.Request) (*http.Response, error) {
+	return func(request *http.Request) (*http.Response, error) {
+		var endpoint atomic.Pointer[string]
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				addr := info.Conn.RemoteAddr().String()
+				endpoint.Store(&addr)
+			},
+		}
+		response, err := send(request.WithContext(httptrace.WithClientTrace(request.Context(), trace)))
+		addr := endpoint.Load()
+		switch {
+		case addr == nil || request.Context().Err() == context.Canceled:
+		case err != nil:
+			if h.fail(*addr, err) {
+				onUnhealthy() // Drop idle connections to the endpoint, so that requests connect to another
+			}
+		default:
+			h.succeed(*addr)
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flappingDialer fails connections to the endpoints in down, and records the endpoints it connected to.
+type flappingDialer struct {
+	down      map[string]bool
+	connected []string
+}
+
+func (d *flappingDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.connected = append(d.connected, addr)
+	if d.down[addr] {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func newTestHealth(now *time.Time) *EndpointHealth {
+	health := NewEndpointHealth()
+	health.now = func() time.Time { return *now }
+	health.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+	return health
+}
+
+func TestEndpointHealthFlapping(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	health := newTestHealth(&now)
+	var unhealthy []string
+	health.OnUnhealthy = func(endpoint string, err error) { unhealthy = append(unhealthy, endpoint) }
+	dialer := &flappingDialer{down: map[string]bool{"10.0.0.1:443": true}}
+	dial := func() {
+		conn, err := health.dial(context.Background(), "tcp", "example.com:443", dialer.dial)
+		require.Nil(t, err)
+		conn.Close()
+	}
+
+	// The endpoint failing is tried after the other, once it has failed, and is marked unhealthy after failing again
+	dial()
+	assert.Equal(t, []string{"10.0.0.1:443", "10.0.0.2:443"}, dialer.connected)
+	health.succeed("10.0.0.1:443") // It flaps back up, and a request over it succeeds
+	dialer.connected = nil
+	dial()
+	assert.Equal(t, []string{"10.0.0.1:443", "10.0.0.2:443"}, dialer.connected)
+	assert.Empty(t, unhealthy)
+	health.fail("10.0.0.1:443", errors.New("timeout"))
+	assert.Equal(t, []string{"10.0.0.1:443"}, unhealthy)
+
+	// The unhealthy endpoint is skipped until its cooldown has passed
+	dialer.connected = nil
+	dial()
+	now = now.Add(29 * time.Second)
+	dial()
+	assert.Equal(t, []string{"10.0.0.2:443", "10.0.0.2:443"}, dialer.connected)
+
+	// It is then tried once, after the healthy one, and stays unhealthy since it first failed
+	now = now.Add(time.Second)
+	dialer.down = map[string]bool{"10.0.0.2:443": true, "10.0.0.1:443": true}
+	dialer.connected = nil
+	_, err := health.dial(context.Background(), "tcp", "example.com:443", dialer.dial)
+	require.NotNil(t, err)
+	assert.Equal(t, []string{"10.0.0.2:443", "10.0.0.1:443"}, dialer.connected)
+	assert.Equal(t, []string{"10.0.0.1:443"}, unhealthy)
+
+	// With both endpoints unhealthy, connections fail immediately
+	health.fail("10.0.0.2:443", errors.New("timeout"))
+	dialer.connected = nil
+	_, err = health.dial(context.Background(), "tcp", "example.com:443", dialer.dial)
+	var unhealthyErr *UnhealthyError
+	require.ErrorAs(t, err, &unhealthyErr)
+	assert.Empty(t, dialer.connected)
+	assert.Equal(t, "endpoint 10.0.0.1:443 marked unhealthy since 12:00:00, not trying it again until 12:01:00: "+
+		"dial tcp: connection refused", err.Error())
+
+	// A success marks the endpoint healthy again
+	now = now.Add(time.Minute)
+	dialer.down = nil
+	dialer.connected = nil
+	dial()
+	health.succeed("10.0.0.1:443")
+	dial()
+	assert.Equal(t, []string{"10.0.0.1:443", "10.0.0.1:443"}, dialer.connected)
+}
+
+func TestEndpointHealthSelection(t *testing.T) {
+	now := time.Now()
+	health := newTestHealth(&now)
+	var selected []string
+	health.OnSelect = func(addr, endpoint string, skipped []string) {
+		selected = append(selected, addr+" "+endpoint+" "+strings.Join(skipped, ","))
+	}
+	dialer := &flappingDialer{}
+	health.fail("10.0.0.1:443", errors.New("timeout"))
+	health.fail("10.0.0.1:443", errors.New("timeout"))
+	conn, err := health.dial(context.Background(), "tcp", "example.com:443", dialer.dial)
+	require.Nil(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"example.com:443 10.0.0.2:443 10.0.0.1:443"}, selected)
+
+	// Addresses which are not resolved to several endpoints are not reported
+	selected = nil
+	conn, err = health.dial(context.Background(), "tcp", "10.0.0.3:443", dialer.dial)
+	require.Nil(t, err)
+	conn.Close()
+	assert.Empty(t, selected)
+}
+
+func TestEndpointHealthFailsFast(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close() // Connections are refused
+
+	client := NewClient(10 * time.Second)
+	ConfigureEndpointHealth(client, NewEndpointHealth())
+	request, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = client.Do(request, time.Second)
+		assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	}
+	_, err = client.Do(request, time.Second)
+	var unhealthyErr *UnhealthyError
+	require.ErrorAs(t, err, &unhealthyErr)
+	assert.Contains(t, err.Error(), "endpoint "+addr+" marked unhealthy since ")
+
+	// Requests failing over an established connection count as well
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	health := NewEndpointHealth()
+	ConfigureEndpointHealth(client, health)
+	slow, err := http.NewRequest("GET", server.URL+"/slow", nil)
+	require.Nil(t, err)
+	fast, err := http.NewRequest("GET", server.URL+"/", nil)
+	require.Nil(t, err)
+	_, err = client.Do(slow, 50*time.Millisecond)
+	require.NotNil(t, err)
+	response, err := client.Do(fast, time.Second) // Succeeding resets the failure count
+	require.Nil(t, err)
+	response.Body.Close()
+	for i := 0; i < 2; i++ {
+		_, err = client.Do(slow, 50*time.Millisecond)
+		require.NotNil(t, err)
+	}
+	_, err = client.Do(fast, time.Second)
+	require.ErrorAs(t, err, &unhealthyErr)
+	assert.Equal(t, server.Listener.Addr().String(), unhealthyErr.Endpoint)
+}
@@ -2,17 +2,26 @@
 package httputil
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/internal/build"
+	"golang.org/x/net/http/httpproxy"
 	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
 )
 
 // Client represents a HTTP client usable by the Vespa CLI.
@@ -21,18 +30,72 @@ type Client interface {
 }
 
 type defaultClient struct {
-	client *http.Client
+	client    *http.Client
+	proxy     func(*http.Request) (*url.URL, error)
+	overrides EndpointOverrides
+	retry     RetryPolicy
+	trace     *Tracer
+	health    *EndpointHealth
+	ctx       context.Context
+
+	connections atomic.Int64
+	protocol    atomic.Pointer[string]
 }
 
+// errNoHTTP2 is returned when dialing a TLS server which does not negotiate HTTP/2.
+var errNoHTTP2 = errors.New("server does not support HTTP/2")
+
 func (c *defaultClient) Do(request *http.Request, timeout time.Duration) (response *http.Response, error error) {
-	if c.client.Timeout != timeout { // Set wanted timeout
-		c.client.Timeout = timeout
-	}
 	if request.Header == nil {
 		request.Header = make(http.Header)
 	}
 	request.Header.Set("User-Agent", fmt.Sprintf("Vespa CLI/%s", build.Version))
-	return c.client.Do(request)
+	if c.ctx != nil && request.Context() == context.Background() {
+		request = request.WithContext(c.ctx)
+	}
+	send := c.sender(timeout)
+	if c.health != nil {
+		send = c.health.wrap(send, c.client.CloseIdleConnections)
+	}
+	if c.trace != nil {
+		send = c.trace.wrap(send)
+	}
+	response, err := c.retry.do(request, send)
+	if err == nil {
+		c.protocol.Store(&response.Proto)
+	}
+	return response, err
+}
+
+// sender returns a function sending each request with a deadline of timeout, if positive, which also applies to reading
+// the body of the response. The deadline is set on the request, and not on the client, as the client may be shared by
+// concurrent requests with different timeouts.
+func (c *defaultClient) sender(timeout time.Duration) func(*http.Request) (*http.Response, error) {
+	if timeout <= 0 {
+		return c.client.Do
+	}
+	return func(request *http.Request) (*http.Response, error) {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		response, err := c.client.Do(request.WithContext(ctx))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		response.Body = &cancelOnClose{ReadCloser: response.Body, cancel: cancel}
+		return response, nil
+	}
+}
+
+// cancelOnClose is a response body which cancels the context of its request when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // ConfigureTLS configures the given client with given certificates and caCertificate. If trustAll is true, the client
@@ -59,46 +122,295 @@ func ConfigureTLS(client Client, certificates []tls.Certificate, caCertificate [
 		tr.TLSClientConfig = tlsConfig
 	} else if tr, ok := c.client.Transport.(*http2.Transport); ok {
 		tr.TLSClientConfig = tlsConfig
+	} else if tr, ok := c.client.Transport.(*fallbackTransport); ok {
+		tr.h2.TLSClientConfig = tlsConfig
+		tr.h1.TLSClientConfig = tlsConfig
 	} else {
 		panic(fmt.Sprintf("unknown transport type: %T", c.client.Transport))
 	}
 }
 
-// ForceHTTP2 configures the given client exclusively with a HTTP/2 transport. The other options are passed to
-// ConfigureTLS. If certificates is nil, the client will be configured with H2C (HTTP/2 over clear-text).
+// ForceHTTP2 configures the given client with a HTTP/2 transport. The other options are passed to ConfigureTLS. If
+// certificates is nil, the client will be configured with H2C (HTTP/2 over clear-text).
+//
+// All requests of the client are multiplexed on a single connection, which is reused for as long as the server keeps
+// it open. If a TLS server does not negotiate HTTP/2, the client falls back to a pool of HTTP/1.1 connections, see
+// ConfigureStreams.
 func ForceHTTP2(client Client, certificates []tls.Certificate, caCertificate []byte, trustAll bool) {
 	c, ok := client.(*defaultClient)
 	if !ok {
 		return
 	}
-	var dialFunc func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error)
-	if certificates == nil {
-		// No certificate, so force H2C (HTTP/2 over clear-text) by using a non-TLS Dialer
-		dialer := net.Dialer{}
-		dialFunc = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, addr)
+	// No certificate, so force H2C (HTTP/2 over clear-text) by using a non-TLS connection
+	useTLS := certificates != nil
+	dialFunc := func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+		if !useTLS {
+			cfg = nil
+		} else if !slices.Contains(cfg.NextProtos, "http/1.1") {
+			// Offer HTTP/1.1 as well, as servers may reject the handshake otherwise, preventing fallback to HTTP/1.1
+			cfg = cfg.Clone()
+			cfg.NextProtos = append(cfg.NextProtos, "http/1.1")
 		}
+		conn, err := c.dial(ctx, network, addr, cfg)
+		if err == nil {
+			c.connections.Add(1)
+		}
+		return conn, err
 	}
 	// Use HTTP/2 transport explicitly. Connection reuse does not work properly when using regular http.Transport, even
 	// though it upgrades to HTTP/2 automatically
 	// https://github.com/golang/go/issues/16582
 	// https://github.com/golang/go/issues/22091
-	c.client.Transport = &http2.Transport{
+	h2 := &http2.Transport{
 		DisableCompression: true,
 		AllowHTTP:          true,
 		DialTLSContext:     dialFunc,
+		// Wait for a free stream instead of opening another connection when the server's stream limit is reached
+		StrictMaxConcurrentStreams: true,
 	}
+	h1 := c.newTransport()
+	h1.DisableCompression = true
+	h1.ForceAttemptHTTP2 = false
+	h1.MaxIdleConnsPerHost = h1.MaxIdleConns
+	c.client.Transport = &fallbackTransport{h2: h2, h1: h1}
 	ConfigureTLS(client, certificates, caCertificate, trustAll)
 }
 
-// NewClients creates a new HTTP client the given default timeout.
-func NewClient(timeout time.Duration) Client {
-	return &defaultClient{
-		client: &http.Client{
-			Timeout:   timeout,
-			Transport: http.DefaultTransport,
-		},
+// ConfigureProxy configures the given client to choose the proxy of each request with proxy. A nil proxy, or one
+// returning a nil URL, means that no proxy is used.
+func ConfigureProxy(client Client, proxy func(*http.Request) (*url.URL, error)) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.proxy = proxy
+}
+
+// requestProxy returns the proxy to use for request, or nil if it is sent directly, because there is no proxy for it,
+// or its address is overridden.
+func (c *defaultClient) requestProxy(request *http.Request) (*url.URL, error) {
+	if c.proxy == nil {
+		return nil, nil
+	}
+	if _, ok := c.overrides.lookup(urlAddr(request.URL)); ok {
+		return nil, nil
+	}
+	return c.proxy(request)
+}
+
+// ConfigureContext configures the given client to send requests which have no context of their own with ctx, such that
+// cancelling ctx cancels them, and any retries of them. A nil ctx leaves requests as they are.
+func ConfigureContext(client Client, ctx context.Context) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.ctx = ctx
+}
+
+// Context returns the context configured for the given client with ConfigureContext, or context.Background() if none
+// is.
+func Context(client Client) context.Context {
+	if c, ok := client.(*defaultClient); ok && c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// ProxyFunc returns a function choosing the proxy of a request from the HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// variables, or their lowercase variants, in environment env. ALL_PROXY is used for the scheme whose variable is unset.
+// Proxies may be HTTP, HTTPS or SOCKS5 proxies. Requests to localhost are never proxied.
+func ProxyFunc(env map[string]string) func(*http.Request) (*url.URL, error) {
+	getEnv := func(names ...string) string {
+		for _, name := range names {
+			if value := env[name]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	config := httpproxy.Config{
+		HTTPProxy:  getEnv("HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"),
+		HTTPSProxy: getEnv("HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"),
+		NoProxy:    getEnv("NO_PROXY", "no_proxy"),
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(request *http.Request) (*url.URL, error) { return proxyFunc(request.URL) }
+}
+
+// dial connects to addr, or the address overriding it, through a CONNECT tunnel or SOCKS5 proxy if this has a proxy
+// for it. If tlsConfig is non-nil, a TLS connection negotiating HTTP/2 is established.
+func (c *defaultClient) dial(ctx context.Context, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	proxyURL, err := c.requestProxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{}
+	switch {
+	case proxyURL == nil:
+		conn, err = c.dialEndpoint(ctx, network, c.overrides.apply(addr), dialer.DialContext)
+	case proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h":
+		conn, err = dialSOCKS(ctx, dialer, proxyURL, addr)
+	default:
+		conn, err = dialTunnel(ctx, dialer, proxyURL, addr)
+	}
+	if err != nil || tlsConfig == nil {
+		return conn, err
+	}
+	if _, ok := c.overrides.lookup(addr); ok && c.overrides.Insecure {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.InsecureSkipVerify = true
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if p := tlsConn.ConnectionState().NegotiatedProtocol; p != http2.NextProtoTLS {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected ALPN protocol %q: want %q: %w", p, http2.NextProtoTLS, errNoHTTP2)
+	}
+	return tlsConn, nil
+}
+
+// dialSOCKS connects to addr through the SOCKS5 proxy at proxyURL.
+func dialSOCKS(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	d, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect through proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// dialTunnel connects to addr through a CONNECT tunnel opened by the proxy at proxyURL.
+func dialTunnel(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("could not connect to proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+	connect := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		connect.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := connect.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open tunnel through proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	// The proxy sends nothing after its response until the client starts talking, so buffered reading is safe
+	response, err := http.ReadResponse(bufio.NewReader(conn), connect)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open tunnel through proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("could not open tunnel through proxy %s: got status %d", proxyURL.Redacted(), response.StatusCode)
+	}
+	return conn, nil
+}
+
+// NewClient creates a new HTTP client. Each request is sent with the timeout given to Do, so the timeout given here
+// is not used. The client uses proxies given by the environment of this process, unless configured otherwise with
+// ConfigureProxy.
+func NewClient(_ time.Duration) Client {
+	c := &defaultClient{proxy: http.ProxyFromEnvironment}
+	c.client = &http.Client{
+		Transport: c.newTransport(),
+	}
+	return c
+}
+
+// Clone returns a client configured like the given client, but with its own transport, such that configuring the TLS of
+// either client does not affect the other. A client not created by NewClient is returned as is.
+func Clone(client Client) Client {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return client
+	}
+	clone := &defaultClient{proxy: c.proxy, overrides: c.overrides, retry: c.retry, trace: c.trace, health: c.health, ctx: c.ctx}
+	clone.client = &http.Client{CheckRedirect: c.client.CheckRedirect, Jar: c.client.Jar}
+	switch tr := c.client.Transport.(type) {
+	case *http.Transport:
+		h1 := clone.newTransport()
+		h1.TLSClientConfig = tr.TLSClientConfig.Clone()
+		clone.client.Transport = h1
+	case *fallbackTransport:
+		var certificates []tls.Certificate // H2C unless the client uses TLS
+		if tr.h2.TLSClientConfig != nil {
+			certificates = tr.h2.TLSClientConfig.Certificates
+			if certificates == nil {
+				certificates = []tls.Certificate{}
+			}
+		}
+		ForceHTTP2(clone, certificates, nil, false)
+		cloned := clone.client.Transport.(*fallbackTransport)
+		cloned.h2.TLSClientConfig = tr.h2.TLSClientConfig.Clone()
+		cloned.h1.TLSClientConfig = tr.h1.TLSClientConfig.Clone()
+	default:
+		panic(fmt.Sprintf("unknown transport type: %T", c.client.Transport))
+	}
+	return clone
+}
+
+// newTransport returns a HTTP/1.1 transport using the proxy and endpoint overrides of this, and counting the
+// connections it opens.
+func (c *defaultClient) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = c.requestProxy
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := c.dialEndpoint(ctx, network, c.overrides.apply(addr), dial)
+		if err == nil {
+			c.connections.Add(1)
+		}
+		return conn, err
+	}
+	c.configureDialTLS(transport)
+	return transport
+}
+
+// dialEndpoint connects to addr with dial, avoiding its unhealthy endpoints if this tracks endpoint health.
+func (c *defaultClient) dialEndpoint(ctx context.Context, network, addr string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (net.Conn, error) {
+	if c.health == nil {
+		return dial(ctx, network, addr)
 	}
+	return c.health.dial(ctx, network, addr, dial)
 }
 
 // ParseHeader parses headers slice into a http.Header. Each element in the slice is expected to contain a string on
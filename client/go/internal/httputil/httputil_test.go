@@ -0,0 +1,246 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// connectProxy is a proxy which tunnels all CONNECT requests to backend, regardless of the requested address.
+type connectProxy struct {
+	backend string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	p.hosts = append(p.hosts, r.Host)
+	p.mu.Unlock()
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		backend.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		io.Copy(backend, conn)
+		backend.Close()
+	}()
+	io.Copy(conn, backend)
+	conn.Close()
+}
+
+func (p *connectProxy) requestedHosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func protoHandler(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.Proto) }
+
+func startProxy(t *testing.T, backend *httptest.Server) (*connectProxy, func(*http.Request) (*url.URL, error)) {
+	t.Helper()
+	proxy := &connectProxy{backend: backend.Listener.Addr().String()}
+	proxyServer := httptest.NewServer(proxy)
+	t.Cleanup(proxyServer.Close)
+	env := map[string]string{
+		"HTTPS_PROXY": proxyServer.URL,
+		"HTTP_PROXY":  proxyServer.URL,
+		"NO_PROXY":    "internal.example",
+	}
+	return proxy, ProxyFunc(env)
+}
+
+func get(t *testing.T, client Client, url string) string {
+	t.Helper()
+	request, err := http.NewRequest("GET", url, nil)
+	require.Nil(t, err)
+	response, err := client.Do(request, 10*time.Second)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.Nil(t, err)
+	return string(body)
+}
+
+func TestProxyFunc(t *testing.T) {
+	proxy := ProxyFunc(map[string]string{
+		"https_proxy": "http://proxy.example:3128",
+		"NO_PROXY":    "internal.example",
+	})
+	for _, tt := range []struct {
+		url   string
+		proxy string
+	}{
+		{"https://vespa.example", "http://proxy.example:3128"},
+		{"https://vespa.example:4443/status.html", "http://proxy.example:3128"},
+		{"http://vespa.example", ""},
+		{"https://internal.example", ""},
+		{"https://config.internal.example", ""},
+		{"https://127.0.0.1:8080", ""},
+	} {
+		u, err := url.Parse(tt.url)
+		require.Nil(t, err)
+		proxyURL, err := proxy(&http.Request{URL: u})
+		require.Nil(t, err)
+		if tt.proxy == "" {
+			assert.Nil(t, proxyURL, tt.url)
+		} else {
+			require.NotNil(t, proxyURL, tt.url)
+			assert.Equal(t, tt.proxy, proxyURL.String(), tt.url)
+		}
+	}
+}
+
+func TestConnectProxy(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(protoHandler))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	proxy, proxyFunc := startProxy(t, backend)
+
+	client := NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ConfigureTLS(client, []tls.Certificate{}, nil, true)
+	assert.Equal(t, "HTTP/2.0", get(t, client, "https://vespa.example:4443/"))
+	assert.Equal(t, []string{"vespa.example:4443"}, proxy.requestedHosts())
+
+	// HTTP/2 transport, as used when feeding with mTLS
+	client = NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ForceHTTP2(client, []tls.Certificate{}, nil, true)
+	assert.Equal(t, "HTTP/2.0", get(t, client, "https://feed.example:4443/"))
+	assert.Equal(t, []string{"vespa.example:4443", "feed.example:4443"}, proxy.requestedHosts())
+}
+
+func TestConnectProxyH2C(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(protoHandler), &http2.Server{}))
+	defer backend.Close()
+	proxy, proxyFunc := startProxy(t, backend)
+
+	client := NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ForceHTTP2(client, nil, nil, false)
+	assert.Equal(t, "HTTP/2.0", get(t, client, "http://feed.example:8080/"))
+	assert.Equal(t, []string{"feed.example:8080"}, proxy.requestedHosts())
+}
+
+func TestNoProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(protoHandler))
+	defer backend.Close()
+	proxy, proxyFunc := startProxy(t, backend)
+
+	client := NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	assert.Equal(t, "HTTP/1.1", get(t, client, backend.URL))
+	assert.Empty(t, proxy.requestedHosts())
+}
+
+func TestConfigureContext(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Minute)
+	assert.Equal(t, context.Background(), Context(client))
+	ctx, cancel := context.WithCancel(context.Background())
+	ConfigureContext(client, ctx)
+	assert.Equal(t, ctx, Context(client))
+	go func() {
+		<-started
+		cancel()
+	}()
+	request, err := http.NewRequest("GET", server.URL, nil)
+	require.Nil(t, err)
+	start := time.Now()
+	_, err = client.Do(request, time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestClientTimeoutPerRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	// The timeout of one request does not affect other requests sent concurrently with the same client
+	client := NewClient(time.Minute)
+	timeouts := []time.Duration{50 * time.Millisecond, 0, time.Minute}
+	errs := make([]error, len(timeouts))
+	bodies := make([]string, len(timeouts))
+	var wg sync.WaitGroup
+	for i, timeout := range timeouts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request, err := http.NewRequest("GET", server.URL, nil)
+			require.Nil(t, err)
+			response, err := client.Do(request, timeout)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			errs[i] = err
+			bodies[i] = string(body)
+		}()
+	}
+	wg.Wait()
+	var netErr net.Error
+	require.ErrorAs(t, errs[0], &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Nil(t, errs[1])
+	assert.Equal(t, "done", bodies[1])
+	assert.Nil(t, errs[2])
+	assert.Equal(t, "done", bodies[2])
+}
+
+func TestClone(t *testing.T) {
+	certificates := []tls.Certificate{{}}
+	client := NewClient(time.Minute)
+	ConfigureTLS(client, certificates, nil, false)
+	clone := Clone(client)
+	assert.Equal(t, certificates, clone.(*defaultClient).client.Transport.(*http.Transport).TLSClientConfig.Certificates)
+	ConfigureTLS(clone, nil, nil, false)
+	assert.Nil(t, clone.(*defaultClient).client.Transport.(*http.Transport).TLSClientConfig)
+	assert.Equal(t, certificates, client.(*defaultClient).client.Transport.(*http.Transport).TLSClientConfig.Certificates)
+
+	ForceHTTP2(client, certificates, nil, false)
+	clone = Clone(client)
+	ConfigureTLS(clone, nil, nil, false)
+	assert.Nil(t, clone.(*defaultClient).client.Transport.(*fallbackTransport).h2.TLSClientConfig)
+	assert.Equal(t, certificates, client.(*defaultClient).client.Transport.(*fallbackTransport).h2.TLSClientConfig.Certificates)
+}
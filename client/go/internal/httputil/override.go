@@ -0,0 +1,123 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// EndpointOverrides redirects connections to some addresses to other addresses, such as the local end of an SSH tunnel.
+// Requests are still sent as to the original address: with its Host header, and with its TLS certificate verified
+// against its host name. Connections to overridden addresses are made directly, and never through a proxy.
+type EndpointOverrides struct {
+	// Addrs maps each overridden address, on the form host:port, to the address to connect to instead
+	Addrs map[string]string
+	// Insecure is whether to skip verification of the TLS certificates of overridden addresses
+	Insecure bool
+	// OnOverride is called, if non-nil, with each connection made to an overridden address
+	OnOverride func(addr, override string)
+}
+
+func (o EndpointOverrides) lookup(addr string) (string, bool) {
+	override, ok := o.Addrs[addr]
+	return override, ok
+}
+
+// apply returns the address to connect to instead of addr, or addr if it is not overridden.
+func (o EndpointOverrides) apply(addr string) string {
+	override, ok := o.lookup(addr)
+	if !ok {
+		return addr
+	}
+	if o.OnOverride != nil {
+		o.OnOverride(addr, override)
+	}
+	return override
+}
+
+// CheckAddress returns an error if addr is not on the form host:port.
+func CheckAddress(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("address %s: missing host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("address %s: invalid port", addr)
+	}
+	return nil
+}
+
+// urlAddr returns the address, on the form host:port, which requests to u connect to.
+func urlAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// ConfigureEndpointOverrides configures the given client to connect to the addresses overriding those of its requests.
+func ConfigureEndpointOverrides(client Client, overrides EndpointOverrides) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.overrides = overrides
+	if tr, ok := c.client.Transport.(*http.Transport); ok {
+		c.configureDialTLS(tr)
+	} else if tr, ok := c.client.Transport.(*fallbackTransport); ok {
+		c.configureDialTLS(tr.h1)
+	}
+}
+
+// configureDialTLS makes transport tr establish TLS connections itself, if verification of the certificates of
+// overridden addresses is skipped, as tr otherwise verifies certificates after dialing.
+func (c *defaultClient) configureDialTLS(tr *http.Transport) {
+	if c.overrides.Insecure && len(c.overrides.Addrs) > 0 {
+		tr.DialTLSContext = c.dialTLS(tr)
+	} else {
+		tr.DialTLSContext = nil
+	}
+}
+
+// dialTLS returns a function establishing a TLS connection for transport tr, which skips verification of the
+// certificates of overridden addresses.
+func (c *defaultClient) dialTLS(tr *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		tlsConfig := &tls.Config{}
+		if tr.TLSClientConfig != nil {
+			tlsConfig = tr.TLSClientConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ServerName = host
+		}
+		if _, ok := c.overrides.lookup(addr); ok {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		conn, err := tr.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hostHandler(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.Host) }
+
+func TestEndpointOverrides(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(hostHandler))
+	defer backend.Close()
+	proxy, proxyFunc := startProxy(t, backend)
+
+	var connected []string
+	client := NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ConfigureEndpointOverrides(client, EndpointOverrides{
+		Addrs:      map[string]string{"internal-host:8080": backend.Listener.Addr().String()},
+		OnOverride: func(addr, override string) { connected = append(connected, addr+" "+override) },
+	})
+	// The original Host header is sent, and the proxy is bypassed
+	assert.Equal(t, "internal-host:8080", get(t, client, "http://internal-host:8080/"))
+	assert.Equal(t, []string{"internal-host:8080 " + backend.Listener.Addr().String()}, connected)
+	assert.Empty(t, proxy.requestedHosts())
+}
+
+func TestEndpointOverridesTLS(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(hostHandler))
+	backend.EnableHTTP2 = true
+	backend.Config.ErrorLog = log.New(io.Discard, "", 0) // Handshakes failing verification are expected
+	backend.StartTLS()
+	defer backend.Close()
+	caCertificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	addr := backend.Listener.Addr().String()
+	// The certificate of the test server is valid for example.com
+	overrides := EndpointOverrides{Addrs: map[string]string{"example.com:443": addr, "internal-host:443": addr}}
+
+	for _, forceHTTP2 := range []bool{false, true} {
+		client := NewClient(10 * time.Second)
+		ConfigureEndpointOverrides(client, overrides)
+		if forceHTTP2 {
+			ForceHTTP2(client, []tls.Certificate{}, caCertificate, false)
+		} else {
+			ConfigureTLS(client, []tls.Certificate{}, caCertificate, false)
+		}
+		// The certificate is verified against the original host name
+		assert.Equal(t, "example.com", get(t, client, "https://example.com/"))
+		request, err := http.NewRequest("GET", "https://internal-host/", nil)
+		require.Nil(t, err)
+		_, err = client.Do(request, 10*time.Second)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "certificate is valid for example.com")
+
+		insecure := overrides
+		insecure.Insecure = true
+		ConfigureEndpointOverrides(client, insecure)
+		assert.Equal(t, "internal-host", get(t, client, "https://internal-host/"))
+	}
+}
+
+// socksProxy is a SOCKS5 proxy without authentication, which connects all CONNECT requests to backend, regardless of
+// the requested address.
+type socksProxy struct {
+	backend  string
+	listener net.Listener
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func startSOCKSProxy(t *testing.T, backend string) *socksProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	p := &socksProxy{backend: backend, listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	// Greeting: version, number of methods and methods. Choose no authentication
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+	// Request: version, command, reserved, address type, address and port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	p.mu.Lock()
+	p.addrs = append(p.addrs, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	p.mu.Unlock()
+	backend, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer backend.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(backend, conn)
+	io.Copy(conn, backend)
+}
+
+func (p *socksProxy) requestedAddrs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.addrs...)
+}
+
+func TestSOCKSProxy(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(protoHandler))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	proxy := startSOCKSProxy(t, backend.Listener.Addr().String())
+	proxyFunc := ProxyFunc(map[string]string{"ALL_PROXY": "socks5://" + proxy.listener.Addr().String()})
+
+	client := NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ConfigureTLS(client, []tls.Certificate{}, nil, true)
+	assert.Equal(t, "HTTP/2.0", get(t, client, "https://vespa.example:4443/"))
+	assert.Equal(t, []string{"vespa.example:4443"}, proxy.requestedAddrs())
+
+	// HTTP/2 transport, as used when feeding with mTLS
+	client = NewClient(10 * time.Second)
+	ConfigureProxy(client, proxyFunc)
+	ForceHTTP2(client, []tls.Certificate{}, nil, true)
+	assert.Equal(t, "HTTP/2.0", get(t, client, "https://feed.example:4443/"))
+	assert.Equal(t, []string{"vespa.example:4443", "feed.example:4443"}, proxy.requestedAddrs())
+}
+
+func TestCheckAddress(t *testing.T) {
+	assert.Nil(t, CheckAddress("internal-host:8080"))
+	assert.Nil(t, CheckAddress("[::1]:8080"))
+	assert.NotNil(t, CheckAddress("internal-host"))
+	assert.NotNil(t, CheckAddress(":8080"))
+	assert.NotNil(t, CheckAddress("internal-host:http"))
+	assert.NotNil(t, CheckAddress("internal-host:0"))
+}
@@ -0,0 +1,151 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// maxRetryAfter is the longest delay requested by a Retry-After header which is honored. Responses asking for a longer
+// delay are returned as-is.
+const maxRetryAfter = 30 * time.Second
+
+// RetryPolicy decides how requests failing with a transient error are retried. Only requests with a safe method, i.e.
+// GET, HEAD and OPTIONS, are retried. PUT and DELETE are idempotent in HTTP, but e.g. document updates are not, so
+// these are left to their callers, together with POST. Transient errors are connection resets, and responses with
+// status 429, 502, 503 and 504.
+type RetryPolicy struct {
+	// Retries is the maximum number of times to retry a request. Zero disables retrying.
+	Retries int
+	// MinBackoff is the delay before the first retry. This doubles for each retry, up to MaxBackoff, and is then
+	// reduced by a random amount of up to half. A Retry-After header in the response takes precedence.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnRetry is called, if non-nil, before a request is retried, with the reason for and the delay until the retry.
+	OnRetry func(request *http.Request, reason string, delay time.Duration, retriesLeft int)
+
+	sleep  func(ctx context.Context, d time.Duration) error
+	random func() float64
+}
+
+// DefaultRetryPolicy returns a policy retrying requests given number of times.
+func DefaultRetryPolicy(retries int) RetryPolicy {
+	return RetryPolicy{Retries: retries, MinBackoff: 500 * time.Millisecond, MaxBackoff: 8 * time.Second}
+}
+
+// ConfigureRetry configures the given client to retry requests according to policy.
+func ConfigureRetry(client Client, policy RetryPolicy) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.retry = policy
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// do sends request with send, retrying it according to this policy.
+func (p RetryPolicy) do(request *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	hasBody := request.Body != nil && request.Body != http.NoBody
+	if p.Retries <= 0 || !isSafeMethod(request.Method) || (hasBody && request.GetBody == nil) {
+		return send(request)
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && hasBody {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+		response, err := send(request)
+		reason, retryAfter, retryable := retryReason(response, err)
+		if !retryable || attempt >= p.Retries || retryAfter > maxRetryAfter {
+			return response, err
+		}
+		delay := p.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if response != nil {
+			io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16)) // Allow connection to be reused
+			response.Body.Close()
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(request, reason, delay, p.Retries-attempt)
+		}
+		if err := p.wait(request.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff << attempt
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	random := rand.Float64
+	if p.random != nil {
+		random = p.random
+	}
+	return d/2 + time.Duration(random()*float64(d/2))
+}
+
+func (p RetryPolicy) wait(ctx context.Context, d time.Duration) error {
+	if p.sleep != nil {
+		return p.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryReason returns the reason for retrying after receiving response or err, the delay requested by the response,
+// if any, and whether to retry at all.
+func retryReason(response *http.Response, err error) (string, time.Duration, bool) {
+	if err != nil {
+		if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return err.Error(), 0, true
+		}
+		return "", 0, false
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "got status " + strconv.Itoa(response.StatusCode), parseRetryAfter(response.Header.Get("Retry-After")), true
+	}
+	return "", 0, false
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or a HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
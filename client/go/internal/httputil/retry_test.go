@@ -0,0 +1,145 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type retryRecorder struct {
+	delays  []time.Duration
+	reasons []string
+}
+
+func (r *retryRecorder) policy(retries int) RetryPolicy {
+	policy := DefaultRetryPolicy(retries)
+	policy.random = func() float64 { return 1 }
+	policy.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	policy.OnRetry = func(request *http.Request, reason string, delay time.Duration, retriesLeft int) {
+		r.delays = append(r.delays, delay)
+		r.reasons = append(r.reasons, fmt.Sprintf("%s %s: %s (%d left)", request.Method, request.URL.Path, reason, retriesLeft))
+	}
+	return policy
+}
+
+// statusServer responds with the given statuses in order, and 200 OK after these.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		if n <= len(statuses) {
+			if statuses[n-1] == http.StatusServiceUnavailable {
+				w.Header().Set("Retry-After", "3")
+			}
+			w.WriteHeader(statuses[n-1])
+		}
+		fmt.Fprintf(w, "attempt %d: %s", n, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func send(t *testing.T, client Client, method, url, body string) (int, string) {
+	t.Helper()
+	var request *http.Request
+	var err error
+	if body == "" {
+		request, err = http.NewRequest(method, url, nil)
+	} else {
+		request, err = http.NewRequest(method, url, strings.NewReader(body))
+	}
+	require.Nil(t, err)
+	response, err := client.Do(request, 10*time.Second)
+	require.Nil(t, err)
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	require.Nil(t, err)
+	return response.StatusCode, string(data)
+}
+
+func TestRetry(t *testing.T) {
+	server, requests := statusServer(t, 502, 503, 429)
+	client := NewClient(10 * time.Second)
+	recorder := &retryRecorder{}
+	ConfigureRetry(client, recorder.policy(3))
+	status, body := send(t, client, "GET", server.URL+"/status.html", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "attempt 4: ", body)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 3 * time.Second, 2 * time.Second}, recorder.delays)
+	assert.Equal(t, []string{
+		"GET /status.html: got status 502 (3 left)",
+		"GET /status.html: got status 503 (2 left)",
+		"GET /status.html: got status 429 (1 left)",
+	}, recorder.reasons)
+	assert.Equal(t, int32(4), requests.Load())
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	server, requests := statusServer(t, 504, 504, 504)
+	client := NewClient(10 * time.Second)
+	recorder := &retryRecorder{}
+	ConfigureRetry(client, recorder.policy(2))
+	status, body := send(t, client, "GET", server.URL, "")
+	assert.Equal(t, 504, status)
+	assert.Equal(t, "attempt 3: ", body)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestRetryOnlySafeMethods(t *testing.T) {
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		server, requests := statusServer(t, 503)
+		client := NewClient(10 * time.Second)
+		recorder := &retryRecorder{}
+		ConfigureRetry(client, recorder.policy(2))
+		status, _ := send(t, client, method, server.URL, "doc")
+		assert.Equal(t, 503, status, method)
+		assert.Equal(t, int32(1), requests.Load(), method)
+		assert.Empty(t, recorder.reasons, method)
+	}
+
+	// Non-transient errors are not retried either
+	server, requests := statusServer(t, 500)
+	client := NewClient(10 * time.Second)
+	ConfigureRetry(client, (&retryRecorder{}).policy(2))
+	status, _ := send(t, client, "GET", server.URL, "")
+	assert.Equal(t, 500, status)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRetryResendsBody(t *testing.T) {
+	server, _ := statusServer(t, 502)
+	client := NewClient(10 * time.Second)
+	ConfigureRetry(client, (&retryRecorder{}).policy(1))
+	status, body := send(t, client, "GET", server.URL, `{"yql":"select * from sources * where true"}`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `attempt 2: {"yql":"select * from sources * where true"}`, body)
+}
+
+func TestNoRetry(t *testing.T) {
+	server, requests := statusServer(t, 503)
+	client := NewClient(10 * time.Second)
+	ConfigureRetry(client, (&retryRecorder{}).policy(0))
+	status, _ := send(t, client, "GET", server.URL, "")
+	assert.Equal(t, 503, status)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120"))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, d > 58*time.Second && d <= time.Minute, d)
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)))
+}
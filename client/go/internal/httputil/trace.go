@@ -0,0 +1,226 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Redacted replaces sensitive values in a trace, and in other output showing requests.
+const Redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers whose values are never written to a trace.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Authorization", // Signature of requests signed with an API key
+	"X-Key",           // Public key of an API key
+	"Yahoo-Principal-Auth",
+}
+
+// pemBlockPattern matches PEM encoded certificates and keys, including those cut short by truncation.
+var pemBlockPattern = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+(?s:.*?)(-----END [A-Z0-9 ]+-----|$)`)
+
+// Tracer writes each request sent by a client, and its response, as a line of JSON. Authentication material is
+// redacted from headers and bodies, and bodies are truncated to a maximum size.
+type Tracer struct {
+	w           io.Writer
+	maxBodySize int
+	now         func() time.Time
+
+	mu sync.Mutex
+	// secretHeaders are headers whose values are redacted, in addition to sensitiveHeaders
+	secretHeaders []string
+}
+
+// TraceEntry is a request and its response, as written by a Tracer.
+type TraceEntry struct {
+	Time                  time.Time   `json:"time"`
+	Method                string      `json:"method"`
+	URL                   string      `json:"url"`
+	RequestHeaders        http.Header `json:"requestHeaders,omitempty"`
+	RequestBody           string      `json:"requestBody,omitempty"`
+	RequestBodyTruncated  bool        `json:"requestBodyTruncated,omitempty"`
+	Status                int         `json:"status,omitempty"`
+	Protocol              string      `json:"protocol,omitempty"`
+	ResponseHeaders       http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody          string      `json:"responseBody,omitempty"`
+	ResponseBodyTruncated bool        `json:"responseBodyTruncated,omitempty"`
+	Error                 string      `json:"error,omitempty"`
+	// HeadersMillis is the time until the response headers were received
+	HeadersMillis int64 `json:"headersMillis"`
+	// DurationMillis is the time until the response body was read, or the request failed
+	DurationMillis int64 `json:"durationMillis"`
+}
+
+// NewTracer returns a tracer writing to w, and keeping up to maxBodySize bytes of each body.
+func NewTracer(w io.Writer, maxBodySize int) *Tracer {
+	return &Tracer{w: w, maxBodySize: maxBodySize, now: time.Now}
+}
+
+// RedactHeaders makes this redact the values of the named headers, in addition to those which always hold credentials.
+func (t *Tracer) RedactHeaders(names ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secretHeaders = append(t.secretHeaders, names...)
+}
+
+// redactHeader returns a copy of header with the values of sensitive headers redacted.
+func (t *Tracer) redactHeader(header http.Header) http.Header {
+	t.mu.Lock()
+	names := append(slices.Clone(sensitiveHeaders), t.secretHeaders...)
+	t.mu.Unlock()
+	return RedactHeader(header, names)
+}
+
+// ConfigureTrace configures the given client to write each request and response to tracer. A nil tracer disables
+// tracing.
+func ConfigureTrace(client Client, tracer *Tracer) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	c.trace = tracer
+}
+
+// wrap returns send, writing each request sent and its response to this.
+func (t *Tracer) wrap(send func(*http.Request) (*http.Response, error)) func(*http.Request) (*http.Response, error) {
+	return func(request *http.Request) (*http.Response, error) {
+		start := t.now()
+		method := request.Method
+		if method == "" {
+			method = "GET"
+		}
+		entry := &TraceEntry{
+			Time:           start,
+			Method:         method,
+			URL:            request.URL.Redacted(),
+			RequestHeaders: t.redactHeader(request.Header),
+		}
+		if err := t.recordRequestBody(request, entry); err != nil {
+			return nil, err
+		}
+		response, err := send(request)
+		entry.HeadersMillis = t.now().Sub(start).Milliseconds()
+		if err != nil {
+			entry.Error = err.Error()
+			entry.DurationMillis = entry.HeadersMillis
+			t.write(entry)
+			return nil, err
+		}
+		entry.Status = response.StatusCode
+		entry.Protocol = response.Proto
+		entry.ResponseHeaders = t.redactHeader(response.Header)
+		response.Body = &tracedBody{ReadCloser: response.Body, tracer: t, entry: entry, start: start}
+		return response, nil
+	}
+}
+
+// recordRequestBody records the body of request in entry. If the body cannot be read again, it is replaced by a copy.
+func (t *Tracer) recordRequestBody(request *http.Request, entry *TraceEntry) error {
+	if request.Body == nil || request.Body == http.NoBody {
+		return nil
+	}
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		data, err := io.ReadAll(io.LimitReader(body, int64(t.maxBodySize)+1))
+		if err != nil {
+			return err
+		}
+		entry.RequestBody, entry.RequestBodyTruncated = t.truncate(data)
+		return nil
+	}
+	data, err := io.ReadAll(request.Body)
+	request.Body.Close()
+	if err != nil {
+		return err
+	}
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	entry.RequestBody, entry.RequestBodyTruncated = t.truncate(data)
+	return nil
+}
+
+// truncate returns data as a redacted string of at most the maximum body size of this, and whether it was truncated.
+func (t *Tracer) truncate(data []byte) (string, bool) {
+	truncated := len(data) > t.maxBodySize
+	if truncated {
+		data = data[:t.maxBodySize]
+	}
+	return pemBlockPattern.ReplaceAllString(string(data), Redacted), truncated
+}
+
+func (t *Tracer) write(entry *TraceEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.w.Write(data) // Tracing is best-effort, and never fails a request
+}
+
+// RedactHeader returns a copy of header with the values of the named headers redacted.
+func RedactHeader(header http.Header, names []string) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	h := header.Clone()
+	for _, name := range names {
+		if values := h.Values(name); len(values) > 0 {
+			h[http.CanonicalHeaderKey(name)] = []string{Redacted}
+		}
+	}
+	return h
+}
+
+// tracedBody is a response body which records what is read from it, and writes the trace entry of its response when
+// fully read or closed.
+type tracedBody struct {
+	io.ReadCloser
+	tracer *Tracer
+	entry  *TraceEntry
+	start  time.Time
+
+	buf  bytes.Buffer
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := b.tracer.maxBodySize + 1 - b.buf.Len(); remaining > 0 {
+		b.buf.Write(p[:min(n, remaining)])
+	}
+	if err == io.EOF {
+		b.finish()
+	} else if err != nil {
+		b.entry.Error = err.Error()
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *tracedBody) finish() {
+	b.once.Do(func() {
+		b.entry.ResponseBody, b.entry.ResponseBodyTruncated = b.tracer.truncate(b.buf.Bytes())
+		b.entry.DurationMillis = b.tracer.now().Sub(b.start).Milliseconds()
+		b.tracer.write(b.entry)
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTrace(t *testing.T, buf *bytes.Buffer) []TraceEntry {
+	t.Helper()
+	var entries []TraceEntry
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var entry TraceEntry
+		require.Nil(t, dec.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(201)
+		w.Write([]byte("got " + string(body)))
+	}))
+	t.Cleanup(server.Close)
+
+	var buf bytes.Buffer
+	client := NewClient(10 * time.Second)
+	tracer := NewTracer(&buf, 16)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracer.now = func() time.Time {
+		now = now.Add(10 * time.Millisecond)
+		return now
+	}
+	ConfigureTrace(client, tracer)
+	tracer.RedactHeaders("x-org-token")
+
+	pemBody := "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----"
+	request, err := http.NewRequest("POST", server.URL+"/document/v1/", strings.NewReader(pemBody))
+	require.Nil(t, err)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("X-Key", "secret")
+	request.Header.Set("Accept", "text/plain")
+	request.Header.Set("X-Org-Token", "secret")
+	response, err := client.Do(request, 10*time.Second)
+	require.Nil(t, err)
+	data, err := io.ReadAll(response.Body)
+	require.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, "got "+pemBody, string(data))
+
+	entries := readTrace(t, &buf)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, server.URL+"/document/v1/", entry.URL)
+	assert.Equal(t, "[REDACTED]", entry.RequestHeaders.Get("Authorization"))
+	assert.Equal(t, "[REDACTED]", entry.RequestHeaders.Get("X-Key"))
+	assert.Equal(t, "text/plain", entry.RequestHeaders.Get("Accept"))
+	assert.Equal(t, "[REDACTED]", entry.RequestHeaders.Get("X-Org-Token"))
+	assert.Equal(t, "[REDACTED]", entry.RequestBody)
+	assert.True(t, entry.RequestBodyTruncated)
+	assert.Equal(t, 201, entry.Status)
+	assert.Equal(t, "[REDACTED]", entry.ResponseHeaders.Get("Set-Cookie"))
+	assert.Equal(t, "got [REDACTED]", entry.ResponseBody)
+	assert.True(t, entry.ResponseBodyTruncated)
+	assert.Equal(t, int64(10), entry.HeadersMillis)
+	assert.Equal(t, int64(20), entry.DurationMillis)
+	// The request is passed on unchanged
+	assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+}
+
+func TestTraceRetriesAndErrors(t *testing.T) {
+	server, _ := statusServer(t, 503)
+	var buf bytes.Buffer
+	client := NewClient(10 * time.Second)
+	ConfigureTrace(client, NewTracer(&buf, 1024))
+	recorder := &retryRecorder{}
+	ConfigureRetry(client, recorder.policy(1))
+	status, _ := send(t, client, "GET", server.URL+"/status.html", "")
+	assert.Equal(t, 200, status)
+
+	// Each attempt is traced
+	entries := readTrace(t, &buf)
+	require.Len(t, entries, 2)
+	assert.Equal(t, 503, entries[0].Status)
+	assert.Equal(t, 200, entries[1].Status)
+	assert.Equal(t, "attempt 2: ", entries[1].ResponseBody)
+
+	// Failed requests are traced
+	server.Close()
+	request, err := http.NewRequest("GET", server.URL, nil)
+	require.Nil(t, err)
+	_, err = client.Do(request, time.Second)
+	require.NotNil(t, err)
+	entries = readTrace(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 0, entries[0].Status)
+	assert.NotEmpty(t, entries[0].Error)
+
+	// No tracing when disabled
+	ConfigureTrace(client, nil)
+	_, err = client.Do(request, time.Second)
+	require.NotNil(t, err)
+	assert.Equal(t, 0, buf.Len())
+}
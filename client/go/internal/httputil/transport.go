@@ -0,0 +1,80 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+)
+
+// fallbackTransport sends requests with a HTTP/2 transport, until a server fails to negotiate HTTP/2. All requests are
+// then sent with a pooling HTTP/1.1 transport.
+type fallbackTransport struct {
+	h2    *http2.Transport
+	h1    *http.Transport
+	http1 atomic.Bool
+}
+
+func (t *fallbackTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if t.http1.Load() {
+		return t.h1.RoundTrip(request)
+	}
+	response, err := t.h2.RoundTrip(request)
+	if !errors.Is(err, errNoHTTP2) {
+		return response, err
+	}
+	t.http1.Store(true)
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody == nil {
+			return nil, err
+		}
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.Body = body
+	}
+	return t.h1.RoundTrip(request)
+}
+
+// ConfigureStreams sets the number of concurrent requests a client configured with ForceHTTP2 sends over HTTP/1.1, if
+// the server does not support HTTP/2. This is the size of its HTTP/1.1 connection pool, as each of these connections
+// carries a single request at a time. Over HTTP/2, the number of concurrent streams is negotiated with the server.
+func ConfigureStreams(client Client, streams int) {
+	c, ok := client.(*defaultClient)
+	if !ok {
+		return
+	}
+	if tr, ok := c.client.Transport.(*fallbackTransport); ok {
+		tr.h1.MaxConnsPerHost = streams
+		tr.h1.MaxIdleConnsPerHost = streams
+		tr.h1.MaxIdleConns = 0
+	}
+}
+
+// ConnectionStats holds statistics of the connections used by one or more clients.
+type ConnectionStats struct {
+	// Protocol is the protocol of the most recent response, e.g. "HTTP/2.0", or empty if there were no responses.
+	Protocol string
+	// Connections is the number of connections opened.
+	Connections int64
+}
+
+// Connections returns the combined connection statistics of the given clients.
+func Connections(clients ...Client) ConnectionStats {
+	var stats ConnectionStats
+	for _, client := range clients {
+		c, ok := client.(*defaultClient)
+		if !ok {
+			continue
+		}
+		stats.Connections += c.connections.Load()
+		if protocol := c.protocol.Load(); protocol != nil && stats.Protocol == "" {
+			stats.Protocol = *protocol
+		}
+	}
+	return stats
+}
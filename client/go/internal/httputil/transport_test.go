@@ -0,0 +1,108 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package httputil
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	fmt.Fprintf(w, "%s %s", r.Proto, body)
+}
+
+func feedServer(t testing.TB, http2 bool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.EnableHTTP2 = http2
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func feedClient(streams int) Client {
+	client := NewClient(10 * time.Second)
+	ForceHTTP2(client, []tls.Certificate{}, nil, true)
+	ConfigureStreams(client, streams)
+	return client
+}
+
+func sendConcurrently(t *testing.T, client Client, url string, n int) []string {
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, bodies[i] = send(t, client, "POST", url, fmt.Sprintf("doc%d", i))
+		}()
+	}
+	wg.Wait()
+	return bodies
+}
+
+func TestForceHTTP2ReusesConnection(t *testing.T) {
+	server := feedServer(t, true)
+	client := feedClient(4)
+	assert.Equal(t, ConnectionStats{}, Connections(client))
+	bodies := sendConcurrently(t, client, server.URL, 50)
+	for i, body := range bodies {
+		assert.Equal(t, fmt.Sprintf("HTTP/2.0 doc%d", i), body)
+	}
+	assert.Equal(t, ConnectionStats{Protocol: "HTTP/2.0", Connections: 1}, Connections(client))
+	sendConcurrently(t, client, server.URL, 50)
+	assert.Equal(t, ConnectionStats{Protocol: "HTTP/2.0", Connections: 1}, Connections(client))
+
+	other := feedClient(4)
+	sendConcurrently(t, other, server.URL, 1)
+	assert.Equal(t, ConnectionStats{Protocol: "HTTP/2.0", Connections: 2}, Connections(client, other))
+}
+
+func TestForceHTTP2FallsBackToHTTP1(t *testing.T) {
+	server := feedServer(t, false)
+	client := feedClient(4)
+	_, body := send(t, client, "POST", server.URL, "doc")
+	assert.Equal(t, "HTTP/1.1 doc", body)
+	bodies := sendConcurrently(t, client, server.URL, 50)
+	for i, body := range bodies {
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 doc%d", i), body)
+	}
+	stats := Connections(client)
+	assert.Equal(t, "HTTP/1.1", stats.Protocol)
+	assert.True(t, stats.Connections >= 1 && stats.Connections <= 4, stats.Connections)
+}
+
+func BenchmarkFeed(b *testing.B) {
+	for _, tt := range []struct {
+		name  string
+		http2 bool
+	}{{"HTTP/2", true}, {"HTTP/1.1", false}} {
+		b.Run(tt.name, func(b *testing.B) {
+			server := feedServer(b, tt.http2)
+			client := feedClient(64)
+			doc := strings.Repeat("x", 1024)
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					request, err := http.NewRequest("POST", server.URL, strings.NewReader(doc))
+					require.Nil(b, err)
+					response, err := client.Do(request, 10*time.Second)
+					require.Nil(b, err)
+					io.Copy(io.Discard, response.Body)
+					response.Body.Close()
+				}
+			})
+			b.ReportMetric(float64(Connections(client).Connections), "connections")
+		})
+	}
+}
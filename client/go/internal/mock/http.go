@@ -8,10 +8,13 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type HTTPClient struct {
+	mu sync.Mutex
+
 	// The responses to return for future requests. Once a response is consumed, it's removed from this slice.
 	nextResponses []HTTPResponse
 
@@ -33,6 +36,9 @@ type HTTPClient struct {
 
 	// Requests contains all requests made through this.
 	Requests []*http.Request
+
+	// RequestTimes contains the time each of Requests was made.
+	RequestTimes []time.Time
 }
 
 type HTTPResponse struct {
@@ -66,6 +72,8 @@ func (c *HTTPClient) Do(request *http.Request, timeout time.Duration) (*http.Res
 	if c.LogRequests {
 		fmt.Fprintf(os.Stderr, "Sending request %+v\n", request)
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.LastRequest = request
 	if len(c.nextErrors) > 0 {
 		err := c.nextErrors[0]
@@ -90,6 +98,7 @@ func (c *HTTPClient) Do(request *http.Request, timeout time.Duration) (*http.Res
 		c.LastBody = nil
 	}
 	c.Requests = append(c.Requests, request)
+	c.RequestTimes = append(c.RequestTimes, time.Now())
 	if response.Header == nil {
 		response.Header = make(http.Header)
 	}
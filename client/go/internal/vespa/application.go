@@ -4,13 +4,19 @@ package vespa
 import (
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
 	"github.com/vespa-engine/vespa/client/go/internal/vespa/ignore"
@@ -19,8 +25,29 @@ import (
 type ApplicationPackage struct {
 	Path     string
 	TestPath string
+	// Exclude holds ignore patterns applied in addition to those in .vespaignore, when zipping a directory
+	Exclude []string
+	// Template holds the values of placeholders substituted in services.xml and deployment.xml, when zipping a
+	// directory. Placeholders are left as they are if this is nil
+	Template *Template
 }
 
+// PackageStats holds statistics of a zipped application package.
+type PackageStats struct {
+	// Files is the number of files in the zip
+	Files int
+	// Skipped is the number of files skipped by ignore patterns
+	Skipped int
+	// Size is the size of the zip, in bytes
+	Size int64
+	// Digest is the hex-encoded SHA-256 digest of the zip
+	Digest string
+}
+
+// zipModified is the modification time of all entries in a zip created from a directory. It is fixed, together with
+// the order, compression and modes of the entries, such that zipping the same files always gives the same bytes.
+var zipModified = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func (ap *ApplicationPackage) HasCertificate() bool { return ap.hasFile("security", "clients.pem") }
 
 func processPEMEntries(data []byte) []*pem.Block {
@@ -83,6 +110,191 @@ func (ap *ApplicationPackage) HasMatchingCertificate(certificatePEM []byte) (boo
 	return containsMatchingCertificate(certificatePEM, clientsPEM)
 }
 
+func (ap *ApplicationPackage) clientsPEMPath() string {
+	return filepath.Join(ap.Path, "security", "clients.pem")
+}
+
+// ClientCertificates returns the certificates in security/clients.pem of this application package.
+func (ap *ApplicationPackage) ClientCertificates() ([]*x509.Certificate, error) {
+	if ap.IsZip() {
+		return nil, fmt.Errorf("cannot read certificates from compressed application package: '%s'", ap.Path)
+	}
+	clientsPEM, err := os.ReadFile(ap.clientsPEMPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var certificates []*x509.Certificate
+	for _, block := range processPEMEntries(clientsPEM) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", ap.clientsPEMPath(), err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// AddClientCertificate appends the PEM-encoded certificate in certificatePEM to security/clients.pem of this application
+// package, unless a certificate with the same fingerprint is already present. It returns whether the certificate was
+// added.
+func (ap *ApplicationPackage) AddClientCertificate(certificatePEM []byte) (bool, error) {
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return false, fmt.Errorf("missing client certificate pem data")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("invalid certificate: %w", err)
+	}
+	existing, err := ap.ClientCertificates()
+	if err != nil {
+		return false, err
+	}
+	fingerprint := FingerprintSHA256(certificate)
+	for _, c := range existing {
+		if FingerprintSHA256(c) == fingerprint {
+			return false, nil
+		}
+	}
+	clientsPEM, err := os.ReadFile(ap.clientsPEMPath())
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if len(clientsPEM) > 0 && !bytes.HasSuffix(clientsPEM, []byte("\n")) {
+		clientsPEM = append(clientsPEM, '\n')
+	}
+	clientsPEM = append(clientsPEM, pem.EncodeToMemory(block)...)
+	if err := os.MkdirAll(filepath.Dir(ap.clientsPEMPath()), 0755); err != nil {
+		return false, err
+	}
+	return true, ioutil.AtomicWriteFile(ap.clientsPEMPath(), clientsPEM)
+}
+
+// ReplaceClientCertificate writes the PEM-encoded certificate in certificatePEM to security/clients.pem of this
+// application package, in place of the certificate in previousPEM, if given, and of any copy of the certificate itself.
+// Other certificates in the file are kept. The certificate is appended if neither is present.
+func (ap *ApplicationPackage) ReplaceClientCertificate(certificatePEM, previousPEM []byte) error {
+	block, _ := pem.Decode(certificatePEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("missing client certificate pem data")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("invalid certificate: %w", err)
+	}
+	previous, _ := pem.Decode(previousPEM)
+	clientsPEM, err := os.ReadFile(ap.clientsPEMPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var (
+		replaced bytes.Buffer
+		written  bool
+	)
+	for _, b := range processPEMEntries(clientsPEM) {
+		if pemEqual(b, block) || pemEqual(b, previous) {
+			if written {
+				continue
+			}
+			b = block
+			written = true
+		}
+		if err := pem.Encode(&replaced, b); err != nil {
+			return err
+		}
+	}
+	if !written {
+		if err := pem.Encode(&replaced, block); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(ap.clientsPEMPath()), 0755); err != nil {
+		return err
+	}
+	return ioutil.AtomicWriteFile(ap.clientsPEMPath(), replaced.Bytes())
+}
+
+// RemoveClientCertificate removes the certificate having given SHA-256 fingerprint from security/clients.pem of this
+// application package. The fingerprint is matched ignoring case and colons. The file is removed if no certificates
+// remain.
+func (ap *ApplicationPackage) RemoveClientCertificate(fingerprint string) error {
+	if ap.IsZip() {
+		return fmt.Errorf("cannot remove certificate from compressed application package: '%s'", ap.Path)
+	}
+	clientsPEM, err := os.ReadFile(ap.clientsPEMPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no certificate with fingerprint %s found: %s does not exist", fingerprint, filepath.Join("security", "clients.pem"))
+		}
+		return err
+	}
+	normalize := func(fp string) string { return strings.ToUpper(strings.ReplaceAll(fp, ":", "")) }
+	var (
+		remaining    bytes.Buffer
+		found        bool
+		certificates int
+	)
+	for _, block := range processPEMEntries(clientsPEM) {
+		if block.Type == "CERTIFICATE" {
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err == nil && normalize(FingerprintSHA256(certificate)) == normalize(fingerprint) {
+				found = true
+				continue
+			}
+			certificates++
+		}
+		if err := pem.Encode(&remaining, block); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("no certificate with fingerprint %s found in %s", fingerprint, filepath.Join("security", "clients.pem"))
+	}
+	if certificates == 0 {
+		return os.Remove(ap.clientsPEMPath())
+	}
+	return ioutil.AtomicWriteFile(ap.clientsPEMPath(), remaining.Bytes())
+}
+
+// ReadFile returns the contents of the file with given slash-separated name in this application package, which may be
+// compressed. The placeholders of template files are substituted from the template of this, when it is a directory.
+func (ap *ApplicationPackage) ReadFile(name string) ([]byte, error) {
+	if !ap.IsZip() {
+		data, err := os.ReadFile(filepath.Join(ap.Path, filepath.FromSlash(name)))
+		if err != nil || !isTemplateFile(name) {
+			return data, err
+		}
+		rendered, _ := ap.template().render(data)
+		return rendered, nil
+	}
+	r, err := zip.OpenReader(ap.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Stats returns statistics of this application package, as zipped for deployment.
+func (ap *ApplicationPackage) Stats() (PackageStats, error) {
+	r, stats, err := ap.zipReader(false)
+	if err != nil {
+		return PackageStats{}, err
+	}
+	r.Close()
+	return stats, nil
+}
+
 func (ap *ApplicationPackage) HasDeploymentSpec() bool { return ap.hasFile("deployment.xml", "") }
 
 func (ap *ApplicationPackage) hasFile(pathSegment ...string) bool {
@@ -144,23 +356,31 @@ func alwaysIgnore(filename string) bool {
 	return false
 }
 
-func zipDir(dir string, destination string, ignores *ignore.List) error {
+func zipDir(dir string, destination string, ignores *ignore.List, template *Template) (PackageStats, error) {
 	if !ioutil.Exists(dir) {
 		message := "'" + dir + "' should be an application package zip or dir, but does not exist"
-		return errors.New(message)
+		return PackageStats{}, errors.New(message)
 	}
 	if !ioutil.IsDir(dir) {
 		message := "'" + dir + "' should be an application package dir, but is a (non-zip) file"
-		return errors.New(message)
+		return PackageStats{}, errors.New(message)
 	}
 	file, err := os.Create(destination)
 	if err != nil {
 		message := "Could not create a temporary zip file for the application package: " + err.Error()
-		return errors.New(message)
+		return PackageStats{}, errors.New(message)
 	}
 	defer file.Close()
-	w := zip.NewWriter(file)
-	defer w.Close()
+	type zipEntry struct {
+		name, path string
+		mode       os.FileMode
+		// data is the content of the entry, if it is rendered from a template, rather than read from path
+		data []byte
+	}
+	var (
+		stats   PackageStats
+		entries []zipEntry
+	)
 	walker := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -169,31 +389,148 @@ func zipDir(dir string, destination string, ignores *ignore.List) error {
 		if err != nil {
 			return err
 		}
-		if alwaysIgnore(path) || ignores.Match(zipPath) {
+		matchPath := zipPath
+		if info.IsDir() {
+			matchPath += string(filepath.Separator)
+		}
+		if zipPath != "." && (alwaysIgnore(path) || ignores.Match(matchPath)) {
 			if info.IsDir() {
+				stats.Skipped += countFiles(path)
 				return filepath.SkipDir
 			}
+			stats.Skipped++
 			return nil
 		}
 		if info.IsDir() {
 			return nil
 		}
-		srcFile, err := os.Open(path)
-		if err != nil {
-			return err
+		stats.Files++
+		mode := os.FileMode(0644)
+		if info.Mode()&0111 != 0 {
+			mode = 0755
 		}
-		defer srcFile.Close()
-		zipFile, err := w.Create(zipPath)
-		if err != nil {
-			return err
+		entries = append(entries, zipEntry{name: filepath.ToSlash(zipPath), path: path, mode: mode})
+		return nil
+	}
+	if err := filepath.Walk(dir, walker); err != nil {
+		return PackageStats{}, err
+	}
+	if template != nil {
+		var unresolved []string
+		for i, entry := range entries {
+			if !isTemplateFile(entry.name) {
+				continue
+			}
+			data, err := os.ReadFile(entry.path)
+			if err != nil {
+				return PackageStats{}, err
+			}
+			rendered, missing := template.render(data)
+			for _, placeholder := range missing {
+				unresolved = append(unresolved, entry.name+": "+placeholder)
+			}
+			if !bytes.Equal(rendered, data) {
+				entries[i].data = rendered
+			}
 		}
-		_, err = io.Copy(zipFile, srcFile)
-		if err != nil {
-			return err
+		if len(unresolved) > 0 {
+			return PackageStats{}, &UnresolvedError{Placeholders: unresolved}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	w := zip.NewWriter(file)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.DefaultCompression)
+	})
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: zipModified}
+		header.SetMode(entry.mode)
+		if entry.data != nil {
+			dst, err := w.CreateHeader(header)
+			if err != nil {
+				return PackageStats{}, err
+			}
+			if _, err := dst.Write(entry.data); err != nil {
+				return PackageStats{}, err
+			}
+		} else if err := copyToZip(w, header, entry.path); err != nil {
+			return PackageStats{}, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return PackageStats{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return PackageStats{}, err
+	}
+	stats.Size = info.Size()
+	if stats.Digest, err = fileDigest(destination); err != nil {
+		return PackageStats{}, err
+	}
+	return stats, nil
+}
+
+func copyToZip(w *zip.Writer, header *zip.FileHeader, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// fileDigest returns the hex-encoded SHA-256 digest of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// countFiles returns the number of files contained in dir, and its subdirectories.
+func countFiles(dir string) int {
+	n := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
 		}
 		return nil
+	})
+	return n
+}
+
+// zipStats returns statistics of the existing zip at path.
+func zipStats(path string) (PackageStats, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return PackageStats{}, err
+	}
+	defer r.Close()
+	var stats PackageStats
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			stats.Files++
+		}
 	}
-	return filepath.Walk(dir, walker)
+	info, err := os.Stat(path)
+	if err != nil {
+		return PackageStats{}, err
+	}
+	stats.Size = info.Size()
+	stats.Digest, err = fileDigest(path)
+	return stats, err
 }
 
 func (ap *ApplicationPackage) openZip(name string) (io.ReadCloser, error) {
@@ -204,17 +541,28 @@ func (ap *ApplicationPackage) openZip(name string) (io.ReadCloser, error) {
 	return f, nil
 }
 
-func (ap *ApplicationPackage) zipReader(test bool) (io.ReadCloser, error) {
+// zipReader returns a reader for the zipped application package, and statistics of the zip if it was created from a
+// directory.
+func (ap *ApplicationPackage) zipReader(test bool) (io.ReadCloser, PackageStats, error) {
 	path := ap.Path
 	if test {
 		path = ap.TestPath
 	}
-	if ap.IsZip() {
-		return ap.openZip(path)
+	if isZip(path) {
+		r, err := ap.openZip(path)
+		if err != nil {
+			return nil, PackageStats{}, err
+		}
+		stats, err := zipStats(path)
+		if err != nil {
+			r.Close()
+			return nil, PackageStats{}, err
+		}
+		return r, stats, nil
 	}
 	tmp, err := os.CreateTemp("", "vespa")
 	if err != nil {
-		return nil, fmt.Errorf("could not create a temporary zip file for the application package: %w", err)
+		return nil, PackageStats{}, fmt.Errorf("could not create a temporary zip file for the application package: %w", err)
 	}
 	defer func() {
 		tmp.Close()
@@ -222,16 +570,31 @@ func (ap *ApplicationPackage) zipReader(test bool) (io.ReadCloser, error) {
 	}()
 	ignores, err := ignore.ReadFile(filepath.Join(path, ".vespaignore"))
 	if err != nil {
-		return nil, fmt.Errorf("could not read .vespaignore: %w", err)
+		return nil, PackageStats{}, fmt.Errorf("could not read .vespaignore: %w", err)
 	}
-	if err := zipDir(path, tmp.Name(), ignores); err != nil {
-		return nil, err
+	for _, pattern := range ap.Exclude {
+		if err := ignores.Add(pattern); err != nil {
+			return nil, PackageStats{}, fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+	}
+	var template *Template
+	if !test {
+		template = ap.Template
 	}
-	return ap.openZip(tmp.Name())
+	stats, err := zipDir(path, tmp.Name(), ignores, template)
+	if err != nil {
+		return nil, PackageStats{}, err
+	}
+	r, err := ap.openZip(tmp.Name())
+	return r, stats, err
 }
 
 func (ap *ApplicationPackage) Unzip(test bool) (string, error) {
-	if !ap.IsZip() {
+	path := ap.Path
+	if test {
+		path = ap.TestPath
+	}
+	if !isZip(path) {
 		return "", fmt.Errorf("can't unzip a package that is a directory structure")
 	}
 	cleanTemp := true
@@ -244,10 +607,6 @@ func (ap *ApplicationPackage) Unzip(test bool) (string, error) {
 			os.RemoveAll(tmp)
 		}
 	}()
-	path := ap.Path
-	if test {
-		path = ap.TestPath
-	}
 	f, err := zip.OpenReader(path)
 	if err != nil {
 		return "", err
@@ -271,6 +630,9 @@ func (ap *ApplicationPackage) Unzip(test bool) (string, error) {
 
 func (ap *ApplicationPackage) HasTests() bool { return ap.TestPath != "" }
 
+// IsTestZip returns whether the tests of this application package are a zip file.
+func (ap *ApplicationPackage) IsTestZip() bool { return isZip(ap.TestPath) }
+
 func validPath(path string) bool {
 	path = strings.TrimSuffix(path, "/")
 	if filepath.Clean(path) != path {
@@ -307,6 +669,9 @@ type PackageOptions struct {
 	SourceOnly bool
 }
 
+// ErrNoApplicationPackage is returned when no application package is found at a given path.
+var ErrNoApplicationPackage = errors.New("could not find an application package source")
+
 // FindApplicationPackage finds the path to an application package from the zip file or directory zipOrDir. If
 // requirePackaging is true, the application package is required to be packaged with mvn package.
 //
@@ -356,7 +721,7 @@ func findApplicationPackage(zipOrDir string, options PackageOptions) (Applicatio
 		}
 		return ApplicationPackage{Path: zipOrDir, TestPath: testPath}, nil
 	}
-	return ApplicationPackage{}, fmt.Errorf("could not find an application package source in '%s'", zipOrDir)
+	return ApplicationPackage{}, fmt.Errorf("%w in '%s'", ErrNoApplicationPackage, zipOrDir)
 }
 
 func existingPath(path string) string {
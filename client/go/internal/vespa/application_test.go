@@ -1,7 +1,15 @@
 package vespa
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestPemEquality(t *testing.T) {
@@ -65,3 +73,208 @@ sLEZh2u4owcwLcw3Bqn5pnIFlGla4oZd7nzUBBg=
 		})
 	}
 }
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	pkg := ApplicationPackage{Path: dir}
+	kpA, err := CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kpB, err := CreateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, kp := range []PemKeyPair{kpA, kpB, kpA} {
+		added, err := pkg.AddClientCertificate(kp.Certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 2; added != want {
+			t.Errorf("got added=%t for certificate %d, want %t", added, i, want)
+		}
+	}
+	certificates, err := pkg.ClientCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 2 {
+		t.Fatalf("got %d certificates, want 2", len(certificates))
+	}
+	if err := pkg.RemoveClientCertificate("AA:BB"); err == nil {
+		t.Error("expected error when removing unknown certificate")
+	}
+	if err := pkg.RemoveClientCertificate(FingerprintSHA256(certificates[0])); err != nil {
+		t.Fatal(err)
+	}
+	remaining, err := pkg.ClientCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || FingerprintSHA256(remaining[0]) != FingerprintSHA256(certificates[1]) {
+		t.Errorf("got %d remaining certificates, want the second certificate only", len(remaining))
+	}
+}
+
+func TestZipDirDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]os.FileMode{"services.xml": 0600, "a-b.txt": 0644, "a/b.txt": 0644, "schemas/music.sd": 0664, "bin/run.sh": 0700}
+	for name, mode := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content of "+name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkg := ApplicationPackage{Path: dir}
+	zipPackage := func() ([]byte, PackageStats) {
+		r, stats, err := pkg.zipReader(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, stats
+	}
+	first, stats := zipPackage()
+	later := time.Now().Add(time.Hour)
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(dir, name), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second, _ := zipPackage()
+	if !bytes.Equal(first, second) {
+		t.Fatal("zips of the same files differ")
+	}
+	digest := sha256.Sum256(first)
+	if want := hex.EncodeToString(digest[:]); stats.Digest != want {
+		t.Errorf("got digest %s, want %s", stats.Digest, want)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(zipModified) {
+			t.Errorf("got modification time %s of %s, want %s", f.Modified, f.Name, zipModified)
+		}
+		wantMode := os.FileMode(0644)
+		if f.Name == "bin/run.sh" {
+			wantMode = 0755
+		}
+		if f.Mode() != wantMode {
+			t.Errorf("got mode %s of %s, want %s", f.Mode(), f.Name, wantMode)
+		}
+	}
+	want := []string{"a-b.txt", "a/b.txt", "bin/run.sh", "schemas/music.sd", "services.xml"}
+	if len(names) != len(want) {
+		t.Fatalf("got entries %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got entries %v, want %v", names, want)
+		}
+	}
+}
+
+func TestZipDirTemplate(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"services.xml":     `<nodes count="${nodes:2}"/><!-- ${env.USER} -->`,
+		"deployment.xml":   `<prod><region>aws-us-east-1c</region></prod>`,
+		"schemas/music.sd": `schema music { ${nodes} }`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readZip := func(template *Template) map[string]string {
+		pkg := ApplicationPackage{Path: dir, Template: template}
+		r, _, err := pkg.zipReader(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := make(map[string]string)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries[f.Name] = string(content)
+		}
+		return entries
+	}
+	lookupEnv := func(name string) (string, bool) {
+		if name == "USER" {
+			return "alice", true
+		}
+		return "", false
+	}
+	got := readZip(&Template{Values: map[string]string{"nodes": "4"}, LookupEnv: lookupEnv})
+	want := map[string]string{
+		"services.xml":     `<nodes count="4"/><!-- alice -->`,
+		"deployment.xml":   files["deployment.xml"],
+		"schemas/music.sd": files["schemas/music.sd"],
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("got %q for %s, want %q", got[name], name, content)
+		}
+	}
+
+	pkg := ApplicationPackage{Path: dir, Template: &Template{}}
+	_, _, err := pkg.zipReader(false)
+	wantErr := "unresolved placeholders in application package: services.xml: ${env.USER}"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v, want %q", err, wantErr)
+	}
+
+	// Files without placeholders give the same zip as without a template
+	if err := os.WriteFile(filepath.Join(dir, "services.xml"), []byte(`<nodes count="2"/>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zipBytes := func(template *Template) []byte {
+		pkg := ApplicationPackage{Path: dir, Template: template}
+		r, _, err := pkg.zipReader(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if !bytes.Equal(zipBytes(nil), zipBytes(&Template{Values: map[string]string{"nodes": "4"}})) {
+		t.Error("zip of files without placeholders differs with a template")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// Build is an application package submitted for production deployment.
+type Build struct {
+	Number    int64
+	Commit    string
+	SourceURL string
+	// SubmittedAt is when this build was submitted, if known
+	SubmittedAt time.Time
+}
+
+type buildsResponse struct {
+	Builds []struct {
+		Build       int64  `json:"build"`
+		Commit      string `json:"commit"`
+		SourceURL   string `json:"sourceUrl"`
+		SubmittedAt int64  `json:"submittedAt"`
+	} `json:"builds"`
+}
+
+// Builds returns the builds submitted for the application in target, newest first.
+func Builds(target Target) ([]Build, error) {
+	if !target.IsCloud() {
+		return nil, fmt.Errorf("builds are unsupported by %s target", target.Type())
+	}
+	var response buildsResponse
+	if err := getJSON(target, target.Deployment().System.BuildsURL(target.Deployment().Application), &response); err != nil {
+		return nil, err
+	}
+	builds := make([]Build, 0, len(response.Builds))
+	for _, b := range response.Builds {
+		build := Build{Number: b.Build, Commit: b.Commit, SourceURL: b.SourceURL}
+		if b.SubmittedAt > 0 {
+			build.SubmittedAt = time.UnixMilli(b.SubmittedAt)
+		}
+		builds = append(builds, build)
+	}
+	sort.SliceStable(builds, func(i, j int) bool { return builds[i].Number > builds[j].Number })
+	return builds, nil
+}
+
+// DeployBuild triggers deployment of a previously submitted build to the instance of the application in target. If
+// pin is true, the instance stays on build, rather than upgrading to newer submissions, until unpinned.
+func DeployBuild(target Target, build int64, pin bool) error {
+	body, err := json.Marshal(struct {
+		Build int64 `json:"build"`
+		Pin   bool  `json:"pin,omitempty"`
+	}{build, pin})
+	if err != nil {
+		return err
+	}
+	return sendControllerRequest(target, "POST", target.Deployment().System.DeployingApplicationURL(target.Deployment().Application), body)
+}
+
+// UnpinBuild removes the pin of the build deployed to the instance of the application in target, such that newer
+// submissions are deployed again.
+func UnpinBuild(target Target) error {
+	return sendControllerRequest(target, "DELETE", target.Deployment().System.DeployingApplicationURL(target.Deployment().Application)+"/pin", nil)
+}
+
+func sendControllerRequest(target Target, method, requestURL string, body []byte) error {
+	if !target.IsCloud() {
+		return fmt.Errorf("deploying builds is unsupported by %s target", target.Type())
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return err
+	}
+	req := &http.Request{URL: u, Method: method, Header: make(http.Header)}
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	service, err := target.DeployService()
+	if err != nil {
+		return err
+	}
+	response, err := service.Do(req, 30*time.Second)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", requestURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		responseBody, _ := io.ReadAll(response.Body)
+		var errorResponse struct {
+			Message string `json:"message"`
+		}
+		message := string(responseBody)
+		if json.Unmarshal(responseBody, &errorResponse) == nil && errorResponse.Message != "" {
+			message = errorResponse.Message
+		}
+		return fmt.Errorf("request to %s failed: got status %d: %s", requestURL, response.StatusCode, message)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestBuilds(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	client.NextResponse(mock.HTTPResponse{
+		URI:    "/application/v4/tenant/t1/application/a1/build",
+		Status: 200,
+		Body: []byte(`{"builds": [
+  {"build": 41, "commit": "abc", "submittedAt": 1700000000000},
+  {"build": 42, "commit": "def", "sourceUrl": "https://ci.example.com/42"}
+]}`),
+	})
+	builds, err := Builds(target)
+	require.Nil(t, err)
+	assert.Equal(t, []Build{
+		{Number: 42, Commit: "def", SourceURL: "https://ci.example.com/42"},
+		{Number: 41, Commit: "abc", SubmittedAt: time.UnixMilli(1700000000000)},
+	}, builds)
+
+	client.NextStatus(404)
+	_, err = Builds(target)
+	assert.NotNil(t, err)
+}
+
+func TestDeployBuild(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	client.ReadBody = true
+	client.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/deploying/application", Status: 200})
+	require.Nil(t, DeployBuild(target, 42, true))
+	assert.Equal(t, "POST", client.LastRequest.Method)
+	assert.Equal(t, `{"build":42,"pin":true}`, string(client.LastBody))
+
+	client.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/deploying/application", Status: 200})
+	require.Nil(t, DeployBuild(target, 42, false))
+	assert.Equal(t, `{"build":42}`, string(client.LastBody))
+
+	client.NextResponse(mock.HTTPResponse{URI: "/application/v4/tenant/t1/application/a1/instance/i1/deploying/application/pin", Status: 200})
+	require.Nil(t, UnpinBuild(target))
+	assert.Equal(t, "DELETE", client.LastRequest.Method)
+
+	client.NextResponseString(400, `{"error-code": "BAD_REQUEST", "message": "no build 43"}`)
+	err := DeployBuild(target, 43, false)
+	require.NotNil(t, err)
+	assert.Equal(t, "request to https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1/instance/i1/deploying/application failed: got status 400: no build 43", err.Error())
+}
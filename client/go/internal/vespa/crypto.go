@@ -8,6 +8,7 @@ import (
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
@@ -82,6 +83,36 @@ func CreateKeyPair() (PemKeyPair, error) {
 	return PemKeyPair{Certificate: pemCertificate, PrivateKey: pemPrivateKey}, nil
 }
 
+// ValidateKeyPair verifies that the certificate and private key in kp belong together, and that the certificate is valid
+// at time now. It returns the parsed certificate.
+func ValidateKeyPair(kp PemKeyPair, now time.Time) (*x509.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(kp.Certificate, kp.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pair: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	if now.After(certificate.NotAfter) {
+		return nil, fmt.Errorf("certificate expired at %s", certificate.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(certificate.NotBefore) {
+		return nil, fmt.Errorf("certificate is not valid until %s", certificate.NotBefore.Format(time.RFC3339))
+	}
+	return certificate, nil
+}
+
+// FingerprintSHA256 returns a SHA-256 fingerprint of certificate.
+func FingerprintSHA256(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	hexDigits := make([]string, len(sum))
+	for i, c := range sum {
+		hexDigits[i] = strings.ToUpper(hex.EncodeToString([]byte{c}))
+	}
+	return strings.Join(hexDigits, ":")
+}
+
 // CreateAPIKey creates a EC private key encoded as PEM
 func CreateAPIKey() ([]byte, error) {
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
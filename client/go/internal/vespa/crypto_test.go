@@ -19,6 +19,24 @@ func TestCreateKeyPair(t *testing.T) {
 	assert.NotEmpty(t, kp.PrivateKey)
 }
 
+func TestValidateKeyPair(t *testing.T) {
+	kp, err := CreateKeyPair()
+	assert.Nil(t, err)
+	certificate, err := ValidateKeyPair(kp, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, defaultCommonName, certificate.Subject.CommonName)
+	assert.Regexp(t, "^([0-9A-F]{2}:){31}[0-9A-F]{2}$", FingerprintSHA256(certificate))
+
+	_, err = ValidateKeyPair(kp, time.Now().Add(certificateExpiry+time.Hour))
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "certificate expired at "))
+
+	other, err := CreateKeyPair()
+	assert.Nil(t, err)
+	_, err = ValidateKeyPair(PemKeyPair{Certificate: kp.Certificate, PrivateKey: other.PrivateKey}, time.Now())
+	assert.Equal(t, "invalid key pair: tls: private key does not match public key", err.Error())
+}
+
 func TestSignRequest(t *testing.T) {
 	fixedTime := time.Unix(0, 0)
 	rnd := rand.New(rand.NewSource(0)) // Fixed seed for testing purposes
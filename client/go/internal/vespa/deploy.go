@@ -5,6 +5,7 @@
 package vespa
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +32,10 @@ var (
 	DefaultZone        = ZoneID{Environment: "prod", Region: "default"}
 	DefaultDeployment  = Deployment{Application: DefaultApplication, Zone: DefaultZone}
 	ErrUnauthorized    = errors.New("unauthorized")
+	// ErrDestinationExists is the error returned when fetching an application package would overwrite existing files.
+	ErrDestinationExists = errors.New("refusing to overwrite")
+	// ErrNotFound is the error returned when fetching an application package which does not exist.
+	ErrNotFound = errors.New("not found")
 )
 
 type ApplicationID struct {
@@ -52,11 +59,17 @@ type DeploymentOptions struct {
 	Target             Target
 	ApplicationPackage ApplicationPackage
 	Version            version.Version
+	// PackageFunc is called with statistics of the zipped application package, before it is uploaded
+	PackageFunc func(PackageStats)
+	// UploadFunc, if non-nil, wraps the body uploading the application package, of the given size in bytes, e.g. to
+	// report the progress of the upload
+	UploadFunc func(body io.Reader, size int64) io.Reader
 }
 
 type Submission struct {
 	Risk        int    `json:"risk,omitempty"`
 	Commit      string `json:"commit,omitempty"`
+	Branch      string `json:"branch,omitempty"`
 	Description string `json:"description,omitempty"`
 	AuthorEmail string `json:"authorEmail,omitempty"`
 	SourceURL   string `json:"sourceUrl,omitempty"`
@@ -68,10 +81,66 @@ type LogLinePrepareResponse struct {
 	Message string
 }
 
+// DeployError is an error response from the deploy API, holding the log messages of the failed deployment.
+type DeployError struct {
+	LogLines []LogLinePrepareResponse
+	// StatusCode is the HTTP status of the response, if any
+	StatusCode int
+	// ErrorCode is the error code given in the response, such as INVALID_APPLICATION_PACKAGE, if any
+	ErrorCode string
+	// ValidationFailures holds the validations which rejected the deployment, and can be overridden, if any
+	ValidationFailures []ValidationFailure
+	err                error
+}
+
+func (e *DeployError) Error() string { return e.err.Error() }
+
+func (e *DeployError) Unwrap() error { return e.err }
+
 type PrepareResult struct {
 	// Session or Run ID
 	ID       int64
 	LogLines []LogLinePrepareResponse
+	// ConfigChangeActions holds the actions required for the deployed changes to take effect
+	ConfigChangeActions ConfigChangeActions
+}
+
+// ConfigChangeActions holds the actions returned by prepare, which are required for a config change to take effect.
+type ConfigChangeActions struct {
+	Restart []RestartAction      `json:"restart,omitempty"`
+	Refeed  []DocumentTypeAction `json:"refeed,omitempty"`
+	Reindex []DocumentTypeAction `json:"reindex,omitempty"`
+}
+
+// RestartAction is a config change action requiring services to be restarted.
+type RestartAction struct {
+	ClusterName string                `json:"clusterName"`
+	ClusterType string                `json:"clusterType"`
+	ServiceType string                `json:"serviceType"`
+	Messages    []string              `json:"messages"`
+	Services    []ChangeActionService `json:"services"`
+}
+
+// DocumentTypeAction is a config change action requiring documents of a document type to be re-fed or re-indexed.
+type DocumentTypeAction struct {
+	Name         string                `json:"name"`
+	DocumentType string                `json:"documentType"`
+	ClusterName  string                `json:"clusterName"`
+	Messages     []string              `json:"messages"`
+	Services     []ChangeActionService `json:"services"`
+}
+
+// ChangeActionService is a service affected by a config change action.
+type ChangeActionService struct {
+	ServiceName string `json:"serviceName"`
+	ServiceType string `json:"serviceType"`
+	ConfigID    string `json:"configId"`
+	HostName    string `json:"hostName"`
+}
+
+// IsEmpty returns whether no actions are required.
+func (a ConfigChangeActions) IsEmpty() bool {
+	return len(a.Restart) == 0 && len(a.Refeed) == 0 && len(a.Reindex) == 0
 }
 
 func (a ApplicationID) String() string {
@@ -94,6 +163,26 @@ func (d DeploymentOptions) String() string {
 	return fmt.Sprintf("%s to %s", d.Target.Deployment(), d.Target.Type())
 }
 
+// zipReader returns a reader for the zipped application package of these options, and its size in bytes.
+func (d *DeploymentOptions) zipReader() (io.ReadCloser, int64, error) {
+	r, stats, err := d.ApplicationPackage.zipReader(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.PackageFunc != nil {
+		d.PackageFunc(stats)
+	}
+	return r, stats.Size, nil
+}
+
+// uploadBody returns the body uploading the application package of these options.
+func (d *DeploymentOptions) uploadBody(body io.Reader, size int64) io.Reader {
+	if d.UploadFunc == nil {
+		return body
+	}
+	return d.UploadFunc(body, size)
+}
+
 func (d *DeploymentOptions) url(path string) (*url.URL, error) {
 	service, err := d.Target.DeployService()
 	if err != nil {
@@ -122,34 +211,121 @@ func ZoneFromString(s string) (ZoneID, error) {
 	return ZoneID{Environment: parts[0], Region: parts[1]}, nil
 }
 
-func Fetch(deployment DeploymentOptions, path string) (string, error) {
-	if ioutil.IsDir(path) {
-		path = filepath.Join(path, "application.zip")
+// FetchOptions holds options for fetching a deployed application package.
+type FetchOptions struct {
+	// Extract the application package into a directory, instead of writing it as a zip file
+	Extract bool
+	// Force overwriting of an existing zip file, or files in a non-empty directory
+	Force bool
+}
+
+// FetchResult describes a fetched application package.
+type FetchResult struct {
+	// Path is the zip file or directory the application package was written to
+	Path string
+	// Generation is the config generation (session) of the package, when fetched from a config server
+	Generation int64
+	// Build is the build number of the package, when fetched from Vespa Cloud and known
+	Build int64
+}
+
+// Fetch downloads the currently deployed application package of deployment to path.
+func Fetch(deployment DeploymentOptions, path string, options FetchOptions) (FetchResult, error) {
+	if options.Extract {
+		if ioutil.Exists(path) && !ioutil.IsDir(path) {
+			return FetchResult{}, fmt.Errorf("%s is not a directory", path)
+		}
+		if !options.Force && !isEmptyDir(path) {
+			return FetchResult{}, fmt.Errorf("%w: directory %s is not empty", ErrDestinationExists, path)
+		}
+	} else {
+		if ioutil.IsDir(path) {
+			path = filepath.Join(path, "application.zip")
+		}
+		if ioutil.Exists(path) && !options.Force {
+			return FetchResult{}, fmt.Errorf("%w: %s already exists", ErrDestinationExists, path)
+		}
 	}
-	if ioutil.Exists(path) {
-		return "", fmt.Errorf("%s already exists", path)
+	tmpDir, err := os.MkdirTemp("", "vespa")
+	if err != nil {
+		return FetchResult{}, err
 	}
+	defer os.RemoveAll(tmpDir)
+	zipFile := filepath.Join(tmpDir, "application.zip")
+	result := FetchResult{Path: path}
 	if deployment.Target.IsCloud() {
-		return path, fetchFromController(deployment, path)
+		result.Build, err = fetchFromController(deployment, zipFile)
+	} else {
+		result.Generation, err = fetchFromConfigServer(deployment, tmpDir, zipFile)
 	}
-	return path, fetchFromConfigServer(deployment, path)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if options.Extract {
+		return result, extractZip(zipFile, path)
+	}
+	if err := renameOrCopyTmpFile(zipFile, path); err != nil {
+		return FetchResult{}, fmt.Errorf("Could neither rename nor copy %s to %s: %w", zipFile, path, err)
+	}
+	return result, nil
 }
 
-func deployServiceGet(url string, deployment DeploymentOptions, w io.Writer) error {
-	req, err := http.NewRequest("GET", url, nil)
+func isEmptyDir(path string) bool {
+	entries, err := os.ReadDir(path)
+	return err != nil || len(entries) == 0
+}
+
+func extractZip(zipFile, dir string) error {
+	r, err := zip.OpenReader(zipFile)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
+	for _, f := range r.File {
+		if !validPath(f.Name) {
+			return fmt.Errorf("invalid path in application package: %s", f.Name)
+		}
+		dst := filepath.Join(dir, f.Name)
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(f, dst); err != nil {
+			return fmt.Errorf("copying %s to %s failed: %w", f.Name, dst, err)
+		}
+	}
+	return nil
+}
+
+func deployServiceGet(url string, deployment DeploymentOptions, w io.Writer) (http.Header, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
 	response, err := deployServiceDo(req, 0, deployment)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer response.Body.Close()
+	if response.StatusCode == 404 {
+		return nil, fmt.Errorf("could not fetch %s: %w", url, ErrNotFound)
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("could not fetch %s (status %d):\n%s", url, response.StatusCode, ioutil.ReaderToJSON(response.Body))
+	}
 	_, err = io.Copy(w, response.Body)
-	return err
+	return response.Header, err
 }
 
-func fetchFromController(deployment DeploymentOptions, path string) error {
+// buildPattern matches the build number in the file name of an application package served by the controller.
+var buildPattern = regexp.MustCompile(`-build(\d+)\.zip`)
+
+func fetchFromController(deployment DeploymentOptions, path string) (int64, error) {
 	var (
 		pkgURL *url.URL
 		err    error
@@ -169,44 +345,54 @@ func fetchFromController(deployment DeploymentOptions, path string) error {
 		)
 	}
 	if err != nil {
-		return err
+		return 0, err
 	}
-	tmpFile, err := os.CreateTemp("", "vespa")
+	f, err := os.Create(path)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer os.Remove(tmpFile.Name())
-	if err := deployServiceGet(pkgURL.String(), deployment, tmpFile); err != nil {
-		return err
+	header, err := deployServiceGet(pkgURL.String(), deployment, f)
+	if err != nil {
+		f.Close()
+		return 0, err
 	}
-	if err := tmpFile.Close(); err != nil {
-		return err
+	if err := f.Close(); err != nil {
+		return 0, err
 	}
-	return os.Rename(tmpFile.Name(), path)
+	var build int64
+	if m := buildPattern.FindStringSubmatch(header.Get("Content-Disposition")); m != nil {
+		build, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	return build, nil
 }
 
-func fetchFromConfigServer(deployment DeploymentOptions, path string) error {
-	tmpDir, err := os.MkdirTemp("", "vespa")
+func fetchFromConfigServer(deployment DeploymentOptions, tmpDir, path string) (int64, error) {
+	appURL, err := deployment.url("/application/v2/tenant/default/application/default")
 	if err != nil {
-		return err
+		return 0, err
+	}
+	var data bytes.Buffer
+	if _, err := deployServiceGet(appURL.String(), deployment, &data); err != nil {
+		return 0, err
+	}
+	var app struct {
+		Generation int64 `json:"generation"`
+	}
+	if err := json.Unmarshal(data.Bytes(), &app); err != nil {
+		return 0, fmt.Errorf("could not parse application response: %w", err)
 	}
-	defer os.RemoveAll(tmpDir)
 	u, err := deployment.url("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content")
 	if err != nil {
-		return err
+		return 0, err
 	}
 	dir := filepath.Join(tmpDir, "application")
 	if err := fetchFilesFromConfigServer(deployment, u, dir); err != nil {
-		return err
-	}
-	zipFile := filepath.Join(tmpDir, "application.zip")
-	if err := zipDir(dir, zipFile, &ignore.List{}); err != nil {
-		return err
+		return 0, err
 	}
-	if err = renameOrCopyTmpFile(zipFile, path); err != nil {
-		return fmt.Errorf("Could neither rename nor copy %s to %s: %w", zipFile, path, err)
+	if _, err := zipDir(dir, path, &ignore.List{}, nil); err != nil {
+		return 0, err
 	}
-	return err
+	return app.Generation, nil
 }
 
 func renameOrCopyTmpFile(srcPath, dstPath string) error {
@@ -217,21 +403,25 @@ func renameOrCopyTmpFile(srcPath, dstPath string) error {
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 	stat, err := os.Stat(srcPath)
 	if err != nil {
 		return err
 	}
-	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY, stat.Mode())
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, stat.Mode())
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(dst, src)
-	return err
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
 }
 
 func fetchFilesFromConfigServer(deployment DeploymentOptions, contentURL *url.URL, path string) error {
 	var data bytes.Buffer
-	if err := deployServiceGet(contentURL.String(), deployment, &data); err != nil {
+	if _, err := deployServiceGet(contentURL.String(), deployment, &data); err != nil {
 		return err
 	}
 	var fileURLs []string
@@ -256,7 +446,7 @@ func fetchFilesFromConfigServer(deployment DeploymentOptions, contentURL *url.UR
 			if err != nil {
 				return err
 			}
-			if err := deployServiceGet(fu, deployment, f); err != nil {
+			if _, err := deployServiceGet(fu, deployment, f); err != nil {
 				f.Close()
 				return err
 			}
@@ -293,8 +483,9 @@ func Prepare(deployment DeploymentOptions) (PrepareResult, error) {
 		return PrepareResult{}, err
 	}
 	var jsonResponse struct {
-		SessionID string                   `json:"session-id"` // API returns ID as string
-		Log       []LogLinePrepareResponse `json:"log"`
+		SessionID           string                   `json:"session-id"` // API returns ID as string
+		Log                 []LogLinePrepareResponse `json:"log"`
+		ConfigChangeActions ConfigChangeActions      `json:"configChangeActions"`
 	}
 	jsonDec := json.NewDecoder(response.Body)
 	if err := jsonDec.Decode(&jsonResponse); err != nil {
@@ -306,27 +497,35 @@ func Prepare(deployment DeploymentOptions) (PrepareResult, error) {
 		return PrepareResult{}, err
 	}
 	return PrepareResult{
-		ID:       id,
-		LogLines: jsonResponse.Log,
+		ID:                  id,
+		LogLines:            jsonResponse.Log,
+		ConfigChangeActions: jsonResponse.ConfigChangeActions,
 	}, err
 }
 
-// Activate deployment with sessionID from a past prepare
-func Activate(sessionID int64, deployment DeploymentOptions) error {
+// Activate deployment with sessionID from a past prepare, and return the log messages of the activation
+func Activate(sessionID int64, deployment DeploymentOptions) ([]LogLinePrepareResponse, error) {
 	u, err := deployment.url(fmt.Sprintf("/application/v2/tenant/default/session/%d/active", sessionID))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req, err := http.NewRequest("PUT", u.String(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	response, err := deployServiceDo(req, time.Second*30, deployment)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer response.Body.Close()
-	return checkResponse(req, response)
+	if err := checkResponse(req, response); err != nil {
+		return nil, err
+	}
+	var jsonResponse struct {
+		Log []LogLinePrepareResponse `json:"log"`
+	}
+	json.NewDecoder(response.Body).Decode(&jsonResponse) // Ignore error, as the log is optional
+	return jsonResponse.Log, nil
 }
 
 // Deactivate given deployment
@@ -356,6 +555,51 @@ func Deactivate(deployment DeploymentOptions) error {
 	return checkResponse(req, resp)
 }
 
+// DeployedZones returns the zones the instance of the application in target is deployed in, sorted by environment and
+// region.
+func DeployedZones(target Target) ([]ZoneID, error) {
+	if !target.IsCloud() {
+		return nil, fmt.Errorf("listing deployments is unsupported by %s target", target.Type())
+	}
+	var response struct {
+		Deployments []struct {
+			Environment string `json:"environment"`
+			Region      string `json:"region"`
+		} `json:"deployments"`
+	}
+	if err := getJSON(target, target.Deployment().System.InstanceURL(target.Deployment().Application), &response); err != nil {
+		return nil, err
+	}
+	zones := make([]ZoneID, 0, len(response.Deployments))
+	for _, d := range response.Deployments {
+		zones = append(zones, ZoneID{Environment: d.Environment, Region: d.Region})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].String() < zones[j].String() })
+	return zones, nil
+}
+
+// ApplicationExists returns whether the application of target exists in its tenant.
+func ApplicationExists(target Target) (bool, error) {
+	if !target.IsCloud() {
+		return false, fmt.Errorf("checking applications is unsupported by %s target", target.Type())
+	}
+	url := target.Deployment().System.ApplicationURL(target.Deployment().Application)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	status, err := deployRequest(target, func(status int, response []byte) (bool, error) {
+		if status == http.StatusNotFound {
+			return true, nil
+		}
+		return isOK(status)
+	}, func() *http.Request { return req }, 0, 0)
+	if err != nil {
+		return false, fmt.Errorf("request to %s failed: %w", url, err)
+	}
+	return status != http.StatusNotFound, nil
+}
+
 // Deploy deploys an application.
 func Deploy(deployment DeploymentOptions) (PrepareResult, error) {
 	var (
@@ -417,7 +661,7 @@ func Submit(opts DeploymentOptions, submission Submission) (int64, error) {
 	if err := copyToPart(writer, bytes.NewReader(submitOptions), "submitOptions", ""); err != nil {
 		return 0, err
 	}
-	applicationZip, err := opts.ApplicationPackage.zipReader(false)
+	applicationZip, _, err := opts.zipReader()
 	if err != nil {
 		return 0, err
 	}
@@ -425,7 +669,7 @@ func Submit(opts DeploymentOptions, submission Submission) (int64, error) {
 		return 0, err
 	}
 	if opts.ApplicationPackage.HasTests() {
-		testApplicationZip, err := opts.ApplicationPackage.zipReader(true)
+		testApplicationZip, _, err := opts.ApplicationPackage.zipReader(true)
 		if err != nil {
 			return 0, err
 		}
@@ -439,7 +683,7 @@ func Submit(opts DeploymentOptions, submission Submission) (int64, error) {
 	request := &http.Request{
 		URL:    u,
 		Method: "POST",
-		Body:   io.NopCloser(&body),
+		Body:   io.NopCloser(opts.uploadBody(&body, int64(body.Len()))),
 		Header: make(http.Header),
 	}
 	request.Header.Set("Content-Type", writer.FormDataContentType())
@@ -483,7 +727,7 @@ func checkDeploymentOpts(opts DeploymentOptions) error {
 }
 
 func newDeploymentRequest(url *url.URL, opts DeploymentOptions) (*http.Request, error) {
-	zipReader, err := opts.ApplicationPackage.zipReader(false)
+	zipReader, size, err := opts.zipReader()
 	if err != nil {
 		return nil, err
 	}
@@ -509,10 +753,10 @@ func newDeploymentRequest(url *url.URL, opts DeploymentOptions) (*http.Request,
 			return nil, err
 		}
 		header.Set("Content-Type", form.FormDataContentType())
-		body = &buf
+		body = opts.uploadBody(&buf, int64(buf.Len()))
 	} else {
 		header.Set("Content-Type", "application/zip")
-		body = zipReader
+		body = opts.uploadBody(zipReader, size)
 	}
 	return &http.Request{
 		URL:    url,
@@ -541,7 +785,8 @@ func uploadApplicationPackage(url *url.URL, opts DeploymentOptions) (PrepareResu
 		SessionID string `json:"session-id"` // Config server. API returns ID as string
 		RunID     int64  `json:"run"`        // Controller
 
-		Log []LogLinePrepareResponse `json:"log"`
+		Log                 []LogLinePrepareResponse `json:"log"`
+		ConfigChangeActions ConfigChangeActions      `json:"configChangeActions"`
 	}
 	jsonResponse.SessionID = "0" // Set a default session ID for responses that don't contain int (e.g. cloud deployment)
 	if err := checkResponse(request, response); err != nil {
@@ -557,20 +802,38 @@ func uploadApplicationPackage(url *url.URL, opts DeploymentOptions) (PrepareResu
 		}
 	}
 	return PrepareResult{
-		ID:       id,
-		LogLines: jsonResponse.Log,
+		ID:                  id,
+		LogLines:            jsonResponse.Log,
+		ConfigChangeActions: jsonResponse.ConfigChangeActions,
 	}, err
 }
 
 func checkResponse(req *http.Request, response *http.Response) error {
+	if response.StatusCode == 200 {
+		return nil
+	}
+	body, _ := io.ReadAll(response.Body)
+	var err error
 	if response.StatusCode == 401 || response.StatusCode == 403 {
-		return fmt.Errorf("deployment failed: %w (status %d)\n%s", ErrUnauthorized, response.StatusCode, ioutil.ReaderToJSON(response.Body))
+		err = fmt.Errorf("deployment failed: %w (status %d)\n%s", ErrUnauthorized, response.StatusCode, ioutil.ReaderToJSON(bytes.NewReader(body)))
 	} else if response.StatusCode/100 == 4 {
-		return fmt.Errorf("invalid application package (status %d)\n%s", response.StatusCode, extractError(response.Body))
-	} else if response.StatusCode != 200 {
-		return fmt.Errorf("error from deploy API at %s (status %d):\n%s", req.URL.Host, response.StatusCode, ioutil.ReaderToJSON(response.Body))
+		err = fmt.Errorf("invalid application package (status %d)\n%s", response.StatusCode, extractError(bytes.NewReader(body)))
+	} else {
+		err = fmt.Errorf("error from deploy API at %s (status %d):\n%s", req.URL.Host, response.StatusCode, ioutil.ReaderToJSON(bytes.NewReader(body)))
+	}
+	var jsonResponse struct {
+		ErrorCode string                   `json:"error-code"`
+		Message   string                   `json:"message"`
+		Log       []LogLinePrepareResponse `json:"log"`
+	}
+	json.Unmarshal(body, &jsonResponse) // Ignore error in case this is a non-JSON response
+	return &DeployError{
+		LogLines:           jsonResponse.Log,
+		StatusCode:         response.StatusCode,
+		ErrorCode:          jsonResponse.ErrorCode,
+		ValidationFailures: ParseValidationFailures(jsonResponse.Message),
+		err:                err,
 	}
-	return nil
 }
 
 // Returns the error message in the given JSON, or the entire content if it could not be extracted
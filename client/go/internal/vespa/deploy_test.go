@@ -203,10 +203,101 @@ func TestDeactivateCloud(t *testing.T) {
 	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1", req.URL.String())
 }
 
+func TestDeployedZones(t *testing.T) {
+	httpClient := mock.HTTPClient{}
+	target, _ := createCloudTarget(t, io.Discard)
+	cloudTarget, ok := target.(*cloudTarget)
+	require.True(t, ok)
+	cloudTarget.httpClient = &httpClient
+	httpClient.NextResponseString(200, `{"deployments":[{"environment":"prod","region":"aws-us-east-1c"},{"environment":"dev","region":"aws-us-east-1c"}]}`)
+	zones, err := DeployedZones(target)
+	require.Nil(t, err)
+	assert.Equal(t, []ZoneID{{Environment: "dev", Region: "aws-us-east-1c"}, {Environment: "prod", Region: "aws-us-east-1c"}}, zones)
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1/instance/i1", httpClient.LastRequest.URL.String())
+
+	httpClient.NextResponseString(404, `{"message":"not found"}`)
+	_, err = DeployedZones(target)
+	assert.NotNil(t, err)
+}
+
+func TestApplicationExists(t *testing.T) {
+	httpClient := mock.HTTPClient{}
+	target, _ := createCloudTarget(t, io.Discard)
+	cloudTarget, ok := target.(*cloudTarget)
+	require.True(t, ok)
+	cloudTarget.httpClient = &httpClient
+	httpClient.NextResponseString(200, `{"tenant":"t1","application":"a1"}`)
+	exists, err := ApplicationExists(target)
+	require.Nil(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "https://api-ctl.vespa-cloud.com:4443/application/v4/tenant/t1/application/a1", httpClient.LastRequest.URL.String())
+
+	httpClient.NextResponseString(404, `{"error-code":"NOT_FOUND","message":"t1.a1 not found"}`)
+	exists, err = ApplicationExists(target)
+	require.Nil(t, err)
+	assert.False(t, exists)
+
+	httpClient.NextResponseString(403, `{"message":"forbidden"}`)
+	_, err = ApplicationExists(target)
+	assert.NotNil(t, err)
+
+	_, err = ApplicationExists(LocalTarget(&httpClient, TLSOptions{}, 0))
+	assert.NotNil(t, err)
+}
+
 func TestFetch(t *testing.T) {
 	httpClient := mock.HTTPClient{}
 	target := LocalTarget(&httpClient, TLSOptions{}, 0)
 	opts := DeploymentOptions{Target: target}
+	mockFetchResponses(&httpClient)
+	dir := t.TempDir()
+	result, err := Fetch(opts, dir, FetchOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "application.zip"), result.Path)
+	assert.Equal(t, int64(3), result.Generation)
+	assert.True(t, ioutil.Exists(result.Path))
+
+	f, err := os.Open(result.Path)
+	require.Nil(t, err)
+	defer f.Close()
+	zr, err := zip.NewReader(f, 1000)
+	require.Nil(t, err)
+	schema, err := zr.Open("schemas/music.sd")
+	require.Nil(t, err)
+	data, err := io.ReadAll(schema)
+	require.Nil(t, err)
+	assert.Equal(t, `music.sd contents`, string(data))
+
+	// Existing file is not overwritten
+	_, err = Fetch(opts, dir, FetchOptions{})
+	assert.ErrorIs(t, err, ErrDestinationExists)
+	mockFetchResponses(&httpClient)
+	_, err = Fetch(opts, dir, FetchOptions{Force: true})
+	assert.Nil(t, err)
+
+	// Extract into directory
+	extractDir := filepath.Join(dir, "app")
+	mockFetchResponses(&httpClient)
+	result, err = Fetch(opts, extractDir, FetchOptions{Extract: true})
+	require.Nil(t, err)
+	assert.Equal(t, extractDir, result.Path)
+	data, err = os.ReadFile(filepath.Join(extractDir, "schemas", "music.sd"))
+	require.Nil(t, err)
+	assert.Equal(t, `music.sd contents`, string(data))
+	_, err = Fetch(opts, extractDir, FetchOptions{Extract: true})
+	assert.ErrorIs(t, err, ErrDestinationExists)
+	mockFetchResponses(&httpClient)
+	_, err = Fetch(opts, extractDir, FetchOptions{Extract: true, Force: true})
+	assert.Nil(t, err)
+	assert.True(t, httpClient.Consumed())
+}
+
+func mockFetchResponses(httpClient *mock.HTTPClient) {
+	httpClient.NextResponse(mock.HTTPResponse{
+		URI:    "/application/v2/tenant/default/application/default",
+		Status: 200,
+		Body:   []byte(`{"generation": 3}`),
+	})
 	httpClient.NextResponse(mock.HTTPResponse{
 		URI:    "/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content",
 		Status: 200,
@@ -232,21 +323,6 @@ func TestFetch(t *testing.T) {
 		Status: 200,
 		Body:   []byte(`services.xml contents`),
 	})
-	dir := t.TempDir()
-	dst, err := Fetch(opts, dir)
-	require.Nil(t, err)
-	assert.True(t, ioutil.Exists(dst))
-
-	f, err := os.Open(dst)
-	require.Nil(t, err)
-	defer f.Close()
-	zr, err := zip.NewReader(f, 1000)
-	require.Nil(t, err)
-	schema, err := zr.Open("schemas/music.sd")
-	require.Nil(t, err)
-	data, err := io.ReadAll(schema)
-	require.Nil(t, err)
-	assert.Equal(t, `music.sd contents`, string(data))
 }
 
 func TestFetchCloud(t *testing.T) {
@@ -260,11 +336,17 @@ func TestFetchCloud(t *testing.T) {
 		URI:    "/application/v4/tenant/t1/application/a1/instance/i1/job/dev-us-north-1/package",
 		Status: 200,
 		Body:   []byte(`application zip`),
+		Header: http.Header{"Content-Disposition": []string{`attachment; filename="t1.a1-build42.zip"`}},
 	})
 	dir := t.TempDir()
-	dst, err := Fetch(opts, dir)
+	result, err := Fetch(opts, dir, FetchOptions{})
 	require.Nil(t, err)
-	assert.True(t, ioutil.Exists(dst))
+	assert.True(t, ioutil.Exists(result.Path))
+	assert.Equal(t, int64(42), result.Build)
+
+	httpClient.NextStatus(404)
+	_, err = Fetch(opts, t.TempDir(), FetchOptions{})
+	assert.NotNil(t, err)
 }
 
 type pkgFixture struct {
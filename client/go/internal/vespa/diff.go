@@ -0,0 +1,113 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxDiffContentSize is the maximum size of a file whose content is kept for diffing.
+const maxDiffContentSize = 1 << 20
+
+// PackageFile describes a file in an application package.
+type PackageFile struct {
+	Path   string
+	Size   int64
+	SHA256 string
+	// Content holds the content of the file, if it's no larger than 1 MiB
+	Content []byte
+}
+
+// FileChange is a file which differs between two application packages.
+type FileChange struct {
+	Path string
+	// Old is the file in the old application package, or nil if the file was added
+	Old *PackageFile
+	// New is the file in the new application package, or nil if the file was removed
+	New *PackageFile
+}
+
+// Added returns whether this file only exists in the new application package.
+func (c FileChange) Added() bool { return c.Old == nil }
+
+// Removed returns whether this file only exists in the old application package.
+func (c FileChange) Removed() bool { return c.New == nil }
+
+// Files returns the files of this application package, keyed by their path in the package. If the application package
+// is a directory, files matching ignore patterns are not included.
+func (ap *ApplicationPackage) Files() (map[string]PackageFile, error) {
+	r, _, err := ap.zipReader(false)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("could not read application package at '%s': %w", ap.Path, err)
+	}
+	files := make(map[string]PackageFile)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		file, err := readPackageFile(f)
+		if err != nil {
+			return nil, err
+		}
+		files[file.Path] = file
+	}
+	return files, nil
+}
+
+func readPackageFile(f *zip.File) (PackageFile, error) {
+	r, err := f.Open()
+	if err != nil {
+		return PackageFile{}, err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	var content bytes.Buffer
+	w := io.Writer(hash)
+	if f.UncompressedSize64 <= maxDiffContentSize {
+		w = io.MultiWriter(hash, &content)
+	}
+	size, err := io.Copy(w, r)
+	if err != nil {
+		return PackageFile{}, fmt.Errorf("could not read %s: %w", f.Name, err)
+	}
+	file := PackageFile{Path: strings.TrimPrefix(f.Name, "/"), Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	if content.Len() > 0 || size == 0 {
+		file.Content = content.Bytes()
+	}
+	return file, nil
+}
+
+// DiffFiles returns the files which are added, removed or modified in newFiles, compared to oldFiles, sorted by path.
+func DiffFiles(oldFiles, newFiles map[string]PackageFile) []FileChange {
+	var changes []FileChange
+	for path, newFile := range newFiles {
+		oldFile, ok := oldFiles[path]
+		if !ok {
+			changes = append(changes, FileChange{Path: path, New: &newFile})
+		} else if oldFile.SHA256 != newFile.SHA256 {
+			changes = append(changes, FileChange{Path: path, Old: &oldFile, New: &newFile})
+		}
+	}
+	for path, oldFile := range oldFiles {
+		if _, ok := newFiles[path]; !ok {
+			changes = append(changes, FileChange{Path: path, Old: &oldFile})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
@@ -0,0 +1,55 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "services.xml"), []byte("<services/>"), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("ignored"), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, ".vespaignore"), []byte("ignored.txt\n"), 0644))
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "schemas"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "schemas", "music.sd"), []byte("schema music {}"), 0644))
+
+	pkg := ApplicationPackage{Path: dir}
+	files, err := pkg.Files()
+	require.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	services := files["services.xml"]
+	assert.Equal(t, int64(11), services.Size)
+	assert.Equal(t, "<services/>", string(services.Content))
+	assert.Equal(t, 64, len(services.SHA256))
+	assert.Contains(t, files, "schemas/music.sd")
+	assert.NotContains(t, files, "ignored.txt")
+}
+
+func TestDiffFiles(t *testing.T) {
+	oldFiles := map[string]PackageFile{
+		"a": {Path: "a", SHA256: "1"},
+		"b": {Path: "b", SHA256: "2"},
+		"c": {Path: "c", SHA256: "3"},
+	}
+	newFiles := map[string]PackageFile{
+		"a": {Path: "a", SHA256: "1"},
+		"b": {Path: "b", SHA256: "4"},
+		"d": {Path: "d", SHA256: "5"},
+	}
+	changes := DiffFiles(oldFiles, newFiles)
+	require.Equal(t, 3, len(changes))
+	assert.Equal(t, "b", changes[0].Path)
+	assert.False(t, changes[0].Added())
+	assert.False(t, changes[0].Removed())
+	assert.Equal(t, "4", changes[0].New.SHA256)
+	assert.Equal(t, "c", changes[1].Path)
+	assert.True(t, changes[1].Removed())
+	assert.Equal(t, "d", changes[2].Path)
+	assert.True(t, changes[2].Added())
+	assert.Empty(t, DiffFiles(newFiles, newFiles))
+}
@@ -0,0 +1,127 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"slices"
+	"sync"
+)
+
+// Checkpoint tracks the low-water mark of a sequence of document operations read from a single input. The low-water
+// mark is the number of operations, counted from the start of the input, for which every operation has been
+// processed: either acknowledged, or failed permanently. Operations which failed permanently are recorded separately,
+// such that they can be fed again when the feed is resumed.
+//
+// Operations may complete in any order, as the dispatcher sends them concurrently, so an operation only advances the
+// low-water mark once all operations before it have completed.
+type Checkpoint struct {
+	mu     sync.Mutex
+	next   int64
+	low    int64
+	done   map[int64]bool
+	failed map[int64]bool
+	// refeed holds the operations before the initial low-water mark which failed when previously fed, until they
+	// succeed
+	refeed map[int64]bool
+}
+
+// NewCheckpoint creates a new checkpoint, where the first offset operations are considered completed, except those
+// given by failed, which failed when previously fed.
+func NewCheckpoint(offset int64, failed ...int64) *Checkpoint {
+	c := &Checkpoint{next: offset, low: offset, done: make(map[int64]bool), failed: make(map[int64]bool), refeed: make(map[int64]bool)}
+	for _, seq := range failed {
+		if seq < offset {
+			c.refeed[seq] = true
+		}
+	}
+	return c
+}
+
+// Track assigns the next sequence number of this checkpoint to doc, unless doc is already tracked by Refeed. The
+// checkpoint is notified by the dispatcher when the operation completes.
+func (c *Checkpoint) Track(doc *Document) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if doc.checkpoint == c {
+		return
+	}
+	doc.checkpoint = c
+	doc.seq = c.next
+	c.next++
+}
+
+// Refeed returns whether the operation at given sequence number, before the initial low-water mark, failed when
+// previously fed, and must be fed again. If so, doc is tracked as that operation.
+func (c *Checkpoint) Refeed(doc *Document, seq int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.refeed[seq] {
+		return false
+	}
+	doc.checkpoint = c
+	doc.seq = seq
+	return true
+}
+
+// Skip completes doc without sending it, e.g. because it is left out of the feed. If doc is not yet tracked, it is
+// assigned the next sequence number.
+func (c *Checkpoint) Skip(doc *Document) {
+	c.Track(doc)
+	c.complete(doc.seq, true)
+}
+
+// Fail completes doc as failed without sending it, e.g. because it could not be transformed. If doc is not yet
+// tracked, it is assigned the next sequence number.
+func (c *Checkpoint) Fail(doc *Document) {
+	c.Track(doc)
+	c.complete(doc.seq, false)
+}
+
+// Completed returns the current low-water mark.
+func (c *Checkpoint) Completed() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.low
+}
+
+// Failed returns the sequence numbers, in order, of the operations below the low-water mark which failed, including
+// those which failed when previously fed and have not succeeded since.
+func (c *Checkpoint) Failed() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var failed []int64
+	for seq := range c.failed {
+		if seq < c.low && !c.refeed[seq] {
+			failed = append(failed, seq)
+		}
+	}
+	for seq := range c.refeed {
+		failed = append(failed, seq)
+	}
+	slices.Sort(failed)
+	return failed
+}
+
+// Tracked returns the number of operations tracked, including the operations considered completed initially.
+func (c *Checkpoint) Tracked() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.next
+}
+
+func (c *Checkpoint) complete(seq int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		delete(c.refeed, seq)
+	} else {
+		c.failed[seq] = true
+	}
+	if seq < c.low {
+		return
+	}
+	c.done[seq] = true
+	for c.done[c.low] {
+		delete(c.done, c.low)
+		c.low++
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	c := NewCheckpoint(0)
+	docs := make([]Document, 4)
+	for i := range docs {
+		c.Track(&docs[i])
+	}
+	assert.Equal(t, int64(0), c.Completed())
+	assert.Equal(t, int64(4), c.Tracked())
+	c.complete(docs[1].seq, true)
+	c.complete(docs[2].seq, false)
+	assert.Equal(t, int64(0), c.Completed())
+	c.complete(docs[0].seq, true)
+	assert.Equal(t, int64(3), c.Completed())
+	assert.Equal(t, []int64{2}, c.Failed())
+	c.complete(docs[3].seq, true)
+	assert.Equal(t, int64(4), c.Completed())
+	c.complete(docs[3].seq, true) // Duplicate completion is ignored
+	assert.Equal(t, int64(4), c.Completed())
+
+	assert.Equal(t, int64(5), NewCheckpoint(5).Tracked())
+
+	c = NewCheckpoint(0)
+	c.Track(&docs[0])
+	c.Skip(&Document{})
+	c.Track(&docs[1])
+	assert.Equal(t, int64(0), c.Completed())
+	c.complete(docs[0].seq, true)
+	assert.Equal(t, int64(2), c.Completed())
+	assert.Equal(t, int64(2), docs[1].seq)
+	assert.Nil(t, c.Failed())
+
+	// Operations which failed before resuming are fed again, and recorded as failed again if they fail again
+	c = NewCheckpoint(4, 1, 3, 5)
+	refed := make([]Document, 2)
+	assert.False(t, c.Refeed(&refed[0], 0))
+	assert.True(t, c.Refeed(&refed[0], 1))
+	assert.True(t, c.Refeed(&refed[1], 3))
+	assert.False(t, c.Refeed(&refed[1], 5))
+	assert.Equal(t, []int64{1, 3}, c.Failed())
+	c.Track(&refed[0]) // Already tracked
+	assert.Equal(t, int64(1), refed[0].seq)
+	c.complete(refed[0].seq, true)
+	c.Fail(&refed[1])
+	assert.Equal(t, int64(4), c.Completed())
+	assert.Equal(t, int64(4), c.Tracked())
+	assert.Equal(t, []int64{3}, c.Failed())
+}
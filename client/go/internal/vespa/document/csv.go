@@ -0,0 +1,186 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	templateVariable = regexp.MustCompile(`\{([^{}]+)\}`)
+	jsonNumber       = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// CSVDecoder decodes put operations from CSV records. The first record is a header naming the columns. The document ID
+// of each operation is created from a template referring to columns by name, e.g. "id:ns:type::{sku}". The remaining
+// columns become fields of the document. Values that are valid JSON numbers are written as numbers, other values as
+// strings, while empty values are omitted.
+type CSVDecoder struct {
+	r          *csv.Reader
+	idTemplate string
+
+	header     []string
+	ids        *idTemplate
+	headerRead bool
+}
+
+// NewCSVDecoder creates a decoder for CSV records read from r, separated by comma. Quoting follows RFC 4180.
+func NewCSVDecoder(r io.Reader, comma rune, idTemplate string) *CSVDecoder {
+	cr := csv.NewReader(r)
+	cr.Comma = comma
+	cr.ReuseRecord = true
+	return &CSVDecoder{r: cr, idTemplate: idTemplate}
+}
+
+func (d *CSVDecoder) readHeader() error {
+	d.headerRead = true
+	header, err := d.r.Read()
+	if err == io.EOF {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // Byte order mark
+	}
+	names, columns, err := columnNames(header)
+	if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	ids, err := newIdTemplate(d.idTemplate, columns)
+	if err != nil {
+		return err
+	}
+	d.header = names
+	d.ids = ids
+	return nil
+}
+
+// columnNames returns the trimmed names of the given columns, and the index of each, or an error if any name is empty
+// or repeated.
+func columnNames(header []string) ([]string, map[string]int, error) {
+	names := make([]string, len(header))
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, nil, fmt.Errorf("column %d has no name", i+1)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, nil, fmt.Errorf("duplicate column %q", name)
+		}
+		columns[name] = i
+		names[i] = name
+	}
+	return names, columns, nil
+}
+
+// idTemplate creates document IDs by replacing each {column} of a template by the value of that column.
+type idTemplate struct {
+	template string
+	columns  map[string]int
+}
+
+func newIdTemplate(template string, columns map[string]int) (*idTemplate, error) {
+	t := &idTemplate{template: template, columns: make(map[string]int)}
+	for _, m := range templateVariable.FindAllStringSubmatch(template, -1) {
+		i, ok := columns[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("id template %q refers to unknown column %q", template, m[1])
+		}
+		t.columns[m[1]] = i
+	}
+	if len(t.columns) == 0 {
+		return nil, fmt.Errorf("id template %q does not refer to any column", template)
+	}
+	return t, nil
+}
+
+// refers returns whether the template refers to the named column.
+func (t *idTemplate) refers(name string) bool {
+	_, ok := t.columns[name]
+	return ok
+}
+
+// id returns the document ID created from the column values given by value.
+func (t *idTemplate) id(value func(column int) string) (Id, error) {
+	var templateErr error
+	id := templateVariable.ReplaceAllStringFunc(t.template, func(v string) string {
+		name := v[1 : len(v)-1]
+		value := value(t.columns[name])
+		if value == "" && templateErr == nil {
+			templateErr = fmt.Errorf("column %q is empty", name)
+		}
+		return value
+	})
+	if templateErr != nil {
+		return Id{}, templateErr
+	}
+	return ParseId(id)
+}
+
+// Decode returns the put operation of the next record.
+func (d *CSVDecoder) Decode() (Document, error) {
+	if !d.headerRead {
+		if err := d.readHeader(); err != nil {
+			return Document{}, err
+		}
+	}
+	if d.header == nil {
+		return Document{}, io.EOF
+	}
+	record, err := d.r.Read()
+	if err != nil {
+		return Document{}, err
+	}
+	line, _ := d.r.FieldPos(0)
+	doc, err := d.decode(record)
+	if err != nil {
+		return Document{}, fmt.Errorf("invalid record on line %d: %w", line, err)
+	}
+	return doc, nil
+}
+
+func (d *CSVDecoder) decode(record []string) (Document, error) {
+	docId, err := d.ids.id(func(column int) string { return record[column] })
+	if err != nil {
+		return Document{}, err
+	}
+	var body bytes.Buffer
+	body.WriteString(`{"fields":{`)
+	first := true
+	for i, value := range record {
+		name := d.header[i]
+		if d.ids.refers(name) || value == "" {
+			continue
+		}
+		if !first {
+			body.WriteString(",")
+		}
+		first = false
+		writeJSONString(&body, name)
+		body.WriteString(":")
+		writeInferredValue(&body, value)
+	}
+	body.WriteString("}}")
+	return Document{Id: docId, Operation: OperationPut, Body: body.Bytes()}, nil
+}
+
+// writeInferredValue writes value as a JSON number if it is a valid one, and as a string otherwise.
+func writeInferredValue(buf *bytes.Buffer, value string) {
+	if jsonNumber.MatchString(value) {
+		buf.WriteString(value)
+	} else {
+		writeJSONString(buf, value)
+	}
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	data, _ := json.Marshal(s) // Marshalling a string cannot fail
+	buf.Write(data)
+}
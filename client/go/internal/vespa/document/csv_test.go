@@ -0,0 +1,68 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVDecoder(t *testing.T) {
+	input := `sku,title,price,zip,notes
+a1,"Hello, ""world""",12.50,00123,
+b2,Plain,-3,1e3,"multi
+line"
+`
+	dec := NewCSVDecoder(strings.NewReader(input), ',', "id:music:song::{sku}")
+	want := []Document{
+		{Id: mustParseId("id:music:song::a1"), Operation: OperationPut, Body: []byte(`{"fields":{"title":"Hello, \"world\"","price":12.50,"zip":"00123"}}`)},
+		{Id: mustParseId("id:music:song::b2"), Operation: OperationPut, Body: []byte(`{"fields":{"title":"Plain","price":-3,"zip":1e3,"notes":"multi\nline"}}`)},
+	}
+	for i, w := range want {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %s", i, err)
+		}
+		if !got.Equal(w) {
+			t.Errorf("#%d: got %s, want %s", i, got, w)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("got err = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestCSVDecoderTSV(t *testing.T) {
+	dec := NewCSVDecoder(strings.NewReader("group\tid\tscore\nfoo\t1\t0.5\n"), '\t', "id:ns:type:g={group}:{id}")
+	got, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Document{Id: mustParseId("id:ns:type:g=foo:1"), Operation: OperationPut, Body: []byte(`{"fields":{"score":0.5}}`)}
+	if !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCSVDecoderInvalid(t *testing.T) {
+	tests := []struct {
+		input    string
+		template string
+		err      string
+	}{
+		{"sku,title\n", "id:ns:type::{id}", `id template "id:ns:type::{id}" refers to unknown column "id"`},
+		{"sku,title\n", "id:ns:type::doc", `id template "id:ns:type::doc" does not refer to any column`},
+		{"sku,sku\n", "id:ns:type::{sku}", `invalid header: duplicate column "sku"`},
+		{"sku,title\n,foo\n", "id:ns:type::{sku}", `invalid record on line 2: column "sku" is empty`},
+		{"sku,title\na,b,c\n", "id:ns:type::{sku}", `record on line 2: wrong number of fields`},
+		{"sku,title\na,\"b\n", "id:ns:type::{sku}", `parse error on line 2, column 6: extraneous or missing " in quoted-field`},
+		{"sku,title\na/b,c\n", "{sku}", `invalid record on line 2: invalid document: expected id:<namespace>:<document-type>:[n=<number>|g=<group>]:<user-specific>, got "a/b"`},
+	}
+	for i, tt := range tests {
+		dec := NewCSVDecoder(strings.NewReader(tt.input), ',', tt.template)
+		_, err := dec.Decode()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("#%d: got err = %v, want %q", i, err, tt.err)
+		}
+	}
+}
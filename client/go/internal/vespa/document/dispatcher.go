@@ -2,6 +2,7 @@
 package document
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -14,10 +15,14 @@ import (
 // maxAttempts controls the maximum number of times a document operation is attempted before giving up.
 const maxAttempts = 10
 
+// ErrTooManyErrors is returned when operations are refused because too many operations have failed in a row.
+var ErrTooManyErrors = errors.New("too many errors")
+
 // Feeder is the interface for a consumer of documents.
 type Feeder interface{ Send(Document) Result }
 
-// Dispatcher dispatches documents from a queue to a Feeder.
+// Dispatcher dispatches documents from a queue to a Feeder. Operations on the same document ID are sent one at a time,
+// in the order they were enqueued, while operations on different IDs are sent concurrently.
 type Dispatcher struct {
 	feeder         Feeder
 	throttler      Throttler
@@ -30,8 +35,15 @@ type Dispatcher struct {
 
 	inflight      map[string]*Queue[documentOp]
 	inflightCount atomic.Int64
+	maxQueueDepth int64
 	output        io.Writer
 	verbose       bool
+	errorLog      *ErrorLog
+	rateLimiter   *RateLimiter
+	memory        *memoryBudget
+	// completed is called with each operation which completes, with its final result
+	completed func(Document, Result)
+	now       func() time.Time
 
 	mu         sync.Mutex
 	statsMu    sync.Mutex
@@ -46,6 +58,47 @@ type documentOp struct {
 	attempts int
 }
 
+// memoryBudget bounds the total size of the bodies of the operations held by a dispatcher.
+type memoryBudget struct {
+	limit    int64
+	buffered int64
+	peak     int64
+	waited   bool
+	mu       sync.Mutex
+	cond     *sync.Cond
+}
+
+// acquire waits until the body of size n fits within the budget, and adds it to the buffered bytes. A body which does
+// not fit is accepted when nothing else is buffered. It returns whether this is the first time acquire had to wait.
+func (b *memoryBudget) acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	firstWait := false
+	for b.limit > 0 && b.buffered > 0 && b.buffered+n > b.limit {
+		if !b.waited {
+			b.waited = true
+			firstWait = true
+		}
+		b.cond.Wait()
+	}
+	b.buffered += n
+	b.peak = max(b.peak, b.buffered)
+	return firstWait
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.buffered -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *memoryBudget) peakBytes() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
+
 func (op documentOp) resetResult() documentOp {
 	op.result = Result{}
 	return op
@@ -59,11 +112,32 @@ func NewDispatcher(feeder Feeder, throttler Throttler, breaker CircuitBreaker, o
 		inflight:       make(map[string]*Queue[documentOp]),
 		output:         output,
 		verbose:        verbose,
+		memory:         &memoryBudget{},
+		now:            time.Now,
 	}
+	d.memory.cond = sync.NewCond(&d.memory.mu)
 	d.start()
 	return d
 }
 
+// SetErrorLog sets the error log receiving operations which fail permanently. It must be called before any documents
+// are enqueued.
+func (d *Dispatcher) SetErrorLog(errorLog *ErrorLog) { d.errorLog = errorLog }
+
+// SetRateLimiter sets the rate limiter bounding the rate of requests sent to the feeder, including retries. It must be
+// called before any documents are enqueued.
+func (d *Dispatcher) SetRateLimiter(rateLimiter *RateLimiter) { d.rateLimiter = rateLimiter }
+
+// SetMemoryLimit sets the maximum total size, in bytes, of the bodies of the operations held by the dispatcher, from
+// they are enqueued until they complete. Enqueue blocks while an operation would exceed this limit, but an operation
+// larger than the limit is accepted when no other operations are held. Zero means no limit. It must be called before
+// any documents are enqueued.
+func (d *Dispatcher) SetMemoryLimit(bytes int64) { d.memory.limit = bytes }
+
+// SetNowFunc sets the clock giving the time operations are dispatched, from which their operation timeout is counted.
+// It must be called before any documents are enqueued.
+func (d *Dispatcher) SetNowFunc(now func() time.Time) { d.now = now }
+
 func (d *Dispatcher) logResult(op documentOp, retry bool) {
 	doc := op.document
 	result := op.result
@@ -97,6 +171,10 @@ func (d *Dispatcher) logResult(op documentOp, retry bool) {
 	if !result.Success() {
 		if retry {
 			msg.WriteString(": retrying")
+		} else if errors.Is(result.Err, ErrOperationTimeout) {
+			msg.WriteString(": giving up after ")
+			msg.WriteString(strconv.Itoa(op.attempts - 1))
+			msg.WriteString(" attempts")
 		} else if op.attempts > 1 {
 			msg.WriteString(": giving up after ")
 			msg.WriteString(strconv.Itoa(maxAttempts))
@@ -109,12 +187,21 @@ func (d *Dispatcher) logResult(op documentOp, retry bool) {
 }
 
 func (d *Dispatcher) shouldRetry(op documentOp, result Result) bool {
+	if result.Status == StatusInvalidOperation {
+		return false
+	}
 	if result.Success() {
 		d.throttler.Success()
 		d.circuitBreaker.Success()
 		return false
-	} else if result.HTTPStatus == 429 {
+	}
+	if result.Throttled() {
 		d.throttler.Throttled(d.inflightCount.Load())
+	}
+	if errors.Is(result.Err, ErrOperationTimeout) {
+		return false
+	}
+	if result.HTTPStatus == 429 {
 		return true
 	} else if result.Err != nil || result.HTTPStatus == 503 {
 		d.circuitBreaker.Failure()
@@ -141,11 +228,16 @@ func (d *Dispatcher) start() {
 
 func (d *Dispatcher) dispatch(op documentOp) {
 	if !d.acceptDocument() {
-		d.msgs <- fmt.Sprintf("refusing to dispatch document %s: too many errors", op.document.Id.String())
+		d.msgs <- fmt.Sprintf("refusing to dispatch document %s: %s", op.document.Id.String(), ErrTooManyErrors)
 		d.results <- op.resetResult()
 		return
 	}
 	go func() {
+		d.rateLimiter.Wait(op.document)
+		if op.attempts == 0 {
+			// Set after waiting for the rate limiter, such that the wait does not count against the operation timeout
+			op.document.dispatched = d.now()
+		}
 		op.attempts++
 		op.result = d.feeder.Send(op.document)
 		d.results <- op
@@ -161,8 +253,31 @@ func (d *Dispatcher) processResults() {
 		retry := d.shouldRetry(op, op.result)
 		d.logResult(op, retry)
 		if retry {
-			d.enqueue(op.resetResult(), true)
-		} else {
+			// A retry goes first in the queue of its ID, such that it is sent before any later operation on the same
+			// document
+			if err := d.enqueue(op.resetResult(), true); err != nil {
+				d.msgs <- fmt.Sprintf("feed: could not retry %s %s: %s", op.document.Operation, op.document.Id, err)
+				retry = false
+			}
+		}
+		if !retry {
+			if !op.result.Success() {
+				d.statsMu.Lock()
+				d.stats.AddFailure(op.result)
+				d.statsMu.Unlock()
+			}
+			if op.document.checkpoint != nil {
+				op.document.checkpoint.complete(op.document.seq, op.result.Success())
+			}
+			if d.errorLog != nil && !op.result.Success() {
+				if err := d.errorLog.Write(op.document, op.result, op.attempts); err != nil {
+					d.msgs <- fmt.Sprintf("feed: could not write %s %s to error log: %s", op.document.Operation, op.document.Id, err)
+				}
+			}
+			if d.completed != nil {
+				d.completed(op.document, op.result)
+			}
+			d.memory.release(int64(len(op.document.Body)))
 			op.document.Reset()
 			d.inflightWg.Done()
 		}
@@ -209,18 +324,23 @@ func (d *Dispatcher) enqueue(op documentOp, isRetry bool) error {
 	}
 	if !d.acceptDocument() {
 		d.mu.Unlock()
-		return fmt.Errorf("refusing to enqueue document %s: too many errors", op.document.Id.String())
+		return fmt.Errorf("refusing to enqueue document %s: %w", op.document.Id.String(), ErrTooManyErrors)
 	}
 	k := op.document.Id.String()
 	q, ok := d.inflight[k]
 	if !ok {
 		d.inflight[k] = nil // track operation, but defer allocating queue until needed
+		d.maxQueueDepth = max(d.maxQueueDepth, 1)
 	} else {
 		if q == nil {
 			q = NewQueue[documentOp]()
 			d.inflight[k] = q
 		}
 		q.Add(op, isRetry)
+		if !isRetry {
+			// The queue holds the operations waiting for the one in flight
+			d.maxQueueDepth = max(d.maxQueueDepth, int64(q.Len()+1))
+		}
 	}
 	if !isRetry {
 		d.inflightWg.Add(1)
@@ -257,13 +377,30 @@ func (d *Dispatcher) acquireSlot() {
 
 func (d *Dispatcher) releaseSlot() { d.inflightCount.Add(-1) }
 
-func (d *Dispatcher) Enqueue(doc Document) error { return d.enqueue(documentOp{document: doc}, false) }
+// Enqueue enqueues doc for dispatching, waiting until its body fits within the memory limit of this.
+func (d *Dispatcher) Enqueue(doc Document) error {
+	size := int64(len(doc.Body))
+	if d.memory.acquire(size) && d.verbose {
+		d.msgs <- fmt.Sprintf("feed: operations in flight reached the memory limit of %d bytes: reading of input waits for them to complete", d.memory.limit)
+	}
+	if err := d.enqueue(documentOp{document: doc}, false); err != nil {
+		d.memory.release(size)
+		return err
+	}
+	return nil
+}
 
 func (d *Dispatcher) Stats() Stats {
 	d.statsMu.Lock()
 	defer d.statsMu.Unlock()
 	statsCopy := d.stats.Clone()
 	statsCopy.Inflight = d.inflightCount.Load()
+	statsCopy.TargetInflight = d.throttler.TargetInflight()
+	statsCopy.RateLimited = d.rateLimiter.Waited()
+	statsCopy.PeakBufferedBytes = d.memory.peakBytes()
+	d.mu.Lock()
+	statsCopy.MaxQueueDepth = d.maxQueueDepth
+	d.mu.Unlock()
 	return statsCopy
 }
 
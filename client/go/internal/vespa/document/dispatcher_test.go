@@ -2,12 +2,19 @@
 package document
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 )
 
 type mockFeeder struct {
@@ -41,6 +48,7 @@ func (f *mockFeeder) Send(doc Document) Result {
 		result.HTTPStatus = 503
 		result.Status = StatusVespaFailure
 	} else {
+		doc.dispatched = time.Time{} // Compared to documents as enqueued
 		f.documents = append(f.documents, doc)
 	}
 	return result
@@ -164,7 +172,206 @@ func TestDispatcherOrderingWithRetry(t *testing.T) {
 	}
 	dispatcher.Close()
 	assert.Equal(t, docs, feeder.documents)
-	assert.Equal(t, int64(5), dispatcher.Stats().Unsuccessful())
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(5), stats.Unsuccessful())
+	assert.Equal(t, int64(5), stats.Throttled)
+	assert.Equal(t, int64(16), stats.TargetInflight)
+}
+
+// recordingFeeder records the order in which operations arrive for each document ID, where each operation is
+// identified by its body. It fails the first attempt of each operation for which fail returns true, and holds each
+// request until gate is closed, if it is set.
+type recordingFeeder struct {
+	fail     func(body string) bool
+	gate     chan struct{}
+	attempts map[string]int
+	arrivals map[string][]string
+	mu       sync.Mutex
+}
+
+func (f *recordingFeeder) Send(doc Document) Result {
+	if f.gate != nil {
+		<-f.gate
+	}
+	body := string(doc.Body)
+	f.mu.Lock()
+	f.attempts[body]++
+	first := f.attempts[body] == 1
+	f.arrivals[doc.Id.String()] = append(f.arrivals[doc.Id.String()], body)
+	f.mu.Unlock()
+	// Vary latency, such that operations on different IDs complete out of order
+	time.Sleep(time.Duration(len(body)%3) * time.Millisecond)
+	if first && f.fail != nil && f.fail(body) {
+		return Result{Id: doc.Id, HTTPStatus: 429, Status: StatusVespaFailure}
+	}
+	return Result{Id: doc.Id, HTTPStatus: 200}
+}
+
+func newRecordingFeeder() *recordingFeeder {
+	return &recordingFeeder{attempts: make(map[string]int), arrivals: make(map[string][]string)}
+}
+
+func TestDispatcherOrderingPerId(t *testing.T) {
+	feeder := newRecordingFeeder()
+	// The first attempt of every third operation fails, and is retried while later operations on its ID are queued
+	feeder.fail = func(body string) bool { return len(body)%3 == 0 }
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	want := make(map[string][]string)
+	for op := range 5 {
+		for doc := range 20 {
+			id := fmt.Sprintf("id:ns:type::doc%d", doc)
+			body := fmt.Sprintf("%s-%s", id, strings.Repeat("x", op*doc))
+			dispatcher.Enqueue(Document{Id: mustParseId(id), Operation: OperationPut, Body: []byte(body)})
+			want[id] = append(want[id], body)
+			if feeder.fail(body) {
+				// Retries arrive right after the failed attempt
+				want[id] = append(want[id], body)
+			}
+		}
+	}
+	dispatcher.Close()
+	assert.Equal(t, want, feeder.arrivals)
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(100), stats.Operations)
+	assert.Equal(t, int64(0), stats.Unsuccessful()-stats.Throttled)
+	assert.GreaterOrEqual(t, stats.MaxQueueDepth, int64(1))
+	assert.LessOrEqual(t, stats.MaxQueueDepth, int64(5))
+}
+
+func TestDispatcherQueueDepth(t *testing.T) {
+	feeder := newRecordingFeeder()
+	feeder.gate = make(chan struct{})
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	for i, id := range []string{"id:ns:type::doc1", "id:ns:type::doc2", "id:ns:type::doc1", "id:ns:type::doc1", "id:ns:type::doc2"} {
+		dispatcher.Enqueue(Document{Id: mustParseId(id), Operation: OperationPut, Body: []byte(strconv.Itoa(i))})
+	}
+	assert.Equal(t, int64(3), dispatcher.Stats().MaxQueueDepth)
+	close(feeder.gate)
+	dispatcher.Close()
+	assert.Equal(t, map[string][]string{"id:ns:type::doc1": {"0", "2", "3"}, "id:ns:type::doc2": {"1", "4"}}, feeder.arrivals)
+	assert.Equal(t, int64(3), dispatcher.Stats().MaxQueueDepth)
+}
+
+// openingFeeder fails every request with a retryable error, and opens the circuit breaker when it does.
+type openingFeeder struct {
+	breaker   *mockCircuitBreaker
+	gate      chan struct{}
+	sendCount atomic.Int64
+}
+
+func (f *openingFeeder) Send(doc Document) Result {
+	<-f.gate
+	f.sendCount.Add(1)
+	f.breaker.state = CircuitOpen
+	return Result{Id: doc.Id, HTTPStatus: 503, Status: StatusVespaFailure}
+}
+
+func TestDispatcherRetryRefused(t *testing.T) {
+	breaker := &mockCircuitBreaker{}
+	feeder := &openingFeeder{breaker: breaker, gate: make(chan struct{})}
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	var output bytes.Buffer
+	dispatcher := NewDispatcher(feeder, throttler, breaker, &output, false)
+	id := mustParseId("id:ns:type::doc1")
+	assert.Nil(t, dispatcher.Enqueue(Document{Id: id, Operation: OperationPut}))
+	assert.Nil(t, dispatcher.Enqueue(Document{Id: id, Operation: OperationUpdate}))
+	close(feeder.gate)
+	// The retry of the put is refused, which fails it, and the queued update is refused too
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("dispatcher did not complete operations whose retry was refused")
+	}
+	assert.Equal(t, int64(1), feeder.sendCount.Load())
+	assert.Equal(t, map[int]int64{0: 1, 503: 1}, dispatcher.Stats().FailuresByCode)
+	assert.Contains(t, output.String(), "feed: could not retry put id:ns:type::doc1: refusing to enqueue document id:ns:type::doc1: too many errors\n")
+	assert.Contains(t, output.String(), "refusing to dispatch document id:ns:type::doc1: too many errors\n")
+}
+
+type timeoutFeeder struct {
+	sendCount  int
+	dispatched time.Time
+}
+
+func (f *timeoutFeeder) Send(doc Document) Result {
+	f.sendCount++
+	f.dispatched = doc.dispatched
+	return Result{Id: doc.Id, Err: ErrOperationTimeout, Status: StatusTransportFailure}
+}
+
+func TestDispatcherOperationTimeout(t *testing.T) {
+	feeder := &timeoutFeeder{}
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	// The operation timeout is counted from when the operation is dispatched, by the clock of the dispatcher
+	dispatchClock := &manualClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	dispatcher.SetNowFunc(dispatchClock.now)
+	dispatcher.Enqueue(Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationPut})
+	dispatcher.Close()
+	assert.Equal(t, 1, feeder.sendCount)
+	assert.Equal(t, dispatchClock.t, feeder.dispatched)
+	assert.Equal(t, int64(1), dispatcher.Stats().Errors)
+}
+
+// flakyHTTPClient simulates a server which is unavailable for every failEvery-th request it receives.
+type flakyHTTPClient struct {
+	failEvery int64
+	requests  atomic.Int64
+}
+
+func (c *flakyHTTPClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	status, body := 200, `{"message":"OK"}`
+	if c.requests.Add(1)%c.failEvery == 0 {
+		status, body = 503, `{"message":"overloaded"}`
+	}
+	return &http.Response{StatusCode: status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestDispatcherFlakyServer(t *testing.T) {
+	server := &flakyHTTPClient{failEvery: 5}
+	client, err := NewClient(ClientOptions{BaseURL: "https://example.com:1337"}, []httputil.Client{server})
+	assert.Nil(t, err)
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(1, clock.now)
+	breaker := NewCircuitBreaker(time.Minute, 0)
+	var output bytes.Buffer
+	dispatcher := NewDispatcher(client, throttler, breaker, &output, true)
+	for i := range 100 {
+		assert.Nil(t, dispatcher.Enqueue(Document{Id: mustParseId(fmt.Sprintf("id:ns:type::doc%d", i)), Operation: OperationPut, Body: []byte(`{"fields": {}}`)}))
+	}
+	dispatcher.Close()
+
+	// Every failed request is retried until it succeeds, so 24 of the 124 requests fail, and the last one succeeds
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(124), server.requests.Load())
+	assert.Equal(t, int64(100), stats.Operations)
+	assert.Equal(t, int64(124), stats.Requests)
+	assert.Equal(t, int64(124), stats.Responses)
+	assert.Equal(t, map[int]int64{200: 100, 503: 24}, stats.ResponsesByCode)
+	assert.Empty(t, stats.FailuresByCode)
+	assert.Equal(t, int64(0), stats.Errors)
+	assert.Equal(t, int64(24), stats.Throttled)
+	assert.Equal(t, int64(0), stats.Inflight)
+	// The window is cut when throttled, but not below the minimum of two operations per connection
+	assert.GreaterOrEqual(t, stats.TargetInflight, int64(2))
+	assert.Less(t, stats.TargetInflight, throttler.maxInflight)
+	assert.Equal(t, 24, strings.Count(output.String(), ": retrying\n"))
+	assert.NotContains(t, output.String(), "giving up")
+	assert.Equal(t, CircuitClosed, breaker.State())
 }
 
 func TestDispatcherOpenCircuit(t *testing.T) {
@@ -198,3 +405,85 @@ func BenchmarkDocumentDispatching(b *testing.B) {
 		dispatcher.inflightWg.Wait()
 	}
 }
+
+type failingIdFeeder struct{ id string }
+
+func (f *failingIdFeeder) Send(doc Document) Result {
+	if doc.Id.String() == f.id {
+		return Result{Id: doc.Id, HTTPStatus: 400, Status: StatusVespaFailure}
+	}
+	return Result{Id: doc.Id, HTTPStatus: 200}
+}
+
+func TestDispatcherCheckpoint(t *testing.T) {
+	feeder := &failingIdFeeder{id: "id:ns:type::doc3"}
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	checkpoint := NewCheckpoint(10)
+	for _, id := range []string{"id:ns:type::doc1", "id:ns:type::doc2", "id:ns:type::doc3", "id:ns:type::doc4"} {
+		doc := Document{Id: mustParseId(id), Operation: OperationPut}
+		checkpoint.Track(&doc)
+		dispatcher.Enqueue(doc)
+	}
+	dispatcher.Close()
+	// Third document fails permanently, which is recorded separately
+	assert.Equal(t, int64(14), checkpoint.Completed())
+	assert.Equal(t, []int64{12}, checkpoint.Failed())
+	assert.Equal(t, map[int]int64{400: 1}, dispatcher.Stats().FailuresByCode)
+}
+
+// slowFeeder is a feeder which takes a while to send each document, and tracks the total size of the bodies it holds.
+type slowFeeder struct {
+	delay   time.Duration
+	sent    int
+	held    int64
+	maxHeld int64
+	mu      sync.Mutex
+}
+
+func (f *slowFeeder) Send(doc Document) Result {
+	size := int64(len(doc.Body))
+	f.mu.Lock()
+	f.held += size
+	f.maxHeld = max(f.maxHeld, f.held)
+	f.mu.Unlock()
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.held -= size
+	f.sent++
+	return Result{Id: doc.Id, HTTPStatus: 200}
+}
+
+func TestDispatcherMemoryLimit(t *testing.T) {
+	feeder := &slowFeeder{delay: 10 * time.Millisecond}
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	const docSize = 10 << 20
+	const limit = 25 << 20
+	dispatcher.SetMemoryLimit(limit)
+	for i := range 20 {
+		body := make([]byte, docSize)
+		dispatcher.Enqueue(Document{Id: mustParseId(fmt.Sprintf("id:ns:type::doc%d", i)), Operation: OperationPut, Body: body})
+	}
+	dispatcher.Close()
+	stats := dispatcher.Stats()
+	assert.Equal(t, 20, feeder.sent)
+	assert.Equal(t, int64(20), stats.Operations)
+	assert.LessOrEqual(t, feeder.maxHeld, int64(limit))
+	assert.LessOrEqual(t, stats.PeakBufferedBytes, int64(limit))
+	assert.Equal(t, int64(2*docSize), stats.PeakBufferedBytes)
+
+	// A document larger than the limit is accepted when nothing else is held
+	dispatcher = NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	dispatcher.SetMemoryLimit(docSize / 2)
+	for i := range 2 {
+		dispatcher.Enqueue(Document{Id: mustParseId(fmt.Sprintf("id:ns:type::doc%d", i)), Operation: OperationPut, Body: make([]byte, docSize)})
+	}
+	dispatcher.Close()
+	assert.Equal(t, int64(docSize), dispatcher.Stats().PeakBufferedBytes)
+}
@@ -39,6 +39,7 @@ var (
 	ErrMissingId = errors.New("no id specified")
 	fieldsPrefix = []byte(`{"fields":`)
 	fieldsSuffix = []byte("}")
+	newline      = []byte("\n")
 )
 
 func (o Operation) String() string {
@@ -131,7 +132,13 @@ type Document struct {
 	Operation Operation
 	Create    bool
 
-	resetFunc func()
+	resetFunc  func()
+	checkpoint *Checkpoint
+	seq        int64
+	// mirrorSeq is the sequence number of this in a Mirror, if it is sent through one
+	mirrorSeq int64
+	// dispatched is the time this was first dispatched by a Dispatcher
+	dispatched time.Time
 }
 
 func (d Document) Equal(o Document) bool {
@@ -154,15 +161,35 @@ func (d *Document) Reset() {
 type Decoder struct {
 	dec *jsontext.Decoder
 	buf bytes.Buffer
+	// Input which has not yet been read by dec
+	r io.Reader
 
 	array bool
 	jsonl bool
 
 	fieldsEnd int64
+	// Total number of bytes written to buf
+	buffered int64
+	// Offset of the input read by dec, relative to the start of r
+	base int64
+	// Offset where the last decoded operation starts
+	lastStart int64
+	// Number of newlines in the input preceding the buffer
+	lines int64
+	// Line where the last decoded operation starts
+	lastLine int64
 
 	documentBuffers sync.Pool
 }
 
+// decoderBuffer records the input read by a decoder.
+type decoderBuffer struct{ d *Decoder }
+
+func (b decoderBuffer) Write(p []byte) (int, error) {
+	b.d.buffered += int64(len(p))
+	return b.d.buf.Write(p)
+}
+
 func (d Document) String() string {
 	var sb strings.Builder
 	sb.WriteString(d.Operation.String())
@@ -235,11 +262,103 @@ func (d *Decoder) readBool() (bool, error) {
 func (d *Decoder) Decode() (Document, error) {
 	doc, err := d.decode()
 	if err != nil && err != io.EOF {
-		return doc, fmt.Errorf("invalid operation at byte offset %d: %w", d.dec.InputOffset(), err)
+		return doc, fmt.Errorf("invalid operation at byte offset %d: %w", d.InputOffset(), err)
 	}
 	return doc, err
 }
 
+// InputOffset returns the number of bytes of input read by this decoder so far.
+func (d *Decoder) InputOffset() int64 { return d.base + d.dec.InputOffset() }
+
+// NextOffset returns the offset of the first non-whitespace input following the last value read by this decoder. After
+// Decode returns an error, this is where the invalid input starts.
+func (d *Decoder) NextOffset() int64 { return d.base + d.nextOffset() }
+
+// LastOffset returns the offset where the last operation decoded by this decoder starts.
+func (d *Decoder) LastOffset() int64 { return d.lastStart }
+
+// LastLine returns the line where the last operation decoded by this decoder starts, counting from 1.
+func (d *Decoder) LastLine() int64 { return d.lastLine }
+
+// NextLine returns the line of the offset returned by NextOffset, counting from 1.
+func (d *Decoder) NextLine() int64 { return d.lineAt(d.nextOffset()) }
+
+// lineAt returns the line at offset, relative to the input read by dec, which must not precede the buffer.
+func (d *Decoder) lineAt(offset int64) int64 {
+	bufStart := d.buffered - int64(d.buf.Len())
+	n := min(int64(d.buf.Len()), max(0, offset-bufStart))
+	return d.lines + 1 + int64(bytes.Count(d.buf.Bytes()[:n], newline))
+}
+
+// drop drops the next n bytes from the buffer, and returns them.
+func (d *Decoder) drop(n int) []byte {
+	b := d.buf.Next(n)
+	d.lines += int64(bytes.Count(b, newline))
+	return b
+}
+
+func (d *Decoder) nextOffset() int64 {
+	offset := d.dec.InputOffset()
+	bufStart := d.buffered - int64(d.buf.Len())
+	rest := d.buf.Bytes()[min(int64(d.buf.Len()), max(0, offset-bufStart)):]
+	for _, b := range rest {
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			break
+		}
+		offset++
+	}
+	return offset
+}
+
+// Skip discards the remainder of the line where decoding stopped, so that decoding can resume on the next line after
+// Decode returned an error. This is only possible for input in JSONL format.
+func (d *Decoder) Skip() error {
+	if d.array {
+		return fmt.Errorf("cannot skip invalid operation in a JSON array")
+	}
+	// Bytes read by dec which have not yet been dropped from the buffer start at this offset
+	bufStart := d.buffered - int64(d.buf.Len())
+	start := max(d.nextOffset(), bufStart)
+	rest := d.buf.Bytes()[min(int64(d.buf.Len()), start-bufStart):]
+	d.lines += int64(bytes.Count(d.buf.Bytes()[:int64(d.buf.Len())-int64(len(rest))], newline))
+	var (
+		skipped   int64
+		remaining []byte
+	)
+	if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+		skipped = start + int64(i+1)
+		remaining = bytes.Clone(rest[i+1:])
+		d.lines++
+	} else {
+		// Read past the end of the line
+		skipped = start + int64(len(rest))
+		chunk := make([]byte, 4096)
+		for {
+			n, err := d.r.Read(chunk)
+			if j := bytes.IndexByte(chunk[:n], '\n'); j >= 0 {
+				skipped += int64(j + 1)
+				remaining = bytes.Clone(chunk[j+1 : n])
+				d.lines++
+				break
+			}
+			skipped += int64(n)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	d.jsonl = true
+	d.base += skipped
+	d.buf.Reset()
+	d.buffered = 0
+	d.fieldsEnd = 0
+	d.r = io.MultiReader(bytes.NewReader(remaining), d.r)
+	d.dec = jsontext.NewDecoder(io.TeeReader(d.r, decoderBuffer{d}))
+	return nil
+}
+
 func (d *Decoder) buffer() *bytes.Buffer {
 	buf := d.documentBuffers.Get().(*bytes.Buffer)
 	buf.Reset()
@@ -270,13 +389,18 @@ func (d *Decoder) readField(name string, offset int64, doc *Document) error {
 			return err
 		}
 		doc.Create = create
+	case errorLogField:
+		// Operations read from an error log can be fed again
+		if err := d.dec.SkipValue(); err != nil {
+			return err
+		}
 	case "fields":
 		if _, err := d.readNext(jsonObjectStart); err != nil {
 			return err
 		}
 		// Skip data between start of operation and start of fields
 		fieldsStart := d.dec.InputOffset() - 1
-		d.buf.Next(int(fieldsStart - offset))
+		d.drop(int(fieldsStart - offset))
 		depth := 1
 		for depth > 0 {
 			t, err := d.dec.ReadToken()
@@ -291,7 +415,7 @@ func (d *Decoder) readField(name string, offset int64, doc *Document) error {
 			}
 		}
 		d.fieldsEnd = d.dec.InputOffset()
-		fields := d.buf.Next(int(d.fieldsEnd - fieldsStart))
+		fields := d.drop(int(d.fieldsEnd - fieldsStart))
 		// Try to re-use buffers holding the document body. The buffer is released by document.Reset()
 		bodyBuf := d.buffer()
 		bodyBuf.Grow(len(fieldsPrefix) + len(fields) + len(fieldsSuffix))
@@ -331,6 +455,8 @@ func (d *Decoder) decode() (Document, error) {
 	if _, err := d.readNext(jsonObjectStart); err != nil {
 		return Document{}, err
 	}
+	d.lastStart = d.base + d.dec.InputOffset() - 1
+	d.lastLine = d.lineAt(d.dec.InputOffset() - 1)
 	var doc Document
 loop:
 	for {
@@ -350,7 +476,7 @@ loop:
 			// Drop operation from the buffer
 			start = max(start, d.fieldsEnd)
 			end := d.dec.InputOffset()
-			d.buf.Next(int(end - start))
+			d.drop(int(end - start))
 			break loop
 		}
 	}
@@ -363,7 +489,8 @@ loop:
 func NewDecoder(r io.Reader) *Decoder {
 	d := &Decoder{}
 	d.documentBuffers.New = func() any { return &bytes.Buffer{} }
-	d.dec = jsontext.NewDecoder(io.TeeReader(r, &d.buf))
+	d.r = r
+	d.dec = jsontext.NewDecoder(io.TeeReader(r, decoderBuffer{d}))
 	return d
 }
 
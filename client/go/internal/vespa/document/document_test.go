@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -227,6 +229,100 @@ func TestDocumentDecoderInvalid(t *testing.T) {
 	}
 }
 
+func TestDocumentDecoderLines(t *testing.T) {
+	json := `[
+  {
+    "put": "id:ns:type::doc1",
+    "fields": {
+      "foo": "1"
+    }
+  },
+
+  {"remove": "id:ns:type::doc2"},
+  {"put": "id:ns:type::doc3", "fields": {
+    "foo": "3"}}, {"put": "id:ns:type::doc4"}
+]`
+	for _, r := range []io.Reader{strings.NewReader(json), iotest.OneByteReader(strings.NewReader(json))} {
+		dec := NewDecoder(r)
+		var lines []int64
+		for {
+			_, err := dec.Decode()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, dec.LastLine())
+		}
+		if want := []int64{2, 9, 10, 11}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("got operations on lines %v, want %v", lines, want)
+		}
+	}
+}
+
+func TestDocumentDecoderSkip(t *testing.T) {
+	jsonl := `{"put": "id:ns:type::doc1", "fields": {"foo": "1"}}
+{"put": "id:ns:type::doc2", "fields": {"foo": "2}}
+{"put": "invalid-id", "fields": {"foo": "3"}}
+{"remove": "id:ns:type::doc4"}
+garbage
+{"update": "id:ns:type::doc6", "fields": {"foo": {"assign": "6"}}}`
+	for _, r := range []io.Reader{strings.NewReader(jsonl), iotest.OneByteReader(strings.NewReader(jsonl))} {
+		dec := NewDecoder(r)
+		var ids []string
+		var offsets, starts, errorLines, startLines []int64
+		for {
+			doc, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				offsets = append(offsets, dec.NextOffset())
+				errorLines = append(errorLines, dec.NextLine())
+				if err := dec.Skip(); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			ids = append(ids, doc.Id.String())
+			starts = append(starts, dec.LastOffset())
+			startLines = append(startLines, dec.LastLine())
+		}
+		if want := []string{"id:ns:type::doc1", "id:ns:type::doc4", "id:ns:type::doc6"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got ids %v, want %v", ids, want)
+		}
+		lines := make([]int, 0, len(offsets))
+		for _, offset := range offsets {
+			lines = append(lines, strings.Count(jsonl[:offset], "\n")+1)
+		}
+		if want := []int{2, 3, 5}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("got errors on lines %v, want %v", lines, want)
+		}
+		if want := []int64{2, 3, 5}; !reflect.DeepEqual(errorLines, want) {
+			t.Errorf("got error lines %v, want %v", errorLines, want)
+		}
+		if want := []int64{1, 4, 6}; !reflect.DeepEqual(startLines, want) {
+			t.Errorf("got operation lines %v, want %v", startLines, want)
+		}
+		for i, start := range starts {
+			if jsonl[start] != '{' {
+				t.Errorf("operation %d starts with %q, want '{'", i, jsonl[start])
+			}
+		}
+		if got, want := strings.Count(jsonl[:starts[1]], "\n")+1, 4; got != want {
+			t.Errorf("got second operation on line %d, want %d", got, want)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader(`[{"put": "id:ns:type::doc1", "fields": {"foo": "1}}]`))
+	if _, err := dec.Decode(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := dec.Skip(); err == nil {
+		t.Error("expected error when skipping in array")
+	}
+}
+
 func benchmarkDocumentDecoder(b *testing.B, size int) {
 	b.Helper()
 	input := fmt.Sprintf(`{"put": "id:ns:type::doc1", "fields": {"foo": "%s"}}`, strings.Repeat("s", size))
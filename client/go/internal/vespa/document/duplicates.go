@@ -0,0 +1,114 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+const (
+	// bloomHashes is the number of bits set in the Bloom filter for each document ID. This gives a false positive rate
+	// of 1% at 9.6 bits per document ID, and 0.03% at 19.2 bits.
+	bloomHashes = 7
+	// exactEntryOverhead is the estimated memory used by an entry in the exact set, in addition to its document ID
+	exactEntryOverhead = 80
+)
+
+// Duplicate describes a document ID which was added to a DuplicateDetector before.
+type Duplicate[P any] struct {
+	// Previous is the position the ID was added at before, if Known
+	Previous P
+	// Known is whether the ID is known to be added before. Otherwise, the ID may be a false positive of the Bloom filter
+	Known bool
+}
+
+// DuplicateDetector detects document IDs which are added more than once, using a bounded amount of memory.
+//
+// Document IDs are kept in an exact set, along with the position they were added at, until half the memory is used by
+// this. Further document IDs are added to a Bloom filter, using the other half. An ID which the Bloom filter reports as
+// already added may be a false positive, and is kept in the exact set, if there is room, such that a later duplicate
+// of it is known.
+type DuplicateDetector[P any] struct {
+	maxBytes int64
+
+	exact      map[string]P
+	exactBytes int64
+
+	bloom     []uint64
+	seeds     [2]maphash.Seed
+	bloomBits uint64
+}
+
+// NewDuplicateDetector returns a detector using approximately maxBytes of memory. If maxBytes is 0 or less, memory is
+// unbounded, and all duplicates are known.
+func NewDuplicateDetector[P any](maxBytes int64) *DuplicateDetector[P] {
+	return &DuplicateDetector[P]{maxBytes: maxBytes, exact: make(map[string]P), seeds: [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()}}
+}
+
+// Add adds document ID id, at position pos, and returns the duplicate, and true, if id was added before.
+func (d *DuplicateDetector[P]) Add(id string, pos P) (Duplicate[P], bool) {
+	if prev, ok := d.exact[id]; ok {
+		return Duplicate[P]{Previous: prev, Known: true}, true
+	}
+	exactLimit := d.maxBytes / 2
+	if d.bloom != nil && d.bloomContains(id) {
+		// Keep flagged IDs exactly, in the quarter of the exact set reserved for these
+		if d.exactBytes < exactLimit {
+			d.addExact(id, pos)
+		}
+		return Duplicate[P]{}, true
+	}
+	if d.maxBytes <= 0 || d.exactBytes < exactLimit*3/4 {
+		d.addExact(id, pos)
+	} else {
+		d.bloomAdd(id)
+	}
+	return Duplicate[P]{}, false
+}
+
+func (d *DuplicateDetector[P]) addExact(id string, pos P) {
+	d.exact[id] = pos
+	d.exactBytes += int64(len(id)) + exactEntryOverhead
+}
+
+func (d *DuplicateDetector[P]) hashes(id string) (uint64, uint64) {
+	return maphash.String(d.seeds[0], id), maphash.String(d.seeds[1], id) | 1
+}
+
+func (d *DuplicateDetector[P]) bloomAdd(id string) {
+	if d.bloom == nil {
+		words := max(1, d.maxBytes/2/8)
+		d.bloom = make([]uint64, words)
+		d.bloomBits = uint64(words) * 64
+	}
+	h1, h2 := d.hashes(id)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % d.bloomBits
+		d.bloom[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (d *DuplicateDetector[P]) bloomContains(id string) bool {
+	h1, h2 := d.hashes(id)
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % d.bloomBits
+		if d.bloom[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FalsePositiveRate returns the estimated probability that a new document ID is reported as a duplicate which is not
+// known, based on the fraction of bits set in the Bloom filter. This is 0 while all document IDs are kept exactly.
+func (d *DuplicateDetector[P]) FalsePositiveRate() float64 {
+	if d.bloom == nil {
+		return 0
+	}
+	set := 0
+	for _, w := range d.bloom {
+		set += bits.OnesCount64(w)
+	}
+	return math.Pow(float64(set)/float64(d.bloomBits), bloomHashes)
+}
@@ -0,0 +1,60 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDuplicateDetectorExact(t *testing.T) {
+	d := NewDuplicateDetector[int](0)
+	if _, ok := d.Add("id:ns:type::doc1", 1); ok {
+		t.Error("doc1 reported as duplicate")
+	}
+	if _, ok := d.Add("id:ns:type::doc2", 2); ok {
+		t.Error("doc2 reported as duplicate")
+	}
+	dup, ok := d.Add("id:ns:type::doc1", 3)
+	if !ok || !dup.Known || dup.Previous != 1 {
+		t.Errorf("got duplicate %+v, %t, want known duplicate of position 1", dup, ok)
+	}
+	// The first position is kept
+	dup, _ = d.Add("id:ns:type::doc1", 4)
+	if dup.Previous != 1 {
+		t.Errorf("got previous position %d, want 1", dup.Previous)
+	}
+	if rate := d.FalsePositiveRate(); rate != 0 {
+		t.Errorf("got false positive rate %f, want 0", rate)
+	}
+}
+
+func TestDuplicateDetectorBloom(t *testing.T) {
+	n := 100000
+	// Room for about 100 exact entries, and 2.4 bytes per document ID in the Bloom filter
+	d := NewDuplicateDetector[int](2 * int64(n) * 12 / 10 * 2)
+	d.exactBytes = d.maxBytes/2*3/4 - 100*(exactEntryOverhead+20)
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if _, ok := d.Add("id:ns:type::"+strconv.Itoa(i), i); ok {
+			falsePositives++
+		}
+	}
+	if falsePositives > n/1000 {
+		t.Errorf("got %d false positives of %d, want at most %d", falsePositives, n, n/1000)
+	}
+	if rate := d.FalsePositiveRate(); rate <= 0 || rate > 0.001 {
+		t.Errorf("got estimated false positive rate %f, want in (0, 0.001]", rate)
+	}
+	// Exact entries are known duplicates, while those in the Bloom filter are not
+	if dup, ok := d.Add("id:ns:type::0", -1); !ok || !dup.Known || dup.Previous != 0 {
+		t.Errorf("got duplicate %+v, %t, want known duplicate of position 0", dup, ok)
+	}
+	last := "id:ns:type::" + strconv.Itoa(n-1)
+	if dup, ok := d.Add(last, -1); !ok || dup.Known {
+		t.Errorf("got duplicate %+v, %t, want unknown duplicate", dup, ok)
+	}
+	// A flagged ID is kept exactly, such that a later duplicate of it is known
+	if dup, ok := d.Add(last, -2); !ok || !dup.Known || dup.Previous != -1 {
+		t.Errorf("got duplicate %+v, %t, want known duplicate of position -1", dup, ok)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// errorLogField is the member of an operation written to an error log which describes why the operation failed. It is
+// ignored when decoding operations, so that an error log can be fed again.
+const errorLogField = "feedError"
+
+// ErrorLog writes operations which failed permanently to a file, as JSONL which can be decoded by Decoder and fed
+// again. Each operation is written with a member describing its failure. The file is created when the first failure is
+// written.
+type ErrorLog struct {
+	path string
+
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	count int64
+	err   error
+}
+
+// errorLogEntry describes the failure of an operation written to an ErrorLog.
+type errorLogEntry struct {
+	Status   int    `json:"status,omitempty"`
+	Message  string `json:"message"`
+	Attempts int    `json:"attempts"`
+}
+
+// NewErrorLog returns a new error log writing to the file at path.
+func NewErrorLog(path string) *ErrorLog { return &ErrorLog{path: path} }
+
+// Path returns the path of the file written by this.
+func (l *ErrorLog) Path() string { return l.path }
+
+// Count returns the number of operations written to this.
+func (l *ErrorLog) Count() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Write writes operation doc, which failed with result after given number of attempts, to this.
+func (l *ErrorLog) Write(doc Document, result Result, attempts int) error {
+	line, err := errorLogLine(doc, result, attempts)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if l.f == nil {
+		f, err := os.Create(l.path)
+		if err != nil {
+			l.err = err
+			return err
+		}
+		l.f = f
+		l.w = bufio.NewWriter(f)
+	}
+	if _, err := l.w.Write(line); err != nil {
+		l.err = err
+		return err
+	}
+	l.count++
+	return nil
+}
+
+// Close flushes and closes the file written by this, if it was created.
+func (l *ErrorLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return l.err
+	}
+	err := l.w.Flush()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	if l.err == nil {
+		l.err = err
+	}
+	return err
+}
+
+// errorLogLine returns the JSONL line holding doc, and the failure described by result and attempts.
+func errorLogLine(doc Document, result Result, attempts int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeMember(&buf, doc.Operation.String(), doc.Id.String())
+	if doc.Condition != "" {
+		buf.WriteString(",")
+		writeMember(&buf, "condition", doc.Condition)
+	}
+	if doc.Create {
+		buf.WriteString(`,"create":true`)
+	}
+	// The body holds an object with the fields of the operation, whose members are written as part of the operation
+	if body := bytes.TrimSpace(doc.Body); len(body) > 2 {
+		buf.WriteString(",")
+		buf.Write(bytes.TrimSpace(body[1 : len(body)-1]))
+	}
+	entry := errorLogEntry{Status: result.HTTPStatus, Message: failureMessage(result), Attempts: attempts}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(`,"` + errorLogField + `":`)
+	buf.Write(entryJSON)
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+func writeMember(buf *bytes.Buffer, name, value string) {
+	writeJSONString(buf, name)
+	buf.WriteString(":")
+	writeJSONString(buf, value)
+}
+
+// failureMessage returns a message describing why the operation with given result failed.
+func failureMessage(result Result) string {
+	if result.Err != nil {
+		return result.Err.Error()
+	}
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(result.Body, &response); err == nil && response.Message != "" {
+		return response.Message
+	}
+	if len(result.Body) > 0 {
+		return string(result.Body)
+	}
+	if result.HTTPStatus == 0 {
+		return "operation was not sent"
+	}
+	return "no body"
+}
@@ -0,0 +1,85 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type rejectingFeeder struct{}
+
+func (f *rejectingFeeder) Send(doc Document) Result {
+	if strings.HasPrefix(doc.Id.UserSpecific, "bad") {
+		return Result{Id: doc.Id, HTTPStatus: 400, Status: StatusVespaFailure, Body: []byte(`{"message":"invalid document"}`)}
+	}
+	return Result{Id: doc.Id, HTTPStatus: 200, Status: StatusSuccess}
+}
+
+func TestErrorLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failed.jsonl")
+	errorLog := NewErrorLog(path)
+	clock := &manualClock{tick: time.Second}
+	dispatcher := NewDispatcher(&rejectingFeeder{}, newThrottler(8, clock.now), NewCircuitBreaker(time.Second, 0), io.Discard, false)
+	dispatcher.SetErrorLog(errorLog)
+	var wantIds []string
+	for i := range 100 {
+		prefix := "good"
+		if i%2 == 0 {
+			prefix = "bad"
+			wantIds = append(wantIds, fmt.Sprintf("id:ns:type::bad%d", i))
+		}
+		id := mustParseId(fmt.Sprintf("id:ns:type::%s%d", prefix, i))
+		dispatcher.Enqueue(Document{Id: id, Operation: OperationPut, Body: []byte(`{"fields": {"foo": "123"}}`)})
+	}
+	dispatcher.Close()
+	require.Nil(t, errorLog.Close())
+	assert.Equal(t, int64(50), errorLog.Count())
+
+	// All failed operations can be decoded again
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	dec := NewDecoder(bytes.NewReader(data))
+	var ids []string
+	for {
+		doc, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.Nil(t, err)
+		assert.Equal(t, OperationPut, doc.Operation)
+		assert.Equal(t, `{"fields":{"foo": "123"}}`, string(doc.Body))
+		ids = append(ids, doc.Id.String())
+	}
+	sort.Strings(ids)
+	sort.Strings(wantIds)
+	assert.Equal(t, wantIds, ids)
+}
+
+func TestErrorLogLazy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failed.jsonl")
+	errorLog := NewErrorLog(path)
+	require.Nil(t, errorLog.Close())
+	assert.NoFileExists(t, path)
+
+	remove := Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationRemove, Condition: `type.foo=="bar"`}
+	require.Nil(t, errorLog.Write(remove, Result{}, 0))
+	require.Nil(t, errorLog.Write(remove, Result{Err: errors.New("connection reset")}, 10))
+	require.Nil(t, errorLog.Close())
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	want := `{"remove":"id:ns:type::doc1","condition":"type.foo==\"bar\"","feedError":{"message":"operation was not sent","attempts":0}}
+{"remove":"id:ns:type::doc1","condition":"type.foo==\"bar\"","feedError":{"message":"connection reset","attempts":10}}
+`
+	assert.Equal(t, want, string(data))
+}
@@ -0,0 +1,109 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// Number of linear sub-buckets per power of two. This bounds the relative error of a recorded value to 1/64.
+	histogramSubBuckets = 64
+	// Highest recordable value, in microseconds. Larger values are recorded as this value.
+	histogramMaxValue = 1<<40 - 1
+)
+
+// Histogram records durations in buckets of bounded relative error, similar to a HDR histogram. Memory usage is
+// constant, regardless of the number of recorded values.
+type Histogram struct {
+	counts []int64
+	count  int64
+	min    time.Duration
+	max    time.Duration
+}
+
+func histogramIndex(micros int64) int {
+	if micros < histogramSubBuckets {
+		return int(max(0, micros))
+	}
+	micros = min(micros, histogramMaxValue)
+	exp := bits.Len64(uint64(micros)) - 7 // log2(2 * histogramSubBuckets)
+	return exp*histogramSubBuckets + int(micros>>exp)
+}
+
+// histogramValue returns the value at the middle of the bucket at given index, in microseconds.
+func histogramValue(index int) int64 {
+	if index < 2*histogramSubBuckets {
+		return int64(index)
+	}
+	exp := index/histogramSubBuckets - 1
+	mantissa := int64(index - exp*histogramSubBuckets)
+	return mantissa<<exp + (1<<exp)/2
+}
+
+// Record adds the duration d to this histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]int64, histogramIndex(histogramMaxValue)+1)
+	}
+	h.counts[histogramIndex(d.Microseconds())]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() int64 { return h.count }
+
+// Percentile returns the approximate duration below which p percent of the recorded durations fall.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := max(1, int64(math.Ceil(p/100*float64(h.count))))
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			d := time.Duration(histogramValue(i)) * time.Microsecond
+			if h.max > 0 {
+				d = max(h.min, min(h.max, d))
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// Since returns a histogram of the durations recorded in h after the point where prev was copied from it. The returned
+// histogram has no knowledge of its exact minimum and maximum values.
+func (h *Histogram) Since(prev Histogram) Histogram {
+	var diff Histogram
+	if h.counts == nil {
+		return diff
+	}
+	diff.counts = make([]int64, len(h.counts))
+	for i, n := range h.counts {
+		if prev.counts != nil {
+			n -= prev.counts[i]
+		}
+		diff.counts[i] = n
+	}
+	diff.count = h.count - prev.count
+	return diff
+}
+
+// Clone returns a deep copy of this histogram.
+func (h Histogram) Clone() Histogram {
+	if h.counts != nil {
+		counts := make([]int64, len(h.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	return h
+}
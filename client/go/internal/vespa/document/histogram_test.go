@@ -0,0 +1,73 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	var h Histogram
+	if got := h.Percentile(50); got != 0 {
+		t.Errorf("got p50 = %s for empty histogram, want 0", got)
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	if got, want := h.Count(), int64(1000); got != want {
+		t.Errorf("got Count() = %d, want %d", got, want)
+	}
+	tests := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 500 * time.Millisecond},
+		{95, 950 * time.Millisecond},
+		{99, 990 * time.Millisecond},
+		{100, 1000 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.Percentile(tt.percentile)
+		if relErr := float64(got-tt.want) / float64(tt.want); relErr < -1.0/64 || relErr > 1.0/64 {
+			t.Errorf("got p%.0f = %s, want %s within 1/64", tt.percentile, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramIndex(t *testing.T) {
+	for _, micros := range []int64{0, 1, 63, 64, 127, 128, 1000, 123456789, histogramMaxValue} {
+		index := histogramIndex(micros)
+		value := histogramValue(index)
+		if relErr := float64(value-micros) / float64(max(1, micros)); relErr < -1.0/64 || relErr > 1.0/64 {
+			t.Errorf("got value %d for %d at index %d, want it within 1/64", value, micros, index)
+		}
+		if index > 0 && histogramIndex(micros-1) > index {
+			t.Errorf("index of %d is higher than index of %d", micros-1, micros)
+		}
+	}
+	if got, want := histogramIndex(histogramMaxValue+1), histogramIndex(histogramMaxValue); got != want {
+		t.Errorf("got index %d for too large value, want %d", got, want)
+	}
+}
+
+func TestHistogramSince(t *testing.T) {
+	var h Histogram
+	for range 10 {
+		h.Record(10 * time.Millisecond)
+	}
+	prev := h.Clone()
+	for range 10 {
+		h.Record(time.Second)
+	}
+	diff := h.Since(prev)
+	if got, want := diff.Count(), int64(10); got != want {
+		t.Errorf("got Count() = %d, want %d", got, want)
+	}
+	if got := diff.Percentile(1); got < 990*time.Millisecond || got > 1010*time.Millisecond {
+		t.Errorf("got p1 = %s, want approximately 1s", got)
+	}
+	if got, want := prev.Count(), int64(10); got != want {
+		t.Errorf("got Count() = %d of clone, want %d", got, want)
+	}
+}
@@ -3,7 +3,9 @@ package document
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -50,8 +52,24 @@ type ClientOptions struct {
 	Compression Compression
 	Speedtest   bool
 	NowFunc     func() time.Time
+	// Condition is the test-and-set condition of operations which do not specify their own condition.
+	Condition string
+	// Create sets create-if-nonexistent on all puts and updates.
+	Create bool
+	// Cluster is the content cluster to send operations to, or empty to let Vespa choose it.
+	Cluster string
+	// OperationTimeout bounds the total time of each operation, including any retries made by a Dispatcher. Each
+	// attempt is given the time remaining, both as its server-side timeout and as the deadline of its request. This
+	// replaces Timeout as the server-side timeout.
+	OperationTimeout time.Duration
 }
 
+// ErrCreateRemove is returned for remove operations when the client is configured to create documents.
+var ErrCreateRemove = errors.New("create-if-nonexistent cannot be used with remove")
+
+// ErrOperationTimeout is returned for operations which are not completed within the operation timeout.
+var ErrOperationTimeout = errors.New("operation timed out")
+
 type countingHTTPClient struct {
 	client   httputil.Client
 	inflight atomic.Int64
@@ -150,27 +168,39 @@ func (c *Client) methodAndURL(d Document, buf *bytes.Buffer) (string, string) {
 	c.writeDocumentPath(d.Id, buf)
 	// Query part
 	queryStart := buf.Len()
-	if c.options.Timeout > 0 {
-		writeQueryParam(buf, queryStart, false, "timeout", strconv.FormatInt(c.options.Timeout.Milliseconds(), 10)+"ms")
-	}
-	if c.options.Route != "" {
-		writeQueryParam(buf, queryStart, true, "route", c.options.Route)
+	if timeout := c.serverTimeout(d); timeout > 0 {
+		writeQueryParam(buf, queryStart, false, "timeout", strconv.FormatInt(timeout.Milliseconds(), 10)+"ms")
 	}
+	c.writeClusterParams(buf, queryStart)
 	if c.options.TraceLevel > 0 {
 		writeQueryParam(buf, queryStart, false, "tracelevel", strconv.Itoa(c.options.TraceLevel))
 	}
 	if c.options.Speedtest {
 		writeQueryParam(buf, queryStart, false, "dryRun", "true")
 	}
-	if d.Condition != "" {
-		writeQueryParam(buf, queryStart, true, "condition", d.Condition)
+	condition := d.Condition
+	if condition == "" {
+		condition = c.options.Condition
+	}
+	if condition != "" {
+		writeQueryParam(buf, queryStart, true, "condition", condition)
 	}
-	if d.Create {
+	if d.Create || (c.options.Create && d.Operation != OperationRemove) {
 		writeQueryParam(buf, queryStart, false, "create", "true")
 	}
 	return httpMethod, buf.String()
 }
 
+// writeClusterParams writes the query parameters choosing the route and content cluster of operations, if any.
+func (c *Client) writeClusterParams(buf *bytes.Buffer, queryStart int) {
+	if c.options.Route != "" {
+		writeQueryParam(buf, queryStart, true, "route", c.options.Route)
+	}
+	if c.options.Cluster != "" {
+		writeQueryParam(buf, queryStart, true, "cluster", c.options.Cluster)
+	}
+}
+
 func (c *Client) leastBusyClient() *countingHTTPClient {
 	leastBusy := c.httpClients[0]
 	min := int64(math.MaxInt64)
@@ -265,15 +295,49 @@ func (c *Client) clientTimeout() time.Duration {
 	return c.options.Timeout*11/10 + 1000 // slightly higher than the server-side timeout
 }
 
-// Send given document to the endpoint configured in this client.
+// remainingTime returns the time remaining of the operation timeout of document d.
+func (c *Client) remainingTime(d Document) time.Duration {
+	if d.dispatched.IsZero() {
+		return c.options.OperationTimeout
+	}
+	return c.options.OperationTimeout - c.now().Sub(d.dispatched)
+}
+
+// serverTimeout returns the server-side timeout of an attempt to send document d, or 0 for none.
+func (c *Client) serverTimeout(d Document) time.Duration {
+	if c.options.OperationTimeout > 0 {
+		// Whole milliseconds, and at least one, as in the timeout parameter
+		return max(c.remainingTime(d).Truncate(time.Millisecond), time.Millisecond)
+	}
+	return c.options.Timeout
+}
+
+// Send given document to the endpoint configured in this client. The request body, compressed or not, is prepared from
+// the document on every call, so that retrying a failed operation never sends a partially consumed body.
 func (c *Client) Send(document Document) Result {
 	start := c.now()
 	result := Result{Id: document.Id}
+	if c.options.Create && document.Operation == OperationRemove {
+		result = resultWithErr(result, ErrCreateRemove, 0)
+		result.Status = StatusInvalidOperation
+		return result
+	}
+	var remaining time.Duration
+	if c.options.OperationTimeout > 0 {
+		if remaining = c.remainingTime(document); remaining <= 0 {
+			return resultWithErr(result, ErrOperationTimeout, 0)
+		}
+	}
 	req, buf, err := c.prepare(document)
 	defer c.buffers.Put(buf)
 	if err != nil {
 		return resultWithErr(result, err, 0)
 	}
+	if remaining > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), remaining)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
 	bodySize := len(document.Body)
 	if buf.Len() > 0 {
 		bodySize = buf.Len()
@@ -284,7 +348,9 @@ func (c *Client) Send(document Document) Result {
 		return resultWithErr(result, err, elapsed)
 	}
 	defer resp.Body.Close()
-	return c.resultWithResponse(resp, bodySize, result, elapsed, buf, false)
+	result = c.resultWithResponse(resp, bodySize, result, elapsed, buf, false)
+	result.BytesUncompressed = int64(len(document.Body))
+	return result
 }
 
 // Get retrieves document with given ID.
@@ -293,8 +359,10 @@ func (c *Client) Get(id Id, fieldSet string) Result {
 	buf := c.buffer()
 	defer c.buffers.Put(buf)
 	c.writeDocumentPath(id, buf)
+	queryStart := buf.Len()
+	c.writeClusterParams(buf, queryStart)
 	if fieldSet != "" {
-		writeQueryParam(buf, buf.Len(), true, "fieldSet", fieldSet)
+		writeQueryParam(buf, queryStart, true, "fieldSet", fieldSet)
 	}
 	url := buf.String()
 	result := Result{Id: id}
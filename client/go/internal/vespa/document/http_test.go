@@ -3,13 +3,17 @@ package document
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/gzip"
+
 	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/mock"
 )
@@ -116,6 +120,7 @@ func TestClientSend(t *testing.T) {
 		res := client.Send(doc)
 		if res.Err == nil {
 			wantRes.BytesSent = int64(len(httpClient.LastBody))
+			wantRes.BytesUncompressed = int64(len(httpClient.LastBody))
 		}
 		if !reflect.DeepEqual(res, wantRes) {
 			t.Fatalf("#%d: got result %+v, want %+v", i, res, wantRes)
@@ -153,6 +158,11 @@ func TestClientSend(t *testing.T) {
 		MaxLatency:   time.Second,
 		BytesSent:    75,
 		BytesRecv:    89,
+
+		BytesUncompressed: 75,
+	}
+	for range 5 {
+		want.Latencies.Record(time.Second)
 	}
 	if !reflect.DeepEqual(want, stats) {
 		t.Errorf("got %+v, want %+v", stats, want)
@@ -200,6 +210,14 @@ func TestClientGet(t *testing.T) {
 	if gotURL != wantURL {
 		t.Errorf("got URL=%s, want %s", gotURL, wantURL)
 	}
+	client.options.Route = "music-route"
+	client.options.Cluster = "music"
+	client.Get(id, "[all]")
+	gotURL = httpClient.LastRequest.URL.String()
+	wantURL = "https://example.com:1337/document/v1/mynamespace/music/docid/doc1?route=music-route&cluster=music&fieldSet=%5Ball%5D"
+	if gotURL != wantURL {
+		t.Errorf("got URL=%s, want %s", gotURL, wantURL)
+	}
 }
 
 func TestClientSendCompressed(t *testing.T) {
@@ -250,6 +268,102 @@ func assertCompressedRequest(t *testing.T, want bool, result Result, client *moc
 	if compressed != want {
 		t.Errorf("got compressed=%t, want %t", compressed, want)
 	}
+	body := client.LastBody
+	if compressed {
+		body = gunzip(t, client.LastBody)
+	}
+	if result.BytesUncompressed != int64(len(body)) {
+		t.Errorf("got BytesUncompressed=%d, want %d", result.BytesUncompressed, len(body))
+	}
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestClientSendRemoveWithCreate(t *testing.T) {
+	httpClient := &mock.HTTPClient{}
+	client, _ := NewClient(ClientOptions{
+		BaseURL: "https://example.com:1337",
+		Create:  true,
+	}, []httputil.Client{httpClient})
+	result := client.Send(Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationRemove})
+	if result.Err != ErrCreateRemove || result.Status != StatusInvalidOperation {
+		t.Errorf("got result %+v, want error %q", result, ErrCreateRemove)
+	}
+	if len(httpClient.Requests) != 0 {
+		t.Errorf("got %d requests, want none", len(httpClient.Requests))
+	}
+}
+
+func TestClientSendOperationTimeout(t *testing.T) {
+	httpClient := &mock.HTTPClient{}
+	clock := &manualClock{t: time.Now()}
+	client, _ := NewClient(ClientOptions{
+		BaseURL:          "https://example.com:1337",
+		Timeout:          time.Minute,
+		OperationTimeout: 10 * time.Second,
+		NowFunc:          clock.now,
+	}, []httputil.Client{httpClient})
+	doc := Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationRemove, dispatched: clock.t.Add(-4 * time.Second)}
+	httpClient.NextResponseString(200, `{"message":"OK"}`)
+	result := client.Send(doc)
+	if !result.Success() {
+		t.Fatalf("got result %+v, want success", result)
+	}
+	req := httpClient.LastRequest
+	// The remaining time is measured by the clock of the client
+	if timeout := req.URL.Query().Get("timeout"); timeout != "6000ms" {
+		t.Errorf("got timeout=%s, want the remaining 6000ms", timeout)
+	}
+	if deadline, ok := req.Context().Deadline(); !ok || time.Until(deadline) > 6*time.Second {
+		t.Errorf("got deadline %v, want at most 6s from now", deadline)
+	}
+
+	clock.advance(6 * time.Second)
+	result = client.Send(doc)
+	if !errors.Is(result.Err, ErrOperationTimeout) {
+		t.Errorf("got error %v, want %v", result.Err, ErrOperationTimeout)
+	}
+	if len(httpClient.Requests) != 1 {
+		t.Errorf("got %d requests, want 1", len(httpClient.Requests))
+	}
+}
+
+func TestClientSendCompressedRetry(t *testing.T) {
+	httpClient := &mock.HTTPClient{ReadBody: true}
+	client, _ := NewClient(ClientOptions{
+		BaseURL:     "https://example.com:1337",
+		Compression: CompressionGzip,
+	}, []httputil.Client{httpClient})
+	doc := makeDocument(1000)
+
+	httpClient.NextResponseString(503, `{"message":"overloaded"}`)
+	result := client.Send(doc)
+	if result.HTTPStatus != 503 {
+		t.Fatalf("got HTTPStatus=%d, want 503", result.HTTPStatus)
+	}
+	firstBody := httpClient.LastBody
+	httpClient.NextResponseString(200, `{"message":"OK"}`)
+	result = client.Send(doc)
+	if !result.Success() {
+		t.Fatalf("got HTTPStatus=%d, want 200", result.HTTPStatus)
+	}
+	if !bytes.Equal(firstBody, httpClient.LastBody) {
+		t.Errorf("retried request has a different body")
+	}
+	if got := gunzip(t, httpClient.LastBody); !bytes.Equal(got, doc.Body) {
+		t.Errorf("got body %q, want %q", got, doc.Body)
+	}
 }
 
 func TestClientMethodAndURL(t *testing.T) {
@@ -291,6 +405,15 @@ func TestClientMethodAndURL(t *testing.T) {
 			"POST",
 			"https://example.com/document/v1/ns/type/docid/:?route=elsewhere",
 		},
+		{
+			Document{
+				Id:        mustParseId("id:ns:type::user"),
+				Operation: OperationRemove,
+			},
+			ClientOptions{Timeout: 10 * time.Second, Cluster: "books"},
+			"DELETE",
+			"https://example.com/document/v1/ns/type/docid/user?timeout=10000ms&cluster=books",
+		},
 		{
 			Document{
 				Id:        mustParseId("id:ns:type-with-/::user"),
@@ -320,6 +443,34 @@ func TestClientMethodAndURL(t *testing.T) {
 			"DELETE",
 			"https://example.com/document/v1/ns/type/docid/user",
 		},
+		{
+			Document{
+				Id:        mustParseId("id:ns:type::user"),
+				Operation: OperationUpdate,
+			},
+			ClientOptions{Condition: "type.foo == 'a&b'", Create: true},
+			"PUT",
+			"https://example.com/document/v1/ns/type/docid/user?condition=type.foo+%3D%3D+%27a%26b%27&create=true",
+		},
+		{
+			Document{
+				Id:        mustParseId("id:ns:type::user"),
+				Operation: OperationPut,
+				Condition: "type.bar",
+			},
+			ClientOptions{Condition: "type.foo"},
+			"POST",
+			"https://example.com/document/v1/ns/type/docid/user?condition=type.bar",
+		},
+		{
+			Document{
+				Id:        mustParseId("id:ns:type::user"),
+				Operation: OperationRemove,
+			},
+			ClientOptions{Condition: "type.foo"},
+			"DELETE",
+			"https://example.com/document/v1/ns/type/docid/user?condition=type.foo",
+		},
 	}
 	httpClient := mock.HTTPClient{}
 	client, _ := NewClient(ClientOptions{
@@ -328,8 +479,11 @@ func TestClientMethodAndURL(t *testing.T) {
 	for i, tt := range tests {
 		client.options.Timeout = tt.options.Timeout
 		client.options.Route = tt.options.Route
+		client.options.Cluster = tt.options.Cluster
 		client.options.TraceLevel = tt.options.TraceLevel
 		client.options.Speedtest = tt.options.Speedtest
+		client.options.Condition = tt.options.Condition
+		client.options.Create = tt.options.Create
 		method, url := client.methodAndURL(tt.in, &bytes.Buffer{})
 		if url != tt.url || method != tt.method {
 			t.Errorf("#%d: methodAndURL(doc) = (%s, %s), want (%s, %s)", i, method, url, tt.method, tt.url)
@@ -366,3 +520,15 @@ func BenchmarkClientSendMediumUncompressed(b *testing.B) {
 func BenchmarkClientSendMediumGzip(b *testing.B) {
 	benchmarkClientSend(b, CompressionGzip, makeDocument(1000))
 }
+
+func BenchmarkClientSendMediumAuto(b *testing.B) {
+	benchmarkClientSend(b, CompressionAuto, makeDocument(1000))
+}
+
+func BenchmarkClientSendLargeUncompressed(b *testing.B) {
+	benchmarkClientSend(b, CompressionNone, makeDocument(100000))
+}
+
+func BenchmarkClientSendLargeGzip(b *testing.B) {
+	benchmarkClientSend(b, CompressionGzip, makeDocument(100000))
+}
@@ -0,0 +1,100 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrIdGeneration is returned when the ID of a document cannot be generated.
+var ErrIdGeneration = errors.New("cannot generate document id")
+
+// IdGenerator generates the IDs of documents which do not specify one.
+type IdGenerator struct {
+	namespace string
+	docType   string
+	field     string
+	random    io.Reader
+}
+
+// NewIdGenerator creates a generator of IDs for documents of type docType in namespace. If field is non-empty, the
+// user-specified part of each ID is the value of that field in the document. Otherwise, it is a random UUID.
+func NewIdGenerator(namespace, docType, field string) (*IdGenerator, error) {
+	if namespace == "" || strings.Contains(namespace, ":") {
+		return nil, fmt.Errorf("invalid namespace: %q", namespace)
+	}
+	if docType == "" || strings.Contains(docType, ":") {
+		return nil, fmt.Errorf("invalid document type: %q", docType)
+	}
+	return &IdGenerator{namespace: namespace, docType: docType, field: field, random: rand.Reader}, nil
+}
+
+// Generate returns the ID of the document with given body, which holds the document fields on the form
+// {"fields":{...}}.
+func (g *IdGenerator) Generate(body []byte) (Id, error) {
+	var userSpecific string
+	if g.field == "" {
+		uuid, err := newUUID(g.random)
+		if err != nil {
+			return Id{}, fmt.Errorf("%w: %w", ErrIdGeneration, err)
+		}
+		userSpecific = uuid
+	} else {
+		value, err := g.fieldValue(body)
+		if err != nil {
+			return Id{}, fmt.Errorf("%w: %w", ErrIdGeneration, err)
+		}
+		userSpecific = value
+	}
+	return ParseId("id:" + g.namespace + ":" + g.docType + "::" + userSpecific)
+}
+
+// fieldValue returns the value of the ID field of this in body, as a string.
+func (g *IdGenerator) fieldValue(body []byte) (string, error) {
+	var doc struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", err
+		}
+	}
+	raw, ok := doc.Fields[g.field]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return "", fmt.Errorf("field %q is missing", g.field)
+	}
+	var value any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("field %q is empty", g.field)
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("field %q must be a string or a number, got %s", g.field, raw)
+}
+
+// newUUID returns a random (version 4) UUID read from random.
+func newUUID(random io.Reader) (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(random, b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
@@ -0,0 +1,75 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestIdGeneratorField(t *testing.T) {
+	g, err := NewIdGenerator("music", "song", "sku")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		body string
+		id   string
+		err  string
+	}{
+		{`{"fields":{"sku":"a1","title":"Foo"}}`, "id:music:song::a1", ""},
+		{`{"fields":{"sku":"a/b c?d:e"}}`, "id:music:song::a/b c?d:e", ""},
+		{`{"fields":{"sku":12.50}}`, "id:music:song::12.50", ""},
+		{`{"fields":{"title":"Foo"}}`, "", `cannot generate document id: field "sku" is missing`},
+		{``, "", `cannot generate document id: field "sku" is missing`},
+		{`{"fields":{"sku":null}}`, "", `cannot generate document id: field "sku" is missing`},
+		{`{"fields":{"sku":""}}`, "", `cannot generate document id: field "sku" is empty`},
+		{`{"fields":{"sku":["a"]}}`, "", `cannot generate document id: field "sku" must be a string or a number, got ["a"]`},
+	}
+	for i, tt := range tests {
+		id, err := g.Generate([]byte(tt.body))
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("#%d: got err = %v, want %q", i, err, tt.err)
+			}
+			if !errors.Is(err, ErrIdGeneration) {
+				t.Errorf("#%d: got err = %v, want %v", i, err, ErrIdGeneration)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %s", i, err)
+		} else if id.String() != tt.id {
+			t.Errorf("#%d: got id %s, want %s", i, id, tt.id)
+		}
+	}
+}
+
+func TestIdGeneratorUUID(t *testing.T) {
+	g, err := NewIdGenerator("music", "song", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.random = bytes.NewReader(bytes.Repeat([]byte{0xff}, 16))
+	id, err := g.Generate([]byte(`{"fields":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id:music:song::ffffffff-ffff-4fff-bfff-ffffffffffff"; id.String() != want {
+		t.Errorf("got id %s, want %s", id, want)
+	}
+	g, _ = NewIdGenerator("music", "song", "")
+	id1, _ := g.Generate(nil)
+	id2, _ := g.Generate(nil)
+	uuid := regexp.MustCompile(`^id:music:song::[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(id1.String()) || id1.String() == id2.String() {
+		t.Errorf("got ids %s and %s, want distinct random UUIDs", id1, id2)
+	}
+	if _, err := NewIdGenerator("", "song", ""); err == nil {
+		t.Error("want error for empty namespace")
+	}
+	if _, err := NewIdGenerator("music", "a:b", ""); err == nil {
+		t.Error("want error for invalid document type")
+	}
+}
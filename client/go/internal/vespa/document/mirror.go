@@ -0,0 +1,135 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// maxDivergences is the maximum number of diverging operations recorded by a Mirror.
+const maxDivergences = 100
+
+// Mirror enqueues each document operation with two dispatchers, which feed a primary and a mirror target
+// independently, and records the operations which succeed on one target but fail on the other. The mirror dispatcher
+// shares the memory limit of the primary one, such that an operation counts against the limit until both targets have
+// completed it.
+type Mirror struct {
+	primary *Dispatcher
+	mirror  *Dispatcher
+	strict  bool
+
+	mu      sync.Mutex
+	next    int64
+	pending map[int64]Result
+	stats   MirrorStats
+}
+
+// MirrorStats holds the outcome of the operations sent through a Mirror.
+type MirrorStats struct {
+	// Number of operations which succeeded on the primary target
+	PrimarySucceeded int64
+	// Number of operations which failed on the primary target
+	PrimaryFailed int64
+	// Number of operations which succeeded on the mirror target
+	MirrorSucceeded int64
+	// Number of operations which failed on the mirror target, including those which could not be enqueued
+	MirrorFailed int64
+	// Number of operations which succeeded on one target, but failed on the other
+	Diverged int64
+	// The first diverging operations, up to a maximum of 100
+	Divergences []Divergence
+}
+
+// Divergence is an operation which succeeded on one target of a Mirror, but failed on the other.
+type Divergence struct {
+	Id        Id
+	Operation Operation
+	Primary   Result
+	Mirror    Result
+}
+
+// NewMirror creates a mirror enqueueing operations with both primary and mirror. If strict is true, an operation which
+// cannot be enqueued with mirror fails Enqueue, otherwise it is counted as failed on the mirror target. This must be
+// called before any documents are enqueued with either dispatcher.
+func NewMirror(primary, mirror *Dispatcher, strict bool) *Mirror {
+	m := &Mirror{primary: primary, mirror: mirror, strict: strict, pending: make(map[int64]Result)}
+	mirror.memory = primary.memory
+	primary.completed = func(doc Document, result Result) { m.complete(doc, result, true) }
+	mirror.completed = func(doc Document, result Result) { m.complete(doc, result, false) }
+	return m
+}
+
+// Enqueue enqueues doc with the primary dispatcher, and a copy of it with the mirror dispatcher.
+func (m *Mirror) Enqueue(doc Document) error {
+	m.mu.Lock()
+	m.next++
+	seq := m.next
+	m.mu.Unlock()
+	// The body of doc may be reused once the primary dispatcher completes it, so the mirror gets its own copy
+	mirrored := Document{Id: doc.Id, Condition: doc.Condition, Body: bytes.Clone(doc.Body), Operation: doc.Operation, Create: doc.Create, mirrorSeq: seq}
+	doc.mirrorSeq = seq
+	if err := m.primary.Enqueue(doc); err != nil {
+		return err
+	}
+	if err := m.mirror.Enqueue(mirrored); err != nil {
+		m.complete(mirrored, Result{Id: mirrored.Id, Err: err, Status: StatusTransportFailure}, false)
+		if m.strict {
+			return fmt.Errorf("could not mirror %s %s: %w", mirrored.Operation, mirrored.Id, err)
+		}
+	}
+	return nil
+}
+
+func (m *Mirror) complete(doc Document, result Result, primary bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case primary && result.Success():
+		m.stats.PrimarySucceeded++
+	case primary:
+		m.stats.PrimaryFailed++
+	case result.Success():
+		m.stats.MirrorSucceeded++
+	default:
+		m.stats.MirrorFailed++
+	}
+	other, ok := m.pending[doc.mirrorSeq]
+	if !ok {
+		m.pending[doc.mirrorSeq] = result
+		return
+	}
+	delete(m.pending, doc.mirrorSeq)
+	if result.Success() == other.Success() {
+		return
+	}
+	m.stats.Diverged++
+	if len(m.stats.Divergences) < maxDivergences {
+		divergence := Divergence{Id: doc.Id, Operation: doc.Operation, Primary: other, Mirror: result}
+		if primary {
+			divergence.Primary, divergence.Mirror = result, other
+		}
+		m.stats.Divergences = append(m.stats.Divergences, divergence)
+	}
+}
+
+// Stats returns the statistics of the primary dispatcher.
+func (m *Mirror) Stats() Stats { return m.primary.Stats() }
+
+// MirrorStats returns the outcome of the operations completed by both targets so far.
+func (m *Mirror) MirrorStats() MirrorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Divergences = slices.Clone(m.stats.Divergences)
+	return stats
+}
+
+// Close waits for all operations to complete on both targets, and closes the dispatchers.
+func (m *Mirror) Close() error {
+	if err := m.primary.Close(); err != nil {
+		return err
+	}
+	return m.mirror.Close()
+}
@@ -0,0 +1,89 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDispatcher(feeder Feeder, breaker CircuitBreaker) *Dispatcher {
+	clock := &manualClock{tick: time.Second}
+	return NewDispatcher(feeder, newThrottler(8, clock.now), breaker, io.Discard, false)
+}
+
+func TestMirror(t *testing.T) {
+	primaryFeeder := &failingIdFeeder{id: "id:ns:type::doc2"}
+	mirrorFeeder := &failingIdFeeder{id: "id:ns:type::doc3"}
+	primary := newTestDispatcher(primaryFeeder, NewCircuitBreaker(time.Second, 0))
+	mirror := newTestDispatcher(mirrorFeeder, NewCircuitBreaker(time.Second, 0))
+	m := NewMirror(primary, mirror, false)
+	for i := range 4 {
+		require.Nil(t, m.Enqueue(Document{Id: mustParseId(fmt.Sprintf("id:ns:type::doc%d", i)), Operation: OperationPut, Body: []byte(`{"fields":{}}`)}))
+	}
+	require.Nil(t, m.Close())
+	stats := m.MirrorStats()
+	assert.Equal(t, int64(3), stats.PrimarySucceeded)
+	assert.Equal(t, int64(1), stats.PrimaryFailed)
+	assert.Equal(t, int64(3), stats.MirrorSucceeded)
+	assert.Equal(t, int64(1), stats.MirrorFailed)
+	assert.Equal(t, int64(2), stats.Diverged)
+	require.Len(t, stats.Divergences, 2)
+	byId := make(map[string]Divergence)
+	for _, d := range stats.Divergences {
+		byId[d.Id.String()] = d
+	}
+	assert.Equal(t, 400, byId["id:ns:type::doc2"].Primary.HTTPStatus)
+	assert.Equal(t, 200, byId["id:ns:type::doc2"].Mirror.HTTPStatus)
+	assert.Equal(t, 200, byId["id:ns:type::doc3"].Primary.HTTPStatus)
+	assert.Equal(t, 400, byId["id:ns:type::doc3"].Mirror.HTTPStatus)
+	assert.Equal(t, int64(4), m.Stats().Operations)
+	assert.Equal(t, int64(4), mirror.Stats().Operations)
+}
+
+func TestMirrorRefused(t *testing.T) {
+	primary := newTestDispatcher(&mockFeeder{}, NewCircuitBreaker(time.Second, 0))
+	mirror := newTestDispatcher(&mockFeeder{}, &mockCircuitBreaker{state: CircuitOpen})
+	m := NewMirror(primary, mirror, false)
+	doc := Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationPut}
+	assert.Nil(t, m.Enqueue(doc))
+	require.Nil(t, m.Close())
+	stats := m.MirrorStats()
+	assert.Equal(t, int64(1), stats.PrimarySucceeded)
+	assert.Equal(t, int64(1), stats.MirrorFailed)
+	assert.Equal(t, int64(1), stats.Diverged)
+
+	// A strict mirror fails the operation
+	primary = newTestDispatcher(&mockFeeder{}, NewCircuitBreaker(time.Second, 0))
+	mirror = newTestDispatcher(&mockFeeder{}, &mockCircuitBreaker{state: CircuitOpen})
+	m = NewMirror(primary, mirror, true)
+	assert.ErrorIs(t, m.Enqueue(doc), ErrTooManyErrors)
+	require.Nil(t, m.Close())
+}
+
+func TestMirrorMemoryLimit(t *testing.T) {
+	primaryFeeder := &slowFeeder{}
+	mirrorFeeder := &slowFeeder{delay: 10 * time.Millisecond}
+	primary := newTestDispatcher(primaryFeeder, NewCircuitBreaker(time.Second, 0))
+	mirror := newTestDispatcher(mirrorFeeder, NewCircuitBreaker(time.Second, 0))
+	const docSize = 10 << 20
+	const limit = 45 << 20
+	primary.SetMemoryLimit(limit)
+	m := NewMirror(primary, mirror, false)
+	for i := range 10 {
+		body := make([]byte, docSize)
+		require.Nil(t, m.Enqueue(Document{Id: mustParseId(fmt.Sprintf("id:ns:type::doc%d", i)), Operation: OperationPut, Body: body}))
+	}
+	require.Nil(t, m.Close())
+	assert.Equal(t, 10, primaryFeeder.sent)
+	assert.Equal(t, 10, mirrorFeeder.sent)
+	// Operations are held until the slower mirror completes them, and both copies count against the limit
+	assert.LessOrEqual(t, primaryFeeder.maxHeld, int64(limit))
+	assert.LessOrEqual(t, mirrorFeeder.maxHeld, int64(limit))
+	assert.LessOrEqual(t, m.Stats().PeakBufferedBytes, int64(limit))
+	assert.Equal(t, m.Stats().PeakBufferedBytes, mirror.Stats().PeakBufferedBytes)
+}
@@ -18,6 +18,8 @@ func (q *Queue[T]) Add(item T, front bool) {
 	}
 }
 
+func (q *Queue[T]) Len() int { return q.items.Len() }
+
 func (q *Queue[T]) Poll() (T, bool) {
 	front := q.items.Front()
 	if front == nil {
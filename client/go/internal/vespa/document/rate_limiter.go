@@ -0,0 +1,102 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiterBurst is the duration of traffic a rate limiter allows to be sent at once.
+const rateLimiterBurst = 100 * time.Millisecond
+
+// RateLimiter limits the rate of requests sent by a Dispatcher, as a number of operations per second, and a number of
+// bytes per second. Each attempt of an operation counts against both limits, so retries are also limited.
+type RateLimiter struct {
+	ops   *tokenBucket
+	bytes *tokenBucket
+
+	waited atomic.Int64
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// tokenBucket holds tokens which are replenished at a fixed rate, up to a burst size. Tokens may be taken before they
+// are available, in which case the taker must wait until the bucket is no longer in debt.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewRateLimiter returns a rate limiter sending at most opsPerSecond operations, and at most bytesPerSecond bytes of
+// operation bodies, per second. A zero limit is unlimited. If both limits are zero, nil is returned, which is a valid,
+// unlimited rate limiter.
+func NewRateLimiter(opsPerSecond float64, bytesPerSecond int64) *RateLimiter {
+	return newRateLimiter(opsPerSecond, bytesPerSecond, time.Now, time.Sleep)
+}
+
+func newRateLimiter(opsPerSecond float64, bytesPerSecond int64, nowFunc func() time.Time, sleepFunc func(time.Duration)) *RateLimiter {
+	if opsPerSecond <= 0 && bytesPerSecond <= 0 {
+		return nil
+	}
+	now := nowFunc()
+	return &RateLimiter{
+		ops:   newTokenBucket(opsPerSecond, 1, now),
+		bytes: newTokenBucket(float64(bytesPerSecond), 1, now),
+		now:   nowFunc,
+		sleep: sleepFunc,
+	}
+}
+
+// newTokenBucket returns a full bucket replenished at rate tokens per second, or nil if rate is not positive. The
+// burst size is the number of tokens replenished during rateLimiterBurst, but at least minBurst.
+func newTokenBucket(rate float64, minBurst float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(minBurst, rate*rateLimiterBurst.Seconds())
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// take takes n tokens from this, and returns the time to wait until they are available.
+func (b *tokenBucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Wait blocks until doc may be sent without exceeding the limits of this.
+func (l *RateLimiter) Wait(doc Document) {
+	if l == nil {
+		return
+	}
+	now := l.now()
+	wait := max(l.ops.take(1, now), l.bytes.take(float64(len(doc.Body)), now))
+	if wait > 0 {
+		l.waited.Add(int64(wait))
+		l.sleep(wait)
+	}
+}
+
+// Waited returns the total time operations have waited for this rate limiter.
+func (l *RateLimiter) Waited() time.Duration {
+	if l == nil {
+		return 0
+	}
+	return time.Duration(l.waited.Load())
+}
@@ -0,0 +1,59 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0, 0))
+	var unlimited *RateLimiter
+	unlimited.Wait(Document{})
+	assert.Equal(t, time.Duration(0), unlimited.Waited())
+
+	now := time.Unix(0, 0)
+	var sleeps []time.Duration
+	limiter := newRateLimiter(20, 0, func() time.Time { return now }, func(d time.Duration) { sleeps = append(sleeps, d) })
+	doc := Document{Body: []byte("0123456789")}
+	for i := 0; i < 4; i++ {
+		limiter.Wait(doc) // The first two are within the burst of 100 ms
+	}
+	assert.Equal(t, []time.Duration{50 * time.Millisecond, 100 * time.Millisecond}, sleeps)
+	now = now.Add(time.Second) // Refills the burst only
+	sleeps = nil
+	for i := 0; i < 3; i++ {
+		limiter.Wait(doc)
+	}
+	assert.Equal(t, []time.Duration{50 * time.Millisecond}, sleeps)
+	assert.Equal(t, 200*time.Millisecond, limiter.Waited())
+
+	// The lower of the limits wins
+	now = time.Unix(0, 0)
+	sleeps = nil
+	limiter = newRateLimiter(1000, 100, func() time.Time { return now }, func(d time.Duration) { sleeps = append(sleeps, d) })
+	limiter.Wait(doc) // Burst of bytes is 10
+	limiter.Wait(doc)
+	limiter.Wait(Document{Body: make([]byte, 30)})
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 400 * time.Millisecond}, sleeps)
+}
+
+func TestDispatcherRateLimit(t *testing.T) {
+	feeder := &mockFeeder{}
+	feeder.failN(2)
+	clock := &manualClock{tick: time.Second}
+	throttler := newThrottler(8, clock.now)
+	breaker := NewCircuitBreaker(time.Second, 0)
+	dispatcher := NewDispatcher(feeder, throttler, breaker, io.Discard, false)
+	now := time.Now()
+	dispatcher.SetRateLimiter(newRateLimiter(10, 0, func() time.Time { return now }, func(time.Duration) {}))
+	dispatcher.Enqueue(Document{Id: mustParseId("id:ns:type::doc1"), Operation: OperationPut})
+	dispatcher.Close()
+	stats := dispatcher.Stats()
+	assert.Equal(t, int64(3), stats.Requests)
+	// Retries count against the limit: 3 requests with a burst of 1 wait 100 ms and 200 ms
+	assert.Equal(t, 300*time.Millisecond, stats.RateLimited)
+}
@@ -0,0 +1,319 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLOptions configures the reading of put operations from the rows of a SQL query.
+type SQLOptions struct {
+	// Context is the context queries are run with. Cancelling it cancels the query being run. If nil, the background
+	// context is used
+	Context context.Context
+	// Query is the query whose rows become put operations
+	Query string
+	// IdTemplate creates the document ID of each row, referring to columns by name, e.g. "id:ns:type::{sku}"
+	IdTemplate string
+	// OrderColumn is a column with unique values, which rows are read in order of, in pages of BatchSize rows. Reading
+	// resumes after the last row read when the query fails with a retryable error. If empty, all rows are read by a
+	// single query, which is retried only if it fails before any row is read
+	OrderColumn string
+	// BatchSize is the number of rows read by each query when OrderColumn is set
+	BatchSize int
+	// Placeholder is the placeholder of a query parameter, e.g. "$1" for PostgreSQL or "?" for MySQL
+	Placeholder string
+	// Retries is the number of times in a row a query failing with a retryable error is retried, before the error is
+	// returned
+	Retries int
+	// Retryable returns whether err is retryable, e.g. because the connection to the database was lost. If nil, no
+	// error is retryable
+	Retryable func(err error) bool
+	// Backoff is the time waited before the first retry, which grows with each retry
+	Backoff time.Duration
+	// OnRetry is called, if non-nil, before retrying a query which failed with err, after given number of rows were
+	// read, with the number of consecutive retries
+	OnRetry func(err error, rows int64, attempt int)
+}
+
+// SQLDecoder decodes put operations from the rows of a SQL query, like CSVDecoder decodes them from CSV records, where
+// the columns of the query take the place of the header. Numbers and booleans are written as such, as are strings
+// which are valid JSON numbers. Other values are written as strings, while NULL and empty values are omitted.
+type SQLDecoder struct {
+	db      *sql.DB
+	options SQLOptions
+
+	rows       *sql.Rows
+	header     []string
+	ids        *idTemplate
+	orderIndex int
+	values     []any
+	lastKey    any
+	pageRows   int
+	rowsRead   int64
+	failures   int // Consecutive failures of queries
+	retries    int
+	done       bool
+}
+
+// NewSQLDecoder creates a decoder of the rows of the query given in options, run against db.
+func NewSQLDecoder(db *sql.DB, options SQLOptions) (*SQLDecoder, error) {
+	options.Query = strings.TrimRight(strings.TrimSpace(options.Query), ";")
+	if options.Query == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+	if options.OrderColumn != "" {
+		if !sqlIdentifier.MatchString(options.OrderColumn) {
+			return nil, fmt.Errorf("invalid order column %q: must be a column name of letters, digits and underscores", options.OrderColumn)
+		}
+		if options.BatchSize < 1 {
+			return nil, fmt.Errorf("invalid batch size: %d", options.BatchSize)
+		}
+		if options.Placeholder == "" {
+			options.Placeholder = "?"
+		}
+	}
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	return &SQLDecoder{db: db, options: options, orderIndex: -1}, nil
+}
+
+// RowsRead returns the number of rows read so far.
+func (d *SQLDecoder) RowsRead() int64 { return d.rowsRead }
+
+// Retries returns the number of times a query was retried.
+func (d *SQLDecoder) Retries() int { return d.retries }
+
+// Decode returns the put operation of the next row.
+func (d *SQLDecoder) Decode() (Document, error) {
+	for {
+		if d.done {
+			return Document{}, io.EOF
+		}
+		if d.rows == nil {
+			if err := d.query(); err != nil {
+				if d.done {
+					return Document{}, err // The columns of the query cannot be fed
+				}
+				if d.retry(err) {
+					continue
+				}
+				return Document{}, d.queryError(err)
+			}
+		}
+		if d.rows.Next() {
+			if err := d.rows.Scan(d.values...); err != nil {
+				return Document{}, fmt.Errorf("invalid row %d: %w", d.rowsRead+1, err)
+			}
+			d.pageRows++
+			d.rowsRead++
+			d.failures = 0
+			doc, err := d.decode()
+			if err != nil {
+				return Document{}, fmt.Errorf("invalid row %d: %w", d.rowsRead, err)
+			}
+			return doc, nil
+		}
+		err := d.rows.Err()
+		d.rows.Close()
+		d.rows = nil
+		if err != nil {
+			if d.retry(err) {
+				continue
+			}
+			return Document{}, d.queryError(err)
+		}
+		if d.options.OrderColumn == "" || d.pageRows < d.options.BatchSize {
+			d.done = true
+		}
+	}
+}
+
+// Close closes the rows of the query in progress, if any.
+func (d *SQLDecoder) Close() error {
+	if d.rows != nil {
+		return d.rows.Close()
+	}
+	return nil
+}
+
+func (d *SQLDecoder) query() error {
+	query, args := d.options.Query, []any(nil)
+	if column := d.options.OrderColumn; column != "" {
+		query = "SELECT * FROM (" + query + ") AS vespa_feed_rows"
+		if d.lastKey != nil {
+			query += " WHERE " + column + " > " + d.options.Placeholder
+			args = append(args, d.lastKey)
+		}
+		query += " ORDER BY " + column + " LIMIT " + strconv.Itoa(d.options.BatchSize)
+	}
+	rows, err := d.db.QueryContext(d.options.Context, query, args...)
+	if err != nil {
+		return err
+	}
+	if d.header == nil {
+		if err := d.readColumns(rows); err != nil {
+			rows.Close()
+			d.done = true
+			return err
+		}
+	}
+	d.rows = rows
+	d.pageRows = 0
+	return nil
+}
+
+func (d *SQLDecoder) readColumns(rows *sql.Rows) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	names, indices, err := columnNames(columns)
+	if err != nil {
+		return fmt.Errorf("invalid columns: %w", err)
+	}
+	ids, err := newIdTemplate(d.options.IdTemplate, indices)
+	if err != nil {
+		return err
+	}
+	if d.options.OrderColumn != "" {
+		i, ok := indices[d.options.OrderColumn]
+		if !ok {
+			return fmt.Errorf("order column %q is not a column of the query", d.options.OrderColumn)
+		}
+		d.orderIndex = i
+	}
+	d.header = names
+	d.ids = ids
+	d.values = make([]any, len(names))
+	for i := range d.values {
+		d.values[i] = new(any)
+	}
+	return nil
+}
+
+// retry returns whether the query should be run again after failing with err, and waits before it should.
+func (d *SQLDecoder) retry(err error) bool {
+	if d.done || d.options.Retryable == nil || !d.options.Retryable(err) || d.failures >= d.options.Retries {
+		return false
+	}
+	if d.options.Context.Err() != nil {
+		return false // Cancelled
+	}
+	if d.options.OrderColumn == "" && d.rowsRead > 0 {
+		return false // Rows would be read again
+	}
+	d.failures++
+	d.retries++
+	if d.options.OnRetry != nil {
+		d.options.OnRetry(err, d.rowsRead, d.failures)
+	}
+	backoff := time.NewTimer(d.options.Backoff * time.Duration(d.failures))
+	defer backoff.Stop()
+	select {
+	case <-backoff.C:
+		return true
+	case <-d.options.Context.Done():
+		return false // Cancelled while waiting
+	}
+}
+
+func (d *SQLDecoder) queryError(err error) error {
+	if d.rowsRead > 0 {
+		return fmt.Errorf("query failed after %d rows: %w", d.rowsRead, err)
+	}
+	return fmt.Errorf("query failed: %w", err)
+}
+
+func (d *SQLDecoder) decode() (Document, error) {
+	if d.orderIndex >= 0 {
+		key := *d.values[d.orderIndex].(*any)
+		if key == nil {
+			return Document{}, fmt.Errorf("order column %q is NULL", d.options.OrderColumn)
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b) // Compare as text, not as binary data
+		}
+		d.lastKey = key
+	}
+	docId, err := d.ids.id(func(column int) string { return sqlString(*d.values[column].(*any)) })
+	if err != nil {
+		return Document{}, err
+	}
+	var body bytes.Buffer
+	body.WriteString(`{"fields":{`)
+	first := true
+	for i, name := range d.header {
+		value := *d.values[i].(*any)
+		if d.ids.refers(name) || value == nil {
+			continue
+		}
+		var field bytes.Buffer
+		if err := writeSQLValue(&field, value); err != nil {
+			return Document{}, fmt.Errorf("column %q: %w", name, err)
+		}
+		if field.Len() == 0 {
+			continue
+		}
+		if !first {
+			body.WriteString(",")
+		}
+		first = false
+		writeJSONString(&body, name)
+		body.WriteString(":")
+		body.Write(field.Bytes())
+	}
+	body.WriteString("}}")
+	return Document{Id: docId, Operation: OperationPut, Body: body.Bytes()}, nil
+}
+
+// writeSQLValue writes the JSON of a value scanned from a SQL row to buf, or nothing if the value is empty.
+func writeSQLValue(buf *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%v is not a valid JSON number", v)
+		}
+		buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	default:
+		if s := sqlString(v); s != "" {
+			writeInferredValue(buf, s)
+		}
+	}
+	return nil
+}
+
+// sqlString returns the string form of a value scanned from a SQL row.
+func sqlString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
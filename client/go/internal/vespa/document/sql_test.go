@@ -0,0 +1,300 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDriver serves queries by the handler registered under the data source name.
+type fakeDriver struct {
+	mu       sync.Mutex
+	handlers map[string]func(query string, args []driver.Value) (driver.Rows, error)
+}
+
+var testDriver = &fakeDriver{handlers: make(map[string]func(string, []driver.Value) (driver.Rows, error))}
+
+func init() { sql.Register("fake", testDriver) }
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &fakeConn{handler: d.handlers[name]}, nil
+}
+
+type fakeConn struct {
+	handler func(query string, args []driver.Value) (driver.Rows, error)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c *fakeConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.handler(query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	err     error // Returned after all values
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func openFakeDB(t *testing.T, handler func(query string, args []driver.Value) (driver.Rows, error)) *sql.DB {
+	testDriver.mu.Lock()
+	testDriver.handlers[t.Name()] = handler
+	testDriver.mu.Unlock()
+	db, err := sql.Open("fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var errLostConnection = errors.New("lost connection")
+
+func TestSQLDecoder(t *testing.T) {
+	db := openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+		if query != "select * from songs" {
+			t.Errorf("got query %q", query)
+		}
+		return &fakeRows{
+			columns: []string{"sku", "title", "price", "zip", "available", "notes", "added"},
+			values: [][]driver.Value{
+				{int64(1), []byte(`Hello, "world"`), []byte("12.50"), "00123", true, nil, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+				{int64(2), "Plain", 0.25, "", false, []byte("1e3"), nil},
+			},
+		}, nil
+	})
+	dec, err := NewSQLDecoder(db, SQLOptions{Query: "select * from songs;", IdTemplate: "id:music:song::{sku}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Document{
+		{Id: mustParseId("id:music:song::1"), Operation: OperationPut, Body: []byte(`{"fields":{"title":"Hello, \"world\"","price":12.50,"zip":"00123","available":true,"added":"2024-01-02T03:04:05Z"}}`)},
+		{Id: mustParseId("id:music:song::2"), Operation: OperationPut, Body: []byte(`{"fields":{"title":"Plain","price":0.25,"available":false,"notes":1e3}}`)},
+	}
+	for i, w := range want {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %s", i, err)
+		}
+		if !got.Equal(w) {
+			t.Errorf("#%d: got %s, want %s", i, got, w)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("got err = %v, want %v", err, io.EOF)
+	}
+	if got := dec.RowsRead(); got != 2 {
+		t.Errorf("got %d rows read, want 2", got)
+	}
+}
+
+func TestSQLDecoderResume(t *testing.T) {
+	var queries []string
+	failed := false
+	db := openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+		var after int64
+		if len(args) > 0 {
+			after = args[0].(int64)
+		}
+		queries = append(queries, query)
+		rows := &fakeRows{columns: []string{"id", "title"}}
+		for id := after + 1; id <= min(after+2, 5); id++ {
+			rows.values = append(rows.values, []driver.Value{id, "song"})
+		}
+		if after == 2 && !failed {
+			failed = true
+			rows.values = rows.values[:1]
+			rows.err = errLostConnection
+		}
+		return rows, nil
+	})
+	var retries []int64
+	dec, err := NewSQLDecoder(db, SQLOptions{
+		Query:       "select * from songs",
+		IdTemplate:  "id:music:song::{id}",
+		OrderColumn: "id",
+		BatchSize:   2,
+		Placeholder: "$1",
+		Retries:     1,
+		Retryable:   func(err error) bool { return errors.Is(err, errLostConnection) },
+		OnRetry:     func(err error, rows int64, attempt int) { retries = append(retries, rows) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for {
+		doc, err := dec.Decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.Id.UserSpecific)
+	}
+	if got, want := strings.Join(ids, ","), "1,2,3,4,5"; got != want {
+		t.Errorf("got ids %s, want %s", got, want)
+	}
+	wantQueries := []string{
+		"SELECT * FROM (select * from songs) AS vespa_feed_rows ORDER BY id LIMIT 2",
+		"SELECT * FROM (select * from songs) AS vespa_feed_rows WHERE id > $1 ORDER BY id LIMIT 2",
+		"SELECT * FROM (select * from songs) AS vespa_feed_rows WHERE id > $1 ORDER BY id LIMIT 2",
+		"SELECT * FROM (select * from songs) AS vespa_feed_rows WHERE id > $1 ORDER BY id LIMIT 2",
+	}
+	if strings.Join(queries, "\n") != strings.Join(wantQueries, "\n") {
+		t.Errorf("got queries\n%s\nwant\n%s", strings.Join(queries, "\n"), strings.Join(wantQueries, "\n"))
+	}
+	if len(retries) != 1 || retries[0] != 3 {
+		t.Errorf("got retries after rows %v, want [3]", retries)
+	}
+	if dec.RowsRead() != 5 || dec.Retries() != 1 {
+		t.Errorf("got %d rows read and %d retries, want 5 and 1", dec.RowsRead(), dec.Retries())
+	}
+}
+
+func TestSQLDecoderFailure(t *testing.T) {
+	db := openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+		return &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{int64(1)}}, err: errLostConnection}, nil
+	})
+	dec, err := NewSQLDecoder(db, SQLOptions{
+		Query:      "select id from songs",
+		IdTemplate: "id:music:song::{id}",
+		Retries:    3,
+		Retryable:  func(err error) bool { return true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	// Without an order column, the query is not resumed once rows are read
+	if _, err := dec.Decode(); err == nil || err.Error() != "query failed after 1 rows: lost connection" {
+		t.Errorf("got err = %v", err)
+	}
+}
+
+func TestSQLDecoderCancel(t *testing.T) {
+	queries := 0
+	db := openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+		queries++
+		return &fakeRows{columns: []string{"id"}}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dec, err := NewSQLDecoder(db, SQLOptions{
+		Context:    ctx,
+		Query:      "select id from songs",
+		IdTemplate: "id:music:song::{id}",
+		Retries:    3,
+		Retryable:  func(err error) bool { return true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A cancelled query is not retried
+	if _, err := dec.Decode(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got err = %v, want %v", err, context.Canceled)
+	}
+	if queries != 0 || dec.Retries() != 0 {
+		t.Errorf("got %d queries and %d retries, want none", queries, dec.Retries())
+	}
+
+	// Cancelling stops the wait before a retry
+	db = openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+		return nil, errLostConnection
+	})
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	dec, err = NewSQLDecoder(db, SQLOptions{
+		Context:    ctx,
+		Query:      "select id from songs",
+		IdTemplate: "id:music:song::{id}",
+		Retries:    3,
+		Retryable:  func(err error) bool { return true },
+		Backoff:    time.Hour,
+		OnRetry:    func(err error, rows int64, attempt int) { cancel() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := dec.Decode()
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err == nil || err.Error() != "query failed: lost connection" {
+			t.Errorf("got err = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("decoder did not stop waiting to retry when cancelled")
+	}
+}
+
+func TestSQLDecoderInvalid(t *testing.T) {
+	tests := []struct {
+		columns  []string
+		values   []driver.Value
+		template string
+		order    string
+		err      string
+	}{
+		{[]string{"sku", "title"}, nil, "id:ns:type::{id}", "", `id template "id:ns:type::{id}" refers to unknown column "id"`},
+		{[]string{"sku", "sku"}, nil, "id:ns:type::{sku}", "", `invalid columns: duplicate column "sku"`},
+		{[]string{"sku", "title"}, nil, "id:ns:type::{sku}", "id", `order column "id" is not a column of the query`},
+		{[]string{"sku", "title"}, []driver.Value{nil, "a"}, "id:ns:type::{sku}", "", `invalid row 1: column "sku" is empty`},
+		{[]string{"sku", "score"}, []driver.Value{"a", math.NaN()}, "id:ns:type::{sku}", "", `invalid row 1: column "score": NaN is not a valid JSON number`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			db := openFakeDB(t, func(query string, args []driver.Value) (driver.Rows, error) {
+				rows := &fakeRows{columns: tt.columns}
+				if tt.values != nil {
+					rows.values = [][]driver.Value{tt.values}
+				}
+				return rows, nil
+			})
+			dec, err := NewSQLDecoder(db, SQLOptions{Query: "select", IdTemplate: tt.template, OrderColumn: tt.order, BatchSize: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.Decode(); err == nil || err.Error() != tt.err {
+				t.Errorf("#%d: got err = %v, want %s", i, err, tt.err)
+			}
+		})
+	}
+	if _, err := NewSQLDecoder(nil, SQLOptions{Query: "select", OrderColumn: "id; drop table songs", BatchSize: 1}); err == nil {
+		t.Error("expected error for invalid order column")
+	}
+}
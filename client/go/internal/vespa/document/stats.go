@@ -19,6 +19,8 @@ const (
 	// StatusTransportFailure indicates that there was failure in the transport layer error while sending the document
 	// operation to Vespa.
 	StatusTransportFailure
+	// StatusInvalidOperation indicates that the document operation was not sent to Vespa, because it is invalid.
+	StatusInvalidOperation
 )
 
 // Result represents the result of a feeding operation.
@@ -31,13 +33,22 @@ type Result struct {
 	HTTPStatus int
 	Latency    time.Duration
 	BytesSent  int64
-	BytesRecv  int64
+	// BytesUncompressed is the size of the request body before any compression. This equals BytesSent for
+	// uncompressed requests.
+	BytesUncompressed int64
+	BytesRecv         int64
 }
 
 func (r Result) Success() bool {
 	return r.HTTPStatus/100 == 2 || r.HTTPStatus == 404 || r.HTTPStatus == 412
 }
 
+// Throttled returns whether this result indicates that Vespa is overloaded, i.e. too many requests (429), service
+// unavailable (503) or feed blocked due to insufficient storage (507).
+func (r Result) Throttled() bool {
+	return r.HTTPStatus == 429 || r.HTTPStatus == 503 || r.HTTPStatus == 507
+}
+
 // Stats represents feeding operation statistics.
 type Stats struct {
 	// Number of operations passed to the feeder by the user, not counting retries.
@@ -45,22 +56,42 @@ type Stats struct {
 	// Number of responses received, grouped by the HTTP status code. Requests that do not receive a response (i.e. no
 	// status code) are not counted.
 	ResponsesByCode map[int]int64
+	// Number of operations which failed permanently, i.e. without being retried, grouped by the HTTP status code of
+	// their last response, or 0 if the last request received no response. Invalid operations, which are not sent, are
+	// not counted.
+	FailuresByCode map[int]int64
 	// Number of requests made, including retries.
 	Requests int64
 	// Number of responses received.
 	Responses int64
 	// Number of transport layer errors.
 	Errors int64
+	// Number of operations whose test-and-set condition was not met.
+	ConditionNotMet int64
 	// Number of requests currently in-flight.
 	Inflight int64
+	// Target number of requests in-flight, as decided by the throttler.
+	TargetInflight int64
+	// Number of responses which caused throttling.
+	Throttled int64
+	// Total time requests waited for the rate limiter.
+	RateLimited time.Duration
+	// Highest total size of the bodies of operations held by the dispatcher at any time.
+	PeakBufferedBytes int64
+	// Highest number of operations held for a single document ID at any time, including the one in flight.
+	MaxQueueDepth int64
 	// Sum of response latency
 	TotalLatency time.Duration
 	// Lowest recorded response latency
 	MinLatency time.Duration
 	// Highest recorded response latency
 	MaxLatency time.Duration
+	// Distribution of response latency
+	Latencies Histogram
 	// Total bytes sent
 	BytesSent int64
+	// Total bytes sent, before compression
+	BytesUncompressed int64
 	// Total bytes received
 	BytesRecv int64
 }
@@ -91,9 +122,32 @@ func (s Stats) Clone() Stats {
 		}
 		s.ResponsesByCode = mapCopy
 	}
+	if s.FailuresByCode != nil {
+		mapCopy := make(map[int]int64)
+		for k, v := range s.FailuresByCode {
+			mapCopy[k] = v
+		}
+		s.FailuresByCode = mapCopy
+	}
+	s.Latencies = s.Latencies.Clone()
 	return s
 }
 
+// AddFailure records that the operation of result failed permanently.
+func (s *Stats) AddFailure(result Result) {
+	if result.Status == StatusInvalidOperation {
+		return
+	}
+	if s.FailuresByCode == nil {
+		s.FailuresByCode = make(map[int]int64)
+	}
+	code := result.HTTPStatus
+	if result.Err != nil {
+		code = 0
+	}
+	s.FailuresByCode[code]++
+}
+
 // Add statistics from result to this.
 func (s *Stats) Add(result Result, retry bool) {
 	if !retry {
@@ -106,6 +160,12 @@ func (s *Stats) Add(result Result, retry bool) {
 	if result.Err == nil {
 		s.ResponsesByCode[result.HTTPStatus]++
 		s.Responses++
+		if result.Throttled() {
+			s.Throttled++
+		}
+		if result.HTTPStatus == 412 {
+			s.ConditionNotMet++
+		}
 	} else {
 		s.Errors++
 	}
@@ -116,6 +176,8 @@ func (s *Stats) Add(result Result, retry bool) {
 	if result.Latency > s.MaxLatency {
 		s.MaxLatency = result.Latency
 	}
+	s.Latencies.Record(result.Latency)
 	s.BytesSent += result.BytesSent
+	s.BytesUncompressed += result.BytesUncompressed
 	s.BytesRecv += result.BytesRecv
 }
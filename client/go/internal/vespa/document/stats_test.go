@@ -16,6 +16,10 @@ func TestStatsAdd(t *testing.T) {
 	stats.Add(Result{HTTPStatus: 200, Latency: 300 * time.Millisecond}, false)
 	stats.Add(Result{HTTPStatus: 500, Latency: 100 * time.Millisecond}, false)
 	stats.Add(Result{HTTPStatus: 200, Latency: 100 * time.Millisecond}, true)
+	var latencies Histogram
+	for _, ms := range []time.Duration{200, 400, 100, 500, 300, 100, 100} {
+		latencies.Record(ms * time.Millisecond)
+	}
 	expected := Stats{
 		Operations:      6,
 		Requests:        7,
@@ -24,6 +28,7 @@ func TestStatsAdd(t *testing.T) {
 		TotalLatency:    1700 * time.Millisecond,
 		MinLatency:      100 * time.Millisecond,
 		MaxLatency:      500 * time.Millisecond,
+		Latencies:       latencies,
 	}
 	if !reflect.DeepEqual(stats, expected) {
 		t.Errorf("got %+v, want %+v", stats, expected)
@@ -31,6 +36,9 @@ func TestStatsAdd(t *testing.T) {
 	if want, got := int64(1), stats.Unsuccessful(); want != got {
 		t.Errorf("got stats.Unsuccessful() = %d, want %d", got, want)
 	}
+	if got := stats.Latencies.Percentile(50); got < 197*time.Millisecond || got > 203*time.Millisecond {
+		t.Errorf("got p50 = %s, want approximately 200ms", got)
+	}
 }
 
 func TestStatsClone(t *testing.T) {
@@ -39,7 +47,9 @@ func TestStatsClone(t *testing.T) {
 	b := a.Clone()
 	a.Add(Result{HTTPStatus: 200}, false)
 
-	want := Stats{Operations: 1, Requests: 1, Responses: 1, ResponsesByCode: map[int]int64{200: 1}}
+	var latencies Histogram
+	latencies.Record(0)
+	want := Stats{Operations: 1, Requests: 1, Responses: 1, ResponsesByCode: map[int]int64{200: 1}, Latencies: latencies}
 	if !reflect.DeepEqual(b, want) {
 		t.Errorf("got %+v, want %+v", b, want)
 	}
@@ -30,12 +30,33 @@ func (*staticThrottler) Success()                {}
 func (*staticThrottler) Throttled(count int64)   {}
 func (s *staticThrottler) TargetInflight() int64 { return int64(s.inflight) }
 
+// ThrottlerOptions configures a throttler.
+type ThrottlerOptions struct {
+	// Connections is the number of connections used for feeding.
+	Connections int
+	// Inflight sets a static number of inflight operations. If zero, the number of inflight operations is adjusted
+	// dynamically.
+	Inflight int
+	// MaxConnections bounds the window of a dynamic throttler to the number of streams available on this many
+	// connections. If zero, Connections is used.
+	MaxConnections int
+	// Streams is the number of concurrent streams available on each connection. If zero, the server side maximum of
+	// 512 is used.
+	Streams int
+	// MinThroughput is the number of operations per second a dynamic throttler should sustain. When throttled, the
+	// window is never reduced below the size which is estimated to give this throughput.
+	MinThroughput float64
+}
+
 type dynamicThrottler struct {
 	minInflight    int64
 	maxInflight    int64
 	targetInflight atomic.Int64
 	targetTimesTen atomic.Int64
 
+	minThroughput float64
+	latencyNanos  atomic.Int64
+
 	throughputs []float64
 	ok          atomic.Int64
 	sent        int64
@@ -45,13 +66,24 @@ type dynamicThrottler struct {
 }
 
 func newThrottler(connections int, nowFunc func() time.Time) *dynamicThrottler {
-	var (
-		minInflight = 2 * int64(connections)
-		maxInflight = 256 * minInflight // 512 max streams per connection on the server side
-	)
+	return newThrottlerWithOptions(ThrottlerOptions{Connections: connections}, nowFunc)
+}
+
+func newThrottlerWithOptions(options ThrottlerOptions, nowFunc func() time.Time) *dynamicThrottler {
+	maxConnections := options.MaxConnections
+	if maxConnections < 1 {
+		maxConnections = options.Connections
+	}
+	streams := options.Streams
+	if streams < 1 {
+		streams = 512 // 512 max streams per connection on the server side
+	}
+	minInflight := 2 * int64(options.Connections)
+	maxInflight := max(minInflight, int64(streams)*int64(maxConnections))
 	t := &dynamicThrottler{
-		minInflight: minInflight,
-		maxInflight: maxInflight,
+		minInflight:   minInflight,
+		maxInflight:   maxInflight,
+		minThroughput: options.MinThroughput,
 
 		throughputs: make([]float64, 128),
 
@@ -63,11 +95,11 @@ func newThrottler(connections int, nowFunc func() time.Time) *dynamicThrottler {
 	return t
 }
 
-func NewThrottler(connections int, inflight int) Throttler {
-	if inflight > 0 {
-		return &staticThrottler{inflight}
+func NewThrottler(options ThrottlerOptions) Throttler {
+	if options.Inflight > 0 {
+		return &staticThrottler{options.Inflight}
 	}
-	return newThrottler(connections, time.Now)
+	return newThrottlerWithOptions(options, time.Now)
 }
 
 func (t *dynamicThrottler) Sent() {
@@ -81,6 +113,10 @@ func (t *dynamicThrottler) Sent() {
 	elapsed := now.Sub(t.start)
 	t.start = now
 	currentThroughput := float64(t.ok.Swap(0)) / float64(elapsed)
+	if currentThroughput > 0 {
+		// Estimate latency from the current throughput and inflight operations, using Little's law
+		t.latencyNanos.Store(int64(float64(currentInflight) / currentThroughput))
+	}
 
 	// Use buckets for throughput over inflight, along the log-scale, in [minInflight, maxInflight).
 	index := int(float64(len(t.throughputs)) * math.Log(max(1, min(255, float64(currentInflight)/float64(t.minInflight)))) / math.Log(256))
@@ -129,7 +165,16 @@ func (t *dynamicThrottler) Success() {
 }
 
 func (t *dynamicThrottler) Throttled(inflight int64) {
-	t.targetTimesTen.Store(max(inflight*5, t.minInflight*10))
+	t.targetTimesTen.Store(max(inflight*5, t.minInflight*10, t.minThroughputInflight()*10))
+}
+
+// minThroughputInflight returns the estimated number of inflight operations needed to sustain the minimum throughput.
+func (t *dynamicThrottler) minThroughputInflight() int64 {
+	latency := time.Duration(t.latencyNanos.Load())
+	if t.minThroughput <= 0 || latency <= 0 {
+		return 0
+	}
+	return min(t.maxInflight, int64(math.Ceil(t.minThroughput*latency.Seconds())))
 }
 
 func (t *dynamicThrottler) TargetInflight() int64 {
@@ -26,6 +26,55 @@ func TestThrottler(t *testing.T) {
 	}
 }
 
+func TestThrottlerOptions(t *testing.T) {
+	tr := newThrottlerWithOptions(ThrottlerOptions{Connections: 8, MaxConnections: 2}, time.Now)
+	if got, want := tr.maxInflight, int64(1024); got != want {
+		t.Errorf("got maxInflight = %d, but want %d", got, want)
+	}
+	tr = newThrottlerWithOptions(ThrottlerOptions{Connections: 8}, time.Now)
+	if got, want := tr.maxInflight, int64(4096); got != want {
+		t.Errorf("got maxInflight = %d, but want %d", got, want)
+	}
+	tr = newThrottlerWithOptions(ThrottlerOptions{Connections: 8, Streams: 64}, time.Now)
+	if got, want := tr.maxInflight, int64(512); got != want {
+		t.Errorf("got maxInflight = %d, but want %d", got, want)
+	}
+}
+
+func TestThrottlerMinThroughput(t *testing.T) {
+	clock := &manualClock{tick: time.Second}
+	tr := newThrottlerWithOptions(ThrottlerOptions{Connections: 8, MinThroughput: 100}, clock.now)
+	for range 64 {
+		tr.Sent()
+		tr.Success()
+	}
+	// 63 operations per second with 16 inflight gives an estimated latency of 254 ms, so 26 inflight are needed to
+	// sustain 100 operations per second
+	if got, want := tr.minThroughputInflight(), int64(26); got != want {
+		t.Errorf("got minThroughputInflight() = %d, but want %d", got, want)
+	}
+	// Repeated throttling does not reduce the window below what is needed for the minimum throughput
+	for range 10 {
+		tr.Throttled(tr.TargetInflight())
+	}
+	if got, want := tr.targetTimesTen.Load()/10, int64(26); got != want {
+		t.Errorf("got static target = %d, but want %d", got, want)
+	}
+
+	// Without a minimum throughput, the window shrinks to its minimum
+	tr = newThrottlerWithOptions(ThrottlerOptions{Connections: 8}, clock.now)
+	for range 64 {
+		tr.Sent()
+		tr.Success()
+	}
+	for range 10 {
+		tr.Throttled(tr.TargetInflight())
+	}
+	if got, want := tr.TargetInflight(), int64(16); got != want {
+		t.Errorf("got TargetInflight() = %d, but want %d", got, want)
+	}
+}
+
 func TestStaticThrottler(t *testing.T) {
 	var tr Throttler = &staticThrottler{369}
 	if got, want := tr.TargetInflight(), int64(369); got != want {
@@ -0,0 +1,197 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Getter is the interface for retrieving documents.
+type Getter interface {
+	Get(id Id, fieldSet string) Result
+}
+
+// VerifyStats represents the result of verifying fed documents.
+type VerifyStats struct {
+	// Number of documents which were read back with the fields that were fed.
+	Verified int64
+	// Number of documents which were read back with fields differing from those that were fed.
+	Mismatched int64
+	// Number of documents which were not found.
+	Missing int64
+	// Number of documents which could not be read back, due to an error.
+	Errors int64
+}
+
+// Failed returns the number of documents that failed verification.
+func (s VerifyStats) Failed() int64 { return s.Mismatched + s.Missing + s.Errors }
+
+// Verifier is a Feeder which records the fields of a sample of the documents successfully put by another Feeder, such
+// that these can be read back and compared to what was fed.
+type Verifier struct {
+	feeder Feeder
+	sample uint32
+
+	mu     sync.Mutex
+	bodies map[string]verifiedDocument
+}
+
+type verifiedDocument struct {
+	id   Id
+	body []byte
+}
+
+// samplePrecision is number of distinct buckets a document ID is hashed into when sampling.
+const samplePrecision = 1_000_000
+
+// NewVerifier creates a verifier recording documents sent with feeder. The fraction of documents recorded is given by
+// sample, which must be in the range (0, 1]. Documents are sampled by their ID, so repeated runs sample the same
+// documents.
+func NewVerifier(feeder Feeder, sample float64) *Verifier {
+	return &Verifier{
+		feeder: feeder,
+		sample: uint32(sample * samplePrecision),
+		bodies: make(map[string]verifiedDocument),
+	}
+}
+
+func (v *Verifier) sampled(id Id) bool {
+	h := fnv.New32a()
+	h.Write([]byte(id.String()))
+	return h.Sum32()%samplePrecision < v.sample
+}
+
+// Send sends document with the underlying feeder. If this is a put which succeeds, its fields are recorded for
+// verification. Any other operation on the same document discards its recorded fields, as its contents are then no
+// longer known.
+func (v *Verifier) Send(document Document) Result {
+	result := v.feeder.Send(document)
+	if !v.sampled(document.Id) {
+		return result
+	}
+	k := document.Id.String()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if document.Operation == OperationPut && result.HTTPStatus == 200 {
+		v.bodies[k] = verifiedDocument{id: document.Id, body: bytes.Clone(document.Body)}
+	} else {
+		delete(v.bodies, k)
+	}
+	return result
+}
+
+// Verify retrieves all recorded documents with getter, using given number of concurrent requests, and compares their
+// fields to those that were fed. Only the fed fields are compared, so fields added by Vespa are ignored. The function
+// fail is called for each document that fails verification, with the reason for the failure.
+func (v *Verifier) Verify(getter Getter, concurrency int, fail func(id Id, reason string)) VerifyStats {
+	v.mu.Lock()
+	docs := make([]verifiedDocument, 0, len(v.bodies))
+	for _, doc := range v.bodies {
+		docs = append(docs, doc)
+	}
+	v.mu.Unlock()
+	slices.SortFunc(docs, func(a, b verifiedDocument) int { return strings.Compare(a.id.String(), b.id.String()) })
+	var (
+		stats VerifyStats
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		next  = make(chan verifiedDocument)
+	)
+	for range max(1, concurrency) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range next {
+				outcome, reason := verifyDocument(getter, doc)
+				mu.Lock()
+				switch outcome {
+				case verifyOK:
+					stats.Verified++
+				case verifyMismatch:
+					stats.Mismatched++
+				case verifyMissing:
+					stats.Missing++
+				case verifyError:
+					stats.Errors++
+				}
+				mu.Unlock()
+				if outcome != verifyOK {
+					fail(doc.id, reason)
+				}
+			}
+		}()
+	}
+	for _, doc := range docs {
+		next <- doc
+	}
+	close(next)
+	wg.Wait()
+	return stats
+}
+
+type verifyOutcome int
+
+const (
+	verifyOK verifyOutcome = iota
+	verifyMismatch
+	verifyMissing
+	verifyError
+)
+
+// verifyDocument verifies a single document, returning the outcome and, if it failed, the reason.
+func verifyDocument(getter Getter, doc verifiedDocument) (verifyOutcome, string) {
+	result := getter.Get(doc.id, "")
+	if result.Err != nil {
+		return verifyError, fmt.Sprintf("could not get document: %s", result.Err)
+	}
+	switch result.HTTPStatus {
+	case 200:
+	case 404:
+		return verifyMissing, "document not found"
+	default:
+		return verifyError, fmt.Sprintf("could not get document: got status %d", result.HTTPStatus)
+	}
+	var sent, got struct {
+		Fields map[string]any `json:"fields"`
+	}
+	if err := json.Unmarshal(doc.body, &sent); err != nil {
+		return verifyError, fmt.Sprintf("could not decode fed document: %s", err)
+	}
+	if err := json.Unmarshal(result.Body, &got); err != nil {
+		return verifyError, fmt.Sprintf("could not decode retrieved document: %s", err)
+	}
+	names := make([]string, 0, len(sent.Fields))
+	for name := range sent.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		want := sent.Fields[name]
+		value, ok := got.Fields[name]
+		if !ok {
+			if want == nil {
+				continue // Fields assigned null are removed
+			}
+			return verifyMismatch, fmt.Sprintf("field %s is missing", name)
+		}
+		if !reflect.DeepEqual(want, value) {
+			return verifyMismatch, fmt.Sprintf("field %s differs: fed %s, got %s", name, compactJSON(want), compactJSON(value))
+		}
+	}
+	return verifyOK, ""
+}
+
+func compactJSON(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,109 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package document
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockStore is a Feeder and Getter storing the documents put to it, after rewriting them with rewrite.
+type mockStore struct {
+	mu      sync.Mutex
+	docs    map[string]string
+	rewrite func(id string, body string) string
+	failing bool
+}
+
+func (s *mockStore) Send(doc Document) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return Result{Id: doc.Id, HTTPStatus: 500}
+	}
+	body := string(doc.Body)
+	if s.rewrite != nil {
+		body = s.rewrite(doc.Id.String(), body)
+	}
+	if body == "" {
+		delete(s.docs, doc.Id.String())
+	} else {
+		s.docs[doc.Id.String()] = body
+	}
+	return Result{Id: doc.Id, HTTPStatus: 200}
+}
+
+func (s *mockStore) Get(id Id, fieldSet string) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, ok := s.docs[id.String()]
+	if !ok {
+		return Result{Id: id, HTTPStatus: 404}
+	}
+	return Result{Id: id, HTTPStatus: 200, Body: []byte(body)}
+}
+
+func putDocument(id, fields string) Document {
+	return Document{Id: mustParseId(id), Operation: OperationPut, Body: []byte(`{"fields":` + fields + `}`)}
+}
+
+func TestVerifier(t *testing.T) {
+	store := &mockStore{docs: make(map[string]string), rewrite: func(id, body string) string {
+		switch id {
+		case "id:ns:type::doc1": // Server adds fields and reorders
+			return `{"id":"id:ns:type::doc1","fields":{"added":true,"b":[1,2],"a":{"y":2.0,"x":"1"}}}`
+		case "id:ns:type::doc2":
+			return `{"fields":{"a":"changed"}}`
+		case "id:ns:type::doc3":
+			return `{"fields":{}}`
+		case "id:ns:type::doc4": // Lost
+			return ""
+		}
+		return body
+	}}
+	verifier := NewVerifier(store, 1)
+	verifier.Send(putDocument("id:ns:type::doc1", `{"a":{"x":"1","y":2},"b":[1,2],"c":null}`))
+	verifier.Send(putDocument("id:ns:type::doc2", `{"a":"original"}`))
+	verifier.Send(putDocument("id:ns:type::doc3", `{"a":"original"}`))
+	verifier.Send(putDocument("id:ns:type::doc4", `{"a":"original"}`))
+	verifier.Send(putDocument("id:ns:type::doc5", `{"a":"original"}`))
+	verifier.Send(Document{Id: mustParseId("id:ns:type::doc5"), Operation: OperationUpdate, Body: []byte(`{"fields":{"a":{"assign":"updated"}}}`)})
+	store.failing = true
+	verifier.Send(putDocument("id:ns:type::doc6", `{"a":"failed"}`))
+
+	var failures []string
+	var mu sync.Mutex
+	stats := verifier.Verify(store, 4, func(id Id, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, fmt.Sprintf("%s: %s", id, reason))
+	})
+	sort.Strings(failures)
+	assert.Equal(t, VerifyStats{Verified: 1, Mismatched: 2, Missing: 1}, stats)
+	assert.Equal(t, int64(3), stats.Failed())
+	assert.Equal(t, []string{
+		`id:ns:type::doc2: field a differs: fed "original", got "changed"`,
+		`id:ns:type::doc3: field a is missing`,
+		`id:ns:type::doc4: document not found`,
+	}, failures)
+}
+
+func TestVerifierSample(t *testing.T) {
+	store := &mockStore{docs: make(map[string]string)}
+	verifier := NewVerifier(store, 0.1)
+	for i := range 1000 {
+		verifier.Send(putDocument(fmt.Sprintf("id:ns:type::doc%d", i), `{"a":1}`))
+	}
+	stats := verifier.Verify(store, 4, func(id Id, reason string) { t.Errorf("%s: %s", id, reason) })
+	assert.InDelta(t, 100, stats.Verified, 30)
+
+	// The same documents are sampled again
+	again := NewVerifier(store, 0.1)
+	for i := range 1000 {
+		again.Send(putDocument(fmt.Sprintf("id:ns:type::doc%d", i), `{"a":1}`))
+	}
+	assert.Equal(t, stats, again.Verify(store, 1, func(id Id, reason string) {}))
+}
@@ -0,0 +1,100 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"os"
+	"slices"
+	"sort"
+)
+
+// DocumentType is a document type defined by a schema of an application package.
+type DocumentType struct {
+	Name string
+	// Fields holds the names of the fields of the document type, including those of the document types it inherits,
+	// sorted by name. Fields declared outside the document, and imported fields, are not part of the document.
+	Fields []string
+	// FieldTypes holds the declared type of each field, without spaces, e.g. array<string> or tensor<float>(x[4])
+	FieldTypes map[string]string
+}
+
+// HasField returns whether this document type has a field with the given name.
+func (d DocumentType) HasField(name string) bool {
+	_, found := slices.BinarySearch(d.Fields, name)
+	return found
+}
+
+// DocumentTypes returns the document types defined by the schemas of this application package, sorted by name. Schemas
+// are parsed as by Lint, and problems found in them are ignored.
+func (ap *ApplicationPackage) DocumentTypes() ([]DocumentType, error) {
+	files, err := ap.Files()
+	if err != nil {
+		return nil, err
+	}
+	schemas := (&linter{}).parseSchemas(files)
+	documents := make(map[string]*lintSchema)
+	for _, s := range schemas {
+		if s.document != "" {
+			documents[s.document] = s
+		}
+	}
+	types := make([]DocumentType, 0, len(documents))
+	for name, s := range documents {
+		fields := make(map[string]string)
+		documentFields(s, documents, fields, nil)
+		d := DocumentType{Name: name, Fields: make([]string, 0, len(fields)), FieldTypes: make(map[string]string, len(fields))}
+		for field, typ := range fields {
+			d.Fields = append(d.Fields, field)
+			if typ != "" {
+				d.FieldTypes[field] = typ
+			}
+		}
+		sort.Strings(d.Fields)
+		types = append(types, d)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types, nil
+}
+
+// documentFields adds the document fields of schema s, and of the document types it inherits, to fields, with their
+// types, if declared.
+func documentFields(s *lintSchema, documents map[string]*lintSchema, fields map[string]string, seen []*lintSchema) {
+	if slices.Contains(seen, s) {
+		return // Inheritance cycle
+	}
+	for _, name := range s.documentFields {
+		fields[name] = s.documentFieldTypes[name]
+	}
+	for _, name := range s.inherits {
+		if parent, ok := documents[name]; ok {
+			documentFields(parent, documents, fields, append(seen, s))
+		}
+	}
+}
+
+// FetchDocumentTypes returns the document types defined by the schemas of the application package deployed to target.
+// An error wrapping ErrNotFound is returned if no application package is deployed.
+func FetchDocumentTypes(target Target) ([]DocumentType, error) {
+	var types []DocumentType
+	err := withFetchedPackage(target, func(pkg ApplicationPackage) error {
+		var err error
+		types, err = pkg.DocumentTypes()
+		return err
+	})
+	return types, err
+}
+
+// withFetchedPackage calls f with the application package deployed to target, fetched to a temporary file which is
+// removed when f returns.
+func withFetchedPackage(target Target, f func(pkg ApplicationPackage) error) error {
+	tmpDir, err := os.MkdirTemp("", "vespa-application")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	fetched, err := Fetch(DeploymentOptions{Target: target}, tmpDir, FetchOptions{})
+	if err != nil {
+		return err
+	}
+	return f(ApplicationPackage{Path: fetched.Path})
+}
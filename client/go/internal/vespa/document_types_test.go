@@ -0,0 +1,41 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentTypes(t *testing.T) {
+	pkg := writeLintApp(t, map[string]string{
+		"services.xml":     lintMusicServices,
+		"schemas/music.sd": lintMusicSchema,
+		"schemas/lyrics.sd": `document lyrics inherits music {
+    field text type string {}
+}`,
+		"schemas/cycle.sd": `schema cycle { document cycle inherits cycle { field a type int {} } }`,
+	})
+	types, err := pkg.DocumentTypes()
+	require.Nil(t, err)
+	musicTypes := map[string]string{
+		"artist":    "string",
+		"embedding": "tensor<float>(x[4],cat{})",
+		"info":      "info",
+		"scores":    "map<string,weightedset<int>>",
+		"tags":      "array<string>",
+		"title":     "string",
+	}
+	lyricsTypes := map[string]string{"text": "string"}
+	for name, typ := range musicTypes {
+		lyricsTypes[name] = typ
+	}
+	assert.Equal(t, []DocumentType{
+		{Name: "cycle", Fields: []string{"a"}, FieldTypes: map[string]string{"a": "int"}},
+		{Name: "lyrics", Fields: []string{"artist", "embedding", "info", "scores", "tags", "text", "title"}, FieldTypes: lyricsTypes},
+		{Name: "music", Fields: []string{"artist", "embedding", "info", "scores", "tags", "title"}, FieldTypes: musicTypes},
+	}, types)
+	assert.True(t, types[2].HasField("title"))
+	assert.False(t, types[2].HasField("title_length"))
+}
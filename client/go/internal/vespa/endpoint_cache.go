@@ -0,0 +1,141 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EndpointCache stores the endpoints discovered for deployments in Vespa Cloud in a file, such that subsequent
+// invocations of the CLI can use them without asking the control plane.
+type EndpointCache struct {
+	// Path is the file holding the cached endpoints
+	Path string
+	// TTL is how long discovered endpoints are used before they are discovered again
+	TTL time.Duration
+	// Identity identifies the credentials endpoints are discovered with. Endpoints are only found in the cache by the
+	// same identity that stored them
+	Identity string
+	// NowFunc returns the current time. Defaults to time.Now
+	NowFunc func() time.Time
+}
+
+type endpointCacheFile struct {
+	Entries map[string]endpointCacheEntry `json:"entries"`
+}
+
+type endpointCacheEntry struct {
+	Endpoints map[string][]clusterTarget `json:"endpoints"`
+	ExpiresAt time.Time                  `json:"expiresAt"`
+}
+
+func (c *EndpointCache) now() time.Time {
+	if c.NowFunc == nil {
+		return time.Now()
+	}
+	return c.NowFunc()
+}
+
+// key returns the key of the endpoints of deployment d. The key is a hash, such that the identity is not stored.
+func (c *EndpointCache) key(d Deployment) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.Identity, d.System.Name, d.Application.SerializedForm(), d.Zone.String()}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *EndpointCache) read() endpointCacheFile {
+	var f endpointCacheFile
+	data, err := os.ReadFile(c.Path)
+	if err == nil {
+		_ = json.Unmarshal(data, &f) // A corrupt cache is just empty
+	}
+	if f.Entries == nil {
+		f.Entries = make(map[string]endpointCacheEntry)
+	}
+	return f
+}
+
+// update applies fn to the entries of the cache, and writes the entries which have not expired.
+func (c *EndpointCache) update(fn func(entries map[string]endpointCacheEntry)) error {
+	f := c.read()
+	fn(f.Entries)
+	now := c.now()
+	for key, entry := range f.Entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(f.Entries, key)
+		}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.Path), filepath.Base(c.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), c.Path)
+}
+
+// get returns the cached endpoints of deployment d, if any have been stored and not expired.
+func (c *EndpointCache) get(d Deployment) (map[string][]clusterTarget, bool) {
+	if c == nil || c.Identity == "" || c.TTL <= 0 {
+		return nil, false
+	}
+	entry, ok := c.read().Entries[c.key(d)]
+	if !ok || !c.now().Before(entry.ExpiresAt) || len(entry.Endpoints) == 0 {
+		return nil, false
+	}
+	return entry.Endpoints, true
+}
+
+// put stores the endpoints of deployment d.
+func (c *EndpointCache) put(d Deployment, endpoints map[string][]clusterTarget) error {
+	if c == nil || c.Identity == "" || c.TTL <= 0 {
+		return nil
+	}
+	return c.update(func(entries map[string]endpointCacheEntry) {
+		entries[c.key(d)] = endpointCacheEntry{Endpoints: endpoints, ExpiresAt: c.now().Add(c.TTL)}
+	})
+}
+
+// Invalidate removes any cached endpoints of deployment d.
+func (c *EndpointCache) Invalidate(d Deployment) error {
+	if c == nil {
+		return nil
+	}
+	key := c.key(d)
+	if _, ok := c.read().Entries[key]; !ok {
+		return nil
+	}
+	return c.update(func(entries map[string]endpointCacheEntry) { delete(entries, key) })
+}
+
+// isStaleEndpoint returns whether the result of sending request indicates that the endpoint it was sent to no longer
+// exists. Document API responses are not considered, as these return 404 for documents that do not exist.
+func isStaleEndpoint(request *http.Request, response *http.Response, err error) bool {
+	if err != nil {
+		var opErr *net.OpError
+		var dnsErr *net.DNSError
+		return errors.As(err, &opErr) || errors.As(err, &dnsErr)
+	}
+	return response != nil && response.StatusCode == http.StatusNotFound && !strings.HasPrefix(request.URL.Path, "/document/v1/")
+}
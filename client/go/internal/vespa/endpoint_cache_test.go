@@ -0,0 +1,60 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointCache(t *testing.T) {
+	now := time.Now()
+	path := filepath.Join(t.TempDir(), "endpoints.json")
+	cache := &EndpointCache{Path: path, TTL: time.Minute, Identity: "alice", NowFunc: func() time.Time { return now }}
+	deployment := Deployment{System: PublicSystem, Application: ApplicationID{Tenant: "t1", Application: "a1", Instance: "i1"}, Zone: ZoneID{Environment: "dev", Region: "us-north-1"}}
+	endpoints := map[string][]clusterTarget{"search": {{URL: "https://example.com", AuthMethod: "mtls"}}}
+
+	_, ok := cache.get(deployment)
+	assert.False(t, ok)
+	require.Nil(t, cache.put(deployment, endpoints))
+	cached, ok := cache.get(deployment)
+	assert.True(t, ok)
+	assert.Equal(t, endpoints, cached)
+
+	// Other zones and identities do not see the cached endpoints
+	other := deployment
+	other.Zone.Region = "us-south-1"
+	_, ok = cache.get(other)
+	assert.False(t, ok)
+	otherIdentity := *cache
+	otherIdentity.Identity = "bob"
+	_, ok = otherIdentity.get(deployment)
+	assert.False(t, ok)
+
+	// Endpoints expire, and can be invalidated
+	now = now.Add(time.Minute)
+	_, ok = cache.get(deployment)
+	assert.False(t, ok)
+	now = now.Add(-time.Second)
+	require.Nil(t, cache.Invalidate(deployment))
+	_, ok = cache.get(deployment)
+	assert.False(t, ok)
+}
+
+func TestIsStaleEndpoint(t *testing.T) {
+	query, _ := http.NewRequest("GET", "https://example.com/search/", nil)
+	document, _ := http.NewRequest("GET", "https://example.com/document/v1/ns/type/docid/1", nil)
+	assert.True(t, isStaleEndpoint(query, nil, &net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, isStaleEndpoint(query, nil, &net.DNSError{Err: "no such host", IsNotFound: true}))
+	assert.False(t, isStaleEndpoint(query, nil, errors.New("other")))
+	assert.True(t, isStaleEndpoint(query, &http.Response{StatusCode: 404}, nil))
+	assert.False(t, isStaleEndpoint(query, &http.Response{StatusCode: 200}, nil))
+	assert.False(t, isStaleEndpoint(document, &http.Response{StatusCode: 404}, nil))
+}
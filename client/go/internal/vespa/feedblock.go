@@ -0,0 +1,120 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Metrics of cluster controllers holding the resource usage of the content cluster given by their cluster dimension.
+// Utilization is the highest usage among the content nodes, relative to the limit, such that feed is blocked at 1.
+const (
+	MetricMaxDiskUtilization   = "cluster-controller.resource_usage.max_disk_utilization"
+	MetricMaxMemoryUtilization = "cluster-controller.resource_usage.max_memory_utilization"
+	MetricNodesAboveLimit      = "cluster-controller.resource_usage.nodes_above_limit"
+	MetricDiskLimit            = "cluster-controller.resource_usage.disk_limit"
+	MetricMemoryLimit          = "cluster-controller.resource_usage.memory_limit"
+)
+
+// ResourceUsage is the usage of a resource in a content cluster, relative to the limit at which feed is blocked.
+type ResourceUsage struct {
+	// Utilization is the highest usage among the content nodes, divided by Limit
+	Utilization float64
+	// Limit is the fraction of the resource used when feed is blocked
+	Limit float64
+}
+
+// Usage returns the highest fraction of the resource used by a content node.
+func (r ResourceUsage) Usage() float64 { return r.Utilization * r.Limit }
+
+// FeedBlock is the state of feed blocking in a content cluster, as reported by its cluster controllers.
+type FeedBlock struct {
+	Cluster string
+	// Reported is whether any cluster controller reported the resource usage of the cluster
+	Reported bool
+	// NodesAboveLimit is the number of content nodes using more of a resource than its limit
+	NodesAboveLimit int
+	Disk            ResourceUsage
+	Memory          ResourceUsage
+}
+
+// Blocked returns whether feed is blocked in the cluster, as some content node is above a resource limit.
+func (b FeedBlock) Blocked() bool { return b.NodesAboveLimit > 0 }
+
+// FeedBlockTarget is implemented by targets which can read whether feed is blocked in their content clusters.
+type FeedBlockTarget interface {
+	// FeedBlocks returns the feed block state of each content cluster of the deployment, sorted by cluster name.
+	FeedBlocks() ([]FeedBlock, error)
+}
+
+func (t *customTarget) FeedBlocks() ([]FeedBlock, error) {
+	status, err := t.serviceStatus(AnyDeployment, 0)
+	if err != nil {
+		if t.targetType == TargetCustom {
+			// The URL of a custom target may be that of a container cluster, where the state of content clusters is unknown
+			return nil, nil
+		}
+		return nil, err
+	}
+	var controllers []serviceInfo
+	blocks := make(map[string]*FeedBlock)
+	for _, s := range status.Services {
+		switch s.Type {
+		case "container-clustercontroller":
+			controllers = append(controllers, s)
+		case "distributor":
+			blocks[s.ClusterName] = &FeedBlock{Cluster: s.ClusterName}
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	if len(controllers) == 0 {
+		return nil, fmt.Errorf("no cluster controllers found in deployment")
+	}
+	var errs []string
+	for _, c := range controllers {
+		var metrics stateMetrics
+		if err := t.getServiceJSON(c, "/state/v1/metrics", &metrics); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		// Only the master controller of a cluster reports its resource usage, so take the highest values reported
+		for _, m := range metrics.Metrics.Values {
+			b, ok := blocks[m.Dimensions["cluster"]]
+			if !ok {
+				continue
+			}
+			value, ok := m.Values["last"]
+			if !ok {
+				continue
+			}
+			switch m.Name {
+			case MetricMaxDiskUtilization:
+				b.Disk.Utilization = max(b.Disk.Utilization, value)
+			case MetricMaxMemoryUtilization:
+				b.Memory.Utilization = max(b.Memory.Utilization, value)
+			case MetricDiskLimit:
+				b.Disk.Limit = max(b.Disk.Limit, value)
+			case MetricMemoryLimit:
+				b.Memory.Limit = max(b.Memory.Limit, value)
+			case MetricNodesAboveLimit:
+				b.NodesAboveLimit = max(b.NodesAboveLimit, int(value))
+			default:
+				continue
+			}
+			b.Reported = true
+		}
+	}
+	if len(errs) == len(controllers) {
+		return nil, fmt.Errorf("could not get metrics of cluster controllers: %s", strings.Join(errs, ", "))
+	}
+	result := make([]FeedBlock, 0, len(blocks))
+	for _, b := range blocks {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Cluster < result[j].Cluster })
+	return result, nil
+}
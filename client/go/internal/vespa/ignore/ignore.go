@@ -5,27 +5,110 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-// List is a list of ignore patterns.
-type List struct{ patterns []string }
+// List is a list of ignore patterns, using the syntax of .gitignore files:
+//
+//   - A pattern without a slash matches files and directories at any level, e.g. node_modules.
+//   - A pattern containing a slash, other than a trailing one, is relative to the root, e.g. /build or models/*.onnx.
+//   - A pattern ending with a slash matches only directories, e.g. fixtures/.
+//   - Two consecutive asterisks match any number of directories, e.g. **/testdata or models/**/*.bin.
+//   - A pattern starting with an exclamation mark re-includes paths excluded by a previous pattern, e.g. !keep.txt.
+//
+// A path inside an excluded directory is always excluded.
+type List struct{ patterns []pattern }
 
-// Match returns whether path matches any pattern in this list.
-func (l *List) Match(path string) bool {
-	for _, pattern := range l.patterns {
-		if ok, _ := filepath.Match(pattern, path); ok {
-			return true
-		}
-		// A directory exclude applies to all subpaths
-		if strings.HasSuffix(pattern, string(filepath.Separator)) && strings.HasPrefix(path, pattern) {
+type pattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Match returns whether path matches this list. Paths ending with a separator are considered to be directories.
+func (l *List) Match(p string) bool {
+	isDir := strings.HasSuffix(p, "/") || strings.HasSuffix(p, string(filepath.Separator))
+	p = strings.Trim(filepath.ToSlash(p), "/")
+	if p == "" {
+		return false
+	}
+	segments := strings.Split(p, "/")
+	for i := 1; i <= len(segments); i++ {
+		// Every parent of path is a directory
+		if l.matchSegments(segments[:i], i < len(segments) || isDir) {
 			return true
 		}
 	}
 	return false
 }
 
+func (l *List) matchSegments(segments []string, isDir bool) bool {
+	matched := false
+	for _, p := range l.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matchGlob(p.segments, segments) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+func matchGlob(glob, segments []string) bool {
+	if len(glob) == 0 {
+		return len(segments) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(glob[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(glob[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlob(glob[1:], segments[1:])
+}
+
+// Add adds pattern to this list.
+func (l *List) Add(s string) error {
+	p := pattern{}
+	if strings.HasPrefix(s, "!") {
+		p.negate = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, `\!`) || strings.HasPrefix(s, `\#`) {
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	anchored := strings.Contains(s, "/")
+	s = strings.TrimPrefix(s, "/")
+	if s == "" {
+		return fmt.Errorf("bad pattern: empty")
+	}
+	if !anchored {
+		s = "**/" + s
+	}
+	p.segments = strings.Split(s, "/")
+	for _, segment := range p.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("bad pattern: %s: %w", s, err)
+		}
+	}
+	l.patterns = append(l.patterns, p)
+	return nil
+}
+
 // Read reads an ignore list from reader r.
 func Read(r io.Reader) (*List, error) {
 	scanner := bufio.NewScanner(r)
@@ -37,10 +120,9 @@ func Read(r io.Reader) (*List, error) {
 		if pattern == "" || strings.HasPrefix(pattern, "#") {
 			continue
 		}
-		if _, err := filepath.Match(pattern, ""); err != nil {
-			return nil, fmt.Errorf("line %d: bad pattern: %s: %w", line, pattern, err)
+		if err := ignore.Add(pattern); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
 		}
-		ignore.patterns = append(ignore.patterns, pattern)
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
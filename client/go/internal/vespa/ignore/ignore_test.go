@@ -30,19 +30,57 @@ bar/
 	assertMatch(t, list, "foobar", true)
 	assertMatch(t, list, "foo/bar", true)
 	assertMatch(t, list, "foo/bar/baz", true)
-	assertMatch(t, list, "foo/bar/bax", false)
+	assertMatch(t, list, "foo/bar/bax", true) // Parent directory is excluded
 	assertMatch(t, list, "bar", false)
 	assertMatch(t, list, "bar/", true)
 	assertMatch(t, list, "bar/x", true)
 	assertMatch(t, list, "foo.tmp", true)
 	assertMatch(t, list, "fooo.tmp", false)
 
+	assertMatch(t, list, "x/foo", true)
+	assertMatch(t, list, "x/bar", false)
+	assertMatch(t, list, "x/bar/", true)
+
 	_, err = Read(strings.NewReader("myfile["))
 	if err == nil {
 		t.Fatal("want error")
 	}
 }
 
+func TestReadGitignoreSyntax(t *testing.T) {
+	f := `
+node_modules/
+/build
+models/**/*.bin
+**/testdata
+*.log
+!keep.log
+\#hash
+`
+	list, err := Read(strings.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMatch(t, list, "node_modules/", true)
+	assertMatch(t, list, "node_modules/pkg/index.js", true)
+	assertMatch(t, list, "src/node_modules/pkg/index.js", true)
+	assertMatch(t, list, "node_modules", false) // Not a directory
+	assertMatch(t, list, "build", true)
+	assertMatch(t, list, "build/x.jar", true)
+	assertMatch(t, list, "src/build", false)
+	assertMatch(t, list, "models/a.bin", true)
+	assertMatch(t, list, "models/x/y/a.bin", true)
+	assertMatch(t, list, "models/a.onnx", false)
+	assertMatch(t, list, "other/models/a.bin", false)
+	assertMatch(t, list, "testdata/", true)
+	assertMatch(t, list, "a/b/testdata/file.json", true)
+	assertMatch(t, list, "debug.log", true)
+	assertMatch(t, list, "logs/debug.log", true)
+	assertMatch(t, list, "keep.log", false)
+	assertMatch(t, list, "build/keep.log", true) // Cannot re-include inside an excluded directory
+	assertMatch(t, list, "#hash", true)
+}
+
 func assertMatch(t *testing.T, list *List, name string, match bool) {
 	if got := list.Match(name); got != match {
 		t.Errorf("Match(%q) = %t, want %t", name, got, match)
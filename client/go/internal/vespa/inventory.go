@@ -0,0 +1,152 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RankProfile is a rank profile of a schema.
+type RankProfile struct {
+	Schema string
+	Name   string
+}
+
+// Inventory lists what an application package defines that requests to it may exercise.
+type Inventory struct {
+	// DocumentTypes holds the names of the document types defined by the schemas, sorted
+	DocumentTypes []string
+	// RankProfiles holds the rank profiles declared in the schemas, sorted by schema and name
+	RankProfiles []RankProfile
+	// Handlers holds the bindings of the request handlers of the container clusters, by path, sorted. A binding ending
+	// in * matches all paths starting with what precedes it, like /search/* or /document/v1/*
+	Handlers []string
+}
+
+// Inventory returns the inventory of this application package. Schemas are parsed as by Lint, and problems found in
+// them are ignored. An error is returned if services.xml can not be parsed.
+func (ap *ApplicationPackage) Inventory() (Inventory, error) {
+	files, err := ap.Files()
+	if err != nil {
+		return Inventory{}, err
+	}
+	var inventory Inventory
+	for _, s := range (&linter{}).parseSchemas(files) {
+		if s.document != "" {
+			inventory.DocumentTypes = append(inventory.DocumentTypes, s.document)
+		}
+		for _, name := range s.rankProfiles {
+			inventory.RankProfiles = append(inventory.RankProfiles, RankProfile{Schema: s.name, Name: name})
+		}
+	}
+	for name := range files {
+		// Rank profiles may also be declared in files named schemas/<schema>/<profile>.profile
+		dir, file := path.Split(path.Clean(strings.ReplaceAll(name, "\\", "/")))
+		dir = path.Clean(dir)
+		if path.Dir(dir) == "schemas" && path.Ext(file) == ".profile" {
+			inventory.RankProfiles = append(inventory.RankProfiles, RankProfile{Schema: path.Base(dir), Name: strings.TrimSuffix(file, ".profile")})
+		}
+	}
+	if f, ok := files["services.xml"]; ok && f.Content != nil {
+		if inventory.Handlers, err = containerHandlers(f.Content); err != nil {
+			return Inventory{}, fmt.Errorf("could not parse services.xml: %w", err)
+		}
+	}
+	sort.Strings(inventory.DocumentTypes)
+	sort.Slice(inventory.RankProfiles, func(i, j int) bool {
+		a, b := inventory.RankProfiles[i], inventory.RankProfiles[j]
+		if a.Schema != b.Schema {
+			return a.Schema < b.Schema
+		}
+		return a.Name < b.Name
+	})
+	inventory.RankProfiles = slices.Compact(inventory.RankProfiles)
+	return inventory, nil
+}
+
+// FetchInventory returns the inventory of the application package deployed to target. An error wrapping ErrNotFound is
+// returned if no application package is deployed.
+func FetchInventory(target Target) (Inventory, error) {
+	var inventory Inventory
+	err := withFetchedPackage(target, func(pkg ApplicationPackage) error {
+		var err error
+		inventory, err = pkg.Inventory()
+		return err
+	})
+	return inventory, err
+}
+
+// MatchesHandler returns whether the path of a request matches the given handler binding.
+func MatchesHandler(binding, requestPath string) bool {
+	if prefix, ok := strings.CutSuffix(binding, "*"); ok {
+		return strings.HasPrefix(requestPath, prefix)
+	}
+	return requestPath == binding
+}
+
+// containerHandlers returns the bindings of the handlers of the container clusters in services.xml content, by path. The
+// search and document API handlers are included when these are enabled.
+func containerHandlers(content []byte) ([]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var (
+		stack    []string
+		binding  *strings.Builder
+		handlers []string
+	)
+	inContainer := func() bool {
+		return len(stack) >= 2 && (stack[len(stack)-2] == "container" || stack[len(stack)-2] == "jdisc")
+	}
+	for {
+		t, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			stack = append(stack, e.Name.Local)
+			switch {
+			case e.Name.Local == "search" && inContainer():
+				handlers = append(handlers, "/search/*")
+			case e.Name.Local == "document-api" && inContainer():
+				handlers = append(handlers, "/document/v1/*")
+			case e.Name.Local == "binding" && len(stack) >= 3 && stack[len(stack)-2] == "handler" && (stack[len(stack)-3] == "container" || stack[len(stack)-3] == "jdisc"):
+				binding = &strings.Builder{}
+			}
+		case xml.CharData:
+			if binding != nil {
+				binding.Write(e)
+			}
+		case xml.EndElement:
+			if binding != nil {
+				handlers = append(handlers, bindingPath(binding.String()))
+				binding = nil
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	sort.Strings(handlers)
+	return slices.Compact(handlers), nil
+}
+
+// bindingPath returns the path of a handler binding, like http://*/my-handler/*.
+func bindingPath(binding string) string {
+	binding = strings.TrimSpace(binding)
+	if _, rest, ok := strings.Cut(binding, "://"); ok {
+		binding = rest
+	}
+	if i := strings.Index(binding, "/"); i >= 0 {
+		return binding[i:]
+	}
+	return "/*"
+}
@@ -0,0 +1,58 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventory(t *testing.T) {
+	pkg := writeLintApp(t, map[string]string{
+		"services.xml": `<services version="1.0">
+  <container id="default" version="1.0">
+    <search/>
+    <document-api/>
+    <handler id="com.example.Handler">
+      <binding>http://*/my-handler/*</binding>
+      <binding> http://*:8080/status </binding>
+    </handler>
+  </container>
+  <content id="music" version="1.0">
+    <documents>
+      <document type="music" mode="index"/>
+      <document type="lyrics" mode="index"/>
+    </documents>
+  </content>
+</services>
+`,
+		"schemas/music.sd":              lintMusicSchema,
+		"schemas/music/popular.profile": `rank-profile popular inherits default { }`,
+		"schemas/lyrics.sd": `document lyrics inherits music {
+    field text type string {}
+}`,
+	})
+	inventory, err := pkg.Inventory()
+	require.Nil(t, err)
+	assert.Equal(t, Inventory{
+		DocumentTypes: []string{"lyrics", "music"},
+		RankProfiles:  []RankProfile{{Schema: "music", Name: "default"}, {Schema: "music", Name: "popular"}, {Schema: "music", Name: "semantic"}},
+		Handlers:      []string{"/document/v1/*", "/my-handler/*", "/search/*", "/status"},
+	}, inventory)
+
+	assert.True(t, MatchesHandler("/search/*", "/search/"))
+	assert.True(t, MatchesHandler("/my-handler/*", "/my-handler/a/b"))
+	assert.False(t, MatchesHandler("/my-handler/*", "/my-handler"))
+	assert.True(t, MatchesHandler("/status", "/status"))
+	assert.False(t, MatchesHandler("/status", "/status/"))
+
+	// Packages without services.xml have no handlers, and invalid ones fail
+	pkg = writeLintApp(t, map[string]string{"schemas/music.sd": lintMusicSchema})
+	inventory, err = pkg.Inventory()
+	require.Nil(t, err)
+	assert.Empty(t, inventory.Handlers)
+	pkg = writeLintApp(t, map[string]string{"services.xml": "<services><container>"})
+	_, err = pkg.Inventory()
+	assert.NotNil(t, err)
+}
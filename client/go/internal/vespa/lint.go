@@ -0,0 +1,544 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// LintProblem is a problem found when linting an application package.
+type LintProblem struct {
+	// Path is the path of the file holding the problem, relative to the root of the application package
+	Path string
+	// Line is the line of the problem, or 0 if the problem concerns the file as a whole
+	Line    int
+	Message string
+}
+
+func (p LintProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	}
+	return p.Path + ": " + p.Message
+}
+
+// xmlRoots holds the accepted root elements of the XML files checked by Lint.
+var xmlRoots = map[string][]string{
+	"services.xml":             {"services", "container"},
+	"hosts.xml":                {"hosts"},
+	"deployment.xml":           {"deployment"},
+	"validation-overrides.xml": {"validation-overrides"},
+}
+
+// primitiveFieldTypes holds the field types which take no type parameters.
+var primitiveFieldTypes = []string{"bool", "byte", "int", "long", "float", "float16", "double", "string", "raw", "uri", "position", "predicate", "tag"}
+
+// rankFeatureRegexp matches rank features whose arguments are field names.
+var rankFeatureRegexp = regexp.MustCompile(`\b(attribute|bm25|closeness|distance|elementCompleteness|elementSimilarity|fieldLength|fieldMatch|fieldTermMatch|matches|nativeAttributeMatch|nativeFieldMatch|nativeProximity|nativeRank|textSimilarity)\(([^()]*)\)`)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Lint runs fast, local checks of the XML files and schemas of this application package, and returns the problems found,
+// ordered by file and line. These checks catch common mistakes before deploying, but passing them does not mean the
+// application package is valid: this is only decided by the config server when the application package is deployed.
+func (ap *ApplicationPackage) Lint() ([]LintProblem, error) {
+	files, err := ap.Files()
+	if err != nil {
+		return nil, err
+	}
+	l := &linter{}
+	var documentTypes []documentTypeRef
+	if _, ok := files["services.xml"]; !ok {
+		l.report("services.xml", 0, "file is missing")
+	}
+	for name, roots := range xmlRoots {
+		f, ok := files[name]
+		if !ok || f.Content == nil {
+			continue
+		}
+		var visit func(stack []string, e xml.StartElement, line int)
+		if name == "services.xml" {
+			visit = func(stack []string, e xml.StartElement, line int) {
+				if len(stack) >= 3 && slices.Equal(stack[len(stack)-3:], []string{"content", "documents", "document"}) {
+					for _, attr := range e.Attr {
+						if attr.Name.Local == "type" {
+							documentTypes = append(documentTypes, documentTypeRef{name: attr.Value, line: line})
+						}
+					}
+				}
+			}
+		}
+		l.lintXML(name, f.Content, roots, visit)
+	}
+	schemas := l.parseSchemas(files)
+	l.lintSchemas(schemas)
+	for _, ref := range documentTypes {
+		if !slices.ContainsFunc(schemas, func(s *lintSchema) bool { return s.document == ref.name }) {
+			l.report("services.xml", ref.line, fmt.Sprintf("document type '%s' has no schema: expected schemas/%s.sd", ref.name, ref.name))
+		}
+	}
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		if l.problems[i].Path != l.problems[j].Path {
+			return l.problems[i].Path < l.problems[j].Path
+		}
+		return l.problems[i].Line < l.problems[j].Line
+	})
+	return l.problems, nil
+}
+
+// parseSchemas parses the schema files among files.
+func (l *linter) parseSchemas(files map[string]PackageFile) []*lintSchema {
+	var schemas []*lintSchema
+	for name, f := range files {
+		dir, file := path.Split(path.Clean(strings.ReplaceAll(name, "\\", "/")))
+		if (dir == "schemas/" || dir == "searchdefinitions/") && path.Ext(file) == ".sd" && f.Content != nil {
+			schemas = append(schemas, l.parseSchema(name, f.Content))
+		}
+	}
+	return schemas
+}
+
+type linter struct {
+	problems []LintProblem
+}
+
+func (l *linter) report(path string, line int, message string) {
+	l.problems = append(l.problems, LintProblem{Path: path, Line: line, Message: message})
+}
+
+// documentTypeRef is a document type referenced from services.xml.
+type documentTypeRef struct {
+	name string
+	line int
+}
+
+// lintXML checks that content is well-formed XML with one of the given root elements. Function visit, if non-nil, is
+// called with the path to each element and the line it starts on.
+func (l *linter) lintXML(name string, content []byte, roots []string, visit func(stack []string, e xml.StartElement, line int)) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	var stack []string
+	hasRoot := false
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				l.report(name, syntaxErr.Line, "invalid XML: "+syntaxErr.Msg)
+			} else {
+				line, _ := dec.InputPos()
+				l.report(name, line, "invalid XML: "+err.Error())
+			}
+			return
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			line, _ := dec.InputPos()
+			if len(stack) == 0 {
+				if hasRoot {
+					l.report(name, line, "invalid XML: more than one root element")
+					return
+				}
+				hasRoot = true
+				if !slices.Contains(roots, e.Name.Local) {
+					l.report(name, line, fmt.Sprintf("root element must be <%s>, got <%s>", strings.Join(roots, "> or <"), e.Name.Local))
+				}
+			}
+			stack = append(stack, e.Name.Local)
+			if visit != nil {
+				visit(stack, e, line)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if !hasRoot {
+		l.report(name, 0, "invalid XML: no root element")
+	}
+}
+
+// lintSchema holds what the linter needs to know about a schema file.
+type lintSchema struct {
+	path     string
+	name     string
+	document string
+	inherits []string
+	structs  []string
+	// fields holds the fields of the schema and its document, including imported fields
+	fields map[string]int
+	// documentFields holds the names of the fields declared in the document of the schema
+	documentFields []string
+	// documentFieldTypes holds the declared types of the fields in documentFields, without spaces
+	documentFieldTypes map[string]string
+	// typed holds the field declarations whose type is checked when all schemas are parsed
+	typed []fieldDecl
+	// references holds the fields referenced from rank profiles
+	references []fieldRef
+	// rankProfiles holds the names of the rank profiles declared in the schema file
+	rankProfiles []string
+}
+
+type fieldDecl struct {
+	name string
+	typ  string
+	line int
+}
+
+// fieldRef is a reference to a field from a rank feature.
+type fieldRef struct {
+	name    string
+	feature string
+	line    int
+}
+
+// schemaBlock is a block of a schema, enclosed in braces.
+type schemaBlock struct {
+	kind   string
+	line   int
+	fields map[string]int
+}
+
+// parseSchema parses the schema in content, reporting any problems which can be found in the file alone.
+func (l *linter) parseSchema(name string, content []byte) *lintSchema {
+	s := &lintSchema{path: name, fields: make(map[string]int)}
+	var (
+		stack    []schemaBlock
+		previous []string
+	)
+	inRankProfile := func() bool {
+		return slices.ContainsFunc(stack, func(b schemaBlock) bool { return b.kind == "rank-profile" })
+	}
+	for i, text := range strings.Split(string(content), "\n") {
+		lineNo := i + 1
+		text = stripSchemaComment(text)
+		tokens := schemaTokens(text)
+		if inRankProfile() || (len(tokens) > 0 && tokens[0] == "rank-profile") {
+			s.references = append(s.references, fieldReferences(text, lineNo)...)
+		}
+		var header []string
+		for _, token := range tokens {
+			switch token {
+			case "{":
+				words := header
+				if len(words) == 0 {
+					words = previous // Opening brace on a line of its own
+				}
+				block := schemaBlock{line: lineNo, fields: s.fields}
+				if len(stack) > 0 {
+					block.fields = stack[len(stack)-1].fields
+				}
+				if len(words) > 0 {
+					block.kind = words[0]
+				}
+				parent := ""
+				if len(stack) > 0 {
+					parent = stack[len(stack)-1].kind
+				}
+				switch {
+				case (block.kind == "schema" || block.kind == "search") && len(stack) == 0:
+					if len(words) > 1 {
+						s.name = words[1]
+					}
+				case block.kind == "document" && (parent == "schema" || parent == "search" || len(stack) == 0):
+					if len(words) > 1 {
+						s.document = words[1]
+						if len(stack) == 0 {
+							s.name = words[1] // A schema holding only a document
+						}
+					}
+					if j := slices.Index(words, "inherits"); j >= 0 {
+						for _, w := range words[j+1:] {
+							for _, inherited := range strings.Split(w, ",") {
+								if inherited != "" {
+									s.inherits = append(s.inherits, inherited)
+								}
+							}
+						}
+					}
+				case block.kind == "struct":
+					if len(words) > 1 {
+						s.structs = append(s.structs, words[1])
+					}
+					block.fields = make(map[string]int)
+				case block.kind == "field" && slices.Contains([]string{"schema", "search", "document", "struct"}, parent):
+					l.declareField(s, block.fields, words, lineNo, parent != "schema" && parent != "search")
+					if parent == "document" && len(words) > 1 {
+						s.documentFields = append(s.documentFields, words[1])
+						if len(words) > 3 && words[2] == "type" {
+							if s.documentFieldTypes == nil {
+								s.documentFieldTypes = make(map[string]string)
+							}
+							s.documentFieldTypes[words[1]] = strings.Join(words[3:], "")
+						}
+					}
+				case block.kind == "rank-profile" && (parent == "schema" || parent == "search"):
+					if len(words) > 1 {
+						s.rankProfiles = append(s.rankProfiles, words[1])
+					}
+				case block.kind == "import":
+					if j := slices.Index(words, "as"); j >= 0 && j+1 < len(words) {
+						l.addField(s, block.fields, words[j+1], lineNo)
+					}
+				}
+				stack = append(stack, block)
+				header = nil
+			case "}":
+				if len(stack) == 0 {
+					l.report(name, lineNo, "unexpected '}'")
+				} else {
+					stack = stack[:len(stack)-1]
+				}
+				header = nil
+			default:
+				header = append(header, token)
+			}
+		}
+		if len(header) > 0 {
+			previous = header
+		} else if len(tokens) > 0 {
+			previous = nil
+		}
+	}
+	for _, block := range stack {
+		l.report(name, block.line, fmt.Sprintf("'{' of %s is never closed", block.kind))
+	}
+	if s.name == "" {
+		l.report(name, 0, "no schema definition found")
+	} else if want := strings.TrimSuffix(path.Base(name), ".sd"); s.name != want {
+		l.report(name, 1, fmt.Sprintf("schema '%s' must be defined in a file named %s.sd", s.name, s.name))
+	}
+	return s
+}
+
+// declareField declares the field in words, on the form 'field name type type-spec', in fields.
+func (l *linter) declareField(s *lintSchema, fields map[string]int, words []string, line int, requireType bool) {
+	if len(words) < 2 {
+		l.report(s.path, line, "field has no name")
+		return
+	}
+	name := words[1]
+	l.addField(s, fields, name, line)
+	if len(words) < 4 || words[2] != "type" {
+		if requireType {
+			l.report(s.path, line, fmt.Sprintf("field '%s' has no type", name))
+		}
+		return
+	}
+	s.typed = append(s.typed, fieldDecl{name: name, typ: strings.Join(words[3:], ""), line: line})
+}
+
+func (l *linter) addField(s *lintSchema, fields map[string]int, name string, line int) {
+	if first, ok := fields[name]; ok {
+		l.report(s.path, line, fmt.Sprintf("duplicate field '%s', first declared on line %d", name, first))
+		return
+	}
+	fields[name] = line
+}
+
+// lintSchemas checks the field types and rank profile field references of schemas, which may depend on each other.
+func (l *linter) lintSchemas(schemas []*lintSchema) {
+	types := make(map[string]bool)
+	documents := make(map[string]*lintSchema)
+	for _, s := range schemas {
+		for _, name := range s.structs {
+			types[name] = true
+		}
+		if s.document != "" {
+			types[s.document] = true
+			documents[s.document] = s
+		}
+	}
+	for _, s := range schemas {
+		for _, field := range s.typed {
+			if !validFieldType(field.typ, types) {
+				l.report(s.path, field.line, fmt.Sprintf("unknown type '%s' of field '%s'", field.typ, field.name))
+			}
+		}
+		fields, ok := schemaFields(s, documents, nil)
+		if !ok {
+			continue // Inherits from a document type we don't know
+		}
+		for _, ref := range s.references {
+			if _, ok := fields[ref.name]; !ok {
+				l.report(s.path, ref.line, fmt.Sprintf("rank feature %s references unknown field '%s'", ref.feature, ref.name))
+			}
+		}
+	}
+}
+
+// schemaFields returns the fields of schema s, including those of the document types it inherits, and whether all
+// inherited document types are known.
+func schemaFields(s *lintSchema, documents map[string]*lintSchema, seen []*lintSchema) (map[string]int, bool) {
+	if slices.Contains(seen, s) {
+		return nil, false // Inheritance cycle
+	}
+	fields := make(map[string]int, len(s.fields))
+	for name, line := range s.fields {
+		fields[name] = line
+	}
+	for _, name := range s.inherits {
+		if name == "document" {
+			continue // The root document type, which has no fields
+		}
+		parent, ok := documents[name]
+		if !ok {
+			return nil, false
+		}
+		inherited, ok := schemaFields(parent, documents, append(seen, s))
+		if !ok {
+			return nil, false
+		}
+		for name, line := range inherited {
+			fields[name] = line
+		}
+	}
+	return fields, true
+}
+
+// fieldReferences returns references to fields from the rank features in text, which holds part of a rank profile.
+func fieldReferences(text string, line int) []fieldRef {
+	var refs []fieldRef
+	for _, m := range rankFeatureRegexp.FindAllStringSubmatch(text, -1) {
+		feature, args := m[1], strings.Split(m[2], ",")
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		var names []string
+		switch feature {
+		case "closeness", "distance":
+			if len(args) == 2 && args[0] == "field" {
+				names = args[1:]
+			}
+		case "nativeRank", "nativeFieldMatch", "nativeProximity", "nativeAttributeMatch":
+			names = args
+		default:
+			names = args[:1]
+		}
+		for _, name := range names {
+			name, _, _ = strings.Cut(name, ".") // A struct or map field
+			ref := fieldRef{name: name, feature: m[0], line: line}
+			if identifierRegexp.MatchString(name) && !slices.Contains(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// validFieldType returns whether t, with whitespace removed, is a valid field type. Types holds the names of the struct
+// and document types which are defined.
+func validFieldType(t string, types map[string]bool) bool {
+	if slices.Contains(primitiveFieldTypes, t) || types[t] {
+		return true
+	}
+	if element, ok := strings.CutSuffix(t, "[]"); ok {
+		return validFieldType(element, types) // Legacy array syntax
+	}
+	if strings.HasPrefix(t, "tensor(") || strings.HasPrefix(t, "tensor<") {
+		return strings.HasSuffix(t, ")")
+	}
+	open := strings.Index(t, "<")
+	if open < 0 || !strings.HasSuffix(t, ">") {
+		return false
+	}
+	args := splitTypeArgs(t[open+1 : len(t)-1])
+	switch t[:open] {
+	case "array", "weightedset":
+		return len(args) == 1 && validFieldType(args[0], types)
+	case "map":
+		return len(args) == 2 && validFieldType(args[0], types) && validFieldType(args[1], types)
+	case "reference", "annotationreference":
+		return len(args) == 1 && identifierRegexp.MatchString(args[0])
+	}
+	return false
+}
+
+// splitTypeArgs splits the type arguments in s at the commas which are not nested in other type arguments.
+func splitTypeArgs(s string) []string {
+	var (
+		args  []string
+		depth int
+		start int
+	)
+	for i, c := range s {
+		switch c {
+		case '<', '(', '[':
+			depth++
+		case '>', ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, s[start:])
+}
+
+// stripSchemaComment returns text without any comment, which starts with a '#' outside a string.
+func stripSchemaComment(text string) string {
+	inString := false
+	for i, c := range text {
+		switch {
+		case c == '"':
+			inString = !inString
+		case c == '#' && !inString:
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// schemaTokens splits text into braces and the words between them. Strings, and braces inside parentheses such as
+// those of tensor types, are kept as part of a word.
+func schemaTokens(text string) []string {
+	var (
+		tokens   []string
+		word     strings.Builder
+		inString bool
+		parens   int
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, c := range text {
+		switch {
+		case inString:
+			word.WriteRune(c)
+			inString = c != '"'
+		case c == '"':
+			word.WriteRune(c)
+			inString = true
+		case c == '(' || c == ')':
+			word.WriteRune(c)
+			if c == '(' {
+				parens++
+			} else if parens > 0 {
+				parens--
+			}
+		case (c == '{' || c == '}') && parens == 0:
+			flush()
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		default:
+			word.WriteRune(c)
+		}
+	}
+	flush()
+	return tokens
+}
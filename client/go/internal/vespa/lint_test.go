@@ -0,0 +1,144 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLintApp(t *testing.T, files map[string]string) ApplicationPackage {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return ApplicationPackage{Path: dir}
+}
+
+func lint(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	pkg := writeLintApp(t, files)
+	problems, err := pkg.Lint()
+	require.Nil(t, err)
+	var lines []string
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return lines
+}
+
+const lintMusicServices = `<services version="1.0">
+  <container id="default" version="1.0"/>
+  <content id="music" version="1.0">
+    <documents>
+      <document type="music" mode="index"/>
+      <document type="lyrics" mode="index"/>
+    </documents>
+  </content>
+</services>
+`
+
+const lintMusicSchema = `schema music {
+    document music {
+        field title type string { # A comment with a {
+            indexing: index | summary
+        }
+        field artist type string {}
+        field embedding type tensor<float>(x[4], cat{}) {
+            indexing: attribute
+        }
+        field tags type array<string> {}
+        field scores type map<string, weightedset<int>> {}
+        field info type info {}
+        struct info {
+            field title type string {}
+        }
+    }
+    field title_length type int {
+        indexing: input title | to_int | attribute
+    }
+    rank-profile default {
+        first-phase {
+            expression: nativeRank(title, artist) + attribute(title_length)
+        }
+    }
+    rank-profile semantic inherits default
+    {
+        first-phase {
+            expression: closeness(field, embedding) + bm25(title)
+        }
+    }
+}
+`
+
+func TestLintValid(t *testing.T) {
+	assert.Nil(t, lint(t, map[string]string{
+		"services.xml":     lintMusicServices,
+		"schemas/music.sd": lintMusicSchema,
+		"schemas/lyrics.sd": `document lyrics inherits music {
+    field text type string {}
+}`,
+		"deployment.xml": `<deployment version="1.0"><prod><region>aws-us-east-1c</region></prod></deployment>`,
+	}))
+}
+
+func TestLintProblems(t *testing.T) {
+	assert.Equal(t, []string{
+		"deployment.xml:1: root element must be <deployment>, got <services>",
+		"schemas/music.sd:3: unknown type 'strng' of field 'title'",
+		"schemas/music.sd:5: duplicate field 'artist', first declared on line 4",
+		"schemas/music.sd:6: unknown type 'map<string>' of field 'vectors'",
+		"schemas/music.sd:7: field 'year' has no type",
+		"schemas/music.sd:12: rank feature attribute(genre) references unknown field 'genre'",
+		"schemas/music.sd:12: rank feature bm25(lyrics) references unknown field 'lyrics'",
+		"services.xml:6: document type 'lyrics' has no schema: expected schemas/lyrics.sd",
+		"validation-overrides.xml:3: invalid XML: element <allow> closed by </validation-overrides>",
+	}, lint(t, map[string]string{
+		"services.xml": lintMusicServices,
+		"schemas/music.sd": `schema music {
+    document music {
+        field title type strng {}
+        field artist type string {}
+        field artist type string {}
+        field vectors type map<string> {}
+        field year {}
+    }
+    rank-profile default {
+        first-phase {
+            expression {
+                attribute(genre) + bm25(lyrics) + bm25(title) + query(genre)
+            }
+        }
+    }
+}
+`,
+		"deployment.xml": `<services/>`,
+		"validation-overrides.xml": `<validation-overrides>
+  <allow until="2025-01-01">field-type-change
+</validation-overrides>`,
+	}))
+}
+
+func TestLintStructure(t *testing.T) {
+	assert.Equal(t, []string{
+		"schemas/album.sd:1: '{' of schema is never closed",
+		"schemas/album.sd:1: schema 'music' must be defined in a file named music.sd",
+		"schemas/broken.sd:3: unexpected '}'",
+		"schemas/empty.sd: no schema definition found",
+		"services.xml: file is missing",
+	}, lint(t, map[string]string{
+		"schemas/album.sd": `schema music {
+    document music {
+}`,
+		"schemas/broken.sd": `schema broken {
+}
+}`,
+		"schemas/empty.sd": `# Nothing here`,
+	}))
+}
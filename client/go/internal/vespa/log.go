@@ -3,8 +3,11 @@ package vespa
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,12 +17,16 @@ var dequoter = strings.NewReplacer("\\n", "\n", "\\t", "\t")
 
 // LogEntry represents a Vespa log entry.
 type LogEntry struct {
-	Time      time.Time
-	Host      string
+	Time time.Time
+	Host string
+	// PID holds the process and thread IDs of the process logging this entry
+	PID       string
 	Service   string
 	Component string
 	Level     string
 	Message   string
+	// Zone is the zone of the deployment logging this entry, when logs of several zones are merged
+	Zone string
 }
 
 func (le *LogEntry) Format(dequote bool) string {
@@ -28,9 +35,39 @@ func (le *LogEntry) Format(dequote bool) string {
 	if dequote {
 		msg = dequoter.Replace(msg)
 	}
+	if le.Zone != "" {
+		return fmt.Sprintf("[%s] %s %-8s %-7s %-16s %s\t%s", t, le.Zone, le.Host, le.Level, le.Service, le.Component, msg)
+	}
 	return fmt.Sprintf("[%s] %-8s %-7s %-16s %s\t%s", t, le.Host, le.Level, le.Service, le.Component, msg)
 }
 
+// FormatJSON formats this entry as a single line JSON object holding all its fields.
+func (le *LogEntry) FormatJSON(dequote bool) (string, error) {
+	msg := le.Message
+	if dequote {
+		msg = dequoter.Replace(msg)
+	}
+	data, err := json.Marshal(struct {
+		Time      string `json:"time"`
+		Host      string `json:"host"`
+		PID       string `json:"pid"`
+		Service   string `json:"service"`
+		Component string `json:"component"`
+		Level     string `json:"level"`
+		Message   string `json:"message"`
+		Zone      string `json:"zone,omitempty"`
+	}{le.Time.UTC().Format(time.RFC3339Nano), le.Host, le.PID, le.Service, le.Component, le.Level, msg, le.Zone})
+	return string(data), err
+}
+
+// FormatAs formats this entry as a single line JSON object if json is true, and as text otherwise.
+func (le *LogEntry) FormatAs(json, dequote bool) (string, error) {
+	if json {
+		return le.FormatJSON(dequote)
+	}
+	return le.Format(dequote), nil
+}
+
 // ParseLogEntry parses a Vespa log entry from string s.
 func ParseLogEntry(s string) (LogEntry, error) {
 	parts := strings.SplitN(s, "\t", 7)
@@ -44,6 +81,7 @@ func ParseLogEntry(s string) (LogEntry, error) {
 	return LogEntry{
 		Time:      time,
 		Host:      parts[1],
+		PID:       parts[2],
 		Service:   parts[3],
 		Component: parts[4],
 		Level:     parts[5],
@@ -69,10 +107,49 @@ func ReadLogEntries(r io.Reader) ([]LogEntry, error) {
 	return entries, nil
 }
 
-// LogLevel returns an int representing a named log level.
+// LogFilter selects log entries by their host, service and message. Empty fields match all entries.
+type LogFilter struct {
+	// Hosts holds the host names to match. A name matches the full host name of an entry, or its first label
+	Hosts []string
+	// Services holds the service names to match, e.g. container or searchnode
+	Services []string
+	// Pattern is matched against the dequoted message of an entry
+	Pattern *regexp.Regexp
+	// Invert selects entries not matching Pattern instead
+	Invert bool
+}
+
+// Matches returns whether entry le is selected by this filter.
+func (f LogFilter) Matches(le LogEntry) bool {
+	if len(f.Hosts) > 0 && !slices.ContainsFunc(f.Hosts, func(host string) bool { return le.Host == host || strings.HasPrefix(le.Host, host+".") }) {
+		return false
+	}
+	if len(f.Services) > 0 && !slices.Contains(f.Services, le.Service) {
+		return false
+	}
+	if f.Pattern != nil && f.Pattern.MatchString(dequoter.Replace(le.Message)) == f.Invert {
+		return false
+	}
+	return true
+}
+
+// LogEntryWriter writes log entries, e.g. to a file.
+type LogEntryWriter interface {
+	WriteEntry(entry LogEntry) error
+}
+
+// LogStats counts the log entries fetched, and the number of them which matched the level and filter in LogOptions.
+type LogStats struct {
+	Fetched int
+	Matched int
+}
+
+// LogLevel returns an int representing a named log level, where more severe levels have lower values.
 func LogLevel(name string) int {
 	switch name {
 	case "none":
+		return -2
+	case "fatal":
 		return -1
 	case "error":
 		return 0
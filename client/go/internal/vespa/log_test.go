@@ -2,6 +2,7 @@
 package vespa
 
 import (
+	"regexp"
 	"testing"
 	"time"
 
@@ -12,6 +13,7 @@ func TestParseLogEntry(t *testing.T) {
 	expected := LogEntry{
 		Time:      time.Date(2021, 9, 27, 10, 31, 30, 905535000, time.UTC),
 		Host:      "host1a.dev.aws-us-east-1c",
+		PID:       "806/53",
 		Service:   "logserver-container",
 		Component: "Container.com.yahoo.container.jdisc.ConfiguredApplication",
 		Level:     "info",
@@ -29,4 +31,30 @@ func TestParseLogEntry(t *testing.T) {
 	logEntry, err = ParseLogEntry(in)
 	assert.Nil(t, err)
 	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tmessage containing newline\nand\ttab", logEntry.Format(true))
+
+	json, err := logEntry.FormatJSON(true)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"time":"2021-09-27T10:31:30.905535Z","host":"host1a.dev.aws-us-east-1c","pid":"806/53","service":"logserver-container","component":"Container.com.yahoo.container.jdisc.ConfiguredApplication","level":"info","message":"message containing newline\nand\ttab"}`, json)
+
+	logEntry.Zone = "dev.aws-us-east-1c"
+	assert.Equal(t, "[2021-09-27 10:31:30.905535] dev.aws-us-east-1c host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tmessage containing newline\\nand\\ttab", logEntry.Format(false))
+	json, err = logEntry.FormatAs(true, false)
+	assert.Nil(t, err)
+	assert.Contains(t, json, `"message":"message containing newline\\nand\\ttab","zone":"dev.aws-us-east-1c"}`)
+}
+
+func TestLogFilter(t *testing.T) {
+	entry := LogEntry{Host: "host1a.dev.aws-us-east-1c", Service: "searchnode", Message: "Query failed:\\nTimeout"}
+	assert.True(t, LogFilter{}.Matches(entry))
+	assert.True(t, LogFilter{Hosts: []string{"host2", "host1a"}}.Matches(entry))
+	assert.True(t, LogFilter{Hosts: []string{"host1a.dev.aws-us-east-1c"}}.Matches(entry))
+	assert.False(t, LogFilter{Hosts: []string{"host1"}}.Matches(entry))
+	assert.True(t, LogFilter{Services: []string{"searchnode"}}.Matches(entry))
+	assert.False(t, LogFilter{Services: []string{"container"}}.Matches(entry))
+	assert.True(t, LogFilter{Pattern: regexp.MustCompile("failed:\nTime")}.Matches(entry))
+	assert.False(t, LogFilter{Pattern: regexp.MustCompile("timeout")}.Matches(entry))
+	assert.True(t, LogFilter{Pattern: regexp.MustCompile("(?i)timeout")}.Matches(entry))
+	assert.False(t, LogFilter{Pattern: regexp.MustCompile("Timeout"), Invert: true}.Matches(entry))
+	assert.True(t, LogFilter{Pattern: regexp.MustCompile("Success"), Invert: true}.Matches(entry))
+	assert.False(t, LogFilter{Services: []string{"searchnode"}, Pattern: regexp.MustCompile("Success")}.Matches(entry))
 }
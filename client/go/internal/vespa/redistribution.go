@@ -0,0 +1,269 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metrics of distributors counting the buckets and operations which remain before a content cluster is in its ideal
+// state.
+const (
+	MetricBuckets              = "vds.idealstate.buckets"
+	MetricBucketsTooFewCopies  = "vds.idealstate.buckets_toofewcopies"
+	MetricBucketsTooManyCopies = "vds.idealstate.buckets_toomanycopies"
+	MetricBucketsNotTrusted    = "vds.idealstate.buckets_notrusted"
+	MetricIdealStateDiff       = "vds.idealstate.idealstate_diff"
+	MetricMergesPending        = "vds.idealstate.merge_bucket.pending"
+)
+
+var redistributionMetrics = []string{
+	MetricBuckets,
+	MetricBucketsTooFewCopies,
+	MetricBucketsTooManyCopies,
+	MetricBucketsNotTrusted,
+	MetricIdealStateDiff,
+	MetricMergesPending,
+}
+
+// Redistribution is the state of bucket redistribution in a content cluster, as reported by its cluster controller and
+// distributors.
+type Redistribution struct {
+	Cluster string
+	// State is the state of the cluster published by its cluster controller, e.g. "up"
+	State string
+	// Distributors is the number of distributors whose metrics are included in Counters
+	Distributors int
+	// Counters holds the last value of each of the redistribution metrics, summed over distributors and bucket spaces
+	Counters map[string]int64
+}
+
+// PendingBuckets returns the number of buckets which have too few or too many replicas.
+func (r Redistribution) PendingBuckets() int64 {
+	return r.Counters[MetricBucketsTooFewCopies] + r.Counters[MetricBucketsTooManyCopies]
+}
+
+// PendingMerges returns the number of merge operations pending in distributors.
+func (r Redistribution) PendingMerges() int64 { return r.Counters[MetricMergesPending] }
+
+// Done returns whether the cluster is up, and all its buckets are in their ideal state.
+func (r Redistribution) Done() bool {
+	return r.State == "up" && r.PendingBuckets() == 0 && r.PendingMerges() == 0 && r.Counters[MetricIdealStateDiff] == 0
+}
+
+// RedistributionTarget is implemented by targets which can read the state of bucket redistribution in their content
+// clusters.
+type RedistributionTarget interface {
+	// AwaitRedistribution reads the redistribution state of the given content cluster until it is done, or timeout
+	// elapses, and returns the last state read. If timeout is zero, the state is read once. If cluster is empty, and
+	// the deployment has a single content cluster, that cluster is used. Progress, if non-nil, is called with each
+	// state read.
+	AwaitRedistribution(cluster string, timeout time.Duration, progress func(Redistribution)) (Redistribution, error)
+}
+
+// ContentClusterTarget is implemented by targets which can list the content clusters of their deployment.
+type ContentClusterTarget interface {
+	// ContentClusters returns the names of the content clusters of the deployment, sorted.
+	ContentClusters() ([]string, error)
+}
+
+// ErrContentCluster is matched by errors caused by a content cluster which is not given, or not found.
+var ErrContentCluster = errors.New("invalid content cluster")
+
+// contentClusterError is an error in choosing the content cluster, which waiting does not resolve.
+type contentClusterError struct{ error }
+
+func (e contentClusterError) Is(target error) bool { return target == ErrContentCluster }
+
+// clusterControllerState is the state of a content cluster, as returned by the state API of cluster controllers.
+type clusterControllerState struct {
+	State struct {
+		Generated struct {
+			State  string `json:"state"`
+			Reason string `json:"reason"`
+		} `json:"generated"`
+	} `json:"state"`
+}
+
+// stateMetrics is the response of the metrics of the state API of a Vespa service.
+type stateMetrics struct {
+	Metrics struct {
+		Values []struct {
+			Name       string             `json:"name"`
+			Values     map[string]float64 `json:"values"`
+			Dimensions map[string]string  `json:"dimensions"`
+		} `json:"values"`
+	} `json:"metrics"`
+}
+
+func (t *customTarget) AwaitRedistribution(cluster string, timeout time.Duration, progress func(Redistribution)) (Redistribution, error) {
+	deployService, err := t.DeployService()
+	if err != nil {
+		return Redistribution{}, err
+	}
+	deadline := t.now().Add(timeout)
+	for {
+		r, err := t.redistribution(cluster)
+		if err == nil && progress != nil {
+			progress(r)
+		}
+		if errors.Is(err, ErrContentCluster) {
+			return r, err
+		}
+		if (err == nil && r.Done()) || timeout == 0 || deadline.Sub(t.now()) < t.retryInterval {
+			return r, err
+		}
+		if err := deployService.sleep(t.retryInterval); err != nil {
+			return r, err
+		}
+	}
+}
+
+func (t *customTarget) ContentClusters() ([]string, error) {
+	status, err := t.serviceStatus(AnyDeployment, 0)
+	if err != nil {
+		return nil, err
+	}
+	var clusters []string
+	for _, s := range status.Services {
+		if s.Type == "distributor" && !slices.Contains(clusters, s.ClusterName) {
+			clusters = append(clusters, s.ClusterName)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// redistribution reads the state of the given content cluster from its cluster controllers, and the redistribution
+// metrics of its distributors.
+func (t *customTarget) redistribution(cluster string) (Redistribution, error) {
+	status, err := t.serviceStatus(AnyDeployment, 0)
+	if err != nil {
+		return Redistribution{}, err
+	}
+	var (
+		controllers  []serviceInfo
+		distributors = make(map[string][]serviceInfo)
+	)
+	for _, s := range status.Services {
+		switch s.Type {
+		case "container-clustercontroller":
+			controllers = append(controllers, s)
+		case "distributor":
+			distributors[s.ClusterName] = append(distributors[s.ClusterName], s)
+		}
+	}
+	clusters := make([]string, 0, len(distributors))
+	for name := range distributors {
+		clusters = append(clusters, name)
+	}
+	sort.Strings(clusters)
+	if cluster == "" && len(clusters) == 1 {
+		cluster = clusters[0]
+	}
+	if _, ok := distributors[cluster]; !ok {
+		if len(clusters) == 0 {
+			return Redistribution{}, contentClusterError{fmt.Errorf("no content clusters found in deployment")}
+		}
+		if cluster == "" {
+			return Redistribution{}, contentClusterError{fmt.Errorf("no content cluster given: must be one of %s", strings.Join(clusters, ", "))}
+		}
+		return Redistribution{}, contentClusterError{fmt.Errorf("content cluster %s not found: must be one of %s", cluster, strings.Join(clusters, ", "))}
+	}
+	r := Redistribution{Cluster: cluster, Counters: make(map[string]int64)}
+	for _, name := range redistributionMetrics {
+		r.Counters[name] = 0
+	}
+	if r.State, err = t.clusterState(cluster, controllers); err != nil {
+		return Redistribution{}, err
+	}
+	for _, d := range distributors[cluster] {
+		if err := t.addDistributorMetrics(d, r.Counters); err != nil {
+			return Redistribution{}, err
+		}
+		r.Distributors++
+	}
+	return r, nil
+}
+
+// clusterState returns the state of cluster, as published by the first of controllers to answer.
+func (t *customTarget) clusterState(cluster string, controllers []serviceInfo) (string, error) {
+	if len(controllers) == 0 {
+		return "", fmt.Errorf("no cluster controllers found in deployment")
+	}
+	var errs []string
+	for _, c := range controllers {
+		var state clusterControllerState
+		if err := t.getServiceJSON(c, "/cluster/v2/"+url.PathEscape(cluster), &state); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return state.State.Generated.State, nil
+	}
+	return "", fmt.Errorf("could not get state of content cluster %s from its cluster controllers: %s", cluster, strings.Join(errs, ", "))
+}
+
+// addDistributorMetrics adds the last value of each redistribution metric of distributor d to counters.
+func (t *customTarget) addDistributorMetrics(d serviceInfo, counters map[string]int64) error {
+	var metrics stateMetrics
+	if err := t.getServiceJSON(d, "/state/v1/metrics", &metrics); err != nil {
+		return fmt.Errorf("could not get metrics of distributor: %w", err)
+	}
+	for _, m := range metrics.Metrics.Values {
+		if _, ok := counters[m.Name]; ok {
+			counters[m.Name] += int64(m.Values["last"])
+		}
+	}
+	return nil
+}
+
+// getServiceJSON sends a single request for path to service s, and decodes its response into v.
+func (t *customTarget) getServiceJSON(s serviceInfo, path string, v any) error {
+	baseURL, err := t.serviceURL(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	decodeFunc := func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			return ok, err
+		}
+		return true, json.Unmarshal(response, v)
+	}
+	if _, err := wait(t.newService(baseURL, "", false), decodeFunc, func() *http.Request { return req }, 0, t.retryInterval); err != nil {
+		return fmt.Errorf("%s: %w", baseURL, err)
+	}
+	return nil
+}
+
+// serviceURL returns the base URL of service s. Services of a local target are reached on the host of its config
+// server, while those of a custom target are reached on their own host, with the scheme of the config server.
+func (t *customTarget) serviceURL(s serviceInfo) (string, error) {
+	if t.targetType == TargetLocal {
+		u, err := t.urlWithPort(s.Port)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+	configServerURL, err := t.customURL()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(configServerURL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s://%s:%d", u.Scheme, s.Host, s.Port), nil
+}
@@ -0,0 +1,408 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobRun is the run of a deployment job, such as system-test or production-aws-us-east-1c, for a given build.
+type JobRun struct {
+	Instance string
+	Job      string
+	// ID is the ID of the run, or 0 if the job has not yet started running the build
+	ID int64
+	// Status is "pending" if the job has not yet started, "running" while the run is active, and "success" or the
+	// reason for failure when it has ended
+	Status string
+	// Step is the step the run is currently in, or the step it ended in
+	Step string
+	// Build is the build deployed by this run
+	Build int64
+	// Start and End are the times this run started and ended, if known
+	Start time.Time
+	End   time.Time
+}
+
+// Pending returns whether this job has not yet started running the build.
+func (r JobRun) Pending() bool { return r.Status == "pending" }
+
+// Active returns whether this run is currently running.
+func (r JobRun) Active() bool { return r.Status == "running" }
+
+// Failed returns whether this run has ended unsuccessfully.
+func (r JobRun) Failed() bool { return !r.Pending() && !r.Active() && r.Status != "success" }
+
+// RunLogEntry is an entry in the log of a job run.
+type RunLogEntry struct {
+	Time    time.Time
+	Step    string
+	Level   string
+	Message string
+}
+
+// RunLog is the part of the log of a job run following a given entry.
+type RunLog struct {
+	Entries []RunLogEntry
+	// LastID is the ID of the last entry, to use when requesting subsequent entries
+	LastID int64
+}
+
+// JobStatus is the status of a deployment job of an application, such as a production deployment to a zone.
+type JobStatus struct {
+	Instance string
+	Job      string
+	// Zone is the zone this job deploys to. Empty for test jobs
+	Zone ZoneID
+	// Build is the build currently deployed by this job, or 0 if none
+	Build int64
+	// Version is the Vespa version currently deployed by this job, if any
+	Version string
+	// DeployedAt is when the current build was deployed, if known
+	DeployedAt time.Time
+	// LastRun is the most recent run of this job, or nil if it has never run
+	LastRun *JobRun
+}
+
+// ChangeBlocker is a time window in which changes to an application instance are blocked.
+type ChangeBlocker struct {
+	Instance string
+	// Versions is whether Vespa version upgrades are blocked
+	Versions bool
+	// Revisions is whether application revisions, i.e. new builds, are blocked
+	Revisions bool
+	Days      []string
+	Hours     []int
+	TimeZone  string
+}
+
+// BlocksRevisionsAt returns whether this blocks application revisions at time t. Empty days or hours mean all days or
+// hours.
+func (b ChangeBlocker) BlocksRevisionsAt(t time.Time) bool {
+	return b.blocksRevisionsAt(t, b.location())
+}
+
+func (b ChangeBlocker) blocksRevisionsAt(t time.Time, location *time.Location) bool {
+	if !b.Revisions {
+		return false
+	}
+	t = t.In(location)
+	if len(b.Days) > 0 && !slices.Contains(b.Days, strings.ToLower(t.Weekday().String()[:3])) {
+		return false
+	}
+	return len(b.Hours) == 0 || slices.Contains(b.Hours, t.Hour())
+}
+
+// location returns the time zone of the window of this, or UTC if it is unknown.
+func (b ChangeBlocker) location() *time.Location {
+	location, err := time.LoadLocation(b.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// RevisionsBlockedUntil returns whether blockers block application revisions of instance at time now, and if so, the
+// time they next stop doing so. The time is zero if they never do.
+func RevisionsBlockedUntil(blockers []ChangeBlocker, instance string, now time.Time) (time.Time, bool) {
+	var (
+		windows   []ChangeBlocker
+		locations []*time.Location
+	)
+	for _, b := range blockers {
+		if b.Instance == instance && b.Revisions {
+			windows = append(windows, b)
+			locations = append(locations, b.location())
+		}
+	}
+	blockedAt := func(t time.Time) bool {
+		for i, b := range windows {
+			if b.blocksRevisionsAt(t, locations[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	if !blockedAt(now) {
+		return time.Time{}, false
+	}
+	// Windows are given in whole hours of their time zones, so the blockers repeat every week, and can only stop
+	// blocking at the start of an hour in one of the zones
+	for t := nextHour(now, locations); t.Before(now.Add(8 * 24 * time.Hour)); t = nextHour(t, locations) {
+		if !blockedAt(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, true
+}
+
+// nextHour returns the earliest start of an hour after t, by the wall clock of any of the given locations.
+func nextHour(t time.Time, locations []*time.Location) time.Time {
+	var next time.Time
+	for _, location := range locations {
+		local := t.In(location)
+		// Subtract the time into the hour, as offsets of zones are not always whole hours
+		start := t.Add(-time.Duration(local.Minute())*time.Minute - time.Duration(local.Second())*time.Second - time.Duration(local.Nanosecond()))
+		if h := start.Add(time.Hour); next.IsZero() || h.Before(next) {
+			next = h
+		}
+	}
+	return next
+}
+
+// RolloutStatus is the status of the rollout of a build to the production zones of an instance.
+type RolloutStatus struct {
+	Instance string
+	Build    int64
+	// Blocked is whether rollout of the build is held back, normally until a change blocker window ends
+	Blocked bool
+	// OpensAt is when the build may next roll out, if it is blocked. Zero if this is not known
+	OpensAt time.Time
+}
+
+// GetRolloutStatus returns the status of the rollout of build to the instance of target, as of time now. The build is
+// blocked if it is pending, i.e., held back, for the instance, or if change blockers of the instance block application
+// revisions at time now.
+func GetRolloutStatus(target Target, build int64, now time.Time) (RolloutStatus, error) {
+	response, err := getDeploymentStatus(target)
+	if err != nil {
+		return RolloutStatus{}, err
+	}
+	instance := target.Deployment().Application.Instance
+	status := RolloutStatus{Instance: instance, Build: build}
+	var blockers []ChangeBlocker
+	pending := false
+	for _, step := range response.Steps {
+		if step.Type != "instance" || step.Instance != instance {
+			continue
+		}
+		for _, b := range step.ChangeBlockers {
+			blockers = append(blockers, ChangeBlocker{Instance: instance, Revisions: b.Revisions, Days: b.Window.Days, Hours: b.Window.Hours, TimeZone: b.Window.Zone})
+		}
+		pending = pending || step.OutstandingChange.Application.Build == build
+	}
+	opensAt, blocked := RevisionsBlockedUntil(blockers, instance, now)
+	status.Blocked = blocked || pending
+	status.OpensAt = opensAt
+	return status, nil
+}
+
+// ProdStatus is the deployment status of all instances of an application.
+type ProdStatus struct {
+	Jobs           []JobStatus
+	ChangeBlockers []ChangeBlocker
+}
+
+type deploymentStatusResponse struct {
+	Steps []deploymentStatusStep `json:"steps"`
+}
+
+type deploymentStatusStep struct {
+	Type               string `json:"type"`
+	JobName            string `json:"jobName"`
+	Instance           string `json:"instance"`
+	Environment        string `json:"environment"`
+	Region             string `json:"region"`
+	CurrentPlatform    string `json:"currentPlatform"`
+	CurrentApplication struct {
+		Build int64 `json:"build"`
+	} `json:"currentApplication"`
+	DeployedAt     int64 `json:"deployedAt"`
+	ChangeBlockers []struct {
+		Versions  bool `json:"versions"`
+		Revisions bool `json:"revisions"`
+		Window    struct {
+			Days  []string `json:"days"`
+			Hours []int    `json:"hours"`
+			Zone  string   `json:"zone"`
+		} `json:"window"`
+	} `json:"changeBlockers"`
+	Runs []deploymentStatusRun `json:"runs"`
+	// OutstandingChange is the change which is pending for an instance, i.e., not yet deploying to it
+	OutstandingChange struct {
+		Application struct {
+			Build int64 `json:"build"`
+		} `json:"application"`
+	} `json:"outstandingChange"`
+}
+
+type deploymentStatusRun struct {
+	ID       int64  `json:"id"`
+	Status   string `json:"status"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Versions struct {
+		TargetApplication struct {
+			Build int64 `json:"build"`
+		} `json:"targetApplication"`
+	} `json:"versions"`
+	Steps []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"steps"`
+}
+
+func (r deploymentStatusRun) toRun(step deploymentStatusStep) JobRun {
+	run := JobRun{Instance: step.Instance, Job: step.JobName, ID: r.ID, Status: r.Status, Build: r.Versions.TargetApplication.Build}
+	if r.Start > 0 {
+		run.Start = time.UnixMilli(r.Start)
+	}
+	if r.End > 0 {
+		run.End = time.UnixMilli(r.End)
+	}
+	for _, s := range r.Steps {
+		run.Step = s.Name
+		if s.Status == "unfinished" || s.Status == "failed" {
+			break
+		}
+	}
+	return run
+}
+
+func getDeploymentStatus(target Target) (deploymentStatusResponse, error) {
+	var response deploymentStatusResponse
+	if !target.IsCloud() {
+		return response, fmt.Errorf("deployment status is unsupported by %s target", target.Type())
+	}
+	err := getJSON(target, target.Deployment().System.DeploymentStatusURL(target.Deployment().Application), &response)
+	return response, err
+}
+
+// GetProdStatus returns the deployment status of the application in target, with jobs sorted by instance and job.
+func GetProdStatus(target Target) (ProdStatus, error) {
+	response, err := getDeploymentStatus(target)
+	if err != nil {
+		return ProdStatus{}, err
+	}
+	var status ProdStatus
+	for _, step := range response.Steps {
+		for _, b := range step.ChangeBlockers {
+			status.ChangeBlockers = append(status.ChangeBlockers, ChangeBlocker{
+				Instance:  step.Instance,
+				Versions:  b.Versions,
+				Revisions: b.Revisions,
+				Days:      b.Window.Days,
+				Hours:     b.Window.Hours,
+				TimeZone:  b.Window.Zone,
+			})
+		}
+		if step.JobName == "" {
+			continue // Not a job
+		}
+		job := JobStatus{Instance: step.Instance, Job: step.JobName, Build: step.CurrentApplication.Build, Version: step.CurrentPlatform}
+		if step.Type == "deployment" {
+			job.Zone = ZoneID{Environment: step.Environment, Region: step.Region}
+		}
+		if step.DeployedAt > 0 {
+			job.DeployedAt = time.UnixMilli(step.DeployedAt)
+		}
+		for _, r := range step.Runs {
+			if job.LastRun == nil || r.ID > job.LastRun.ID {
+				run := r.toRun(step)
+				job.LastRun = &run
+			}
+		}
+		status.Jobs = append(status.Jobs, job)
+	}
+	sort.SliceStable(status.Jobs, func(i, j int) bool {
+		return lessJob(status.Jobs[i].Instance, status.Jobs[i].Job, status.Jobs[j].Instance, status.Jobs[j].Job)
+	})
+	return status, nil
+}
+
+// BuildRuns returns the runs of all jobs deploying build of the application in target, sorted by instance and job.
+// Jobs which have not yet run build are included as pending.
+func BuildRuns(target Target, build int64) ([]JobRun, error) {
+	response, err := getDeploymentStatus(target)
+	if err != nil {
+		return nil, err
+	}
+	var runs []JobRun
+	for _, step := range response.Steps {
+		if step.JobName == "" {
+			continue // Not a job
+		}
+		run := JobRun{Instance: step.Instance, Job: step.JobName, Status: "pending", Build: build}
+		for _, r := range step.Runs {
+			if r.Versions.TargetApplication.Build == build && r.ID > run.ID {
+				run = r.toRun(step)
+			}
+		}
+		runs = append(runs, run)
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return lessJob(runs[i].Instance, runs[i].Job, runs[j].Instance, runs[j].Job) })
+	return runs, nil
+}
+
+// lessJob returns whether the job named job1 of instance1 should be ordered before job2 of instance2.
+func lessJob(instance1, job1, instance2, job2 string) bool {
+	if instance1 != instance2 {
+		return instance1 < instance2
+	}
+	return jobOrder(job1) < jobOrder(job2)
+}
+
+// jobOrder returns the order in which jobs normally run: tests before production.
+func jobOrder(job string) int {
+	switch job {
+	case "system-test":
+		return 0
+	case "staging-test":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// JobRunLog returns the log entries of run which follow the entry with ID after. Use -1 to get all entries.
+func JobRunLog(target Target, run JobRun, after int64) (RunLog, error) {
+	application := target.Deployment().Application
+	application.Instance = run.Instance
+	runURL := target.Deployment().System.JobRunURL(application, run.Job, run.ID) + "?after=" + strconv.FormatInt(after, 10)
+	var response runResponse
+	if err := getJSON(target, runURL, &response); err != nil {
+		return RunLog{}, err
+	}
+	log := RunLog{LastID: after}
+	if response.LastID > 0 {
+		log.LastID = response.LastID
+	}
+	for step, messages := range response.Log {
+		for _, msg := range messages {
+			log.Entries = append(log.Entries, RunLogEntry{Time: time.UnixMilli(msg.At), Step: step, Level: msg.Type, Message: msg.Message})
+		}
+	}
+	sort.SliceStable(log.Entries, func(i, j int) bool { return log.Entries[i].Time.Before(log.Entries[j].Time) })
+	return log, nil
+}
+
+func getJSON(target Target, url string, v any) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	var parseErr error
+	status, err := deployRequest(target, func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			return ok, err
+		}
+		parseErr = json.Unmarshal(response, v)
+		return true, nil
+	}, func() *http.Request { return req }, 0, 0)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", url, err)
+	}
+	if status/100 != 2 {
+		return fmt.Errorf("request to %s failed: got status %d", url, status)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("could not parse response from %s: %w", url, parseErr)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/internal/mock"
+)
+
+func TestBuildRuns(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	client.NextResponse(mock.HTTPResponse{
+		URI:    "/application/v4/tenant/t1/application/a1/deployment",
+		Status: 200,
+		Body: []byte(`{"steps": [
+  {"type": "instance", "instance": "i1"},
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "runs": []},
+  {"type": "test", "jobName": "staging-test", "instance": "i1", "runs": [
+    {"id": 2, "status": "success", "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployReal", "status": "succeeded"}, {"name": "endTests", "status": "succeeded"}]}
+  ]},
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": [
+    {"id": 5, "status": "running", "versions": {"targetApplication": {"build": 43}}},
+    {"id": 4, "status": "running", "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployTester", "status": "succeeded"}, {"name": "runTests", "status": "unfinished"}, {"name": "endTests", "status": "unfinished"}]}
+  ]}
+]}`),
+	})
+	runs, err := BuildRuns(target, 42)
+	require.Nil(t, err)
+	assert.Equal(t, []JobRun{
+		{Instance: "i1", Job: "system-test", ID: 4, Status: "running", Step: "runTests", Build: 42},
+		{Instance: "i1", Job: "staging-test", ID: 2, Status: "success", Step: "endTests", Build: 42},
+		{Instance: "i1", Job: "production-aws-us-east-1c", Status: "pending", Build: 42},
+	}, runs)
+	assert.True(t, runs[0].Active())
+	assert.False(t, runs[1].Failed())
+	assert.True(t, runs[2].Pending())
+	assert.True(t, JobRun{Status: "deploymentFailed"}.Failed())
+
+	client.NextResponse(mock.HTTPResponse{
+		URI:    "/application/v4/tenant/t1/application/a1/instance/i1/job/system-test/run/4?after=-1",
+		Status: 200,
+		Body:   []byte(`{"active": true, "status": "running", "lastId": 3, "log": {"runTests": [{"at": 2000, "type": "info", "message": "second"}], "deployTester": [{"at": 1000, "type": "info", "message": "first"}]}}`),
+	})
+	log, err := JobRunLog(target, runs[0], -1)
+	require.Nil(t, err)
+	assert.Equal(t, int64(3), log.LastID)
+	require.Equal(t, 2, len(log.Entries))
+	assert.Equal(t, "first", log.Entries[0].Message)
+	assert.Equal(t, "deployTester", log.Entries[0].Step)
+	assert.Equal(t, "second", log.Entries[1].Message)
+
+	client.NextStatus(404)
+	_, err = BuildRuns(target, 42)
+	assert.NotNil(t, err)
+}
+
+func TestGetProdStatus(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	client.NextResponse(mock.HTTPResponse{
+		URI:    "/application/v4/tenant/t1/application/a1/deployment",
+		Status: 200,
+		Body: []byte(`{"steps": [
+  {"type": "instance", "instance": "i1", "changeBlockers": [{"versions": true, "revisions": false, "window": {"days": ["sat", "sun"], "hours": [0, 1, 2], "zone": "UTC"}}]},
+  {"type": "deployment", "jobName": "production-aws-us-east-1c", "instance": "i1", "environment": "prod", "region": "aws-us-east-1c",
+   "currentPlatform": "8.1.2", "currentApplication": {"build": 41}, "deployedAt": 1700000000000, "runs": [
+    {"id": 7, "status": "success", "start": 1700000000000, "end": 1700000300000, "versions": {"targetApplication": {"build": 41}}},
+    {"id": 8, "status": "deploymentFailed", "start": 1700001000000, "end": 1700001060000, "versions": {"targetApplication": {"build": 42}}, "steps": [{"name": "deployReal", "status": "failed"}]}
+  ]},
+  {"type": "test", "jobName": "system-test", "instance": "i1", "runs": []}
+]}`),
+	})
+	status, err := GetProdStatus(target)
+	require.Nil(t, err)
+	assert.Equal(t, []ChangeBlocker{{Instance: "i1", Versions: true, Days: []string{"sat", "sun"}, Hours: []int{0, 1, 2}, TimeZone: "UTC"}}, status.ChangeBlockers)
+	require.Equal(t, 2, len(status.Jobs))
+	assert.Equal(t, "system-test", status.Jobs[0].Job)
+	assert.Nil(t, status.Jobs[0].LastRun)
+	prod := status.Jobs[1]
+	assert.Equal(t, ZoneID{Environment: "prod", Region: "aws-us-east-1c"}, prod.Zone)
+	assert.Equal(t, int64(41), prod.Build)
+	assert.Equal(t, "8.1.2", prod.Version)
+	assert.Equal(t, time.UnixMilli(1700000000000), prod.DeployedAt)
+	require.NotNil(t, prod.LastRun)
+	assert.Equal(t, int64(8), prod.LastRun.ID)
+	assert.Equal(t, int64(42), prod.LastRun.Build)
+	assert.Equal(t, "deployReal", prod.LastRun.Step)
+	assert.True(t, prod.LastRun.Failed())
+	assert.Equal(t, time.Minute, prod.LastRun.End.Sub(prod.LastRun.Start))
+}
+
+func TestGetRolloutStatus(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	statusResponse := func(outstandingBuild int64) mock.HTTPResponse {
+		return mock.HTTPResponse{
+			URI:    "/application/v4/tenant/t1/application/a1/deployment",
+			Status: 200,
+			Body: []byte(fmt.Sprintf(`{"steps": [
+  {"type": "instance", "instance": "i1", "outstandingChange": {"application": {"build": %d}}, "changeBlockers": [
+    {"versions": true, "revisions": false, "window": {"days": ["mon", "tue", "wed", "thu", "fri"], "hours": [8, 9, 10], "zone": "UTC"}},
+    {"versions": false, "revisions": true, "window": {"days": ["sat", "sun"], "hours": [0, 1, 2, 3, 22, 23], "zone": "Europe/Oslo"}}
+  ]},
+  {"type": "instance", "instance": "i2", "changeBlockers": [{"versions": false, "revisions": true, "window": {"days": [], "hours": [], "zone": "UTC"}}]}
+]}`, outstandingBuild)),
+		}
+	}
+	// Saturday 2024-01-06 at 01:30 in Oslo
+	saturday := time.Date(2024, 1, 6, 0, 30, 0, 0, time.UTC)
+	client.NextResponse(statusResponse(0))
+	status, err := GetRolloutStatus(target, 42, saturday)
+	require.Nil(t, err)
+	assert.Equal(t, RolloutStatus{Instance: "i1", Build: 42, Blocked: true, OpensAt: time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC)}, status)
+
+	// Monday morning, when only version upgrades are blocked
+	monday := time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)
+	client.NextResponse(statusResponse(0))
+	status, err = GetRolloutStatus(target, 42, monday)
+	require.Nil(t, err)
+	assert.Equal(t, RolloutStatus{Instance: "i1", Build: 42}, status)
+
+	// Pending changes are blocked, also outside windows
+	client.NextResponse(statusResponse(42))
+	status, err = GetRolloutStatus(target, 42, monday)
+	require.Nil(t, err)
+	assert.Equal(t, RolloutStatus{Instance: "i1", Build: 42, Blocked: true}, status)
+
+	// A window without days and hours is always blocking
+	blockers := []ChangeBlocker{{Instance: "i2", Revisions: true, TimeZone: "UTC"}}
+	opensAt, blocked := RevisionsBlockedUntil(blockers, "i2", monday)
+	assert.True(t, blocked)
+	assert.True(t, opensAt.IsZero())
+	_, blocked = RevisionsBlockedUntil(blockers, "i1", monday)
+	assert.False(t, blocked)
+
+	// Windows end at the start of an hour in their own time zone, such as 10:00 in India, at 04:30 UTC
+	blockers = []ChangeBlocker{
+		{Instance: "i1", Revisions: true, Hours: []int{9}, TimeZone: "Asia/Kolkata"},
+		{Instance: "i1", Revisions: true, Hours: []int{4}, TimeZone: "UTC"},
+	}
+	opensAt, blocked = RevisionsBlockedUntil(blockers, "i1", time.Date(2024, 1, 8, 4, 0, 0, 0, time.UTC))
+	assert.True(t, blocked)
+	assert.Equal(t, time.Date(2024, 1, 8, 5, 0, 0, 0, time.UTC), opensAt)
+	opensAt, blocked = RevisionsBlockedUntil(blockers[:1], "i1", time.Date(2024, 1, 8, 4, 0, 0, 0, time.UTC))
+	assert.True(t, blocked)
+	assert.Equal(t, time.Date(2024, 1, 8, 4, 30, 0, 0, time.UTC), opensAt)
+}
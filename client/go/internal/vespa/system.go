@@ -1,7 +1,11 @@
 // Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
 package vespa
 
-import "fmt"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
 
 // PublicSystem represents the main Vespa Cloud system.
 var PublicSystem = System{
@@ -93,10 +97,39 @@ func (s System) DeploymentURL(deployment Deployment) string {
 
 // RunURL returns the API URL for a given deployment job run.
 func (s System) RunURL(deployment Deployment, id int64) string {
+	return s.JobRunURL(deployment.Application, jobName(deployment.Zone), id)
+}
+
+// JobRunURL returns the API URL for a run of the named job, e.g. system-test or production-aws-us-east-1c.
+func (s System) JobRunURL(application ApplicationID, job string, id int64) string {
 	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/job/%s/run/%d",
-		s.URL,
-		deployment.Application.Tenant, deployment.Application.Application, deployment.Application.Instance,
-		jobName(deployment.Zone), id)
+		s.URL, application.Tenant, application.Application, application.Instance, job, id)
+}
+
+// DeploymentStatusURL returns the API URL for the deployment status of all instances of given application.
+func (s System) DeploymentStatusURL(application ApplicationID) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/deployment", s.URL, application.Tenant, application.Application)
+}
+
+// ApplicationURL returns the API URL of given application.
+func (s System) ApplicationURL(application ApplicationID) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s", s.URL, application.Tenant, application.Application)
+}
+
+// InstanceURL returns the API URL of given instance of an application.
+func (s System) InstanceURL(application ApplicationID) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s", s.URL, application.Tenant, application.Application, application.Instance)
+}
+
+// BuildsURL returns the API URL listing the builds submitted for given application.
+func (s System) BuildsURL(application ApplicationID) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/build", s.URL, application.Tenant, application.Application)
+}
+
+// DeployingApplicationURL returns the API URL for triggering deployment of a build to given instance.
+func (s System) DeployingApplicationURL(application ApplicationID) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/deploying/application",
+		s.URL, application.Tenant, application.Application, application.Instance)
 }
 
 // RunsURL returns the API URL listing all runs for given deployment.
@@ -122,7 +155,8 @@ func jobName(zone ZoneID) string {
 	return env + "-" + zone.Region
 }
 
-// GetSystem returns the system of given name.
+// GetSystem returns the system of given name. The name may also be the API URL of a system. A URL which is not that of
+// a known system gives a Vespa Cloud system using that URL, which is named by its host.
 func GetSystem(name string) (System, error) {
 	switch name {
 	case "cd":
@@ -134,5 +168,23 @@ func GetSystem(name string) (System, error) {
 	case "publiccd":
 		return PublicCDSystem, nil
 	}
+	if strings.HasPrefix(name, "https://") || strings.HasPrefix(name, "http://") {
+		u, err := url.Parse(name)
+		if err != nil || u.Host == "" {
+			return System{}, fmt.Errorf("invalid system URL: %s", name)
+		}
+		apiURL := strings.TrimSuffix(name, "/")
+		for _, system := range []System{PublicSystem, PublicCDSystem, MainSystem, CDSystem} {
+			if system.URL == apiURL {
+				return system, nil
+			}
+		}
+		return System{
+			Name:        u.Host,
+			URL:         apiURL,
+			DefaultZone: PublicSystem.DefaultZone,
+			TargetType:  TargetCloud,
+		}, nil
+	}
 	return System{}, fmt.Errorf("invalid system: %s", name)
 }
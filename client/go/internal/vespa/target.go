@@ -4,12 +4,13 @@ package vespa
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -64,6 +65,8 @@ type Authenticator interface {
 type CurlWriter struct {
 	Writer    io.Writer
 	InputFile string
+	// Redact holds the names of headers whose values are printed as redacted
+	Redact []string
 }
 
 func (c *CurlWriter) print(request *http.Request, tlsOptions TLSOptions, timeout time.Duration) error {
@@ -75,7 +78,7 @@ func (c *CurlWriter) print(request *http.Request, tlsOptions TLSOptions, timeout
 		return err
 	}
 	cmd.Method = request.Method
-	for k, vs := range request.Header {
+	for k, vs := range httputil.RedactHeader(request.Header, c.Redact) {
 		for _, v := range vs {
 			cmd.Header(k, v)
 		}
@@ -104,6 +107,8 @@ type Service struct {
 	httpClient    httputil.Client
 	customClient  bool
 	retryInterval time.Duration
+	// onStaleEndpoint is called when a request fails in a way indicating that BaseURL no longer exists
+	onStaleEndpoint func()
 }
 
 // Target represents a Vespa platform, running named Vespa services.
@@ -144,6 +149,66 @@ type Target interface {
 	ShowApplicationInstance(id ApplicationID, timeout time.Duration) (*CloudInstanceResponse, error)
 }
 
+// ServiceDetail describes the state of a single service, or node, of a deployment.
+type ServiceDetail struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	// Type is the type of the service, e.g. "container" or "searchnode", or the type of the cluster of a node
+	Type    string `json:"type"`
+	Cluster string `json:"cluster,omitempty"`
+	// CurrentGeneration and WantedGeneration are the config generations the service runs on, and should run on. These
+	// are zero when unknown, e.g. for nodes in Vespa Cloud
+	CurrentGeneration int64 `json:"currentGeneration,omitempty"`
+	WantedGeneration  int64 `json:"wantedGeneration,omitempty"`
+	// State is the state of the node in the node repository, e.g. "active", if known
+	State string `json:"state,omitempty"`
+	// CurrentVersion and WantedVersion are the Vespa versions the node runs, and should run, if known
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	WantedVersion  string `json:"wantedVersion,omitempty"`
+	// RestartPending is whether the service awaits a restart, or nil if this is unknown
+	RestartPending *bool `json:"restartPending,omitempty"`
+}
+
+// Converged returns whether the service runs on the wanted config generation and Vespa version, is active, and awaits
+// no restart.
+func (d ServiceDetail) Converged() bool {
+	if d.CurrentGeneration < d.WantedGeneration || d.CurrentVersion != d.WantedVersion {
+		return false
+	}
+	if d.State != "" && d.State != "active" {
+		return false
+	}
+	return d.RestartPending == nil || !*d.RestartPending
+}
+
+// DetailTarget is implemented by targets which can describe the state of each service of a deployment.
+type DetailTarget interface {
+	// ServiceDetails returns the state of each service of the current deployment, with services which have not
+	// converged first. A single request is sent, without retrying on failure.
+	ServiceDetails() ([]ServiceDetail, error)
+}
+
+// sortServiceDetails sorts details such that services which have not converged come first, and then by host, cluster,
+// type and port.
+func sortServiceDetails(details []ServiceDetail) {
+	sort.SliceStable(details, func(i, j int) bool {
+		a, b := details[i], details[j]
+		if a.Converged() != b.Converged() {
+			return !a.Converged()
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Port < b.Port
+	})
+}
+
 // TLSOptions holds the client certificate to use for cloud API or service requests.
 type TLSOptions struct {
 	KeyPair  []tls.Certificate
@@ -164,8 +229,19 @@ type LogOptions struct {
 	To      time.Time
 	Follow  bool
 	Dequote bool
-	Writer  io.Writer
-	Level   int
+	// Writer is where entries are printed, if non-nil
+	Writer io.Writer
+	// JSON makes entries be printed to Writer as JSON objects, one per line, instead of as text
+	JSON bool
+	// ErrWriter is where notes on lost and re-established connections to the log service are written when following
+	// logs, if non-nil
+	ErrWriter io.Writer
+	Level     int
+	Filter    LogFilter
+	// Stats is updated with the number of entries fetched and printed, if non-nil
+	Stats *LogStats
+	// EntryWriter receives each printed entry in addition to Writer, if non-nil
+	EntryWriter LogEntryWriter
 }
 
 // Do sends request to this service. Authentication of the request happens automatically.
@@ -183,12 +259,26 @@ func (s *Service) Do(request *http.Request, timeout time.Duration) (*http.Respon
 		return nil, err
 	}
 	resp, err := s.httpClient.Do(request, timeout)
+	if s.onStaleEndpoint != nil && isStaleEndpoint(request, resp, err) {
+		s.onStaleEndpoint()
+	}
 	if isTLSAlert(err) {
 		return nil, fmt.Errorf("%w: %s", errAuth, err)
 	}
 	return resp, err
 }
 
+// UseOwnClient makes this service send requests with its own copy of its HTTP client, configured once with the TLS
+// options of this service, such that it can be used concurrently with other services sharing the client.
+func (s *Service) UseOwnClient() {
+	if s.customClient {
+		return
+	}
+	client := httputil.Clone(s.httpClient)
+	httputil.ConfigureTLS(client, s.TLSOptions.KeyPair, s.TLSOptions.CACertificatePEM, s.TLSOptions.TrustAll)
+	s.SetClient(client)
+}
+
 // SetClient sets a custom HTTP client that this service should use.
 func (s *Service) SetClient(client httputil.Client) {
 	s.httpClient = client
@@ -197,11 +287,17 @@ func (s *Service) SetClient(client httputil.Client) {
 
 // Wait polls the health check of this service until it succeeds or timeout passes.
 func (s *Service) Wait(timeout time.Duration) error {
+	_, err := s.WaitStatus(timeout)
+	return err
+}
+
+// WaitStatus works like Wait, but also returns the last HTTP status received from the health check, if any.
+func (s *Service) WaitStatus(timeout time.Duration) (int, error) {
 	// A path that does not need authentication, on any target
 	url := strings.TrimRight(s.BaseURL, "/") + "/status.html"
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	okFunc := func(status int, response []byte) (bool, error) {
 		// Always retry 404 as /status.html may return 404 while a cluster is becoming ready
@@ -216,9 +312,9 @@ func (s *Service) Wait(timeout time.Duration) error {
 		if status > 0 {
 			statusDesc = fmt.Sprintf(": status %d", status)
 		}
-		return fmt.Errorf("unhealthy %s%s%s at %s: %w", s.Description(), waitDescription(timeout), statusDesc, url, err)
+		return status, fmt.Errorf("unhealthy %s%s%s at %s: %w", s.Description(), waitDescription(timeout), statusDesc, url, err)
 	}
-	return nil
+	return status, nil
 }
 
 func (s *Service) Description() string {
@@ -242,11 +338,31 @@ func FindService(name string, authMethod string, services []*Service) (*Service,
 	if name == "" && len(applicableServices) == 1 {
 		return applicableServices[0], nil
 	}
-	names := make([]string, 0, len(services))
 	for _, s := range services {
 		if name == s.Name {
 			return s, nil
 		}
+	}
+	return nil, serviceNotFoundErr(name, services)
+}
+
+// FindServices returns all services of given name, one for each of their authentication methods, found among services.
+func FindServices(name string, services []*Service) ([]*Service, error) {
+	var found []*Service
+	for _, s := range services {
+		if name == s.Name {
+			found = append(found, s)
+		}
+	}
+	if len(found) == 0 {
+		return nil, serviceNotFoundErr(name, services)
+	}
+	return found, nil
+}
+
+func serviceNotFoundErr(name string, services []*Service) error {
+	names := make([]string, 0, len(services))
+	for _, s := range services {
 		prettyName := color.CyanString("%s", s.Name)
 		if s.AuthMethod != "" {
 			prettyName = fmt.Sprintf("%s (%s)", prettyName, s.AuthMethod)
@@ -258,9 +374,9 @@ func FindService(name string, authMethod string, services []*Service) (*Service,
 		found = "known services: " + strings.Join(names, ", ")
 	}
 	if name != "" {
-		return nil, fmt.Errorf("no such service: %q: %s", name, found)
+		return fmt.Errorf("no such service: %q: %s", name, found)
 	}
-	return nil, fmt.Errorf("no service specified: %s", found)
+	return fmt.Errorf("no service specified: %s", found)
 }
 
 func waitDescription(d time.Duration) string {
@@ -290,16 +406,18 @@ func deployRequest(target Target, fn responseFunc, reqFn requestFunc, timeout, r
 	return wait(deployService, fn, reqFn, timeout, retryInterval)
 }
 
+// maxLogRetryInterval is the maximum interval between attempts to reconnect to the log service when following logs.
+const maxLogRetryInterval = time.Minute
+
 func pollLogs(target Target, logsURL string, options LogOptions, retryInterval time.Duration) error {
 	req, err := http.NewRequest("GET", logsURL, nil)
 	if err != nil {
 		return err
 	}
-	lastFrom := options.From
+	printer := logPrinter{options: options, lastTime: options.From}
 	requestFunc := func() *http.Request {
-		fromMillis := lastFrom.Unix() * 1000
 		q := req.URL.Query()
-		q.Set("from", strconv.FormatInt(fromMillis, 10))
+		q.Set("from", strconv.FormatInt(printer.lastTime.UnixMilli(), 10))
 		if !options.To.IsZero() {
 			toMillis := options.To.Unix() * 1000
 			q.Set("to", strconv.FormatInt(toMillis, 10))
@@ -307,35 +425,141 @@ func pollLogs(target Target, logsURL string, options LogOptions, retryInterval t
 		req.URL.RawQuery = q.Encode()
 		return req
 	}
+	if options.Follow {
+		return followLogs(target, &printer, requestFunc, retryInterval)
+	}
 	logFunc := func(status int, response []byte) (bool, error) {
 		if ok, err := isOK(status); !ok {
 			return ok, err
 		}
-		logEntries, err := ReadLogEntries(bytes.NewReader(response))
-		if err != nil {
+		if err := printer.print(response); err != nil {
 			return false, err
 		}
-		for _, le := range logEntries {
-			if !le.Time.After(lastFrom) {
-				continue
+		return false, nil
+	}
+	// Ignore wait error because logFunc has no concept of completion, we just want to print log entries until timeout is reached
+	if _, err := deployRequest(target, logFunc, requestFunc, 0, retryInterval); errors.Is(err, errWriteLog) {
+		return err
+	} else if err != nil && !errors.Is(err, ErrWaitTimeout) {
+		return fmt.Errorf("failed to read logs: %s", err)
+	}
+	return nil
+}
+
+// followLogs polls the log service until a terminal error occurs. If the connection to the log service is lost, or it
+// fails with a server error, it reconnects with exponential backoff, resuming from the last printed entry.
+func followLogs(target Target, printer *logPrinter, requestFunc requestFunc, retryInterval time.Duration) error {
+	service, err := target.DeployService()
+	if err != nil {
+		return err
+	}
+	interval := retryInterval
+	disconnected := false
+	for {
+		err := pollLogsOnce(service, printer, requestFunc)
+		if errors.Is(err, errWriteLog) {
+			return err
+		}
+		if ctxErr := service.context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, errAuth) || errors.Is(err, errClientStatus) {
+			return fmt.Errorf("failed to read logs: %s", err)
+		}
+		if err != nil {
+			if !disconnected && printer.options.ErrWriter != nil {
+				fmt.Fprintf(printer.options.ErrWriter, "Lost connection to log service: %s. Reconnecting ...\n", err)
 			}
-			if LogLevel(le.Level) > options.Level {
-				continue
+			disconnected = true
+			if err := service.sleep(interval); err != nil {
+				return err
 			}
-			fmt.Fprintln(options.Writer, le.Format(options.Dequote))
+			interval = min(max(2*interval, retryInterval), maxLogRetryInterval)
+			continue
 		}
-		if len(logEntries) > 0 {
-			lastFrom = logEntries[len(logEntries)-1].Time
+		if disconnected && printer.options.ErrWriter != nil {
+			fmt.Fprintf(printer.options.ErrWriter, "Reconnected to log service. Resuming from %s\n", printer.lastTime.UTC().Format(time.RFC3339Nano))
+		}
+		disconnected = false
+		interval = retryInterval
+		if err := service.sleep(retryInterval); err != nil {
+			return err
 		}
-		return false, nil
 	}
-	var timeout time.Duration
-	if options.Follow {
-		timeout = math.MaxInt64 // No timeout
+}
+
+var (
+	errClientStatus = errors.New("client error")
+	errWriteLog     = errors.New("failed to write log entry")
+)
+
+func pollLogsOnce(service *Service, printer *logPrinter, requestFunc requestFunc) error {
+	response, err := service.Do(requestFunc(), 20*time.Second)
+	if err != nil {
+		return err
 	}
-	// Ignore wait error because logFunc has no concept of completion, we just want to print log entries until timeout is reached
-	if _, err := deployRequest(target, logFunc, requestFunc, timeout, retryInterval); err != nil && !errors.Is(err, ErrWaitTimeout) {
-		return fmt.Errorf("failed to read logs: %s", err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode/100 == 4 {
+		return fmt.Errorf("%w: got status %d", errClientStatus, response.StatusCode)
+	} else if response.StatusCode/100 != 2 {
+		return fmt.Errorf("got status %d", response.StatusCode)
+	}
+	return printer.print(body)
+}
+
+// logPrinter prints log entries which have not already been printed. As entries are requested from the timestamp of
+// the last printed entry, entries at that timestamp may be read again, and are identified by their timestamp, host
+// and message.
+type logPrinter struct {
+	options  LogOptions
+	lastTime time.Time
+	printed  map[string]bool // Entries printed at lastTime
+}
+
+func (p *logPrinter) print(response []byte) error {
+	logEntries, err := ReadLogEntries(bytes.NewReader(response))
+	if err != nil {
+		return err
+	}
+	for _, le := range logEntries {
+		if le.Time.Before(p.lastTime) {
+			continue
+		}
+		key := le.Host + "\t" + le.Message
+		if le.Time.Equal(p.lastTime) {
+			if p.printed[key] || p.printed == nil {
+				continue // Already printed, or at the start timestamp which is exclusive
+			}
+		} else {
+			p.lastTime = le.Time
+			p.printed = make(map[string]bool)
+		}
+		p.printed[key] = true
+		if p.options.Stats != nil {
+			p.options.Stats.Fetched++
+		}
+		if LogLevel(le.Level) > p.options.Level || !p.options.Filter.Matches(le) {
+			continue
+		}
+		if p.options.Stats != nil {
+			p.options.Stats.Matched++
+		}
+		if p.options.Writer != nil {
+			line, err := le.FormatAs(p.options.JSON, p.options.Dequote)
+			if err != nil {
+				return fmt.Errorf("%w: %s", errWriteLog, err)
+			}
+			fmt.Fprintln(p.options.Writer, line)
+		}
+		if p.options.EntryWriter != nil {
+			if err := p.options.EntryWriter.WriteEntry(le); err != nil {
+				return fmt.Errorf("%w: %s", errWriteLog, err)
+			}
+		}
 	}
 	return nil
 }
@@ -347,6 +571,22 @@ type responseFunc func(status int, response []byte) (ok bool, err error)
 
 type requestFunc func() *http.Request
 
+// context returns the context requests to this service are sent with.
+func (s *Service) context() context.Context { return httputil.Context(s.httpClient) }
+
+// sleep waits for d to elapse, or returns the error of the context of this service, if it is done before that.
+func (s *Service) sleep(d time.Duration) error {
+	ctx := s.context()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // wait queries service until one of the following conditions are satisfied:
 //
 // 1. okFn returns true or a non-nil error
@@ -384,7 +624,9 @@ func wait(service *Service, okFn responseFunc, reqFn requestFunc, timeout, retry
 		if loopOnce || timeLeft < retryInterval {
 			break
 		}
-		time.Sleep(retryInterval)
+		if err := service.sleep(retryInterval); err != nil {
+			return status, err
+		}
 	}
 	if err == nil {
 		return status, ErrWaitTimeout
@@ -26,6 +26,8 @@ type CloudDeploymentOptions struct {
 	TLSOptions  TLSOptions
 	CustomURL   string
 	ClusterURLs map[string]string // Endpoints keyed on cluster name
+	// EndpointCache holds endpoints discovered by previous invocations. Endpoints are always discovered if this is nil
+	EndpointCache *EndpointCache
 }
 
 type cloudTarget struct {
@@ -50,8 +52,8 @@ type deploymentResponse struct {
 }
 
 type clusterTarget struct {
-	URL        string
-	AuthMethod string
+	URL        string `json:"url"`
+	AuthMethod string `json:"authMethod"`
 }
 
 type runResponse struct {
@@ -116,6 +118,8 @@ func (t *cloudTarget) DeployService() (*Service, error) {
 
 func (t *cloudTarget) ContainerServices(timeout time.Duration) ([]*Service, error) {
 	var clusterTargets map[string][]clusterTarget
+	cache := t.deploymentOptions.EndpointCache
+	cached := false
 	if t.deploymentOptions.CustomURL != "" {
 		// Custom URL is always preferred
 		clusterTargets = map[string][]clusterTarget{
@@ -133,13 +137,19 @@ func (t *cloudTarget) ContainerServices(timeout time.Duration) ([]*Service, erro
 				{URL: url, AuthMethod: "token"},
 			}
 		}
+	} else if endpoints, ok := t.cachedEndpoints(timeout); ok {
+		// ... then endpoints discovered by a previous invocation
+		clusterTargets = endpoints
+		cached = true
 	} else {
 		// ... then discovered endpoints
 		endpoints, err := t.discoverEndpoints(timeout)
 		if err != nil {
 			return nil, err
 		}
+		_ = cache.put(t.deploymentOptions.Deployment, endpoints) // Caching is best-effort
 		clusterTargets = endpoints
+		cached = cache != nil
 	}
 	services := make([]*Service, 0, len(clusterTargets))
 	for name, targets := range clusterTargets {
@@ -153,6 +163,9 @@ func (t *cloudTarget) ContainerServices(timeout time.Duration) ([]*Service, erro
 				auth:          t.deploymentAuth,
 				retryInterval: t.retryInterval,
 			}
+			if cached {
+				service.onStaleEndpoint = func() { _ = cache.Invalidate(t.deploymentOptions.Deployment) }
+			}
 			if timeout > 0 {
 				if err := service.Wait(timeout); err != nil {
 					return nil, err
@@ -161,7 +174,12 @@ func (t *cloudTarget) ContainerServices(timeout time.Duration) ([]*Service, erro
 			services = append(services, service)
 		}
 	}
-	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name == services[j].Name {
+			return services[i].AuthMethod < services[j].AuthMethod
+		}
+		return services[i].Name < services[j].Name
+	})
 	return services, nil
 }
 
@@ -210,6 +228,59 @@ func (t *cloudTarget) PrintLog(options LogOptions) error {
 	return pollLogs(t, t.logsURL(), options, t.retryInterval)
 }
 
+func (t *cloudTarget) nodesURL() string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/environment/%s/region/%s/nodes",
+		t.apiOptions.System.URL,
+		t.deploymentOptions.Deployment.Application.Tenant, t.deploymentOptions.Deployment.Application.Application, t.deploymentOptions.Deployment.Application.Instance,
+		t.deploymentOptions.Deployment.Zone.Environment, t.deploymentOptions.Deployment.Zone.Region)
+}
+
+type nodesResponse struct {
+	Nodes []struct {
+		Hostname                 string `json:"hostname"`
+		State                    string `json:"state"`
+		Version                  string `json:"version"`
+		WantedVersion            string `json:"wantedVersion"`
+		ClusterID                string `json:"clusterId"`
+		ClusterType              string `json:"clusterType"`
+		RestartGeneration        int64  `json:"restartGeneration"`
+		CurrentRestartGeneration int64  `json:"currentRestartGeneration"`
+	} `json:"nodes"`
+}
+
+// ServiceDetails returns the nodes of this deployment, as given by the node repository.
+func (t *cloudTarget) ServiceDetails() ([]ServiceDetail, error) {
+	req, err := http.NewRequest("GET", t.nodesURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp nodesResponse
+	nodesFunc := func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			return ok, err
+		}
+		return true, json.Unmarshal(response, &resp)
+	}
+	if _, err := deployRequest(t, nodesFunc, func() *http.Request { return req }, 0, t.retryInterval); err != nil {
+		return nil, fmt.Errorf("could not get nodes of deployment: %w", err)
+	}
+	details := make([]ServiceDetail, 0, len(resp.Nodes))
+	for _, node := range resp.Nodes {
+		restartPending := node.RestartGeneration > node.CurrentRestartGeneration
+		details = append(details, ServiceDetail{
+			Host:           node.Hostname,
+			Type:           node.ClusterType,
+			Cluster:        node.ClusterID,
+			State:          node.State,
+			CurrentVersion: node.Version,
+			WantedVersion:  node.WantedVersion,
+			RestartPending: &restartPending,
+		})
+	}
+	sortServiceDetails(details)
+	return details, nil
+}
+
 func (t *cloudTarget) discoverLatestRun(timeout time.Duration) (int64, error) {
 	runsURL := t.apiOptions.System.RunsURL(t.deploymentOptions.Deployment) + "?limit=1"
 	req, err := http.NewRequest("GET", runsURL, nil)
@@ -257,6 +328,7 @@ func (t *cloudTarget) AwaitDeployment(runID int64, timeout time.Duration) (int64
 		return req
 	}
 	success := false
+	var runErrors []LogLinePrepareResponse
 	jobSuccessFunc := func(status int, response []byte) (bool, error) {
 		if ok, err := isOK(status); !ok {
 			return ok, err
@@ -266,13 +338,20 @@ func (t *cloudTarget) AwaitDeployment(runID int64, timeout time.Duration) (int64
 			return false, err
 		}
 		if t.logOptions.Writer != nil {
+			runErrors = append(runErrors, errorMessages(resp)...) // Only new messages are returned after the first
 			lastID = t.printLog(resp, lastID)
+		} else {
+			runErrors = errorMessages(resp)
 		}
 		if resp.Active {
 			return false, nil
 		}
 		if resp.Status != "success" {
-			return false, fmt.Errorf("%w: run %d ended with unsuccessful status: %s", ErrDeployment, runID, resp.Status)
+			err := fmt.Errorf("%w: run %d ended with unsuccessful status: %s", ErrDeployment, runID, resp.Status)
+			if len(runErrors) > 0 {
+				return false, &DeployError{LogLines: runErrors, err: err}
+			}
+			return false, err
 		}
 		success = true
 		return success, nil
@@ -287,6 +366,20 @@ func (t *cloudTarget) AwaitDeployment(runID int64, timeout time.Duration) (int64
 	return runID, nil
 }
 
+// errorMessages returns the error messages in the log of response, ordered by time.
+func errorMessages(response runResponse) []LogLinePrepareResponse {
+	var msgs []LogLinePrepareResponse
+	for _, stepMsgs := range response.Log {
+		for _, msg := range stepMsgs {
+			if msg.Type == "error" {
+				msgs = append(msgs, LogLinePrepareResponse{Time: msg.At, Level: "ERROR", Message: msg.Message})
+			}
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Time < msgs[j].Time })
+	return msgs
+}
+
 func (t *cloudTarget) printLog(response runResponse, last int64) int64 {
 	if response.LastID == 0 {
 		return last
@@ -309,6 +402,15 @@ func (t *cloudTarget) printLog(response runResponse, last int64) int64 {
 	return response.LastID
 }
 
+// cachedEndpoints returns the endpoints of this deployment found in the endpoint cache. The cache is not used when
+// waiting for the deployment, as its endpoints may be changing.
+func (t *cloudTarget) cachedEndpoints(timeout time.Duration) (map[string][]clusterTarget, bool) {
+	if timeout > 0 {
+		return nil, false
+	}
+	return t.deploymentOptions.EndpointCache.get(t.deploymentOptions.Deployment)
+}
+
 func (t *cloudTarget) discoverEndpoints(timeout time.Duration) (map[string][]clusterTarget, error) {
 	deploymentURL := fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/environment/%s/region/%s",
 		t.apiOptions.System.URL,
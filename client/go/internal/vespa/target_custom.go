@@ -9,30 +9,107 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/internal/httputil"
 	"github.com/vespa-engine/vespa/client/go/internal/version"
 )
 
+// configServerProbeTimeout is the time to wait for a connection to each config server of a target with multiple.
+const configServerProbeTimeout = 5 * time.Second
+
 type customTarget struct {
 	targetType    string
 	baseURL       string
 	httpClient    httputil.Client
 	tlsOptions    TLSOptions
 	retryInterval time.Duration
+	progress      func(ConvergenceProgress) error
+	now           func() time.Time
+
+	// configServers holds the base URLs of config servers to fail over between, if there are multiple. The first of
+	// these accepting connections becomes the baseURL of this target
+	configServers []string
+	failover      func(url string, err error)
+	mu            sync.Mutex
+
+	// portMap maps the ports of a local target to the host ports they are published on
+	portMap map[int]int
 }
 
 type serviceStatus struct {
 	Converged         bool          `json:"converged"`
 	CurrentGeneration int64         `json:"currentGeneration"`
+	WantedGeneration  int64         `json:"wantedGeneration"`
 	Services          []serviceInfo `json:"services"`
 }
 
 type serviceInfo struct {
-	ClusterName string `json:"clusterName"`
-	Type        string `json:"type"`
-	Port        int    `json:"port"`
+	ClusterName       string `json:"clusterName"`
+	Type              string `json:"type"`
+	Host              string `json:"host"`
+	Port              int    `json:"port"`
+	CurrentGeneration int64  `json:"currentGeneration"`
+}
+
+// ConvergenceProgress describes how far a deployment has come in converging on a config generation.
+type ConvergenceProgress struct {
+	// Generation is the config generation services are converging on.
+	Generation int64
+	// Converged is the number of services running on Generation.
+	Converged int
+	// Total is the total number of services.
+	Total int
+	// Pending holds the host:port of services not yet running on Generation.
+	Pending []string
+	// Failing holds the services whose config generation could not be read, e.g. because they are down.
+	Failing []ServiceDetail
+}
+
+// FailoverTarget is implemented by targets which can fail over between multiple config servers.
+type FailoverTarget interface {
+	// SetFailoverFunc sets a function to call for each config server tried, with the error connecting to it, or nil
+	// for the server which is then used.
+	SetFailoverFunc(fn func(url string, err error))
+}
+
+// PortMapTarget is implemented by targets whose ports may be published on other ports of the host, e.g. by a container
+// runtime.
+type PortMapTarget interface {
+	// SetPortMap makes requests to any port in ports go to the host port it maps to.
+	SetPortMap(ports map[int]int)
+}
+
+// ClockTarget is implemented by targets which can wait by a clock other than the system clock.
+type ClockTarget interface {
+	// SetNowFunc sets the function returning the current time, by which deadlines of waiting are measured.
+	SetNowFunc(fn func() time.Time)
+}
+
+// ProgressTarget is implemented by targets which can report progress while awaiting deployment convergence.
+type ProgressTarget interface {
+	// SetProgressFunc sets a function to call every time convergence status is polled. A nil function disables
+	// reporting. If the function returns an error, waiting stops with that error.
+	SetProgressFunc(fn func(ConvergenceProgress) error)
+}
+
+func newConvergenceProgress(status serviceStatus) ConvergenceProgress {
+	progress := ConvergenceProgress{Generation: status.CurrentGeneration, Total: len(status.Services)}
+	for _, s := range status.Services {
+		if s.CurrentGeneration >= status.CurrentGeneration {
+			progress.Converged++
+		} else {
+			progress.Pending = append(progress.Pending, net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+		}
+		// The config server reports a negative generation for services it cannot reach
+		if s.CurrentGeneration < 0 {
+			progress.Failing = append(progress.Failing, ServiceDetail{Host: s.Host, Port: s.Port, Type: s.Type, Cluster: s.ClusterName})
+		}
+	}
+	sort.Strings(progress.Pending)
+	return progress
 }
 
 // LocalTarget creates a target for a Vespa platform running locally.
@@ -43,22 +120,49 @@ func LocalTarget(httpClient httputil.Client, tlsOptions TLSOptions, retryInterva
 		httpClient:    httpClient,
 		tlsOptions:    tlsOptions,
 		retryInterval: retryInterval,
+		now:           time.Now,
 	}
 }
 
-// CustomTarget creates a Target for a Vespa platform running at baseURL.
+// CustomTarget creates a Target for a Vespa platform running at baseURL. This may be a comma-separated list of URLs of
+// config servers, which are tried in order until one accepts connections.
 func CustomTarget(httpClient httputil.Client, baseURL string, tlsOptions TLSOptions, retryInterval time.Duration) Target {
-	return &customTarget{
+	t := &customTarget{
 		targetType:    TargetCustom,
 		baseURL:       baseURL,
 		httpClient:    httpClient,
 		tlsOptions:    tlsOptions,
 		retryInterval: retryInterval,
+		now:           time.Now,
 	}
+	if urls := SplitURLs(baseURL); len(urls) > 1 {
+		t.baseURL = ""
+		t.configServers = urls
+	}
+	return t
+}
+
+// SplitURLs returns the URLs in comma-separated list s.
+func SplitURLs(s string) []string {
+	var urls []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
 }
 
 func (t *customTarget) Type() string { return t.targetType }
 
+func (t *customTarget) SetProgressFunc(fn func(ConvergenceProgress) error) { t.progress = fn }
+
+func (t *customTarget) SetFailoverFunc(fn func(url string, err error)) { t.failover = fn }
+
+func (t *customTarget) SetPortMap(ports map[int]int) { t.portMap = ports }
+
+func (t *customTarget) SetNowFunc(fn func() time.Time) { t.now = fn }
+
 func (t *customTarget) IsCloud() bool { return false }
 
 func (t *customTarget) Deployment() Deployment { return DefaultDeployment }
@@ -76,28 +180,11 @@ func (t *customTarget) CompatibleWith(minVersion version.Version) error {
 	if minVersion.IsZero() { // development version is always fine
 		return nil
 	}
-	deployService, err := t.DeployService()
-	if err != nil {
-		return err
-	}
-	versionURL := deployService.BaseURL + "/state/v1/version"
-	req, err := http.NewRequest("GET", versionURL, nil)
-	if err != nil {
-		return err
-	}
-	var versionResponse struct {
-		Version string `json:"version"`
-	}
-	response, err := deployService.Do(req, 10*time.Second)
+	configServerVersion, err := t.configServerVersion()
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
-	dec := json.NewDecoder(response.Body)
-	if err := dec.Decode(&versionResponse); err != nil {
-		return err
-	}
-	targetVersion, err := version.Parse(versionResponse.Version)
+	targetVersion, err := version.Parse(configServerVersion)
 	if err != nil {
 		return err
 	}
@@ -118,9 +205,53 @@ func (t *customTarget) newService(url, name string, deployAPI bool) *Service {
 	}
 }
 
+// customURL returns the base URL of this custom target. If it has multiple config servers, this is the first of them
+// accepting connections, which is then used for the lifetime of this.
+func (t *customTarget) customURL() (string, error) {
+	if len(t.configServers) == 0 {
+		return t.baseURL, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.baseURL != "" {
+		return t.baseURL, nil
+	}
+	var errs []string
+	for _, u := range t.configServers {
+		err := t.probe(u)
+		if t.failover != nil {
+			t.failover(u, err)
+		}
+		if err == nil {
+			t.baseURL = u
+			return u, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", u, err))
+	}
+	return "", fmt.Errorf("no config server is reachable: %s", strings.Join(errs, ", "))
+}
+
+// probe returns an error if a connection to the config server at url fails. Any HTTP response is fine.
+func (t *customTarget) probe(url string) error {
+	req, err := http.NewRequest("GET", url+"/status.html", nil)
+	if err != nil {
+		return err
+	}
+	response, err := t.newService(url, "", true).Do(req, configServerProbeTimeout)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
 func (t *customTarget) DeployService() (*Service, error) {
 	if t.targetType == TargetCustom {
-		return t.newService(t.baseURL, "", true), nil
+		url, err := t.customURL()
+		if err != nil {
+			return nil, err
+		}
+		return t.newService(url, "", true), nil
 	}
 	u, err := t.urlWithPort(19071)
 	if err != nil {
@@ -131,7 +262,11 @@ func (t *customTarget) DeployService() (*Service, error) {
 
 func (t *customTarget) ContainerServices(timeout time.Duration) ([]*Service, error) {
 	if t.targetType == TargetCustom {
-		return []*Service{t.newService(t.baseURL, "", false)}, nil
+		url, err := t.customURL()
+		if err != nil {
+			return nil, err
+		}
+		return []*Service{t.newService(url, "", false)}, nil
 	}
 	status, err := t.serviceStatus(AnyDeployment, timeout)
 	if err != nil {
@@ -177,6 +312,9 @@ func (t *customTarget) urlWithPort(port int) (*url.URL, error) {
 	if _, _, err := net.SplitHostPort(u.Host); err == nil {
 		return nil, fmt.Errorf("url %s already contains port", u)
 	}
+	if hostPort, ok := t.portMap[port]; ok {
+		port = hostPort
+	}
 	u.Host = net.JoinHostPort(u.Host, strconv.Itoa(port))
 	return u, nil
 }
@@ -200,6 +338,11 @@ func (t *customTarget) serviceStatus(wantedGeneration int64, timeout time.Durati
 		if err := json.Unmarshal(response, &status); err != nil {
 			return false, err
 		}
+		if t.progress != nil {
+			if err := t.progress(newConvergenceProgress(status)); err != nil {
+				return false, err
+			}
+		}
 		converged = wantedGeneration == AnyDeployment ||
 			(wantedGeneration == LatestDeployment && status.Converged) ||
 			status.CurrentGeneration == wantedGeneration
@@ -214,6 +357,45 @@ func (t *customTarget) serviceStatus(wantedGeneration int64, timeout time.Durati
 	return status, nil
 }
 
+func (t *customTarget) ServiceDetails() ([]ServiceDetail, error) {
+	deployService, err := t.DeployService()
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge", deployService.BaseURL)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var status serviceStatus
+	statusFunc := func(httpStatus int, response []byte) (bool, error) {
+		if ok, err := isOK(httpStatus); !ok {
+			return ok, err
+		}
+		return true, json.Unmarshal(response, &status)
+	}
+	if _, err := wait(deployService, statusFunc, func() *http.Request { return req }, 0, t.retryInterval); err != nil {
+		return nil, fmt.Errorf("could not get status of services: %w", err)
+	}
+	wanted := status.WantedGeneration
+	if wanted == 0 {
+		wanted = status.CurrentGeneration
+	}
+	details := make([]ServiceDetail, 0, len(status.Services))
+	for _, s := range status.Services {
+		details = append(details, ServiceDetail{
+			Host:              s.Host,
+			Port:              s.Port,
+			Type:              s.Type,
+			Cluster:           s.ClusterName,
+			CurrentGeneration: s.CurrentGeneration,
+			WantedGeneration:  wanted,
+		})
+	}
+	sortServiceDetails(details)
+	return details, nil
+}
+
 func (t *customTarget) ListApplications(_ string, _ time.Duration) (*CloudTenantResponse, error) {
 	return nil, fmt.Errorf("not implemented")
 }
@@ -3,6 +3,7 @@ package vespa
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -64,6 +65,10 @@ func TestLocalTarget(t *testing.T) {
 	}
 	assertServiceURL(t, "http://127.0.0.1:8080", lt, "container8080")
 	assertServiceURL(t, "http://127.0.0.1:8081", lt, "feed")
+
+	// Ports published on other host ports
+	lt.(PortMapTarget).SetPortMap(map[int]int{19071: 49071})
+	assertServiceURL(t, "http://127.0.0.1:49071", lt, "deploy")
 }
 
 func TestCustomTarget(t *testing.T) {
@@ -247,6 +252,38 @@ func TestLog(t *testing.T) {
 	assert.Equal(t, expected, buf.String())
 }
 
+func TestLogFollow(t *testing.T) {
+	target, client := createCloudTarget(t, io.Discard)
+	logsURL := "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1/logs"
+	entry := func(timestamp, message string) string {
+		return timestamp + "\thost1\t1/1\tcontainer\tcom.example.Foo\tinfo\t" + message + "\n"
+	}
+	client.NextResponseError(io.ErrUnexpectedEOF)
+	client.NextResponse(mock.HTTPResponse{URI: logsURL + "?from=1632738690000", Status: 200,
+		Body: []byte(entry("1632738690.000000", "at start") + entry("1632738690.100000", "first") + entry("1632738691.200000", "second"))})
+	client.NextResponse(mock.HTTPResponse{URI: logsURL + "?from=1632738691200", Status: 503})
+	client.NextResponse(mock.HTTPResponse{URI: logsURL + "?from=1632738691200", Status: 200,
+		Body: []byte(entry("1632738691.200000", "second") + entry("1632738691.200000", "third") + entry("1632738692.000000", "fourth"))})
+	client.NextResponse(mock.HTTPResponse{URI: logsURL + "?from=1632738692000", Status: 200,
+		Body: []byte(entry("1632738692.000000", "fourth"))})
+	client.NextResponse(mock.HTTPResponse{URI: logsURL + "?from=1632738692000", Status: 403})
+
+	var out, errOut bytes.Buffer
+	options := LogOptions{Writer: &out, ErrWriter: &errOut, Level: 3, Follow: true, From: time.Unix(1632738690, 0)}
+	err := target.PrintLog(options)
+	assert.Equal(t, "failed to read logs: client error: got status 403", err.Error())
+	assert.True(t, client.Consumed())
+	format := "[2021-09-27 10:31:%s] host1    info    container        com.example.Foo\t%s\n"
+	assert.Equal(t, fmt.Sprintf(format, "30.100000", "first")+
+		fmt.Sprintf(format, "31.200000", "second")+
+		fmt.Sprintf(format, "31.200000", "third")+
+		fmt.Sprintf(format, "32.000000", "fourth"), out.String())
+	assert.Equal(t, "Lost connection to log service: unexpected EOF. Reconnecting ...\n"+
+		"Reconnected to log service. Resuming from 2021-09-27T10:31:31.2Z\n"+
+		"Lost connection to log service: got status 503. Reconnecting ...\n"+
+		"Reconnected to log service. Resuming from 2021-09-27T10:31:32Z\n", errOut.String())
+}
+
 func TestCloudCompatibleWith(t *testing.T) {
 	target, client := createCloudTarget(t, io.Discard)
 	for range 3 {
@@ -313,3 +350,27 @@ func assertService(t *testing.T, fail bool, target Target, serviceName string, t
 type mockAuthenticator struct{}
 
 func (a *mockAuthenticator) Authenticate(request *http.Request) error { return nil }
+
+func TestCustomTargetFailover(t *testing.T) {
+	client := &mock.HTTPClient{}
+	target := CustomTarget(client, "http://192.0.2.1:19071, http://192.0.2.2:19071,http://192.0.2.3:19071", TLSOptions{}, 0)
+	var tried []string
+	target.(FailoverTarget).SetFailoverFunc(func(url string, err error) {
+		tried = append(tried, fmt.Sprintf("%s: %v", url, err))
+	})
+	client.NextResponseError(io.EOF)
+	client.NextStatus(503) // Reachable, even if not ready
+	assertServiceURL(t, "http://192.0.2.2:19071", target, "deploy")
+	assert.Equal(t, []string{"http://192.0.2.1:19071: EOF", "http://192.0.2.2:19071: <nil>"}, tried)
+	assert.Equal(t, "http://192.0.2.2:19071/status.html", client.LastRequest.URL.String())
+
+	// The first reachable server is remembered
+	assertServiceURL(t, "http://192.0.2.2:19071", target, "")
+	assert.Len(t, tried, 2)
+
+	target = CustomTarget(client, "http://192.0.2.1:19071,http://192.0.2.2:19071", TLSOptions{}, 0)
+	client.NextResponseError(io.EOF)
+	client.NextResponseError(io.ErrUnexpectedEOF)
+	_, err := target.DeployService()
+	assert.Equal(t, "no config server is reachable: http://192.0.2.1:19071: EOF, http://192.0.2.2:19071: unexpected EOF", err.Error())
+}
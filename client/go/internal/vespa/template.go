@@ -0,0 +1,107 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// TemplateFiles are the files at the root of an application package in which placeholders are substituted.
+var TemplateFiles = []string{"services.xml", "deployment.xml"}
+
+// placeholderPattern matches placeholders on the form ${name} or ${name:default}.
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.-]*)(?::([^}]*))?\}`)
+
+// Template holds the values of placeholders in the template files of an application package. A placeholder is given
+// as ${name}, ${env.NAME} for the environment variable NAME, or with a default value, as in ${name:default}.
+type Template struct {
+	// Values holds the values of placeholders, by name. These take precedence over environment variables and defaults
+	Values map[string]string
+	// LookupEnv returns the value of an environment variable, and whether it is set
+	LookupEnv func(name string) (string, bool)
+}
+
+// UnresolvedError is returned when placeholders in the template files of an application package have no value, and no
+// default.
+type UnresolvedError struct {
+	// Placeholders holds each unresolved placeholder, prefixed by the file it is found in
+	Placeholders []string
+}
+
+func (e *UnresolvedError) Error() string {
+	return "unresolved placeholders in application package: " + strings.Join(e.Placeholders, ", ")
+}
+
+func (t *Template) value(name string) (string, bool) {
+	if value, ok := t.Values[name]; ok {
+		return value, true
+	}
+	if env, ok := strings.CutPrefix(name, "env."); ok && t.LookupEnv != nil {
+		return t.LookupEnv(env)
+	}
+	return "", false
+}
+
+// render returns data with its placeholders substituted, and the placeholders which could not be resolved.
+func (t *Template) render(data []byte) ([]byte, []string) {
+	var unresolved []string
+	rendered := placeholderPattern.ReplaceAllFunc(data, func(placeholder []byte) []byte {
+		match := placeholderPattern.FindSubmatch(placeholder)
+		if value, ok := t.value(string(match[1])); ok {
+			return []byte(value)
+		}
+		if match[2] != nil {
+			return match[2]
+		}
+		unresolved = append(unresolved, string(placeholder))
+		return placeholder
+	})
+	return rendered, unresolved
+}
+
+// isTemplateFile returns whether the zip entry name is a template file.
+func isTemplateFile(name string) bool {
+	for _, f := range TemplateFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderTemplates returns the template files of this application package, by name, with their placeholders
+// substituted from the template of this. Files without placeholders are returned as they are.
+func (ap *ApplicationPackage) RenderTemplates() (map[string][]byte, error) {
+	if ap.IsZip() {
+		return nil, fmt.Errorf("cannot substitute placeholders in compressed application package: '%s'", ap.Path)
+	}
+	files := make(map[string][]byte)
+	var unresolved []string
+	for _, name := range TemplateFiles {
+		data, err := os.ReadFile(filepath.Join(ap.Path, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rendered, missing := ap.template().render(data)
+		for _, placeholder := range missing {
+			unresolved = append(unresolved, name+": "+placeholder)
+		}
+		files[name] = rendered
+	}
+	if len(unresolved) > 0 {
+		return nil, &UnresolvedError{Placeholders: unresolved}
+	}
+	return files, nil
+}
+
+func (ap *ApplicationPackage) template() *Template {
+	if ap.Template == nil {
+		return &Template{}
+	}
+	return ap.Template
+}
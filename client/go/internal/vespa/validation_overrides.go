@@ -0,0 +1,140 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/ioutil"
+)
+
+// ValidationOverridesFile is the file of an application package holding its validation overrides.
+const ValidationOverridesFile = "validation-overrides.xml"
+
+// validationAllowRegexp matches the instruction given by the config server for overriding a failed validation.
+var validationAllowRegexp = regexp.MustCompile(`To allow this add <allow until='yyyy-mm-dd'>([a-z0-9-]+)</allow> to validation-overrides\.xml`)
+
+// validationDescriptions describes what each validation protects against.
+var validationDescriptions = map[string]string{
+	"indexing-change":         "Changing what tokens are expected and stored in field indexes, which requires re-feeding",
+	"indexing-mode-change":    "Changing the indexing mode of a content cluster, which requires re-feeding",
+	"field-type-change":       "Changing the type of a field, which requires re-feeding",
+	"tensor-type-change":      "Changing the type of a tensor field, which requires re-feeding",
+	"hnsw-settings-change":    "Changing the HNSW index settings of a field, which requires re-feeding",
+	"cluster-size-reduction":  "Reducing the number of nodes of a cluster by more than 50% in one deployment",
+	"resources-reduction":     "Reducing the resources of a cluster by more than 50% in one deployment",
+	"content-type-removal":    "Removing a document type from a content cluster, which deletes all its documents",
+	"content-cluster-removal": "Removing a content cluster, which deletes all its documents",
+	"deployment-removal":      "Removing a production deployment, which deletes all its data",
+	"global-document-change":  "Changing whether a document type is global, which requires re-feeding",
+	"global-endpoint-change":  "Changing the global endpoints of an application, which may break clients",
+	"zone-endpoint-change":    "Changing the zone endpoints of an application, which may break clients",
+	"redundancy-increase":     "Increasing the redundancy of a content cluster, which requires more disk and memory",
+	"redundancy-one":          "Using redundancy 1 in a content cluster, which loses data if any node fails",
+	"paged-setting-removal":   "Removing the paged setting of an attribute, which may require more memory",
+	"certificate-removal":     "Removing data plane certificates, which may lock out clients",
+}
+
+// ValidationFailure is a validation which failed for a deployment, and can be overridden in validation-overrides.xml.
+type ValidationFailure struct {
+	// ID is the ID of the validation, such as content-cluster-removal
+	ID string
+	// Message is the reason given for the failure
+	Message string
+}
+
+// Description returns a description of what the validation protects against, or an empty string if the validation is
+// unknown.
+func (f ValidationFailure) Description() string { return validationDescriptions[f.ID] }
+
+// ParseValidationFailures returns the validations which failed according to the given error message from a deploy
+// API, in the order they appear. Each failure is on the form
+// "<id>: <message>. To allow this add <allow until='yyyy-mm-dd'><id></allow> to validation-overrides.xml, ...".
+func ParseValidationFailures(message string) []ValidationFailure {
+	var failures []ValidationFailure
+	seen := make(map[string]int)
+	for _, m := range validationAllowRegexp.FindAllStringSubmatchIndex(message, -1) {
+		id := message[m[2]:m[3]]
+		before := message[:m[0]]
+		reason := ""
+		if start := strings.LastIndex(before, id+": "); start >= 0 {
+			reason = strings.TrimRight(strings.TrimSpace(before[start+len(id)+2:]), ".")
+		}
+		if i, ok := seen[id]; ok {
+			if reason != "" {
+				failures[i].Message = strings.TrimPrefix(failures[i].Message+"; "+reason, "; ")
+			}
+			continue
+		}
+		seen[id] = len(failures)
+		failures = append(failures, ValidationFailure{ID: id, Message: reason})
+	}
+	return failures
+}
+
+// allowRegexp returns a regexp matching an existing override of the validation with given ID, capturing its until
+// attribute.
+func allowRegexp(id string) *regexp.Regexp {
+	return regexp.MustCompile(`(<allow\s[^>]*until\s*=\s*["'])([^"']*)(["'][^>]*>\s*` + regexp.QuoteMeta(id) + `\s*</allow>)`)
+}
+
+// AddValidationOverrides allows the validations with given IDs until the end of the given day, by writing them to
+// validation-overrides.xml in this application package, which must be a directory. The file is created if it does not
+// exist. Existing overrides of the same validations are extended to until, while new ones are added after an XML
+// comment holding comment. The path of the file is returned.
+func (ap *ApplicationPackage) AddValidationOverrides(ids []string, until time.Time, comment string) (string, error) {
+	if ap.IsZip() {
+		return "", fmt.Errorf("cannot add validation overrides to zipped application package %s", ap.Path)
+	}
+	path := filepath.Join(ap.Path, ValidationOverridesFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data = []byte("<validation-overrides>\n</validation-overrides>\n")
+	} else if err != nil {
+		return "", err
+	}
+	content := string(data)
+	date := until.Format(time.DateOnly)
+	var added []string
+	for _, id := range ids {
+		re := allowRegexp(id)
+		if re.MatchString(content) {
+			content = re.ReplaceAllStringFunc(content, func(s string) string {
+				m := re.FindStringSubmatch(s)
+				if m[2] >= date { // Dates on the form yyyy-mm-dd compare lexically
+					return s
+				}
+				return m[1] + date + m[3]
+			})
+			continue
+		}
+		added = append(added, fmt.Sprintf("<allow until=\"%s\">%s</allow>", date, id))
+	}
+	if len(added) > 0 {
+		end := strings.LastIndex(content, "</validation-overrides>")
+		if end < 0 {
+			return "", fmt.Errorf("invalid %s: missing </validation-overrides>", path)
+		}
+		var sb strings.Builder
+		if lineStart := strings.LastIndex(content[:end], "\n") + 1; strings.TrimSpace(content[lineStart:end]) == "" {
+			// Insert before the line holding the end tag
+			end = lineStart
+		} else {
+			sb.WriteString("\n")
+		}
+		comment = strings.ReplaceAll(comment, "--", "-")
+		sb.WriteString("  <!-- " + comment + " -->\n")
+		for _, a := range added {
+			sb.WriteString("  " + a + "\n")
+		}
+		content = content[:end] + sb.String() + content[end:]
+	}
+	if err := ioutil.AtomicWriteFile(path, []byte(content)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
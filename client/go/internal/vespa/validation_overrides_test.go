@@ -0,0 +1,81 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValidationFailures(t *testing.T) {
+	message := "Invalid application: content-cluster-removal: Content cluster 'music' is removed. This will cause loss of all data in this cluster. " +
+		"To allow this add <allow until='yyyy-mm-dd'>content-cluster-removal</allow> to validation-overrides.xml, see https://docs.vespa.ai/en/reference/validation-overrides.html\n" +
+		"field-type-change: Field 'year' changed: data type: 'int' -> 'string'. " +
+		"To allow this add <allow until='yyyy-mm-dd'>field-type-change</allow> to validation-overrides.xml, see https://docs.vespa.ai/en/reference/validation-overrides.html"
+	failures := ParseValidationFailures(message)
+	assert.Equal(t, []ValidationFailure{
+		{ID: "content-cluster-removal", Message: "Content cluster 'music' is removed. This will cause loss of all data in this cluster"},
+		{ID: "field-type-change", Message: "Field 'year' changed: data type: 'int' -> 'string'"},
+	}, failures)
+	assert.Equal(t, "Removing a content cluster, which deletes all its documents", failures[0].Description())
+	assert.Equal(t, "", ValidationFailure{ID: "unknown-change"}.Description())
+	assert.Nil(t, ParseValidationFailures("Invalid application: services.xml is invalid"))
+}
+
+func TestAddValidationOverrides(t *testing.T) {
+	dir := t.TempDir()
+	pkg := ApplicationPackage{Path: dir}
+	until := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	path, err := pkg.AddValidationOverrides([]string{"content-cluster-removal"}, until, "Added by alice with vespa deploy at 2024-01-01T00:00:00Z")
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "validation-overrides.xml"), path)
+	assertFile(t, path, `<validation-overrides>
+  <!-- Added by alice with vespa deploy at 2024-01-01T00:00:00Z -->
+  <allow until="2024-01-08">content-cluster-removal</allow>
+</validation-overrides>
+`)
+
+	// Existing overrides are extended, and others are added after them
+	_, err = pkg.AddValidationOverrides([]string{"content-cluster-removal", "field-type-change"}, until.AddDate(0, 0, 2), "Added by bob")
+	require.Nil(t, err)
+	assertFile(t, path, `<validation-overrides>
+  <!-- Added by alice with vespa deploy at 2024-01-01T00:00:00Z -->
+  <allow until="2024-01-10">content-cluster-removal</allow>
+  <!-- Added by bob -->
+  <allow until="2024-01-10">field-type-change</allow>
+</validation-overrides>
+`)
+
+	// Overrides are never shortened
+	_, err = pkg.AddValidationOverrides([]string{"field-type-change"}, until, "Added by bob")
+	require.Nil(t, err)
+	assertFile(t, path, `<validation-overrides>
+  <!-- Added by alice with vespa deploy at 2024-01-01T00:00:00Z -->
+  <allow until="2024-01-10">content-cluster-removal</allow>
+  <!-- Added by bob -->
+  <allow until="2024-01-10">field-type-change</allow>
+</validation-overrides>
+`)
+
+	require.Nil(t, os.WriteFile(path, []byte(`<validation-overrides><allow until='2023-12-01' comment="old">indexing-change</allow></validation-overrides>`), 0644))
+	_, err = pkg.AddValidationOverrides([]string{"indexing-change", "redundancy-one"}, until, "Added by carol")
+	require.Nil(t, err)
+	assertFile(t, path, `<validation-overrides><allow until='2024-01-08' comment="old">indexing-change</allow>
+  <!-- Added by carol -->
+  <allow until="2024-01-08">redundancy-one</allow>
+</validation-overrides>`)
+
+	_, err = (&ApplicationPackage{Path: filepath.Join(dir, "app.zip")}).AddValidationOverrides([]string{"indexing-change"}, until, "")
+	assert.NotNil(t, err)
+}
+
+func assertFile(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, want, string(data))
+}
@@ -0,0 +1,122 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// NodeVersion is the Vespa version of a service, or node, of a deployment.
+type NodeVersion struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	// Type is the type of the service, e.g. "searchnode", or the type of the cluster of a node
+	Type    string `json:"type"`
+	Cluster string `json:"cluster,omitempty"`
+	// Version is the Vespa version the service runs, or empty if it could not be read
+	Version string `json:"version,omitempty"`
+	// WantedVersion is the Vespa version the node should run, if known
+	WantedVersion string `json:"wantedVersion,omitempty"`
+	// Error is the reason the version could not be read, if it could not
+	Error string `json:"error,omitempty"`
+}
+
+// Upgrading returns whether this node does not yet run its wanted version.
+func (v NodeVersion) Upgrading() bool { return v.WantedVersion != "" && v.Version != v.WantedVersion }
+
+// Versions holds the Vespa versions of the services, or nodes, of a deployment.
+type Versions struct {
+	// ConfigServer is the Vespa version of the config server. Empty for Vespa Cloud, where nodes have a wanted version
+	// instead
+	ConfigServer string
+	Nodes        []NodeVersion
+}
+
+// Skewed returns whether node v runs another Vespa version than the config server.
+func (vs Versions) Skewed(v NodeVersion) bool {
+	return vs.ConfigServer != "" && v.Version != "" && v.Version != vs.ConfigServer
+}
+
+// VersionTarget is implemented by targets which can read the Vespa version of each service, or node, of their
+// deployment.
+type VersionTarget interface {
+	// Versions returns the Vespa versions of the config server and of each service of the deployment. A service whose
+	// version cannot be read is included with the error of reading it. Services are sorted by host and port, and a
+	// single request is sent to each, without retrying on failure.
+	Versions() (Versions, error)
+}
+
+// stateVersion is the response of the version of the state API of a Vespa service.
+type stateVersion struct {
+	Version string `json:"version"`
+}
+
+func (t *customTarget) Versions() (Versions, error) {
+	configServerVersion, err := t.configServerVersion()
+	if err != nil {
+		return Versions{}, fmt.Errorf("could not get version of config server: %w", err)
+	}
+	status, err := t.serviceStatus(AnyDeployment, 0)
+	if err != nil {
+		return Versions{}, err
+	}
+	services := status.Services
+	sort.SliceStable(services, func(i, j int) bool {
+		if services[i].Host != services[j].Host {
+			return services[i].Host < services[j].Host
+		}
+		return services[i].Port < services[j].Port
+	})
+	versions := Versions{ConfigServer: configServerVersion}
+	for _, s := range services {
+		v := NodeVersion{Host: s.Host, Port: s.Port, Type: s.Type, Cluster: s.ClusterName}
+		var state stateVersion
+		if err := t.getServiceJSON(s, "/state/v1/version", &state); err != nil {
+			v.Error = err.Error()
+		} else {
+			v.Version = state.Version
+		}
+		versions.Nodes = append(versions.Nodes, v)
+	}
+	return versions, nil
+}
+
+// configServerVersion returns the Vespa version of the config server of this.
+func (t *customTarget) configServerVersion() (string, error) {
+	deployService, err := t.DeployService()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", deployService.BaseURL+"/state/v1/version", nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := deployService.Do(req, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var versionResponse stateVersion
+	if err := json.NewDecoder(response.Body).Decode(&versionResponse); err != nil {
+		return "", err
+	}
+	return versionResponse.Version, nil
+}
+
+// Versions returns the current and wanted Vespa version of each node of this deployment, as given by the node
+// repository.
+func (t *cloudTarget) Versions() (Versions, error) {
+	details, err := t.ServiceDetails()
+	if err != nil {
+		return Versions{}, err
+	}
+	var versions Versions
+	for _, d := range details {
+		versions.Nodes = append(versions.Nodes, NodeVersion{Host: d.Host, Type: d.Type, Cluster: d.Cluster, Version: d.CurrentVersion, WantedVersion: d.WantedVersion})
+	}
+	return versions, nil
+}
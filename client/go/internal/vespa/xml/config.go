@@ -35,21 +35,86 @@ type Deployment struct {
 	Version  string     `xml:"version,attr"`
 	Instance []Instance `xml:"instance"`
 	Prod     Prod       `xml:"prod"`
-	rawXML   bytes.Buffer
+	// SystemTest and StagingTest are the test and staging elements, which declare system and staging tests
+	SystemTest  *struct{} `xml:"test"`
+	StagingTest *struct{} `xml:"staging"`
+	rawXML      bytes.Buffer
 }
 
 type Instance struct {
-	Prod Prod `xml:"prod"`
+	ID          string    `xml:"id,attr"`
+	Prod        Prod      `xml:"prod"`
+	SystemTest  *struct{} `xml:"test"`
+	StagingTest *struct{} `xml:"staging"`
 }
 
 type Prod struct {
 	Regions []Region `xml:"region"`
+	Tests   []Test   `xml:"test"`
+	// Parallel and Steps are groups of steps, run in parallel or in sequence
+	Parallel []Prod `xml:"parallel"`
+	Steps    []Prod `xml:"steps"`
+}
+
+// AllRegions returns the regions of p, including those in its groups of steps.
+func (p Prod) AllRegions() []Region {
+	regions := append([]Region{}, p.Regions...)
+	for _, group := range p.Parallel {
+		regions = append(regions, group.AllRegions()...)
+	}
+	for _, group := range p.Steps {
+		regions = append(regions, group.AllRegions()...)
+	}
+	return regions
+}
+
+// AllTests returns the production tests of p, including those in its groups of steps.
+func (p Prod) AllTests() []Test {
+	tests := append([]Test{}, p.Tests...)
+	for _, group := range p.Parallel {
+		tests = append(tests, group.AllTests()...)
+	}
+	for _, group := range p.Steps {
+		tests = append(tests, group.AllTests()...)
+	}
+	return tests
 }
 
 type Region struct {
 	Name string `xml:",chardata"`
 }
 
+// Test is a production test element, which runs production tests after deployment to the region it names.
+type Test struct {
+	Name string `xml:",chardata"`
+}
+
+// Notifications is the notifications element of deployment.xml.
+type Notifications struct {
+	When   string  `xml:"when,attr,omitempty"`
+	Emails []Email `xml:"email"`
+}
+
+type Email struct {
+	Address string `xml:"address,attr,omitempty"`
+	Role    string `xml:"role,attr,omitempty"`
+}
+
+// ProdStep is a step of a production deployment, i.e. a region or test element.
+type ProdStep struct {
+	Region *Region
+	Test   *Test
+}
+
+func (s ProdStep) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if s.Test != nil {
+		start.Name.Local = "test"
+		return e.EncodeElement(s.Test, start)
+	}
+	start.Name.Local = "region"
+	return e.EncodeElement(s.Region, start)
+}
+
 func (d Deployment) String() string { return d.rawXML.String() }
 
 // Replace replaces any elements of name found under parentName with data.
@@ -66,6 +131,20 @@ func (s *Deployment) Replace(parentName, name string, data interface{}) error {
 	return nil
 }
 
+// Insert inserts data as an element of name, as the first child of the first element named parentName.
+func (s *Deployment) Insert(parentName, name string, data interface{}) error {
+	rewritten, err := Insert(&s.rawXML, parentName, name, data)
+	if err != nil {
+		return err
+	}
+	newXML, err := ReadDeployment(strings.NewReader(rewritten))
+	if err != nil {
+		return err
+	}
+	*s = newXML
+	return nil
+}
+
 // Services represents the contents of a services.xml file.
 type Services struct {
 	Root      xml.Name    `xml:"services"`
@@ -119,6 +198,20 @@ func (s *Services) Replace(parentName, name string, data interface{}) error {
 	return nil
 }
 
+// Insert inserts data as an element of name, as the first child of the first element named parentName.
+func (s *Services) Insert(parentName, name string, data interface{}) error {
+	rewritten, err := Insert(&s.rawXML, parentName, name, data)
+	if err != nil {
+		return err
+	}
+	newXML, err := ReadServices(strings.NewReader(rewritten))
+	if err != nil {
+		return err
+	}
+	*s = newXML
+	return nil
+}
+
 func (s *Services) ContainsAnyTokenClient() bool {
 	for _, container := range s.Container {
 		for _, client := range container.Clients {
@@ -167,6 +260,22 @@ func Regions(names ...string) []Region {
 	return regions
 }
 
+// ProdSteps returns given region names as region elements, where each region in testRegions is followed by a test
+// element for the same region.
+func ProdSteps(regions []string, testRegions []string) []ProdStep {
+	var steps []ProdStep
+	for _, r := range regions {
+		steps = append(steps, ProdStep{Region: &Region{Name: r}})
+		for _, t := range testRegions {
+			if t == r {
+				steps = append(steps, ProdStep{Test: &Test{Name: r}})
+				break
+			}
+		}
+	}
+	return steps
+}
+
 // ParseResources parses nodes resources from string s.
 func ParseResources(s string) (Resources, error) {
 	var parts []string
@@ -355,11 +464,53 @@ func Replace(r io.Reader, parentName, name string, data interface{}) (string, er
 			return "", err
 		}
 	}
+	return indentedString(enc, &buf)
+}
+
+// Insert inserts data as an element of name, as the first child of the first element named parentName in the XML read
+// from reader r. As with Replace, parentName may contain an ID selector.
+func Insert(r io.Reader, parentName, name string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(r)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	id := ""
+	if parts := strings.SplitN(parentName, "#", 2); len(parts) > 1 {
+		parentName = parts[0]
+		id = parts[1]
+	}
+	done := false
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		token = joinNamespace(token)
+		if err := enc.EncodeToken(token); err != nil {
+			return "", err
+		}
+		if _, ok := getStartElement(parentName, id, token); ok && !done {
+			if err := enc.EncodeElement(data, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+				return "", err
+			}
+			done = true
+		}
+	}
+	if !done {
+		return "", fmt.Errorf("no element %s found", parentName)
+	}
+	return indentedString(enc, &buf)
+}
+
+// indentedString flushes enc and returns the XML written to buf, without any lines containing only whitespace.
+func indentedString(enc *xml.Encoder, buf *bytes.Buffer) (string, error) {
 	if err := enc.Flush(); err != nil {
 		return "", err
 	}
 	var sb strings.Builder
-	scanner := bufio.NewScanner(&buf)
+	scanner := bufio.NewScanner(buf)
 	for scanner.Scan() {
 		line := scanner.Text()
 		// Skip lines containing only whitespace
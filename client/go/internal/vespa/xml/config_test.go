@@ -149,6 +149,56 @@ func TestReplaceRemovesElement(t *testing.T) {
 	assertReplace(t, in, out, "prod", "test", nil)
 }
 
+func TestReplaceProdSteps(t *testing.T) {
+	in := `
+<deployment version="1.0">
+    <prod>
+        <region>us-north-1</region>
+    </prod>
+</deployment>`
+
+	out := `<deployment version="1.0">
+  <prod>
+    <region>eu-south-1</region>
+    <region>us-central-1</region>
+    <test>us-central-1</test>
+  </prod>
+</deployment>
+`
+	steps := ProdSteps([]string{"eu-south-1", "us-central-1"}, []string{"us-central-1"})
+	assertReplace(t, in, out, "prod", "region", steps)
+}
+
+func TestInsert(t *testing.T) {
+	in := `
+<deployment version="1.0">
+    <prod>
+        <region>us-north-1</region>
+    </prod>
+</deployment>`
+
+	out := `<deployment version="1.0">
+  <notifications when="failing">
+    <email address="alice@example.com"></email>
+  </notifications>
+  <prod>
+    <region>us-north-1</region>
+  </prod>
+</deployment>
+`
+	notifications := Notifications{When: "failing", Emails: []Email{{Address: "alice@example.com"}}}
+	got, err := Insert(strings.NewReader(in), "deployment", "notifications", notifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != out {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, out)
+	}
+	if _, err := Insert(strings.NewReader(in), "services", "notifications", notifications); err == nil {
+		t.Error("want error for missing parent element")
+	}
+}
+
 func TestReplaceRaw(t *testing.T) {
 	in := `
 <project xmlns="http://maven.apache.org/POM/4.0.0"
@@ -0,0 +1,177 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespacli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vespa-engine/vespa/client/go/internal/cli/cmd"
+)
+
+// Result is the result of a command. The member holding the result of the command is set, if the command produced
+// one, e.g. Query for a query.
+type Result struct {
+	// Command is the executed command, without the root command and flags, e.g. "query" or "status deploy"
+	Command string
+
+	Deploy *DeployResult
+	Feed   *FeedResult
+	Query  *QueryResult
+	Status *StatusResult
+
+	// JSON is the result of the command as JSON, as printed with --output json, for commands which produce one. It is
+	// set for commands without a member of their own too, e.g. "config get"
+	JSON json.RawMessage
+}
+
+// DeployResult is the result of deploy.
+type DeployResult struct {
+	// Path is the path of the deployed application package
+	Path string `json:"path"`
+	// RunID is the ID of the deployment job run in Vespa Cloud
+	RunID int64 `json:"runId,omitempty"`
+	// SessionID is the ID of the session created by a deployment to a self-hosted config server
+	SessionID  int64      `json:"sessionId,omitempty"`
+	ConsoleURL string     `json:"consoleUrl,omitempty"`
+	Endpoints  []Endpoint `json:"endpoints,omitempty"`
+}
+
+// Endpoint is an endpoint of a container cluster.
+type Endpoint struct {
+	Cluster string `json:"cluster"`
+	URL     string `json:"url"`
+}
+
+// FeedResult is the result of feed, which holds the statistics of the feed.
+type FeedResult struct {
+	Operations      int64         `json:"feeder.operation.count"`
+	Seconds         float64       `json:"feeder.seconds"`
+	Successful      int64         `json:"feeder.ok.count"`
+	Errors          int64         `json:"feeder.error.count"`
+	ConditionNotMet int64         `json:"feeder.condition.not.met.count"`
+	Requests        int64         `json:"http.request.count"`
+	Responses       int64         `json:"http.response.count"`
+	ResponseErrors  int64         `json:"http.response.error.count"`
+	ResponseCodes   map[int]int64 `json:"http.response.code.counts"`
+}
+
+// QueryResult is the result of a query. It is not set for queries which are streamed, paginated or repeated.
+type QueryResult struct {
+	// TotalCount is the number of documents matching the query
+	TotalCount int64
+	Coverage   *Coverage
+	Hits       []Hit
+	Errors     []QueryError
+	// Response is the complete response to the query
+	Response json.RawMessage
+}
+
+// Hit is a hit of a query, or a group of hits of a grouping query.
+type Hit struct {
+	ID        string  `json:"id"`
+	Relevance float64 `json:"relevance"`
+	Source    string  `json:"source,omitempty"`
+	// Fields holds the fields of the hit, decoded with numbers as json.Number
+	Fields   map[string]any `json:"fields,omitempty"`
+	Children []Hit          `json:"children,omitempty"`
+}
+
+// Coverage describes how much of the corpus a query was run against.
+type Coverage struct {
+	Coverage  int   `json:"coverage"`
+	Documents int64 `json:"documents"`
+	Full      bool  `json:"full"`
+	Nodes     int   `json:"nodes"`
+}
+
+// QueryError is an error returned by a query which was run partially, or not at all.
+type QueryError struct {
+	Code    int    `json:"code"`
+	Summary string `json:"summary"`
+	Message string `json:"message"`
+}
+
+// StatusResult is the result of status, and status deploy.
+type StatusResult struct {
+	// Ready is whether all services are ready
+	Ready           bool            `json:"ready"`
+	Services        []ServiceStatus `json:"services"`
+	ContentClusters []ContentStatus `json:"contentClusters,omitempty"`
+}
+
+// ServiceStatus is the status of a service.
+type ServiceStatus struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	AuthMethod string `json:"authMethod,omitempty"`
+	// Status is the HTTP status of the status endpoint of the service
+	Status int  `json:"status"`
+	Ready  bool `json:"ready"`
+	// Generation is the config generation the deployment has converged on, which is set by status deploy for
+	// self-hosted targets
+	Generation int64  `json:"generation,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ContentStatus is the feed block state of a content cluster, which is shown for local targets.
+type ContentStatus struct {
+	Cluster         string `json:"cluster"`
+	FeedBlocked     bool   `json:"feedBlocked"`
+	NodesAboveLimit int    `json:"nodesAboveLimit"`
+}
+
+func newResult(result cmd.Result) (Result, error) {
+	r := Result{Command: result.Command}
+	if result.Value == nil {
+		return r, nil
+	}
+	data, err := json.Marshal(result.Value)
+	if err != nil {
+		return r, err
+	}
+	r.JSON = data
+	switch r.Command {
+	case "deploy":
+		r.Deploy = &DeployResult{}
+		err = json.Unmarshal(data, r.Deploy)
+	case "feed":
+		r.Feed = &FeedResult{}
+		err = json.Unmarshal(data, r.Feed)
+	case "query":
+		r.Query, err = newQueryResult(data)
+	case "status", "status deploy":
+		r.Status = &StatusResult{}
+		err = json.Unmarshal(data, r.Status)
+	}
+	if err != nil {
+		return r, fmt.Errorf("invalid result of %s: %w", r.Command, err)
+	}
+	return r, nil
+}
+
+func newQueryResult(data []byte) (*QueryResult, error) {
+	var response struct {
+		Root struct {
+			Fields struct {
+				TotalCount int64 `json:"totalCount"`
+			} `json:"fields"`
+			Coverage *Coverage    `json:"coverage"`
+			Children []Hit        `json:"children"`
+			Errors   []QueryError `json:"errors"`
+		} `json:"root"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&response); err != nil {
+		return nil, err
+	}
+	return &QueryResult{
+		TotalCount: response.Root.Fields.TotalCount,
+		Coverage:   response.Root.Coverage,
+		Hits:       response.Root.Children,
+		Errors:     response.Root.Errors,
+		Response:   data,
+	}, nil
+}
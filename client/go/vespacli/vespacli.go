@@ -0,0 +1,129 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+// Package vespacli runs commands of the Vespa CLI in a Go program, and returns their results as Go values.
+//
+// Commands are given the same arguments as on the command line:
+//
+//	cli, err := vespacli.New(vespacli.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := cli.Execute([]string{"query", "-t", "http://localhost:8080", "select * from music where true"})
+//	if err != nil {
+//		return err
+//	}
+//	for _, hit := range result.Query.Hits {
+//		fmt.Println(hit.ID, hit.Relevance)
+//	}
+//
+// The types and functions of this package are kept compatible between releases of the Vespa CLI: members may be added,
+// but are not removed or changed. This holds for the results of commands too, while the text the commands write to
+// Options.Stdout and Options.Stderr may change.
+package vespacli
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/internal/cli/cmd"
+	"github.com/vespa-engine/vespa/client/go/internal/httputil"
+)
+
+// HTTPClient sends the HTTP requests of commands.
+type HTTPClient interface {
+	// Do sends request, and returns its response. The request is cancelled after timeout, if it is positive.
+	Do(request *http.Request, timeout time.Duration) (*http.Response, error)
+}
+
+// Options holds the dependencies of a CLI. The zero value runs commands like the vespa binary does, in the environment
+// of the process, with the output of commands discarded.
+type Options struct {
+	// Stdin is read by commands reading standard input, e.g. feed with '-'. It defaults to os.Stdin
+	Stdin io.Reader
+	// Stdout and Stderr receive the text written by commands. It is discarded if they are nil
+	Stdout io.Writer
+	Stderr io.Writer
+	// Environment holds the environment variables read by commands, by name. It defaults to the environment of the
+	// process. Use VESPA_CLI_HOME to select the directory of the configuration of the CLI
+	Environment map[string]string
+	// HTTPClient creates the clients sending the HTTP requests of commands, with the given timeout. If nil, requests are
+	// sent by clients supporting the proxy, TLS and tracing configuration of the CLI. These are not applied to clients
+	// created by this
+	HTTPClient func(timeout time.Duration) HTTPClient
+}
+
+// CLI runs commands of the Vespa CLI. A CLI may run several commands, one at a time. It is not safe for concurrent use
+// by multiple goroutines, and commands of several CLIs should not run at once either, since they share the
+// configuration of terminal colors and logging of the process.
+type CLI struct {
+	cli *cmd.CLI
+}
+
+// New creates a CLI with the given options.
+func New(opts Options) (*CLI, error) {
+	env := opts.Environment
+	if env == nil {
+		env = make(map[string]string)
+		for _, entry := range os.Environ() {
+			name, value, _ := strings.Cut(entry, "=")
+			env[name] = value
+		}
+	}
+	options := cmd.Options{Stdout: opts.Stdout, Stderr: opts.Stderr, Environment: env}
+	if opts.Stdin != nil {
+		options.Stdin = readOnly{opts.Stdin}
+	}
+	if opts.HTTPClient != nil {
+		options.HTTPClientFactory = func(timeout time.Duration) httputil.Client { return opts.HTTPClient(timeout) }
+	}
+	cli, err := cmd.NewCLI(options)
+	if err != nil {
+		return nil, err
+	}
+	return &CLI{cli: cli}, nil
+}
+
+// Execute runs the command given by args, which are the arguments of the vespa binary, and returns its result. The
+// error, if any, is an *Error. The result may be non-empty for commands which fail too, e.g. a status showing which
+// services are not ready.
+func (c *CLI) Execute(args []string) (Result, error) {
+	result, err := c.cli.Execute(args)
+	r, decodeErr := newResult(result)
+	if err != nil {
+		return r, newError(err)
+	}
+	return r, decodeErr
+}
+
+// Error is an error returned by a command.
+type Error struct {
+	// Status is the exit status of the vespa binary for this error
+	Status int
+	// Code is the error code of this, e.g. SERVICE_NOT_READY, or the empty string if it has none. 'vespa explain'
+	// describes each code
+	Code string
+	// Hints holds suggestions for resolving this error
+	Hints []string
+
+	err error
+}
+
+func newError(err error) *Error {
+	if cliErr, ok := err.(cmd.ErrCLI); ok {
+		return &Error{Status: cliErr.Status, Code: cliErr.Code(), Hints: cliErr.Hints(), err: cliErr}
+	}
+	return &Error{Status: 1, err: err}
+}
+
+func (e *Error) Error() string { return e.err.Error() }
+
+// Unwrap returns the error this was created from, such that errors.Is and errors.As can inspect the cause of it.
+func (e *Error) Unwrap() error { return e.err }
+
+// readOnly is a standard input which cannot be written.
+type readOnly struct{ io.Reader }
+
+func (readOnly) Write(p []byte) (int, error) { return 0, os.ErrInvalid }
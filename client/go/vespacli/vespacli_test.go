@@ -0,0 +1,166 @@
+// Copyright Vespa.ai. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package vespacli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/vespacli"
+)
+
+const queryResponse = `{
+  "root": {
+    "id": "toplevel",
+    "relevance": 1.0,
+    "fields": {"totalCount": 42},
+    "coverage": {"coverage": 100, "documents": 1000, "full": true, "nodes": 2, "results": 1, "resultsFull": 1},
+    "children": [
+      {"id": "id:mynamespace:music::a-head-full-of-dreams", "relevance": 0.5, "source": "music", "fields": {"title": "A Head Full of Dreams", "year": 2015}}
+    ]
+  }
+}`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/status.html":
+			w.WriteHeader(200)
+		case r.URL.Path == "/search/" && r.URL.Query().Get("yql") == "select * from invalid":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"root":{"errors":[{"code":4,"summary":"Invalid query","message":"no such source"}]}}`))
+		case r.URL.Path == "/search/":
+			w.Write([]byte(queryResponse))
+		case strings.HasPrefix(r.URL.Path, "/document/v1/"):
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"message":"OK"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestCLI(t *testing.T, opts vespacli.Options) *vespacli.CLI {
+	opts.Environment = map[string]string{"VESPA_CLI_HOME": t.TempDir(), "VESPA_CLI_CACHE_DIR": t.TempDir()}
+	cli, err := vespacli.New(opts)
+	require.Nil(t, err)
+	return cli
+}
+
+func TestExecuteQuery(t *testing.T) {
+	server := newTestServer(t)
+	var stdout bytes.Buffer
+	cli := newTestCLI(t, vespacli.Options{Stdout: &stdout})
+	result, err := cli.Execute([]string{"query", "-t", server.URL, "select * from music where true"})
+	require.Nil(t, err)
+	assert.Equal(t, "query", result.Command)
+	require.NotNil(t, result.Query)
+	assert.Equal(t, int64(42), result.Query.TotalCount)
+	assert.Equal(t, &vespacli.Coverage{Coverage: 100, Documents: 1000, Full: true, Nodes: 2}, result.Query.Coverage)
+	assert.Equal(t, []vespacli.Hit{{
+		ID:        "id:mynamespace:music::a-head-full-of-dreams",
+		Relevance: 0.5,
+		Source:    "music",
+		Fields:    map[string]any{"title": "A Head Full of Dreams", "year": json.Number("2015")},
+	}}, result.Query.Hits)
+	assert.JSONEq(t, queryResponse, string(result.Query.Response))
+	assert.Contains(t, stdout.String(), `"totalCount": 42`)
+
+	result, err = cli.Execute([]string{"query", "-t", server.URL, "select * from invalid"})
+	var cliErr *vespacli.Error
+	require.True(t, errors.As(err, &cliErr))
+	assert.Equal(t, 1, cliErr.Status)
+	assert.Contains(t, cliErr.Error(), "invalid query: 400 Bad Request")
+	assert.Nil(t, result.Query)
+
+	// Paginated queries print their hits, but have no result
+	stdout.Reset()
+	result, err = cli.Execute([]string{"query", "-t", server.URL, "--max-hits", "1", "select * from music where true"})
+	require.Nil(t, err)
+	assert.Nil(t, result.Query)
+	assert.Equal(t, `{"id":"id:mynamespace:music::a-head-full-of-dreams","relevance":0.5,"source":"music","fields":{"title":"A Head Full of Dreams","year":2015}}`+"\n", stdout.String())
+}
+
+func TestExecuteErrorCause(t *testing.T) {
+	errBroken := errors.New("broken")
+	cli := newTestCLI(t, vespacli.Options{HTTPClient: func(timeout time.Duration) vespacli.HTTPClient { return brokenClient{errBroken} }})
+	_, err := cli.Execute([]string{"query", "-t", "http://127.0.0.1:8080", "select * from music where true"})
+	var cliErr *vespacli.Error
+	require.True(t, errors.As(err, &cliErr))
+	assert.True(t, errors.Is(err, errBroken))
+}
+
+type brokenClient struct{ err error }
+
+func (c brokenClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	return nil, c.err
+}
+
+func TestExecuteWithHTTPClient(t *testing.T) {
+	server := newTestServer(t)
+	client := &countingClient{}
+	cli := newTestCLI(t, vespacli.Options{HTTPClient: func(timeout time.Duration) vespacli.HTTPClient { return client }})
+	result, err := cli.Execute([]string{"query", "-t", server.URL, "select * from music where true"})
+	require.Nil(t, err)
+	assert.Equal(t, 1, len(result.Query.Hits))
+	assert.Equal(t, int64(1), client.requests.Load())
+}
+
+func TestExecuteFeed(t *testing.T) {
+	server := newTestServer(t)
+	// Feeding is done over HTTP/2, which the test server does not support
+	client := &countingClient{}
+	cli := newTestCLI(t, vespacli.Options{
+		HTTPClient: func(timeout time.Duration) vespacli.HTTPClient { return client },
+		Stdin: strings.NewReader(`{"put": "id:ns:type::doc1", "fields": {"title": "a"}}
+{"put": "id:ns:type::doc2", "fields": {"title": "b"}}
+`),
+	})
+	result, err := cli.Execute([]string{"feed", "-t", server.URL, "-"})
+	require.Nil(t, err)
+	require.NotNil(t, result.Feed)
+	assert.Equal(t, int64(2), result.Feed.Operations)
+	assert.Equal(t, int64(2), result.Feed.Successful)
+	assert.Equal(t, map[int]int64{200: 2}, result.Feed.ResponseCodes)
+	// The feed block state is read before the operations are sent
+	assert.Equal(t, int64(3), client.requests.Load())
+}
+
+func TestExecuteStatus(t *testing.T) {
+	server := newTestServer(t)
+	cli := newTestCLI(t, vespacli.Options{})
+	result, err := cli.Execute([]string{"status", "-t", server.URL})
+	require.Nil(t, err)
+	assert.Equal(t, "status", result.Command)
+	assert.Equal(t, &vespacli.StatusResult{Ready: true, Services: []vespacli.ServiceStatus{{URL: server.URL, Status: 200, Ready: true}}}, result.Status)
+
+	// Commands without a result have none
+	result, err = cli.Execute([]string{"version"})
+	require.Nil(t, err)
+	assert.Equal(t, vespacli.Result{Command: "version"}, result)
+
+	// Commands without a result type of their own have their result as JSON, if they print it as JSON
+	result, err = cli.Execute([]string{"config", "get", "--output", "json", "target"})
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"target":"local"}`, string(result.JSON))
+}
+
+// countingClient sends requests with http.DefaultClient, and counts them.
+type countingClient struct{ requests atomic.Int64 }
+
+func (c *countingClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultClient.Do(request)
+}